@@ -15,9 +15,24 @@ package main
 
 import (
 	"fmt"
+	"mime"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
 
 	"github.com/mendersoftware/deployments/config"
+	"github.com/mendersoftware/deployments/resources/deployments/webhook"
+	"github.com/mendersoftware/deployments/resources/images"
+	imagesController "github.com/mendersoftware/deployments/resources/images/controller"
+	imagesModel "github.com/mendersoftware/deployments/resources/images/model"
+	"github.com/mendersoftware/deployments/utils/logging"
+	"github.com/mendersoftware/deployments/utils/readpref"
 )
 
 const (
@@ -37,6 +52,19 @@ const (
 	SettingsAwsTagArtifact        = SettingsAws + ".tag_artifact"
 	SettingsAwsTagArtifactDefault = false
 
+	SettingAwsPresignClockSkew        = SettingsAws + ".presign_clock_skew"
+	SettingAwsPresignClockSkewDefault = "0s"
+
+	SettingAwsConsistencyWindow          = SettingsAws + ".consistency_window"
+	SettingAwsConsistencyWindowDefault   = "30s"
+	SettingAwsConsistencyAttempts        = SettingsAws + ".consistency_attempts"
+	SettingAwsConsistencyAttemptsDefault = 4
+	SettingAwsConsistencyBackoff         = SettingsAws + ".consistency_backoff"
+	SettingAwsConsistencyBackoffDefault  = "100ms"
+
+	// S3 buckets the artifact files are replicated to, by region
+	SettingAwsReplicas = SettingsAws + ".replicas"
+
 	SettingsAwsAuth      = SettingsAws + ".auth"
 	SettingAwsAuthKeyId  = SettingsAwsAuth + ".key"
 	SettingAwsAuthSecret = SettingsAwsAuth + ".secret"
@@ -54,11 +82,153 @@ const (
 	SettingDbUsername = "mongo_username"
 	SettingDbPassword = "mongo_password"
 
+	SettingDbReadPreference        = "mongo_read_preference"
+	SettingDbReadPreferenceDefault = readpref.Primary
+
+	SettingDbPoolLimit        = "mongo_pool_limit"
+	SettingDbPoolLimitDefault = 0
+
+	SettingDbConnectTimeout        = "mongo_connect_timeout"
+	SettingDbConnectTimeoutDefault = "10s"
+
+	SettingDbSocketTimeout        = "mongo_socket_timeout"
+	SettingDbSocketTimeoutDefault = "1m"
+
+	SettingDbSyncTimeout        = "mongo_sync_timeout"
+	SettingDbSyncTimeoutDefault = "1m"
+
 	SettingGateway        = "mender-gateway"
 	SettingGatewayDefault = "localhost:9080"
 
 	SettingMiddleware        = "middleware"
 	SettingMiddlewareDefault = EnvProd
+
+	SettingLogFormat        = "log_format"
+	SettingLogFormatDefault = logging.FormatText
+
+	SettingStorageLatencyThreshold        = "storage_latency_threshold"
+	SettingStorageLatencyThresholdDefault = "500ms"
+
+	SettingIntegrityCheckInterval        = "integrity_check_interval"
+	SettingIntegrityCheckIntervalDefault = "0"
+
+	SettingChecksumRecomputeRate        = "checksum_recompute_rate"
+	SettingChecksumRecomputeRateDefault = imagesModel.DefaultChecksumsRate
+
+	SettingArtifactExpiryCheckInterval        = "artifact_expiry_check_interval"
+	SettingArtifactExpiryCheckIntervalDefault = "10m"
+
+	SettingUploadPurgeInterval        = "upload_purge_interval"
+	SettingUploadPurgeIntervalDefault = "10m"
+
+	SettingUsageReconcileInterval        = "usage_reconcile_interval"
+	SettingUsageReconcileIntervalDefault = "1h"
+
+	SettingDeletionRetryInterval        = "deletion_retry_interval"
+	SettingDeletionRetryIntervalDefault = "1m"
+	SettingDeletionRetryBackoff         = "deletion_retry_backoff"
+	SettingDeletionRetryBackoffDefault  = "1m"
+
+	SettingUploadConcurrency        = "upload_concurrency"
+	SettingUploadConcurrencyDefault = 0
+
+	SettingUploadConcurrencyPerTenant        = "upload_concurrency_per_tenant"
+	SettingUploadConcurrencyPerTenantDefault = 0
+
+	SettingArtifactKeyTemplate        = "artifact_key_template"
+	SettingArtifactKeyTemplateDefault = images.DefaultObjectKeyTemplate
+
+	SettingArtifactVerifyKeys = "artifact_verify_keys"
+
+	SettingArtifactContentTypes = "artifact_content_types"
+
+	SettingArtifactRequiredFields = "artifact_required_fields"
+
+	SettingArtifactFormAliases = "artifact_form_aliases"
+
+	SettingArtifactFormRejectUnknown        = "artifact_form_reject_unknown"
+	SettingArtifactFormRejectUnknownDefault = false
+
+	SettingArtifactNameMaxLength        = "artifact_name_max_length"
+	SettingArtifactNameMaxLengthDefault = images.DefaultMaxNameLength
+
+	SettingArtifactNamePattern        = "artifact_name_pattern"
+	SettingArtifactNamePatternDefault = ""
+
+	SettingArtifactReviewerRole        = "artifact_reviewer_role"
+	SettingArtifactReviewerRoleDefault = imagesController.DefaultArtifactReviewerRole
+
+	SettingArtifactScanCommand = "artifact_scan_command"
+
+	SettingComposeDir        = "compose_dir"
+	SettingComposeDirDefault = ""
+
+	SettingComposeMaxSize        = "compose_max_size"
+	SettingComposeMaxSizeDefault = imagesModel.MaxImageSize
+
+	SettingArtifactScanAsync        = "artifact_scan_async"
+	SettingArtifactScanAsyncDefault = false
+
+	SettingArtifactScanTimeout        = "artifact_scan_timeout"
+	SettingArtifactScanTimeoutDefault = "5m"
+
+	SettingDeploymentCallbackAttempts        = "deployment_callback_attempts"
+	SettingDeploymentCallbackAttemptsDefault = webhook.DefaultAttempts
+
+	SettingDeploymentCallbackBackoff        = "deployment_callback_backoff"
+	SettingDeploymentCallbackBackoffDefault = "1s"
+
+	SettingDeploymentCallbackTimeout        = "deployment_callback_timeout"
+	SettingDeploymentCallbackTimeoutDefault = "10s"
+
+	SettingDeploymentCallbackAllowPrivate        = "deployment_callback_allow_private"
+	SettingDeploymentCallbackAllowPrivateDefault = false
+
+	SettingDeploymentIdempotencyWindow        = "deployment_idempotency_window"
+	SettingDeploymentIdempotencyWindowDefault = "24h"
+
+	SettingDeploymentRequireApproval        = "deployment_require_approval"
+	SettingDeploymentRequireApprovalDefault = false
+
+	SettingDeploymentBlockDeprecated        = "deployment_block_deprecated"
+	SettingDeploymentBlockDeprecatedDefault = false
+
+	SettingDeploymentDefaultDeviceType        = "deployment_default_device_type"
+	SettingDeploymentDefaultDeviceTypeDefault = ""
+
+	SettingDownloadProxy        = "download_proxy"
+	SettingDownloadProxyDefault = false
+
+	SettingDownloadRestrictDevices        = "download_restrict_devices"
+	SettingDownloadRestrictDevicesDefault = false
+
+	SettingMaintenance        = "maintenance"
+	SettingMaintenanceDefault = false
+
+	SettingOperationTimeout        = "operation_timeout"
+	SettingOperationTimeoutDefault = "30s"
+
+	SettingArtifactEmptyListStatus        = "artifact_empty_list_status"
+	SettingArtifactEmptyListStatusDefault = 200
+
+	SettingUploadTimeout        = "upload_timeout"
+	SettingUploadTimeoutDefault = "1h"
+
+	SettingStorageUploadTimeout        = "storage_upload_timeout"
+	SettingStorageUploadTimeoutDefault = "0s"
+
+	SettingUploadSlowThroughput        = "upload_slow_throughput"
+	SettingUploadSlowThroughputDefault = 65536
+	SettingUploadSlowPeriod            = "upload_slow_period"
+	SettingUploadSlowPeriodDefault     = "1m"
+
+	SettingHandlerTimeout        = "handler_timeout"
+	SettingHandlerTimeoutDefault = "0s"
+
+	SettingHandlerTimeouts = "handler_timeouts"
+
+	SettingMetricsTenantLabelsMax        = "metrics_tenant_labels_max"
+	SettingMetricsTenantLabelsMaxDefault = 0
 )
 
 // ValidateAwsAuth validates configuration of SettingsAwsAuth section if provided.
@@ -104,21 +274,374 @@ func ValidateHttps(c config.ConfigReader) error {
 	return nil
 }
 
+// ValidateLogFormat validates SettingLogFormat value.
+func ValidateLogFormat(c config.ConfigReader) error {
+
+	switch format := c.GetString(SettingLogFormat); format {
+	case logging.FormatText, logging.FormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("Unknown log format: '%s'", format)
+	}
+}
+
+// ValidateArtifactKeyTemplate validates SettingArtifactKeyTemplate value.
+func ValidateArtifactKeyTemplate(c config.ConfigReader) error {
+	_, err := images.NewObjectKeyTemplate(c.GetString(SettingArtifactKeyTemplate))
+	return err
+}
+
+// ValidateArtifactVerifyKeys checks if SettingArtifactVerifyKeys files
+// can be loaded.
+func ValidateArtifactVerifyKeys(c config.ConfigReader) error {
+	_, err := imagesModel.LoadTrustedKeys(c.GetStringSlice(SettingArtifactVerifyKeys))
+	return err
+}
+
+// ValidateArtifactContentTypes checks if SettingArtifactContentTypes
+// are valid media types.
+func ValidateArtifactContentTypes(c config.ConfigReader) error {
+	for _, contentType := range c.GetStringSlice(SettingArtifactContentTypes) {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return fmt.Errorf("Invalid content type '%s' in option '%s': %s",
+				contentType, SettingArtifactContentTypes, err)
+		}
+	}
+	return nil
+}
+
+// ValidateArtifactFormAliases checks if SettingArtifactFormAliases map
+// to the known upload form fields, and don't shadow any of them.
+func ValidateArtifactFormAliases(c config.ConfigReader) error {
+	for alias, field := range c.GetStringMapString(SettingArtifactFormAliases) {
+		if imagesController.IsFormField(alias) {
+			return fmt.Errorf("Invalid alias '%s' in option '%s': it is a form field",
+				alias, SettingArtifactFormAliases)
+		}
+		if !imagesController.IsFormField(field) {
+			return fmt.Errorf("Invalid alias '%s' in option '%s': unknown form field '%s'",
+				alias, SettingArtifactFormAliases, field)
+		}
+	}
+	return nil
+}
+
+// ValidateArtifactEmptyListStatus checks if SettingArtifactEmptyListStatus
+// is one of the supported statuses.
+func ValidateArtifactEmptyListStatus(c config.ConfigReader) error {
+	switch c.GetInt(SettingArtifactEmptyListStatus) {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return fmt.Errorf("Option '%s' must be one of 200, 204 or 404",
+		SettingArtifactEmptyListStatus)
+}
+
+// ValidateArtifactRequiredFields checks if SettingArtifactRequiredFields
+// are known metadata fields.
+func ValidateArtifactRequiredFields(c config.ConfigReader) error {
+	for _, field := range c.GetStringSlice(SettingArtifactRequiredFields) {
+		known := false
+		for _, metaField := range images.MetaFields {
+			if field == metaField {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("Unknown field '%s' in option '%s', expected one of: %s",
+				field, SettingArtifactRequiredFields, strings.Join(images.MetaFields, ", "))
+		}
+	}
+	return nil
+}
+
+// ValidateArtifactNamePattern checks if SettingArtifactNamePattern is
+// a valid regular expression.
+func ValidateArtifactNamePattern(c config.ConfigReader) error {
+	if _, err := regexp.Compile(c.GetString(SettingArtifactNamePattern)); err != nil {
+		return fmt.Errorf("Option '%s' is not a valid regular expression: %v",
+			SettingArtifactNamePattern, err)
+	}
+	return nil
+}
+
+// ValidateArtifactScanCommand checks if the program of
+// SettingArtifactScanCommand, if set, can be found.
+func ValidateArtifactScanCommand(c config.ConfigReader) error {
+	command := c.GetStringSlice(SettingArtifactScanCommand)
+	if len(command) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return fmt.Errorf("Invalid option '%s': %v", SettingArtifactScanCommand, err)
+	}
+	return nil
+}
+
+// ValidateComposeDir checks if SettingComposeDir, if set, is a directory.
+func ValidateComposeDir(c config.ConfigReader) error {
+	dir := c.GetString(SettingComposeDir)
+	if dir == "" {
+		return nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("Invalid option '%s': %v", SettingComposeDir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Invalid option '%s': %s is not a directory",
+			SettingComposeDir, dir)
+	}
+	return nil
+}
+
+// ValidateHandlerTimeouts checks if SettingHandlerTimeouts maps the routes
+// to valid durations.
+func ValidateHandlerTimeouts(c config.ConfigReader) error {
+	_, err := handlerTimeouts(c)
+	return err
+}
+
+// handlerTimeouts parses SettingHandlerTimeouts into the timeouts by route,
+// the routes are given as 'METHOD /path', with the path as defined
+// by the router, e.g. 'GET /api/management/v1/deployments/deployments/:id'.
+func handlerTimeouts(c config.ConfigReader) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for route, value := range c.GetStringMapString(SettingHandlerTimeouts) {
+		fields := strings.Fields(route)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid route '%s' in option '%s': expected 'METHOD /path'",
+				route, SettingHandlerTimeouts)
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("Invalid timeout of route '%s' in option '%s': '%s'",
+				route, SettingHandlerTimeouts, value)
+		}
+
+		// config keys are case insensitive
+		timeouts[strings.ToUpper(fields[0])+" "+fields[1]] = d
+	}
+	return timeouts, nil
+}
+
+// S3 bucket naming rules: 3-63 characters, lowercase letters, digits,
+// dots and hyphens, starting and ending with a letter or digit.
+var s3BucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// ValidateAwsS3Bucket validates SettingAwsS3Bucket value.
+func ValidateAwsS3Bucket(c config.ConfigReader) error {
+
+	bucket := c.GetString(SettingAwsS3Bucket)
+	if !isValidS3BucketName(bucket) {
+		return fmt.Errorf("Invalid S3 bucket name: '%s'", bucket)
+	}
+
+	return nil
+}
+
+// ValidateAwsReplicas validates the buckets of SettingAwsReplicas.
+func ValidateAwsReplicas(c config.ConfigReader) error {
+
+	for region, bucket := range c.GetStringMapString(SettingAwsReplicas) {
+		if !isValidS3BucketName(bucket) {
+			return fmt.Errorf("Invalid S3 bucket name of region '%s' in option '%s': '%s'",
+				region, SettingAwsReplicas, bucket)
+		}
+	}
+
+	return nil
+}
+
+func isValidS3BucketName(bucket string) bool {
+	return s3BucketNameRegexp.MatchString(bucket) &&
+		!strings.Contains(bucket, "..") &&
+		net.ParseIP(bucket) == nil
+}
+
+// ValidateMongoURL validates SettingMongo value.
+func ValidateMongoURL(c config.ConfigReader) error {
+
+	if _, err := mgo.ParseURL(c.GetString(SettingMongo)); err != nil {
+		return fmt.Errorf("Invalid option '%s': %v", SettingMongo, err)
+	}
+
+	return nil
+}
+
+// ValidateDbReadPreference validates SettingDbReadPreference value.
+func ValidateDbReadPreference(c config.ConfigReader) error {
+	if _, err := readpref.ParseMode(c.GetString(SettingDbReadPreference)); err != nil {
+		return fmt.Errorf("Invalid option '%s': %v", SettingDbReadPreference, err)
+	}
+	return nil
+}
+
+// durationSettings lists duration options along with the lowest allowed value
+var durationSettings = []struct {
+	key string
+	min time.Duration
+}{
+	{SettingStorageLatencyThreshold, time.Millisecond},
+	{SettingAwsPresignClockSkew, 0},
+	{SettingAwsConsistencyWindow, 0},
+	{SettingAwsConsistencyBackoff, 0},
+	{SettingIntegrityCheckInterval, 0},
+	{SettingArtifactExpiryCheckInterval, 0},
+	{SettingUploadPurgeInterval, 0},
+	{SettingUsageReconcileInterval, 0},
+	{SettingDeletionRetryInterval, 0},
+	{SettingDeletionRetryBackoff, 0},
+	{SettingDeploymentCallbackBackoff, 0},
+	{SettingDeploymentCallbackTimeout, 0},
+	{SettingDeploymentIdempotencyWindow, 0},
+	{SettingOperationTimeout, 0},
+	{SettingUploadTimeout, 0},
+	{SettingStorageUploadTimeout, 0},
+	{SettingArtifactScanTimeout, 0},
+	{SettingUploadSlowPeriod, time.Second},
+	{SettingHandlerTimeout, 0},
+	{SettingDbConnectTimeout, time.Millisecond},
+	{SettingDbSocketTimeout, time.Millisecond},
+	{SettingDbSyncTimeout, time.Millisecond},
+}
+
+// ValidateDurations checks if duration options can be parsed and are within bounds.
+// Unparsable durations would be silently read as 0 otherwise.
+func ValidateDurations(c config.ConfigReader) error {
+	var errs config.ValidationErrors
+
+	for _, setting := range durationSettings {
+		value := c.GetString(setting.key)
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs,
+				fmt.Errorf("Invalid duration '%s': '%s'", setting.key, value))
+		} else if d < setting.min {
+			errs = append(errs,
+				fmt.Errorf("Option '%s' must be at least %s", setting.key, setting.min))
+		}
+	}
+
+	// download links would expire before they are issued
+	skew, err := time.ParseDuration(c.GetString(SettingAwsPresignClockSkew))
+	if err == nil && skew >= imagesController.DefaultDownloadLinkExpire {
+		errs = append(errs, fmt.Errorf("Option '%s' must be less than %s",
+			SettingAwsPresignClockSkew, imagesController.DefaultDownloadLinkExpire))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidateLimits checks if numeric options are not negative.
+func ValidateLimits(c config.ConfigReader) error {
+	var errs config.ValidationErrors
+
+	for _, key := range []string{
+		SettingUploadConcurrency,
+		SettingUploadConcurrencyPerTenant,
+		SettingUploadSlowThroughput,
+		SettingDeploymentCallbackAttempts,
+		SettingDbPoolLimit,
+		SettingAwsConsistencyAttempts,
+		SettingMetricsTenantLabelsMax,
+		SettingChecksumRecomputeRate,
+	} {
+		if c.GetInt(key) < 0 {
+			errs = append(errs, fmt.Errorf("Option '%s' can't be negative", key))
+		}
+	}
+
+	if n := c.GetInt(SettingArtifactNameMaxLength); n < 1 || n > images.MaxNameLengthLimit {
+		errs = append(errs, fmt.Errorf("Option '%s' must be between 1 and %d",
+			SettingArtifactNameMaxLength, images.MaxNameLengthLimit))
+	}
+
+	// composed artifact must fit the limit of the uploaded ones
+	if n := c.GetInt(SettingComposeMaxSize); n < 1 || n > imagesModel.MaxImageSize {
+		errs = append(errs, fmt.Errorf("Option '%s' must be between 1 and %d",
+			SettingComposeMaxSize, imagesModel.MaxImageSize))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
 // Generate error with missing reuired option message.
 func MissingOptionError(option string) error {
 	return fmt.Errorf("Required option: '%s'", option)
 }
 
 var (
-	configValidators = []config.Validator{ValidateAwsAuth, ValidateHttps}
-	configDefaults   = []config.Default{
+	configValidators = []config.Validator{ValidateAwsAuth, ValidateHttps, ValidateLogFormat,
+		ValidateArtifactKeyTemplate, ValidateArtifactVerifyKeys, ValidateArtifactContentTypes,
+		ValidateArtifactRequiredFields, ValidateArtifactFormAliases, ValidateArtifactEmptyListStatus,
+		ValidateArtifactNamePattern, ValidateArtifactScanCommand, ValidateComposeDir,
+		ValidateAwsS3Bucket, ValidateAwsReplicas, ValidateMongoURL, ValidateDurations, ValidateLimits,
+		ValidateHandlerTimeouts, ValidateDbReadPreference}
+	configDefaults = []config.Default{
 		{Key: SettingListen, Value: SettingListenDefault},
 		{Key: SettingAwsS3Region, Value: SettingAwsS3RegionDefault},
 		{Key: SettingAwsS3Bucket, Value: SettingAwsS3BucketDefault},
 		{Key: SettingMongo, Value: SettingMongoDefault},
 		{Key: SettingDbSSL, Value: SettingDbSSLDefault},
 		{Key: SettingDbSSLSkipVerify, Value: SettingDbSSLSkipVerifyDefault},
+		{Key: SettingDbReadPreference, Value: SettingDbReadPreferenceDefault},
+		{Key: SettingDbPoolLimit, Value: SettingDbPoolLimitDefault},
+		{Key: SettingDbConnectTimeout, Value: SettingDbConnectTimeoutDefault},
+		{Key: SettingDbSocketTimeout, Value: SettingDbSocketTimeoutDefault},
+		{Key: SettingDbSyncTimeout, Value: SettingDbSyncTimeoutDefault},
 		{Key: SettingGateway, Value: SettingGatewayDefault},
 		{Key: SettingsAwsTagArtifact, Value: SettingsAwsTagArtifactDefault},
+		{Key: SettingAwsPresignClockSkew, Value: SettingAwsPresignClockSkewDefault},
+		{Key: SettingAwsConsistencyWindow, Value: SettingAwsConsistencyWindowDefault},
+		{Key: SettingAwsConsistencyAttempts, Value: SettingAwsConsistencyAttemptsDefault},
+		{Key: SettingAwsConsistencyBackoff, Value: SettingAwsConsistencyBackoffDefault},
+		{Key: SettingLogFormat, Value: SettingLogFormatDefault},
+		{Key: SettingStorageLatencyThreshold, Value: SettingStorageLatencyThresholdDefault},
+		{Key: SettingIntegrityCheckInterval, Value: SettingIntegrityCheckIntervalDefault},
+		{Key: SettingChecksumRecomputeRate, Value: SettingChecksumRecomputeRateDefault},
+		{Key: SettingArtifactExpiryCheckInterval, Value: SettingArtifactExpiryCheckIntervalDefault},
+		{Key: SettingUploadPurgeInterval, Value: SettingUploadPurgeIntervalDefault},
+		{Key: SettingUsageReconcileInterval, Value: SettingUsageReconcileIntervalDefault},
+		{Key: SettingDeletionRetryInterval, Value: SettingDeletionRetryIntervalDefault},
+		{Key: SettingDeletionRetryBackoff, Value: SettingDeletionRetryBackoffDefault},
+		{Key: SettingUploadConcurrency, Value: SettingUploadConcurrencyDefault},
+		{Key: SettingUploadConcurrencyPerTenant, Value: SettingUploadConcurrencyPerTenantDefault},
+		{Key: SettingArtifactKeyTemplate, Value: SettingArtifactKeyTemplateDefault},
+		{Key: SettingArtifactNameMaxLength, Value: SettingArtifactNameMaxLengthDefault},
+		{Key: SettingArtifactNamePattern, Value: SettingArtifactNamePatternDefault},
+		{Key: SettingArtifactFormRejectUnknown, Value: SettingArtifactFormRejectUnknownDefault},
+		{Key: SettingArtifactReviewerRole, Value: SettingArtifactReviewerRoleDefault},
+		{Key: SettingArtifactScanAsync, Value: SettingArtifactScanAsyncDefault},
+		{Key: SettingArtifactScanTimeout, Value: SettingArtifactScanTimeoutDefault},
+		{Key: SettingComposeDir, Value: SettingComposeDirDefault},
+		{Key: SettingComposeMaxSize, Value: SettingComposeMaxSizeDefault},
+		{Key: SettingDeploymentCallbackAttempts, Value: SettingDeploymentCallbackAttemptsDefault},
+		{Key: SettingDeploymentCallbackBackoff, Value: SettingDeploymentCallbackBackoffDefault},
+		{Key: SettingDeploymentCallbackTimeout, Value: SettingDeploymentCallbackTimeoutDefault},
+		{Key: SettingDeploymentCallbackAllowPrivate, Value: SettingDeploymentCallbackAllowPrivateDefault},
+		{Key: SettingDeploymentIdempotencyWindow, Value: SettingDeploymentIdempotencyWindowDefault},
+		{Key: SettingDeploymentRequireApproval, Value: SettingDeploymentRequireApprovalDefault},
+		{Key: SettingDeploymentBlockDeprecated, Value: SettingDeploymentBlockDeprecatedDefault},
+		{Key: SettingDeploymentDefaultDeviceType, Value: SettingDeploymentDefaultDeviceTypeDefault},
+		{Key: SettingDownloadProxy, Value: SettingDownloadProxyDefault},
+		{Key: SettingDownloadRestrictDevices, Value: SettingDownloadRestrictDevicesDefault},
+		{Key: SettingMaintenance, Value: SettingMaintenanceDefault},
+		{Key: SettingOperationTimeout, Value: SettingOperationTimeoutDefault},
+		{Key: SettingArtifactEmptyListStatus, Value: SettingArtifactEmptyListStatusDefault},
+		{Key: SettingUploadTimeout, Value: SettingUploadTimeoutDefault},
+		{Key: SettingStorageUploadTimeout, Value: SettingStorageUploadTimeoutDefault},
+		{Key: SettingUploadSlowThroughput, Value: SettingUploadSlowThroughputDefault},
+		{Key: SettingUploadSlowPeriod, Value: SettingUploadSlowPeriodDefault},
+		{Key: SettingHandlerTimeout, Value: SettingHandlerTimeoutDefault},
+		{Key: SettingMetricsTenantLabelsMax, Value: SettingMetricsTenantLabelsMaxDefault},
 	}
 )
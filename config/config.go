@@ -36,6 +36,7 @@ type ConfigReader interface {
 	GetTime(key string) time.Time
 	GetDuration(key string) time.Duration
 	IsSet(key string) bool
+	AllKeys() []string
 }
 
 func FromConfigFile(filePath string,
@@ -83,6 +84,39 @@ func ValidateConfig(c ConfigReader, validators ...Validator) error {
 	return nil
 }
 
+// ValidationErrors lists every problem found in the configuration
+type ValidationErrors []error
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = "  - " + err.Error()
+	}
+	return "Invalid configuration:\n" + strings.Join(msgs, "\n")
+}
+
+// ValidateConfigAll validates config according to provided validators.
+// Unlike ValidateConfig it doesn't stop on the first failure, all errors
+// are returned as ValidationErrors.
+func ValidateConfigAll(c ConfigReader, validators ...Validator) error {
+	var errs ValidationErrors
+
+	for _, validator := range validators {
+		switch err := validator(c).(type) {
+		case nil:
+		case ValidationErrors:
+			errs = append(errs, err...)
+		default:
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
 type Writer interface {
 	SetDefault(key string, val interface{})
 	Set(key string, val interface{})
@@ -32,6 +32,7 @@ func (m *MockConfigReader) GetStringSlice(key string) []string              { re
 func (m *MockConfigReader) GetTime(key string) time.Time                    { return time.Now() }
 func (m *MockConfigReader) GetDuration(key string) time.Duration            { return time.Second }
 func (m *MockConfigReader) IsSet(key string) bool                           { return true }
+func (m *MockConfigReader) AllKeys() []string                               { return []string{} }
 
 func TestValidateConfig(t *testing.T) {
 
@@ -52,3 +53,28 @@ func TestValidateConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateConfigAll(t *testing.T) {
+
+	err1 := errors.New("test error 1")
+	err2 := errors.New("test error 2")
+	ok := func(c ConfigReader) error { return nil }
+
+	if ValidateConfigAll(&MockConfigReader{}, ok) != nil {
+		t.FailNow()
+	}
+
+	err := ValidateConfigAll(&MockConfigReader{},
+		func(c ConfigReader) error { return err1 },
+		ok,
+		func(c ConfigReader) error { return ValidationErrors{err2} })
+
+	errs, isErrs := err.(ValidationErrors)
+	if !isErrs || len(errs) != 2 || errs[0] != err1 || errs[1] != err2 {
+		t.FailNow()
+	}
+
+	if err.Error() != "Invalid configuration:\n  - test error 1\n  - test error 2" {
+		t.FailNow()
+	}
+}
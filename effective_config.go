@@ -0,0 +1,178 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+
+	"github.com/mendersoftware/deployments/config"
+	deploymentsModel "github.com/mendersoftware/deployments/resources/deployments/model"
+	imagesController "github.com/mendersoftware/deployments/resources/images/controller"
+	imagesModel "github.com/mendersoftware/deployments/resources/images/model"
+	"github.com/mendersoftware/deployments/resources/images/s3"
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+)
+
+// Redacted replaces the values of the settings not known to be public
+// in the effective configuration
+const Redacted = "*****"
+
+// FileStorageS3 is the only file storage backend, AWS S3 or compatible
+const FileStorageS3 = "s3"
+
+// publicSettings are the settings shown as is in the effective configuration,
+// along with their nested settings; the others are redacted, so that
+// the secrets added later are not exposed by mistake.
+var publicSettings = map[string]bool{
+	SettingListen:                         true,
+	SettingAwsS3Region:                    true,
+	SettingAwsS3Bucket:                    true,
+	SettingsAwsTagArtifact:                true,
+	SettingAwsPresignClockSkew:            true,
+	SettingAwsConsistencyWindow:           true,
+	SettingAwsConsistencyAttempts:         true,
+	SettingAwsConsistencyBackoff:          true,
+	SettingDbSSL:                          true,
+	SettingDbSSLSkipVerify:                true,
+	SettingDbReadPreference:               true,
+	SettingDbPoolLimit:                    true,
+	SettingDbConnectTimeout:               true,
+	SettingDbSocketTimeout:                true,
+	SettingDbSyncTimeout:                  true,
+	SettingMiddleware:                     true,
+	SettingLogFormat:                      true,
+	SettingStorageLatencyThreshold:        true,
+	SettingIntegrityCheckInterval:         true,
+	SettingChecksumRecomputeRate:          true,
+	SettingArtifactExpiryCheckInterval:    true,
+	SettingUploadPurgeInterval:            true,
+	SettingUsageReconcileInterval:         true,
+	SettingDeletionRetryInterval:          true,
+	SettingDeletionRetryBackoff:           true,
+	SettingUploadConcurrency:              true,
+	SettingUploadConcurrencyPerTenant:     true,
+	SettingArtifactKeyTemplate:            true,
+	SettingArtifactContentTypes:           true,
+	SettingArtifactRequiredFields:         true,
+	SettingArtifactFormAliases:            true,
+	SettingArtifactFormRejectUnknown:      true,
+	SettingArtifactNameMaxLength:          true,
+	SettingArtifactNamePattern:            true,
+	SettingArtifactReviewerRole:           true,
+	SettingComposeMaxSize:                 true,
+	SettingArtifactScanAsync:              true,
+	SettingArtifactScanTimeout:            true,
+	SettingDeploymentCallbackAttempts:     true,
+	SettingDeploymentCallbackBackoff:      true,
+	SettingDeploymentCallbackTimeout:      true,
+	SettingDeploymentCallbackAllowPrivate: true,
+	SettingDeploymentIdempotencyWindow:    true,
+	SettingDeploymentRequireApproval:      true,
+	SettingDeploymentBlockDeprecated:      true,
+	SettingDeploymentDefaultDeviceType:    true,
+	SettingDownloadProxy:                  true,
+	SettingDownloadRestrictDevices:        true,
+	SettingMaintenance:                    true,
+	SettingOperationTimeout:               true,
+	SettingArtifactEmptyListStatus:        true,
+	SettingUploadTimeout:                  true,
+	SettingStorageUploadTimeout:           true,
+	SettingUploadSlowThroughput:           true,
+	SettingUploadSlowPeriod:               true,
+	SettingHandlerTimeout:                 true,
+	SettingHandlerTimeouts:                true,
+	SettingMetricsTenantLabelsMax:         true,
+}
+
+// EffectiveConfig is the configuration the service instance runs with:
+// the settings loaded, the defaults included, and the built-in limits.
+type EffectiveConfig struct {
+	Settings map[string]interface{} `json:"settings"`
+	Limits   EffectiveLimits        `json:"limits"`
+}
+
+// EffectiveLimits are the built-in limits of the service, not configurable;
+// durations are formatted as the duration settings.
+type EffectiveLimits struct {
+	FileStorage              string `json:"file_storage"`
+	MaxImageSize             int64  `json:"max_image_size"`
+	MaxDirectUploadSize      int64  `json:"max_direct_upload_size"`
+	MaxUploadRequestSize     int64  `json:"max_upload_request_size"`
+	MinLinkExpire            string `json:"min_link_expire"`
+	MaxLinkExpire            string `json:"max_link_expire"`
+	DownloadLinkExpire       string `json:"download_link_expire"`
+	UpdateDownloadLinkExpire string `json:"update_download_link_expire"`
+	UploadSessionExpire      string `json:"upload_session_expire"`
+}
+
+// NewEffectiveConfig collects the effective configuration, the values of
+// the settings not known to be public are redacted.
+func NewEffectiveConfig(c config.ConfigReader) *EffectiveConfig {
+	keys := c.AllKeys()
+	sort.Strings(keys)
+
+	settings := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		settings[key] = redactSetting(key, c.Get(key))
+	}
+
+	return &EffectiveConfig{
+		Settings: settings,
+		Limits: EffectiveLimits{
+			FileStorage:              FileStorageS3,
+			MaxImageSize:             imagesModel.MaxImageSize,
+			MaxDirectUploadSize:      imagesModel.MaxDirectUploadSize,
+			MaxUploadRequestSize:     imagesController.MaxUploadRequestSize,
+			MinLinkExpire:            s3.ExpireMinLimit.String(),
+			MaxLinkExpire:            s3.ExpireMaxLimit.String(),
+			DownloadLinkExpire:       imagesController.DefaultDownloadLinkExpire.String(),
+			UpdateDownloadLinkExpire: deploymentsModel.DefaultUpdateDownloadLinkExpire.String(),
+			UploadSessionExpire:      imagesModel.DefaultUploadSessionExpire.String(),
+		},
+	}
+}
+
+// redactSetting hides the value of the setting unless the setting, or
+// the one it is nested in, is public; the settings not set are left empty,
+// to tell them apart.
+func redactSetting(key string, value interface{}) interface{} {
+	for parent := key; ; {
+		if publicSettings[parent] {
+			return value
+		}
+		i := strings.LastIndex(parent, ".")
+		if i < 0 {
+			break
+		}
+		parent = parent[:i]
+	}
+
+	if value == nil || value == "" {
+		return value
+	}
+	return Redacted
+}
+
+// NewEffectiveConfigHandler responds with the effective configuration.
+// Served by the internal API only, the configuration is the operator's.
+func NewEffectiveConfigHandler(c config.ConfigReader) rest.HandlerFunc {
+	restView := new(view.RESTView)
+
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		restView.RenderSuccessGet(w, r, NewEffectiveConfig(c))
+	}
+}
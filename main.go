@@ -25,6 +25,7 @@ import (
 
 	"github.com/mendersoftware/deployments/config"
 	"github.com/mendersoftware/deployments/migrations"
+	"github.com/mendersoftware/deployments/utils/logging"
 )
 
 func main() {
@@ -84,6 +85,13 @@ func doMain(args []string) {
 				1)
 		}
 
+		err = logging.Setup(config.Config.GetString(SettingLogFormat))
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("error setting up logging: %s", err),
+				1)
+		}
+
 		return nil
 	}
 
@@ -103,6 +111,10 @@ func cmdServer(args *cli.Context) error {
 	l.Printf("Deployments Service, version %s starting up",
 		CreateVersionString())
 
+	if err := ValidateStartup(config.Config); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
 	dbSession, err := NewMongoSession(config.Config)
 	if err != nil {
 		return cli.NewExitError(
@@ -15,8 +15,10 @@
 package main
 
 import (
+	"fmt"
 	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/mendersoftware/go-lib-micro/accesslog"
@@ -26,6 +28,9 @@ import (
 	"github.com/mendersoftware/go-lib-micro/requestlog"
 
 	"github.com/mendersoftware/deployments/config"
+	"github.com/mendersoftware/deployments/utils/logging"
+	"github.com/mendersoftware/deployments/utils/restutil"
+	"github.com/mendersoftware/deployments/utils/tracing"
 )
 
 const (
@@ -41,6 +46,11 @@ const (
 	HttpHeaderLink                        string = "Link"
 	HttpHeaderAllow                       string = "Allow"
 	HttpHeaderAccept                      string = "Accept"
+	HttpHeaderUploadOffset                string = "Upload-Offset"
+
+	ContentTypeMultipart   string = "multipart/form-data"
+	ContentTypeUploadChunk string = "application/offset+octet-stream"
+	ContentTypeBundle      string = "application/x-tar"
 
 	EnvProd string = "prod"
 	EnvDev  string = "dev"
@@ -50,6 +60,7 @@ var commonLoggingAccessStack = []rest.Middleware{
 	// logging
 	&requestlog.RequestLogMiddleware{},
 	&accesslog.AccessLogMiddleware{Format: accesslog.SimpleLogFormat},
+	&logging.StatusLogMiddleware{},
 	&rest.TimerMiddleware{},
 	&rest.RecorderMiddleware{},
 }
@@ -69,8 +80,14 @@ var defaultProdStack = []rest.Middleware{
 	// catches the panic errorsx
 	&rest.RecoverMiddleware{},
 
-	// response compression
-	&rest.GzipMiddleware{},
+	// response compression, except for the artifact files streamed through
+	// the service: the byte ranges and the length refer to the file as stored
+	&rest.IfMiddleware{
+		Condition: func(r *rest.Request) bool {
+			return !isArtifactFileDownload(r)
+		},
+		IfTrue: &rest.GzipMiddleware{},
+	},
 }
 
 func SetupMiddleware(c config.ConfigReader, api *rest.Api) {
@@ -92,25 +109,31 @@ func SetupMiddleware(c config.ConfigReader, api *rest.Api) {
 	api.Use(&requestid.RequestIdMiddleware{},
 		&identity.IdentityMiddleware{
 			UpdateLogger: true,
-		})
+		},
+		&tracing.TracingMiddleware{})
+
+	// panics in handlers are responded with 500, logged with the request ID
+	api.Use(&restutil.RecoverMiddleware{})
+
+	// '/artifacts/' is served as '/artifacts'
+	api.Use(&restutil.TrailingSlashMiddleware{})
 
 	// Verifies the request Content-Type header if the content is non-null.
 	// For the POST /api/0.0.1/images request expected Content-Type is 'multipart/form-data'.
+	// For the PATCH of artifact upload session expected Content-Type is 'application/offset+octet-stream'.
+	// For the artifacts import expected Content-Type is 'application/x-tar'.
 	// For the rest of the requests expected Content-Type is 'application/json'.
 	api.Use(&rest.IfMiddleware{
 		Condition: func(r *rest.Request) bool {
-			if r.URL.Path == ApiUrlManagementArtifacts && r.Method == http.MethodPost {
-				return true
-			} else {
-				return false
-			}
+			return expectedContentType(r) != ""
 		},
 		IfTrue: rest.MiddlewareSimple(func(handler rest.HandlerFunc) rest.HandlerFunc {
 			return func(w rest.ResponseWriter, r *rest.Request) {
+				expected := expectedContentType(r)
 				mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
-				if r.ContentLength > 0 && !(mediatype == "multipart/form-data") {
+				if r.ContentLength > 0 && !(mediatype == expected) {
 					rest.Error(w,
-						"Bad Content-Type, expected 'multipart/form-data'",
+						fmt.Sprintf("Bad Content-Type, expected '%s'", expected),
 						http.StatusUnsupportedMediaType)
 					return
 				}
@@ -141,6 +164,7 @@ func SetupMiddleware(c config.ConfigReader, api *rest.Api) {
 			http.MethodGet,
 			http.MethodPost,
 			http.MethodPut,
+			http.MethodPatch,
 			http.MethodDelete,
 			http.MethodOptions,
 		},
@@ -155,12 +179,43 @@ func SetupMiddleware(c config.ConfigReader, api *rest.Api) {
 			HttpHeaderAcceptEncoding,
 			HttpHeaderAccessControlRequestHeaders,
 			HttpHeaderAccessControlRequestMethod,
+			HttpHeaderUploadOffset,
+			tracing.HttpHeaderTraceParent,
 		},
 
 		// Headers that can be exposed to JS
 		AccessControlExposeHeaders: []string{
 			HttpHeaderLocation,
 			HttpHeaderLink,
+			HttpHeaderUploadOffset,
+			tracing.HttpHeaderTraceParent,
 		},
 	})
 }
+
+// isArtifactFileDownload tells if the request is for the artifact file
+// streamed through the service (download proxy).
+func isArtifactFileDownload(r *rest.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	return (strings.HasPrefix(r.URL.Path, ApiUrlDevices+"/artifacts/") &&
+		strings.HasSuffix(r.URL.Path, "/download")) ||
+		(strings.HasPrefix(r.URL.Path, ApiUrlManagementArtifacts+"/") &&
+			strings.HasSuffix(r.URL.Path, "/file"))
+}
+
+// expectedContentType returns content type expected for requests
+// not carrying JSON payload, empty string otherwise.
+func expectedContentType(r *rest.Request) string {
+	switch {
+	case r.URL.Path == ApiUrlManagementArtifacts && r.Method == http.MethodPost:
+		return ContentTypeMultipart
+	case strings.HasPrefix(r.URL.Path, ApiUrlManagementArtifactsUploads+"/") &&
+		r.Method == http.MethodPatch:
+		return ContentTypeUploadChunk
+	case r.URL.Path == ApiUrlManagementArtifacts+"/import" && r.Method == http.MethodPost:
+		return ContentTypeBundle
+	}
+	return ""
+}
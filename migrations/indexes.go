@@ -0,0 +1,147 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package migrations
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	ctx_store "github.com/mendersoftware/go-lib-micro/store"
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+
+	deployments_mongo "github.com/mendersoftware/deployments/resources/deployments/mongo"
+	images_mongo "github.com/mendersoftware/deployments/resources/images/mongo"
+)
+
+// IndexReport lists names of the indexes of the database by collection:
+// the ones created by EnsureIndexes and the ones which were already present.
+type IndexReport struct {
+	Db       string              `json:"db"`
+	Created  map[string][]string `json:"created"`
+	Existing map[string][]string `json:"existing"`
+}
+
+// Collections with the indexes required by the storages
+var indexedCollections = []string{
+	images_mongo.CollectionImages,
+	images_mongo.CollectionDeletedImages,
+	deployments_mongo.CollectionDeployments,
+	deployments_mongo.CollectionDevices,
+}
+
+// EnsureIndexes creates the indexes required by the storages which are
+// missing in the database, e.g. added after the database was migrated.
+// Indexes already present are left intact, so it is safe to run repeatedly.
+func EnsureIndexes(ctx context.Context, db string, session *mgo.Session) (*IndexReport, error) {
+	s := session.Copy()
+	defer s.Close()
+
+	// indexes are ensured only once per session, but could have been
+	// dropped since
+	s.ResetIndexCache()
+
+	before, err := indexNames(s, db)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := images_mongo.NewSoftwareImagesStorage(s).DoEnsureIndexing(db, s); err != nil {
+		return nil, errors.Wrap(err, "failed to ensure indexes of artifacts")
+	}
+	if err := deployments_mongo.NewDeploymentsStorage(s).DoEnsureIndexing(db, s); err != nil {
+		return nil, errors.Wrap(err, "failed to ensure indexes of deployments")
+	}
+	if err := deployments_mongo.NewDeviceDeploymentsStorage(s).DoEnsureIndexing(db, s); err != nil {
+		return nil, errors.Wrap(err, "failed to ensure indexes of device deployments")
+	}
+
+	after, err := indexNames(s, db)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &IndexReport{
+		Db:       db,
+		Created:  make(map[string][]string),
+		Existing: make(map[string][]string),
+	}
+	for _, c := range indexedCollections {
+		existing := make(map[string]bool)
+		for _, name := range before[c] {
+			existing[name] = true
+		}
+
+		report.Created[c] = []string{}
+		report.Existing[c] = before[c]
+		for _, name := range after[c] {
+			if !existing[name] {
+				report.Created[c] = append(report.Created[c], name)
+			}
+		}
+
+		if len(report.Created[c]) > 0 {
+			log.FromContext(ctx).F(log.Ctx{
+				"db":         db,
+				"collection": c,
+				"indexes":    report.Created[c],
+			}).Info("created missing indexes")
+		}
+	}
+
+	return report, nil
+}
+
+// EnsureAllIndexes runs EnsureIndexes for the tenant databases, or the default
+// database in single tenant setup.
+func EnsureAllIndexes(ctx context.Context, session *mgo.Session) ([]IndexReport, error) {
+	dbs, err := migrate.GetTenantDbs(session, ctx_store.IsTenantDb(DbName))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed go retrieve tenant DBs")
+	}
+
+	if len(dbs) == 0 {
+		dbs = []string{DbName}
+	}
+
+	reports := make([]IndexReport, 0, len(dbs))
+	for _, d := range dbs {
+		report, err := EnsureIndexes(ctx, d, session)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to ensure indexes of %s", d)
+		}
+		reports = append(reports, *report)
+	}
+
+	return reports, nil
+}
+
+// indexNames lists names of the indexes of the indexed collections,
+// missing collections have no indexes.
+func indexNames(s *mgo.Session, db string) (map[string][]string, error) {
+	names := make(map[string][]string)
+	for _, c := range indexedCollections {
+		idxs, err := s.DB(db).C(c).Indexes()
+		if err != nil && !isNotFound(err) {
+			return nil, errors.Wrapf(err, "failed to list indexes of %s", c)
+		}
+
+		names[c] = []string{}
+		for _, idx := range idxs {
+			names[c] = append(names[c], idx.Name)
+		}
+	}
+	return names, nil
+}
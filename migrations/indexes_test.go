@@ -0,0 +1,87 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2"
+
+	dm "github.com/mendersoftware/deployments/resources/deployments/mongo"
+	im "github.com/mendersoftware/deployments/resources/images/mongo"
+)
+
+func TestEnsureIndexes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestEnsureIndexes in short mode.")
+	}
+
+	db.Wipe()
+	s := db.Session()
+	defer s.Close()
+
+	const dbName = "deployment_service-59afdb71c704db002a86ad95"
+
+	// database migrated before the tags and expiry indexes were added
+	err := s.DB(dbName).C(im.CollectionImages).EnsureIndex(mgo.Index{
+		Key: []string{
+			im.StorageKeySoftwareImageName,
+			im.StorageKeySoftwareImageDeviceTypes,
+			im.StorageKeySoftwareImageDeltaFrom,
+		},
+		Unique: true,
+		Name:   im.IndexUniqueNameDeviceTypeAndDeltaStr,
+	})
+	assert.NoError(t, err)
+
+	report, err := EnsureIndexes(context.Background(), dbName, s)
+	assert.NoError(t, err)
+	assert.Equal(t, dbName, report.Db)
+	assert.Equal(t, []string{im.IndexChecksumStr, im.IndexExpiresAtStr,
+		im.IndexModifiedStr, im.IndexTagsStr},
+		report.Created[im.CollectionImages])
+	assert.Equal(t, []string{"_id_", im.IndexUniqueNameDeviceTypeAndDeltaStr},
+		report.Existing[im.CollectionImages])
+	assert.Equal(t, []string{"_id_", im.IndexDeletedStr},
+		report.Created[im.CollectionDeletedImages])
+	assert.Equal(t, []string{"_id_", dm.IndexDeploymentArtifactNameStr,
+		dm.IndexDeploymentArtifactsStr, dm.IndexDeploymentUniqueIdempotencyStr},
+		report.Created[dm.CollectionDeployments])
+	assert.Empty(t, report.Existing[dm.CollectionDeployments])
+	assert.Equal(t, []string{"_id_", dm.IndexDeviceDeploymentStatusStr,
+		dm.IndexDeviceDeploymentFinishedStr},
+		report.Created[dm.CollectionDevices])
+
+	// nothing to create when run again, also if the indexes were ensured
+	// with the session before
+	report, err = EnsureIndexes(context.Background(), dbName, s)
+	assert.NoError(t, err)
+	for _, c := range indexedCollections {
+		assert.Empty(t, report.Created[c])
+	}
+
+	// dropped index is recreated
+	assert.NoError(t, s.DB(dbName).C(im.CollectionImages).DropIndexName(im.IndexTagsStr))
+	report, err = EnsureIndexes(context.Background(), dbName, s)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{im.IndexTagsStr}, report.Created[im.CollectionImages])
+
+	reports, err := EnsureAllIndexes(context.Background(), s)
+	assert.NoError(t, err)
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, dbName, reports[0].Db)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package migrations
+
+import (
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	"gopkg.in/mgo.v2"
+
+	deployments_mongo "github.com/mendersoftware/deployments/resources/deployments/mongo"
+)
+
+type migration_1_2_2 struct {
+	session *mgo.Session
+	db      string
+}
+
+// Up creates the index for listing device deployments by deployment and status
+func (m *migration_1_2_2) Up(from migrate.Version) error {
+	s := m.session.Copy()
+	defer s.Close()
+
+	storage := deployments_mongo.NewDeviceDeploymentsStorage(m.session)
+	return storage.DoEnsureIndexing(m.db, s)
+}
+
+func (m *migration_1_2_2) Version() migrate.Version {
+	return migrate.MakeVersion(1, 2, 2)
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	"github.com/stretchr/testify/assert"
+
+	dm "github.com/mendersoftware/deployments/resources/deployments/mongo"
+)
+
+func TestMigration_1_2_3(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMigration_1_2_3 in short mode.")
+	}
+
+	for _, dbName := range []string{
+		"deployments_service",
+		"deployments_service-59afdb71c704db002a86ad95",
+	} {
+		db.Wipe()
+		s := db.Session()
+
+		migrations := []migrate.Migration{
+			&migration_1_2_1{
+				session: s,
+				db:      dbName,
+			},
+			&migration_1_2_2{
+				session: s,
+				db:      dbName,
+			},
+			&migration_1_2_3{
+				session: s,
+				db:      dbName,
+			},
+		}
+
+		m := migrate.SimpleMigrator{
+			Session:     s,
+			Db:          dbName,
+			Automigrate: true,
+		}
+
+		err := m.Apply(context.Background(), migrate.MakeVersion(1, 2, 3), migrations)
+		assert.NoError(t, err)
+
+		idxs, err := s.DB(dbName).C(dm.CollectionDeployments).Indexes()
+		assert.NoError(t, err)
+		assert.True(t, hasIndex(dm.IndexDeploymentArtifactsStr, idxs))
+
+		s.Close()
+	}
+}
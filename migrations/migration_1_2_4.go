@@ -0,0 +1,63 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package migrations
+
+import (
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	"gopkg.in/mgo.v2"
+
+	images_mongo "github.com/mendersoftware/deployments/resources/images/mongo"
+)
+
+type migration_1_2_4 struct {
+	session *mgo.Session
+	db      string
+}
+
+// Up replaces the unique artifact name and device type index in the 'images'
+// collection with the one allowing deltas of the artifact
+func (m *migration_1_2_4) Up(from migrate.Version) error {
+	s := m.session.Copy()
+	defer s.Close()
+
+	err := s.DB(m.db).
+		C(images_mongo.CollectionImages).
+		DropIndexName(images_mongo.IndexUniqeNameAndDeviceTypeStr)
+
+	// neither the collection nor the index exist if no artifact
+	// was ever uploaded
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	storage := images_mongo.NewSoftwareImagesStorage(m.session)
+	return storage.DoEnsureIndexing(m.db, s)
+}
+
+func (m *migration_1_2_4) Version() migrate.Version {
+	return migrate.MakeVersion(1, 2, 4)
+}
+
+// MongoDB error codes of missing collection and missing index
+const (
+	errCodeNamespaceNotFound = 26
+	errCodeIndexNotFound     = 27
+)
+
+func isNotFound(err error) bool {
+	if qerr, ok := err.(*mgo.QueryError); ok {
+		return qerr.Code == errCodeNamespaceNotFound || qerr.Code == errCodeIndexNotFound
+	}
+	return err.Error() == "ns not found"
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2"
+
+	im "github.com/mendersoftware/deployments/resources/images/mongo"
+)
+
+func TestMigration_1_2_4(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMigration_1_2_4 in short mode.")
+	}
+
+	testCases := map[string]struct {
+		db string
+
+		// create the old index before migrating
+		withOldIndex bool
+	}{
+		"ST, no index": {
+			db: "deployments_service",
+		},
+		"ST, with old index": {
+			db:           "deployments_service",
+			withOldIndex: true,
+		},
+		"MT, with old index": {
+			db:           "deployments_service-59afdb71c704db002a86ad95",
+			withOldIndex: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+
+		db.Wipe()
+		s := db.Session()
+
+		if tc.withOldIndex {
+			err := s.DB(tc.db).C(im.CollectionImages).EnsureIndex(mgo.Index{
+				Key:    []string{im.StorageKeySoftwareImageName, im.StorageKeySoftwareImageDeviceTypes},
+				Unique: true,
+				Name:   im.IndexUniqeNameAndDeviceTypeStr,
+			})
+			assert.NoError(t, err)
+		}
+
+		migrations := []migrate.Migration{
+			&migration_1_2_4{
+				session: s,
+				db:      tc.db,
+			},
+		}
+
+		m := migrate.SimpleMigrator{
+			Session:     s,
+			Db:          tc.db,
+			Automigrate: true,
+		}
+
+		err := m.Apply(context.Background(), migrate.MakeVersion(1, 2, 4), migrations)
+		assert.NoError(t, err)
+
+		idxs, err := s.DB(tc.db).C(im.CollectionImages).Indexes()
+		assert.NoError(t, err)
+		assert.True(t, hasIndex(im.IndexUniqueNameDeviceTypeAndDeltaStr, idxs))
+		assert.False(t, hasIndex(im.IndexUniqeNameAndDeviceTypeStr, idxs))
+
+		s.Close()
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package migrations
+
+import (
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	images_mongo "github.com/mendersoftware/deployments/resources/images/mongo"
+)
+
+type migration_1_2_5 struct {
+	session *mgo.Session
+	db      string
+}
+
+// Up normalizes the compatible device types of the artifacts in the 'images'
+// collection. Artifacts which would clash with other artifact of the same
+// name and device type once normalized are left intact.
+func (m *migration_1_2_5) Up(from migrate.Version) error {
+	s := m.session.Copy()
+	defer s.Close()
+
+	c := s.DB(m.db).C(images_mongo.CollectionImages)
+
+	var image struct {
+		Id           string `bson:"_id"`
+		MetaArtifact struct {
+			DeviceTypes []string `bson:"device_types_compatible"`
+		} `bson:"meta_artifact"`
+	}
+
+	iter := c.Find(nil).
+		Select(bson.M{images_mongo.StorageKeySoftwareImageDeviceTypes: 1}).
+		Iter()
+	for iter.Next(&image) {
+		deviceTypes := image.MetaArtifact.DeviceTypes
+		normalized := images.NormalizeDeviceTypes(deviceTypes)
+		if equalStrings(deviceTypes, normalized) {
+			continue
+		}
+
+		err := c.UpdateId(image.Id, bson.M{"$set": bson.M{
+			images_mongo.StorageKeySoftwareImageDeviceTypes: normalized,
+		}})
+		if mgo.IsDup(err) {
+			log.New(log.Ctx{
+				"db":           m.db,
+				"image_id":     image.Id,
+				"device_types": deviceTypes,
+			}).Warn("device types not normalized, conflicting artifact exists")
+			continue
+		}
+		if err != nil {
+			iter.Close()
+			return err
+		}
+	}
+
+	return iter.Close()
+}
+
+func (m *migration_1_2_5) Version() migrate.Version {
+	return migrate.MakeVersion(1, 2, 5)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+
+	im "github.com/mendersoftware/deployments/resources/images/mongo"
+)
+
+func TestMigration_1_2_5(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMigration_1_2_5 in short mode.")
+	}
+
+	testCases := map[string]struct {
+		db string
+	}{
+		"ST": {
+			db: "deployments_service",
+		},
+		"MT": {
+			db: "deployments_service-59afdb71c704db002a86ad95",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+
+		db.Wipe()
+		s := db.Session()
+
+		storage := im.NewSoftwareImagesStorage(s)
+		assert.NoError(t, storage.DoEnsureIndexing(tc.db, s))
+
+		c := s.DB(tc.db).C(im.CollectionImages)
+		for id, meta := range map[string]bson.M{
+			"normalized": {"name": "foo", "device_types_compatible": []string{"pi"}},
+			"mixed case": {"name": "foo", "device_types_compatible": []string{" Beagle ", "BEAGLE"}},
+			"conflict":   {"name": "foo", "device_types_compatible": []string{"PI"}},
+		} {
+			assert.NoError(t, c.Insert(bson.M{"_id": id, "meta_artifact": meta}))
+		}
+
+		migrations := []migrate.Migration{
+			&migration_1_2_5{
+				session: s,
+				db:      tc.db,
+			},
+		}
+
+		m := migrate.SimpleMigrator{
+			Session:     s,
+			Db:          tc.db,
+			Automigrate: true,
+		}
+
+		err := m.Apply(context.Background(), migrate.MakeVersion(1, 2, 5), migrations)
+		assert.NoError(t, err)
+
+		for id, expected := range map[string][]string{
+			"normalized": {"pi"},
+			"mixed case": {"beagle"},
+			"conflict":   {"PI"},
+		} {
+			var image struct {
+				MetaArtifact struct {
+					DeviceTypes []string `bson:"device_types_compatible"`
+				} `bson:"meta_artifact"`
+			}
+			assert.NoError(t, c.FindId(id).One(&image))
+			assert.Equal(t, expected, image.MetaArtifact.DeviceTypes, id)
+		}
+
+		s.Close()
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package migrations
+
+import (
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	deployments_mongo "github.com/mendersoftware/deployments/resources/deployments/mongo"
+)
+
+type migration_1_2_6 struct {
+	session *mgo.Session
+	db      string
+}
+
+// Up replaces the idempotency key index in the 'deployments' collection with
+// the unique one. Only the latest deployment keeps the key used more than once.
+func (m *migration_1_2_6) Up(from migrate.Version) error {
+	s := m.session.Copy()
+	defer s.Close()
+
+	c := s.DB(m.db).C(deployments_mongo.CollectionDeployments)
+
+	err := c.DropIndexName(deployments_mongo.IndexDeploymentIdempotencyStr)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	var deployment struct {
+		Id  string `bson:"_id"`
+		Key string `bson:"idempotency_key"`
+	}
+
+	iter := c.Find(bson.M{
+		deployments_mongo.StorageKeyDeploymentIdempotency: bson.M{"$type": "string"},
+	}).
+		Select(bson.M{deployments_mongo.StorageKeyDeploymentIdempotency: 1}).
+		Sort(deployments_mongo.StorageKeyDeploymentIdempotency,
+			"-"+deployments_mongo.StorageKeyDeploymentCreated).
+		Iter()
+	previous := ""
+	for iter.Next(&deployment) {
+		if deployment.Key != previous {
+			previous = deployment.Key
+			continue
+		}
+
+		err := c.UpdateId(deployment.Id, bson.M{"$unset": bson.M{
+			deployments_mongo.StorageKeyDeploymentIdempotency: "",
+		}})
+		if err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	storage := deployments_mongo.NewDeploymentsStorage(m.session)
+	return storage.DoEnsureIndexing(m.db, s)
+}
+
+func (m *migration_1_2_6) Version() migrate.Version {
+	return migrate.MakeVersion(1, 2, 6)
+}
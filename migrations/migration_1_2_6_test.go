@@ -0,0 +1,121 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package migrations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	dm "github.com/mendersoftware/deployments/resources/deployments/mongo"
+)
+
+func TestMigration_1_2_6(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMigration_1_2_6 in short mode.")
+	}
+
+	testCases := map[string]struct {
+		db string
+
+		// create the old index before migrating
+		withOldIndex bool
+	}{
+		"ST, no index": {
+			db: "deployments_service",
+		},
+		"ST, with old index": {
+			db:           "deployments_service",
+			withOldIndex: true,
+		},
+		"MT, with old index": {
+			db:           "deployments_service-59afdb71c704db002a86ad95",
+			withOldIndex: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+
+		db.Wipe()
+		s := db.Session()
+
+		c := s.DB(tc.db).C(dm.CollectionDeployments)
+		if tc.withOldIndex {
+			err := c.EnsureIndex(mgo.Index{
+				Key:    []string{dm.StorageKeyDeploymentIdempotency, "-" + dm.StorageKeyDeploymentCreated},
+				Name:   dm.IndexDeploymentIdempotencyStr,
+				Sparse: true,
+			})
+			assert.NoError(t, err)
+		}
+
+		now := time.Now()
+		for id, deployment := range map[string]bson.M{
+			"older":   {"idempotency_key": "key-1", "created": now.Add(-time.Hour)},
+			"latest":  {"idempotency_key": "key-1", "created": now},
+			"other":   {"idempotency_key": "key-2", "created": now.Add(-time.Hour)},
+			"without": {"created": now},
+		} {
+			deployment["_id"] = id
+			assert.NoError(t, c.Insert(deployment))
+		}
+
+		migrations := []migrate.Migration{
+			&migration_1_2_6{
+				session: s,
+				db:      tc.db,
+			},
+		}
+
+		m := migrate.SimpleMigrator{
+			Session:     s,
+			Db:          tc.db,
+			Automigrate: true,
+		}
+
+		err := m.Apply(context.Background(), migrate.MakeVersion(1, 2, 6), migrations)
+		assert.NoError(t, err)
+
+		idxs, err := c.Indexes()
+		assert.NoError(t, err)
+		assert.True(t, hasIndex(dm.IndexDeploymentUniqueIdempotencyStr, idxs))
+		assert.False(t, hasIndex(dm.IndexDeploymentIdempotencyStr, idxs))
+
+		for id, expected := range map[string]string{
+			"older":   "",
+			"latest":  "key-1",
+			"other":   "key-2",
+			"without": "",
+		} {
+			var deployment struct {
+				Key string `bson:"idempotency_key"`
+			}
+			assert.NoError(t, c.FindId(id).One(&deployment))
+			assert.Equal(t, expected, deployment.Key, id)
+		}
+
+		// the key is unique once migrated
+		err = c.Insert(bson.M{"_id": "duplicate", "idempotency_key": "key-1"})
+		assert.True(t, mgo.IsDup(err))
+
+		s.Close()
+	}
+}
@@ -24,7 +24,7 @@ import (
 )
 
 const (
-	DbVersion = "1.2.1"
+	DbVersion = "1.2.6"
 	DbName    = "deployment_service"
 )
 
@@ -85,6 +85,26 @@ func MigrateSingle(ctx context.Context,
 			session: session,
 			db:      db,
 		},
+		&migration_1_2_2{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_3{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_4{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_5{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_6{
+			session: session,
+			db:      db,
+		},
 	}
 
 	err = m.Apply(ctx, *ver, migrations)
@@ -92,5 +112,13 @@ func MigrateSingle(ctx context.Context,
 		return errors.Wrap(err, "failed to apply migrations")
 	}
 
+	// indexes added without a migration are missing in the databases
+	// migrated before
+	if automigrate {
+		if _, err := EnsureIndexes(ctx, db, session); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,94 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package deployments
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/pkg/errors"
+)
+
+// Bounds of the canary cohort size, in percent of the eligible devices
+const (
+	CanaryMinPercentage = 1
+	CanaryMaxPercentage = 100
+)
+
+var ErrInvalidCanaryPercentage = errors.Errorf(
+	"Invalid canary percentage, expected between %d and %d",
+	CanaryMinPercentage, CanaryMaxPercentage)
+
+// CanarySpec selects the devices the deployment is rolled out to first,
+// the other devices wait until the deployment is promoted.
+type CanarySpec struct {
+	// Percent of the eligible devices in the canary cohort, rounded up
+	Percentage int `json:"percentage" bson:"percentage"`
+
+	// Only the devices which last reported the device type are eligible, optional
+	DeviceType string `json:"device_type,omitempty" bson:"device_type,omitempty" valid:"length(1|4096),optional"`
+
+	// Seed of the selection: the same seed selects the same devices, optional
+	Seed int64 `json:"seed,omitempty" bson:"seed,omitempty"`
+}
+
+// Validate checks the percentage and the device type.
+func (c *CanarySpec) Validate() error {
+	if _, err := govalidator.ValidateStruct(c); err != nil {
+		return err
+	}
+	if c.Percentage < CanaryMinPercentage || c.Percentage > CanaryMaxPercentage {
+		return ErrInvalidCanaryPercentage
+	}
+	return nil
+}
+
+// Select picks the canary cohort out of the eligible devices. The devices are
+// ordered by the hash of the seed and the device ID, and the first ones make
+// the cohort, so that selecting again out of the same devices gives the same
+// result regardless of their order.
+func (c *CanarySpec) Select(eligible []string) []string {
+	if len(eligible) == 0 {
+		return []string{}
+	}
+
+	type weighted struct {
+		id   string
+		hash uint64
+	}
+	devices := make([]weighted, 0, len(eligible))
+	seed := strconv.FormatInt(c.Seed, 10)
+	for _, id := range eligible {
+		h := fnv.New64a()
+		h.Write([]byte(seed + ":" + id))
+		devices = append(devices, weighted{id: id, hash: h.Sum64()})
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].hash != devices[j].hash {
+			return devices[i].hash < devices[j].hash
+		}
+		return devices[i].id < devices[j].id
+	})
+
+	size := int(math.Ceil(float64(len(devices)*c.Percentage) / 100))
+	cohort := make([]string, 0, size)
+	for _, device := range devices[:size] {
+		cohort = append(cohort, device.id)
+	}
+	return cohort
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package deployments_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/mendersoftware/deployments/resources/deployments"
+)
+
+func TestCanarySpecValidate(t *testing.T) {
+	assert.NoError(t, (&CanarySpec{Percentage: 10}).Validate())
+	assert.NoError(t, (&CanarySpec{Percentage: 100, DeviceType: "hammer"}).Validate())
+	assert.Equal(t, ErrInvalidCanaryPercentage, (&CanarySpec{}).Validate())
+	assert.Equal(t, ErrInvalidCanaryPercentage, (&CanarySpec{Percentage: 101}).Validate())
+}
+
+func TestCanarySpecSelect(t *testing.T) {
+	devices := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	reversed := make([]string, 0, len(devices))
+	for i := len(devices) - 1; i >= 0; i-- {
+		reversed = append(reversed, devices[i])
+	}
+
+	spec := &CanarySpec{Percentage: 25, Seed: 42}
+	cohort := spec.Select(devices)
+	// rounded up
+	assert.Len(t, cohort, 3)
+	for _, id := range cohort {
+		assert.Contains(t, devices, id)
+	}
+
+	// stable, regardless of the order of the devices
+	assert.Equal(t, cohort, spec.Select(devices))
+	assert.Equal(t, cohort, spec.Select(reversed))
+
+	// other seed, other cohort
+	other := (&CanarySpec{Percentage: 25, Seed: 7}).Select(devices)
+	assert.Len(t, other, 3)
+	assert.NotEqual(t, cohort, other)
+
+	// at least one device
+	assert.Len(t, (&CanarySpec{Percentage: 1}).Select(devices), 1)
+	all := (&CanarySpec{Percentage: 100}).Select(devices)
+	sort.Strings(all)
+	assert.Equal(t, devices, all)
+	assert.Empty(t, spec.Select(nil))
+}
@@ -17,6 +17,8 @@ package controller
 import (
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/asaskevich/govalidator"
@@ -26,6 +28,8 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/deployments/resources/deployments"
+	"github.com/mendersoftware/deployments/utils/readpref"
+	"github.com/mendersoftware/deployments/utils/restutil"
 )
 
 // Errors
@@ -37,6 +41,38 @@ var (
 	ErrUnexpectedDeploymentStatus = errors.New("Unexpected deployment status")
 	ErrMissingIdentity            = errors.New("Missing identity data")
 	ErrNoArtifact                 = errors.New("No artifact for the deployment")
+	ErrArtifactExpired            = errors.New("Artifact for the deployment has expired")
+	ErrArtifactNotApproved        = errors.New("Artifact for the deployment is not approved")
+	ErrArtifactDeprecated         = errors.New("Artifact for the deployment is deprecated")
+	ErrInvalidDryRun              = errors.New("Invalid dry_run value, expected boolean")
+	ErrMissingDeviceType          = errors.New("Missing device_type parameter")
+	ErrInvalidIdempotencyKey      = errors.New("Invalid idempotency key, expected at most 255 characters")
+	ErrInvalidFinishedRange       = errors.New("Invalid finished_after or finished_before, expected RFC 3339 times in order")
+	ErrNoCanaryDevices            = errors.New("No devices eligible for the canary cohort")
+	ErrDeploymentNotCanary        = errors.New("Deployment is not a canary deployment")
+	ErrDeploymentHalted           = errors.New("Deployment was halted")
+	ErrDeploymentPromoted         = errors.New("Deployment was already promoted")
+	ErrInvalidStatusUpdates       = errors.Errorf("Invalid updates, expected between 1 and %d status updates", MaxDeviceStatusUpdates)
+)
+
+const (
+	// Query parameter requesting deployment creation without persisting it
+	QueryDryRun = "dry_run"
+
+	// Query parameters bounding the finish time of the counted device deployments
+	QueryFinishedAfter  = "finished_after"
+	QueryFinishedBefore = "finished_before"
+
+	// Maximal number of deployments the statistics are fetched of at once
+	MaxGetDeploymentsStatsIDs = 100
+
+	// Maximal number of device deployment status updates applied at once
+	MaxDeviceStatusUpdates = 1000
+
+	// Header identifying the deployment creation request, repeated requests
+	// with the same key are answered with the originally created deployment
+	IdempotencyKeyHeader    = "X-Idempotency-Key"
+	IdempotencyKeyMaxLength = 255
 )
 
 type DeploymentsController struct {
@@ -57,13 +93,88 @@ func (d *DeploymentsController) PostDeployment(w rest.ResponseWriter, r *rest.Re
 
 	constructor, err := d.getDeploymentConstructorFromBody(r)
 	if err != nil {
-		d.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get(QueryDryRun); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			d.view.RenderError(w, r, ErrInvalidDryRun, http.StatusBadRequest, l)
+			return
+		}
+	}
+
+	if dryRun {
+		d.planDeployment(w, r, constructor)
+		return
+	}
+
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+		d.createIdempotentDeployment(w, r, constructor, key)
+		return
+	}
+
+	created, err := d.model.CreateDeployment(ctx, constructor)
+	if err != nil {
+		if isCreationError(err) {
+			d.view.RenderError(w, r, err, http.StatusUnprocessableEntity, l)
+		} else {
+			d.view.RenderInternalError(w, r, err, l)
+		}
+		return
+	}
+
+	d.view.RenderSuccessPostObject(w, r, created.Id, created)
+}
+
+func (d *DeploymentsController) createIdempotentDeployment(w rest.ResponseWriter,
+	r *rest.Request, constructor *deployments.DeploymentConstructor, key string) {
+
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	if len(key) > IdempotencyKeyMaxLength {
+		d.view.RenderError(w, r, ErrInvalidIdempotencyKey, http.StatusBadRequest, l)
+		return
+	}
+
+	deployment, created, err := d.model.CreateDeploymentWithIdempotencyKey(ctx,
+		constructor, key)
+	if err != nil {
+		if isCreationError(err) {
+			d.view.RenderError(w, r, err, http.StatusUnprocessableEntity, l)
+		} else {
+			d.view.RenderInternalError(w, r, err, l)
+		}
+		return
+	}
+
+	if created == nil {
+		d.view.RenderSuccessGet(w, r, deployment)
 		return
 	}
 
-	id, err := d.model.CreateDeployment(ctx, constructor)
+	d.view.RenderSuccessPostObject(w, r, created.Id, created)
+}
+
+// isCreationError tells if the deployment can't be created because of
+// the artifacts or the canary devices it refers to.
+func isCreationError(err error) bool {
+	return err == ErrNoArtifact || err == ErrArtifactExpired ||
+		err == ErrArtifactNotApproved || err == ErrArtifactDeprecated ||
+		err == ErrNoCanaryDevices
+}
+
+func (d *DeploymentsController) planDeployment(w rest.ResponseWriter, r *rest.Request,
+	constructor *deployments.DeploymentConstructor) {
+
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	plan, err := d.model.PlanDeployment(ctx, constructor)
 	if err != nil {
-		if err == ErrNoArtifact {
+		if isCreationError(err) {
 			d.view.RenderError(w, r, err, http.StatusUnprocessableEntity, l)
 		} else {
 			d.view.RenderInternalError(w, r, err, l)
@@ -71,24 +182,24 @@ func (d *DeploymentsController) PostDeployment(w rest.ResponseWriter, r *rest.Re
 		return
 	}
 
-	d.view.RenderSuccessPost(w, r, id)
+	d.view.RenderSuccessGet(w, r, plan)
 }
 
 func (d *DeploymentsController) getDeploymentConstructorFromBody(r *rest.Request) (*deployments.DeploymentConstructor, error) {
-	var constructor *deployments.DeploymentConstructor
-	if err := r.DecodeJsonPayload(&constructor); err != nil {
+	var constructor deployments.DeploymentConstructor
+	if err := restutil.DecodeJsonObject(r.Body, &constructor); err != nil {
 		return nil, err
 	}
 
 	if err := constructor.Validate(); err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Validating request body")
 	}
 
-	return constructor, nil
+	return &constructor, nil
 }
 
 func (d *DeploymentsController) GetDeployment(w rest.ResponseWriter, r *rest.Request) {
-	ctx := r.Context()
+	ctx := readpref.WithSecondaryReads(r.Context())
 	l := log.FromContext(ctx)
 
 	id := r.PathParam("id")
@@ -109,11 +220,11 @@ func (d *DeploymentsController) GetDeployment(w rest.ResponseWriter, r *rest.Req
 		return
 	}
 
-	d.view.RenderSuccessGet(w, deployment)
+	d.view.RenderSuccessGet(w, r, deployment)
 }
 
 func (d *DeploymentsController) GetDeploymentStats(w rest.ResponseWriter, r *rest.Request) {
-	ctx := r.Context()
+	ctx := readpref.WithSecondaryReads(r.Context())
 	l := log.FromContext(ctx)
 
 	id := r.PathParam("id")
@@ -134,7 +245,81 @@ func (d *DeploymentsController) GetDeploymentStats(w rest.ResponseWriter, r *res
 		return
 	}
 
-	d.view.RenderSuccessGet(w, stats)
+	d.view.RenderSuccessGet(w, r, stats)
+}
+
+// GetDeploymentsStats counts the device deployments by status of each of
+// the deployments listed in the body; the deployments not found are
+// listed as missing.
+func (d *DeploymentsController) GetDeploymentsStats(w rest.ResponseWriter, r *rest.Request) {
+	ctx := readpref.WithSecondaryReads(r.Context())
+	l := log.FromContext(ctx)
+
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := restutil.DecodeJsonObject(r.Body, &body); err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	if err := restutil.ValidateIDList(body.IDs, MaxGetDeploymentsStatsIDs); err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	lookup, err := d.model.GetDeploymentsStats(ctx, body.IDs)
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	d.view.RenderSuccessGet(w, r, lookup)
+}
+
+// GetArtifactStats counts the devices each artifact was installed on
+// successfully and failed to install on, optionally within a time range.
+func (d *DeploymentsController) GetArtifactStats(w rest.ResponseWriter, r *rest.Request) {
+	ctx := readpref.WithSecondaryReads(r.Context())
+	l := log.FromContext(ctx)
+
+	query, err := parseArtifactStatsQuery(r.URL.Query())
+	if err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	stats, err := d.model.GetArtifactStats(ctx, query)
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	d.view.RenderSuccessGet(w, r, stats)
+}
+
+func parseArtifactStatsQuery(vals url.Values) (deployments.ArtifactStatsQuery, error) {
+	query := deployments.ArtifactStatsQuery{}
+
+	for param, bound := range map[string]*time.Time{
+		QueryFinishedAfter:  &query.FinishedAfter,
+		QueryFinishedBefore: &query.FinishedBefore,
+	} {
+		if value := vals.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return query, ErrInvalidFinishedRange
+			}
+			*bound = t
+		}
+	}
+
+	if !query.FinishedAfter.IsZero() && !query.FinishedBefore.IsZero() &&
+		!query.FinishedAfter.Before(query.FinishedBefore) {
+		return query, ErrInvalidFinishedRange
+	}
+
+	return query, nil
 }
 
 func (d *DeploymentsController) AbortDeployment(w rest.ResponseWriter, r *rest.Request) {
@@ -153,7 +338,7 @@ func (d *DeploymentsController) AbortDeployment(w rest.ResponseWriter, r *rest.R
 		Status string
 	}
 
-	err := r.DecodeJsonPayload(&status)
+	err := restutil.DecodeJsonObject(r.Body, &status)
 	if err != nil {
 		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
 		return
@@ -163,7 +348,7 @@ func (d *DeploymentsController) AbortDeployment(w rest.ResponseWriter, r *rest.R
 		d.view.RenderError(w, r, ErrUnexpectedDeploymentStatus, http.StatusBadRequest, l)
 	}
 
-	l.Infof("Abort deployment: %s", id)
+	l.F(log.Ctx{"deployment_id": id}).Info("abort deployment")
 
 	// Check if deployment is finished
 	isDeploymentFinished, err := d.model.IsDeploymentFinished(ctx, id)
@@ -184,11 +369,90 @@ func (d *DeploymentsController) AbortDeployment(w rest.ResponseWriter, r *rest.R
 	d.view.RenderEmptySuccessResponse(w)
 }
 
+// PromoteDeployment rolls out the canary deployment to all its devices.
+func (d *DeploymentsController) PromoteDeployment(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		d.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	l.F(log.Ctx{"deployment_id": id}).Info("promote deployment")
+
+	switch err := d.model.PromoteDeployment(ctx, id); err {
+	default:
+		d.view.RenderInternalError(w, r, err, l)
+	case nil:
+		d.view.RenderEmptySuccessResponse(w)
+	case ErrModelDeploymentNotFound:
+		d.view.RenderErrorNotFound(w, r, l)
+	case ErrDeploymentNotCanary, ErrDeploymentHalted:
+		d.view.RenderError(w, r, err, http.StatusConflict, l)
+	case ErrDeploymentAlreadyFinished:
+		d.view.RenderError(w, r, err, http.StatusUnprocessableEntity, l)
+	}
+}
+
+func (d *DeploymentsController) HaltDeployment(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		d.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	l.F(log.Ctx{"deployment_id": id}).Info("halt deployment")
+
+	switch err := d.model.HaltDeployment(ctx, id); err {
+	default:
+		d.view.RenderInternalError(w, r, err, l)
+	case nil:
+		d.view.RenderEmptySuccessResponse(w)
+	case ErrModelDeploymentNotFound:
+		d.view.RenderErrorNotFound(w, r, l)
+	case ErrDeploymentNotCanary, ErrDeploymentPromoted:
+		d.view.RenderError(w, r, err, http.StatusConflict, l)
+	case ErrDeploymentAlreadyFinished:
+		d.view.RenderError(w, r, err, http.StatusUnprocessableEntity, l)
+	}
+}
+
 const (
 	GetDeploymentForDeviceQueryArtifact   = "artifact_name"
 	GetDeploymentForDeviceQueryDeviceType = "device_type"
 )
 
+// DefaultDeviceType is assumed for the devices reporting no device type,
+// configurable on startup; such devices are rejected if empty.
+var DefaultDeviceType string
+
+// installedDeviceDeployment reads the artifact installed on the device and
+// the device type from the query, falling back to DefaultDeviceType.
+func installedDeviceDeployment(r *rest.Request, deviceID string,
+	l *log.Logger) deployments.InstalledDeviceDeployment {
+
+	q := r.URL.Query()
+	installed := deployments.InstalledDeviceDeployment{
+		Artifact:   q.Get(GetDeploymentForDeviceQueryArtifact),
+		DeviceType: q.Get(GetDeploymentForDeviceQueryDeviceType),
+	}
+
+	if installed.DeviceType == "" && DefaultDeviceType != "" {
+		l.F(log.Ctx{"device_id": deviceID, "device_type": DefaultDeviceType}).
+			Info("device reported no device type, assuming default")
+		installed.DeviceType = DefaultDeviceType
+	}
+
+	return installed
+}
+
 func (d *DeploymentsController) GetDeploymentForDevice(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 	l := log.FromContext(ctx)
@@ -199,12 +463,7 @@ func (d *DeploymentsController) GetDeploymentForDevice(w rest.ResponseWriter, r
 		return
 	}
 
-	q := r.URL.Query()
-	installed := deployments.InstalledDeviceDeployment{
-		Artifact:   q.Get(GetDeploymentForDeviceQueryArtifact),
-		DeviceType: q.Get(GetDeploymentForDeviceQueryDeviceType),
-	}
-
+	installed := installedDeviceDeployment(r, idata.Subject, l)
 	if err := installed.Validate(); err != nil {
 		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
 		return
@@ -221,7 +480,56 @@ func (d *DeploymentsController) GetDeploymentForDevice(w rest.ResponseWriter, r
 		return
 	}
 
-	d.view.RenderSuccessGet(w, deployment)
+	d.view.RenderSuccessGet(w, r, deployment)
+}
+
+// PreviewDeploymentForDevice tells the device which artifact it would
+// receive on the next update request, without starting the deployment.
+func (d *DeploymentsController) PreviewDeploymentForDevice(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	idata := identity.FromContext(ctx)
+	if idata == nil {
+		d.view.RenderError(w, r, ErrMissingIdentity, http.StatusBadRequest, l)
+		return
+	}
+
+	d.previewDeployment(w, r, idata.Subject)
+}
+
+// PreviewDeploymentForDeviceID is the management counterpart of
+// PreviewDeploymentForDevice, for the device given in the path.
+func (d *DeploymentsController) PreviewDeploymentForDeviceID(w rest.ResponseWriter, r *rest.Request) {
+	d.previewDeployment(w, r, r.PathParam("id"))
+}
+
+func (d *DeploymentsController) previewDeployment(w rest.ResponseWriter, r *rest.Request,
+	deviceID string) {
+
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	installed := installedDeviceDeployment(r, deviceID, l)
+
+	// current artifact is optional here
+	if installed.DeviceType == "" {
+		d.view.RenderError(w, r, ErrMissingDeviceType, http.StatusBadRequest, l)
+		return
+	}
+
+	preview, err := d.model.PreviewDeploymentForDevice(ctx, deviceID, installed)
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if preview == nil {
+		d.view.RenderNoUpdateForDevice(w)
+		return
+	}
+
+	d.view.RenderSuccessGet(w, r, preview)
 }
 
 func (d *DeploymentsController) PutDeploymentStatusForDevice(w rest.ResponseWriter, r *rest.Request) {
@@ -239,13 +547,20 @@ func (d *DeploymentsController) PutDeploymentStatusForDevice(w rest.ResponseWrit
 	// receive request body
 	var report statusReport
 
-	err := r.DecodeJsonPayload(&report)
+	err := restutil.DecodeJsonObject(r.Body, &report)
 	if err != nil {
 		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
 		return
 	}
 
-	l.Infof("status: %+v", report)
+	logCtx := log.Ctx{
+		"deployment_id": did,
+		"device_status": report.Status,
+	}
+	if report.SubState != nil {
+		logCtx["device_substate"] = *report.SubState
+	}
+	l.F(logCtx).Info("device deployment status report")
 	if err := d.model.UpdateDeviceDeploymentStatus(ctx, did,
 		idata.Subject, deployments.DeviceDeploymentStatus{
 			Status:   report.Status,
@@ -263,10 +578,43 @@ func (d *DeploymentsController) PutDeploymentStatusForDevice(w rest.ResponseWrit
 	d.view.RenderEmptySuccessResponse(w)
 }
 
-func (d *DeploymentsController) GetDeviceStatusesForDeployment(w rest.ResponseWriter, r *rest.Request) {
+// PutDeploymentStatusesForDevices applies a batch of the device deployment
+// statuses of the tenant, reported on behalf of the devices, and responds
+// with the outcome of each status update.
+func (d *DeploymentsController) PutDeploymentStatusesForDevices(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 	l := log.FromContext(ctx)
 
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: r.PathParam("tenant"),
+	})
+
+	var body struct {
+		Updates []deployments.DeviceStatusUpdate `json:"updates"`
+	}
+	if err := restutil.DecodeJsonObject(r.Body, &body); err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	if len(body.Updates) == 0 || len(body.Updates) > MaxDeviceStatusUpdates {
+		d.view.RenderError(w, r, ErrInvalidStatusUpdates, http.StatusBadRequest, l)
+		return
+	}
+
+	results, err := d.model.UpdateDeviceDeploymentStatuses(ctx, body.Updates)
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	d.view.RenderSuccessGet(w, r, results)
+}
+
+func (d *DeploymentsController) GetDeviceStatusesForDeployment(w rest.ResponseWriter, r *rest.Request) {
+	ctx := readpref.WithSecondaryReads(r.Context())
+	l := log.FromContext(ctx)
+
 	did := r.PathParam("id")
 
 	if !govalidator.IsUUIDv4(did) {
@@ -286,7 +634,63 @@ func (d *DeploymentsController) GetDeviceStatusesForDeployment(w rest.ResponseWr
 		}
 	}
 
-	d.view.RenderSuccessGet(w, statuses)
+	d.view.RenderSuccessGet(w, r, statuses)
+}
+
+func (d *DeploymentsController) GetDevicesListForDeployment(w rest.ResponseWriter, r *rest.Request) {
+	ctx := readpref.WithSecondaryReads(r.Context())
+	l := log.FromContext(ctx)
+
+	did := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(did) {
+		d.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	query := deployments.ListQuery{DeploymentID: did}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && !deployments.IsValidDeviceDeploymentStatus(status) {
+		d.view.RenderError(w, r, errors.Errorf("unknown status %s", status),
+			http.StatusBadRequest, l)
+		return
+	}
+	query.Status = status
+
+	page, perPage, err := rest_utils.ParsePagination(r)
+	if err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+	query.Skip = int((page - 1) * perPage)
+	query.Limit = int(perPage + 1)
+
+	statuses, err := d.model.GetDevicesListForDeployment(ctx, query)
+	if err != nil {
+		switch err {
+		case ErrModelDeploymentNotFound:
+			d.view.RenderError(w, r, err, http.StatusNotFound, l)
+			return
+		default:
+			d.view.RenderInternalError(w, r, ErrInternal, l)
+			return
+		}
+	}
+
+	len := len(statuses)
+	hasNext := false
+	if uint64(len) > perPage {
+		hasNext = true
+		len = int(perPage)
+	}
+
+	links := rest_utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+
+	d.view.RenderSuccessGet(w, r, statuses[:len])
 }
 
 func ParseLookupQuery(vals url.Values) (deployments.Query, error) {
@@ -317,8 +721,74 @@ func ParseLookupQuery(vals url.Values) (deployments.Query, error) {
 	return query, nil
 }
 
+// deploymentsPage is the page of the deployments listed, by the cursor or
+// by the page number
+type deploymentsPage struct {
+	cursor  *restutil.CursorPage
+	page    uint64
+	perPage uint64
+}
+
+// parseDeploymentsPage parses the pagination of the request into the query,
+// one more deployment is listed to tell if there is the next page
+func parseDeploymentsPage(r *rest.Request,
+	query *deployments.Query) (*deploymentsPage, error) {
+
+	cursor, err := restutil.ParseCursorPage(r)
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil {
+		query.Limit = cursor.PerPage + 1
+		if cursor.Cursor != nil {
+			if cursor.Cursor.Time == nil {
+				return nil, restutil.ErrInvalidCursor
+			}
+			query.AfterCreated = cursor.Cursor.Time
+			query.AfterID = cursor.Cursor.ID
+		}
+		return &deploymentsPage{cursor: cursor}, nil
+	}
+
+	page, perPage, err := rest_utils.ParsePagination(r)
+	if err != nil {
+		return nil, err
+	}
+	query.Skip = int((page - 1) * perPage)
+	query.Limit = int(perPage + 1)
+	return &deploymentsPage{page: page, perPage: perPage}, nil
+}
+
+// paginate cuts the deployments listed down to the page and adds the Link
+// headers of the pages around it
+func (p *deploymentsPage) paginate(w rest.ResponseWriter, r *rest.Request,
+	deps []*deployments.Deployment) []*deployments.Deployment {
+
+	if p.cursor != nil {
+		if len(deps) > p.cursor.PerPage {
+			deps = deps[:p.cursor.PerPage]
+			last := deps[len(deps)-1]
+			w.Header().Add(rest_utils.LinkHdr, restutil.MakeCursorLinkHdr(r,
+				&restutil.Cursor{Time: last.Created, ID: *last.Id}))
+		}
+		return deps
+	}
+
+	hasNext := false
+	if uint64(len(deps)) > p.perPage {
+		hasNext = true
+		deps = deps[:p.perPage]
+	}
+
+	links := rest_utils.MakePageLinkHdrs(r, p.page, p.perPage, hasNext)
+	for _, l := range links {
+		w.Header().Add(rest_utils.LinkHdr, l)
+	}
+	return deps
+}
+
 func (d *DeploymentsController) LookupDeployment(w rest.ResponseWriter, r *rest.Request) {
-	ctx := r.Context()
+	ctx := readpref.WithSecondaryReads(r.Context())
 	l := log.FromContext(ctx)
 
 	query, err := ParseLookupQuery(r.URL.Query())
@@ -327,13 +797,11 @@ func (d *DeploymentsController) LookupDeployment(w rest.ResponseWriter, r *rest.
 		return
 	}
 
-	page, perPage, err := rest_utils.ParsePagination(r)
+	page, err := parseDeploymentsPage(r, &query)
 	if err != nil {
 		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
 		return
 	}
-	query.Skip = int((page - 1) * perPage)
-	query.Limit = int(perPage + 1)
 
 	deps, err := d.model.LookupDeployment(ctx, query)
 	if err != nil {
@@ -341,19 +809,41 @@ func (d *DeploymentsController) LookupDeployment(w rest.ResponseWriter, r *rest.
 		return
 	}
 
-	len := len(deps)
-	hasNext := false
-	if uint64(len) > perPage {
-		hasNext = true
-		len = int(perPage)
+	d.view.RenderSuccessGet(w, r, page.paginate(w, r, deps))
+}
+
+// ListDeploymentsForArtifact lists deployments which reference given artifact
+func (d *DeploymentsController) ListDeploymentsForArtifact(w rest.ResponseWriter, r *rest.Request) {
+	ctx := readpref.WithSecondaryReads(r.Context())
+	l := log.FromContext(ctx)
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		d.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
 	}
 
-	links := rest_utils.MakePageLinkHdrs(r, page, perPage, hasNext)
-	for _, l := range links {
-		w.Header().Add("Link", l)
+	query, err := ParseLookupQuery(r.URL.Query())
+	if err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+	query.ArtifactID = id
+
+	page, err := parseDeploymentsPage(r, &query)
+	if err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	deps, err := d.model.LookupDeployment(ctx, query)
+	if err != nil {
+		d.view.RenderInternalError(w, r, ErrInternal, l)
+		return
 	}
 
-	d.view.RenderSuccessGet(w, deps[:len])
+	d.view.RenderSuccessGet(w, r, page.paginate(w, r, deps))
 }
 
 func (d *DeploymentsController) PutDeploymentLogForDevice(w rest.ResponseWriter, r *rest.Request) {
@@ -372,7 +862,7 @@ func (d *DeploymentsController) PutDeploymentLogForDevice(w rest.ResponseWriter,
 	// (un-)marshalling DeploymentLog to/from JSON
 	var log deployments.DeploymentLog
 
-	err := r.DecodeJsonPayload(&log)
+	err := restutil.DecodeJsonObject(r.Body, &log)
 	if err != nil {
 		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
 		return
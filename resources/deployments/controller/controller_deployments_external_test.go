@@ -15,6 +15,7 @@
 package controller_test
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -22,6 +23,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -40,6 +42,7 @@ import (
 	"github.com/mendersoftware/deployments/resources/deployments/view"
 	"github.com/mendersoftware/deployments/resources/images"
 	. "github.com/mendersoftware/deployments/utils/pointers"
+	"github.com/mendersoftware/deployments/utils/restutil"
 	h "github.com/mendersoftware/deployments/utils/testing"
 )
 
@@ -279,6 +282,185 @@ func TestControllerGetDeploymentForDevice(t *testing.T) {
 	}
 }
 
+func TestControllerGetDeploymentForDeviceDefaultDeviceType(t *testing.T) {
+	DefaultDeviceType = "legacy"
+	defer func() { DefaultDeviceType = "" }()
+
+	deploymentModel := new(mocks.DeploymentsModel)
+	deploymentModel.On("GetDeploymentForDeviceWithCurrent",
+		h.ContextMatcher(), "device-id-1",
+		deployments.InstalledDeviceDeployment{
+			Artifact:   "artifact-name",
+			DeviceType: "legacy",
+		}).
+		Return(nil, nil)
+
+	router, err := rest.MakeRouter(
+		rest.Get("/r/update",
+			NewDeploymentsController(deploymentModel,
+				new(view.DeploymentsView)).GetDeploymentForDevice))
+	assert.NoError(t, err)
+
+	vals := url.Values{
+		GetDeploymentForDeviceQueryArtifact: []string{"artifact-name"},
+	}
+	req := test.MakeSimpleRequest("GET", "http://localhost/r/update?"+vals.Encode(), nil)
+	req.Header.Set("Authorization", makeDeviceAuthHeader(`{"sub": "device-id-1"}`))
+	req.Header.Add(requestid.RequestIdHeader, "test")
+	recorded := test.RunRequest(t, makeApi(router).MakeHandler(), req)
+
+	h.CheckRecordedResponse(t, recorded, h.JSONResponseParams{
+		OutputStatus: http.StatusNoContent,
+	})
+	deploymentModel.AssertExpectations(t)
+}
+
+func TestControllerPreviewDeploymentForDevice(t *testing.T) {
+
+	t.Parallel()
+
+	preview := &deployments.DeploymentPreview{
+		ID: "foo-1",
+		Artifact: deployments.ArtifactPreview{
+			ID:                    validUUIDv4,
+			ArtifactName:          "artifact-name",
+			DeviceTypesCompatible: []string{"hammer"},
+		},
+	}
+
+	testCases := []struct {
+		h.JSONResponseParams
+
+		InputID string
+		Params  url.Values
+
+		InputModelPreview *deployments.DeploymentPreview
+		InputModelError   error
+
+		InputModelCurrentDeployment deployments.InstalledDeviceDeployment
+
+		Headers map[string]string
+	}{
+		{
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrMissingIdentity),
+			},
+			Headers: map[string]string{
+				"Authorization": makeDeviceAuthHeader(`"sub": "device"}`),
+			},
+		},
+		{
+			InputID: "device-id-1",
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrMissingDeviceType),
+			},
+			Params: url.Values{
+				GetDeploymentForDeviceQueryArtifact: []string{"artifact-name"},
+			},
+			Headers: map[string]string{
+				"Authorization": makeDeviceAuthHeader(`{"sub": "device-id-1"}`),
+			},
+		},
+		{
+			InputID:         "device-id-2",
+			InputModelError: errors.New("model error"),
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+			},
+			InputModelCurrentDeployment: deployments.InstalledDeviceDeployment{
+				DeviceType: "hammer",
+			},
+			Params: url.Values{
+				GetDeploymentForDeviceQueryDeviceType: []string{"hammer"},
+			},
+			Headers: map[string]string{
+				"Authorization": makeDeviceAuthHeader(`{"sub": "device-id-2"}`),
+			},
+		},
+		{
+			InputID: "device-id-3",
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusNoContent,
+			},
+			InputModelCurrentDeployment: deployments.InstalledDeviceDeployment{
+				Artifact:   "artifact-name",
+				DeviceType: "hammer",
+			},
+			Params: url.Values{
+				GetDeploymentForDeviceQueryArtifact:   []string{"artifact-name"},
+				GetDeploymentForDeviceQueryDeviceType: []string{"hammer"},
+			},
+			Headers: map[string]string{
+				"Authorization": makeDeviceAuthHeader(`{"sub": "device-id-3"}`),
+			},
+		},
+		{
+			InputID:           "device-id-4",
+			InputModelPreview: preview,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: preview,
+			},
+			InputModelCurrentDeployment: deployments.InstalledDeviceDeployment{
+				DeviceType: "hammer",
+			},
+			Params: url.Values{
+				GetDeploymentForDeviceQueryDeviceType: []string{"hammer"},
+			},
+			Headers: map[string]string{
+				"Authorization": makeDeviceAuthHeader(`{"sub": "device-id-4"}`),
+			},
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+
+			deploymentModel := new(mocks.DeploymentsModel)
+			deploymentModel.On("PreviewDeploymentForDevice",
+				h.ContextMatcher(),
+				testCase.InputID,
+				testCase.InputModelCurrentDeployment).
+				Return(testCase.InputModelPreview, testCase.InputModelError)
+
+			controller := NewDeploymentsController(deploymentModel, new(view.DeploymentsView))
+			router, err := rest.MakeRouter(
+				rest.Get("/r/preview", controller.PreviewDeploymentForDevice),
+				rest.Get("/r/devices/:id/next", controller.PreviewDeploymentForDeviceID))
+			assert.NoError(t, err)
+
+			api := makeApi(router)
+
+			vals := testCase.Params.Encode()
+			req := test.MakeSimpleRequest("GET", "http://localhost/r/preview?"+vals, nil)
+			for k, v := range testCase.Headers {
+				req.Header.Set(k, v)
+			}
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
+
+			// management API gives the same answer for the device
+			if testCase.InputID == "" {
+				return
+			}
+			req = test.MakeSimpleRequest("GET",
+				"http://localhost/r/devices/"+testCase.InputID+"/next?"+vals, nil)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded = test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
+			deploymentModel.AssertNotCalled(t, "GetDeploymentForDeviceWithCurrent",
+				mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
 func TestControllerGetDeployment(t *testing.T) {
 
 	t.Parallel()
@@ -358,6 +540,44 @@ func TestControllerGetDeployment(t *testing.T) {
 	}
 }
 
+func TestControllerGetDeploymentTimesUTC(t *testing.T) {
+
+	t.Parallel()
+
+	// decoded by the storage driver in the local time zone
+	created := time.Date(2018, 3, 10, 11, 12, 1, 422000000, time.FixedZone("CEST", 2*60*60))
+	finished := time.Date(2018, 3, 10, 4, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+	deployment := &deployments.Deployment{
+		Id:       StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+		Created:  &created,
+		Finished: &finished,
+	}
+
+	deploymentModel := new(mocks.DeploymentsModel)
+	deploymentModel.On("GetDeployment",
+		h.ContextMatcher(), *deployment.Id).
+		Return(deployment, nil)
+
+	router, err := rest.MakeRouter(
+		rest.Get("/r/:id",
+			NewDeploymentsController(deploymentModel, new(view.DeploymentsView)).GetDeployment))
+	assert.NoError(t, err)
+
+	api := makeApi(router)
+
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/r/"+*deployment.Id, nil))
+	recorded.CodeIs(http.StatusOK)
+
+	var received struct {
+		Created  string `json:"created"`
+		Finished string `json:"finished"`
+	}
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Equal(t, "2018-03-10T09:12:01.422Z", received.Created)
+	assert.Equal(t, "2018-03-10T09:30:00Z", received.Finished)
+}
+
 func TestControllerPostDeployment(t *testing.T) {
 
 	t.Parallel()
@@ -367,14 +587,14 @@ func TestControllerPostDeployment(t *testing.T) {
 
 		InputBodyObject interface{}
 
-		InputModelID    string
-		InputModelError error
+		InputModelCreated *deployments.DeploymentCreated
+		InputModelError   error
 	}{
 		{
 			InputBodyObject: nil,
 			JSONResponseParams: h.JSONResponseParams{
 				OutputStatus:     http.StatusBadRequest,
-				OutputBodyObject: h.ErrorToErrStruct(errors.New("Validating request body: JSON payload is empty")),
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("Malformed request body: JSON payload is empty")),
 			},
 		},
 		{
@@ -402,11 +622,79 @@ func TestControllerPostDeployment(t *testing.T) {
 				ArtifactName: StringToPointer("App 123"),
 				Devices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
 			},
-			InputModelID: "1234",
+			InputModelCreated: &deployments.DeploymentCreated{
+				Id:       "1234",
+				Warnings: []deployments.PlannedDevice{},
+			},
 			JSONResponseParams: h.JSONResponseParams{
-				OutputStatus:     http.StatusCreated,
-				OutputBodyObject: nil,
-				OutputHeaders:    map[string]string{"Location": "./r/1234"},
+				OutputStatus: http.StatusCreated,
+				OutputBodyObject: &deployments.DeploymentCreated{
+					Id:       "1234",
+					Warnings: []deployments.PlannedDevice{},
+				},
+				OutputHeaders: map[string]string{"Location": "./r/1234"},
+			},
+		},
+		{
+			InputBodyObject: &deployments.DeploymentConstructor{
+				Name:         StringToPointer("NYC Production"),
+				ArtifactName: StringToPointer("App 123"),
+				Devices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			},
+			InputModelCreated: &deployments.DeploymentCreated{
+				Id: "1234",
+				Warnings: []deployments.PlannedDevice{
+					{
+						DeviceId:   "f826484e-1157-4109-af21-304e6d711560",
+						DeviceType: "screwdriver",
+					},
+				},
+			},
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusCreated,
+				OutputBodyObject: &deployments.DeploymentCreated{
+					Id: "1234",
+					Warnings: []deployments.PlannedDevice{
+						{
+							DeviceId:   "f826484e-1157-4109-af21-304e6d711560",
+							DeviceType: "screwdriver",
+						},
+					},
+				},
+				OutputHeaders: map[string]string{"Location": "./r/1234"},
+			},
+		},
+		{
+			InputBodyObject: &deployments.DeploymentConstructor{
+				Name:         StringToPointer("NYC Production"),
+				ArtifactName: StringToPointer("App 123"),
+				Devices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			},
+			InputModelCreated: &deployments.DeploymentCreated{
+				Id:                  "1234",
+				Warnings:            []deployments.PlannedDevice{},
+				DeprecatedArtifacts: []string{"a1"},
+			},
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusCreated,
+				OutputBodyObject: &deployments.DeploymentCreated{
+					Id:                  "1234",
+					Warnings:            []deployments.PlannedDevice{},
+					DeprecatedArtifacts: []string{"a1"},
+				},
+				OutputHeaders: map[string]string{"Location": "./r/1234"},
+			},
+		},
+		{
+			InputBodyObject: &deployments.DeploymentConstructor{
+				Name:         StringToPointer("NYC Production"),
+				ArtifactName: StringToPointer("App 123"),
+				Devices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			},
+			InputModelError: ErrArtifactDeprecated,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusUnprocessableEntity,
+				OutputBodyObject: h.ErrorToErrStruct(ErrArtifactDeprecated),
 			},
 		},
 	}
@@ -419,7 +707,7 @@ func TestControllerPostDeployment(t *testing.T) {
 
 			deploymentModel.On("CreateDeployment",
 				h.ContextMatcher(), testCase.InputBodyObject).
-				Return(testCase.InputModelID, testCase.InputModelError)
+				Return(testCase.InputModelCreated, testCase.InputModelError)
 
 			router, err := rest.MakeRouter(
 				rest.Post("/r",
@@ -438,6 +726,269 @@ func TestControllerPostDeployment(t *testing.T) {
 	}
 }
 
+func TestControllerPostDeploymentMalformedBody(t *testing.T) {
+
+	t.Parallel()
+
+	testCases := []struct {
+		body  string
+		error string
+	}{
+		{
+			body:  `{"name": "NYC Production", "artifact_name": "App`,
+			error: "Malformed request body: unexpected end of JSON input",
+		},
+		{
+			body:  `["NYC Production"]`,
+			error: "Malformed request body: JSON object expected",
+		},
+		{
+			body:  `null`,
+			error: "Malformed request body: JSON object expected",
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+
+			deploymentModel := new(mocks.DeploymentsModel)
+
+			router, err := rest.MakeRouter(
+				rest.Post("/r",
+					NewDeploymentsController(deploymentModel,
+						new(view.DeploymentsView)).PostDeployment))
+			assert.NoError(t, err)
+
+			api := makeApi(router)
+
+			req := test.MakeSimpleRequest("POST", "http://localhost/r", nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(testCase.body))
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New(testCase.error)),
+			})
+			deploymentModel.AssertExpectations(t)
+		})
+	}
+}
+
+func TestControllerPostDeploymentDryRun(t *testing.T) {
+
+	t.Parallel()
+
+	constructor := &deployments.DeploymentConstructor{
+		Name:         StringToPointer("NYC Production"),
+		ArtifactName: StringToPointer("App 123"),
+		Devices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+	}
+	plan := &deployments.DeploymentPlan{
+		Name:              "NYC Production",
+		ArtifactName:      "App 123",
+		Artifacts:         []string{"1234"},
+		Devices:           []string{"f826484e-1157-4109-af21-304e6d711560"},
+		Skipped:           []deployments.PlannedDevice{},
+		UnknownDeviceType: []string{"f826484e-1157-4109-af21-304e6d711560"},
+	}
+
+	testCases := []struct {
+		h.JSONResponseParams
+
+		InputDryRun string
+
+		InputModelPlan  *deployments.DeploymentPlan
+		InputModelError error
+	}{
+		{
+			InputDryRun: "maybe",
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrInvalidDryRun),
+			},
+		},
+		{
+			InputDryRun:     "true",
+			InputModelError: ErrNoArtifact,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusUnprocessableEntity,
+				OutputBodyObject: h.ErrorToErrStruct(ErrNoArtifact),
+			},
+		},
+		{
+			InputDryRun:     "1",
+			InputModelError: errors.New("model error"),
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+			},
+		},
+		{
+			InputDryRun:    "true",
+			InputModelPlan: plan,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: plan,
+			},
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+
+			deploymentModel := new(mocks.DeploymentsModel)
+
+			deploymentModel.On("PlanDeployment",
+				h.ContextMatcher(), constructor).
+				Return(testCase.InputModelPlan, testCase.InputModelError)
+
+			router, err := rest.MakeRouter(
+				rest.Post("/r",
+					NewDeploymentsController(deploymentModel,
+						new(view.DeploymentsView)).PostDeployment))
+			assert.NoError(t, err)
+
+			api := makeApi(router)
+
+			req := test.MakeSimpleRequest("POST",
+				"http://localhost/r?dry_run="+testCase.InputDryRun, constructor)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
+			deploymentModel.AssertNotCalled(t, "CreateDeployment", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestControllerPostDeploymentIdempotencyKey(t *testing.T) {
+
+	t.Parallel()
+
+	constructor := &deployments.DeploymentConstructor{
+		Name:         StringToPointer("NYC Production"),
+		ArtifactName: StringToPointer("App 123"),
+		Devices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+	}
+	deployment := deployments.NewDeploymentFromConstructor(constructor)
+
+	testCases := []struct {
+		h.JSONResponseParams
+
+		InputKey string
+
+		InputModelDeployment *deployments.Deployment
+		InputModelCreated    *deployments.DeploymentCreated
+		InputModelError      error
+	}{
+		{
+			InputKey: strings.Repeat("k", IdempotencyKeyMaxLength+1),
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrInvalidIdempotencyKey),
+			},
+		},
+		{
+			InputKey:        "key-1",
+			InputModelError: ErrNoArtifact,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusUnprocessableEntity,
+				OutputBodyObject: h.ErrorToErrStruct(ErrNoArtifact),
+			},
+		},
+		{
+			InputKey:        "key-1",
+			InputModelError: ErrArtifactExpired,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusUnprocessableEntity,
+				OutputBodyObject: h.ErrorToErrStruct(ErrArtifactExpired),
+			},
+		},
+		{
+			InputKey:        "key-1",
+			InputModelError: ErrArtifactNotApproved,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusUnprocessableEntity,
+				OutputBodyObject: h.ErrorToErrStruct(ErrArtifactNotApproved),
+			},
+		},
+		{
+			InputKey:        "key-1",
+			InputModelError: errors.New("model error"),
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+			},
+		},
+		{
+			InputKey:             "key-1",
+			InputModelDeployment: deployment,
+			InputModelCreated: &deployments.DeploymentCreated{
+				Id: *deployment.Id,
+				Warnings: []deployments.PlannedDevice{
+					{
+						DeviceId:   "f826484e-1157-4109-af21-304e6d711560",
+						DeviceType: "screwdriver",
+					},
+				},
+			},
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusCreated,
+				OutputBodyObject: &deployments.DeploymentCreated{
+					Id: *deployment.Id,
+					Warnings: []deployments.PlannedDevice{
+						{
+							DeviceId:   "f826484e-1157-4109-af21-304e6d711560",
+							DeviceType: "screwdriver",
+						},
+					},
+				},
+				OutputHeaders: map[string]string{"Location": "./r/" + *deployment.Id},
+			},
+		},
+		{
+			InputKey:             "key-1",
+			InputModelDeployment: deployment,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: deployment,
+			},
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+
+			deploymentModel := new(mocks.DeploymentsModel)
+
+			deploymentModel.On("CreateDeploymentWithIdempotencyKey",
+				h.ContextMatcher(), constructor, testCase.InputKey).
+				Return(testCase.InputModelDeployment, testCase.InputModelCreated,
+					testCase.InputModelError)
+
+			router, err := rest.MakeRouter(
+				rest.Post("/r",
+					NewDeploymentsController(deploymentModel,
+						new(view.DeploymentsView)).PostDeployment))
+			assert.NoError(t, err)
+
+			api := makeApi(router)
+
+			req := test.MakeSimpleRequest("POST", "http://localhost/r", constructor)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			req.Header.Add(IdempotencyKeyHeader, testCase.InputKey)
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
+			deploymentModel.AssertNotCalled(t, "CreateDeployment", mock.Anything, mock.Anything)
+		})
+	}
+}
+
 func TestControllerPutDeploymentStatus(t *testing.T) {
 
 	t.Parallel()
@@ -469,7 +1020,7 @@ func TestControllerPutDeploymentStatus(t *testing.T) {
 
 			JSONResponseParams: h.JSONResponseParams{
 				OutputStatus:     http.StatusBadRequest,
-				OutputBodyObject: h.ErrorToErrStruct(errors.New("JSON payload is empty")),
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("Malformed request body: JSON payload is empty")),
 			},
 			Headers: map[string]string{
 				"Authorization": makeDeviceAuthHeader(`{"sub": "device-id-1"}`),
@@ -563,67 +1114,189 @@ func TestControllerPutDeploymentStatus(t *testing.T) {
 			},
 
 			JSONResponseParams: h.JSONResponseParams{
-				OutputStatus:     http.StatusNoContent,
-				OutputBodyObject: nil,
-			},
-			Headers: map[string]string{
-				"Authorization": makeDeviceAuthHeader(`{"sub": "device-id-2"}`),
+				OutputStatus:     http.StatusNoContent,
+				OutputBodyObject: nil,
+			},
+			Headers: map[string]string{
+				"Authorization": makeDeviceAuthHeader(`{"sub": "device-id-2"}`),
+			},
+		},
+		{
+			// substate too long
+			InputBodyObject: &report{
+				Status: "installing",
+				// 202 chars
+				SubState: "ppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppff",
+			},
+			InputModelDeploymentID: "f826484e-1157-4109-af21-304e6d711561",
+			InputModelDeviceID:     "device-id-2",
+			InputModelStatus:       nil,
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("SubState: ppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppff does not validate as length(0|200);")),
+			},
+			Headers: map[string]string{
+				"Authorization": makeDeviceAuthHeader(`{"sub": "device-id-2"}`),
+			},
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+
+			deploymentModel := new(mocks.DeploymentsModel)
+
+			if testCase.InputModelStatus != nil {
+				deploymentModel.On("UpdateDeviceDeploymentStatus",
+					h.ContextMatcher(),
+					testCase.InputModelDeploymentID,
+					testCase.InputModelDeviceID,
+					*testCase.InputModelStatus).
+					Return(testCase.InputModelError)
+			}
+
+			router, err := rest.MakeRouter(
+				rest.Post("/r/:id",
+					NewDeploymentsController(deploymentModel,
+						new(view.DeploymentsView)).PutDeploymentStatusForDevice))
+			assert.NoError(t, err)
+
+			api := makeApi(router)
+
+			req := test.MakeSimpleRequest("POST", "http://localhost/r/"+testCase.InputModelDeploymentID,
+				testCase.InputBodyObject)
+			for k, v := range testCase.Headers {
+				req.Header.Set(k, v)
+			}
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
+
+			deploymentModel.AssertExpectations(t)
+		})
+	}
+}
+
+func TestControllerPutDeploymentStatusesForDevices(t *testing.T) {
+
+	t.Parallel()
+
+	updates := []deployments.DeviceStatusUpdate{
+		{
+			DeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			DeviceID:     "device-1",
+			Status:       deployments.DeviceDeploymentStatusSuccess,
+		},
+		{
+			DeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			DeviceID:     "device-2",
+			Status:       deployments.DeviceDeploymentStatusSuccess,
+		},
+	}
+	results := []deployments.DeviceStatusUpdateResult{
+		{
+			DeploymentID: updates[0].DeploymentID,
+			DeviceID:     updates[0].DeviceID,
+			Result:       deployments.StatusUpdateApplied,
+		},
+		{
+			DeploymentID: updates[1].DeploymentID,
+			DeviceID:     updates[1].DeviceID,
+			Result:       deployments.StatusUpdateRejected,
+			Error:        ErrDeploymentAborted.Error(),
+		},
+	}
+
+	testCases := map[string]struct {
+		h.JSONResponseParams
+
+		InputBodyObject interface{}
+
+		InputModelUpdates []deployments.DeviceStatusUpdate
+		InputModelResults []deployments.DeviceStatusUpdateResult
+		InputModelError   error
+	}{
+		"ok": {
+			InputBodyObject:   map[string]interface{}{"updates": updates},
+			InputModelUpdates: updates,
+			InputModelResults: results,
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: results,
+			},
+		},
+		"no body": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(
+					errors.New("Malformed request body: JSON payload is empty")),
+			},
+		},
+		"no updates": {
+			InputBodyObject: map[string]interface{}{
+				"updates": []deployments.DeviceStatusUpdate{},
+			},
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrInvalidStatusUpdates),
 			},
 		},
-		{
-			// substate too long
-			InputBodyObject: &report{
-				Status: "installing",
-				// 202 chars
-				SubState: "ppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppff",
+		"too many updates": {
+			InputBodyObject: map[string]interface{}{
+				"updates": make([]deployments.DeviceStatusUpdate, MaxDeviceStatusUpdates+1),
 			},
-			InputModelDeploymentID: "f826484e-1157-4109-af21-304e6d711561",
-			InputModelDeviceID:     "device-id-2",
-			InputModelStatus:       nil,
 
 			JSONResponseParams: h.JSONResponseParams{
 				OutputStatus:     http.StatusBadRequest,
-				OutputBodyObject: h.ErrorToErrStruct(errors.New("SubState: ppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppppff does not validate as length(0|200);")),
+				OutputBodyObject: h.ErrorToErrStruct(ErrInvalidStatusUpdates),
 			},
-			Headers: map[string]string{
-				"Authorization": makeDeviceAuthHeader(`{"sub": "device-id-2"}`),
+		},
+		"storage issue": {
+			InputBodyObject:   map[string]interface{}{"updates": updates},
+			InputModelUpdates: updates,
+			InputModelError:   errors.New("storage issue"),
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
 			},
 		},
 	}
 
-	for testCaseNumber, testCase := range testCases {
+	for name, testCase := range testCases {
 
-		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+		t.Run(name, func(t *testing.T) {
 
 			deploymentModel := new(mocks.DeploymentsModel)
-
-			if testCase.InputModelStatus != nil {
-				deploymentModel.On("UpdateDeviceDeploymentStatus",
-					h.ContextMatcher(),
-					testCase.InputModelDeploymentID,
-					testCase.InputModelDeviceID,
-					*testCase.InputModelStatus).
-					Return(testCase.InputModelError)
+			if testCase.InputModelUpdates != nil {
+				deploymentModel.On("UpdateDeviceDeploymentStatuses",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						id := identity.FromContext(ctx)
+						return id != nil && id.Tenant == "tenant"
+					}), testCase.InputModelUpdates).
+					Return(testCase.InputModelResults, testCase.InputModelError)
 			}
 
 			router, err := rest.MakeRouter(
-				rest.Post("/r/:id",
+				rest.Post("/r/:tenant",
 					NewDeploymentsController(deploymentModel,
-						new(view.DeploymentsView)).PutDeploymentStatusForDevice))
+						new(view.DeploymentsView)).PutDeploymentStatusesForDevices))
+
 			assert.NoError(t, err)
 
 			api := makeApi(router)
 
-			req := test.MakeSimpleRequest("POST", "http://localhost/r/"+testCase.InputModelDeploymentID,
+			req := test.MakeSimpleRequest("POST", "http://localhost/r/tenant",
 				testCase.InputBodyObject)
-			for k, v := range testCase.Headers {
-				req.Header.Set(k, v)
-			}
 			req.Header.Add(requestid.RequestIdHeader, "test")
 			recorded := test.RunRequest(t, api.MakeHandler(), req)
 
 			h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
-
 			deploymentModel.AssertExpectations(t)
 		})
 	}
@@ -719,6 +1392,211 @@ func TestControllerGetDeploymentStats(t *testing.T) {
 	}
 }
 
+func TestControllerGetDeploymentsStats(t *testing.T) {
+
+	t.Parallel()
+
+	ids := []string{
+		"30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+		"ee13ea8b-a6d3-4d4c-99a6-bcfcaebc7ec3",
+	}
+	lookup := &deployments.DeploymentsStatsLookup{
+		Deployments: []deployments.DeploymentStats{
+			{DeploymentID: ids[0], Stats: deployments.Stats{
+				deployments.DeviceDeploymentStatusSuccess: 3,
+			}},
+		},
+		Missing: []string{ids[1]},
+	}
+
+	testCases := map[string]struct {
+		h.JSONResponseParams
+
+		InputBodyObject interface{}
+
+		InputModelIDs    []string
+		InputModelLookup *deployments.DeploymentsStatsLookup
+		InputModelError  error
+	}{
+		"ok, with missing": {
+			InputBodyObject:  map[string]interface{}{"ids": ids},
+			InputModelIDs:    ids,
+			InputModelLookup: lookup,
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: lookup,
+			},
+		},
+		"no body": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(
+					errors.New("Malformed request body: JSON payload is empty")),
+			},
+		},
+		"no ids": {
+			InputBodyObject: map[string]interface{}{"ids": []string{}},
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("Invalid ID list: empty")),
+			},
+		},
+		"invalid id": {
+			InputBodyObject: map[string]interface{}{
+				"ids": []string{ids[0], "deployment"},
+			},
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(
+					errors.New("Invalid ID list: IDs not UUIDv4: deployment")),
+			},
+		},
+		"storage issue": {
+			InputBodyObject: map[string]interface{}{"ids": ids},
+			InputModelIDs:   ids,
+			InputModelError: errors.New("storage issue"),
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+			},
+		},
+	}
+
+	for name, testCase := range testCases {
+
+		t.Run(name, func(t *testing.T) {
+
+			deploymentModel := new(mocks.DeploymentsModel)
+			if testCase.InputModelIDs != nil {
+				deploymentModel.On("GetDeploymentsStats",
+					h.ContextMatcher(), testCase.InputModelIDs).
+					Return(testCase.InputModelLookup, testCase.InputModelError)
+			}
+
+			router, err := rest.MakeRouter(
+				rest.Post("/r",
+					NewDeploymentsController(deploymentModel,
+						new(view.DeploymentsView)).GetDeploymentsStats))
+
+			assert.NoError(t, err)
+
+			api := makeApi(router)
+
+			req := test.MakeSimpleRequest("POST", "http://localhost/r",
+				testCase.InputBodyObject)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
+			deploymentModel.AssertExpectations(t)
+		})
+	}
+}
+
+func TestControllerGetArtifactStats(t *testing.T) {
+
+	t.Parallel()
+
+	after := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		h.JSONResponseParams
+
+		InputQuery string
+
+		InputModelQuery *deployments.ArtifactStatsQuery
+		InputModelStats []deployments.ArtifactStats
+		InputModelError error
+	}{
+		"all": {
+			InputModelQuery: &deployments.ArtifactStatsQuery{},
+			InputModelStats: []deployments.ArtifactStats{
+				{ArtifactName: "app-1", Success: 12, Failure: 2},
+			},
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusOK,
+				OutputBodyObject: []deployments.ArtifactStats{
+					{ArtifactName: "app-1", Success: 12, Failure: 2},
+				},
+			},
+		},
+		"time range": {
+			InputQuery: "?finished_after=2018-03-01T00:00:00Z&finished_before=2018-04-01T00:00:00Z",
+			InputModelQuery: &deployments.ArtifactStatsQuery{
+				FinishedAfter:  after,
+				FinishedBefore: before,
+			},
+			InputModelStats: []deployments.ArtifactStats{},
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: []deployments.ArtifactStats{},
+			},
+		},
+		"invalid time": {
+			InputQuery: "?finished_after=yesterday",
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrInvalidFinishedRange),
+			},
+		},
+		"reversed time range": {
+			InputQuery: "?finished_after=2018-04-01T00:00:00Z&finished_before=2018-03-01T00:00:00Z",
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrInvalidFinishedRange),
+			},
+		},
+		"storage issue": {
+			InputModelQuery: &deployments.ArtifactStatsQuery{},
+			InputModelError: errors.New("storage issue"),
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+			},
+		},
+	}
+
+	for name, testCase := range testCases {
+
+		t.Run(name, func(t *testing.T) {
+
+			deploymentModel := new(mocks.DeploymentsModel)
+			if testCase.InputModelQuery != nil {
+				deploymentModel.On("GetArtifactStats",
+					h.ContextMatcher(), *testCase.InputModelQuery).
+					Return(testCase.InputModelStats, testCase.InputModelError)
+			}
+
+			router, err := rest.MakeRouter(
+				rest.Get("/r",
+					NewDeploymentsController(deploymentModel,
+						new(view.DeploymentsView)).GetArtifactStats))
+
+			assert.NoError(t, err)
+
+			api := makeApi(router)
+
+			req := test.MakeSimpleRequest("GET", "http://localhost/r"+testCase.InputQuery,
+				nil)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
+			deploymentModel.AssertExpectations(t)
+		})
+	}
+}
+
 func TestControllerGetDeviceStatusesForDeployment(t *testing.T) {
 	t.Parallel()
 
@@ -802,6 +1680,147 @@ func TestControllerGetDeviceStatusesForDeployment(t *testing.T) {
 	}
 }
 
+func TestControllerGetDevicesListForDeployment(t *testing.T) {
+	t.Parallel()
+
+	statuses := []deployments.DeviceDeployment{
+		*deployments.NewDeviceDeployment("device0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"),
+		*deployments.NewDeviceDeployment("device0002", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"),
+		*deployments.NewDeviceDeployment("device0003", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"),
+	}
+
+	testCases := map[string]struct {
+		h.JSONResponseParams
+
+		deploymentID string
+		queryString  string
+
+		modelQuery    *deployments.ListQuery
+		modelStatuses []deployments.DeviceDeployment
+		modelErr      error
+
+		links []string
+	}{
+		"ok, first page": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: statuses[:2],
+			},
+			deploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			queryString:  "?per_page=2",
+			modelQuery: &deployments.ListQuery{
+				DeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Limit:        3,
+			},
+			modelStatuses: statuses,
+			links: []string{
+				"<http://localhost/r/30b3e62c-9ec2-4312-a7fa-cff24cc7397a?page=2&per_page=2>; rel=\"next\"",
+				"<http://localhost/r/30b3e62c-9ec2-4312-a7fa-cff24cc7397a?page=1&per_page=2>; rel=\"first\"",
+			},
+		},
+		"ok, filtered by status, last page": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: statuses[2:],
+			},
+			deploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			queryString:  "?status=failure&page=2&per_page=2",
+			modelQuery: &deployments.ListQuery{
+				DeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Status:       deployments.DeviceDeploymentStatusFailure,
+				Skip:         2,
+				Limit:        3,
+			},
+			modelStatuses: statuses[2:],
+			links: []string{
+				"<http://localhost/r/30b3e62c-9ec2-4312-a7fa-cff24cc7397a?page=1&per_page=2&status=failure>; rel=\"prev\"",
+				"<http://localhost/r/30b3e62c-9ec2-4312-a7fa-cff24cc7397a?page=1&per_page=2&status=failure>; rel=\"first\"",
+			},
+		},
+		"deployment ID format error": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("ID is not UUIDv4")),
+			},
+			deploymentID: "30b3e62c9ec24312a7facff24cc7397a",
+		},
+		"unknown status": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("unknown status badstatus")),
+			},
+			deploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			queryString:  "?status=badstatus",
+		},
+		"invalid pagination": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("Can't parse param page")),
+			},
+			deploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			queryString:  "?page=foo",
+		},
+		"model error: deployment doesn't exist": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusNotFound,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("Deployment not found")),
+			},
+			deploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			modelQuery: &deployments.ListQuery{
+				DeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Limit:        21,
+			},
+			modelErr: ErrModelDeploymentNotFound,
+		},
+		"unknown model error": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+			},
+			deploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			modelQuery: &deployments.ListQuery{
+				DeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Limit:        21,
+			},
+			modelErr: errors.New("some unknown error"),
+		},
+	}
+
+	for caseName, tc := range testCases {
+
+		t.Run(caseName, func(t *testing.T) {
+
+			deploymentModel := new(mocks.DeploymentsModel)
+			if tc.modelQuery != nil {
+				deploymentModel.On("GetDevicesListForDeployment",
+					h.ContextMatcher(), *tc.modelQuery).
+					Return(tc.modelStatuses, tc.modelErr)
+			}
+
+			router, err := rest.MakeRouter(
+				rest.Get("/r/:id",
+					NewDeploymentsController(deploymentModel,
+						new(view.DeploymentsView)).GetDevicesListForDeployment))
+
+			assert.NoError(t, err)
+
+			api := makeApi(router)
+
+			req := test.MakeSimpleRequest("GET",
+				"http://localhost/r/"+tc.deploymentID+tc.queryString, nil)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, tc.JSONResponseParams)
+			if tc.links != nil {
+				assert.Equal(t, tc.links, recorded.Recorder.HeaderMap["Link"])
+			}
+
+			deploymentModel.AssertExpectations(t)
+		})
+	}
+}
+
 func TestControllerLookupDeployment(t *testing.T) {
 
 	t.Parallel()
@@ -975,6 +1994,210 @@ func TestControllerLookupDeployment(t *testing.T) {
 	}
 }
 
+func TestControllerListDeploymentsForArtifact(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2018, 5, 3, 10, 0, 0, 0, time.UTC)
+	cursor := (&restutil.Cursor{
+		Time: &created,
+		ID:   "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+	}).Encode()
+
+	someDeployments := []*deployments.Deployment{
+		{
+			DeploymentConstructor: &deployments.DeploymentConstructor{
+				Name:         StringToPointer("zen"),
+				ArtifactName: StringToPointer("baz"),
+				Devices:      []string{"b532b01a-9313-404f-8d19-e7fcbe5cc347"},
+			},
+			Id:        StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+			Artifacts: []string{"30b3e62c-9ec2-4312-a7fa-cff24cc7397a"},
+			Created:   &created,
+		},
+		{
+			DeploymentConstructor: &deployments.DeploymentConstructor{
+				Name:         StringToPointer("foo"),
+				ArtifactName: StringToPointer("baz"),
+				Devices:      []string{"b532b01a-9313-404f-8d19-e7fcbe5cc347"},
+			},
+			Id:        StringToPointer("e8c32ff6-7c1b-43c7-aa31-2e4fc3a3c130"),
+			Artifacts: []string{"30b3e62c-9ec2-4312-a7fa-cff24cc7397a"},
+			Created:   &created,
+		},
+	}
+
+	testCases := map[string]struct {
+		h.JSONResponseParams
+
+		artifactID  string
+		queryString string
+
+		modelQuery       *deployments.Query
+		modelDeployments []*deployments.Deployment
+		modelErr         error
+
+		links []string
+	}{
+		"ok, first page": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: someDeployments[:1],
+			},
+			artifactID:  "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			queryString: "?per_page=1",
+			modelQuery: &deployments.Query{
+				ArtifactID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Limit:      2,
+			},
+			modelDeployments: someDeployments,
+			links: []string{
+				"<http://localhost/r/30b3e62c-9ec2-4312-a7fa-cff24cc7397a?page=2&per_page=1>; rel=\"next\"",
+				"<http://localhost/r/30b3e62c-9ec2-4312-a7fa-cff24cc7397a?page=1&per_page=1>; rel=\"first\"",
+			},
+		},
+		"ok, filtered by status, last page": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: someDeployments[1:],
+			},
+			artifactID:  "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			queryString: "?status=finished&page=2&per_page=1",
+			modelQuery: &deployments.Query{
+				ArtifactID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Status:     deployments.StatusQueryFinished,
+				Skip:       1,
+				Limit:      2,
+			},
+			modelDeployments: someDeployments[1:],
+			links: []string{
+				"<http://localhost/r/30b3e62c-9ec2-4312-a7fa-cff24cc7397a?page=1&per_page=1&status=finished>; rel=\"prev\"",
+				"<http://localhost/r/30b3e62c-9ec2-4312-a7fa-cff24cc7397a?page=1&per_page=1&status=finished>; rel=\"first\"",
+			},
+		},
+		"ok, cursor, first page": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: someDeployments[:1],
+			},
+			artifactID:  "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			queryString: "?cursor=&per_page=1",
+			modelQuery: &deployments.Query{
+				ArtifactID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Limit:      2,
+			},
+			modelDeployments: someDeployments,
+			links: []string{
+				"<http://localhost/r/30b3e62c-9ec2-4312-a7fa-cff24cc7397a?cursor=" +
+					cursor + "&per_page=1>; rel=\"next\"",
+			},
+		},
+		"ok, cursor, last page": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: someDeployments[1:],
+			},
+			artifactID:  "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			queryString: "?cursor=" + cursor + "&per_page=1",
+			modelQuery: &deployments.Query{
+				ArtifactID:   "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				AfterCreated: &created,
+				AfterID:      "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+				Limit:        2,
+			},
+			modelDeployments: someDeployments[1:],
+		},
+		"invalid cursor": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(restutil.ErrInvalidCursor),
+			},
+			artifactID:  "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			queryString: "?cursor=foo",
+		},
+		"ok, no deployments": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: []*deployments.Deployment{},
+			},
+			artifactID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			modelQuery: &deployments.Query{
+				ArtifactID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Limit:      21,
+			},
+			modelDeployments: []*deployments.Deployment{},
+		},
+		"artifact ID format error": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("ID is not UUIDv4")),
+			},
+			artifactID: "30b3e62c9ec24312a7facff24cc7397a",
+		},
+		"unknown status": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("unknown status badstatus")),
+			},
+			artifactID:  "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			queryString: "?status=badstatus",
+		},
+		"invalid pagination": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("Can't parse param page")),
+			},
+			artifactID:  "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			queryString: "?page=foo",
+		},
+		"model error": {
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+			},
+			artifactID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			modelQuery: &deployments.Query{
+				ArtifactID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Limit:      21,
+			},
+			modelErr: errors.New("some unknown error"),
+		},
+	}
+
+	for caseName, tc := range testCases {
+
+		t.Run(caseName, func(t *testing.T) {
+
+			deploymentModel := new(mocks.DeploymentsModel)
+			if tc.modelQuery != nil {
+				deploymentModel.On("LookupDeployment",
+					h.ContextMatcher(), *tc.modelQuery).
+					Return(tc.modelDeployments, tc.modelErr)
+			}
+
+			router, err := rest.MakeRouter(
+				rest.Get("/r/:id",
+					NewDeploymentsController(deploymentModel,
+						new(view.DeploymentsView)).ListDeploymentsForArtifact))
+
+			assert.NoError(t, err)
+
+			api := makeApi(router)
+
+			req := test.MakeSimpleRequest("GET",
+				"http://localhost/r/"+tc.artifactID+tc.queryString, nil)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, tc.JSONResponseParams)
+			if tc.links != nil {
+				assert.Equal(t, tc.links, recorded.Recorder.HeaderMap["Link"])
+			}
+
+			deploymentModel.AssertExpectations(t)
+		})
+	}
+}
+
 func TestParseLookupQuery(t *testing.T) {
 	testCases := []struct {
 		vals  url.Values
@@ -1099,7 +2322,7 @@ func TestControllerPutDeploymentLog(t *testing.T) {
 
 			JSONResponseParams: h.JSONResponseParams{
 				OutputStatus:     http.StatusBadRequest,
-				OutputBodyObject: h.ErrorToErrStruct(errors.New("JSON payload is empty")),
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("Malformed request body: JSON payload is empty")),
 			},
 			Headers: map[string]string{
 				"Authorization": makeDeviceAuthHeader(`{"sub": "device-id-1"}`),
@@ -1368,7 +2591,7 @@ func TestControllerAbortDeployment(t *testing.T) {
 
 			JSONResponseParams: h.JSONResponseParams{
 				OutputStatus:     http.StatusBadRequest,
-				OutputBodyObject: h.ErrorToErrStruct(errors.New("JSON payload is empty")),
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("Malformed request body: JSON payload is empty")),
 			},
 		},
 		{
@@ -1454,6 +2677,195 @@ func TestControllerAbortDeployment(t *testing.T) {
 	}
 }
 
+func TestControllerPromoteDeployment(t *testing.T) {
+
+	t.Parallel()
+
+	testCases := []struct {
+		h.JSONResponseParams
+
+		InputDeploymentID string
+		InputModelError   error
+	}{
+		{
+			InputDeploymentID: "not-a-uuid",
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrIDNotUUIDv4),
+			},
+		},
+		{
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+			InputModelError:   ErrModelDeploymentNotFound,
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusNotFound,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("Resource not found")),
+			},
+		},
+		{
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+			InputModelError:   ErrDeploymentNotCanary,
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusConflict,
+				OutputBodyObject: h.ErrorToErrStruct(ErrDeploymentNotCanary),
+			},
+		},
+		{
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+			InputModelError:   ErrDeploymentHalted,
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusConflict,
+				OutputBodyObject: h.ErrorToErrStruct(ErrDeploymentHalted),
+			},
+		},
+		{
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+			InputModelError:   ErrDeploymentAlreadyFinished,
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusUnprocessableEntity,
+				OutputBodyObject: h.ErrorToErrStruct(ErrDeploymentAlreadyFinished),
+			},
+		},
+		{
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+			InputModelError:   errors.New("storage error"),
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+			},
+		},
+		{
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusNoContent,
+			},
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+
+			deploymentModel := new(mocks.DeploymentsModel)
+			deploymentModel.On("PromoteDeployment",
+				h.ContextMatcher(), testCase.InputDeploymentID).
+				Return(testCase.InputModelError)
+
+			router, err := rest.MakeRouter(
+				rest.Post("/r/:id/promote",
+					NewDeploymentsController(deploymentModel,
+						new(view.DeploymentsView)).PromoteDeployment))
+			assert.NoError(t, err)
+
+			api := makeApi(router)
+
+			req := test.MakeSimpleRequest("POST",
+				"http://localhost/r/"+testCase.InputDeploymentID+"/promote", nil)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
+		})
+	}
+}
+
+func TestControllerHaltDeployment(t *testing.T) {
+
+	t.Parallel()
+
+	testCases := []struct {
+		h.JSONResponseParams
+
+		InputDeploymentID string
+		InputModelError   error
+	}{
+		{
+			InputDeploymentID: "not-a-uuid",
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrIDNotUUIDv4),
+			},
+		},
+		{
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+			InputModelError:   ErrModelDeploymentNotFound,
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusNotFound,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("Resource not found")),
+			},
+		},
+		{
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+			InputModelError:   ErrDeploymentPromoted,
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusConflict,
+				OutputBodyObject: h.ErrorToErrStruct(ErrDeploymentPromoted),
+			},
+		},
+		{
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+			InputModelError:   ErrDeploymentAlreadyFinished,
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusUnprocessableEntity,
+				OutputBodyObject: h.ErrorToErrStruct(ErrDeploymentAlreadyFinished),
+			},
+		},
+		{
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+			InputModelError:   errors.New("storage error"),
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+			},
+		},
+		{
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusNoContent,
+			},
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+
+			deploymentModel := new(mocks.DeploymentsModel)
+			deploymentModel.On("HaltDeployment",
+				h.ContextMatcher(), testCase.InputDeploymentID).
+				Return(testCase.InputModelError)
+
+			router, err := rest.MakeRouter(
+				rest.Post("/r/:id/halt",
+					NewDeploymentsController(deploymentModel,
+						new(view.DeploymentsView)).HaltDeployment))
+			assert.NoError(t, err)
+
+			api := makeApi(router)
+
+			req := test.MakeSimpleRequest("POST",
+				"http://localhost/r/"+testCase.InputDeploymentID+"/halt", nil)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
+		})
+	}
+}
+
 func TestControllerDecommissionDevice(t *testing.T) {
 
 	t.Parallel()
@@ -36,19 +36,36 @@ var (
 // Domain model for deployment
 type DeploymentsModel interface {
 	CreateDeployment(ctx context.Context,
-		constructor *deployments.DeploymentConstructor) (string, error)
+		constructor *deployments.DeploymentConstructor) (*deployments.DeploymentCreated, error)
+	CreateDeploymentWithIdempotencyKey(ctx context.Context,
+		constructor *deployments.DeploymentConstructor,
+		key string) (*deployments.Deployment, *deployments.DeploymentCreated, error)
+	PlanDeployment(ctx context.Context,
+		constructor *deployments.DeploymentConstructor) (*deployments.DeploymentPlan, error)
 	GetDeployment(ctx context.Context, deploymentID string) (*deployments.Deployment, error)
 	IsDeploymentFinished(ctx context.Context, deploymentID string) (bool, error)
 	AbortDeployment(ctx context.Context, deploymentID string) error
+	PromoteDeployment(ctx context.Context, deploymentID string) error
+	HaltDeployment(ctx context.Context, deploymentID string) error
 	GetDeploymentStats(ctx context.Context, deploymentID string) (deployments.Stats, error)
+	GetDeploymentsStats(ctx context.Context,
+		deploymentIDs []string) (*deployments.DeploymentsStatsLookup, error)
+	GetArtifactStats(ctx context.Context,
+		query deployments.ArtifactStatsQuery) ([]deployments.ArtifactStats, error)
 	GetDeploymentForDeviceWithCurrent(ctx context.Context, deviceID string,
 		current deployments.InstalledDeviceDeployment) (*deployments.DeploymentInstructions, error)
+	PreviewDeploymentForDevice(ctx context.Context, deviceID string,
+		current deployments.InstalledDeviceDeployment) (*deployments.DeploymentPreview, error)
 	HasDeploymentForDevice(ctx context.Context, deploymentID string,
 		deviceID string) (bool, error)
 	UpdateDeviceDeploymentStatus(ctx context.Context, deploymentID string,
 		deviceID string, status deployments.DeviceDeploymentStatus) error
+	UpdateDeviceDeploymentStatuses(ctx context.Context,
+		updates []deployments.DeviceStatusUpdate) ([]deployments.DeviceStatusUpdateResult, error)
 	GetDeviceStatusesForDeployment(ctx context.Context,
 		deploymentID string) ([]deployments.DeviceDeployment, error)
+	GetDevicesListForDeployment(ctx context.Context,
+		query deployments.ListQuery) ([]deployments.DeviceDeployment, error)
 	LookupDeployment(ctx context.Context,
 		query deployments.Query) ([]*deployments.Deployment, error)
 	SaveDeviceDeploymentLog(ctx context.Context, deviceID string,
@@ -39,14 +39,16 @@ func (_m *DeploymentsModel) AbortDeployment(ctx context.Context, deploymentID st
 }
 
 // CreateDeployment provides a mock function with given fields: ctx, constructor
-func (_m *DeploymentsModel) CreateDeployment(ctx context.Context, constructor *deployments.DeploymentConstructor) (string, error) {
+func (_m *DeploymentsModel) CreateDeployment(ctx context.Context, constructor *deployments.DeploymentConstructor) (*deployments.DeploymentCreated, error) {
 	ret := _m.Called(ctx, constructor)
 
-	var r0 string
-	if rf, ok := ret.Get(0).(func(context.Context, *deployments.DeploymentConstructor) string); ok {
+	var r0 *deployments.DeploymentCreated
+	if rf, ok := ret.Get(0).(func(context.Context, *deployments.DeploymentConstructor) *deployments.DeploymentCreated); ok {
 		r0 = rf(ctx, constructor)
 	} else {
-		r0 = ret.Get(0).(string)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*deployments.DeploymentCreated)
+		}
 	}
 
 	var r1 error
@@ -59,6 +61,38 @@ func (_m *DeploymentsModel) CreateDeployment(ctx context.Context, constructor *d
 	return r0, r1
 }
 
+// CreateDeploymentWithIdempotencyKey provides a mock function with given fields: ctx, constructor, key
+func (_m *DeploymentsModel) CreateDeploymentWithIdempotencyKey(ctx context.Context, constructor *deployments.DeploymentConstructor, key string) (*deployments.Deployment, *deployments.DeploymentCreated, error) {
+	ret := _m.Called(ctx, constructor, key)
+
+	var r0 *deployments.Deployment
+	if rf, ok := ret.Get(0).(func(context.Context, *deployments.DeploymentConstructor, string) *deployments.Deployment); ok {
+		r0 = rf(ctx, constructor, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*deployments.Deployment)
+		}
+	}
+
+	var r1 *deployments.DeploymentCreated
+	if rf, ok := ret.Get(1).(func(context.Context, *deployments.DeploymentConstructor, string) *deployments.DeploymentCreated); ok {
+		r1 = rf(ctx, constructor, key)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*deployments.DeploymentCreated)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, *deployments.DeploymentConstructor, string) error); ok {
+		r2 = rf(ctx, constructor, key)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // DecommissionDevice provides a mock function with given fields: ctx, deviceID
 func (_m *DeploymentsModel) DecommissionDevice(ctx context.Context, deviceID string) error {
 	ret := _m.Called(ctx, deviceID)
@@ -73,6 +107,29 @@ func (_m *DeploymentsModel) DecommissionDevice(ctx context.Context, deviceID str
 	return r0
 }
 
+// GetArtifactStats provides a mock function with given fields: ctx, query
+func (_m *DeploymentsModel) GetArtifactStats(ctx context.Context, query deployments.ArtifactStatsQuery) ([]deployments.ArtifactStats, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []deployments.ArtifactStats
+	if rf, ok := ret.Get(0).(func(context.Context, deployments.ArtifactStatsQuery) []deployments.ArtifactStats); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]deployments.ArtifactStats)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, deployments.ArtifactStatsQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetDeployment provides a mock function with given fields: ctx, deploymentID
 func (_m *DeploymentsModel) GetDeployment(ctx context.Context, deploymentID string) (*deployments.Deployment, error) {
 	ret := _m.Called(ctx, deploymentID)
@@ -142,6 +199,29 @@ func (_m *DeploymentsModel) GetDeploymentStats(ctx context.Context, deploymentID
 	return r0, r1
 }
 
+// GetDeploymentsStats provides a mock function with given fields: ctx, deploymentIDs
+func (_m *DeploymentsModel) GetDeploymentsStats(ctx context.Context, deploymentIDs []string) (*deployments.DeploymentsStatsLookup, error) {
+	ret := _m.Called(ctx, deploymentIDs)
+
+	var r0 *deployments.DeploymentsStatsLookup
+	if rf, ok := ret.Get(0).(func(context.Context, []string) *deployments.DeploymentsStatsLookup); ok {
+		r0 = rf(ctx, deploymentIDs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*deployments.DeploymentsStatsLookup)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, deploymentIDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetDeviceDeploymentLog provides a mock function with given fields: ctx, deviceID, deploymentID
 func (_m *DeploymentsModel) GetDeviceDeploymentLog(ctx context.Context, deviceID string, deploymentID string) (*deployments.DeploymentLog, error) {
 	ret := _m.Called(ctx, deviceID, deploymentID)
@@ -188,6 +268,43 @@ func (_m *DeploymentsModel) GetDeviceStatusesForDeployment(ctx context.Context,
 	return r0, r1
 }
 
+// GetDevicesListForDeployment provides a mock function with given fields: ctx, query
+func (_m *DeploymentsModel) GetDevicesListForDeployment(ctx context.Context, query deployments.ListQuery) ([]deployments.DeviceDeployment, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []deployments.DeviceDeployment
+	if rf, ok := ret.Get(0).(func(context.Context, deployments.ListQuery) []deployments.DeviceDeployment); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]deployments.DeviceDeployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, deployments.ListQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// HaltDeployment provides a mock function with given fields: ctx, deploymentID
+func (_m *DeploymentsModel) HaltDeployment(ctx context.Context, deploymentID string) error {
+	ret := _m.Called(ctx, deploymentID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, deploymentID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // HasDeploymentForDevice provides a mock function with given fields: ctx, deploymentID, deviceID
 func (_m *DeploymentsModel) HasDeploymentForDevice(ctx context.Context, deploymentID string, deviceID string) (bool, error) {
 	ret := _m.Called(ctx, deploymentID, deviceID)
@@ -253,6 +370,66 @@ func (_m *DeploymentsModel) LookupDeployment(ctx context.Context, query deployme
 	return r0, r1
 }
 
+// PlanDeployment provides a mock function with given fields: ctx, constructor
+func (_m *DeploymentsModel) PlanDeployment(ctx context.Context, constructor *deployments.DeploymentConstructor) (*deployments.DeploymentPlan, error) {
+	ret := _m.Called(ctx, constructor)
+
+	var r0 *deployments.DeploymentPlan
+	if rf, ok := ret.Get(0).(func(context.Context, *deployments.DeploymentConstructor) *deployments.DeploymentPlan); ok {
+		r0 = rf(ctx, constructor)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*deployments.DeploymentPlan)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *deployments.DeploymentConstructor) error); ok {
+		r1 = rf(ctx, constructor)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PreviewDeploymentForDevice provides a mock function with given fields: ctx, deviceID, current
+func (_m *DeploymentsModel) PreviewDeploymentForDevice(ctx context.Context, deviceID string, current deployments.InstalledDeviceDeployment) (*deployments.DeploymentPreview, error) {
+	ret := _m.Called(ctx, deviceID, current)
+
+	var r0 *deployments.DeploymentPreview
+	if rf, ok := ret.Get(0).(func(context.Context, string, deployments.InstalledDeviceDeployment) *deployments.DeploymentPreview); ok {
+		r0 = rf(ctx, deviceID, current)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*deployments.DeploymentPreview)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, deployments.InstalledDeviceDeployment) error); ok {
+		r1 = rf(ctx, deviceID, current)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PromoteDeployment provides a mock function with given fields: ctx, deploymentID
+func (_m *DeploymentsModel) PromoteDeployment(ctx context.Context, deploymentID string) error {
+	ret := _m.Called(ctx, deploymentID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, deploymentID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SaveDeviceDeploymentLog provides a mock function with given fields: ctx, deviceID, deploymentID, logs
 func (_m *DeploymentsModel) SaveDeviceDeploymentLog(ctx context.Context, deviceID string, deploymentID string, logs []deployments.LogMessage) error {
 	ret := _m.Called(ctx, deviceID, deploymentID, logs)
@@ -281,4 +458,27 @@ func (_m *DeploymentsModel) UpdateDeviceDeploymentStatus(ctx context.Context, de
 	return r0
 }
 
+// UpdateDeviceDeploymentStatuses provides a mock function with given fields: ctx, updates
+func (_m *DeploymentsModel) UpdateDeviceDeploymentStatuses(ctx context.Context, updates []deployments.DeviceStatusUpdate) ([]deployments.DeviceStatusUpdateResult, error) {
+	ret := _m.Called(ctx, updates)
+
+	var r0 []deployments.DeviceStatusUpdateResult
+	if rf, ok := ret.Get(0).(func(context.Context, []deployments.DeviceStatusUpdate) []deployments.DeviceStatusUpdateResult); ok {
+		r0 = rf(ctx, updates)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]deployments.DeviceStatusUpdateResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []deployments.DeviceStatusUpdate) error); ok {
+		r1 = rf(ctx, updates)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 var _ controller.DeploymentsModel = (*DeploymentsModel)(nil)
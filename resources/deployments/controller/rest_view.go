@@ -23,8 +23,8 @@ import (
 
 type RESTView interface {
 	RenderNoUpdateForDevice(w rest.ResponseWriter)
-	RenderSuccessPost(w rest.ResponseWriter, r *rest.Request, id string)
-	RenderSuccessGet(w rest.ResponseWriter, object interface{})
+	RenderSuccessPostObject(w rest.ResponseWriter, r *rest.Request, id string, object interface{})
+	RenderSuccessGet(w rest.ResponseWriter, r *rest.Request, object interface{})
 	RenderEmptySuccessResponse(w rest.ResponseWriter)
 	RenderError(w rest.ResponseWriter, r *rest.Request, err error, status int, l *log.Logger)
 	RenderInternalError(w rest.ResponseWriter, r *rest.Request, err error, l *log.Logger)
@@ -52,16 +52,7 @@ func (s *statusReport) UnmarshalJSON(raw []byte) error {
 		return err
 	}
 
-	valid := []string{
-		deployments.DeviceDeploymentStatusDownloading,
-		deployments.DeviceDeploymentStatusInstalling,
-		deployments.DeviceDeploymentStatusRebooting,
-		deployments.DeviceDeploymentStatusSuccess,
-		deployments.DeviceDeploymentStatusFailure,
-		deployments.DeviceDeploymentStatusAlreadyInst,
-	}
-
-	if !containsString(temp.Status, valid) {
+	if !containsString(temp.Status, deployments.DeviceReportedStatuses()) {
 		return ErrBadStatus
 	}
 
@@ -17,17 +17,41 @@ package deployments
 import (
 	"encoding/json"
 	"errors"
+	"net"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/satori/go.uuid"
+
+	"github.com/mendersoftware/deployments/resources/images"
 )
 
 // Errors
 var (
-	ErrInvalidDeviceID = errors.New("Invalid device ID")
+	ErrInvalidDeviceID    = errors.New("Invalid device ID")
+	ErrInvalidCallbackURL = errors.New("Invalid callback URL, expected absolute http(s) URL")
+	ErrPrivateCallbackURL = errors.New(
+		"Invalid callback URL, loopback, link-local and private addresses are not allowed")
+
+	// ErrDuplicateIdempotencyKey is returned by the storage when
+	// a deployment with the same idempotency key already exists
+	ErrDuplicateIdempotencyKey = errors.New("Deployment with the idempotency key already exists")
 )
 
+// AllowPrivateCallbacks lets the callback URLs point at the loopback,
+// link-local and private addresses, configurable on startup. Such URLs are
+// rejected by default, so that the callbacks can't reach internal services.
+var AllowPrivateCallbacks = false
+
+// IsPrivateAddress checks if the address is the loopback, link-local,
+// private or unspecified one.
+func IsPrivateAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified()
+}
+
 // DeploymentConstructor represent input data needed for creating new Deployment (they differ in fields)
 type DeploymentConstructor struct {
 	// Deployment name, required
@@ -38,6 +62,15 @@ type DeploymentConstructor struct {
 
 	// List of device id's targeted for deployments, required
 	Devices []string `json:"devices,omitempty" valid:"required" bson:"-"`
+
+	// Install the artifact also on the devices which already have it installed, optional
+	Force bool `json:"force,omitempty"`
+
+	// URL notified with the deployment summary once the deployment is finished, optional
+	CallbackURL *string `json:"callback_url,omitempty" valid:"length(1|4096),optional"`
+
+	// Devices the deployment is rolled out to first, until it is promoted, optional
+	Canary *CanarySpec `json:"canary,omitempty" bson:"canary,omitempty" valid:"-"`
 }
 
 func NewDeploymentConstructor() *DeploymentConstructor {
@@ -51,12 +84,34 @@ func (c *DeploymentConstructor) Validate() error {
 		return err
 	}
 
+	if err := images.ValidateNamePattern("artifact_name", *c.ArtifactName); err != nil {
+		return err
+	}
+
 	for _, id := range c.Devices {
 		if govalidator.IsNull(id) {
 			return ErrInvalidDeviceID
 		}
 	}
 
+	if c.CallbackURL != nil {
+		u, err := url.Parse(*c.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidCallbackURL
+		}
+		// host names resolving to private addresses are refused on delivery
+		host := strings.ToLower(u.Hostname())
+		ip := net.ParseIP(host)
+		if !AllowPrivateCallbacks && (host == "localhost" ||
+			strings.HasSuffix(host, ".localhost") || (ip != nil && IsPrivateAddress(ip))) {
+			return ErrPrivateCallbackURL
+		}
+	}
+
+	if c.Canary != nil {
+		return c.Canary.Validate()
+	}
+
 	return nil
 }
 
@@ -70,6 +125,12 @@ type Deployment struct {
 	// Finished deplyment time
 	Finished *time.Time `json:"finished,omitempty" valid:"optional"`
 
+	// Time the canary deployment was promoted to all the devices
+	Promoted *time.Time `json:"promoted,omitempty" bson:"promoted,omitempty" valid:"optional"`
+
+	// Time the canary deployment was halted, aborting the held devices
+	Halted *time.Time `json:"halted,omitempty" bson:"halted,omitempty" valid:"optional"`
+
 	// Deployment id, required
 	Id *string `json:"id" bson:"_id" valid:"uuidv4,required"`
 
@@ -80,6 +141,10 @@ type Deployment struct {
 	// Initialized with the "pending" counter set to total device count for deployment.
 	// Individual counter incremented/decremented according to device status updates.
 	Stats map[string]int `json:"-"`
+
+	// Key provided by the client creating the deployment,
+	// used to recognize repeated creation requests, optional
+	IdempotencyKey string `json:"-" bson:"idempotency_key,omitempty"`
 }
 
 // NewDeployment creates new deployment object, sets create data by default.
@@ -129,6 +194,22 @@ func (d *Deployment) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&slim)
 }
 
+// IsAlreadyInstalled checks if the device reporting the installed artifact
+// name can skip the deployment. Forced deployments are never skipped.
+func (d *Deployment) IsAlreadyInstalled(installedArtifact string) bool {
+	if d.Force || installedArtifact == "" || d.ArtifactName == nil {
+		return false
+	}
+	return *d.ArtifactName == installedArtifact
+}
+
+// IsCanaryPending tells if the deployment is rolled out to the canary
+// devices only, until it is promoted or halted.
+func (d *Deployment) IsCanaryPending() bool {
+	return d.DeploymentConstructor != nil && d.Canary != nil &&
+		d.Promoted == nil && d.Halted == nil
+}
+
 func (d *Deployment) IsInProgress() bool {
 	active := []string{
 		DeviceDeploymentStatusRebooting,
@@ -216,6 +297,12 @@ type Query struct {
 	SearchText string
 	// deployment status
 	Status StatusQuery
-	Limit  int
-	Skip   int
+	// match deployments referencing given artifact ID
+	ArtifactID string
+	// match deployments following the given one in the list ordered by
+	// creation time, latest first, for the cursor pagination
+	AfterCreated *time.Time
+	AfterID      string
+	Limit        int
+	Skip         int
 }
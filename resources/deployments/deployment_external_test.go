@@ -16,12 +16,14 @@ package deployments_test
 
 import (
 	"math/rand"
+	"regexp"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	. "github.com/mendersoftware/deployments/resources/deployments"
+	"github.com/mendersoftware/deployments/resources/images"
 	. "github.com/mendersoftware/deployments/utils/pointers"
 )
 
@@ -33,6 +35,7 @@ func TestDeploymentConstructorValidate(t *testing.T) {
 		InputName         *string
 		InputArtifactName *string
 		InputDevices      []string
+		InputCallbackURL  *string
 		IsValid           bool
 	}{
 		{
@@ -83,6 +86,34 @@ func TestDeploymentConstructorValidate(t *testing.T) {
 			InputDevices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
 			IsValid:           true,
 		},
+		{
+			InputName:         StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName: StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputCallbackURL:  StringToPointer("https://ci.example.com/hooks/deployments?token=abc"),
+			IsValid:           true,
+		},
+		{
+			InputName:         StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName: StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputCallbackURL:  StringToPointer("ftp://ci.example.com/hooks"),
+			IsValid:           false,
+		},
+		{
+			InputName:         StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName: StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputCallbackURL:  StringToPointer("/hooks/deployments"),
+			IsValid:           false,
+		},
+		{
+			InputName:         StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName: StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputCallbackURL:  StringToPointer(""),
+			IsValid:           false,
+		},
 	}
 
 	for _, test := range testCases {
@@ -91,6 +122,7 @@ func TestDeploymentConstructorValidate(t *testing.T) {
 		dep.Name = test.InputName
 		dep.ArtifactName = test.InputArtifactName
 		dep.Devices = test.InputDevices
+		dep.CallbackURL = test.InputCallbackURL
 
 		err := dep.Validate()
 
@@ -103,6 +135,62 @@ func TestDeploymentConstructorValidate(t *testing.T) {
 
 }
 
+func TestDeploymentConstructorValidatePrivateCallbackURL(t *testing.T) {
+
+	dep := NewDeploymentConstructor()
+	dep.Name = StringToPointer("foo")
+	dep.ArtifactName = StringToPointer("bar")
+	dep.Devices = []string{"f826484e-1157-4109-af21-304e6d711560"}
+
+	callbackURLs := []string{
+		"http://localhost:8080/hooks",
+		"http://api.LOCALHOST/hooks",
+		"http://127.0.0.1/hooks",
+		"http://0.0.0.0/hooks",
+		"http://10.0.0.1/hooks",
+		"http://172.16.0.1/hooks",
+		"https://192.168.1.1/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hooks",
+		"http://[fe80::1]/hooks",
+		"http://[fd00::1]/hooks",
+		"http://[::ffff:127.0.0.1]/hooks",
+	}
+
+	for _, callbackURL := range callbackURLs {
+		dep.CallbackURL = StringToPointer(callbackURL)
+		assert.Equal(t, ErrPrivateCallbackURL, dep.Validate(), callbackURL)
+	}
+
+	dep.CallbackURL = StringToPointer("http://8.8.8.8/hooks")
+	assert.NoError(t, dep.Validate())
+
+	AllowPrivateCallbacks = true
+	defer func() { AllowPrivateCallbacks = false }()
+
+	for _, callbackURL := range callbackURLs {
+		dep.CallbackURL = StringToPointer(callbackURL)
+		assert.NoError(t, dep.Validate(), callbackURL)
+	}
+}
+
+func TestDeploymentConstructorValidateNamePattern(t *testing.T) {
+
+	images.NamePattern = regexp.MustCompile(`^product-\d+\.\d+\.\d+$`)
+	defer func() { images.NamePattern = nil }()
+
+	dep := NewDeploymentConstructor()
+	dep.Name = StringToPointer("production")
+	dep.Devices = []string{"lala"}
+
+	dep.ArtifactName = StringToPointer("product-1.2.3")
+	assert.NoError(t, dep.Validate())
+
+	dep.ArtifactName = StringToPointer("product-1.2")
+	assert.EqualError(t, dep.Validate(),
+		`Invalid artifact_name: expected to match pattern ^product-\d+\.\d+\.\d+$`)
+}
+
 func TestNewDeploymentFromConstructor(t *testing.T) {
 
 	t.Parallel()
@@ -257,6 +345,19 @@ func TestDeploymentIs(t *testing.T) {
 	}
 }
 
+func TestDeploymentIsAlreadyInstalled(t *testing.T) {
+	d := NewDeployment()
+	assert.False(t, d.IsAlreadyInstalled("foo"))
+
+	d.ArtifactName = StringToPointer("foo")
+	assert.True(t, d.IsAlreadyInstalled("foo"))
+	assert.False(t, d.IsAlreadyInstalled("bar"))
+	assert.False(t, d.IsAlreadyInstalled(""))
+
+	d.Force = true
+	assert.False(t, d.IsAlreadyInstalled("foo"))
+}
+
 func TestDeploymentGetStatus(t *testing.T) {
 
 	tests := map[string]struct {
@@ -26,3 +26,17 @@ type DeploymentInstructions struct {
 	ID       string                         `json:"id"`
 	Artifact ArtifactDeploymentInstructions `json:"artifact"`
 }
+
+// ArtifactPreview describes the artifact selected for the device
+type ArtifactPreview struct {
+	ID                    string   `json:"id"`
+	ArtifactName          string   `json:"artifact_name"`
+	DeviceTypesCompatible []string `json:"device_types_compatible"`
+}
+
+// DeploymentPreview describes what the device would receive on its next
+// update request, without the download link
+type DeploymentPreview struct {
+	ID       string          `json:"id"`
+	Artifact ArtifactPreview `json:"artifact"`
+}
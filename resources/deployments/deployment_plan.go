@@ -0,0 +1,72 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package deployments
+
+// DeploymentPlan is the outcome of a deployment creation dry run:
+// what would be created, without persisting anything.
+type DeploymentPlan struct {
+	// Deployment name
+	Name string `json:"name"`
+
+	// Artifact name to be installed
+	ArtifactName string `json:"artifact_name"`
+
+	// IDs of the artifacts matching the artifact name
+	Artifacts []string `json:"artifacts"`
+
+	// Devices the deployment would be created for
+	Devices []string `json:"devices"`
+
+	// Devices which would get 'noartifact' status:
+	// none of the artifacts supports the last device type they reported
+	Skipped []PlannedDevice `json:"skipped"`
+
+	// Devices which never reported their device type,
+	// compatibility will be known on their update request only
+	UnknownDeviceType []string `json:"unknown_device_type"`
+
+	// IDs of the artifacts marked as deprecated
+	DeprecatedArtifacts []string `json:"deprecated_artifacts"`
+
+	// Devices the canary deployment would be rolled out to first
+	Canary []string `json:"canary,omitempty"`
+}
+
+// PlannedDevice is a device targeted by the planned deployment
+type PlannedDevice struct {
+	// Device ID
+	DeviceId string `json:"id"`
+
+	// Device type last reported by the device
+	DeviceType string `json:"device_type"`
+}
+
+// DeploymentCreated is the outcome of a deployment creation
+type DeploymentCreated struct {
+	// Deployment ID
+	Id string `json:"id"`
+
+	// Devices which will get 'noartifact' status:
+	// none of the artifacts supports the last device type they reported
+	Warnings []PlannedDevice `json:"warnings"`
+
+	// IDs of the artifacts to be installed marked as deprecated,
+	// the deployment is created anyway unless blocked by the configuration
+	DeprecatedArtifacts []string `json:"deprecated_artifacts,omitempty"`
+
+	// Devices the canary deployment is rolled out to first,
+	// the other devices get it once the deployment is promoted
+	Canary []string `json:"canary,omitempty"`
+}
@@ -78,6 +78,10 @@ type DeviceDeployment struct {
 
 	// Device reported substate
 	SubState *string `json:"substate,omitempty" valid:"-" bson:"substate"`
+
+	// Set for the devices outside of the canary cohort, which do not get
+	// the deployment until it is promoted
+	Held bool `json:"held,omitempty" valid:"-" bson:"held,omitempty"`
 }
 
 func NewDeviceDeployment(deviceId, deploymentId string) *DeviceDeployment {
@@ -129,6 +133,13 @@ func NewDeviceDeploymentStats() Stats {
 	return s
 }
 
+// IsValidDeviceDeploymentStatus checks if status is one of the known
+// device deployment statuses.
+func IsValidDeviceDeploymentStatus(status string) bool {
+	_, ok := NewDeviceDeploymentStats()[status]
+	return ok
+}
+
 func IsDeviceDeploymentStatusFinished(status string) bool {
 	if status == DeviceDeploymentStatusFailure || status == DeviceDeploymentStatusSuccess ||
 		status == DeviceDeploymentStatusNoArtifact || status == DeviceDeploymentStatusAlreadyInst ||
@@ -155,7 +166,52 @@ type InstalledDeviceDeployment struct {
 	DeviceType string `valid:"required"`
 }
 
+// Validate checks the artifact name and the device type are given,
+// the device type is normalized.
 func (i *InstalledDeviceDeployment) Validate() error {
+	i.DeviceType = images.NormalizeDeviceType(i.DeviceType)
 	_, err := govalidator.ValidateStruct(i)
 	return err
 }
+
+// Device deployments lookup query
+type ListQuery struct {
+	// deployment the devices are assigned to
+	DeploymentID string
+	// match devices in given status only, all statuses if empty
+	Status string
+	Limit  int
+	Skip   int
+}
+
+// ArtifactStats counts the devices the artifact was installed on
+// and the devices it failed to install on, across all the deployments.
+type ArtifactStats struct {
+	ArtifactName string `json:"artifact_name" bson:"_id"`
+	Success      int    `json:"success" bson:"success"`
+	Failure      int    `json:"failure" bson:"failure"`
+}
+
+// ArtifactStatsQuery narrows down the counted device deployments
+// to the ones finished within the time range; zero time means no bound.
+type ArtifactStatsQuery struct {
+	FinishedAfter  time.Time
+	FinishedBefore time.Time
+}
+
+// DeploymentStats carries the device deployment counts by status
+// of the deployment
+type DeploymentStats struct {
+	DeploymentID string `json:"id"`
+	Stats        Stats  `json:"stats"`
+}
+
+// DeploymentsStatsLookup is the result of fetching the statistics of
+// multiple deployments at once
+type DeploymentsStatsLookup struct {
+	// Statistics of the found deployments, in the order of the requested IDs
+	Deployments []DeploymentStats `json:"deployments"`
+
+	// Requested IDs which were not found
+	Missing []string `json:"missing"`
+}
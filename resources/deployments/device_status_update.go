@@ -0,0 +1,78 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package deployments
+
+import (
+	"github.com/asaskevich/govalidator"
+	"github.com/pkg/errors"
+)
+
+// Outcomes of the device deployment status updates
+const (
+	StatusUpdateApplied   = "applied"
+	StatusUpdateUnchanged = "unchanged"
+	StatusUpdateRejected  = "rejected"
+)
+
+var ErrInvalidReportedStatus = errors.New("Invalid status: not one of the statuses reported by the devices")
+
+// DeviceReportedStatuses lists the statuses the devices report
+// their deployments progress with.
+func DeviceReportedStatuses() []string {
+	return []string{
+		DeviceDeploymentStatusDownloading,
+		DeviceDeploymentStatusInstalling,
+		DeviceDeploymentStatusRebooting,
+		DeviceDeploymentStatusSuccess,
+		DeviceDeploymentStatusFailure,
+		DeviceDeploymentStatusAlreadyInst,
+	}
+}
+
+// DeviceStatusUpdate is a device deployment status reported on behalf
+// of the device, one of a batch.
+type DeviceStatusUpdate struct {
+	DeploymentID string  `json:"deployment_id" valid:"uuidv4,required"`
+	DeviceID     string  `json:"device_id" valid:"required"`
+	Status       string  `json:"status" valid:"required"`
+	SubState     *string `json:"substate,omitempty" valid:"length(0|200)"`
+}
+
+// Validate checks the IDs are given and the status is one of
+// DeviceReportedStatuses.
+func (u *DeviceStatusUpdate) Validate() error {
+	if _, err := govalidator.ValidateStruct(u); err != nil {
+		return err
+	}
+	for _, status := range DeviceReportedStatuses() {
+		if u.Status == status {
+			return nil
+		}
+	}
+	return ErrInvalidReportedStatus
+}
+
+// DeviceStatusUpdateResult is the outcome of one of the batch of
+// the device deployment status updates.
+type DeviceStatusUpdateResult struct {
+	DeploymentID string `json:"deployment_id"`
+	DeviceID     string `json:"device_id"`
+
+	// One of StatusUpdate* constants
+	Result string `json:"result"`
+
+	// Reason of the rejection
+	Error string `json:"error,omitempty"`
+}
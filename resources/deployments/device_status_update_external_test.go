@@ -0,0 +1,78 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package deployments_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/mendersoftware/deployments/resources/deployments"
+	. "github.com/mendersoftware/deployments/utils/pointers"
+)
+
+func TestDeviceStatusUpdateValidate(t *testing.T) {
+	t.Parallel()
+
+	deploymentID := "b532b01a-9313-404f-8d19-e7fcbe5cc347"
+
+	testCases := map[string]struct {
+		update DeviceStatusUpdate
+		err    string
+	}{
+		"ok": {
+			update: DeviceStatusUpdate{
+				DeploymentID: deploymentID,
+				DeviceID:     "device",
+				Status:       DeviceDeploymentStatusInstalling,
+				SubState:     StringToPointer("running scripts"),
+			},
+		},
+		"invalid deployment ID": {
+			update: DeviceStatusUpdate{
+				DeploymentID: "1234",
+				DeviceID:     "device",
+				Status:       DeviceDeploymentStatusSuccess,
+			},
+			err: "DeploymentID: 1234 does not validate as uuidv4;",
+		},
+		"missing device ID": {
+			update: DeviceStatusUpdate{
+				DeploymentID: deploymentID,
+				Status:       DeviceDeploymentStatusSuccess,
+			},
+			err: "DeviceID: non zero value required;",
+		},
+		"status not reported by devices": {
+			update: DeviceStatusUpdate{
+				DeploymentID: deploymentID,
+				DeviceID:     "device",
+				Status:       DeviceDeploymentStatusAborted,
+			},
+			err: ErrInvalidReportedStatus.Error(),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.update.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package metrics records the progress of the deployments
+// as Prometheus metrics labeled by tenant.
+//
+// The gauges are updated with the status transitions processed
+// by the service instance since it started, so they should be summed
+// over all the instances.
+package metrics
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/deployments/resources/deployments"
+	utilsMetrics "github.com/mendersoftware/deployments/utils/metrics"
+)
+
+// Metric names
+const (
+	NameActiveDeployments = "deployments_active"
+	NameDevices           = "deployments_devices"
+	NameDevicesFinished   = "deployments_devices_finished_total"
+	NameDeviceFailures    = "deployments_device_failures_total"
+)
+
+// Label names
+const (
+	LabelTenant = "tenant"
+	LabelStatus = "status"
+)
+
+// Metrics of the deployments
+type Metrics struct {
+	active   *utilsMetrics.Gauge
+	devices  *utilsMetrics.Gauge
+	finished *utilsMetrics.Counter
+	failures *utilsMetrics.Counter
+
+	tenants *utilsMetrics.TenantLabels
+}
+
+// NewMetrics registers the deployment metrics in the registry.
+func NewMetrics(r *utilsMetrics.Registry) *Metrics {
+	return &Metrics{
+		active: r.NewGauge(NameActiveDeployments,
+			"Number of unfinished deployments.",
+			LabelTenant),
+		devices: r.NewGauge(NameDevices,
+			"Number of devices of unfinished deployments, by device deployment status.",
+			LabelTenant, LabelStatus),
+		finished: r.NewCounter(NameDevicesFinished,
+			"Number of device deployments finished, by final status.",
+			LabelTenant, LabelStatus),
+		failures: r.NewCounter(NameDeviceFailures,
+			"Number of device deployments reported as failed by the devices.",
+			LabelTenant),
+		tenants: r.Tenants(),
+	}
+}
+
+// tenant returns the tenant label value of the request.
+func (m *Metrics) tenant(ctx context.Context) string {
+	if id := identity.FromContext(ctx); id != nil {
+		return m.tenants.Label(id.Tenant)
+	}
+	return ""
+}
+
+// DeploymentCreated counts the deployment as active, with all its devices pending.
+func (m *Metrics) DeploymentCreated(ctx context.Context, deployment *deployments.Deployment) {
+	tenant := m.tenant(ctx)
+
+	m.active.Inc(tenant)
+	for status, count := range deployment.Stats {
+		if count > 0 {
+			m.devices.Add(float64(count), tenant, status)
+		}
+	}
+}
+
+// DeploymentFinished removes the deployment and its devices from the active ones.
+func (m *Metrics) DeploymentFinished(ctx context.Context, deployment *deployments.Deployment) {
+	tenant := m.tenant(ctx)
+
+	m.active.Dec(tenant)
+	for status, count := range deployment.Stats {
+		if count > 0 {
+			m.devices.Sub(float64(count), tenant, status)
+		}
+	}
+}
+
+// DeviceDeploymentStatusChanged moves count devices of an active deployment
+// between the statuses.
+func (m *Metrics) DeviceDeploymentStatusChanged(ctx context.Context,
+	from, to string, count int) {
+
+	tenant := m.tenant(ctx)
+
+	m.devices.Sub(float64(count), tenant, from)
+	m.devices.Add(float64(count), tenant, to)
+
+	if deployments.IsDeviceDeploymentStatusFinished(to) {
+		m.finished.Add(float64(count), tenant, to)
+	}
+	if to == deployments.DeviceDeploymentStatusFailure {
+		m.failures.Add(float64(count), tenant)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/deployments"
+	utilsMetrics "github.com/mendersoftware/deployments/utils/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := utilsMetrics.NewRegistry()
+	m := NewMetrics(registry)
+
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "tenant1"})
+
+	m.DeploymentCreated(ctx, &deployments.Deployment{
+		Stats: deployments.Stats{
+			deployments.DeviceDeploymentStatusPending: 3,
+		},
+	})
+	m.DeviceDeploymentStatusChanged(ctx, deployments.DeviceDeploymentStatusPending,
+		deployments.DeviceDeploymentStatusInstalling, 1)
+	m.DeviceDeploymentStatusChanged(ctx, deployments.DeviceDeploymentStatusInstalling,
+		deployments.DeviceDeploymentStatusFailure, 1)
+	m.DeviceDeploymentStatusChanged(ctx, deployments.DeviceDeploymentStatusPending,
+		deployments.DeviceDeploymentStatusAborted, 2)
+	m.DeploymentFinished(ctx, &deployments.Deployment{
+		Stats: deployments.Stats{
+			deployments.DeviceDeploymentStatusFailure: 1,
+			deployments.DeviceDeploymentStatusAborted: 2,
+		},
+	})
+
+	// another deployment, without tenant
+	m.DeploymentCreated(context.Background(), &deployments.Deployment{
+		Stats: deployments.Stats{
+			deployments.DeviceDeploymentStatusPending: 1,
+		},
+	})
+
+	var buf bytes.Buffer
+	_, err := registry.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP deployments_active Number of unfinished deployments.
+# TYPE deployments_active gauge
+deployments_active{tenant=""} 1
+deployments_active{tenant="tenant1"} 0
+# HELP deployments_device_failures_total Number of device deployments reported as failed by the devices.
+# TYPE deployments_device_failures_total counter
+deployments_device_failures_total{tenant="tenant1"} 1
+# HELP deployments_devices Number of devices of unfinished deployments, by device deployment status.
+# TYPE deployments_devices gauge
+deployments_devices{tenant="",status="pending"} 1
+deployments_devices{tenant="tenant1",status="aborted"} 0
+deployments_devices{tenant="tenant1",status="failure"} 0
+deployments_devices{tenant="tenant1",status="installing"} 0
+deployments_devices{tenant="tenant1",status="pending"} 0
+# HELP deployments_devices_finished_total Number of device deployments finished, by final status.
+# TYPE deployments_devices_finished_total counter
+deployments_devices_finished_total{tenant="tenant1",status="aborted"} 2
+deployments_devices_finished_total{tenant="tenant1",status="failure"} 1
+`, buf.String())
+}
+
+func TestMetricsTenantLimit(t *testing.T) {
+	registry := utilsMetrics.NewRegistry()
+	registry.Tenants().SetLimit(1)
+	m := NewMetrics(registry)
+
+	for _, tenant := range []string{"tenant1", "tenant2", "tenant3"} {
+		ctx := identity.WithContext(context.Background(),
+			&identity.Identity{Tenant: tenant})
+		m.DeploymentCreated(ctx, &deployments.Deployment{})
+	}
+
+	var buf bytes.Buffer
+	_, err := registry.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `deployments_active{tenant="other"} 2
+deployments_active{tenant="tenant1"} 1
+`)
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/mendersoftware/go-lib-micro/identity"
 	"github.com/mendersoftware/go-lib-micro/log"
 	"github.com/pkg/errors"
 
@@ -38,6 +39,23 @@ type ArtifactGetter interface {
 		ids []string, deviceType string) (*images.SoftwareImage, error)
 	ImageByNameAndDeviceType(ctx context.Context,
 		name, deviceType string) (*images.SoftwareImage, error)
+	DeltaImageByNameAndDeviceType(ctx context.Context,
+		from, name, deviceType string) (*images.SoftwareImage, error)
+}
+
+// DeploymentNotifier is informed about deployments reaching a terminal state.
+// Implementations must not block, the call is made while processing
+// device status updates.
+type DeploymentNotifier interface {
+	NotifyDeploymentFinished(ctx context.Context, deployment *deployments.Deployment)
+}
+
+// DeploymentMetrics records the progress of the deployments as the statuses
+// of the devices change. Devices are counted only while the deployment is active.
+type DeploymentMetrics interface {
+	DeploymentCreated(ctx context.Context, deployment *deployments.Deployment)
+	DeploymentFinished(ctx context.Context, deployment *deployments.Deployment)
+	DeviceDeploymentStatusChanged(ctx context.Context, from, to string, count int)
 }
 
 type DeploymentsModel struct {
@@ -45,8 +63,14 @@ type DeploymentsModel struct {
 	deviceDeploymentsStorage    DeviceDeploymentStorage
 	deviceDeploymentLogsStorage DeviceDeploymentLogsStorage
 	imageLinker                 GetRequester
+	fileVersioner               FileVersioner
 	artifactGetter              ArtifactGetter
 	imageContentType            string
+	notifier                    DeploymentNotifier
+	metrics                     DeploymentMetrics
+	idempotencyWindow           time.Duration
+	requireApproval             bool
+	blockDeprecated             bool
 }
 
 type DeploymentsModelConfig struct {
@@ -56,6 +80,18 @@ type DeploymentsModelConfig struct {
 	ImageLinker                 GetRequester
 	ArtifactGetter              ArtifactGetter
 	ImageContentType            string
+	// Notifier is optional, finished deployments are not reported if nil
+	Notifier DeploymentNotifier
+	// Metrics are optional, deployment progress is not recorded if nil
+	Metrics DeploymentMetrics
+	// IdempotencyWindow is the time the idempotency keys are remembered for,
+	// keys are ignored if 0
+	IdempotencyWindow time.Duration
+	// RequireApproval allows only the approved artifacts to be deployed
+	RequireApproval bool
+	// BlockDeprecated refuses to deploy the artifacts marked as deprecated,
+	// the deployments of them are only warned about otherwise
+	BlockDeprecated bool
 }
 
 func NewDeploymentModel(config DeploymentsModelConfig) *DeploymentsModel {
@@ -66,9 +102,21 @@ func NewDeploymentModel(config DeploymentsModelConfig) *DeploymentsModel {
 		imageLinker:                 config.ImageLinker,
 		artifactGetter:              config.ArtifactGetter,
 		imageContentType:            config.ImageContentType,
+		notifier:                    config.Notifier,
+		metrics:                     config.Metrics,
+		idempotencyWindow:           config.IdempotencyWindow,
+		requireApproval:             config.RequireApproval,
+		blockDeprecated:             config.BlockDeprecated,
 	}
 }
 
+// SetFileVersioner pins the download links for the devices to the versions
+// of the artifact files, so that the links can be revoked; the links are not
+// pinned by default.
+func (d *DeploymentsModel) SetFileVersioner(versioner FileVersioner) {
+	d.fileVersioner = versioner
+}
+
 func getArtifactIDs(artifacts []*images.SoftwareImage) []string {
 	artifactIDs := make([]string, 0, len(artifacts))
 	for _, artifact := range artifacts {
@@ -77,34 +125,108 @@ func getArtifactIDs(artifacts []*images.SoftwareImage) []string {
 	return artifactIDs
 }
 
+func getDeprecatedArtifactIDs(artifacts []*images.SoftwareImage) []string {
+	artifactIDs := []string{}
+	for _, artifact := range artifacts {
+		if artifact.IsDeprecated() {
+			artifactIDs = append(artifactIDs, artifact.Id)
+		}
+	}
+	return artifactIDs
+}
+
 // CreateDeployment precomputes new deplyomet and schedules it for devices.
+// Returned are also the devices which will not receive anything as none of
+// the artifacts is compatible with them, and the deprecated artifacts.
 // TODO: check if specified devices are bootstrapped (when have a way to do this)
 func (d *DeploymentsModel) CreateDeployment(ctx context.Context,
-	constructor *deployments.DeploymentConstructor) (string, error) {
+	constructor *deployments.DeploymentConstructor) (*deployments.DeploymentCreated, error) {
 
-	if constructor == nil {
-		return "", controller.ErrModelMissingInput
+	_, created, err := d.createDeployment(ctx, constructor, "")
+	if err != nil {
+		return nil, err
 	}
 
-	if err := constructor.Validate(); err != nil {
-		return "", errors.Wrap(err, "Validating deployment")
+	return created, nil
+}
+
+// CreateDeploymentWithIdempotencyKey creates new deployment unless one was
+// already created with the same key within the idempotency window, in which
+// case the original deployment is returned instead.
+// The creation outcome with the warnings is returned only if the deployment
+// was created, it is nil for the original one.
+// The key is not stored without the idempotency window.
+func (d *DeploymentsModel) CreateDeploymentWithIdempotencyKey(ctx context.Context,
+	constructor *deployments.DeploymentConstructor,
+	key string) (*deployments.Deployment, *deployments.DeploymentCreated, error) {
+
+	if key == "" || d.idempotencyWindow <= 0 {
+		return d.createDeployment(ctx, constructor, "")
 	}
 
-	deployment := deployments.NewDeploymentFromConstructor(constructor)
+	since := time.Now().Add(-d.idempotencyWindow)
+	original, err := d.deploymentsStorage.FindByIdempotencyKey(ctx, key, since)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Searching for deployment by idempotency key")
+	}
+	if original != nil {
+		return original, nil, nil
+	}
+
+	// keys are unique, the ones used before the window can be reused
+	if err := d.deploymentsStorage.ReleaseIdempotencyKey(ctx, key, since); err != nil {
+		return nil, nil, errors.Wrap(err, "Releasing expired idempotency key")
+	}
+
+	deployment, created, err := d.createDeployment(ctx, constructor, key)
+	if errors.Cause(err) != deployments.ErrDuplicateIdempotencyKey {
+		return deployment, created, err
+	}
+
+	// concurrent request with the same key created the deployment first
+	original, err = d.deploymentsStorage.FindByIdempotencyKey(ctx, key, since)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Searching for deployment by idempotency key")
+	}
+	if original == nil {
+		return nil, nil, errors.Wrap(deployments.ErrDuplicateIdempotencyKey,
+			"Storing deployment data")
+	}
+	return original, nil, nil
+}
+
+func (d *DeploymentsModel) createDeployment(ctx context.Context,
+	constructor *deployments.DeploymentConstructor,
+	key string) (*deployments.Deployment, *deployments.DeploymentCreated, error) {
 
 	// Assign artifacts to the deployment.
 	// Only artifacts present in the system at the moment of deployment creation
 	// will be part of this deployment.
-	artifacts, err := d.artifactGetter.ImagesByName(ctx, *deployment.ArtifactName)
+	artifacts, err := d.findDeploymentArtifacts(ctx, constructor)
 	if err != nil {
-		return "", errors.Wrap(err, "Finding artifact with given name")
+		return nil, nil, err
 	}
 
-	if len(artifacts) == 0 {
-		return "", controller.ErrNoArtifact
+	// Incompatible devices are reported for information only, the deployment
+	// is created for them anyway.
+	warnings, _, err := d.findIncompatibleDevices(ctx, artifacts, constructor.Devices)
+	if err != nil {
+		return nil, nil, err
 	}
 
+	// devices outside of the canary cohort wait for the promotion
+	canary, err := d.selectCanary(ctx, constructor)
+	if err != nil {
+		return nil, nil, err
+	}
+	inCanary := make(map[string]bool, len(canary))
+	for _, id := range canary {
+		inCanary[id] = true
+	}
+
+	deployment := deployments.NewDeploymentFromConstructor(constructor)
 	deployment.Artifacts = getArtifactIDs(artifacts)
+	deployment.IdempotencyKey = key
 
 	// Generate deployment for each specified device.
 	// Do not assign artifacts to the particular device deployment.
@@ -114,6 +236,7 @@ func (d *DeploymentsModel) CreateDeployment(ctx context.Context,
 	for _, id := range constructor.Devices {
 		deviceDeployment := deployments.NewDeviceDeployment(id, *deployment.Id)
 		deviceDeployment.Created = deployment.Created
+		deviceDeployment.Held = canary != nil && !inCanary[id]
 		deviceDeployments = append(deviceDeployments, deviceDeployment)
 	}
 
@@ -121,7 +244,7 @@ func (d *DeploymentsModel) CreateDeployment(ctx context.Context,
 	deployment.Stats[deployments.DeviceDeploymentStatusPending] = len(constructor.Devices)
 
 	if err := d.deploymentsStorage.Insert(ctx, deployment); err != nil {
-		return "", errors.Wrap(err, "Storing deployment data")
+		return nil, nil, errors.Wrap(err, "Storing deployment data")
 	}
 
 	if err := d.deviceDeploymentsStorage.InsertMany(ctx, deviceDeployments...); err != nil {
@@ -129,10 +252,195 @@ func (d *DeploymentsModel) CreateDeployment(ctx context.Context,
 			err = errors.Wrap(err, errCleanup.Error())
 		}
 
-		return "", errors.Wrap(err, "Storing assigned deployments to devices")
+		return nil, nil, errors.Wrap(err, "Storing assigned deployments to devices")
+	}
+
+	if d.metrics != nil {
+		d.metrics.DeploymentCreated(ctx, deployment)
+	}
+
+	return deployment, &deployments.DeploymentCreated{
+		Id:                  *deployment.Id,
+		Warnings:            warnings,
+		DeprecatedArtifacts: getDeprecatedArtifactIDs(artifacts),
+		Canary:              canary,
+	}, nil
+}
+
+// selectCanary selects the canary cohort of the deployment, nil if it is
+// rolled out to all the devices at once. Only the devices which last
+// reported the device type of the canary spec are eligible, if it is given.
+func (d *DeploymentsModel) selectCanary(ctx context.Context,
+	constructor *deployments.DeploymentConstructor) ([]string, error) {
+
+	if constructor.Canary == nil {
+		return nil, nil
+	}
+
+	eligible := constructor.Devices
+	if constructor.Canary.DeviceType != "" {
+		deviceTypes, err := d.deviceDeploymentsStorage.FindLatestDeviceTypes(ctx,
+			constructor.Devices)
+		if err != nil {
+			return nil, errors.Wrap(err, "Searching for device types")
+		}
+
+		eligible = make([]string, 0, len(constructor.Devices))
+		for _, id := range constructor.Devices {
+			if deviceTypes[id] == constructor.Canary.DeviceType {
+				eligible = append(eligible, id)
+			}
+		}
+	}
+
+	if len(eligible) == 0 {
+		return nil, controller.ErrNoCanaryDevices
+	}
+
+	return constructor.Canary.Select(eligible), nil
+}
+
+// findDeploymentArtifacts validates deployment constructor and finds
+// the artifacts it refers to.
+func (d *DeploymentsModel) findDeploymentArtifacts(ctx context.Context,
+	constructor *deployments.DeploymentConstructor) ([]*images.SoftwareImage, error) {
+
+	if constructor == nil {
+		return nil, controller.ErrModelMissingInput
+	}
+
+	if err := constructor.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Validating deployment")
+	}
+
+	artifacts, err := d.artifactGetter.ImagesByName(ctx, *constructor.ArtifactName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Finding artifact with given name")
+	}
+
+	if len(artifacts) == 0 {
+		return nil, controller.ErrNoArtifact
+	}
+
+	// expired artifacts are about to be removed
+	now := time.Now()
+	valid := artifacts[:0]
+	for _, artifact := range artifacts {
+		if !artifact.IsExpired(now) {
+			valid = append(valid, artifact)
+		}
+	}
+
+	if len(valid) == 0 {
+		return nil, controller.ErrArtifactExpired
+	}
+
+	if d.requireApproval {
+		approved := valid[:0]
+		for _, artifact := range valid {
+			if artifact.IsApproved() {
+				approved = append(approved, artifact)
+			}
+		}
+
+		if len(approved) == 0 {
+			return nil, controller.ErrArtifactNotApproved
+		}
+		valid = approved
+	}
+
+	if d.blockDeprecated {
+		supported := valid[:0]
+		for _, artifact := range valid {
+			if !artifact.IsDeprecated() {
+				supported = append(supported, artifact)
+			}
+		}
+
+		if len(supported) == 0 {
+			return nil, controller.ErrArtifactDeprecated
+		}
+		valid = supported
+	}
+
+	return valid, nil
+}
+
+// PlanDeployment computes the deployment the constructor would create,
+// without storing anything. Devices are checked against device types
+// they reported in the past deployments.
+func (d *DeploymentsModel) PlanDeployment(ctx context.Context,
+	constructor *deployments.DeploymentConstructor) (*deployments.DeploymentPlan, error) {
+
+	artifacts, err := d.findDeploymentArtifacts(ctx, constructor)
+	if err != nil {
+		return nil, err
+	}
+
+	skipped, unknown, err := d.findIncompatibleDevices(ctx, artifacts, constructor.Devices)
+	if err != nil {
+		return nil, err
+	}
+
+	canary, err := d.selectCanary(ctx, constructor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deployments.DeploymentPlan{
+		Name:                *constructor.Name,
+		ArtifactName:        *constructor.ArtifactName,
+		Artifacts:           getArtifactIDs(artifacts),
+		Devices:             constructor.Devices,
+		Skipped:             skipped,
+		UnknownDeviceType:   unknown,
+		DeprecatedArtifacts: getDeprecatedArtifactIDs(artifacts),
+		Canary:              canary,
+	}, nil
+}
+
+// findIncompatibleDevices finds the devices none of the artifacts supports
+// the device type they reported in the past deployments, and the devices
+// which never reported their device type.
+func (d *DeploymentsModel) findIncompatibleDevices(ctx context.Context,
+	artifacts []*images.SoftwareImage,
+	devices []string) ([]deployments.PlannedDevice, []string, error) {
+
+	deviceTypes, err := d.deviceDeploymentsStorage.FindLatestDeviceTypes(ctx, devices)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Searching for device types")
+	}
+
+	incompatible := []deployments.PlannedDevice{}
+	unknown := []string{}
+	for _, id := range devices {
+		deviceType, ok := deviceTypes[id]
+		if !ok {
+			unknown = append(unknown, id)
+			continue
+		}
+		if !artifactsSupportDeviceType(artifacts, deviceType) {
+			incompatible = append(incompatible, deployments.PlannedDevice{
+				DeviceId:   id,
+				DeviceType: deviceType,
+			})
+		}
 	}
 
-	return *deployment.Id, nil
+	return incompatible, unknown, nil
+}
+
+func artifactsSupportDeviceType(artifacts []*images.SoftwareImage, deviceType string) bool {
+	// device types reported before the normalization may differ in case
+	deviceType = images.NormalizeDeviceType(deviceType)
+	for _, artifact := range artifacts {
+		for _, compatible := range artifact.DeviceTypesCompatible {
+			if images.NormalizeDeviceType(compatible) == deviceType {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // IsDeploymentFinished checks if there is unfinished deployment with given ID
@@ -209,6 +517,89 @@ func (d *DeploymentsModel) ImageUsedInDeployment(ctx context.Context, imageID st
 	return found, nil
 }
 
+// ImageAssignedToDevice checks if the image is assigned to the device by
+// an unfinished device deployment. Images are assigned when the device asks
+// for the deployment, so the pending deployments not asked for yet
+// do not count.
+func (d *DeploymentsModel) ImageAssignedToDevice(ctx context.Context,
+	deviceID, imageID string) (bool, error) {
+
+	deviceDeployments, err := d.deviceDeploymentsStorage.FindAllDeploymentsForDeviceIDWithStatuses(
+		ctx, deviceID, deployments.ActiveDeploymentStatuses()...)
+	if err != nil {
+		return false, errors.Wrap(err, "Searching for active deployments of the device")
+	}
+
+	for _, deviceDeployment := range deviceDeployments {
+		if deviceDeployment.Image != nil && deviceDeployment.Image.Id == imageID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// selectArtifact selects the deployment artifact matching device type
+// of the device, nil if none does. A delta from the artifact installed
+// on the device is preferred over the full artifact.
+func (d *DeploymentsModel) selectArtifact(
+	ctx context.Context,
+	deployment *deployments.Deployment,
+	installed deployments.InstalledDeviceDeployment) (*images.SoftwareImage, error) {
+
+	if installed.Artifact != "" && deployment.ArtifactName != nil {
+		delta, err := d.artifactGetter.DeltaImageByNameAndDeviceType(ctx,
+			installed.Artifact, *deployment.ArtifactName, installed.DeviceType)
+		if err != nil {
+			return nil, errors.Wrap(err, "Searching for delta artifact")
+		}
+		// delta is subject to the same checks as the deployment artifacts,
+		// falling back to the full artifact
+		if delta != nil && d.isDeployable(delta, time.Now()) {
+			return delta, nil
+		}
+	}
+
+	// First case is for backward compatibility.
+	// It is possible that there is old deployment structure in the system.
+	// In such case we need to select artifact using name and device type.
+	if deployment.Artifacts == nil || len(deployment.Artifacts) == 0 {
+		return d.artifactGetter.ImageByNameAndDeviceType(ctx, installed.Artifact, installed.DeviceType)
+	}
+
+	// Select artifact for the device deployment from artifacts assgined to the deployment.
+	return d.artifactGetter.ImageByIdsAndDeviceType(ctx, deployment.Artifacts, installed.DeviceType)
+}
+
+// isDeployable tells if the artifact can be deployed: it is not expired,
+// approved if required and not deprecated if those are blocked.
+func (d *DeploymentsModel) isDeployable(artifact *images.SoftwareImage, now time.Time) bool {
+	if artifact.IsExpired(now) {
+		return false
+	}
+	if d.requireApproval && !artifact.IsApproved() {
+		return false
+	}
+	if d.blockDeprecated && artifact.IsDeprecated() {
+		return false
+	}
+	return true
+}
+
+// needsArtifact tells if the artifact has to be (re)selected for the device
+// deployment: it was not assigned yet, the device type has changed or the
+// assigned delta does not apply to the artifact installed on the device
+func needsArtifact(deviceDeployment *deployments.DeviceDeployment,
+	installed deployments.InstalledDeviceDeployment) bool {
+
+	if deviceDeployment.Image == nil || deviceDeployment.DeviceType == nil ||
+		*deviceDeployment.DeviceType != installed.DeviceType {
+		return true
+	}
+
+	delta := deviceDeployment.Image.Delta
+	return delta != nil && delta.From != installed.Artifact
+}
+
 // assignArtifact assignes artifact to the device deployment
 func (d *DeploymentsModel) assignArtifact(
 	ctx context.Context,
@@ -216,28 +607,15 @@ func (d *DeploymentsModel) assignArtifact(
 	deviceDeployment *deployments.DeviceDeployment,
 	installed deployments.InstalledDeviceDeployment) error {
 
-	// Assign artifact to the device deployment.
-	var artifact *images.SoftwareImage
-	var err error
 	// Clear device deployment image
 	// New artifact will be selected for the device deployment
 	// TODO: Should selecting different artifact be treated as an error?
 	deviceDeployment.Image = nil
 
-	// First case is for backward compatibility.
-	// It is possible that there is old deployment structure in the system.
-	// In such case we need to select artifact using name and device type.
-	if deployment.Artifacts == nil || len(deployment.Artifacts) == 0 {
-		artifact, err = d.artifactGetter.ImageByNameAndDeviceType(ctx, installed.Artifact, installed.DeviceType)
-		if err != nil {
-			return errors.Wrap(err, "assigning artifact to device deployment")
-		}
-	} else {
-		// Select artifact for the device deployment from artifacts assgined to the deployment.
-		artifact, err = d.artifactGetter.ImageByIdsAndDeviceType(ctx, deployment.Artifacts, installed.DeviceType)
-		if err != nil {
-			return errors.Wrap(err, "assigning artifact to device deployment")
-		}
+	// Assign artifact to the device deployment.
+	artifact, err := d.selectArtifact(ctx, deployment, installed)
+	if err != nil {
+		return errors.Wrap(err, "assigning artifact to device deployment")
 	}
 
 	if deviceDeployment.DeploymentId == nil || deviceDeployment.DeviceId == nil {
@@ -257,7 +635,8 @@ func (d *DeploymentsModel) assignArtifact(
 	}
 
 	if err := d.deviceDeploymentsStorage.AssignArtifact(
-		ctx, *deviceDeployment.DeviceId, *deviceDeployment.DeploymentId, artifact); err != nil {
+		ctx, *deviceDeployment.DeviceId, *deviceDeployment.DeploymentId,
+		artifact, installed.DeviceType); err != nil {
 		return errors.Wrap(err, "Assigning artifact to the device deployment")
 	}
 
@@ -280,7 +659,8 @@ func (d *DeploymentsModel) GetDeploymentForDeviceWithCurrent(ctx context.Context
 		return nil, errors.Wrap(err, "Searching for oldest active deployment for the device")
 	}
 
-	if deviceDeployment == nil {
+	// devices outside of the canary cohort wait for the promotion
+	if deviceDeployment == nil || deviceDeployment.Held {
 		return nil, nil
 	}
 
@@ -293,7 +673,7 @@ func (d *DeploymentsModel) GetDeploymentForDeviceWithCurrent(ctx context.Context
 		return nil, nil
 	}
 
-	if installed.Artifact != "" && *deployment.ArtifactName == installed.Artifact {
+	if deployment.IsAlreadyInstalled(installed.Artifact) {
 		// pretend there is no deployment for this device, but update
 		// its status to already installed first
 
@@ -308,8 +688,9 @@ func (d *DeploymentsModel) GetDeploymentForDeviceWithCurrent(ctx context.Context
 		return nil, nil
 	}
 
-	// assign artifact only if the artifact was not assigned previously or the device type has changed
-	if deviceDeployment.Image == nil || deviceDeployment.DeviceType == nil || *deviceDeployment.DeviceType != installed.DeviceType {
+	// assign artifact only if the artifact was not assigned previously, the device type has changed
+	// or the assigned delta does not match the installed artifact
+	if needsArtifact(deviceDeployment, installed) {
 		if err := d.assignArtifact(ctx, deployment, deviceDeployment, installed); err != nil {
 			return nil, err
 		}
@@ -319,8 +700,21 @@ func (d *DeploymentsModel) GetDeploymentForDeviceWithCurrent(ctx context.Context
 		return nil, nil
 	}
 
-	link, err := d.imageLinker.GetRequest(ctx, deviceDeployment.Image.Id,
-		DefaultUpdateDownloadLinkExpire, d.imageContentType)
+	var tenant string
+	if id := identity.FromContext(ctx); id != nil {
+		tenant = id.Tenant
+	}
+
+	var versionID string
+	if d.fileVersioner != nil {
+		versionID, err = d.fileVersioner.FileVersion(ctx, deviceDeployment.Image.Id)
+		if err != nil {
+			return nil, errors.Wrap(err, "Searching for artifact file version")
+		}
+	}
+
+	link, err := d.imageLinker.GetRequest(ctx, deviceDeployment.Image.FileObjectKey(tenant),
+		versionID, DefaultUpdateDownloadLinkExpire, d.imageContentType)
 	if err != nil {
 		return nil, errors.Wrap(err, "Generating download link for the device")
 	}
@@ -337,6 +731,64 @@ func (d *DeploymentsModel) GetDeploymentForDeviceWithCurrent(ctx context.Context
 	return instructions, nil
 }
 
+// PreviewDeploymentForDevice tells which artifact the device would receive
+// on its next update request, given its device type and, optionally,
+// the installed artifact name. Neither the device deployment status nor
+// the assigned artifact are modified.
+func (d *DeploymentsModel) PreviewDeploymentForDevice(ctx context.Context, deviceID string,
+	installed deployments.InstalledDeviceDeployment) (*deployments.DeploymentPreview, error) {
+
+	deviceDeployment, err := d.deviceDeploymentsStorage.FindOldestDeploymentForDeviceIDWithStatuses(
+		ctx,
+		deviceID,
+		deployments.ActiveDeploymentStatuses()...)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Searching for oldest active deployment for the device")
+	}
+
+	// devices outside of the canary cohort wait for the promotion
+	if deviceDeployment == nil || deviceDeployment.Held {
+		return nil, nil
+	}
+
+	deployment, err := d.deploymentsStorage.FindByID(ctx, *deviceDeployment.DeploymentId)
+	if err != nil {
+		return nil, controller.ErrModelInternal
+	}
+
+	if deployment == nil {
+		return nil, nil
+	}
+
+	// device would be reported as already installed
+	if deployment.IsAlreadyInstalled(installed.Artifact) {
+		return nil, nil
+	}
+
+	// same rules as for assigning the artifact on the update request
+	artifact := deviceDeployment.Image
+	if needsArtifact(deviceDeployment, installed) {
+		artifact, err = d.selectArtifact(ctx, deployment, installed)
+		if err != nil {
+			return nil, errors.Wrap(err, "Selecting artifact for the device")
+		}
+	}
+
+	if artifact == nil {
+		return nil, nil
+	}
+
+	return &deployments.DeploymentPreview{
+		ID: *deviceDeployment.DeploymentId,
+		Artifact: deployments.ArtifactPreview{
+			ID:                    artifact.Id,
+			ArtifactName:          artifact.Name,
+			DeviceTypesCompatible: artifact.DeviceTypesCompatible,
+		},
+	}, nil
+}
+
 // UpdateDeviceDeploymentStatus will update the deployment status for device of
 // ID `deviceID`. Returns nil if update was successful.
 func (d *DeploymentsModel) UpdateDeviceDeploymentStatus(ctx context.Context, deploymentID string,
@@ -344,7 +796,11 @@ func (d *DeploymentsModel) UpdateDeviceDeploymentStatus(ctx context.Context, dep
 
 	l := log.FromContext(ctx)
 
-	l.Infof("New status: %s for device %s deployment: %v", ddStatus.Status, deviceID, deploymentID)
+	l.F(log.Ctx{
+		"device_id":     deviceID,
+		"deployment_id": deploymentID,
+		"device_status": ddStatus.Status,
+	}).Info("new device deployment status")
 
 	var finishTime *time.Time = nil
 	if deployments.IsDeviceDeploymentStatusFinished(ddStatus.Status) {
@@ -380,8 +836,28 @@ func (d *DeploymentsModel) UpdateDeviceDeploymentStatus(ctx context.Context, dep
 		return err
 	}
 
-	if err = d.deploymentsStorage.UpdateStats(ctx, deploymentID, old, ddStatus.Status); err != nil {
-		return err
+	return d.deploymentProgressed(ctx, deploymentID,
+		statusChange{from: old, to: ddStatus.Status})
+}
+
+// statusChange is a transition of a device deployment status
+type statusChange struct {
+	from string
+	to   string
+}
+
+// deploymentProgressed counts the device deployment status changes in the
+// deployment stats, and finishes the deployment once all its devices did.
+func (d *DeploymentsModel) deploymentProgressed(ctx context.Context,
+	deploymentID string, changes ...statusChange) error {
+
+	l := log.FromContext(ctx)
+
+	for _, change := range changes {
+		if err := d.deploymentsStorage.UpdateStats(ctx, deploymentID,
+			change.from, change.to); err != nil {
+			return err
+		}
 	}
 
 	// fetch deployment stats and update finished field if needed
@@ -390,18 +866,127 @@ func (d *DeploymentsModel) UpdateDeviceDeploymentStatus(ctx context.Context, dep
 		return errors.Wrap(err, "failed when searching for deployment")
 	}
 
+	if d.metrics != nil && deployment.Finished == nil {
+		for _, change := range changes {
+			d.metrics.DeviceDeploymentStatusChanged(ctx, change.from, change.to, 1)
+		}
+	}
+
 	if deployment.IsFinished() {
 		// TODO: Make this part of UpdateStats() call as currently we are doing two
 		// write operations on DB - as well as it's safer to keep them in single transaction.
-		l.Infof("Finish deployment: %s", deploymentID)
-		if err := d.deploymentsStorage.Finish(ctx, deploymentID, time.Now()); err != nil {
+		l.F(log.Ctx{"deployment_id": deploymentID}).Info("finish deployment")
+		now := time.Now()
+		if err := d.deploymentsStorage.Finish(ctx, deploymentID, now); err != nil {
 			return errors.Wrap(err, "failed to mark deployment as finished")
 		}
+
+		// report only the transition, not the updates trickling in
+		// after the deployment was aborted
+		if deployment.Finished == nil {
+			deployment.Finished = &now
+			if d.notifier != nil {
+				d.notifier.NotifyDeploymentFinished(ctx, deployment)
+			}
+			if d.metrics != nil {
+				d.metrics.DeploymentFinished(ctx, deployment)
+			}
+		}
 	}
 
 	return nil
 }
 
+// UpdateDeviceDeploymentStatuses applies a batch of the status updates
+// reported on behalf of the devices, with a single bulk write, and reports
+// the outcome of each in order. Invalid updates and the updates of the device
+// deployments not found, aborted or decommissioned are rejected without
+// failing the others.
+func (d *DeploymentsModel) UpdateDeviceDeploymentStatuses(ctx context.Context,
+	updates []deployments.DeviceStatusUpdate) ([]deployments.DeviceStatusUpdateResult, error) {
+
+	results := make([]deployments.DeviceStatusUpdateResult, len(updates))
+	valid := make([]deployments.DeviceStatusUpdate, 0, len(updates))
+	for i := range updates {
+		results[i] = deployments.DeviceStatusUpdateResult{
+			DeploymentID: updates[i].DeploymentID,
+			DeviceID:     updates[i].DeviceID,
+		}
+		if err := updates[i].Validate(); err != nil {
+			results[i].Result = deployments.StatusUpdateRejected
+			results[i].Error = err.Error()
+			continue
+		}
+		valid = append(valid, updates[i])
+	}
+
+	found, err := d.deviceDeploymentsStorage.FindDeviceDeployments(ctx, valid)
+	if err != nil {
+		return nil, errors.Wrap(err, "Searching for device deployments")
+	}
+
+	type key struct{ deployment, device string }
+	current := make(map[key]string, len(found))
+	for _, deviceDeployment := range found {
+		current[key{*deviceDeployment.DeploymentId, *deviceDeployment.DeviceId}] =
+			*deviceDeployment.Status
+	}
+
+	var apply []deployments.DeviceStatusUpdate
+	var progressed []string
+	changes := map[string][]statusChange{}
+	for i := range results {
+		if results[i].Result != "" {
+			continue
+		}
+		update := updates[i]
+		k := key{update.DeploymentID, update.DeviceID}
+		status, ok := current[k]
+		switch {
+		case !ok:
+			results[i].Result = deployments.StatusUpdateRejected
+			results[i].Error = controller.ErrStorageNotFound.Error()
+		case status == deployments.DeviceDeploymentStatusAborted:
+			results[i].Result = deployments.StatusUpdateRejected
+			results[i].Error = controller.ErrDeploymentAborted.Error()
+		case status == deployments.DeviceDeploymentStatusDecommissioned:
+			results[i].Result = deployments.StatusUpdateRejected
+			results[i].Error = controller.ErrDeviceDecommissioned.Error()
+		case status == update.Status:
+			results[i].Result = deployments.StatusUpdateUnchanged
+		default:
+			results[i].Result = deployments.StatusUpdateApplied
+			// later updates of the same device deployment change this one
+			current[k] = update.Status
+			apply = append(apply, update)
+			if _, ok := changes[update.DeploymentID]; !ok {
+				progressed = append(progressed, update.DeploymentID)
+			}
+			changes[update.DeploymentID] = append(changes[update.DeploymentID],
+				statusChange{from: status, to: update.Status})
+		}
+	}
+
+	log.FromContext(ctx).F(log.Ctx{
+		"updates": len(updates),
+		"applied": len(apply),
+	}).Info("device deployment status updates batch")
+
+	if err := d.deviceDeploymentsStorage.UpdateDeviceDeploymentStatuses(ctx,
+		apply, time.Now()); err != nil {
+		return nil, errors.Wrap(err, "Updating device deployment statuses")
+	}
+
+	for _, deploymentID := range progressed {
+		if err := d.deploymentProgressed(ctx, deploymentID,
+			changes[deploymentID]...); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
 func (d *DeploymentsModel) GetDeploymentStats(ctx context.Context,
 	deploymentID string) (deployments.Stats, error) {
 
@@ -418,6 +1003,53 @@ func (d *DeploymentsModel) GetDeploymentStats(ctx context.Context,
 	return d.deviceDeploymentsStorage.AggregateDeviceDeploymentByStatus(ctx, deploymentID)
 }
 
+// GetDeploymentsStats counts the device deployments by status of each of
+// the deployments at once. IDs which are not found are listed as missing.
+func (d *DeploymentsModel) GetDeploymentsStats(ctx context.Context,
+	deploymentIDs []string) (*deployments.DeploymentsStatsLookup, error) {
+
+	found, err := d.deviceDeploymentsStorage.
+		AggregateDeviceDeploymentByStatusForDeployments(ctx, deploymentIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "counting device deployments by status")
+	}
+
+	byID := make(map[string]deployments.Stats, len(found))
+	for _, stats := range found {
+		byID[stats.DeploymentID] = stats.Stats
+	}
+
+	lookup := &deployments.DeploymentsStatsLookup{
+		Deployments: make([]deployments.DeploymentStats, 0, len(found)),
+		Missing:     make([]string, 0),
+	}
+	for _, id := range deploymentIDs {
+		if stats, ok := byID[id]; ok {
+			lookup.Deployments = append(lookup.Deployments, deployments.DeploymentStats{
+				DeploymentID: id,
+				Stats:        stats,
+			})
+		} else {
+			lookup.Missing = append(lookup.Missing, id)
+		}
+	}
+
+	return lookup, nil
+}
+
+// GetArtifactStats counts the devices each artifact was installed on
+// successfully and failed to install on, across all the deployments.
+func (d *DeploymentsModel) GetArtifactStats(ctx context.Context,
+	query deployments.ArtifactStatsQuery) ([]deployments.ArtifactStats, error) {
+
+	stats, err := d.deviceDeploymentsStorage.AggregateDeviceDeploymentByArtifact(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "counting device deployments by artifact")
+	}
+
+	return stats, nil
+}
+
 //GetDeviceStatusesForDeployment retrieve device deployment statuses for a given deployment.
 func (d *DeploymentsModel) GetDeviceStatusesForDeployment(ctx context.Context,
 	deploymentID string) ([]deployments.DeviceDeployment, error) {
@@ -439,6 +1071,28 @@ func (d *DeploymentsModel) GetDeviceStatusesForDeployment(ctx context.Context,
 	return statuses, nil
 }
 
+// GetDevicesListForDeployment retrieve a page of device deployment statuses
+// for a given deployment, optionally filtered by status.
+func (d *DeploymentsModel) GetDevicesListForDeployment(ctx context.Context,
+	query deployments.ListQuery) ([]deployments.DeviceDeployment, error) {
+
+	deployment, err := d.deploymentsStorage.FindByID(ctx, query.DeploymentID)
+	if err != nil {
+		return nil, controller.ErrModelInternal
+	}
+
+	if deployment == nil {
+		return nil, controller.ErrModelDeploymentNotFound
+	}
+
+	statuses, err := d.deviceDeploymentsStorage.GetDevicesListForDeployment(ctx, query)
+	if err != nil {
+		return nil, controller.ErrModelInternal
+	}
+
+	return statuses, nil
+}
+
 func (d *DeploymentsModel) LookupDeployment(ctx context.Context,
 	query deployments.Query) ([]*deployments.Deployment, error) {
 	list, err := d.deploymentsStorage.Find(ctx, query)
@@ -500,6 +1154,12 @@ func (d *DeploymentsModel) HasDeploymentForDevice(ctx context.Context,
 // AbortDeployment aborts deployment for devices and updates deployment stats
 func (d *DeploymentsModel) AbortDeployment(ctx context.Context, deploymentID string) error {
 
+	// state before aborting, to report the devices aborted
+	var before *deployments.Deployment
+	if d.metrics != nil {
+		before = d.findDeploymentToReport(ctx, deploymentID)
+	}
+
 	if err := d.deviceDeploymentsStorage.AbortDeviceDeployments(ctx, deploymentID); err != nil {
 		return err
 	}
@@ -513,8 +1173,162 @@ func (d *DeploymentsModel) AbortDeployment(ctx context.Context, deploymentID str
 	// Update deployment stats and finish deployment (set finished timestamp to current time)
 	// Aborted deployment is considered to be finished even if some devices are
 	// still processing this deployment.
-	return d.deploymentsStorage.UpdateStatsAndFinishDeployment(ctx,
-		deploymentID, stats)
+	if err := d.deploymentsStorage.UpdateStatsAndFinishDeployment(ctx,
+		deploymentID, stats); err != nil {
+		return err
+	}
+
+	d.reportStatsChange(ctx, before, stats, deployments.DeviceDeploymentStatusAborted)
+	d.notifyDeploymentFinished(ctx, deploymentID)
+
+	return nil
+}
+
+// PromoteDeployment advances the canary deployment to its second and last
+// phase, rolling it out to the devices outside of the canary cohort.
+// Promoting the deployment again releases the devices still held back
+// by a promotion which failed half-way, and is a no-op otherwise.
+func (d *DeploymentsModel) PromoteDeployment(ctx context.Context, deploymentID string) error {
+
+	deployment, err := d.deploymentsStorage.FindByID(ctx, deploymentID)
+	if err != nil {
+		return errors.Wrap(err, "Searching for deployment by ID")
+	}
+
+	switch {
+	case deployment == nil:
+		return controller.ErrModelDeploymentNotFound
+	case deployment.DeploymentConstructor == nil || deployment.Canary == nil:
+		return controller.ErrDeploymentNotCanary
+	case deployment.Halted != nil:
+		return controller.ErrDeploymentHalted
+	case deployment.Finished != nil && deployment.Promoted != nil:
+		return nil
+	case deployment.Finished != nil:
+		return controller.ErrDeploymentAlreadyFinished
+	}
+
+	if deployment.Promoted == nil {
+		promoted := time.Now()
+		changed, err := d.deploymentsStorage.Promote(ctx, deploymentID, promoted)
+		if err != nil {
+			return errors.Wrap(err, "Promoting deployment")
+		}
+		if !changed {
+			// promoted or halted concurrently
+			return d.PromoteDeployment(ctx, deploymentID)
+		}
+
+		auditLogger(ctx, "deployment_promoted", deploymentID, promoted).
+			Info("canary deployment promoted")
+	}
+
+	if err := d.deviceDeploymentsStorage.ReleaseDeviceDeployments(ctx,
+		deploymentID); err != nil {
+		return errors.Wrap(err, "Releasing device deployments")
+	}
+
+	return nil
+}
+
+// HaltDeployment stops the canary deployment from advancing to its second
+// phase, aborting the device deployments of the devices outside of the
+// canary cohort. The canary devices are not affected. Halting the deployment
+// again completes a halt which failed half-way, and is a no-op otherwise.
+func (d *DeploymentsModel) HaltDeployment(ctx context.Context, deploymentID string) error {
+
+	deployment, err := d.deploymentsStorage.FindByID(ctx, deploymentID)
+	if err != nil {
+		return errors.Wrap(err, "Searching for deployment by ID")
+	}
+
+	switch {
+	case deployment == nil:
+		return controller.ErrModelDeploymentNotFound
+	case deployment.DeploymentConstructor == nil || deployment.Canary == nil:
+		return controller.ErrDeploymentNotCanary
+	case deployment.Promoted != nil:
+		return controller.ErrDeploymentPromoted
+	case deployment.Finished != nil && deployment.Halted != nil:
+		return nil
+	case deployment.Finished != nil:
+		return controller.ErrDeploymentAlreadyFinished
+	}
+
+	if deployment.Halted == nil {
+		halted := time.Now()
+		changed, err := d.deploymentsStorage.Halt(ctx, deploymentID, halted)
+		if err != nil {
+			return errors.Wrap(err, "Halting deployment")
+		}
+		if !changed {
+			// promoted or halted concurrently
+			return d.HaltDeployment(ctx, deploymentID)
+		}
+
+		auditLogger(ctx, "deployment_halted", deploymentID, halted).
+			Info("canary deployment halted")
+	}
+
+	if err := d.deviceDeploymentsStorage.AbortHeldDeviceDeployments(ctx,
+		deploymentID); err != nil {
+		return errors.Wrap(err, "Aborting held device deployments")
+	}
+
+	stats, err := d.deviceDeploymentsStorage.AggregateDeviceDeploymentByStatus(
+		ctx, deploymentID)
+	if err != nil {
+		return errors.Wrap(err, "Aggregating device deployment statistics")
+	}
+
+	// finishes the deployment if the canary devices are done already
+	if err := d.deploymentsStorage.UpdateStatsAndFinishDeployment(ctx,
+		deploymentID, stats); err != nil {
+		return errors.Wrap(err, "Updating deployment statistics")
+	}
+
+	d.reportStatsChange(ctx, deployment, stats, deployments.DeviceDeploymentStatusAborted)
+	d.notifyDeploymentFinished(ctx, deploymentID)
+
+	return nil
+}
+
+// auditLogger returns the logger for the audit trail of the change to the
+// deployment, along with the user making it.
+func auditLogger(ctx context.Context, audit, deploymentID string,
+	changed time.Time) *log.Logger {
+
+	l := log.FromContext(ctx).F(log.Ctx{
+		"audit":         audit,
+		"deployment_id": deploymentID,
+		"changed_at":    changed.UTC().Format(time.RFC3339),
+	})
+	if id := identity.FromContext(ctx); id != nil {
+		l = l.F(log.Ctx{
+			"user_id":   id.Subject,
+			"tenant_id": id.Tenant,
+		})
+	}
+	return l
+}
+
+// AbortImageDeployments aborts the active deployments using the image,
+// so that it can be removed. Returns the number of aborted deployments.
+func (d *DeploymentsModel) AbortImageDeployments(ctx context.Context,
+	imageID string) (int, error) {
+
+	active, err := d.deploymentsStorage.FindUnfinishedByArtifactId(ctx, imageID)
+	if err != nil {
+		return 0, errors.Wrap(err, "Searching for active deployments using image")
+	}
+
+	for i, deployment := range active {
+		if err := d.AbortDeployment(ctx, *deployment.Id); err != nil {
+			return i, errors.Wrapf(err, "Aborting deployment %s", *deployment.Id)
+		}
+	}
+
+	return len(active), nil
 }
 
 func (d *DeploymentsModel) DecommissionDevice(ctx context.Context, deviceId string) error {
@@ -541,11 +1355,82 @@ func (d *DeploymentsModel) DecommissionDevice(ctx context.Context, deviceId stri
 		if err != nil {
 			return err
 		}
+
+		// state before the update; deployments of the device
+		// finished earlier were already reported
+		var before *deployments.Deployment
+		if d.notifier != nil || d.metrics != nil {
+			before = d.findDeploymentToReport(ctx, *deviceDeployment.DeploymentId)
+		}
+
 		if err := d.deploymentsStorage.UpdateStatsAndFinishDeployment(
 			ctx, *deviceDeployment.DeploymentId, stats); err != nil {
 			return err
 		}
+
+		if before != nil && before.Finished == nil {
+			d.reportStatsChange(ctx, before, stats,
+				deployments.DeviceDeploymentStatusDecommissioned)
+			d.notifyDeploymentFinished(ctx, *deviceDeployment.DeploymentId)
+		}
 	}
 
 	return nil
 }
+
+// findDeploymentToReport looks up the deployment before its state is changed,
+// so that the change can be reported. Nil if the lookup fails, the change
+// is not reported then.
+func (d *DeploymentsModel) findDeploymentToReport(ctx context.Context,
+	deploymentID string) *deployments.Deployment {
+
+	deployment, err := d.deploymentsStorage.FindByID(ctx, deploymentID)
+	if err != nil {
+		return nil
+	}
+	return deployment
+}
+
+// reportStatsChange reports the devices of the active deployment moved
+// to the given status, based on the deployment statistics before and after
+// the change, as well as the deployment if the change finished it.
+func (d *DeploymentsModel) reportStatsChange(ctx context.Context,
+	before *deployments.Deployment, after deployments.Stats, to string) {
+
+	if d.metrics == nil || before == nil || before.Finished != nil {
+		return
+	}
+
+	for status, count := range before.Stats {
+		if status != to && after[status] < count {
+			d.metrics.DeviceDeploymentStatusChanged(ctx, status, to, count-after[status])
+		}
+	}
+
+	finished := *before
+	finished.Stats = after
+	if finished.IsFinished() {
+		d.metrics.DeploymentFinished(ctx, &finished)
+	}
+}
+
+// notifyDeploymentFinished reports the deployment to the notifier if it is
+// finished. Failures are only logged, the deployment is finished regardless.
+func (d *DeploymentsModel) notifyDeploymentFinished(ctx context.Context,
+	deploymentID string) {
+
+	if d.notifier == nil {
+		return
+	}
+
+	deployment, err := d.deploymentsStorage.FindByID(ctx, deploymentID)
+	if err != nil || deployment == nil {
+		log.FromContext(ctx).F(log.Ctx{"deployment_id": deploymentID}).
+			Errorf("failed to look up finished deployment: %v", err)
+		return
+	}
+
+	if deployment.Finished != nil {
+		d.notifier.NotifyDeploymentFinished(ctx, deployment)
+	}
+}
@@ -233,6 +233,60 @@ func TestDeploymentModelImageUsedInDeployment(t *testing.T) {
 
 }
 
+func TestDeploymentModelImageAssignedToDevice(t *testing.T) {
+	assigned := deployments.NewDeviceDeployment("device", "deployment1")
+	assigned.Image = &images.SoftwareImage{Id: "image1"}
+	notAssigned := deployments.NewDeviceDeployment("device", "deployment2")
+
+	testCases := map[string]struct {
+		imageID     string
+		found       []deployments.DeviceDeployment
+		findErr     error
+		assigned    bool
+		outputError string
+	}{
+		"assigned": {
+			imageID:  "image1",
+			found:    []deployments.DeviceDeployment{*notAssigned, *assigned},
+			assigned: true,
+		},
+		"other image assigned": {
+			imageID: "image2",
+			found:   []deployments.DeviceDeployment{*notAssigned, *assigned},
+		},
+		"no active deployments": {
+			imageID: "image1",
+		},
+		"storage error": {
+			imageID:     "image1",
+			findErr:     errors.New("storage error"),
+			outputError: "Searching for active deployments of the device: storage error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+			deviceDeploymentStorage.On("FindAllDeploymentsForDeviceIDWithStatuses",
+				h.ContextMatcher(), "device", deployments.ActiveDeploymentStatuses()).
+				Return(tc.found, tc.findErr)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
+			})
+
+			assigned, err := model.ImageAssignedToDevice(context.Background(),
+				"device", tc.imageID)
+			if tc.outputError != "" {
+				assert.EqualError(t, err, tc.outputError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.assigned, assigned)
+		})
+	}
+}
+
 func TestDeploymentModelGetDeploymentForDevice(t *testing.T) {
 
 	//t.Parallel()
@@ -256,7 +310,11 @@ func TestDeploymentModelGetDeploymentForDevice(t *testing.T) {
 		InputGetRequestLink  *images.Link
 		InputGetRequestError error
 
+		InputFileVersion      string
+		InputFileVersionError error
+
 		InputInstalledDeployment deployments.InstalledDeviceDeployment
+		InputForce               bool
 
 		InputArtifact                      *images.SoftwareImage
 		InputImageByIdsAndDeviceTypeError  error
@@ -314,6 +372,39 @@ func TestDeploymentModelGetDeploymentForDevice(t *testing.T) {
 
 			OutputError: errors.New("Generating download link for the device: file storage error"),
 		},
+		{
+			InputID: "ID:123",
+			InputOlderstDeviceDeployment: &deployments.DeviceDeployment{
+				Image:        image,
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+			},
+			InputArtifact:         image,
+			InputFileVersionError: errors.New("db error"),
+
+			OutputError: errors.New("Searching for artifact file version: db error"),
+		},
+		{
+			// link pinned to the recorded version of the file
+			InputID: "ID:123",
+			InputOlderstDeviceDeployment: &deployments.DeviceDeployment{
+				Image:        image,
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+			},
+			InputArtifact:       image,
+			InputGetRequestLink: &images.Link{Uri: "pinned"},
+			InputFileVersion:    "v1",
+
+			OutputDeploymentInstructions: &deployments.DeploymentInstructions{
+				ID: "ID:678",
+				Artifact: deployments.ArtifactDeploymentInstructions{
+					ArtifactName:          image.Name,
+					Source:                images.Link{Uri: "pinned"},
+					DeviceTypesCompatible: image.DeviceTypesCompatible,
+				},
+			},
+		},
 		{
 			InputID: "ID:123",
 			InputOlderstDeviceDeployment: &deployments.DeviceDeployment{
@@ -349,6 +440,45 @@ func TestDeploymentModelGetDeploymentForDevice(t *testing.T) {
 				DeviceType: "hammer",
 			},
 		},
+		{
+			// forced deployment, artifact is installed again
+			InputID: "ID:123",
+			InputOlderstDeviceDeployment: &deployments.DeviceDeployment{
+				Id:           StringToPointer("ID:device-deployment-123"),
+				DeviceId:     StringToPointer("ID:123"),
+				Image:        image,
+				DeviceType:   StringToPointer("hammer"),
+				DeploymentId: StringToPointer("ID:678"),
+			},
+			InputGetRequestLink: &images.Link{},
+
+			InputInstalledDeployment: deployments.InstalledDeviceDeployment{
+				Artifact:   image.Name,
+				DeviceType: "hammer",
+			},
+			InputForce: true,
+
+			OutputDeploymentInstructions: &deployments.DeploymentInstructions{
+				ID: "ID:678",
+				Artifact: deployments.ArtifactDeploymentInstructions{
+					ArtifactName:          image.Name,
+					Source:                images.Link{},
+					DeviceTypesCompatible: image.DeviceTypesCompatible,
+				},
+			},
+		},
+		{
+			// device held back until the canary deployment is promoted
+			InputID: "ID:123",
+			InputOlderstDeviceDeployment: &deployments.DeviceDeployment{
+				Image:        image,
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+				Held:         true,
+			},
+			InputArtifact:       image,
+			InputGetRequestLink: &images.Link{},
+		},
 	}
 
 	for testCaseNumber, testCase := range testCases {
@@ -383,7 +513,8 @@ func TestDeploymentModelGetDeploymentForDevice(t *testing.T) {
 				h.ContextMatcher(),
 				mock.AnythingOfType("string"),
 				mock.AnythingOfType("string"),
-				mock.AnythingOfType("*images.SoftwareImage")).
+				mock.AnythingOfType("*images.SoftwareImage"),
+				mock.AnythingOfType("string")).
 				Return(nil)
 				//Return(testCase.InputAssignArtifactError)
 
@@ -396,6 +527,7 @@ func TestDeploymentModelGetDeploymentForDevice(t *testing.T) {
 				// break by panic ;)
 				imageLinker.On("GetRequest", h.ContextMatcher(),
 					testCase.InputOlderstDeviceDeployment.Image.Id,
+					testCase.InputFileVersion,
 					DefaultUpdateDownloadLinkExpire, mock.AnythingOfType("string")).
 					Return(testCase.InputGetRequestLink, testCase.InputGetRequestError)
 
@@ -418,6 +550,7 @@ func TestDeploymentModelGetDeploymentForDevice(t *testing.T) {
 						Stats: deployments.NewDeviceDeploymentStats(),
 						DeploymentConstructor: &deployments.DeploymentConstructor{
 							ArtifactName: &image.Name,
+							Force:        testCase.InputForce,
 						},
 					}, nil)
 
@@ -445,6 +578,13 @@ func TestDeploymentModelGetDeploymentForDevice(t *testing.T) {
 				Return(testCase.InputArtifact,
 					testCase.InputImageByNameAndDeviceTypeError)
 
+			artifactGetter.On("DeltaImageByNameAndDeviceType",
+				h.ContextMatcher(),
+				mock.AnythingOfType("string"),
+				mock.AnythingOfType("string"),
+				mock.AnythingOfType("string")).
+				Return(nil, nil)
+
 			model := NewDeploymentModel(DeploymentsModelConfig{
 				DeviceDeploymentsStorage: deviceDeploymentStorage,
 				DeploymentsStorage:       deploymentStorage,
@@ -452,6 +592,11 @@ func TestDeploymentModelGetDeploymentForDevice(t *testing.T) {
 				ArtifactGetter:           artifactGetter,
 			})
 
+			fileVersioner := new(mocks.FileVersioner)
+			fileVersioner.On("FileVersion", h.ContextMatcher(), image.Id).
+				Return(testCase.InputFileVersion, testCase.InputFileVersionError)
+			model.SetFileVersioner(fileVersioner)
+
 			out, err := model.GetDeploymentForDeviceWithCurrent(context.Background(),
 				testCase.InputID,
 				testCase.InputInstalledDeployment)
@@ -472,6 +617,289 @@ func TestDeploymentModelGetDeploymentForDevice(t *testing.T) {
 
 }
 
+func TestDeploymentModelPreviewDeploymentForDevice(t *testing.T) {
+
+	t.Parallel()
+
+	image := images.NewSoftwareImage(
+		validUUIDv4,
+		&images.SoftwareImageMetaConstructor{},
+		&images.SoftwareImageMetaArtifactConstructor{
+			Name:                  "foo-artifact",
+			DeviceTypesCompatible: []string{"hammer"},
+		})
+
+	delta := images.NewSoftwareImage(
+		"d4cfa8f4-1b4e-4b2a-9c39-3c1a2b8f6e7d",
+		&images.SoftwareImageMetaConstructor{},
+		&images.SoftwareImageMetaArtifactConstructor{
+			Name:                  "foo-artifact",
+			DeviceTypesCompatible: []string{"hammer"},
+		})
+	delta.Delta = &images.DeltaUpdate{
+		From: "bar-artifact",
+		To:   "foo-artifact",
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	expiredDelta := *delta
+	expiredDelta.ExpiresAt = &expired
+
+	deprecatedDelta := *delta
+	deprecatedDelta.State = images.ImageStateDeprecated
+
+	testCases := []struct {
+		InputDeviceDeployment      *deployments.DeviceDeployment
+		InputDeviceDeploymentError error
+
+		InputInstalled       deployments.InstalledDeviceDeployment
+		InputBlockDeprecated bool
+
+		InputArtifact      *images.SoftwareImage
+		InputArtifactError error
+
+		InputDelta      *images.SoftwareImage
+		InputDeltaError error
+
+		OutputPreview *deployments.DeploymentPreview
+		OutputError   error
+	}{
+		{
+			InputDeviceDeploymentError: errors.New("storage issue"),
+			OutputError:                errors.New("Searching for oldest active deployment for the device: storage issue"),
+		},
+		{
+			// no active deployment
+			InputInstalled: deployments.InstalledDeviceDeployment{DeviceType: "hammer"},
+		},
+		{
+			// already installed
+			InputDeviceDeployment: &deployments.DeviceDeployment{
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+			},
+			InputInstalled: deployments.InstalledDeviceDeployment{
+				Artifact:   "foo-artifact",
+				DeviceType: "hammer",
+			},
+		},
+		{
+			// no compatible artifact
+			InputDeviceDeployment: &deployments.DeviceDeployment{
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+			},
+			InputInstalled: deployments.InstalledDeviceDeployment{DeviceType: "drill"},
+		},
+		{
+			InputDeviceDeployment: &deployments.DeviceDeployment{
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+			},
+			InputInstalled:     deployments.InstalledDeviceDeployment{DeviceType: "hammer"},
+			InputArtifactError: errors.New("images error"),
+			OutputError:        errors.New("Selecting artifact for the device: images error"),
+		},
+		{
+			InputDeviceDeployment: &deployments.DeviceDeployment{
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+			},
+			InputInstalled: deployments.InstalledDeviceDeployment{
+				Artifact:   "bar-artifact",
+				DeviceType: "hammer",
+			},
+			InputArtifact: image,
+			OutputPreview: &deployments.DeploymentPreview{
+				ID: "ID:678",
+				Artifact: deployments.ArtifactPreview{
+					ID:                    validUUIDv4,
+					ArtifactName:          "foo-artifact",
+					DeviceTypesCompatible: []string{"hammer"},
+				},
+			},
+		},
+		{
+			// artifact already assigned for the same device type
+			InputDeviceDeployment: &deployments.DeviceDeployment{
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+				DeviceType:   StringToPointer("hammer"),
+				Image:        image,
+			},
+			InputInstalled:     deployments.InstalledDeviceDeployment{DeviceType: "hammer"},
+			InputArtifactError: errors.New("not called"),
+			OutputPreview: &deployments.DeploymentPreview{
+				ID: "ID:678",
+				Artifact: deployments.ArtifactPreview{
+					ID:                    validUUIDv4,
+					ArtifactName:          "foo-artifact",
+					DeviceTypesCompatible: []string{"hammer"},
+				},
+			},
+		},
+		{
+			// delta from the installed artifact is preferred
+			InputDeviceDeployment: &deployments.DeviceDeployment{
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+			},
+			InputInstalled: deployments.InstalledDeviceDeployment{
+				Artifact:   "bar-artifact",
+				DeviceType: "hammer",
+			},
+			InputArtifact: image,
+			InputDelta:    delta,
+			OutputPreview: &deployments.DeploymentPreview{
+				ID: "ID:678",
+				Artifact: deployments.ArtifactPreview{
+					ID:                    delta.Id,
+					ArtifactName:          "foo-artifact",
+					DeviceTypesCompatible: []string{"hammer"},
+				},
+			},
+		},
+		{
+			// assigned delta does not apply to the installed artifact,
+			// full artifact is selected
+			InputDeviceDeployment: &deployments.DeviceDeployment{
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+				DeviceType:   StringToPointer("hammer"),
+				Image:        delta,
+			},
+			InputInstalled: deployments.InstalledDeviceDeployment{
+				Artifact:   "baz-artifact",
+				DeviceType: "hammer",
+			},
+			InputArtifact: image,
+			OutputPreview: &deployments.DeploymentPreview{
+				ID: "ID:678",
+				Artifact: deployments.ArtifactPreview{
+					ID:                    validUUIDv4,
+					ArtifactName:          "foo-artifact",
+					DeviceTypesCompatible: []string{"hammer"},
+				},
+			},
+		},
+		{
+			InputDeviceDeployment: &deployments.DeviceDeployment{
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+			},
+			InputInstalled: deployments.InstalledDeviceDeployment{
+				Artifact:   "bar-artifact",
+				DeviceType: "hammer",
+			},
+			InputDeltaError: errors.New("images error"),
+			OutputError:     errors.New("Selecting artifact for the device: Searching for delta artifact: images error"),
+		},
+		{
+			// expired delta, full artifact is selected
+			InputDeviceDeployment: &deployments.DeviceDeployment{
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+			},
+			InputInstalled: deployments.InstalledDeviceDeployment{
+				Artifact:   "bar-artifact",
+				DeviceType: "hammer",
+			},
+			InputArtifact: image,
+			InputDelta:    &expiredDelta,
+			OutputPreview: &deployments.DeploymentPreview{
+				ID: "ID:678",
+				Artifact: deployments.ArtifactPreview{
+					ID:                    validUUIDv4,
+					ArtifactName:          "foo-artifact",
+					DeviceTypesCompatible: []string{"hammer"},
+				},
+			},
+		},
+		{
+			// deprecated delta is not deployed when blocked,
+			// full artifact is selected
+			InputDeviceDeployment: &deployments.DeviceDeployment{
+				DeviceId:     StringToPointer("ID:123"),
+				DeploymentId: StringToPointer("ID:678"),
+			},
+			InputInstalled: deployments.InstalledDeviceDeployment{
+				Artifact:   "bar-artifact",
+				DeviceType: "hammer",
+			},
+			InputBlockDeprecated: true,
+			InputArtifact:        image,
+			InputDelta:           &deprecatedDelta,
+			OutputPreview: &deployments.DeploymentPreview{
+				ID: "ID:678",
+				Artifact: deployments.ArtifactPreview{
+					ID:                    validUUIDv4,
+					ArtifactName:          "foo-artifact",
+					DeviceTypesCompatible: []string{"hammer"},
+				},
+			},
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+			deviceDeploymentStorage.On("FindOldestDeploymentForDeviceIDWithStatuses",
+				h.ContextMatcher(),
+				"ID:123", mock.AnythingOfType("[]string")).
+				Return(testCase.InputDeviceDeployment,
+					testCase.InputDeviceDeploymentError)
+
+			deploymentStorage := new(mocks.DeploymentsStorage)
+			deploymentStorage.On("FindByID",
+				h.ContextMatcher(),
+				"ID:678").
+				Return(&deployments.Deployment{
+					Id:        StringToPointer("ID:678"),
+					Artifacts: []string{validUUIDv4},
+					DeploymentConstructor: &deployments.DeploymentConstructor{
+						ArtifactName: StringToPointer("foo-artifact"),
+					},
+				}, nil)
+
+			artifactGetter := new(mocks.ArtifactGetter)
+			artifactGetter.On("ImageByIdsAndDeviceType",
+				h.ContextMatcher(),
+				[]string{validUUIDv4},
+				mock.AnythingOfType("string")).
+				Return(testCase.InputArtifact, testCase.InputArtifactError)
+			artifactGetter.On("DeltaImageByNameAndDeviceType",
+				h.ContextMatcher(),
+				mock.AnythingOfType("string"),
+				"foo-artifact",
+				mock.AnythingOfType("string")).
+				Return(testCase.InputDelta, testCase.InputDeltaError)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
+				DeploymentsStorage:       deploymentStorage,
+				ArtifactGetter:           artifactGetter,
+				BlockDeprecated:          testCase.InputBlockDeprecated,
+			})
+
+			out, err := model.PreviewDeploymentForDevice(context.Background(),
+				"ID:123", testCase.InputInstalled)
+			if testCase.OutputError != nil {
+				assert.EqualError(t, err, testCase.OutputError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, testCase.OutputPreview, out)
+
+			// device deployment state is left untouched
+			deviceDeploymentStorage.AssertNotCalled(t, "AssignArtifact",
+				mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			deviceDeploymentStorage.AssertNotCalled(t, "UpdateDeviceDeploymentStatus",
+				mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
 func TestDeploymentModelCreateDeployment(t *testing.T) {
 
 	//t.Parallel()
@@ -483,9 +911,12 @@ func TestDeploymentModelCreateDeployment(t *testing.T) {
 		InputDeviceDeploymentStorageInsertManyError error
 		InputDeploymentStorageDeleteError           error
 		InputImagesByNameError                      error
+		InputDeviceTypes                            map[string]string
+		InputFindLatestDeviceTypesError             error
 
-		OutputError error
-		OutputBody  bool
+		OutputError    error
+		OutputBody     bool
+		OutputWarnings []deployments.PlannedDevice
 	}{
 		{
 			OutputError: controller.ErrModelMissingInput,
@@ -531,8 +962,9 @@ func TestDeploymentModelCreateDeployment(t *testing.T) {
 				ArtifactName: StringToPointer("App 123"),
 				Devices:      []string{"b532b01a-9313-404f-8d19-e7fcbe5cc347"},
 			},
+			InputFindLatestDeviceTypesError: errors.New("find error"),
 
-			OutputBody: true,
+			OutputError: errors.New("Searching for device types: find error"),
 		},
 		{
 			InputConstructor: &deployments.DeploymentConstructor{
@@ -541,7 +973,31 @@ func TestDeploymentModelCreateDeployment(t *testing.T) {
 				Devices:      []string{"b532b01a-9313-404f-8d19-e7fcbe5cc347"},
 			},
 
+			OutputBody:     true,
+			OutputWarnings: []deployments.PlannedDevice{},
+		},
+		{
+			InputConstructor: &deployments.DeploymentConstructor{
+				Name:         StringToPointer("NYC Production"),
+				ArtifactName: StringToPointer("App 123"),
+				Devices: []string{
+					"b532b01a-9313-404f-8d19-e7fcbe5cc347",
+					"b532b01a-9313-404f-8d19-e7fcbe5cc348",
+					"b532b01a-9313-404f-8d19-e7fcbe5cc349",
+				},
+			},
+			InputDeviceTypes: map[string]string{
+				"b532b01a-9313-404f-8d19-e7fcbe5cc347": "hammer",
+				"b532b01a-9313-404f-8d19-e7fcbe5cc348": "screwdriver",
+			},
+
 			OutputBody: true,
+			OutputWarnings: []deployments.PlannedDevice{
+				{
+					DeviceId:   "b532b01a-9313-404f-8d19-e7fcbe5cc348",
+					DeviceType: "screwdriver",
+				},
+			},
 		},
 	}
 
@@ -563,6 +1019,11 @@ func TestDeploymentModelCreateDeployment(t *testing.T) {
 				h.ContextMatcher(),
 				mock.AnythingOfType("[]*deployments.DeviceDeployment")).
 				Return(testCase.InputDeviceDeploymentStorageInsertManyError)
+			deviceDeploymentStorage.On("FindLatestDeviceTypes",
+				h.ContextMatcher(),
+				mock.AnythingOfType("[]string")).
+				Return(testCase.InputDeviceTypes,
+					testCase.InputFindLatestDeviceTypesError)
 
 			artifactGetter := new(mocks.ArtifactGetter)
 			artifactGetter.On("ImagesByName",
@@ -590,205 +1051,843 @@ func TestDeploymentModelCreateDeployment(t *testing.T) {
 			out, err := model.CreateDeployment(context.Background(), testCase.InputConstructor)
 			if testCase.OutputError != nil {
 				assert.EqualError(t, err, testCase.OutputError.Error())
+				assert.Nil(t, out)
 			} else {
 				assert.NoError(t, err)
 			}
 			if testCase.OutputBody {
 				assert.NotNil(t, out)
+				assert.NotEmpty(t, out.Id)
+				assert.Equal(t, testCase.OutputWarnings, out.Warnings)
+				assert.Equal(t, []string{}, out.DeprecatedArtifacts)
 			}
 		})
 	}
 
 }
 
-func TestDeploymentModelUpdateDeviceDeploymentStatus(t *testing.T) {
+func TestDeploymentModelCreateDeploymentCanary(t *testing.T) {
 
 	//t.Parallel()
 
-	testCases := []struct {
-		InputDeployment *deployments.Deployment
-		InputDeviceID   string
-		OldStatus       string
-		InputStatus     string
+	devices := []string{
+		"b532b01a-9313-404f-8d19-e7fcbe5cc347",
+		"b532b01a-9313-404f-8d19-e7fcbe5cc348",
+		"b532b01a-9313-404f-8d19-e7fcbe5cc349",
+		"b532b01a-9313-404f-8d19-e7fcbe5cc350",
+	}
+	deviceTypes := map[string]string{
+		"b532b01a-9313-404f-8d19-e7fcbe5cc347": "hammer",
+		"b532b01a-9313-404f-8d19-e7fcbe5cc348": "hammer",
+		"b532b01a-9313-404f-8d19-e7fcbe5cc349": "hammer",
+		"b532b01a-9313-404f-8d19-e7fcbe5cc350": "hammer",
+	}
 
-		InputDevsStorageError error
-		InputDepsStorageError error
-		InputDepsFinishError  error
-		InputDepsFindError    error
+	testCases := map[string]struct {
+		InputCanary *deployments.CanarySpec
 
-		isFinished bool
+		OutputError  error
+		OutputCanary []string
+	}{
+		"no canary": {},
+		"half of the devices": {
+			InputCanary: &deployments.CanarySpec{Percentage: 50, Seed: 1},
 
-		OutputError error
+			OutputCanary: (&deployments.CanarySpec{Percentage: 50, Seed: 1}).
+				Select(devices),
+		},
+		"device type": {
+			InputCanary: &deployments.CanarySpec{
+				Percentage: 100,
+				DeviceType: "hammer",
+			},
+
+			OutputCanary: (&deployments.CanarySpec{Percentage: 100}).
+				Select(devices),
+		},
+		"no eligible devices": {
+			InputCanary: &deployments.CanarySpec{
+				Percentage: 50,
+				DeviceType: "screwdriver",
+			},
+
+			OutputError: controller.ErrNoCanaryDevices,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+
+			deploymentStorage := new(mocks.DeploymentsStorage)
+			deploymentStorage.On("Insert",
+				h.ContextMatcher(),
+				mock.AnythingOfType("*deployments.Deployment")).
+				Return(nil)
+
+			var inserted []*deployments.DeviceDeployment
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+			deviceDeploymentStorage.On("InsertMany",
+				h.ContextMatcher(),
+				mock.AnythingOfType("[]*deployments.DeviceDeployment")).
+				Run(func(args mock.Arguments) {
+					inserted = args.Get(1).([]*deployments.DeviceDeployment)
+				}).
+				Return(nil)
+			deviceDeploymentStorage.On("FindLatestDeviceTypes",
+				h.ContextMatcher(),
+				mock.AnythingOfType("[]string")).
+				Return(deviceTypes, nil)
+
+			artifactGetter := new(mocks.ArtifactGetter)
+			artifactGetter.On("ImagesByName",
+				h.ContextMatcher(),
+				mock.AnythingOfType("string")).
+				Return(
+					[]*images.SoftwareImage{images.NewSoftwareImage(
+						validUUIDv4,
+						&images.SoftwareImageMetaConstructor{},
+						&images.SoftwareImageMetaArtifactConstructor{
+							Name: "App 123",
+							DeviceTypesCompatible: []string{
+								"hammer",
+							},
+						})},
+					nil)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeploymentsStorage:       deploymentStorage,
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
+				ArtifactGetter:           artifactGetter,
+			})
+
+			out, err := model.CreateDeployment(context.Background(),
+				&deployments.DeploymentConstructor{
+					Name:         StringToPointer("NYC Production"),
+					ArtifactName: StringToPointer("App 123"),
+					Devices:      devices,
+					Canary:       testCase.InputCanary,
+				})
+			if testCase.OutputError != nil {
+				assert.EqualError(t, err, testCase.OutputError.Error())
+				assert.Nil(t, out)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.NotNil(t, out)
+			assert.Equal(t, testCase.OutputCanary, out.Canary)
+
+			assert.Len(t, inserted, len(devices))
+			for _, dd := range inserted {
+				canary := false
+				for _, id := range testCase.OutputCanary {
+					if id == *dd.DeviceId {
+						canary = true
+					}
+				}
+				assert.Equal(t, testCase.InputCanary != nil && !canary, dd.Held)
+			}
+		})
+	}
+}
+
+func TestDeploymentModelCreateDeploymentWithIdempotencyKey(t *testing.T) {
+
+	//t.Parallel()
+
+	original := deployments.NewDeployment()
+
+	testCases := []struct {
+		InputKey    string
+		InputWindow time.Duration
+
+		InputFindByIdempotencyKeyDeployment *deployments.Deployment
+		InputFindByIdempotencyKeyError      error
+		InputReleaseIdempotencyKeyError     error
+		InputInsertError                    error
+		InputFindAfterInsertDeployment      *deployments.Deployment
+
+		OutputKey     string
+		OutputCreated bool
+		OutputError   error
 	}{
 		{
-			InputDeployment: &deployments.Deployment{
-				Id: StringToPointer("123"),
-				Stats: deployments.Stats{
-					deployments.DeviceDeploymentStatusPending: 1,
-				},
-			},
-			InputDeviceID: "123",
-			InputStatus:   "installing",
-			OldStatus:     "pending",
+			InputKey:    "key-1",
+			InputWindow: time.Hour,
 
-			InputDevsStorageError: errors.New("device deployments storage issue"),
-			InputDepsStorageError: nil,
+			OutputKey:     "key-1",
+			OutputCreated: true,
+		},
+		{
+			InputKey:    "key-1",
+			InputWindow: time.Hour,
 
-			OutputError: errors.New("device deployments storage issue"),
+			InputFindByIdempotencyKeyDeployment: original,
 		},
 		{
-			InputDeployment: &deployments.Deployment{
-				Id: StringToPointer("234"),
-				Stats: deployments.Stats{
-					deployments.DeviceDeploymentStatusPending: 1,
-				},
-			},
-			InputDeviceID: "234",
-			InputStatus:   "none",
-			OldStatus:     "pending",
+			InputKey:    "key-1",
+			InputWindow: time.Hour,
 
-			InputDevsStorageError: nil,
-			InputDepsStorageError: errors.New("deployments storage issue"),
+			InputFindByIdempotencyKeyError: errors.New("find error"),
 
-			OutputError: errors.New("deployments storage issue"),
+			OutputError: errors.New("Searching for deployment by idempotency key: find error"),
 		},
 		{
-			isFinished: true,
-			InputDeployment: &deployments.Deployment{
-				Id: StringToPointer("345"),
-				Stats: deployments.Stats{
-					deployments.DeviceDeploymentStatusSuccess: 1,
-				},
-			},
-			InputDeviceID: "345",
-			InputStatus:   "success",
-			OldStatus:     "installing",
+			InputKey:    "key-1",
+			InputWindow: time.Hour,
 
-			InputDevsStorageError: nil,
+			InputReleaseIdempotencyKeyError: errors.New("update error"),
+
+			OutputError: errors.New("Releasing expired idempotency key: update error"),
 		},
 		{
-			isFinished: false,
-			InputDeployment: &deployments.Deployment{
-				Id: StringToPointer("345"),
-				Stats: deployments.Stats{
-					deployments.DeviceDeploymentStatusSuccess: 1,
-				},
-			},
-			InputDeviceID: "345",
-			InputStatus:   "installing",
-			OldStatus:     "installing",
+			// concurrent request created the deployment first
+			InputKey:    "key-1",
+			InputWindow: time.Hour,
 
-			InputDevsStorageError: nil,
+			InputInsertError:               deployments.ErrDuplicateIdempotencyKey,
+			InputFindAfterInsertDeployment: original,
 		},
 		{
-			isFinished: true,
-			InputDeployment: &deployments.Deployment{
-				Id: StringToPointer("456"),
-				Stats: deployments.Stats{
-					deployments.DeviceDeploymentStatusAlreadyInst: 1,
-				},
-			},
-			InputDeviceID: "456",
-			InputStatus:   "already-installed",
-			OldStatus:     "pending",
+			InputKey:    "key-1",
+			InputWindow: time.Hour,
 
-			InputDevsStorageError: nil,
+			InputInsertError: deployments.ErrDuplicateIdempotencyKey,
+
+			OutputError: errors.New("Storing deployment data: " +
+				deployments.ErrDuplicateIdempotencyKey.Error()),
 		},
 		{
-			isFinished: true,
-			InputDeployment: &deployments.Deployment{
-				Id: StringToPointer("567"),
-				Stats: deployments.Stats{
-					deployments.DeviceDeploymentStatusAborted: 1,
-				},
-			},
-			InputDeviceID: "567",
-			InputStatus:   "aborted",
-			OldStatus:     "pending",
+			InputKey:    "key-1",
+			InputWindow: time.Hour,
+
+			InputInsertError: errors.New("insert error"),
+
+			OutputError: errors.New("Storing deployment data: insert error"),
 		},
 		{
-			isFinished: true,
-			InputDeployment: &deployments.Deployment{
-				Id: StringToPointer("678"),
-				Stats: deployments.Stats{
-					deployments.DeviceDeploymentStatusSuccess: 1,
-				},
-			},
-			InputDeviceID: "678",
-			InputStatus:   "success",
-			OldStatus:     "rebooting",
+			// keys are neither looked up nor stored without the window
+			InputKey: "key-1",
 
-			InputDepsFinishError: errors.New("deployments storage finish issue"),
-			OutputError:          errors.New("failed to mark deployment as finished: deployments storage finish issue"),
+			InputFindByIdempotencyKeyDeployment: original,
+
+			OutputCreated: true,
 		},
 		{
-			isFinished: true,
-			InputDeployment: &deployments.Deployment{
-				Id: StringToPointer("789"),
-				Stats: deployments.Stats{
-					deployments.DeviceDeploymentStatusAborted: 1,
-				},
-			},
-			InputDeviceID: "789",
-			InputStatus:   "rebooting",
-			OldStatus:     "aborted",
+			InputWindow: time.Hour,
 
-			OutputError: controller.ErrDeploymentAborted,
+			InputFindByIdempotencyKeyDeployment: original,
+
+			OutputCreated: true,
 		},
 	}
 
 	for testCaseNumber, testCase := range testCases {
 		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
 
-			t.Logf("testing %s %s %s (in) %s (old) %v %v",
-				*testCase.InputDeployment.Id,
-				testCase.InputDeviceID, testCase.InputStatus,
-				testCase.OldStatus,
-				testCase.InputDevsStorageError, testCase.isFinished)
+			deploymentStorage := new(mocks.DeploymentsStorage)
+			deploymentStorage.On("FindByIdempotencyKey",
+				h.ContextMatcher(),
+				testCase.InputKey,
+				mock.AnythingOfType("time.Time")).
+				Return(testCase.InputFindByIdempotencyKeyDeployment,
+					testCase.InputFindByIdempotencyKeyError).
+				Once()
+			deploymentStorage.On("FindByIdempotencyKey",
+				h.ContextMatcher(),
+				testCase.InputKey,
+				mock.AnythingOfType("time.Time")).
+				Return(testCase.InputFindAfterInsertDeployment, nil)
+			deploymentStorage.On("ReleaseIdempotencyKey",
+				h.ContextMatcher(),
+				testCase.InputKey,
+				mock.AnythingOfType("time.Time")).
+				Return(testCase.InputReleaseIdempotencyKeyError)
+			deploymentStorage.On("Insert",
+				h.ContextMatcher(),
+				mock.AnythingOfType("*deployments.Deployment")).
+				Return(testCase.InputInsertError)
 
-			// status is always fetched
 			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
-			deviceDeploymentStorage.On("GetDeviceDeploymentStatus",
+			deviceDeploymentStorage.On("InsertMany",
 				h.ContextMatcher(),
-				*testCase.InputDeployment.Id, testCase.InputDeviceID).
-				Return(testCase.OldStatus, testCase.InputDevsStorageError)
+				mock.AnythingOfType("[]*deployments.DeviceDeployment")).
+				Return(nil)
+			deviceDeploymentStorage.On("FindLatestDeviceTypes",
+				h.ContextMatcher(),
+				mock.AnythingOfType("[]string")).
+				Return(map[string]string{
+					"b532b01a-9313-404f-8d19-e7fcbe5cc347": "screwdriver",
+				}, nil)
 
-			deploymentStorage := new(mocks.DeploymentsStorage)
+			artifactGetter := new(mocks.ArtifactGetter)
+			artifactGetter.On("ImagesByName",
+				h.ContextMatcher(),
+				mock.AnythingOfType("string")).
+				Return([]*images.SoftwareImage{images.NewSoftwareImage(
+					validUUIDv4,
+					&images.SoftwareImageMetaConstructor{},
+					&images.SoftwareImageMetaArtifactConstructor{
+						Name: "App 123",
+						DeviceTypesCompatible: []string{
+							"hammer",
+						},
+					})}, nil)
 
-			// however any status updates in DB are done only if the
-			// status reported by device is different from the
-			// previous one in DB
-			if testCase.OldStatus != testCase.InputStatus {
-				deviceDeploymentStorage.On("UpdateDeviceDeploymentStatus",
-					h.ContextMatcher(),
-					testCase.InputDeviceID, *testCase.InputDeployment.Id,
-					mock.MatchedBy(func(ddStatus deployments.DeviceDeploymentStatus) bool {
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeploymentsStorage:       deploymentStorage,
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
+				ArtifactGetter:           artifactGetter,
+				IdempotencyWindow:        testCase.InputWindow,
+			})
 
-						statusOk := assert.Equal(t, testCase.InputStatus, ddStatus.Status)
-						finishOk := true
-						if testCase.isFinished {
-							finishOk = assert.NotNil(t, ddStatus.FinishTime) &&
-								assert.WithinDuration(t, time.Now(),
-									*ddStatus.FinishTime, time.Second)
-						}
-						return statusOk && finishOk
-					})).
-					Return("dontcare", testCase.InputDevsStorageError)
+			deployment, created, err := model.CreateDeploymentWithIdempotencyKey(
+				context.Background(),
+				&deployments.DeploymentConstructor{
+					Name:         StringToPointer("NYC Production"),
+					ArtifactName: StringToPointer("App 123"),
+					Devices:      []string{"b532b01a-9313-404f-8d19-e7fcbe5cc347"},
+				},
+				testCase.InputKey)
+			assert.Equal(t, testCase.OutputCreated, created != nil)
+			if testCase.OutputError != nil {
+				assert.EqualError(t, err, testCase.OutputError.Error())
+				assert.Nil(t, deployment)
+			} else if testCase.OutputCreated {
+				assert.NoError(t, err)
+				assert.NotEqual(t, original.Id, deployment.Id)
+				assert.Equal(t, testCase.OutputKey, deployment.IdempotencyKey)
+				assert.Equal(t, *deployment.Id, created.Id)
+				assert.Equal(t, []deployments.PlannedDevice{{
+					DeviceId:   "b532b01a-9313-404f-8d19-e7fcbe5cc347",
+					DeviceType: "screwdriver",
+				}}, created.Warnings)
+				deploymentStorage.AssertCalled(t, "Insert",
+					h.ContextMatcher(), deployment)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, original, deployment)
+				if testCase.InputInsertError == nil {
+					deploymentStorage.AssertNotCalled(t, "Insert",
+						h.ContextMatcher(), mock.Anything)
+				}
+			}
+		})
+	}
+}
 
-				deploymentStorage.On("UpdateStats",
-					h.ContextMatcher(),
-					*testCase.InputDeployment.Id, mock.AnythingOfType("string"),
-					mock.AnythingOfType("string")).
-					Return(testCase.InputDepsStorageError)
-				// deployment will be marked as finished when possible, for this we need to
-				// mock a couple of additional calls
-				deploymentStorage.On("FindByID",
-					h.ContextMatcher(),
-					*testCase.InputDeployment.Id).
-					Return(testCase.InputDeployment, testCase.InputDepsFindError)
-				if testCase.isFinished {
-					deploymentStorage.On("Finish",
-						h.ContextMatcher(),
+func TestDeploymentModelPlanDeployment(t *testing.T) {
+
+	t.Parallel()
+
+	constructor := &deployments.DeploymentConstructor{
+		Name:         StringToPointer("NYC Production"),
+		ArtifactName: StringToPointer("App 123"),
+		Devices:      []string{"device-1", "device-2", "device-3"},
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	expiredArtifact := images.NewSoftwareImage(
+		"0c13a0e6-6b63-475d-8260-ee42a590e8ff",
+		&images.SoftwareImageMetaConstructor{ExpiresAt: &expired},
+		&images.SoftwareImageMetaArtifactConstructor{
+			Name:                  "App 123",
+			DeviceTypesCompatible: []string{"drill"},
+		})
+
+	testCases := []struct {
+		InputConstructor      *deployments.DeploymentConstructor
+		InputArtifacts        []*images.SoftwareImage
+		InputDeviceTypes      map[string]string
+		InputDeviceTypesError error
+
+		OutputPlan  *deployments.DeploymentPlan
+		OutputError error
+	}{
+		{
+			OutputError: controller.ErrModelMissingInput,
+		},
+		{
+			InputConstructor: constructor,
+			OutputError:      controller.ErrNoArtifact,
+		},
+		{
+			InputConstructor: constructor,
+			InputArtifacts:   []*images.SoftwareImage{expiredArtifact},
+			OutputError:      controller.ErrArtifactExpired,
+		},
+		{
+			InputConstructor: constructor,
+			InputArtifacts: []*images.SoftwareImage{images.NewSoftwareImage(
+				validUUIDv4,
+				&images.SoftwareImageMetaConstructor{},
+				&images.SoftwareImageMetaArtifactConstructor{
+					Name:                  "App 123",
+					DeviceTypesCompatible: []string{"hammer"},
+				})},
+			InputDeviceTypesError: errors.New("storage error"),
+			OutputError:           errors.New("Searching for device types: storage error"),
+		},
+		{
+			InputConstructor: constructor,
+			InputArtifacts: []*images.SoftwareImage{images.NewSoftwareImage(
+				validUUIDv4,
+				&images.SoftwareImageMetaConstructor{},
+				&images.SoftwareImageMetaArtifactConstructor{
+					Name:                  "App 123",
+					DeviceTypesCompatible: []string{"hammer"},
+				})},
+			InputDeviceTypes: map[string]string{
+				"device-1": "hammer",
+				"device-2": "drill",
+			},
+			OutputPlan: &deployments.DeploymentPlan{
+				Name:         "NYC Production",
+				ArtifactName: "App 123",
+				Artifacts:    []string{validUUIDv4},
+				Devices:      []string{"device-1", "device-2", "device-3"},
+				Skipped: []deployments.PlannedDevice{
+					{DeviceId: "device-2", DeviceType: "drill"},
+				},
+				UnknownDeviceType:   []string{"device-3"},
+				DeprecatedArtifacts: []string{},
+			},
+		},
+		{
+			// expired artifacts are not deployed
+			InputConstructor: constructor,
+			InputArtifacts: []*images.SoftwareImage{
+				images.NewSoftwareImage(
+					validUUIDv4,
+					&images.SoftwareImageMetaConstructor{},
+					&images.SoftwareImageMetaArtifactConstructor{
+						Name:                  "App 123",
+						DeviceTypesCompatible: []string{"hammer"},
+					}),
+				expiredArtifact,
+			},
+			InputDeviceTypes: map[string]string{
+				"device-1": "hammer",
+				"device-2": "drill",
+			},
+			OutputPlan: &deployments.DeploymentPlan{
+				Name:         "NYC Production",
+				ArtifactName: "App 123",
+				Artifacts:    []string{validUUIDv4},
+				Devices:      []string{"device-1", "device-2", "device-3"},
+				Skipped: []deployments.PlannedDevice{
+					{DeviceId: "device-2", DeviceType: "drill"},
+				},
+				UnknownDeviceType:   []string{"device-3"},
+				DeprecatedArtifacts: []string{},
+			},
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+
+			artifactGetter := new(mocks.ArtifactGetter)
+			artifactGetter.On("ImagesByName",
+				h.ContextMatcher(),
+				mock.AnythingOfType("string")).
+				Return(testCase.InputArtifacts, nil)
+
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+			deviceDeploymentStorage.On("FindLatestDeviceTypes",
+				h.ContextMatcher(),
+				mock.AnythingOfType("[]string")).
+				Return(testCase.InputDeviceTypes, testCase.InputDeviceTypesError)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
+				ArtifactGetter:           artifactGetter,
+			})
+
+			plan, err := model.PlanDeployment(context.Background(), testCase.InputConstructor)
+			if testCase.OutputError != nil {
+				assert.EqualError(t, err, testCase.OutputError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, testCase.OutputPlan, plan)
+
+			// nothing is stored
+			deviceDeploymentStorage.AssertNotCalled(t, "InsertMany", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestDeploymentModelPlanDeploymentRequireApproval(t *testing.T) {
+
+	constructor := &deployments.DeploymentConstructor{
+		Name:         StringToPointer("NYC Production"),
+		ArtifactName: StringToPointer("App 123"),
+		Devices:      []string{"device-1"},
+	}
+
+	newArtifact := func(id, state string) *images.SoftwareImage {
+		artifact := images.NewSoftwareImage(id,
+			&images.SoftwareImageMetaConstructor{},
+			&images.SoftwareImageMetaArtifactConstructor{
+				Name:                  "App 123",
+				DeviceTypesCompatible: []string{"hammer"},
+			})
+		artifact.State = state
+		return artifact
+	}
+
+	testCases := map[string]struct {
+		InputArtifacts []*images.SoftwareImage
+
+		OutputArtifacts []string
+		OutputError     error
+	}{
+		"not approved": {
+			InputArtifacts: []*images.SoftwareImage{
+				newArtifact(validUUIDv4, images.ImageStateUploaded),
+				newArtifact("0c13a0e6-6b63-475d-8260-ee42a590e8ff", images.ImageStateDeprecated),
+			},
+			OutputError: controller.ErrArtifactNotApproved,
+		},
+		"recorded before the states": {
+			InputArtifacts: []*images.SoftwareImage{newArtifact(validUUIDv4, "")},
+			OutputError:    controller.ErrArtifactNotApproved,
+		},
+		"approved only": {
+			InputArtifacts: []*images.SoftwareImage{
+				newArtifact(validUUIDv4, images.ImageStateApproved),
+				newArtifact("0c13a0e6-6b63-475d-8260-ee42a590e8ff", images.ImageStateDeprecated),
+			},
+			OutputArtifacts: []string{validUUIDv4},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+
+			artifactGetter := new(mocks.ArtifactGetter)
+			artifactGetter.On("ImagesByName",
+				h.ContextMatcher(),
+				mock.AnythingOfType("string")).
+				Return(testCase.InputArtifacts, nil)
+
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+			deviceDeploymentStorage.On("FindLatestDeviceTypes",
+				h.ContextMatcher(),
+				mock.AnythingOfType("[]string")).
+				Return(map[string]string{"device-1": "hammer"}, nil)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
+				ArtifactGetter:           artifactGetter,
+				RequireApproval:          true,
+			})
+
+			plan, err := model.PlanDeployment(context.Background(), constructor)
+			if testCase.OutputError != nil {
+				assert.Equal(t, testCase.OutputError, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.OutputArtifacts, plan.Artifacts)
+		})
+	}
+}
+
+func TestDeploymentModelPlanDeploymentDeprecated(t *testing.T) {
+
+	constructor := &deployments.DeploymentConstructor{
+		Name:         StringToPointer("NYC Production"),
+		ArtifactName: StringToPointer("App 123"),
+		Devices:      []string{"device-1"},
+	}
+
+	newArtifact := func(id string, state string) *images.SoftwareImage {
+		artifact := images.NewSoftwareImage(id,
+			&images.SoftwareImageMetaConstructor{},
+			&images.SoftwareImageMetaArtifactConstructor{
+				Name:                  "App 123",
+				DeviceTypesCompatible: []string{"hammer"},
+			})
+		artifact.State = state
+		return artifact
+	}
+
+	otherUUIDv4 := "0c13a0e6-6b63-475d-8260-ee42a590e8ff"
+
+	testCases := map[string]struct {
+		InputArtifacts       []*images.SoftwareImage
+		InputBlockDeprecated bool
+
+		OutputArtifacts  []string
+		OutputDeprecated []string
+		OutputError      error
+	}{
+		"none deprecated": {
+			InputArtifacts: []*images.SoftwareImage{
+				newArtifact(validUUIDv4, images.ImageStateUploaded),
+			},
+			OutputArtifacts:  []string{validUUIDv4},
+			OutputDeprecated: []string{},
+		},
+		"deprecated warned about": {
+			InputArtifacts: []*images.SoftwareImage{
+				newArtifact(validUUIDv4, images.ImageStateUploaded),
+				newArtifact(otherUUIDv4, images.ImageStateDeprecated),
+			},
+			OutputArtifacts:  []string{validUUIDv4, otherUUIDv4},
+			OutputDeprecated: []string{otherUUIDv4},
+		},
+		"deprecated state warned about": {
+			InputArtifacts: []*images.SoftwareImage{
+				newArtifact(validUUIDv4, images.ImageStateDeprecated),
+			},
+			OutputArtifacts:  []string{validUUIDv4},
+			OutputDeprecated: []string{validUUIDv4},
+		},
+		"deprecated blocked": {
+			InputArtifacts: []*images.SoftwareImage{
+				newArtifact(validUUIDv4, images.ImageStateUploaded),
+				newArtifact(otherUUIDv4, images.ImageStateDeprecated),
+			},
+			InputBlockDeprecated: true,
+
+			OutputArtifacts:  []string{validUUIDv4},
+			OutputDeprecated: []string{},
+		},
+		"all deprecated blocked": {
+			InputArtifacts: []*images.SoftwareImage{
+				newArtifact(validUUIDv4, images.ImageStateDeprecated),
+				newArtifact(otherUUIDv4, images.ImageStateDeprecated),
+			},
+			InputBlockDeprecated: true,
+
+			OutputError: controller.ErrArtifactDeprecated,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+
+			artifactGetter := new(mocks.ArtifactGetter)
+			artifactGetter.On("ImagesByName",
+				h.ContextMatcher(),
+				mock.AnythingOfType("string")).
+				Return(testCase.InputArtifacts, nil)
+
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+			deviceDeploymentStorage.On("FindLatestDeviceTypes",
+				h.ContextMatcher(),
+				mock.AnythingOfType("[]string")).
+				Return(map[string]string{"device-1": "hammer"}, nil)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
+				ArtifactGetter:           artifactGetter,
+				BlockDeprecated:          testCase.InputBlockDeprecated,
+			})
+
+			plan, err := model.PlanDeployment(context.Background(), constructor)
+			if testCase.OutputError != nil {
+				assert.Equal(t, testCase.OutputError, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.OutputArtifacts, plan.Artifacts)
+			assert.Equal(t, testCase.OutputDeprecated, plan.DeprecatedArtifacts)
+		})
+	}
+}
+
+func TestDeploymentModelUpdateDeviceDeploymentStatus(t *testing.T) {
+
+	//t.Parallel()
+
+	testCases := []struct {
+		InputDeployment *deployments.Deployment
+		InputDeviceID   string
+		OldStatus       string
+		InputStatus     string
+
+		InputDevsStorageError error
+		InputDepsStorageError error
+		InputDepsFinishError  error
+		InputDepsFindError    error
+
+		isFinished bool
+
+		OutputError error
+	}{
+		{
+			InputDeployment: &deployments.Deployment{
+				Id: StringToPointer("123"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusPending: 1,
+				},
+			},
+			InputDeviceID: "123",
+			InputStatus:   "installing",
+			OldStatus:     "pending",
+
+			InputDevsStorageError: errors.New("device deployments storage issue"),
+			InputDepsStorageError: nil,
+
+			OutputError: errors.New("device deployments storage issue"),
+		},
+		{
+			InputDeployment: &deployments.Deployment{
+				Id: StringToPointer("234"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusPending: 1,
+				},
+			},
+			InputDeviceID: "234",
+			InputStatus:   "none",
+			OldStatus:     "pending",
+
+			InputDevsStorageError: nil,
+			InputDepsStorageError: errors.New("deployments storage issue"),
+
+			OutputError: errors.New("deployments storage issue"),
+		},
+		{
+			isFinished: true,
+			InputDeployment: &deployments.Deployment{
+				Id: StringToPointer("345"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusSuccess: 1,
+				},
+			},
+			InputDeviceID: "345",
+			InputStatus:   "success",
+			OldStatus:     "installing",
+
+			InputDevsStorageError: nil,
+		},
+		{
+			isFinished: false,
+			InputDeployment: &deployments.Deployment{
+				Id: StringToPointer("345"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusSuccess: 1,
+				},
+			},
+			InputDeviceID: "345",
+			InputStatus:   "installing",
+			OldStatus:     "installing",
+
+			InputDevsStorageError: nil,
+		},
+		{
+			isFinished: true,
+			InputDeployment: &deployments.Deployment{
+				Id: StringToPointer("456"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusAlreadyInst: 1,
+				},
+			},
+			InputDeviceID: "456",
+			InputStatus:   "already-installed",
+			OldStatus:     "pending",
+
+			InputDevsStorageError: nil,
+		},
+		{
+			isFinished: true,
+			InputDeployment: &deployments.Deployment{
+				Id: StringToPointer("567"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusAborted: 1,
+				},
+			},
+			InputDeviceID: "567",
+			InputStatus:   "aborted",
+			OldStatus:     "pending",
+		},
+		{
+			isFinished: true,
+			InputDeployment: &deployments.Deployment{
+				Id: StringToPointer("678"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusSuccess: 1,
+				},
+			},
+			InputDeviceID: "678",
+			InputStatus:   "success",
+			OldStatus:     "rebooting",
+
+			InputDepsFinishError: errors.New("deployments storage finish issue"),
+			OutputError:          errors.New("failed to mark deployment as finished: deployments storage finish issue"),
+		},
+		{
+			isFinished: true,
+			InputDeployment: &deployments.Deployment{
+				Id: StringToPointer("789"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusAborted: 1,
+				},
+			},
+			InputDeviceID: "789",
+			InputStatus:   "rebooting",
+			OldStatus:     "aborted",
+
+			OutputError: controller.ErrDeploymentAborted,
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+
+			t.Logf("testing %s %s %s (in) %s (old) %v %v",
+				*testCase.InputDeployment.Id,
+				testCase.InputDeviceID, testCase.InputStatus,
+				testCase.OldStatus,
+				testCase.InputDevsStorageError, testCase.isFinished)
+
+			// status is always fetched
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+			deviceDeploymentStorage.On("GetDeviceDeploymentStatus",
+				h.ContextMatcher(),
+				*testCase.InputDeployment.Id, testCase.InputDeviceID).
+				Return(testCase.OldStatus, testCase.InputDevsStorageError)
+
+			deploymentStorage := new(mocks.DeploymentsStorage)
+
+			// however any status updates in DB are done only if the
+			// status reported by device is different from the
+			// previous one in DB
+			if testCase.OldStatus != testCase.InputStatus {
+				deviceDeploymentStorage.On("UpdateDeviceDeploymentStatus",
+					h.ContextMatcher(),
+					testCase.InputDeviceID, *testCase.InputDeployment.Id,
+					mock.MatchedBy(func(ddStatus deployments.DeviceDeploymentStatus) bool {
+
+						statusOk := assert.Equal(t, testCase.InputStatus, ddStatus.Status)
+						finishOk := true
+						if testCase.isFinished {
+							finishOk = assert.NotNil(t, ddStatus.FinishTime) &&
+								assert.WithinDuration(t, time.Now(),
+									*ddStatus.FinishTime, time.Second)
+						}
+						return statusOk && finishOk
+					})).
+					Return("dontcare", testCase.InputDevsStorageError)
+
+				deploymentStorage.On("UpdateStats",
+					h.ContextMatcher(),
+					*testCase.InputDeployment.Id, mock.AnythingOfType("string"),
+					mock.AnythingOfType("string")).
+					Return(testCase.InputDepsStorageError)
+				// deployment will be marked as finished when possible, for this we need to
+				// mock a couple of additional calls
+				deploymentStorage.On("FindByID",
+					h.ContextMatcher(),
+					*testCase.InputDeployment.Id).
+					Return(testCase.InputDeployment, testCase.InputDepsFindError)
+				if testCase.isFinished {
+					deploymentStorage.On("Finish",
+						h.ContextMatcher(),
 						*testCase.InputDeployment.Id,
 						mock.MatchedBy(func(tm time.Time) bool {
 							return assert.WithinDuration(t, time.Now(), tm, time.Second)
@@ -802,512 +1901,1545 @@ func TestDeploymentModelUpdateDeviceDeploymentStatus(t *testing.T) {
 				DeviceDeploymentsStorage: deviceDeploymentStorage,
 			})
 
-			err := model.UpdateDeviceDeploymentStatus(context.Background(),
-				*testCase.InputDeployment.Id,
-				testCase.InputDeviceID,
-				deployments.DeviceDeploymentStatus{
-					Status: testCase.InputStatus,
-				})
+			err := model.UpdateDeviceDeploymentStatus(context.Background(),
+				*testCase.InputDeployment.Id,
+				testCase.InputDeviceID,
+				deployments.DeviceDeploymentStatus{
+					Status: testCase.InputStatus,
+				})
+			if testCase.OutputError != nil {
+				assert.EqualError(t, err, testCase.OutputError.Error())
+			} else {
+				assert.NoError(t, err)
+
+				// make sure that storage calls were done as
+				// expected
+				mock.AssertExpectationsForObjects(t, deviceDeploymentStorage,
+					deploymentStorage)
+			}
+		})
+	}
+
+}
+
+func TestDeploymentModelUpdateDeviceDeploymentStatuses(t *testing.T) {
+	t.Parallel()
+
+	const (
+		running  = "a50eda0d-2cea-4de1-8d42-9cd3e7e8670d"
+		finished = "b50eda0d-2cea-4de1-8d42-9cd3e7e8670d"
+	)
+
+	updates := []deployments.DeviceStatusUpdate{
+		{DeploymentID: running, DeviceID: "applied",
+			Status: deployments.DeviceDeploymentStatusInstalling},
+		{DeploymentID: running, DeviceID: "unchanged",
+			Status: deployments.DeviceDeploymentStatusInstalling},
+		{DeploymentID: running, DeviceID: "aborted",
+			Status: deployments.DeviceDeploymentStatusInstalling},
+		{DeploymentID: running, DeviceID: "missing",
+			Status: deployments.DeviceDeploymentStatusInstalling},
+		{DeploymentID: running, DeviceID: "invalid",
+			Status: deployments.DeviceDeploymentStatusPending},
+		{DeploymentID: finished, DeviceID: "applied",
+			Status: deployments.DeviceDeploymentStatusSuccess},
+	}
+
+	deviceDeployment := func(deploymentID, deviceID, status string) deployments.DeviceDeployment {
+		return deployments.DeviceDeployment{
+			DeploymentId: StringToPointer(deploymentID),
+			DeviceId:     StringToPointer(deviceID),
+			Status:       StringToPointer(status),
+		}
+	}
+
+	deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+	deviceDeploymentStorage.On("FindDeviceDeployments", h.ContextMatcher(),
+		[]deployments.DeviceStatusUpdate{
+			updates[0], updates[1], updates[2], updates[3], updates[5],
+		}).
+		Return([]deployments.DeviceDeployment{
+			deviceDeployment(running, "applied",
+				deployments.DeviceDeploymentStatusDownloading),
+			deviceDeployment(running, "unchanged",
+				deployments.DeviceDeploymentStatusInstalling),
+			deviceDeployment(running, "aborted",
+				deployments.DeviceDeploymentStatusAborted),
+			deviceDeployment(finished, "applied",
+				deployments.DeviceDeploymentStatusInstalling),
+		}, nil)
+	deviceDeploymentStorage.On("UpdateDeviceDeploymentStatuses", h.ContextMatcher(),
+		[]deployments.DeviceStatusUpdate{updates[0], updates[5]},
+		mock.AnythingOfType("time.Time")).
+		Return(nil)
+
+	deploymentStorage := new(mocks.DeploymentsStorage)
+	deploymentStorage.On("UpdateStats", h.ContextMatcher(), running,
+		deployments.DeviceDeploymentStatusDownloading,
+		deployments.DeviceDeploymentStatusInstalling).
+		Return(nil)
+	deploymentStorage.On("FindByID", h.ContextMatcher(), running).
+		Return(&deployments.Deployment{
+			Id: StringToPointer(running),
+			Stats: deployments.Stats{
+				deployments.DeviceDeploymentStatusInstalling: 1,
+			},
+		}, nil)
+	deploymentStorage.On("UpdateStats", h.ContextMatcher(), finished,
+		deployments.DeviceDeploymentStatusInstalling,
+		deployments.DeviceDeploymentStatusSuccess).
+		Return(nil)
+	deploymentStorage.On("FindByID", h.ContextMatcher(), finished).
+		Return(&deployments.Deployment{
+			Id: StringToPointer(finished),
+			Stats: deployments.Stats{
+				deployments.DeviceDeploymentStatusSuccess: 1,
+			},
+		}, nil)
+	deploymentStorage.On("Finish", h.ContextMatcher(), finished,
+		mock.AnythingOfType("time.Time")).
+		Return(nil)
+
+	model := NewDeploymentModel(DeploymentsModelConfig{
+		DeploymentsStorage:       deploymentStorage,
+		DeviceDeploymentsStorage: deviceDeploymentStorage,
+	})
+
+	results, err := model.UpdateDeviceDeploymentStatuses(context.Background(), updates)
+	assert.NoError(t, err)
+	assert.Equal(t, []deployments.DeviceStatusUpdateResult{
+		{DeploymentID: running, DeviceID: "applied",
+			Result: deployments.StatusUpdateApplied},
+		{DeploymentID: running, DeviceID: "unchanged",
+			Result: deployments.StatusUpdateUnchanged},
+		{DeploymentID: running, DeviceID: "aborted",
+			Result: deployments.StatusUpdateRejected,
+			Error:  controller.ErrDeploymentAborted.Error()},
+		{DeploymentID: running, DeviceID: "missing",
+			Result: deployments.StatusUpdateRejected,
+			Error:  controller.ErrStorageNotFound.Error()},
+		{DeploymentID: running, DeviceID: "invalid",
+			Result: deployments.StatusUpdateRejected,
+			Error:  deployments.ErrInvalidReportedStatus.Error()},
+		{DeploymentID: finished, DeviceID: "applied",
+			Result: deployments.StatusUpdateApplied},
+	}, results)
+
+	mock.AssertExpectationsForObjects(t, deviceDeploymentStorage, deploymentStorage)
+}
+
+func TestGetDeploymentStats(t *testing.T) {
+
+	//t.Parallel()
+
+	testCases := []struct {
+		InputDeploymentID         string
+		InputModelDeploymentStats deployments.Stats
+		InputModelError           error
+
+		InoutFindByIDDeployment *deployments.Deployment
+		InoutFindByIDError      error
+
+		OutputStats deployments.Stats
+		OutputError error
+	}{
+		{
+			InputDeploymentID:         "ID:123",
+			InputModelDeploymentStats: nil,
+
+			InoutFindByIDDeployment: new(deployments.Deployment),
+
+			OutputStats: nil,
+		},
+		{
+			InputDeploymentID: "ID:234",
+			InputModelError:   errors.New("storage issue"),
+
+			InoutFindByIDDeployment: new(deployments.Deployment),
+
+			OutputError: errors.New("storage issue"),
+		},
+		{
+			InputDeploymentID: "ID:234",
+			InputModelError:   errors.New("storage issue"),
+
+			InoutFindByIDDeployment: nil,
+		},
+		{
+			InputDeploymentID: "ID:234",
+			InputModelError:   errors.New("storage issue"),
+
+			InoutFindByIDDeployment: new(deployments.Deployment),
+			InoutFindByIDError:      errors.New("an error"),
+
+			OutputError: errors.New("checking deployment id: an error"),
+		},
+		{
+			InputDeploymentID:       "ID:345",
+			InoutFindByIDDeployment: new(deployments.Deployment),
+			InputModelDeploymentStats: deployments.Stats{
+				deployments.DeviceDeploymentStatusPending:     2,
+				deployments.DeviceDeploymentStatusSuccess:     4,
+				deployments.DeviceDeploymentStatusFailure:     1,
+				deployments.DeviceDeploymentStatusInstalling:  3,
+				deployments.DeviceDeploymentStatusRebooting:   3,
+				deployments.DeviceDeploymentStatusDownloading: 3,
+				deployments.DeviceDeploymentStatusAlreadyInst: 0,
+			},
+
+			OutputStats: deployments.Stats{
+				deployments.DeviceDeploymentStatusDownloading: 3,
+				deployments.DeviceDeploymentStatusRebooting:   3,
+				deployments.DeviceDeploymentStatusInstalling:  3,
+				deployments.DeviceDeploymentStatusSuccess:     4,
+				deployments.DeviceDeploymentStatusFailure:     1,
+				deployments.DeviceDeploymentStatusPending:     2,
+				deployments.DeviceDeploymentStatusAlreadyInst: 0,
+			},
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+			t.Logf("testing %s %v %v", testCase.InputDeploymentID,
+				testCase.InputModelDeploymentStats, testCase.InputModelError)
+
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+			deviceDeploymentStorage.On("AggregateDeviceDeploymentByStatus",
+				h.ContextMatcher(),
+				testCase.InputDeploymentID).
+				Return(testCase.InputModelDeploymentStats, testCase.InputModelError)
+
+			deploymentStorage := new(mocks.DeploymentsStorage)
+			deploymentStorage.On("FindByID",
+				h.ContextMatcher(),
+				testCase.InputDeploymentID).
+				Return(testCase.InoutFindByIDDeployment, testCase.InoutFindByIDError)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeploymentsStorage:       deploymentStorage,
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
+			})
+
+			stats, err := model.GetDeploymentStats(context.Background(),
+				testCase.InputDeploymentID)
+
+			if testCase.OutputError != nil {
+				assert.EqualError(t, err, testCase.OutputError.Error())
+			} else {
+				assert.NoError(t, err)
+
+				assert.Equal(t, testCase.OutputStats, stats)
+			}
+		})
+	}
+}
+
+func TestDeploymentModelGetDeploymentsStats(t *testing.T) {
+	ids := []string{
+		"30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+		"ee13ea8b-a6d3-4d4c-99a6-bcfcaebc7ec3",
+	}
+	stats := []deployments.DeploymentStats{
+		{DeploymentID: ids[1], Stats: deployments.NewDeviceDeploymentStats()},
+	}
+	lookup := &deployments.DeploymentsStatsLookup{
+		Deployments: stats,
+		Missing:     []string{ids[0]},
+	}
+
+	deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+	deviceDeploymentStorage.On("AggregateDeviceDeploymentByStatusForDeployments",
+		h.ContextMatcher(), ids).
+		Return(stats, nil).Once()
+	deviceDeploymentStorage.On("AggregateDeviceDeploymentByStatusForDeployments",
+		h.ContextMatcher(), ids).
+		Return(nil, errors.New("storage issue"))
+
+	model := NewDeploymentModel(DeploymentsModelConfig{
+		DeviceDeploymentsStorage: deviceDeploymentStorage,
+	})
+
+	out, err := model.GetDeploymentsStats(context.Background(), ids)
+	assert.NoError(t, err)
+	assert.Equal(t, lookup, out)
+
+	_, err = model.GetDeploymentsStats(context.Background(), ids)
+	assert.EqualError(t, err, "counting device deployments by status: storage issue")
+}
+
+func TestDeploymentModelGetArtifactStats(t *testing.T) {
+	query := deployments.ArtifactStatsQuery{
+		FinishedAfter: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	stats := []deployments.ArtifactStats{
+		{ArtifactName: "app-1", Success: 12, Failure: 2},
+	}
+
+	deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+	deviceDeploymentStorage.On("AggregateDeviceDeploymentByArtifact",
+		h.ContextMatcher(), query).
+		Return(stats, nil).Once()
+	deviceDeploymentStorage.On("AggregateDeviceDeploymentByArtifact",
+		h.ContextMatcher(), query).
+		Return(nil, errors.New("storage issue"))
+
+	model := NewDeploymentModel(DeploymentsModelConfig{
+		DeviceDeploymentsStorage: deviceDeploymentStorage,
+	})
+
+	out, err := model.GetArtifactStats(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Equal(t, stats, out)
+
+	_, err = model.GetArtifactStats(context.Background(), query)
+	assert.EqualError(t, err, "counting device deployments by artifact: storage issue")
+}
+
+func TestDeploymentModelGetDeviceStatusesForDeployment(t *testing.T) {
+	//t.Parallel()
+
+	testCases := map[string]struct {
+		inDeploymentId string
+
+		devsStorageStatuses []deployments.DeviceDeployment
+		devsStorageErr      error
+
+		depsStorageDeployment *deployments.Deployment
+		depsStorageErr        error
+
+		modelErr error
+	}{
+		"existing deployment with statuses": {
+			inDeploymentId: "30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+
+			devsStorageStatuses: []deployments.DeviceDeployment{
+				*deployments.NewDeviceDeployment("dev0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
+				*deployments.NewDeviceDeployment("dev0002", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
+				*deployments.NewDeviceDeployment("dev0003", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
+			},
+			devsStorageErr: nil,
+
+			depsStorageDeployment: &deployments.Deployment{},
+			depsStorageErr:        nil,
+
+			modelErr: nil,
+		},
+		"deployment doesn't exist": {
+			devsStorageStatuses: []deployments.DeviceDeployment{
+				*deployments.NewDeviceDeployment("dev0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
+				*deployments.NewDeviceDeployment("dev0002", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
+				*deployments.NewDeviceDeployment("dev0003", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
+			},
+			devsStorageErr: nil,
+
+			depsStorageDeployment: nil,
+			depsStorageErr:        nil,
+
+			modelErr: controller.ErrModelDeploymentNotFound,
+		},
+		"DeviceDeployments storage layer error": {
+			inDeploymentId: "30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+
+			devsStorageStatuses: nil,
+			devsStorageErr:      errors.New("some verbose, low-level db error"),
+
+			depsStorageDeployment: &deployments.Deployment{},
+			depsStorageErr:        nil,
+
+			modelErr: errors.New("Internal error"),
+		},
+		"Deployments storage layer error": {
+			inDeploymentId: "30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+
+			devsStorageStatuses: nil,
+			devsStorageErr:      nil,
+
+			depsStorageDeployment: nil,
+			depsStorageErr:        errors.New("some verbose, low-level db error"),
+
+			modelErr: errors.New("Internal error"),
+		},
+	}
+
+	for testCaseName, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
+
+			devsDb := new(mocks.DeviceDeploymentStorage)
+
+			devsDb.On("GetDeviceStatusesForDeployment",
+				h.ContextMatcher(), tc.inDeploymentId).
+				Return(tc.devsStorageStatuses, tc.devsStorageErr)
+
+			depsDb := new(mocks.DeploymentsStorage)
+
+			depsDb.On("FindByID", h.ContextMatcher(), tc.inDeploymentId).
+				Return(tc.depsStorageDeployment, tc.depsStorageErr)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeploymentsStorage:       depsDb,
+				DeviceDeploymentsStorage: devsDb,
+			})
+			statuses, err := model.GetDeviceStatusesForDeployment(context.Background(),
+				tc.inDeploymentId)
+
+			if tc.modelErr != nil {
+				assert.EqualError(t, err, tc.modelErr.Error())
+			} else {
+				assert.NoError(t, err)
+
+				for i, expected := range tc.devsStorageStatuses {
+					assert.Equal(t, expected, statuses[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDeploymentModelGetDevicesListForDeployment(t *testing.T) {
+	query := deployments.ListQuery{
+		DeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+		Status:       deployments.DeviceDeploymentStatusFailure,
+		Limit:        3,
+	}
+	statuses := []deployments.DeviceDeployment{
+		*deployments.NewDeviceDeployment("dev0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
+		*deployments.NewDeviceDeployment("dev0002", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
+	}
+
+	testCases := map[string]struct {
+		depsStorageDeployment *deployments.Deployment
+		depsStorageErr        error
+
+		devsStorageStatuses []deployments.DeviceDeployment
+		devsStorageErr      error
+
+		modelErr error
+	}{
+		"ok": {
+			depsStorageDeployment: &deployments.Deployment{},
+			devsStorageStatuses:   statuses,
+		},
+		"deployment doesn't exist": {
+			modelErr: controller.ErrModelDeploymentNotFound,
+		},
+		"Deployments storage layer error": {
+			depsStorageErr: errors.New("some verbose, low-level db error"),
+			modelErr:       controller.ErrModelInternal,
+		},
+		"DeviceDeployments storage layer error": {
+			depsStorageDeployment: &deployments.Deployment{},
+			devsStorageErr:        errors.New("some verbose, low-level db error"),
+			modelErr:              controller.ErrModelInternal,
+		},
+	}
+
+	for testCaseName, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
+
+			devsDb := new(mocks.DeviceDeploymentStorage)
+			devsDb.On("GetDevicesListForDeployment", h.ContextMatcher(), query).
+				Return(tc.devsStorageStatuses, tc.devsStorageErr)
+
+			depsDb := new(mocks.DeploymentsStorage)
+			depsDb.On("FindByID", h.ContextMatcher(), query.DeploymentID).
+				Return(tc.depsStorageDeployment, tc.depsStorageErr)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeploymentsStorage:       depsDb,
+				DeviceDeploymentsStorage: devsDb,
+			})
+			out, err := model.GetDevicesListForDeployment(context.Background(), query)
+
+			if tc.modelErr != nil {
+				assert.Equal(t, tc.modelErr, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.devsStorageStatuses, out)
+			}
+		})
+	}
+}
+
+func TestDeploymentModelSaveDeviceDeploymentLog(t *testing.T) {
+
+	//t.Parallel()
+
+	tref := time.Now()
+	messages := []deployments.LogMessage{
+		{
+			Timestamp: &tref,
+			Message:   "foo",
+			Level:     "notice",
+		},
+	}
+	testCases := []struct {
+		InputDeploymentID string
+		InputDeviceID     string
+		InputLog          []deployments.LogMessage
+
+		InputModelError     error
+		InputHasDeployment  bool
+		InputHasModelError  error
+		InputUpdateLogError error
+
+		OutputError error
+	}{
+		{
+			InputDeploymentID:  "f826484e-1157-4109-af21-304e6d711560",
+			InputDeviceID:      "123",
+			InputLog:           messages,
+			InputModelError:    errors.New("Storage issue"),
+			InputHasDeployment: true,
+
+			OutputError: errors.New("Storage issue"),
+		},
+		{
+			InputDeploymentID:  "ID:234",
+			InputDeviceID:      "234",
+			InputLog:           []deployments.LogMessage{},
+			InputModelError:    nil,
+			InputHasDeployment: true,
+
+			OutputError: errors.New("Invalid deployment log: DeploymentID: ID:234 does not validate as uuidv4;Messages: non zero value required;"),
+		},
+		{
+			InputDeploymentID:  "f826484e-1157-4109-af21-304e6d711561",
+			InputDeviceID:      "345",
+			InputLog:           messages,
+			InputModelError:    nil,
+			InputHasDeployment: false,
+
+			OutputError: errors.New("Deployment not found"),
+		},
+		{
+			InputDeploymentID:   "f826484e-1157-4109-af21-304e6d711562",
+			InputDeviceID:       "456",
+			InputLog:            messages,
+			InputModelError:     nil,
+			InputHasDeployment:  true,
+			InputUpdateLogError: errors.New("Could not set log availability"),
+
+			OutputError: errors.New("Could not set log availability"),
+		},
+		{
+			InputDeploymentID:  "f826484e-1157-4109-af21-304e6d711563",
+			InputDeviceID:      "567",
+			InputLog:           messages,
+			InputHasDeployment: true,
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+
+			t.Logf("testing %s %s %s %v", testCase.InputDeploymentID, testCase.InputDeviceID,
+				testCase.InputLog, testCase.InputModelError)
+
+			deviceDeploymentLogStorage := new(mocks.DeviceDeploymentLogsStorage)
+			deviceDeploymentLogStorage.On("SaveDeviceDeploymentLog",
+				h.ContextMatcher(),
+				deployments.DeploymentLog{
+					DeviceID:     testCase.InputDeviceID,
+					DeploymentID: testCase.InputDeploymentID,
+					Messages:     testCase.InputLog,
+				}).
+				Return(testCase.InputModelError)
+
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+			deviceDeploymentStorage.On("HasDeploymentForDevice",
+				h.ContextMatcher(),
+				testCase.InputDeploymentID, testCase.InputDeviceID).
+				Return(testCase.InputHasDeployment, testCase.InputHasModelError)
+			deviceDeploymentStorage.On("UpdateDeviceDeploymentLogAvailability",
+				h.ContextMatcher(),
+				testCase.InputDeviceID, testCase.InputDeploymentID, true).
+				Return(testCase.InputUpdateLogError)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeviceDeploymentsStorage:    deviceDeploymentStorage,
+				DeviceDeploymentLogsStorage: deviceDeploymentLogStorage,
+			})
+
+			err := model.SaveDeviceDeploymentLog(context.Background(),
+				testCase.InputDeviceID,
+				testCase.InputDeploymentID, testCase.InputLog)
+			if testCase.OutputError != nil {
+				assert.EqualError(t, err, testCase.OutputError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDeploymentModelLookupDeployment(t *testing.T) {
+
+	//t.Parallel()
+
+	testCases := map[string]struct {
+		MockDeployments []*deployments.Deployment
+		MockError       error
+
+		OutputError       error
+		OutputDeployments []*deployments.Deployment
+	}{
+		"nothing found": {
+			MockDeployments:   nil,
+			OutputDeployments: []*deployments.Deployment{},
+		},
+		"error": {
+			MockError:   errors.New("bad bad bad"),
+			OutputError: errors.New("searching for deployments: bad bad bad"),
+		},
+		"found deployments": {
+			MockDeployments:   []*deployments.Deployment{{Id: StringToPointer("lala")}},
+			OutputDeployments: []*deployments.Deployment{{Id: StringToPointer("lala")}},
+		},
+	}
+
+	for testCaseName, testCase := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
+
+			deploymentStorage := new(mocks.DeploymentsStorage)
+			deploymentStorage.On("Find",
+				h.ContextMatcher(), mock.AnythingOfType("deployments.Query")).
+				Return(testCase.MockDeployments, testCase.MockError)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{DeploymentsStorage: deploymentStorage})
+
+			deployments, err := model.LookupDeployment(context.Background(),
+				deployments.Query{})
+			if testCase.OutputError != nil {
+				assert.EqualError(t, err, testCase.OutputError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, testCase.OutputDeployments, deployments)
+		})
+	}
+}
+
+func TestDeploymentModelIsDeploymentFinished(t *testing.T) {
+	//t.Parallel()
+
+	testCases := map[string]struct {
+		InputDeploymentID string
+		MockDeployment    *deployments.Deployment
+		MockError         error
+
+		OutputValue bool
+		OutputError error
+	}{
+		"nothing found": {
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711561",
+			MockDeployment:    nil,
+			OutputError:       nil,
+			OutputValue:       true,
+		},
+		"error": {
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711561",
+			MockDeployment:    nil,
+			MockError:         errors.New("bad bad bad"),
+			OutputError:       errors.New("Searching for unfinished deployment by ID: bad bad bad"),
+		},
+		"found unfinished deployment": {
+			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711561",
+			MockDeployment:    &deployments.Deployment{Id: StringToPointer("f826484e-1157-4109-af21-304e6d711561")},
+			OutputValue:       false,
+		},
+	}
+
+	for testCaseName, testCase := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
+
+			deploymentStorage := new(mocks.DeploymentsStorage)
+			deploymentStorage.On("FindUnfinishedByID",
+				h.ContextMatcher(), mock.AnythingOfType("string")).
+				Return(testCase.MockDeployment, testCase.MockError)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{DeploymentsStorage: deploymentStorage})
+
+			isFinished, err := model.IsDeploymentFinished(context.Background(),
+				testCase.InputDeploymentID)
+			if testCase.OutputError != nil {
+				assert.EqualError(t, err, testCase.OutputError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, testCase.OutputValue, isFinished)
+		})
+	}
+}
+
+func TestDeploymentModelAbortDeployment(t *testing.T) {
+	//t.Parallel()
+
+	testCases := map[string]struct {
+		InputDeploymentID string
+
+		AbortDeviceDeploymentsError            error
+		AggregateDeviceDeploymentByStatusStats deployments.Stats
+		AggregateDeviceDeploymentByStatusError error
+		UpdateStatsAndFinishDeploymentError    error
+
+		OutputError error
+	}{
+		"AbortDeviceDeployments error": {
+			InputDeploymentID:           "f826484e-1157-4109-af21-304e6d711561",
+			AbortDeviceDeploymentsError: errors.New("AbortDeviceDeploymentsError"),
+			OutputError:                 errors.New("AbortDeviceDeploymentsError"),
+		},
+		"AggregateDeviceDeploymentByStatus error": {
+			InputDeploymentID:                      "f826484e-1157-4109-af21-304e6d711561",
+			AggregateDeviceDeploymentByStatusError: errors.New("AggregateDeviceDeploymentByStatusError"),
+			AggregateDeviceDeploymentByStatusStats: deployments.Stats{},
+			OutputError:                            errors.New("AggregateDeviceDeploymentByStatusError"),
+		},
+		"UpdateStatsAndFinishDeployment error": {
+			InputDeploymentID:                      "f826484e-1157-4109-af21-304e6d711561",
+			AggregateDeviceDeploymentByStatusStats: deployments.Stats{"aaa": 1},
+			UpdateStatsAndFinishDeploymentError:    errors.New("UpdateStatsAndFinishDeploymentError"),
+			OutputError:                            errors.New("UpdateStatsAndFinishDeploymentError"),
+		},
+		"all correct": {
+			InputDeploymentID:                      "f826484e-1157-4109-af21-304e6d711561",
+			AggregateDeviceDeploymentByStatusStats: deployments.Stats{"aaa": 1},
+		},
+	}
+
+	for testCaseName, testCase := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
+
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+			deploymentStorage := new(mocks.DeploymentsStorage)
+			deviceDeploymentStorage.On("AbortDeviceDeployments",
+				h.ContextMatcher(), mock.AnythingOfType("string")).
+				Return(testCase.AbortDeviceDeploymentsError)
+			deviceDeploymentStorage.On("AggregateDeviceDeploymentByStatus",
+				h.ContextMatcher(), mock.AnythingOfType("string")).
+				Return(testCase.AggregateDeviceDeploymentByStatusStats,
+					testCase.AggregateDeviceDeploymentByStatusError)
+			deploymentStorage.On("UpdateStatsAndFinishDeployment",
+				h.ContextMatcher(), mock.AnythingOfType("string"),
+				mock.AnythingOfType("deployments.Stats")).
+				Return(testCase.UpdateStatsAndFinishDeploymentError)
+
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeploymentsStorage:       deploymentStorage,
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
+			})
+
+			err := model.AbortDeployment(context.Background(),
+				testCase.InputDeploymentID)
 			if testCase.OutputError != nil {
 				assert.EqualError(t, err, testCase.OutputError.Error())
 			} else {
 				assert.NoError(t, err)
-
-				// make sure that storage calls were done as
-				// expected
-				mock.AssertExpectationsForObjects(t, deviceDeploymentStorage,
-					deploymentStorage)
 			}
 		})
 	}
-
 }
 
-func TestGetDeploymentStats(t *testing.T) {
-
+func TestDeploymentModelPromoteDeployment(t *testing.T) {
 	//t.Parallel()
 
-	testCases := []struct {
-		InputDeploymentID         string
-		InputModelDeploymentStats deployments.Stats
-		InputModelError           error
+	id := "f826484e-1157-4109-af21-304e6d711561"
+	now := time.Now()
 
-		InoutFindByIDDeployment *deployments.Deployment
-		InoutFindByIDError      error
+	canary := &deployments.DeploymentConstructor{
+		Canary: &deployments.CanarySpec{Percentage: 10},
+	}
 
-		OutputStats deployments.Stats
-		OutputError error
+	testCases := map[string]struct {
+		FindByIDDeployment *deployments.Deployment
+		FindByIDError      error
+		// returned once the deployment was changed concurrently
+		RefreshedDeployment *deployments.Deployment
+
+		PromoteChanged                bool
+		PromoteError                  error
+		ReleaseDeviceDeploymentsError error
+
+		OutputError    error
+		OutputPromoted bool
+		OutputReleased bool
 	}{
-		{
-			InputDeploymentID:         "ID:123",
-			InputModelDeploymentStats: nil,
+		"FindByID error": {
+			FindByIDError: errors.New("find error"),
 
-			InoutFindByIDDeployment: new(deployments.Deployment),
+			OutputError: errors.New("Searching for deployment by ID: find error"),
+		},
+		"not found": {
+			OutputError: controller.ErrModelDeploymentNotFound,
+		},
+		"not canary": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: &deployments.DeploymentConstructor{},
+			},
 
-			OutputStats: nil,
+			OutputError: controller.ErrDeploymentNotCanary,
 		},
-		{
-			InputDeploymentID: "ID:234",
-			InputModelError:   errors.New("storage issue"),
+		"already promoted": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Promoted:              &now,
+			},
 
-			InoutFindByIDDeployment: new(deployments.Deployment),
+			OutputReleased: true,
+		},
+		"already promoted and finished": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Promoted:              &now,
+				Finished:              &now,
+			},
+		},
+		"halted": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Halted:                &now,
+			},
 
-			OutputError: errors.New("storage issue"),
+			OutputError: controller.ErrDeploymentHalted,
 		},
-		{
-			InputDeploymentID: "ID:234",
-			InputModelError:   errors.New("storage issue"),
+		"finished": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Finished:              &now,
+			},
 
-			InoutFindByIDDeployment: nil,
+			OutputError: controller.ErrDeploymentAlreadyFinished,
 		},
-		{
-			InputDeploymentID: "ID:234",
-			InputModelError:   errors.New("storage issue"),
+		"Promote error": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+			},
+			PromoteError: errors.New("promote error"),
 
-			InoutFindByIDDeployment: new(deployments.Deployment),
-			InoutFindByIDError:      errors.New("an error"),
+			OutputError:    errors.New("Promoting deployment: promote error"),
+			OutputPromoted: true,
+		},
+		"halted concurrently": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+			},
+			RefreshedDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Halted:                &now,
+			},
 
-			OutputError: errors.New("checking deployment id: an error"),
+			OutputError:    controller.ErrDeploymentHalted,
+			OutputPromoted: true,
 		},
-		{
-			InputDeploymentID:       "ID:345",
-			InoutFindByIDDeployment: new(deployments.Deployment),
-			InputModelDeploymentStats: deployments.Stats{
-				deployments.DeviceDeploymentStatusPending:     2,
-				deployments.DeviceDeploymentStatusSuccess:     4,
-				deployments.DeviceDeploymentStatusFailure:     1,
-				deployments.DeviceDeploymentStatusInstalling:  3,
-				deployments.DeviceDeploymentStatusRebooting:   3,
-				deployments.DeviceDeploymentStatusDownloading: 3,
-				deployments.DeviceDeploymentStatusAlreadyInst: 0,
+		"promoted concurrently": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+			},
+			RefreshedDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Promoted:              &now,
 			},
 
-			OutputStats: deployments.Stats{
-				deployments.DeviceDeploymentStatusDownloading: 3,
-				deployments.DeviceDeploymentStatusRebooting:   3,
-				deployments.DeviceDeploymentStatusInstalling:  3,
-				deployments.DeviceDeploymentStatusSuccess:     4,
-				deployments.DeviceDeploymentStatusFailure:     1,
-				deployments.DeviceDeploymentStatusPending:     2,
-				deployments.DeviceDeploymentStatusAlreadyInst: 0,
+			OutputPromoted: true,
+			OutputReleased: true,
+		},
+		"ReleaseDeviceDeployments error": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
 			},
+			PromoteChanged:                true,
+			ReleaseDeviceDeploymentsError: errors.New("release error"),
+
+			OutputError:    errors.New("Releasing device deployments: release error"),
+			OutputPromoted: true,
+			OutputReleased: true,
 		},
-	}
+		"all correct": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+			},
+			PromoteChanged: true,
 
-	for testCaseNumber, testCase := range testCases {
-		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
-			t.Logf("testing %s %v %v", testCase.InputDeploymentID,
-				testCase.InputModelDeploymentStats, testCase.InputModelError)
+			OutputPromoted: true,
+			OutputReleased: true,
+		},
+	}
 
-			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
-			deviceDeploymentStorage.On("AggregateDeviceDeploymentByStatus",
-				h.ContextMatcher(),
-				testCase.InputDeploymentID).
-				Return(testCase.InputModelDeploymentStats, testCase.InputModelError)
+	for testCaseName, testCase := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
 
 			deploymentStorage := new(mocks.DeploymentsStorage)
-			deploymentStorage.On("FindByID",
-				h.ContextMatcher(),
-				testCase.InputDeploymentID).
-				Return(testCase.InoutFindByIDDeployment, testCase.InoutFindByIDError)
+			if testCase.RefreshedDeployment != nil {
+				deploymentStorage.On("FindByID", h.ContextMatcher(), id).
+					Return(testCase.FindByIDDeployment, nil).Once()
+				deploymentStorage.On("FindByID", h.ContextMatcher(), id).
+					Return(testCase.RefreshedDeployment, nil)
+			} else {
+				deploymentStorage.On("FindByID", h.ContextMatcher(), id).
+					Return(testCase.FindByIDDeployment, testCase.FindByIDError)
+			}
+			deploymentStorage.On("Promote", h.ContextMatcher(), id,
+				mock.AnythingOfType("time.Time")).
+				Return(testCase.PromoteChanged, testCase.PromoteError)
+
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+			deviceDeploymentStorage.On("ReleaseDeviceDeployments",
+				h.ContextMatcher(), id).
+				Return(testCase.ReleaseDeviceDeploymentsError)
 
 			model := NewDeploymentModel(DeploymentsModelConfig{
 				DeploymentsStorage:       deploymentStorage,
 				DeviceDeploymentsStorage: deviceDeploymentStorage,
 			})
 
-			stats, err := model.GetDeploymentStats(context.Background(),
-				testCase.InputDeploymentID)
-
+			err := model.PromoteDeployment(context.Background(), id)
 			if testCase.OutputError != nil {
 				assert.EqualError(t, err, testCase.OutputError.Error())
 			} else {
 				assert.NoError(t, err)
+			}
 
-				assert.Equal(t, testCase.OutputStats, stats)
+			if testCase.OutputPromoted {
+				deploymentStorage.AssertNumberOfCalls(t, "Promote", 1)
+			} else {
+				deploymentStorage.AssertNotCalled(t, "Promote",
+					h.ContextMatcher(), id, mock.AnythingOfType("time.Time"))
+			}
+			if testCase.OutputReleased {
+				deviceDeploymentStorage.AssertNumberOfCalls(t, "ReleaseDeviceDeployments", 1)
+			} else {
+				deviceDeploymentStorage.AssertNotCalled(t, "ReleaseDeviceDeployments",
+					h.ContextMatcher(), id)
 			}
 		})
 	}
 }
 
-func TestDeploymentModelGetDeviceStatusesForDeployment(t *testing.T) {
+func TestDeploymentModelHaltDeployment(t *testing.T) {
 	//t.Parallel()
 
-	testCases := map[string]struct {
-		inDeploymentId string
+	id := "f826484e-1157-4109-af21-304e6d711561"
+	now := time.Now()
 
-		devsStorageStatuses []deployments.DeviceDeployment
-		devsStorageErr      error
+	canary := &deployments.DeploymentConstructor{
+		Canary: &deployments.CanarySpec{Percentage: 10},
+	}
 
-		depsStorageDeployment *deployments.Deployment
-		depsStorageErr        error
+	testCases := map[string]struct {
+		FindByIDDeployment *deployments.Deployment
+		FindByIDError      error
+		// returned once the deployment was changed concurrently
+		RefreshedDeployment *deployments.Deployment
+
+		HaltChanged                            bool
+		HaltError                              error
+		AbortHeldDeviceDeploymentsError        error
+		AggregateDeviceDeploymentByStatusError error
+		UpdateStatsAndFinishDeploymentError    error
 
-		modelErr error
+		OutputError   error
+		OutputHalted  bool
+		OutputAborted bool
 	}{
-		"existing deployment with statuses": {
-			inDeploymentId: "30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+		"FindByID error": {
+			FindByIDError: errors.New("find error"),
 
-			devsStorageStatuses: []deployments.DeviceDeployment{
-				*deployments.NewDeviceDeployment("dev0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
-				*deployments.NewDeviceDeployment("dev0002", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
-				*deployments.NewDeviceDeployment("dev0003", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
+			OutputError: errors.New("Searching for deployment by ID: find error"),
+		},
+		"not found": {
+			OutputError: controller.ErrModelDeploymentNotFound,
+		},
+		"not canary": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: &deployments.DeploymentConstructor{},
 			},
-			devsStorageErr: nil,
 
-			depsStorageDeployment: &deployments.Deployment{},
-			depsStorageErr:        nil,
-
-			modelErr: nil,
+			OutputError: controller.ErrDeploymentNotCanary,
 		},
-		"deployment doesn't exist": {
-			devsStorageStatuses: []deployments.DeviceDeployment{
-				*deployments.NewDeviceDeployment("dev0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
-				*deployments.NewDeviceDeployment("dev0002", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
-				*deployments.NewDeviceDeployment("dev0003", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
+		"already halted": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Halted:                &now,
 			},
-			devsStorageErr: nil,
-
-			depsStorageDeployment: nil,
-			depsStorageErr:        nil,
 
-			modelErr: controller.ErrModelDeploymentNotFound,
+			OutputAborted: true,
 		},
-		"DeviceDeployments storage layer error": {
-			inDeploymentId: "30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
-
-			devsStorageStatuses: nil,
-			devsStorageErr:      errors.New("some verbose, low-level db error"),
-
-			depsStorageDeployment: &deployments.Deployment{},
-			depsStorageErr:        nil,
-
-			modelErr: errors.New("Internal error"),
+		"already halted and finished": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Halted:                &now,
+				Finished:              &now,
+			},
 		},
-		"Deployments storage layer error": {
-			inDeploymentId: "30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
-
-			devsStorageStatuses: nil,
-			devsStorageErr:      nil,
-
-			depsStorageDeployment: nil,
-			depsStorageErr:        errors.New("some verbose, low-level db error"),
+		"promoted": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Promoted:              &now,
+			},
 
-			modelErr: errors.New("Internal error"),
+			OutputError: controller.ErrDeploymentPromoted,
 		},
-	}
-
-	for testCaseName, tc := range testCases {
-		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
-
-			devsDb := new(mocks.DeviceDeploymentStorage)
-
-			devsDb.On("GetDeviceStatusesForDeployment",
-				h.ContextMatcher(), tc.inDeploymentId).
-				Return(tc.devsStorageStatuses, tc.devsStorageErr)
-
-			depsDb := new(mocks.DeploymentsStorage)
-
-			depsDb.On("FindByID", h.ContextMatcher(), tc.inDeploymentId).
-				Return(tc.depsStorageDeployment, tc.depsStorageErr)
-
-			model := NewDeploymentModel(DeploymentsModelConfig{
-				DeploymentsStorage:       depsDb,
-				DeviceDeploymentsStorage: devsDb,
-			})
-			statuses, err := model.GetDeviceStatusesForDeployment(context.Background(),
-				tc.inDeploymentId)
-
-			if tc.modelErr != nil {
-				assert.EqualError(t, err, tc.modelErr.Error())
-			} else {
-				assert.NoError(t, err)
-
-				for i, expected := range tc.devsStorageStatuses {
-					assert.Equal(t, expected, statuses[i])
-				}
-			}
-		})
-	}
-}
-
-func TestDeploymentModelSaveDeviceDeploymentLog(t *testing.T) {
-
-	//t.Parallel()
+		"finished": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Finished:              &now,
+			},
 
-	tref := time.Now()
-	messages := []deployments.LogMessage{
-		{
-			Timestamp: &tref,
-			Message:   "foo",
-			Level:     "notice",
+			OutputError: controller.ErrDeploymentAlreadyFinished,
 		},
-	}
-	testCases := []struct {
-		InputDeploymentID string
-		InputDeviceID     string
-		InputLog          []deployments.LogMessage
-
-		InputModelError     error
-		InputHasDeployment  bool
-		InputHasModelError  error
-		InputUpdateLogError error
+		"Halt error": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+			},
+			HaltError: errors.New("halt error"),
 
-		OutputError error
-	}{
-		{
-			InputDeploymentID:  "f826484e-1157-4109-af21-304e6d711560",
-			InputDeviceID:      "123",
-			InputLog:           messages,
-			InputModelError:    errors.New("Storage issue"),
-			InputHasDeployment: true,
+			OutputError:  errors.New("Halting deployment: halt error"),
+			OutputHalted: true,
+		},
+		"promoted concurrently": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+			},
+			RefreshedDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Promoted:              &now,
+			},
 
-			OutputError: errors.New("Storage issue"),
+			OutputError:  controller.ErrDeploymentPromoted,
+			OutputHalted: true,
 		},
-		{
-			InputDeploymentID:  "ID:234",
-			InputDeviceID:      "234",
-			InputLog:           []deployments.LogMessage{},
-			InputModelError:    nil,
-			InputHasDeployment: true,
+		"halted concurrently": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+			},
+			RefreshedDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+				Halted:                &now,
+			},
 
-			OutputError: errors.New("Invalid deployment log: DeploymentID: ID:234 does not validate as uuidv4;Messages: non zero value required;"),
+			OutputHalted:  true,
+			OutputAborted: true,
 		},
-		{
-			InputDeploymentID:  "f826484e-1157-4109-af21-304e6d711561",
-			InputDeviceID:      "345",
-			InputLog:           messages,
-			InputModelError:    nil,
-			InputHasDeployment: false,
+		"AbortHeldDeviceDeployments error": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+			},
+			HaltChanged:                     true,
+			AbortHeldDeviceDeploymentsError: errors.New("abort error"),
 
-			OutputError: errors.New("Deployment not found"),
+			OutputError:   errors.New("Aborting held device deployments: abort error"),
+			OutputHalted:  true,
+			OutputAborted: true,
 		},
-		{
-			InputDeploymentID:   "f826484e-1157-4109-af21-304e6d711562",
-			InputDeviceID:       "456",
-			InputLog:            messages,
-			InputModelError:     nil,
-			InputHasDeployment:  true,
-			InputUpdateLogError: errors.New("Could not set log availability"),
+		"AggregateDeviceDeploymentByStatus error": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+			},
+			HaltChanged:                            true,
+			AggregateDeviceDeploymentByStatusError: errors.New("aggregate error"),
 
-			OutputError: errors.New("Could not set log availability"),
+			OutputError:   errors.New("Aggregating device deployment statistics: aggregate error"),
+			OutputHalted:  true,
+			OutputAborted: true,
 		},
-		{
-			InputDeploymentID:  "f826484e-1157-4109-af21-304e6d711563",
-			InputDeviceID:      "567",
-			InputLog:           messages,
-			InputHasDeployment: true,
+		"UpdateStatsAndFinishDeployment error": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+			},
+			HaltChanged:                         true,
+			UpdateStatsAndFinishDeploymentError: errors.New("update error"),
+
+			OutputError:   errors.New("Updating deployment statistics: update error"),
+			OutputHalted:  true,
+			OutputAborted: true,
 		},
-	}
+		"all correct": {
+			FindByIDDeployment: &deployments.Deployment{
+				DeploymentConstructor: canary,
+			},
+			HaltChanged: true,
 
-	for testCaseNumber, testCase := range testCases {
-		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+			OutputHalted:  true,
+			OutputAborted: true,
+		},
+	}
 
-			t.Logf("testing %s %s %s %v", testCase.InputDeploymentID, testCase.InputDeviceID,
-				testCase.InputLog, testCase.InputModelError)
+	for testCaseName, testCase := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
 
-			deviceDeploymentLogStorage := new(mocks.DeviceDeploymentLogsStorage)
-			deviceDeploymentLogStorage.On("SaveDeviceDeploymentLog",
-				h.ContextMatcher(),
-				deployments.DeploymentLog{
-					DeviceID:     testCase.InputDeviceID,
-					DeploymentID: testCase.InputDeploymentID,
-					Messages:     testCase.InputLog,
-				}).
-				Return(testCase.InputModelError)
+			deploymentStorage := new(mocks.DeploymentsStorage)
+			if testCase.RefreshedDeployment != nil {
+				deploymentStorage.On("FindByID", h.ContextMatcher(), id).
+					Return(testCase.FindByIDDeployment, nil).Once()
+				deploymentStorage.On("FindByID", h.ContextMatcher(), id).
+					Return(testCase.RefreshedDeployment, nil)
+			} else {
+				deploymentStorage.On("FindByID", h.ContextMatcher(), id).
+					Return(testCase.FindByIDDeployment, testCase.FindByIDError)
+			}
+			deploymentStorage.On("UpdateStatsAndFinishDeployment",
+				h.ContextMatcher(), id,
+				mock.AnythingOfType("deployments.Stats")).
+				Return(testCase.UpdateStatsAndFinishDeploymentError)
+			deploymentStorage.On("Halt", h.ContextMatcher(), id,
+				mock.AnythingOfType("time.Time")).
+				Return(testCase.HaltChanged, testCase.HaltError)
 
 			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
-			deviceDeploymentStorage.On("HasDeploymentForDevice",
-				h.ContextMatcher(),
-				testCase.InputDeploymentID, testCase.InputDeviceID).
-				Return(testCase.InputHasDeployment, testCase.InputHasModelError)
-			deviceDeploymentStorage.On("UpdateDeviceDeploymentLogAvailability",
-				h.ContextMatcher(),
-				testCase.InputDeviceID, testCase.InputDeploymentID, true).
-				Return(testCase.InputUpdateLogError)
+			deviceDeploymentStorage.On("AbortHeldDeviceDeployments",
+				h.ContextMatcher(), id).
+				Return(testCase.AbortHeldDeviceDeploymentsError)
+			deviceDeploymentStorage.On("AggregateDeviceDeploymentByStatus",
+				h.ContextMatcher(), id).
+				Return(deployments.Stats{"aborted": 9, "success": 1},
+					testCase.AggregateDeviceDeploymentByStatusError)
 
 			model := NewDeploymentModel(DeploymentsModelConfig{
-				DeviceDeploymentsStorage:    deviceDeploymentStorage,
-				DeviceDeploymentLogsStorage: deviceDeploymentLogStorage,
+				DeploymentsStorage:       deploymentStorage,
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
 			})
 
-			err := model.SaveDeviceDeploymentLog(context.Background(),
-				testCase.InputDeviceID,
-				testCase.InputDeploymentID, testCase.InputLog)
+			err := model.HaltDeployment(context.Background(), id)
 			if testCase.OutputError != nil {
 				assert.EqualError(t, err, testCase.OutputError.Error())
 			} else {
 				assert.NoError(t, err)
 			}
+
+			if testCase.OutputHalted {
+				deploymentStorage.AssertNumberOfCalls(t, "Halt", 1)
+			} else {
+				deploymentStorage.AssertNotCalled(t, "Halt",
+					h.ContextMatcher(), id, mock.AnythingOfType("time.Time"))
+			}
+			if testCase.OutputAborted {
+				deviceDeploymentStorage.AssertNumberOfCalls(t, "AbortHeldDeviceDeployments", 1)
+			} else {
+				deviceDeploymentStorage.AssertNotCalled(t, "AbortHeldDeviceDeployments",
+					h.ContextMatcher(), id)
+			}
 		})
 	}
 }
 
-func TestDeploymentModelLookupDeployment(t *testing.T) {
-
-	//t.Parallel()
+func TestDeploymentModelAbortImageDeployments(t *testing.T) {
+	imageID := "f826484e-1157-4109-af21-304e6d711561"
+	active := []*deployments.Deployment{
+		{Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7")},
+		{Id: StringToPointer("d1804903-5caa-4a73-a3ae-0efcc3205405")},
+	}
 
 	testCases := map[string]struct {
-		MockDeployments []*deployments.Deployment
-		MockError       error
+		active     []*deployments.Deployment
+		findError  error
+		abortError error
 
-		OutputError       error
-		OutputDeployments []*deployments.Deployment
+		aborted     int
+		abortCalls  int
+		outputError error
 	}{
-		"nothing found": {
-			MockDeployments:   nil,
-			OutputDeployments: []*deployments.Deployment{},
+		"none active": {},
+		"all aborted": {
+			active:     active,
+			aborted:    2,
+			abortCalls: 2,
 		},
-		"error": {
-			MockError:   errors.New("bad bad bad"),
-			OutputError: errors.New("searching for deployments: bad bad bad"),
+		"find error": {
+			findError:   errors.New("db error"),
+			outputError: errors.New("Searching for active deployments using image: db error"),
 		},
-		"found deployments": {
-			MockDeployments:   []*deployments.Deployment{{Id: StringToPointer("lala")}},
-			OutputDeployments: []*deployments.Deployment{{Id: StringToPointer("lala")}},
+		"abort error": {
+			active:     active,
+			abortError: errors.New("db error"),
+			abortCalls: 1,
+			outputError: errors.New("Aborting deployment " +
+				"a108ae14-bb4e-455f-9b40-2ef4bab97bb7: db error"),
 		},
 	}
 
-	for testCaseName, testCase := range testCases {
-		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
-
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
 			deploymentStorage := new(mocks.DeploymentsStorage)
-			deploymentStorage.On("Find",
-				h.ContextMatcher(), mock.AnythingOfType("deployments.Query")).
-				Return(testCase.MockDeployments, testCase.MockError)
+			deploymentStorage.On("FindUnfinishedByArtifactId",
+				h.ContextMatcher(), imageID).
+				Return(tc.active, tc.findError)
+			deviceDeploymentStorage.On("AbortDeviceDeployments",
+				h.ContextMatcher(), mock.AnythingOfType("string")).
+				Return(tc.abortError)
+			deviceDeploymentStorage.On("AggregateDeviceDeploymentByStatus",
+				h.ContextMatcher(), mock.AnythingOfType("string")).
+				Return(deployments.Stats{}, nil)
+			deploymentStorage.On("UpdateStatsAndFinishDeployment",
+				h.ContextMatcher(), mock.AnythingOfType("string"),
+				mock.AnythingOfType("deployments.Stats")).
+				Return(nil)
 
-			model := NewDeploymentModel(DeploymentsModelConfig{DeploymentsStorage: deploymentStorage})
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeploymentsStorage:       deploymentStorage,
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
+			})
 
-			deployments, err := model.LookupDeployment(context.Background(),
-				deployments.Query{})
-			if testCase.OutputError != nil {
-				assert.EqualError(t, err, testCase.OutputError.Error())
+			aborted, err := model.AbortImageDeployments(context.Background(), imageID)
+			if tc.outputError != nil {
+				assert.EqualError(t, err, tc.outputError.Error())
 			} else {
 				assert.NoError(t, err)
 			}
-			assert.Equal(t, testCase.OutputDeployments, deployments)
+			assert.Equal(t, tc.aborted, aborted)
+			deviceDeploymentStorage.AssertNumberOfCalls(t, "AbortDeviceDeployments",
+				tc.abortCalls)
 		})
 	}
 }
 
-func TestDeploymentModelIsDeploymentFinished(t *testing.T) {
-	//t.Parallel()
+func TestDeploymentModelNotifyDeploymentFinished(t *testing.T) {
+	finished := time.Now().Add(-time.Hour)
 
 	testCases := map[string]struct {
-		InputDeploymentID string
-		MockDeployment    *deployments.Deployment
-		MockError         error
+		deployment *deployments.Deployment
+		abort      bool
 
-		OutputValue bool
-		OutputError error
+		notified bool
 	}{
-		"nothing found": {
-			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711561",
-			MockDeployment:    nil,
-			OutputError:       nil,
-			OutputValue:       true,
+		"last device finished": {
+			deployment: &deployments.Deployment{
+				Id: StringToPointer("f826484e-1157-4109-af21-304e6d711561"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusSuccess: 1,
+				},
+			},
+			notified: true,
 		},
-		"error": {
-			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711561",
-			MockDeployment:    nil,
-			MockError:         errors.New("bad bad bad"),
-			OutputError:       errors.New("Searching for unfinished deployment by ID: bad bad bad"),
+		"device finished after the deployment was aborted": {
+			deployment: &deployments.Deployment{
+				Id: StringToPointer("f826484e-1157-4109-af21-304e6d711561"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusSuccess: 1,
+				},
+				Finished: &finished,
+			},
 		},
-		"found unfinished deployment": {
-			InputDeploymentID: "f826484e-1157-4109-af21-304e6d711561",
-			MockDeployment:    &deployments.Deployment{Id: StringToPointer("f826484e-1157-4109-af21-304e6d711561")},
-			OutputValue:       false,
+		"deployment still in progress": {
+			deployment: &deployments.Deployment{
+				Id: StringToPointer("f826484e-1157-4109-af21-304e6d711561"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusSuccess:    1,
+					deployments.DeviceDeploymentStatusInstalling: 1,
+				},
+			},
+		},
+		"deployment aborted": {
+			deployment: &deployments.Deployment{
+				Id: StringToPointer("f826484e-1157-4109-af21-304e6d711561"),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusAborted: 1,
+				},
+				Finished: &finished,
+			},
+			abort:    true,
+			notified: true,
 		},
 	}
 
-	for testCaseName, testCase := range testCases {
+	for testCaseName, tc := range testCases {
 		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
 
+			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
 			deploymentStorage := new(mocks.DeploymentsStorage)
-			deploymentStorage.On("FindUnfinishedByID",
-				h.ContextMatcher(), mock.AnythingOfType("string")).
-				Return(testCase.MockDeployment, testCase.MockError)
+			notifier := new(mocks.DeploymentNotifier)
+
+			deploymentStorage.On("FindByID", h.ContextMatcher(), *tc.deployment.Id).
+				Return(tc.deployment, nil)
+			if tc.notified {
+				notifier.On("NotifyDeploymentFinished", h.ContextMatcher(),
+					mock.MatchedBy(func(d *deployments.Deployment) bool {
+						return *d.Id == *tc.deployment.Id && d.Finished != nil
+					}))
+			}
 
-			model := NewDeploymentModel(DeploymentsModelConfig{DeploymentsStorage: deploymentStorage})
+			model := NewDeploymentModel(DeploymentsModelConfig{
+				DeploymentsStorage:       deploymentStorage,
+				DeviceDeploymentsStorage: deviceDeploymentStorage,
+				Notifier:                 notifier,
+			})
 
-			isFinished, err := model.IsDeploymentFinished(context.Background(),
-				testCase.InputDeploymentID)
-			if testCase.OutputError != nil {
-				assert.EqualError(t, err, testCase.OutputError.Error())
+			var err error
+			if tc.abort {
+				deviceDeploymentStorage.On("AbortDeviceDeployments",
+					h.ContextMatcher(), *tc.deployment.Id).
+					Return(nil)
+				deviceDeploymentStorage.On("AggregateDeviceDeploymentByStatus",
+					h.ContextMatcher(), *tc.deployment.Id).
+					Return(deployments.Stats(tc.deployment.Stats), nil)
+				deploymentStorage.On("UpdateStatsAndFinishDeployment",
+					h.ContextMatcher(), *tc.deployment.Id,
+					deployments.Stats(tc.deployment.Stats)).
+					Return(nil)
+
+				err = model.AbortDeployment(context.Background(), *tc.deployment.Id)
 			} else {
-				assert.NoError(t, err)
+				deviceDeploymentStorage.On("GetDeviceDeploymentStatus",
+					h.ContextMatcher(), *tc.deployment.Id, "device").
+					Return(deployments.DeviceDeploymentStatusInstalling, nil)
+				deviceDeploymentStorage.On("UpdateDeviceDeploymentStatus",
+					h.ContextMatcher(), "device", *tc.deployment.Id,
+					mock.AnythingOfType("deployments.DeviceDeploymentStatus")).
+					Return(deployments.DeviceDeploymentStatusInstalling, nil)
+				deploymentStorage.On("UpdateStats", h.ContextMatcher(),
+					*tc.deployment.Id, deployments.DeviceDeploymentStatusInstalling,
+					deployments.DeviceDeploymentStatusSuccess).
+					Return(nil)
+				deploymentStorage.On("Finish", h.ContextMatcher(),
+					*tc.deployment.Id, mock.AnythingOfType("time.Time")).
+					Return(nil)
+
+				err = model.UpdateDeviceDeploymentStatus(context.Background(),
+					*tc.deployment.Id, "device",
+					deployments.DeviceDeploymentStatus{
+						Status: deployments.DeviceDeploymentStatusSuccess,
+					})
 			}
-			assert.Equal(t, testCase.OutputValue, isFinished)
+			assert.NoError(t, err)
+
+			notifier.AssertExpectations(t)
 		})
 	}
 }
 
-func TestDeploymentModelAbortDeployment(t *testing.T) {
-	//t.Parallel()
+func TestDeploymentModelMetrics(t *testing.T) {
+	deploymentID := "f826484e-1157-4109-af21-304e6d711561"
+	finished := time.Now().Add(-time.Hour)
+
+	t.Run("created", func(t *testing.T) {
+		deploymentStorage := new(mocks.DeploymentsStorage)
+		deploymentStorage.On("Insert", h.ContextMatcher(),
+			mock.AnythingOfType("*deployments.Deployment")).
+			Return(nil)
+		deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+		deviceDeploymentStorage.On("InsertMany", h.ContextMatcher(),
+			mock.AnythingOfType("[]*deployments.DeviceDeployment")).
+			Return(nil)
+		deviceDeploymentStorage.On("FindLatestDeviceTypes", h.ContextMatcher(),
+			mock.AnythingOfType("[]string")).
+			Return(map[string]string{}, nil)
+		artifactGetter := new(mocks.ArtifactGetter)
+		artifactGetter.On("ImagesByName", h.ContextMatcher(), "App 123").
+			Return([]*images.SoftwareImage{images.NewSoftwareImage(validUUIDv4,
+				&images.SoftwareImageMetaConstructor{},
+				&images.SoftwareImageMetaArtifactConstructor{
+					Name:                  "App 123",
+					DeviceTypesCompatible: []string{"hammer"},
+				})}, nil)
+
+		metrics := new(mocks.DeploymentMetrics)
+		metrics.On("DeploymentCreated", h.ContextMatcher(),
+			mock.MatchedBy(func(d *deployments.Deployment) bool {
+				return d.Stats[deployments.DeviceDeploymentStatusPending] == 2
+			}))
+
+		model := NewDeploymentModel(DeploymentsModelConfig{
+			DeploymentsStorage:       deploymentStorage,
+			DeviceDeploymentsStorage: deviceDeploymentStorage,
+			ArtifactGetter:           artifactGetter,
+			Metrics:                  metrics,
+		})
 
-	testCases := map[string]struct {
-		InputDeploymentID string
+		_, err := model.CreateDeployment(context.Background(),
+			&deployments.DeploymentConstructor{
+				Name:         StringToPointer("NYC Production"),
+				ArtifactName: StringToPointer("App 123"),
+				Devices:      []string{"device-1", "device-2"},
+			})
+		assert.NoError(t, err)
+		metrics.AssertExpectations(t)
+	})
 
-		AbortDeviceDeploymentsError            error
-		AggregateDeviceDeploymentByStatusStats deployments.Stats
-		AggregateDeviceDeploymentByStatusError error
-		UpdateStatsAndFinishDeploymentError    error
+	updateTestCases := map[string]struct {
+		deployment *deployments.Deployment
+		status     string
 
-		OutputError error
+		changed  bool
+		finished bool
 	}{
-		"AbortDeviceDeployments error": {
-			InputDeploymentID:           "f826484e-1157-4109-af21-304e6d711561",
-			AbortDeviceDeploymentsError: errors.New("AbortDeviceDeploymentsError"),
-			OutputError:                 errors.New("AbortDeviceDeploymentsError"),
-		},
-		"AggregateDeviceDeploymentByStatus error": {
-			InputDeploymentID:                      "f826484e-1157-4109-af21-304e6d711561",
-			AggregateDeviceDeploymentByStatusError: errors.New("AggregateDeviceDeploymentByStatusError"),
-			AggregateDeviceDeploymentByStatusStats: deployments.Stats{},
-			OutputError:                            errors.New("AggregateDeviceDeploymentByStatusError"),
+		"device failed": {
+			deployment: &deployments.Deployment{
+				Id: StringToPointer(deploymentID),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusFailure:    1,
+					deployments.DeviceDeploymentStatusInstalling: 1,
+				},
+			},
+			status:  deployments.DeviceDeploymentStatusFailure,
+			changed: true,
 		},
-		"UpdateStatsAndFinishDeployment error": {
-			InputDeploymentID:                      "f826484e-1157-4109-af21-304e6d711561",
-			AggregateDeviceDeploymentByStatusStats: deployments.Stats{"aaa": 1},
-			UpdateStatsAndFinishDeploymentError:    errors.New("UpdateStatsAndFinishDeploymentError"),
-			OutputError:                            errors.New("UpdateStatsAndFinishDeploymentError"),
+		"last device finished": {
+			deployment: &deployments.Deployment{
+				Id: StringToPointer(deploymentID),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusSuccess: 1,
+				},
+			},
+			status:   deployments.DeviceDeploymentStatusSuccess,
+			changed:  true,
+			finished: true,
 		},
-		"all correct": {
-			InputDeploymentID:                      "f826484e-1157-4109-af21-304e6d711561",
-			AggregateDeviceDeploymentByStatusStats: deployments.Stats{"aaa": 1},
+		"device finished after the deployment was aborted": {
+			deployment: &deployments.Deployment{
+				Id: StringToPointer(deploymentID),
+				Stats: deployments.Stats{
+					deployments.DeviceDeploymentStatusSuccess: 1,
+				},
+				Finished: &finished,
+			},
+			status: deployments.DeviceDeploymentStatusSuccess,
 		},
 	}
 
-	for testCaseName, testCase := range testCases {
-		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
-
+	for name, tc := range updateTestCases {
+		t.Run(name, func(t *testing.T) {
 			deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
 			deploymentStorage := new(mocks.DeploymentsStorage)
-			deviceDeploymentStorage.On("AbortDeviceDeployments",
-				h.ContextMatcher(), mock.AnythingOfType("string")).
-				Return(testCase.AbortDeviceDeploymentsError)
-			deviceDeploymentStorage.On("AggregateDeviceDeploymentByStatus",
-				h.ContextMatcher(), mock.AnythingOfType("string")).
-				Return(testCase.AggregateDeviceDeploymentByStatusStats,
-					testCase.AggregateDeviceDeploymentByStatusError)
-			deploymentStorage.On("UpdateStatsAndFinishDeployment",
-				h.ContextMatcher(), mock.AnythingOfType("string"),
-				mock.AnythingOfType("deployments.Stats")).
-				Return(testCase.UpdateStatsAndFinishDeploymentError)
+			metrics := new(mocks.DeploymentMetrics)
+
+			deploymentStorage.On("FindByID", h.ContextMatcher(), deploymentID).
+				Return(tc.deployment, nil)
+			deviceDeploymentStorage.On("GetDeviceDeploymentStatus",
+				h.ContextMatcher(), deploymentID, "device").
+				Return(deployments.DeviceDeploymentStatusInstalling, nil)
+			deviceDeploymentStorage.On("UpdateDeviceDeploymentStatus",
+				h.ContextMatcher(), "device", deploymentID,
+				mock.AnythingOfType("deployments.DeviceDeploymentStatus")).
+				Return(deployments.DeviceDeploymentStatusInstalling, nil)
+			deploymentStorage.On("UpdateStats", h.ContextMatcher(), deploymentID,
+				deployments.DeviceDeploymentStatusInstalling, tc.status).
+				Return(nil)
+			deploymentStorage.On("Finish", h.ContextMatcher(),
+				deploymentID, mock.AnythingOfType("time.Time")).
+				Return(nil)
+
+			if tc.changed {
+				metrics.On("DeviceDeploymentStatusChanged", h.ContextMatcher(),
+					deployments.DeviceDeploymentStatusInstalling, tc.status, 1)
+			}
+			if tc.finished {
+				metrics.On("DeploymentFinished", h.ContextMatcher(), tc.deployment)
+			}
 
 			model := NewDeploymentModel(DeploymentsModelConfig{
 				DeploymentsStorage:       deploymentStorage,
 				DeviceDeploymentsStorage: deviceDeploymentStorage,
+				Metrics:                  metrics,
 			})
 
-			err := model.AbortDeployment(context.Background(),
-				testCase.InputDeploymentID)
-			if testCase.OutputError != nil {
-				assert.EqualError(t, err, testCase.OutputError.Error())
-			} else {
-				assert.NoError(t, err)
-			}
+			err := model.UpdateDeviceDeploymentStatus(context.Background(),
+				deploymentID, "device",
+				deployments.DeviceDeploymentStatus{Status: tc.status})
+			assert.NoError(t, err)
+			metrics.AssertExpectations(t)
 		})
 	}
+
+	t.Run("aborted", func(t *testing.T) {
+		deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+		deploymentStorage := new(mocks.DeploymentsStorage)
+		metrics := new(mocks.DeploymentMetrics)
+
+		before := &deployments.Deployment{
+			Id: StringToPointer(deploymentID),
+			Stats: deployments.Stats{
+				deployments.DeviceDeploymentStatusPending:    3,
+				deployments.DeviceDeploymentStatusInstalling: 1,
+				deployments.DeviceDeploymentStatusSuccess:    1,
+			},
+		}
+		after := deployments.Stats{
+			deployments.DeviceDeploymentStatusAborted: 4,
+			deployments.DeviceDeploymentStatusSuccess: 1,
+		}
+
+		deploymentStorage.On("FindByID", h.ContextMatcher(), deploymentID).
+			Return(before, nil)
+		deviceDeploymentStorage.On("AbortDeviceDeployments",
+			h.ContextMatcher(), deploymentID).
+			Return(nil)
+		deviceDeploymentStorage.On("AggregateDeviceDeploymentByStatus",
+			h.ContextMatcher(), deploymentID).
+			Return(after, nil)
+		deploymentStorage.On("UpdateStatsAndFinishDeployment",
+			h.ContextMatcher(), deploymentID, after).
+			Return(nil)
+
+		metrics.On("DeviceDeploymentStatusChanged", h.ContextMatcher(),
+			deployments.DeviceDeploymentStatusPending,
+			deployments.DeviceDeploymentStatusAborted, 3)
+		metrics.On("DeviceDeploymentStatusChanged", h.ContextMatcher(),
+			deployments.DeviceDeploymentStatusInstalling,
+			deployments.DeviceDeploymentStatusAborted, 1)
+		metrics.On("DeploymentFinished", h.ContextMatcher(),
+			mock.MatchedBy(func(d *deployments.Deployment) bool {
+				return d.Stats[deployments.DeviceDeploymentStatusAborted] == 4
+			}))
+
+		model := NewDeploymentModel(DeploymentsModelConfig{
+			DeploymentsStorage:       deploymentStorage,
+			DeviceDeploymentsStorage: deviceDeploymentStorage,
+			Metrics:                  metrics,
+		})
+
+		err := model.AbortDeployment(context.Background(), deploymentID)
+		assert.NoError(t, err)
+		metrics.AssertExpectations(t)
+	})
+
+	t.Run("decommissioned", func(t *testing.T) {
+		deviceDeploymentStorage := new(mocks.DeviceDeploymentStorage)
+		deploymentStorage := new(mocks.DeploymentsStorage)
+		metrics := new(mocks.DeploymentMetrics)
+
+		before := &deployments.Deployment{
+			Id: StringToPointer(deploymentID),
+			Stats: deployments.Stats{
+				deployments.DeviceDeploymentStatusPending: 2,
+			},
+		}
+		after := deployments.Stats{
+			deployments.DeviceDeploymentStatusPending:        1,
+			deployments.DeviceDeploymentStatusDecommissioned: 1,
+		}
+
+		deviceDeploymentStorage.On("DecommissionDeviceDeployments",
+			h.ContextMatcher(), "device").
+			Return(nil)
+		deviceDeploymentStorage.On("FindAllDeploymentsForDeviceIDWithStatuses",
+			h.ContextMatcher(), "device", mock.AnythingOfType("[]string")).
+			Return([]deployments.DeviceDeployment{
+				*deployments.NewDeviceDeployment("device", deploymentID),
+			}, nil)
+		deviceDeploymentStorage.On("AggregateDeviceDeploymentByStatus",
+			h.ContextMatcher(), deploymentID).
+			Return(after, nil)
+		deploymentStorage.On("FindByID", h.ContextMatcher(), deploymentID).
+			Return(before, nil)
+		deploymentStorage.On("UpdateStatsAndFinishDeployment",
+			h.ContextMatcher(), deploymentID, after).
+			Return(nil)
+
+		metrics.On("DeviceDeploymentStatusChanged", h.ContextMatcher(),
+			deployments.DeviceDeploymentStatusPending,
+			deployments.DeviceDeploymentStatusDecommissioned, 1)
+
+		model := NewDeploymentModel(DeploymentsModelConfig{
+			DeploymentsStorage:       deploymentStorage,
+			DeviceDeploymentsStorage: deviceDeploymentStorage,
+			Metrics:                  metrics,
+		})
+
+		err := model.DecommissionDevice(context.Background(), "device")
+		assert.NoError(t, err)
+		metrics.AssertExpectations(t)
+	})
 }
 
 func TestDeploymentModelDecommissionDevice(t *testing.T) {
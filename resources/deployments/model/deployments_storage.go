@@ -28,12 +28,19 @@ type DeploymentsStorage interface {
 	FindByID(ctx context.Context, id string) (*deployments.Deployment, error)
 	FindUnfinishedByID(ctx context.Context,
 		id string) (*deployments.Deployment, error)
+	FindByIdempotencyKey(ctx context.Context,
+		key string, since time.Time) (*deployments.Deployment, error)
+	ReleaseIdempotencyKey(ctx context.Context, key string, before time.Time) error
 	UpdateStats(ctx context.Context, id string, state_from, state_to string) error
 	UpdateStatsAndFinishDeployment(ctx context.Context,
 		id string, stats deployments.Stats) error
 	Find(ctx context.Context,
 		query deployments.Query) ([]*deployments.Deployment, error)
 	Finish(ctx context.Context, id string, when time.Time) error
+	Promote(ctx context.Context, id string, when time.Time) (bool, error)
+	Halt(ctx context.Context, id string, when time.Time) (bool, error)
 	ExistUnfinishedByArtifactId(ctx context.Context, id string) (bool, error)
+	FindUnfinishedByArtifactId(ctx context.Context,
+		id string) ([]*deployments.Deployment, error)
 	ExistByArtifactId(ctx context.Context, id string) (bool, error)
 }
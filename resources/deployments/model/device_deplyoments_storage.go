@@ -16,6 +16,7 @@ package model
 
 import (
 	"context"
+	"time"
 
 	"github.com/mendersoftware/deployments/resources/deployments"
 	"github.com/mendersoftware/deployments/resources/images"
@@ -34,19 +35,33 @@ type DeviceDeploymentStorage interface {
 
 	UpdateDeviceDeploymentStatus(ctx context.Context, deviceID string,
 		deploymentID string, status deployments.DeviceDeploymentStatus) (string, error)
+	FindDeviceDeployments(ctx context.Context,
+		updates []deployments.DeviceStatusUpdate) ([]deployments.DeviceDeployment, error)
+	UpdateDeviceDeploymentStatuses(ctx context.Context,
+		updates []deployments.DeviceStatusUpdate, now time.Time) error
 
 	UpdateDeviceDeploymentLogAvailability(ctx context.Context,
 		deviceID string, deploymentID string, log bool) error
 	AssignArtifact(ctx context.Context, deviceID string,
-		deploymentID string, artifact *images.SoftwareImage) error
+		deploymentID string, artifact *images.SoftwareImage, deviceType string) error
 	AggregateDeviceDeploymentByStatus(ctx context.Context,
 		id string) (deployments.Stats, error)
+	AggregateDeviceDeploymentByStatusForDeployments(ctx context.Context,
+		ids []string) ([]deployments.DeploymentStats, error)
+	AggregateDeviceDeploymentByArtifact(ctx context.Context,
+		query deployments.ArtifactStatsQuery) ([]deployments.ArtifactStats, error)
 	GetDeviceStatusesForDeployment(ctx context.Context,
 		deploymentID string) ([]deployments.DeviceDeployment, error)
+	GetDevicesListForDeployment(ctx context.Context,
+		query deployments.ListQuery) ([]deployments.DeviceDeployment, error)
 	HasDeploymentForDevice(ctx context.Context,
 		deploymentID string, deviceID string) (bool, error)
 	GetDeviceDeploymentStatus(ctx context.Context,
 		deploymentID string, deviceID string) (string, error)
 	AbortDeviceDeployments(ctx context.Context, deploymentID string) error
+	ReleaseDeviceDeployments(ctx context.Context, deploymentID string) error
+	AbortHeldDeviceDeployments(ctx context.Context, deploymentID string) error
 	DecommissionDeviceDeployments(ctx context.Context, deviceId string) error
+	FindLatestDeviceTypes(ctx context.Context,
+		deviceIDs []string) (map[string]string, error)
 }
@@ -21,8 +21,15 @@ import (
 	"github.com/mendersoftware/deployments/resources/images"
 )
 
-// Responsible for providing GET method requests to requested artifact
+// Responsible for providing GET method requests to requested artifact,
+// pinned to the version of the artifact file if given
 type GetRequester interface {
-	GetRequest(ctx context.Context, objectId string,
+	GetRequest(ctx context.Context, objectId, versionId string,
 		duration time.Duration, responseContentType string) (*images.Link, error)
 }
+
+// FileVersioner tells the version of the artifact file the download links
+// are pinned to, so that they can be revoked by rotating the file
+type FileVersioner interface {
+	FileVersion(ctx context.Context, imageID string) (string, error)
+}
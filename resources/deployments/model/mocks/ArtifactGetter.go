@@ -24,6 +24,29 @@ type ArtifactGetter struct {
 	mock.Mock
 }
 
+// DeltaImageByNameAndDeviceType provides a mock function with given fields: ctx, from, name, deviceType
+func (_m *ArtifactGetter) DeltaImageByNameAndDeviceType(ctx context.Context, from string, name string, deviceType string) (*images.SoftwareImage, error) {
+	ret := _m.Called(ctx, from, name, deviceType)
+
+	var r0 *images.SoftwareImage
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) *images.SoftwareImage); ok {
+		r0 = rf(ctx, from, name, deviceType)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.SoftwareImage)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) error); ok {
+		r1 = rf(ctx, from, name, deviceType)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ImageByIdsAndDeviceType provides a mock function with given fields: ctx, ids, deviceType
 func (_m *ArtifactGetter) ImageByIdsAndDeviceType(ctx context.Context, ids []string, deviceType string) (*images.SoftwareImage, error) {
 	ret := _m.Called(ctx, ids, deviceType)
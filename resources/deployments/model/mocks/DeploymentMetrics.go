@@ -0,0 +1,39 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mocks
+
+import context "context"
+import deployments "github.com/mendersoftware/deployments/resources/deployments"
+import mock "github.com/stretchr/testify/mock"
+
+// DeploymentMetrics is an autogenerated mock type for the DeploymentMetrics type
+type DeploymentMetrics struct {
+	mock.Mock
+}
+
+// DeploymentCreated provides a mock function with given fields: ctx, deployment
+func (_m *DeploymentMetrics) DeploymentCreated(ctx context.Context, deployment *deployments.Deployment) {
+	_m.Called(ctx, deployment)
+}
+
+// DeploymentFinished provides a mock function with given fields: ctx, deployment
+func (_m *DeploymentMetrics) DeploymentFinished(ctx context.Context, deployment *deployments.Deployment) {
+	_m.Called(ctx, deployment)
+}
+
+// DeviceDeploymentStatusChanged provides a mock function with given fields: ctx, from, to, count
+func (_m *DeploymentMetrics) DeviceDeploymentStatusChanged(ctx context.Context, from string, to string, count int) {
+	_m.Called(ctx, from, to, count)
+}
@@ -0,0 +1,29 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mocks
+
+import context "context"
+import deployments "github.com/mendersoftware/deployments/resources/deployments"
+import mock "github.com/stretchr/testify/mock"
+
+// DeploymentNotifier is an autogenerated mock type for the DeploymentNotifier type
+type DeploymentNotifier struct {
+	mock.Mock
+}
+
+// NotifyDeploymentFinished provides a mock function with given fields: ctx, deployment
+func (_m *DeploymentNotifier) NotifyDeploymentFinished(ctx context.Context, deployment *deployments.Deployment) {
+	_m.Called(ctx, deployment)
+}
@@ -127,6 +127,52 @@ func (_m *DeploymentsStorage) FindByID(ctx context.Context, id string) (*deploym
 	return r0, r1
 }
 
+// FindByIdempotencyKey provides a mock function with given fields: ctx, key, since
+func (_m *DeploymentsStorage) FindByIdempotencyKey(ctx context.Context, key string, since time.Time) (*deployments.Deployment, error) {
+	ret := _m.Called(ctx, key, since)
+
+	var r0 *deployments.Deployment
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) *deployments.Deployment); ok {
+		r0 = rf(ctx, key, since)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*deployments.Deployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, key, since)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindUnfinishedByArtifactId provides a mock function with given fields: ctx, id
+func (_m *DeploymentsStorage) FindUnfinishedByArtifactId(ctx context.Context, id string) ([]*deployments.Deployment, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 []*deployments.Deployment
+	if rf, ok := ret.Get(0).(func(context.Context, string) []*deployments.Deployment); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*deployments.Deployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FindUnfinishedByID provides a mock function with given fields: ctx, id
 func (_m *DeploymentsStorage) FindUnfinishedByID(ctx context.Context, id string) (*deployments.Deployment, error) {
 	ret := _m.Called(ctx, id)
@@ -164,6 +210,27 @@ func (_m *DeploymentsStorage) Finish(ctx context.Context, id string, when time.T
 	return r0
 }
 
+// Halt provides a mock function with given fields: ctx, id, when
+func (_m *DeploymentsStorage) Halt(ctx context.Context, id string, when time.Time) (bool, error) {
+	ret := _m.Called(ctx, id, when)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) bool); ok {
+		r0 = rf(ctx, id, when)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, id, when)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Insert provides a mock function with given fields: ctx, deployment
 func (_m *DeploymentsStorage) Insert(ctx context.Context, deployment *deployments.Deployment) error {
 	ret := _m.Called(ctx, deployment)
@@ -178,6 +245,41 @@ func (_m *DeploymentsStorage) Insert(ctx context.Context, deployment *deployment
 	return r0
 }
 
+// Promote provides a mock function with given fields: ctx, id, when
+func (_m *DeploymentsStorage) Promote(ctx context.Context, id string, when time.Time) (bool, error) {
+	ret := _m.Called(ctx, id, when)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) bool); ok {
+		r0 = rf(ctx, id, when)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, id, when)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReleaseIdempotencyKey provides a mock function with given fields: ctx, key, before
+func (_m *DeploymentsStorage) ReleaseIdempotencyKey(ctx context.Context, key string, before time.Time) error {
+	ret := _m.Called(ctx, key, before)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) error); ok {
+		r0 = rf(ctx, key, before)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateStats provides a mock function with given fields: ctx, id, state_from, state_to
 func (_m *DeploymentsStorage) UpdateStats(ctx context.Context, id string, state_from string, state_to string) error {
 	ret := _m.Called(ctx, id, state_from, state_to)
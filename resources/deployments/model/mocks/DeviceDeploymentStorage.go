@@ -19,6 +19,7 @@ import deployments "github.com/mendersoftware/deployments/resources/deployments"
 import images "github.com/mendersoftware/deployments/resources/images"
 import mock "github.com/stretchr/testify/mock"
 import model "github.com/mendersoftware/deployments/resources/deployments/model"
+import time "time"
 
 // DeviceDeploymentStorage is an autogenerated mock type for the DeviceDeploymentStorage type
 type DeviceDeploymentStorage struct {
@@ -39,6 +40,43 @@ func (_m *DeviceDeploymentStorage) AbortDeviceDeployments(ctx context.Context, d
 	return r0
 }
 
+// AbortHeldDeviceDeployments provides a mock function with given fields: ctx, deploymentID
+func (_m *DeviceDeploymentStorage) AbortHeldDeviceDeployments(ctx context.Context, deploymentID string) error {
+	ret := _m.Called(ctx, deploymentID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, deploymentID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AggregateDeviceDeploymentByArtifact provides a mock function with given fields: ctx, query
+func (_m *DeviceDeploymentStorage) AggregateDeviceDeploymentByArtifact(ctx context.Context, query deployments.ArtifactStatsQuery) ([]deployments.ArtifactStats, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []deployments.ArtifactStats
+	if rf, ok := ret.Get(0).(func(context.Context, deployments.ArtifactStatsQuery) []deployments.ArtifactStats); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]deployments.ArtifactStats)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, deployments.ArtifactStatsQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // AggregateDeviceDeploymentByStatus provides a mock function with given fields: ctx, id
 func (_m *DeviceDeploymentStorage) AggregateDeviceDeploymentByStatus(ctx context.Context, id string) (deployments.Stats, error) {
 	ret := _m.Called(ctx, id)
@@ -62,13 +100,36 @@ func (_m *DeviceDeploymentStorage) AggregateDeviceDeploymentByStatus(ctx context
 	return r0, r1
 }
 
-// AssignArtifact provides a mock function with given fields: ctx, deviceID, deploymentID, artifact
-func (_m *DeviceDeploymentStorage) AssignArtifact(ctx context.Context, deviceID string, deploymentID string, artifact *images.SoftwareImage) error {
-	ret := _m.Called(ctx, deviceID, deploymentID, artifact)
+// AggregateDeviceDeploymentByStatusForDeployments provides a mock function with given fields: ctx, ids
+func (_m *DeviceDeploymentStorage) AggregateDeviceDeploymentByStatusForDeployments(ctx context.Context, ids []string) ([]deployments.DeploymentStats, error) {
+	ret := _m.Called(ctx, ids)
+
+	var r0 []deployments.DeploymentStats
+	if rf, ok := ret.Get(0).(func(context.Context, []string) []deployments.DeploymentStats); ok {
+		r0 = rf(ctx, ids)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]deployments.DeploymentStats)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, ids)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AssignArtifact provides a mock function with given fields: ctx, deviceID, deploymentID, artifact, deviceType
+func (_m *DeviceDeploymentStorage) AssignArtifact(ctx context.Context, deviceID string, deploymentID string, artifact *images.SoftwareImage, deviceType string) error {
+	ret := _m.Called(ctx, deviceID, deploymentID, artifact, deviceType)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string, *images.SoftwareImage) error); ok {
-		r0 = rf(ctx, deviceID, deploymentID, artifact)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, *images.SoftwareImage, string) error); ok {
+		r0 = rf(ctx, deviceID, deploymentID, artifact, deviceType)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -134,6 +195,52 @@ func (_m *DeviceDeploymentStorage) FindAllDeploymentsForDeviceIDWithStatuses(ctx
 	return r0, r1
 }
 
+// FindDeviceDeployments provides a mock function with given fields: ctx, updates
+func (_m *DeviceDeploymentStorage) FindDeviceDeployments(ctx context.Context, updates []deployments.DeviceStatusUpdate) ([]deployments.DeviceDeployment, error) {
+	ret := _m.Called(ctx, updates)
+
+	var r0 []deployments.DeviceDeployment
+	if rf, ok := ret.Get(0).(func(context.Context, []deployments.DeviceStatusUpdate) []deployments.DeviceDeployment); ok {
+		r0 = rf(ctx, updates)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]deployments.DeviceDeployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []deployments.DeviceStatusUpdate) error); ok {
+		r1 = rf(ctx, updates)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindLatestDeviceTypes provides a mock function with given fields: ctx, deviceIDs
+func (_m *DeviceDeploymentStorage) FindLatestDeviceTypes(ctx context.Context, deviceIDs []string) (map[string]string, error) {
+	ret := _m.Called(ctx, deviceIDs)
+
+	var r0 map[string]string
+	if rf, ok := ret.Get(0).(func(context.Context, []string) map[string]string); ok {
+		r0 = rf(ctx, deviceIDs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, deviceIDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FindOldestDeploymentForDeviceIDWithStatuses provides a mock function with given fields: ctx, deviceID, statuses
 func (_m *DeviceDeploymentStorage) FindOldestDeploymentForDeviceIDWithStatuses(ctx context.Context, deviceID string, statuses ...string) (*deployments.DeviceDeployment, error) {
 	ret := _m.Called(ctx, deviceID, statuses)
@@ -201,6 +308,29 @@ func (_m *DeviceDeploymentStorage) GetDeviceStatusesForDeployment(ctx context.Co
 	return r0, r1
 }
 
+// GetDevicesListForDeployment provides a mock function with given fields: ctx, query
+func (_m *DeviceDeploymentStorage) GetDevicesListForDeployment(ctx context.Context, query deployments.ListQuery) ([]deployments.DeviceDeployment, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []deployments.DeviceDeployment
+	if rf, ok := ret.Get(0).(func(context.Context, deployments.ListQuery) []deployments.DeviceDeployment); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]deployments.DeviceDeployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, deployments.ListQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // HasDeploymentForDevice provides a mock function with given fields: ctx, deploymentID, deviceID
 func (_m *DeviceDeploymentStorage) HasDeploymentForDevice(ctx context.Context, deploymentID string, deviceID string) (bool, error) {
 	ret := _m.Called(ctx, deploymentID, deviceID)
@@ -236,6 +366,20 @@ func (_m *DeviceDeploymentStorage) InsertMany(ctx context.Context, deployment ..
 	return r0
 }
 
+// ReleaseDeviceDeployments provides a mock function with given fields: ctx, deploymentID
+func (_m *DeviceDeploymentStorage) ReleaseDeviceDeployments(ctx context.Context, deploymentID string) error {
+	ret := _m.Called(ctx, deploymentID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, deploymentID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateDeviceDeploymentLogAvailability provides a mock function with given fields: ctx, deviceID, deploymentID, log
 func (_m *DeviceDeploymentStorage) UpdateDeviceDeploymentLogAvailability(ctx context.Context, deviceID string, deploymentID string, log bool) error {
 	ret := _m.Called(ctx, deviceID, deploymentID, log)
@@ -271,4 +415,18 @@ func (_m *DeviceDeploymentStorage) UpdateDeviceDeploymentStatus(ctx context.Cont
 	return r0, r1
 }
 
+// UpdateDeviceDeploymentStatuses provides a mock function with given fields: ctx, updates, now
+func (_m *DeviceDeploymentStorage) UpdateDeviceDeploymentStatuses(ctx context.Context, updates []deployments.DeviceStatusUpdate, now time.Time) error {
+	ret := _m.Called(ctx, updates, now)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []deployments.DeviceStatusUpdate, time.Time) error); ok {
+		r0 = rf(ctx, updates, now)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 var _ model.DeviceDeploymentStorage = (*DeviceDeploymentStorage)(nil)
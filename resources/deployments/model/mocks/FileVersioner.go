@@ -0,0 +1,47 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mocks
+
+import context "context"
+import mock "github.com/stretchr/testify/mock"
+import model "github.com/mendersoftware/deployments/resources/deployments/model"
+
+// FileVersioner is an autogenerated mock type for the FileVersioner type
+type FileVersioner struct {
+	mock.Mock
+}
+
+// FileVersion provides a mock function with given fields: ctx, imageID
+func (_m *FileVersioner) FileVersion(ctx context.Context, imageID string) (string, error) {
+	ret := _m.Called(ctx, imageID)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, imageID)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, imageID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+var _ model.FileVersioner = (*FileVersioner)(nil)
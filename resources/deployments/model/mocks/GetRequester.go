@@ -25,13 +25,13 @@ type GetRequester struct {
 	mock.Mock
 }
 
-// GetRequest provides a mock function with given fields: ctx, objectId, duration, responseContentType
-func (_m *GetRequester) GetRequest(ctx context.Context, objectId string, duration time.Duration, responseContentType string) (*images.Link, error) {
-	ret := _m.Called(ctx, objectId, duration, responseContentType)
+// GetRequest provides a mock function with given fields: ctx, objectId, versionId, duration, responseContentType
+func (_m *GetRequester) GetRequest(ctx context.Context, objectId string, versionId string, duration time.Duration, responseContentType string) (*images.Link, error) {
+	ret := _m.Called(ctx, objectId, versionId, duration, responseContentType)
 
 	var r0 *images.Link
-	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration, string) *images.Link); ok {
-		r0 = rf(ctx, objectId, duration, responseContentType)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Duration, string) *images.Link); ok {
+		r0 = rf(ctx, objectId, versionId, duration, responseContentType)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*images.Link)
@@ -39,8 +39,8 @@ func (_m *GetRequester) GetRequest(ctx context.Context, objectId string, duratio
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, string, time.Duration, string) error); ok {
-		r1 = rf(ctx, objectId, duration, responseContentType)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, time.Duration, string) error); ok {
+		r1 = rf(ctx, objectId, versionId, duration, responseContentType)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -25,6 +25,7 @@ import (
 	"gopkg.in/mgo.v2/bson"
 
 	"github.com/mendersoftware/deployments/resources/deployments"
+	"github.com/mendersoftware/deployments/utils/readpref"
 )
 
 // Database settings
@@ -49,10 +50,19 @@ const (
 	StorageKeyDeploymentStats        = "stats"
 	StorageKeyDeploymentFinished     = "finished"
 	StorageKeyDeploymentArtifacts    = "artifacts"
+	StorageKeyDeploymentCreated      = "created"
+	StorageKeyDeploymentIdempotency  = "idempotency_key"
+	StorageKeyDeploymentPromoted     = "promoted"
+	StorageKeyDeploymentHalted       = "halted"
 )
 
 const (
 	IndexDeploymentArtifactNameStr = "deploymentArtifactNameIndex"
+	IndexDeploymentArtifactsStr    = "deploymentArtifactsIndex"
+	// IndexDeploymentIdempotencyStr is the former non unique index of
+	// the idempotency keys, replaced by IndexDeploymentUniqueIdempotencyStr
+	IndexDeploymentIdempotencyStr       = "deploymentIdempotencyKeyIndex"
+	IndexDeploymentUniqueIdempotencyStr = "deploymentUniqueIdempotencyKeyIndex"
 )
 
 var (
@@ -60,20 +70,43 @@ var (
 		"$text:" + StorageKeyDeploymentName,
 		"$text:" + StorageKeyDeploymentArtifactName,
 	}
+	StorageArtifactsIndexes = []string{
+		StorageKeyDeploymentArtifacts,
+		"-" + StorageKeyDeploymentCreated,
+	}
+	StorageIdempotencyIndexes = []string{
+		StorageKeyDeploymentIdempotency,
+	}
 )
 
 // DeploymentsStorage is a data layer for deployments based on MongoDB
 type DeploymentsStorage struct {
-	session *mgo.Session
+	session  *mgo.Session
+	readMode mgo.Mode
 }
 
 // NewDeploymentsStorage new data layer object
 func NewDeploymentsStorage(session *mgo.Session) *DeploymentsStorage {
 	return &DeploymentsStorage{
-		session: session,
+		session:  session,
+		readMode: mgo.Primary,
 	}
 }
 
+// SetReadMode sets the mode of the reads allowed to be served
+// by the secondaries, see readpref.WithSecondaryReads.
+func (d *DeploymentsStorage) SetReadMode(mode mgo.Mode) {
+	d.readMode = mode
+}
+
+// copyReadSession copies the session for a read, with the read mode
+// applied if the context allows the secondary reads.
+func (d *DeploymentsStorage) copyReadSession(ctx context.Context) *mgo.Session {
+	session := d.session.Copy()
+	readpref.SetMode(ctx, session, d.readMode)
+	return session
+}
+
 func (d *DeploymentsStorage) EnsureIndexing(ctx context.Context, session *mgo.Session) error {
 	db := store.DbFromContext(ctx, DatabaseName)
 
@@ -84,12 +117,45 @@ func (d *DeploymentsStorage) DoEnsureIndexing(db string, session *mgo.Session) e
 	deploymentArtifactNameIndex := mgo.Index{
 		Key:        StorageIndexes,
 		Name:       IndexDeploymentArtifactNameStr,
-		Background: false,
+		Background: true,
 	}
 
-	return session.DB(db).
+	err := session.DB(db).
 		C(CollectionDeployments).
 		EnsureIndex(deploymentArtifactNameIndex)
+	if err != nil {
+		return err
+	}
+
+	// used for listing deployments referencing given artifact
+	deploymentArtifactsIndex := mgo.Index{
+		Key:        StorageArtifactsIndexes,
+		Name:       IndexDeploymentArtifactsStr,
+		Background: true,
+	}
+
+	err = session.DB(db).
+		C(CollectionDeployments).
+		EnsureIndex(deploymentArtifactsIndex)
+	if err != nil {
+		return err
+	}
+
+	// used for finding deployments by the creation request idempotency key,
+	// the key is unique among the deployments created with one; mgo.Index
+	// does not support the partial indexes, hence the command
+	return session.DB(db).Run(bson.D{
+		{Name: "createIndexes", Value: CollectionDeployments},
+		{Name: "indexes", Value: []bson.M{{
+			"key":        bson.D{{Name: StorageKeyDeploymentIdempotency, Value: 1}},
+			"name":       IndexDeploymentUniqueIdempotencyStr,
+			"unique":     true,
+			"background": true,
+			"partialFilterExpression": bson.M{
+				StorageKeyDeploymentIdempotency: bson.M{"$type": "string"},
+			},
+		}}},
+	}, nil)
 }
 
 // return true if required indexing was set up
@@ -137,6 +203,9 @@ func (d *DeploymentsStorage) Insert(ctx context.Context, deployment *deployments
 
 	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
 		C(CollectionDeployments).Insert(deployment); err != nil {
+		if mgo.IsDup(err) && deployment.IdempotencyKey != "" {
+			return deployments.ErrDuplicateIdempotencyKey
+		}
 		return err
 	}
 	return nil
@@ -170,7 +239,7 @@ func (d *DeploymentsStorage) FindByID(ctx context.Context, id string) (*deployme
 		return nil, ErrStorageInvalidID
 	}
 
-	session := d.session.Copy()
+	session := d.copyReadSession(ctx)
 	defer session.Close()
 
 	var deployment *deployments.Deployment
@@ -211,6 +280,90 @@ func (d *DeploymentsStorage) FindUnfinishedByID(ctx context.Context,
 	return deployment, nil
 }
 
+// FindUnfinishedByArtifactId returns the active deployments which use
+// given artifact
+func (d *DeploymentsStorage) FindUnfinishedByArtifactId(ctx context.Context,
+	id string) ([]*deployments.Deployment, error) {
+
+	if govalidator.IsNull(id) {
+		return nil, ErrStorageInvalidID
+	}
+
+	session := d.session.Copy()
+	defer session.Close()
+
+	var found []*deployments.Deployment
+	query := bson.M{
+		StorageKeyDeploymentFinished:  nil,
+		StorageKeyDeploymentArtifacts: id,
+	}
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Find(query).All(&found); err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// ReleaseIdempotencyKey removes the idempotency key from the deployments
+// created with it before given time, so that the key can be used again.
+func (d *DeploymentsStorage) ReleaseIdempotencyKey(ctx context.Context,
+	key string, before time.Time) error {
+
+	if govalidator.IsNull(key) {
+		return ErrStorageInvalidInput
+	}
+
+	session := d.session.Copy()
+	defer session.Close()
+
+	filter := bson.M{
+		StorageKeyDeploymentIdempotency: key,
+		StorageKeyDeploymentCreated: bson.M{
+			"$lt": before,
+		},
+	}
+	update := bson.M{
+		"$unset": bson.M{
+			StorageKeyDeploymentIdempotency: "",
+		},
+	}
+	_, err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).UpdateAll(filter, update)
+	return err
+}
+
+// FindByIdempotencyKey returns the latest deployment created with given
+// idempotency key not earlier than since, nil if there is none.
+func (d *DeploymentsStorage) FindByIdempotencyKey(ctx context.Context,
+	key string, since time.Time) (*deployments.Deployment, error) {
+
+	if govalidator.IsNull(key) {
+		return nil, ErrStorageInvalidInput
+	}
+
+	session := d.session.Copy()
+	defer session.Close()
+
+	var deployment *deployments.Deployment
+	filter := bson.M{
+		StorageKeyDeploymentIdempotency: key,
+		StorageKeyDeploymentCreated: bson.M{
+			"$gte": since,
+		},
+	}
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Find(filter).
+		Sort("-" + StorageKeyDeploymentCreated).One(&deployment); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return deployment, nil
+}
+
 func (d *DeploymentsStorage) UpdateStatsAndFinishDeployment(ctx context.Context,
 	id string, stats deployments.Stats) error {
 
@@ -412,7 +565,7 @@ func buildStatusQuery(status deployments.StatusQuery) bson.M {
 func (d *DeploymentsStorage) Find(ctx context.Context,
 	match deployments.Query) ([]*deployments.Deployment, error) {
 
-	session := d.session.Copy()
+	session := d.copyReadSession(ctx)
 	defer session.Close()
 
 	andq := []bson.M{}
@@ -439,6 +592,26 @@ func (d *DeploymentsStorage) Find(ctx context.Context,
 		andq = append(andq, stq)
 	}
 
+	// build deployment by artifact part of the query
+	if match.ArtifactID != "" {
+		andq = append(andq, bson.M{
+			StorageKeyDeploymentArtifacts: match.ArtifactID,
+		})
+	}
+
+	// the deployments created at the same time are ordered by ID
+	if match.AfterCreated != nil {
+		andq = append(andq, bson.M{
+			"$or": []bson.M{
+				{StorageKeyDeploymentCreated: bson.M{"$lt": match.AfterCreated}},
+				{
+					StorageKeyDeploymentCreated: match.AfterCreated,
+					"_id":                       bson.M{"$lt": match.AfterID},
+				},
+			},
+		})
+	}
+
 	query := bson.M{}
 	if len(andq) != 0 {
 		// use search criteria if any
@@ -449,7 +622,7 @@ func (d *DeploymentsStorage) Find(ctx context.Context,
 	var deployment []*deployments.Deployment
 	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
 		C(CollectionDeployments).
-		Find(&query).Sort("-created").
+		Find(&query).Sort("-"+StorageKeyDeploymentCreated, "-_id").
 		Skip(match.Skip).Limit(match.Limit).
 		All(&deployment)
 	if err != nil {
@@ -484,6 +657,74 @@ func (d *DeploymentsStorage) Finish(ctx context.Context, id string, when time.Ti
 	return err
 }
 
+// Promote sets the time the canary deployment was promoted at, unless it was
+// promoted or halted already. Returns false if the deployment is not changed.
+func (d *DeploymentsStorage) Promote(ctx context.Context, id string,
+	when time.Time) (bool, error) {
+
+	if govalidator.IsNull(id) {
+		return false, ErrStorageInvalidID
+	}
+
+	session := d.session.Copy()
+	defer session.Close()
+
+	// promotion and halt exclude each other
+	query := bson.M{
+		"_id":                        id,
+		StorageKeyDeploymentPromoted: nil,
+		StorageKeyDeploymentHalted:   nil,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			StorageKeyDeploymentPromoted: &when,
+		},
+	}
+
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Update(query, update)
+
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Halt sets the time the canary deployment was halted at, unless it was
+// promoted or halted already. Returns false if the deployment is not changed.
+func (d *DeploymentsStorage) Halt(ctx context.Context, id string,
+	when time.Time) (bool, error) {
+
+	if govalidator.IsNull(id) {
+		return false, ErrStorageInvalidID
+	}
+
+	session := d.session.Copy()
+	defer session.Close()
+
+	// promotion and halt exclude each other
+	query := bson.M{
+		"_id":                        id,
+		StorageKeyDeploymentPromoted: nil,
+		StorageKeyDeploymentHalted:   nil,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			StorageKeyDeploymentHalted: &when,
+		},
+	}
+
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Update(query, update)
+
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
 // ExistUnfinishedByArtifactId checks if there is an active deployment that uses
 // given artifact
 func (d *DeploymentsStorage) ExistUnfinishedByArtifactId(ctx context.Context,
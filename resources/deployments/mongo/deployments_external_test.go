@@ -609,6 +609,51 @@ func TestDeploymentStorageFindUnfinishedByID(t *testing.T) {
 	}
 }
 
+func TestDeploymentStorageFindUnfinishedByArtifactId(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentStorageFindUnfinishedByArtifactId in short mode.")
+	}
+
+	artifactID := "b532b01a-9313-404f-8d19-e7fcbe5cc347"
+	now := time.Now()
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDeploymentsStorage(session)
+
+	ctx := context.Background()
+	dep := session.DB(DatabaseName).C(CollectionDeployments)
+	assert.NoError(t, dep.Insert(
+		&deployments.Deployment{
+			Id:        StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+			Artifacts: []string{artifactID},
+		},
+		&deployments.Deployment{
+			Id:        StringToPointer("d1804903-5caa-4a73-a3ae-0efcc3205405"),
+			Artifacts: []string{artifactID},
+			Finished:  &now,
+		},
+		&deployments.Deployment{
+			Id:        StringToPointer("e9e1cc4d-3e5d-4e39-8e32-c3d1b1c2b6a4"),
+			Artifacts: []string{"6f57e4b0-fc6c-4cfb-9e4b-2b1a3ea7b7b6"},
+		},
+	))
+
+	_, err := store.FindUnfinishedByArtifactId(ctx, "")
+	assert.EqualError(t, err, ErrStorageInvalidID.Error())
+
+	found, err := store.FindUnfinishedByArtifactId(ctx, artifactID)
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "a108ae14-bb4e-455f-9b40-2ef4bab97bb7", *found[0].Id)
+	}
+
+	found, err = store.FindUnfinishedByArtifactId(ctx, "0f7bc1e6-7e3f-4e5a-9a2c-8c5b0c4f6d1e")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+}
+
 func TestDeploymentStorageUpdateStats(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping TestDeploymentStorageUpdateStats in short mode.")
@@ -1029,7 +1074,8 @@ func TestDeploymentStorageFindBy(t *testing.T) {
 				ArtifactName: StringToPointer("bar"),
 				Devices:      []string{"b532b01a-9313-404f-8d19-e7fcbe5cc347"},
 			},
-			Id: StringToPointer("a108ae14-bb4e-455f-9b40-000000000003"),
+			Id:        StringToPointer("a108ae14-bb4e-455f-9b40-000000000003"),
+			Artifacts: []string{"f7a9a6c0-5b3c-4bb5-8a6e-6a7c7e8ed1a1"},
 			Stats: newTestStats(deployments.Stats{
 				deployments.DeviceDeploymentStatusFailure: 2,
 			}),
@@ -1040,7 +1086,8 @@ func TestDeploymentStorageFindBy(t *testing.T) {
 				ArtifactName: StringToPointer("bar"),
 				Devices:      []string{"b532b01a-9313-404f-8d19-e7fcbe5cc347"},
 			},
-			Id: StringToPointer("a108ae14-bb4e-455f-9b40-000000000004"),
+			Id:        StringToPointer("a108ae14-bb4e-455f-9b40-000000000004"),
+			Artifacts: []string{"0d3a7a3e-3b49-4a7d-9d6e-0c4f0f3bd5b2"},
 			Stats: newTestStats(deployments.Stats{
 				deployments.DeviceDeploymentStatusNoArtifact: 1,
 			}),
@@ -1051,7 +1098,8 @@ func TestDeploymentStorageFindBy(t *testing.T) {
 				ArtifactName: StringToPointer("bar"),
 				Devices:      []string{"b532b01a-9313-404f-8d19-e7fcbe5cc347"},
 			},
-			Id: StringToPointer("a108ae14-bb4e-455f-9b40-000000000005"),
+			Id:        StringToPointer("a108ae14-bb4e-455f-9b40-000000000005"),
+			Artifacts: []string{"f7a9a6c0-5b3c-4bb5-8a6e-6a7c7e8ed1a1"},
 			Stats: newTestStats(deployments.Stats{
 				deployments.DeviceDeploymentStatusDownloading: 1,
 			}),
@@ -1356,6 +1404,35 @@ func TestDeploymentStorageFindBy(t *testing.T) {
 				"a108ae14-bb4e-455f-9b40-000000000011",
 			},
 		},
+		{
+			InputModelQuery: deployments.Query{
+				ArtifactID: "f7a9a6c0-5b3c-4bb5-8a6e-6a7c7e8ed1a1",
+			},
+			InputDeploymentsCollection: someDeployments,
+			OutputError:                nil,
+			OutputID: []string{
+				"a108ae14-bb4e-455f-9b40-000000000005",
+				"a108ae14-bb4e-455f-9b40-000000000003",
+			},
+		},
+		{
+			InputModelQuery: deployments.Query{
+				ArtifactID: "f7a9a6c0-5b3c-4bb5-8a6e-6a7c7e8ed1a1",
+				Status:     deployments.StatusQueryFinished,
+			},
+			InputDeploymentsCollection: someDeployments,
+			OutputError:                nil,
+			OutputID: []string{
+				"a108ae14-bb4e-455f-9b40-000000000003",
+			},
+		},
+		{
+			InputModelQuery: deployments.Query{
+				ArtifactID: "a4c5e6f7-1b2c-4d3e-8f9a-0b1c2d3e4f5a",
+			},
+			InputDeploymentsCollection: someDeployments,
+			OutputError:                nil,
+		},
 		{
 			InputModelQuery: deployments.Query{
 				SearchText: "NYC",
@@ -1459,6 +1536,140 @@ func TestDeploymentStorageFindBy(t *testing.T) {
 	}
 }
 
+func TestDeploymentStorageFindByIdempotencyKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentStorageFindByIdempotencyKey in short mode.")
+	}
+
+	now := time.Now().Round(time.Millisecond)
+	hourAgo := now.Add(-time.Hour)
+
+	inputDeployments := []*deployments.Deployment{
+		{
+			Id:             StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+			Created:        &hourAgo,
+			IdempotencyKey: "key-1",
+		},
+		{
+			Id:             StringToPointer("d1804903-5caa-4a73-a3ae-0efcc3205405"),
+			Created:        &now,
+			IdempotencyKey: "key-2",
+		},
+		{
+			Id:      StringToPointer("8c2f5d2e-1b53-4a1e-b7a7-5c9d3fbb1a3c"),
+			Created: &now,
+		},
+	}
+
+	testCases := map[string]struct {
+		InputKey   string
+		InputSince time.Time
+
+		OutputID    string
+		OutputError error
+	}{
+		"found": {
+			InputKey:   "key-1",
+			InputSince: now.Add(-2 * time.Hour),
+			OutputID:   "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+		},
+		"expired": {
+			InputKey:   "key-1",
+			InputSince: now.Add(-time.Minute),
+		},
+		"unknown key": {
+			InputKey:   "key-3",
+			InputSince: now.Add(-2 * time.Hour),
+		},
+		"empty key": {
+			InputSince:  now.Add(-2 * time.Hour),
+			OutputError: ErrStorageInvalidInput,
+		},
+	}
+
+	for testCaseName, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
+
+			db.Wipe()
+
+			session := db.Session()
+			store := NewDeploymentsStorage(session)
+
+			ctx := context.Background()
+			dep := session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+				C(CollectionDeployments)
+			for _, d := range inputDeployments {
+				assert.NoError(t, dep.Insert(d))
+			}
+
+			deployment, err := store.FindByIdempotencyKey(ctx, tc.InputKey, tc.InputSince)
+			if tc.OutputError != nil {
+				assert.EqualError(t, err, tc.OutputError.Error())
+			} else {
+				assert.NoError(t, err)
+				if tc.OutputID == "" {
+					assert.Nil(t, deployment)
+				} else if assert.NotNil(t, deployment) {
+					assert.Equal(t, tc.OutputID, *deployment.Id)
+					assert.Equal(t, tc.InputKey, deployment.IdempotencyKey)
+				}
+			}
+
+			session.Close()
+		})
+	}
+}
+
+func TestDeploymentStorageReleaseIdempotencyKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentStorageReleaseIdempotencyKey in short mode.")
+	}
+
+	now := time.Now().Round(time.Millisecond)
+	hourAgo := now.Add(-time.Hour)
+
+	db.Wipe()
+
+	session := db.Session()
+	defer session.Close()
+	store := NewDeploymentsStorage(session)
+	ctx := context.Background()
+
+	expired := &deployments.Deployment{
+		Id:             StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+		Created:        &hourAgo,
+		IdempotencyKey: "key-1",
+	}
+	dep := session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments)
+	assert.NoError(t, store.EnsureIndexing(ctx, session))
+	assert.NoError(t, dep.Insert(expired))
+
+	// the key is unique
+	duplicate := *expired
+	duplicate.Id = StringToPointer("d1804903-5caa-4a73-a3ae-0efcc3205405")
+	duplicate.Created = &now
+	assert.NoError(t, duplicate.Validate())
+	err := store.Insert(ctx, &duplicate)
+	assert.Equal(t, deployments.ErrDuplicateIdempotencyKey, err)
+
+	assert.Equal(t, ErrStorageInvalidInput,
+		store.ReleaseIdempotencyKey(ctx, "", now.Add(-time.Minute)))
+
+	// keys used since are kept
+	assert.NoError(t, store.ReleaseIdempotencyKey(ctx, "key-1", now.Add(-2*time.Hour)))
+	deployment, err := store.FindByIdempotencyKey(ctx, "key-1", now.Add(-2*time.Hour))
+	assert.NoError(t, err)
+	assert.NotNil(t, deployment)
+
+	// keys used before are released and can be used again
+	assert.NoError(t, store.ReleaseIdempotencyKey(ctx, "key-1", now.Add(-time.Minute)))
+	deployment, err = store.FindByIdempotencyKey(ctx, "key-1", now.Add(-2*time.Hour))
+	assert.NoError(t, err)
+	assert.Nil(t, deployment)
+	assert.NoError(t, dep.Insert(&duplicate))
+}
+
 func TestDeploymentFinish(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping TestDeploymentFinish in short mode.")
@@ -1544,3 +1755,66 @@ func TestDeploymentFinish(t *testing.T) {
 		})
 	}
 }
+
+func TestDeploymentPromoteAndHalt(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentPromoteAndHalt in short mode.")
+	}
+
+	db.Wipe()
+
+	session := db.Session()
+	defer session.Close()
+	store := NewDeploymentsStorage(session)
+	ctx := context.Background()
+
+	dep := session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments)
+	promoted := &deployments.Deployment{
+		Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+	}
+	halted := &deployments.Deployment{
+		Id: StringToPointer("d1804903-5caa-4a73-a3ae-0efcc3205405"),
+	}
+	assert.NoError(t, dep.Insert(promoted, halted))
+
+	now := time.Now()
+
+	changed, err := store.Promote(ctx, *promoted.Id, now)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = store.Halt(ctx, *halted.Id, now)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	// promotion and halt are recorded once and exclude each other
+	changed, err = store.Promote(ctx, *promoted.Id, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	changed, err = store.Halt(ctx, *promoted.Id, now)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	changed, err = store.Promote(ctx, *halted.Id, now)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	deployment, err := store.FindByID(ctx, *promoted.Id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, deployment.Promoted) {
+		assert.WithinDuration(t, now, *deployment.Promoted, time.Second)
+	}
+	assert.Nil(t, deployment.Halted)
+
+	deployment, err = store.FindByID(ctx, *halted.Id)
+	assert.NoError(t, err)
+	assert.NotNil(t, deployment.Halted)
+	assert.Nil(t, deployment.Promoted)
+
+	// nonexistent deployment is not changed
+	changed, err = store.Promote(ctx, "5f1c4a1e-7b1d-4c0e-9e5b-3a1c9c8e2d11", now)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = store.Halt(ctx, "", now)
+	assert.EqualError(t, err, ErrStorageInvalidID.Error())
+}
@@ -16,6 +16,7 @@ package mongo
 
 import (
 	"context"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/mendersoftware/go-lib-micro/store"
@@ -26,6 +27,7 @@ import (
 	"github.com/mendersoftware/deployments/resources/deployments"
 	"github.com/mendersoftware/deployments/resources/images"
 	imagesMongo "github.com/mendersoftware/deployments/resources/images/mongo"
+	"github.com/mendersoftware/deployments/utils/readpref"
 )
 
 // Database settings
@@ -44,6 +46,28 @@ const (
 	StorageKeyDeviceDeploymentFinished        = "finished"
 	StorageKeyDeviceDeploymentIsLogAvailable  = "log"
 	StorageKeyDeviceDeploymentArtifact        = "image"
+	StorageKeyDeviceDeploymentArtifactName    = StorageKeyDeviceDeploymentAssignedImage + "." + imagesMongo.StorageKeySoftwareImageName
+	StorageKeyDeviceDeploymentDeviceType      = "devicetype"
+	StorageKeyDeviceDeploymentCreated         = "created"
+	StorageKeyDeviceDeploymentHeld            = "held"
+)
+
+// Indexes
+const (
+	IndexDeviceDeploymentStatusStr   = "deploymentid_status_deviceid"
+	IndexDeviceDeploymentFinishedStr = "status_finished"
+)
+
+var (
+	DeviceDeploymentStatusIndex = []string{
+		StorageKeyDeviceDeploymentDeploymentID,
+		StorageKeyDeviceDeploymentStatus,
+		StorageKeyDeviceDeploymentDeviceId,
+	}
+	DeviceDeploymentFinishedIndex = []string{
+		StorageKeyDeviceDeploymentStatus,
+		StorageKeyDeviceDeploymentFinished,
+	}
 )
 
 // Errors
@@ -53,16 +77,53 @@ var (
 
 // DeviceDeploymentsStorage is a data layer for deployments based on MongoDB
 type DeviceDeploymentsStorage struct {
-	session *mgo.Session
+	session  *mgo.Session
+	readMode mgo.Mode
 }
 
 // NewDeviceDeploymentsStorage new data layer object
 func NewDeviceDeploymentsStorage(session *mgo.Session) *DeviceDeploymentsStorage {
 	return &DeviceDeploymentsStorage{
-		session: session,
+		session:  session,
+		readMode: mgo.Primary,
 	}
 }
 
+// SetReadMode sets the mode of the reads allowed to be served
+// by the secondaries, see readpref.WithSecondaryReads.
+func (d *DeviceDeploymentsStorage) SetReadMode(mode mgo.Mode) {
+	d.readMode = mode
+}
+
+// copyReadSession copies the session for a read, with the read mode
+// applied if the context allows the secondary reads.
+func (d *DeviceDeploymentsStorage) copyReadSession(ctx context.Context) *mgo.Session {
+	session := d.session.Copy()
+	readpref.SetMode(ctx, session, d.readMode)
+	return session
+}
+
+// DoEnsureIndexing creates the indexes backing device deployment lookups
+// by deployment and status, and by status and finish time.
+func (d *DeviceDeploymentsStorage) DoEnsureIndexing(db string, session *mgo.Session) error {
+	deviceDeploymentStatusIndex := mgo.Index{
+		Key:        DeviceDeploymentStatusIndex,
+		Name:       IndexDeviceDeploymentStatusStr,
+		Background: true,
+	}
+	deviceDeploymentFinishedIndex := mgo.Index{
+		Key:        DeviceDeploymentFinishedIndex,
+		Name:       IndexDeviceDeploymentFinishedStr,
+		Background: true,
+	}
+
+	coll := session.DB(db).C(CollectionDevices)
+	if err := coll.EnsureIndex(deviceDeploymentStatusIndex); err != nil {
+		return err
+	}
+	return coll.EnsureIndex(deviceDeploymentFinishedIndex)
+}
+
 // InsertMany stores multiple device deployment objects.
 // TODO: Handle error cleanup, multi insert is not atomic, loop into two-phase commits
 func (d *DeviceDeploymentsStorage) InsertMany(ctx context.Context,
@@ -258,6 +319,82 @@ func (d *DeviceDeploymentsStorage) UpdateDeviceDeploymentStatus(ctx context.Cont
 	return *old.Status, nil
 }
 
+// FindDeviceDeployments finds the device deployments the status updates
+// are of in a single query, with their IDs and statuses only.
+func (d *DeviceDeploymentsStorage) FindDeviceDeployments(ctx context.Context,
+	updates []deployments.DeviceStatusUpdate) ([]deployments.DeviceDeployment, error) {
+
+	if len(updates) == 0 {
+		return []deployments.DeviceDeployment{}, nil
+	}
+
+	session := d.session.Copy()
+	defer session.Close()
+
+	or := make([]bson.M, len(updates))
+	for i, update := range updates {
+		or[i] = bson.M{
+			StorageKeyDeviceDeploymentDeploymentID: update.DeploymentID,
+			StorageKeyDeviceDeploymentDeviceId:     update.DeviceID,
+		}
+	}
+
+	var found []deployments.DeviceDeployment
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Find(bson.M{"$or": or}).
+		Select(bson.M{
+			StorageKeyDeviceDeploymentDeploymentID: 1,
+			StorageKeyDeviceDeploymentDeviceId:     1,
+			StorageKeyDeviceDeploymentStatus:       1,
+		}).All(&found)
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// UpdateDeviceDeploymentStatuses applies the status updates in a single
+// bulk write, in order; the finish time is set for the finished statuses.
+// Device deployments aborted or decommissioned in the meantime are left
+// as they are.
+func (d *DeviceDeploymentsStorage) UpdateDeviceDeploymentStatuses(ctx context.Context,
+	updates []deployments.DeviceStatusUpdate, now time.Time) error {
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	session := d.session.Copy()
+	defer session.Close()
+
+	bulk := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Bulk()
+	for _, update := range updates {
+		set := bson.M{
+			StorageKeyDeviceDeploymentStatus: update.Status,
+		}
+		if deployments.IsDeviceDeploymentStatusFinished(update.Status) {
+			set[StorageKeyDeviceDeploymentFinished] = now
+		}
+		if update.SubState != nil {
+			set[StorageKeyDeviceDeploymentSubState] = *update.SubState
+		}
+
+		bulk.Update(bson.M{
+			StorageKeyDeviceDeploymentDeploymentID: update.DeploymentID,
+			StorageKeyDeviceDeploymentDeviceId:     update.DeviceID,
+			StorageKeyDeviceDeploymentStatus: bson.M{"$nin": []string{
+				deployments.DeviceDeploymentStatusAborted,
+				deployments.DeviceDeploymentStatusDecommissioned,
+			}},
+		}, bson.M{"$set": set})
+	}
+
+	_, err := bulk.Run()
+	return err
+}
+
 func (d *DeviceDeploymentsStorage) UpdateDeviceDeploymentLogAvailability(ctx context.Context,
 	deviceID string, deploymentID string, log bool) error {
 
@@ -292,9 +429,11 @@ func (d *DeviceDeploymentsStorage) UpdateDeviceDeploymentLogAvailability(ctx con
 	return nil
 }
 
-// AssignArtifact assignes artifact to the device deployment
+// AssignArtifact assignes artifact to the device deployment,
+// recording the device type reported by the device
 func (d *DeviceDeploymentsStorage) AssignArtifact(ctx context.Context,
-	deviceID string, deploymentID string, artifact *images.SoftwareImage) error {
+	deviceID string, deploymentID string, artifact *images.SoftwareImage,
+	deviceType string) error {
 
 	// Verify ID formatting
 	if govalidator.IsNull(deviceID) ||
@@ -312,7 +451,8 @@ func (d *DeviceDeploymentsStorage) AssignArtifact(ctx context.Context,
 
 	update := bson.M{
 		"$set": bson.M{
-			StorageKeyDeviceDeploymentArtifact: artifact,
+			StorageKeyDeviceDeploymentArtifact:   artifact,
+			StorageKeyDeviceDeploymentDeviceType: deviceType,
 		},
 	}
 
@@ -334,7 +474,7 @@ func (d *DeviceDeploymentsStorage) AggregateDeviceDeploymentByStatus(ctx context
 		return nil, ErrStorageInvalidID
 	}
 
-	session := d.session.Copy()
+	session := d.copyReadSession(ctx)
 	defer session.Close()
 
 	match := bson.M{
@@ -374,11 +514,141 @@ func (d *DeviceDeploymentsStorage) AggregateDeviceDeploymentByStatus(ctx context
 	return raw, nil
 }
 
+// AggregateDeviceDeploymentByStatusForDeployments counts the device
+// deployments of the deployments by status in a single aggregation.
+// Deployments without device deployments, i.e. the ones not found,
+// are omitted.
+func (d *DeviceDeploymentsStorage) AggregateDeviceDeploymentByStatusForDeployments(
+	ctx context.Context, ids []string) ([]deployments.DeploymentStats, error) {
+
+	session := d.copyReadSession(ctx)
+	defer session.Close()
+
+	pipe := []bson.M{
+		{
+			"$match": bson.M{
+				StorageKeyDeviceDeploymentDeploymentID: bson.M{"$in": ids},
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id": bson.M{
+					"deployment": "$" + StorageKeyDeviceDeploymentDeploymentID,
+					"status":     "$" + StorageKeyDeviceDeploymentStatus,
+				},
+				"count": bson.M{
+					"$sum": 1,
+				},
+			},
+		},
+	}
+	var results []struct {
+		ID struct {
+			Deployment string `bson:"deployment"`
+			Status     string `bson:"status"`
+		} `bson:"_id"`
+		Count int
+	}
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Pipe(&pipe).All(&results)
+	if err != nil && err.Error() != mgo.ErrNotFound.Error() {
+		return nil, err
+	}
+
+	list := []deployments.DeploymentStats{}
+	byID := make(map[string]deployments.Stats)
+	for _, res := range results {
+		stats, ok := byID[res.ID.Deployment]
+		if !ok {
+			stats = deployments.NewDeviceDeploymentStats()
+			byID[res.ID.Deployment] = stats
+			list = append(list, deployments.DeploymentStats{
+				DeploymentID: res.ID.Deployment,
+				Stats:        stats,
+			})
+		}
+		stats[res.ID.Status] = res.Count
+	}
+	return list, nil
+}
+
+// AggregateDeviceDeploymentByArtifact counts successful and failed device
+// deployments finished within the query time range by the artifact name,
+// ordered by the artifact name.
+func (d *DeviceDeploymentsStorage) AggregateDeviceDeploymentByArtifact(ctx context.Context,
+	query deployments.ArtifactStatsQuery) ([]deployments.ArtifactStats, error) {
+
+	session := d.copyReadSession(ctx)
+	defer session.Close()
+
+	filter := bson.M{
+		StorageKeyDeviceDeploymentStatus: bson.M{"$in": []string{
+			deployments.DeviceDeploymentStatusSuccess,
+			deployments.DeviceDeploymentStatusFailure,
+		}},
+	}
+	if !query.FinishedAfter.IsZero() || !query.FinishedBefore.IsZero() {
+		finished := bson.M{}
+		if !query.FinishedAfter.IsZero() {
+			finished["$gte"] = query.FinishedAfter
+		}
+		if !query.FinishedBefore.IsZero() {
+			finished["$lt"] = query.FinishedBefore
+		}
+		filter[StorageKeyDeviceDeploymentFinished] = finished
+	}
+
+	countStatus := func(status string) bson.M {
+		return bson.M{
+			"$sum": bson.M{
+				"$cond": []interface{}{
+					bson.M{"$eq": []string{"$" + StorageKeyDeviceDeploymentStatus, status}},
+					1,
+					0,
+				},
+			},
+		}
+	}
+
+	match := bson.M{
+		"$match": filter,
+	}
+	group := bson.M{
+		"$group": bson.M{
+			"_id":     "$" + StorageKeyDeviceDeploymentArtifactName,
+			"success": countStatus(deployments.DeviceDeploymentStatusSuccess),
+			"failure": countStatus(deployments.DeviceDeploymentStatusFailure),
+		},
+	}
+	sort := bson.M{
+		"$sort": bson.M{
+			"_id": 1,
+		},
+	}
+	pipe := []bson.M{
+		match,
+		group,
+		sort,
+	}
+
+	results := []deployments.ArtifactStats{}
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Pipe(&pipe).All(&results)
+	if err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return results, nil
+		}
+		return nil, err
+	}
+
+	return results, nil
+}
+
 //GetDeviceStatusesForDeployment retrieve device deployment statuses for a given deployment.
 func (d *DeviceDeploymentsStorage) GetDeviceStatusesForDeployment(ctx context.Context,
 	deploymentID string) ([]deployments.DeviceDeployment, error) {
 
-	session := d.session.Copy()
+	session := d.copyReadSession(ctx)
 	defer session.Close()
 
 	query := bson.M{
@@ -396,6 +666,36 @@ func (d *DeviceDeploymentsStorage) GetDeviceStatusesForDeployment(ctx context.Co
 	return statuses, nil
 }
 
+// GetDevicesListForDeployment retrieves a page of device deployments
+// of a given deployment, optionally limited to a single status.
+// Results are ordered by device ID.
+func (d *DeviceDeploymentsStorage) GetDevicesListForDeployment(ctx context.Context,
+	q deployments.ListQuery) ([]deployments.DeviceDeployment, error) {
+
+	session := d.copyReadSession(ctx)
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeyDeviceDeploymentDeploymentID: q.DeploymentID,
+	}
+	if q.Status != "" {
+		query[StorageKeyDeviceDeploymentStatus] = q.Status
+	}
+
+	var statuses []deployments.DeviceDeployment
+
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Find(query).
+		Sort(StorageKeyDeviceDeploymentDeviceId).
+		Skip(q.Skip).Limit(q.Limit).
+		All(&statuses)
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
 // Returns true if deployment of ID `deploymentID` is assigned to device with ID
 // `deviceID`, false otherwise. In case of errors returns false and an error
 // that occurred
@@ -487,6 +787,67 @@ func (d *DeviceDeploymentsStorage) AbortDeviceDeployments(ctx context.Context,
 	return err
 }
 
+// ReleaseDeviceDeployments lets the devices held until the promotion of the
+// canary deployment get it
+func (d *DeviceDeploymentsStorage) ReleaseDeviceDeployments(ctx context.Context,
+	deploymentID string) error {
+
+	if govalidator.IsNull(deploymentID) {
+		return ErrStorageInvalidID
+	}
+
+	session := d.session.Copy()
+	defer session.Close()
+
+	selector := bson.M{
+		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+		StorageKeyDeviceDeploymentHeld:         true,
+	}
+
+	update := bson.M{
+		"$unset": bson.M{
+			StorageKeyDeviceDeploymentHeld: "",
+		},
+	}
+
+	_, err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).UpdateAll(selector, update)
+
+	return err
+}
+
+// AbortHeldDeviceDeployments aborts the device deployments held until the
+// promotion of the canary deployment; the devices never get it.
+func (d *DeviceDeploymentsStorage) AbortHeldDeviceDeployments(ctx context.Context,
+	deploymentID string) error {
+
+	if govalidator.IsNull(deploymentID) {
+		return ErrStorageInvalidID
+	}
+
+	session := d.session.Copy()
+	defer session.Close()
+
+	selector := bson.M{
+		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+		StorageKeyDeviceDeploymentHeld:         true,
+		StorageKeyDeviceDeploymentStatus: bson.M{
+			"$in": deployments.ActiveDeploymentStatuses(),
+		},
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			StorageKeyDeviceDeploymentStatus: deployments.DeviceDeploymentStatusAborted,
+		},
+	}
+
+	_, err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).UpdateAll(selector, update)
+
+	return err
+}
+
 func (d *DeviceDeploymentsStorage) DecommissionDeviceDeployments(ctx context.Context,
 	deviceId string) error {
 
@@ -520,3 +881,63 @@ func (d *DeviceDeploymentsStorage) DecommissionDeviceDeployments(ctx context.Con
 
 	return err
 }
+
+// FindLatestDeviceTypes returns device types most recently reported
+// by the given devices, keyed by device ID.
+// Devices which never reported their type are not included.
+func (d *DeviceDeploymentsStorage) FindLatestDeviceTypes(ctx context.Context,
+	deviceIDs []string) (map[string]string, error) {
+
+	deviceTypes := map[string]string{}
+	if len(deviceIDs) == 0 {
+		return deviceTypes, nil
+	}
+
+	session := d.session.Copy()
+	defer session.Close()
+
+	match := bson.M{
+		"$match": bson.M{
+			StorageKeyDeviceDeploymentDeviceId: bson.M{"$in": deviceIDs},
+			StorageKeyDeviceDeploymentDeviceType: bson.M{
+				"$exists": true,
+				"$ne":     "",
+			},
+		},
+	}
+	sort := bson.M{
+		"$sort": bson.M{
+			StorageKeyDeviceDeploymentCreated: -1,
+		},
+	}
+	group := bson.M{
+		"$group": bson.M{
+			"_id": "$" + StorageKeyDeviceDeploymentDeviceId,
+			"devicetype": bson.M{
+				"$first": "$" + StorageKeyDeviceDeploymentDeviceType,
+			},
+		},
+	}
+	pipe := []bson.M{
+		match,
+		sort,
+		group,
+	}
+	var results []struct {
+		DeviceID   string `bson:"_id"`
+		DeviceType string `bson:"devicetype"`
+	}
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Pipe(&pipe).All(&results)
+	if err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return deviceTypes, nil
+		}
+		return nil, err
+	}
+
+	for _, res := range results {
+		deviceTypes[res.DeviceID] = res.DeviceType
+	}
+	return deviceTypes, nil
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"testing"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 
 	"github.com/mendersoftware/deployments/resources/deployments"
 	. "github.com/mendersoftware/deployments/resources/deployments/mongo"
+	"github.com/mendersoftware/deployments/resources/images"
 	"github.com/mendersoftware/deployments/utils/pointers"
 )
 
@@ -554,6 +556,63 @@ func TestAggregateDeviceDeploymentByStatus(t *testing.T) {
 	}
 }
 
+func TestAggregateDeviceDeploymentByStatusForDeployments(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("skipping TestAggregateDeviceDeploymentByStatusForDeployments in short mode.")
+	}
+
+	first := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	second := "ee13ea8b-a6d3-4d4c-99a6-bcfcaebc7ec3"
+	missing := "b6a3c2e4-4a1d-4f7e-9a2c-1c0d2e5f6a7b"
+
+	db.Wipe()
+
+	session := db.Session()
+	defer session.Close()
+	store := NewDeviceDeploymentsStorage(session)
+
+	err := store.InsertMany(context.Background(),
+		newDeviceDeploymentWithStatus("123", first,
+			deployments.DeviceDeploymentStatusFailure),
+		newDeviceDeploymentWithStatus("234", first,
+			deployments.DeviceDeploymentStatusSuccess),
+		newDeviceDeploymentWithStatus("345", first,
+			deployments.DeviceDeploymentStatusSuccess),
+		newDeviceDeploymentWithStatus("123", second,
+			deployments.DeviceDeploymentStatusPending),
+	)
+	assert.NoError(t, err)
+
+	stats, err := store.AggregateDeviceDeploymentByStatusForDeployments(
+		context.Background(), []string{second, missing, first})
+	assert.NoError(t, err)
+	// order of the aggregated statistics is not defined
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].DeploymentID < stats[j].DeploymentID
+	})
+	assert.Equal(t, []deployments.DeploymentStats{
+		{
+			DeploymentID: first,
+			Stats: newTestStats(deployments.Stats{
+				deployments.DeviceDeploymentStatusSuccess: 2,
+				deployments.DeviceDeploymentStatusFailure: 1,
+			}),
+		},
+		{
+			DeploymentID: second,
+			Stats: newTestStats(deployments.Stats{
+				deployments.DeviceDeploymentStatusPending: 1,
+			}),
+		},
+	}, stats)
+
+	stats, err = store.AggregateDeviceDeploymentByStatusForDeployments(
+		context.Background(), []string{missing})
+	assert.NoError(t, err)
+	assert.Empty(t, stats)
+}
+
 func TestGetDeviceStatusesForDeployment(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping GetDeviceStatusesForDeployment in short mode.")
@@ -637,6 +696,93 @@ func TestGetDeviceStatusesForDeployment(t *testing.T) {
 	}
 }
 
+func TestGetDevicesListForDeployment(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping GetDevicesListForDeployment in short mode.")
+	}
+
+	input := []*deployments.DeviceDeployment{
+		deployments.NewDeviceDeployment("device0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"),
+		deployments.NewDeviceDeployment("device0002", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"),
+		deployments.NewDeviceDeployment("device0003", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"),
+		deployments.NewDeviceDeployment("device0004", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"),
+		deployments.NewDeviceDeployment("device0005", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"),
+	}
+	failure := deployments.DeviceDeploymentStatusFailure
+	input[1].Status = &failure
+	input[3].Status = &failure
+
+	testCases := map[string]struct {
+		query deployments.ListQuery
+
+		outputDevices []string
+	}{
+		"all": {
+			query: deployments.ListQuery{
+				DeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			},
+			outputDevices: []string{"device0001", "device0002", "device0003", "device0004"},
+		},
+		"paged": {
+			query: deployments.ListQuery{
+				DeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Skip:         1,
+				Limit:        2,
+			},
+			outputDevices: []string{"device0002", "device0003"},
+		},
+		"status": {
+			query: deployments.ListQuery{
+				DeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Status:       deployments.DeviceDeploymentStatusFailure,
+			},
+			outputDevices: []string{"device0002", "device0004"},
+		},
+		"status, paged": {
+			query: deployments.ListQuery{
+				DeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+				Status:       deployments.DeviceDeploymentStatusFailure,
+				Skip:         1,
+				Limit:        1,
+			},
+			outputDevices: []string{"device0004"},
+		},
+		"nonexistent deployment": {
+			query: deployments.ListQuery{
+				DeploymentID: "aaaaaaaa-9ec2-4312-a7fa-cff24cc7397b",
+			},
+			outputDevices: []string{},
+		},
+	}
+
+	for testCaseName, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
+
+			db.Wipe()
+
+			session := db.Session()
+			defer session.Close()
+			store := NewDeviceDeploymentsStorage(session)
+
+			ctx := context.Background()
+
+			err := store.DoEnsureIndexing(DatabaseName, session)
+			assert.NoError(t, err)
+
+			err = store.InsertMany(ctx, input...)
+			assert.NoError(t, err)
+
+			statuses, err := store.GetDevicesListForDeployment(ctx, tc.query)
+			assert.NoError(t, err)
+
+			assert.Len(t, statuses, len(tc.outputDevices))
+			for i, dev := range tc.outputDevices {
+				assert.Equal(t, dev, *statuses[i].DeviceId)
+			}
+		})
+	}
+}
+
 func TestHasDeploymentForDevice(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping GetDeviceStatusesForDeployment in short mode.")
@@ -963,3 +1109,87 @@ func TestDecommissionDeviceDeployments(t *testing.T) {
 		})
 	}
 }
+
+func TestAggregateDeviceDeploymentByArtifact(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("skipping TestAggregateDeviceDeploymentByArtifact in short mode.")
+	}
+
+	day := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	newDeviceDeployment := func(artifact, status string, finished time.Time) *deployments.DeviceDeployment {
+		dd := deployments.NewDeviceDeployment("device", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a")
+		dd.Status = &status
+		if artifact != "" {
+			dd.Image = &images.SoftwareImage{
+				SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+					Name: artifact,
+				},
+			}
+		}
+		if !finished.IsZero() {
+			dd.Finished = &finished
+		}
+		return dd
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	store := NewDeviceDeploymentsStorage(session)
+	assert.NoError(t, store.InsertMany(context.Background(),
+		newDeviceDeployment("app-1", deployments.DeviceDeploymentStatusSuccess, day),
+		newDeviceDeployment("app-1", deployments.DeviceDeploymentStatusSuccess, day.Add(48*time.Hour)),
+		newDeviceDeployment("app-1", deployments.DeviceDeploymentStatusFailure, day.Add(time.Hour)),
+		newDeviceDeployment("app-2", deployments.DeviceDeploymentStatusFailure, day.Add(48*time.Hour)),
+		// not finished, or not installed
+		newDeviceDeployment("app-2", deployments.DeviceDeploymentStatusInstalling, time.Time{}),
+		newDeviceDeployment("app-3", deployments.DeviceDeploymentStatusAborted, day),
+		newDeviceDeployment("", deployments.DeviceDeploymentStatusNoArtifact, day),
+	))
+
+	testCases := map[string]struct {
+		query deployments.ArtifactStatsQuery
+		stats []deployments.ArtifactStats
+	}{
+		"all": {
+			stats: []deployments.ArtifactStats{
+				{ArtifactName: "app-1", Success: 2, Failure: 1},
+				{ArtifactName: "app-2", Success: 0, Failure: 1},
+			},
+		},
+		"finished on the day": {
+			query: deployments.ArtifactStatsQuery{
+				FinishedAfter:  day,
+				FinishedBefore: day.Add(24 * time.Hour),
+			},
+			stats: []deployments.ArtifactStats{
+				{ArtifactName: "app-1", Success: 1, Failure: 1},
+			},
+		},
+		"finished after the day": {
+			query: deployments.ArtifactStatsQuery{
+				FinishedAfter: day.Add(24 * time.Hour),
+			},
+			stats: []deployments.ArtifactStats{
+				{ArtifactName: "app-1", Success: 1, Failure: 0},
+				{ArtifactName: "app-2", Success: 0, Failure: 1},
+			},
+		},
+		"none": {
+			query: deployments.ArtifactStatsQuery{
+				FinishedBefore: day,
+			},
+			stats: []deployments.ArtifactStats{},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			stats, err := store.AggregateDeviceDeploymentByArtifact(context.Background(), tc.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.stats, stats)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package webhook delivers notifications about finished deployments
+// to the callback URLs registered with the deployments.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/deployments"
+)
+
+// Defaults
+const (
+	DefaultAttempts = 5
+	DefaultBackoff  = 1 * time.Second
+	DefaultTimeout  = 10 * time.Second
+
+	// MaxBackoff caps the delay between consecutive delivery attempts
+	MaxBackoff = 5 * time.Minute
+)
+
+// ErrPrivateAddress is returned when the callback URL resolves to the
+// loopback, link-local or private address, and those are not allowed
+var ErrPrivateAddress = errors.New("callback to loopback, link-local or private address refused")
+
+// Deployment statuses reported in the notification
+const (
+	StatusFinished = "finished"
+	StatusAborted  = "aborted"
+)
+
+// DeploymentFinished is the summary POSTed to the callback URL
+type DeploymentFinished struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	ArtifactName string            `json:"artifact_name"`
+	Status       string            `json:"status"`
+	Created      *time.Time        `json:"created"`
+	Finished     *time.Time        `json:"finished,omitempty"`
+	Stats        deployments.Stats `json:"stats"`
+}
+
+// NewDeploymentFinished builds the notification of a finished deployment
+func NewDeploymentFinished(deployment *deployments.Deployment) *DeploymentFinished {
+	n := &DeploymentFinished{
+		ID:       *deployment.Id,
+		Status:   StatusFinished,
+		Created:  deployment.Created,
+		Finished: deployment.Finished,
+		Stats:    deployment.Stats,
+	}
+	if deployment.Name != nil {
+		n.Name = *deployment.Name
+	}
+	if deployment.ArtifactName != nil {
+		n.ArtifactName = *deployment.ArtifactName
+	}
+	if deployment.IsAborted() {
+		n.Status = StatusAborted
+	}
+	return n
+}
+
+// Config of the notification delivery
+type Config struct {
+	// Attempts is the number of delivery attempts before giving up
+	Attempts int
+	// Backoff is the delay before the first retry, doubled with each
+	// subsequent one
+	Backoff time.Duration
+	// Timeout of a single delivery attempt
+	Timeout time.Duration
+	// AllowPrivate lets the callbacks be delivered to the loopback,
+	// link-local and private addresses
+	AllowPrivate bool
+}
+
+// Notifier POSTs deployment summaries to the callback URLs. Delivery happens
+// in background, failed attempts are retried with exponential backoff.
+type Notifier struct {
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+
+	wg sync.WaitGroup
+}
+
+// NewNotifier creates notifier, zero config values are replaced with defaults
+func NewNotifier(config Config) *Notifier {
+	if config.Attempts <= 0 {
+		config.Attempts = DefaultAttempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = DefaultBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	client := &http.Client{Timeout: config.Timeout}
+	if !config.AllowPrivate {
+		// the addresses are checked once resolved, right before connecting,
+		// so that neither DNS nor redirects lead to the internal services
+		dialer := &net.Dialer{Timeout: config.Timeout, Control: refusePrivate}
+		client.Transport = &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: config.Timeout,
+		}
+	}
+
+	return &Notifier{
+		client:   client,
+		attempts: config.Attempts,
+		backoff:  config.Backoff,
+	}
+}
+
+// NotifyDeploymentFinished schedules delivery of the deployment summary to
+// the deployment's callback URL, if one was registered. Returns immediately,
+// delivery failures are only logged.
+func (n *Notifier) NotifyDeploymentFinished(ctx context.Context,
+	deployment *deployments.Deployment) {
+
+	if deployment.DeploymentConstructor == nil || deployment.CallbackURL == nil {
+		return
+	}
+
+	l := log.FromContext(ctx).F(log.Ctx{"deployment_id": *deployment.Id})
+
+	body, err := json.Marshal(NewDeploymentFinished(deployment))
+	if err != nil {
+		l.F(log.Ctx{"error": err.Error()}).Error("failed to encode deployment callback")
+		return
+	}
+
+	// delivery outlives the request which finished the deployment,
+	// keep the logger only
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.deliver(log.WithContext(context.Background(), l), *deployment.CallbackURL, body)
+	}()
+}
+
+func (n *Notifier) deliver(ctx context.Context, url string, body []byte) {
+	l := log.FromContext(ctx)
+
+	backoff := n.backoff
+	for attempt := 1; ; attempt++ {
+		err := n.post(ctx, url, body)
+		if err == nil {
+			l.Info("deployment callback delivered")
+			return
+		}
+
+		if attempt >= n.attempts {
+			l.F(log.Ctx{"attempts": attempt, "error": err.Error()}).
+				Error("giving up on deployment callback")
+			return
+		}
+
+		l.F(log.Ctx{
+			"attempt": attempt,
+			"backoff": backoff.String(),
+			"error":   err.Error(),
+		}).Warn("deployment callback failed, retrying")
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > MaxBackoff {
+			backoff = MaxBackoff
+		}
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "sending request")
+	}
+	defer resp.Body.Close()
+
+	// drain the body so that the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// refusePrivate refuses connecting to the loopback, link-local and private
+// addresses.
+func refusePrivate(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || deployments.IsPrivateAddress(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/deployments"
+	. "github.com/mendersoftware/deployments/utils/pointers"
+)
+
+func makeDeployment(callbackURL *string, stats deployments.Stats) *deployments.Deployment {
+	d := deployments.NewDeploymentFromConstructor(&deployments.DeploymentConstructor{
+		Name:         StringToPointer("foo"),
+		ArtifactName: StringToPointer("bar"),
+		CallbackURL:  callbackURL,
+	})
+	now := time.Now()
+	d.Finished = &now
+	d.Stats = stats
+	return d
+}
+
+func TestNotifyDeploymentFinished(t *testing.T) {
+	testCases := map[string]struct {
+		failures int
+		attempts int
+		stats    deployments.Stats
+
+		requests  int
+		delivered bool
+		status    string
+	}{
+		"delivered": {
+			attempts:  3,
+			stats:     deployments.Stats{deployments.DeviceDeploymentStatusSuccess: 2},
+			requests:  1,
+			delivered: true,
+			status:    StatusFinished,
+		},
+		"delivered after retries": {
+			failures:  2,
+			attempts:  3,
+			stats:     deployments.Stats{deployments.DeviceDeploymentStatusAborted: 1},
+			requests:  3,
+			delivered: true,
+			status:    StatusAborted,
+		},
+		"gave up": {
+			failures: 5,
+			attempts: 3,
+			requests: 3,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				requests int
+				received *DeploymentFinished
+			)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				requests++
+				if requests <= tc.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				received = &DeploymentFinished{}
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(received))
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			// test server listens on the loopback address
+			n := NewNotifier(Config{
+				Attempts:     tc.attempts,
+				Backoff:      time.Millisecond,
+				AllowPrivate: true,
+			})
+
+			deployment := makeDeployment(StringToPointer(srv.URL+"/hook"), tc.stats)
+			n.NotifyDeploymentFinished(context.Background(), deployment)
+			n.wg.Wait()
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			assert.Equal(t, tc.requests, requests)
+			if tc.delivered {
+				assert.NotNil(t, received)
+				assert.Equal(t, *deployment.Id, received.ID)
+				assert.Equal(t, "foo", received.Name)
+				assert.Equal(t, "bar", received.ArtifactName)
+				assert.Equal(t, tc.status, received.Status)
+				assert.Equal(t, tc.stats, received.Stats)
+				assert.NotNil(t, received.Finished)
+			} else {
+				assert.Nil(t, received)
+			}
+		})
+	}
+}
+
+func TestNotifyDeploymentFinishedPrivateAddress(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+	}))
+	defer srv.Close()
+
+	n := NewNotifier(Config{Attempts: 2, Backoff: time.Millisecond})
+
+	n.NotifyDeploymentFinished(context.Background(),
+		makeDeployment(StringToPointer(srv.URL+"/hook"), deployments.Stats{}))
+	n.wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 0, requests)
+
+	for address, refused := range map[string]bool{
+		"127.0.0.1:80":       true,
+		"10.1.2.3:443":       true,
+		"169.254.169.254:80": true,
+		"[::1]:80":           true,
+		"[fd00::1]:443":      true,
+		"8.8.8.8:443":        false,
+		"[2001:4860::1]:443": false,
+	} {
+		err := refusePrivate("tcp", address, nil)
+		if refused {
+			assert.Equal(t, ErrPrivateAddress, err, address)
+		} else {
+			assert.NoError(t, err, address)
+		}
+	}
+}
+
+func TestNotifyDeploymentFinishedNoCallback(t *testing.T) {
+	n := NewNotifier(Config{})
+	assert.Equal(t, DefaultAttempts, n.attempts)
+	assert.Equal(t, DefaultBackoff, n.backoff)
+	assert.Equal(t, DefaultTimeout, n.client.Timeout)
+
+	// nothing to deliver, returns right away
+	n.NotifyDeploymentFinished(context.Background(),
+		makeDeployment(nil, deployments.Stats{}))
+	n.wg.Wait()
+}
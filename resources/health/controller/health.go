@@ -0,0 +1,51 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package controller
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+
+	"github.com/mendersoftware/deployments/resources/health"
+)
+
+// Domain model for health checks
+type HealthModel interface {
+	Readiness(ctx context.Context) *health.Readiness
+}
+
+type HealthController struct {
+	model HealthModel
+}
+
+func NewHealthController(model HealthModel) *HealthController {
+	return &HealthController{
+		model: model,
+	}
+}
+
+// Readiness reports the state of the backends. Degraded backends are
+// reported with 200, only failing ones make the service unavailable.
+func (h *HealthController) Readiness(w rest.ResponseWriter, r *rest.Request) {
+	readiness := h.model.Readiness(r.Context())
+
+	if readiness.IsAvailable() {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.WriteJson(readiness)
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package controller_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/health"
+	. "github.com/mendersoftware/deployments/resources/health/controller"
+	"github.com/mendersoftware/deployments/resources/health/controller/mocks"
+	h "github.com/mendersoftware/deployments/utils/testing"
+)
+
+func TestControllerReadiness(t *testing.T) {
+	testCases := []struct {
+		readiness *health.Readiness
+		code      int
+	}{
+		{
+			readiness: &health.Readiness{
+				Status:  health.StatusOK,
+				Storage: health.Check{Status: health.StatusOK, LatencyMs: 1.5},
+			},
+			code: http.StatusOK,
+		},
+		{
+			readiness: &health.Readiness{
+				Status:  health.StatusDegraded,
+				Storage: health.Check{Status: health.StatusDegraded, LatencyMs: 900},
+			},
+			code: http.StatusOK,
+		},
+		{
+			readiness: &health.Readiness{
+				Status: health.StatusUnavailable,
+				Storage: health.Check{
+					Status: health.StatusUnavailable,
+					Error:  "bucket not found",
+				},
+			},
+			code: http.StatusServiceUnavailable,
+		},
+		{
+			// maintenance mode does not make the service unavailable
+			readiness: &health.Readiness{
+				Status:      health.StatusOK,
+				Storage:     health.Check{Status: health.StatusOK, LatencyMs: 1.5},
+				Maintenance: true,
+			},
+			code: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		model := &mocks.HealthModel{}
+		model.On("Readiness", h.ContextMatcher()).Return(tc.readiness)
+
+		router, err := rest.MakeRouter(
+			rest.Get("/ready", NewHealthController(model).Readiness))
+		assert.NoError(t, err)
+
+		api := rest.NewApi()
+		api.SetApp(router)
+
+		recorded := test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("GET", "http://localhost/ready", nil))
+		recorded.CodeIs(tc.code)
+		recorded.ContentTypeIsJson()
+
+		var received health.Readiness
+		assert.NoError(t, recorded.DecodeJsonPayload(&received))
+		assert.Equal(t, *tc.readiness, received)
+	}
+}
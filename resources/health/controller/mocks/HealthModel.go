@@ -0,0 +1,42 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mocks
+
+import context "context"
+import controller "github.com/mendersoftware/deployments/resources/health/controller"
+import health "github.com/mendersoftware/deployments/resources/health"
+import mock "github.com/stretchr/testify/mock"
+
+// HealthModel is an autogenerated mock type for the HealthModel type
+type HealthModel struct {
+	mock.Mock
+}
+
+// Readiness provides a mock function with given fields: ctx
+func (_m *HealthModel) Readiness(ctx context.Context) *health.Readiness {
+	ret := _m.Called(ctx)
+
+	var r0 *health.Readiness
+	if rf, ok := ret.Get(0).(func(context.Context) *health.Readiness); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*health.Readiness)
+		}
+	}
+
+	return r0
+}
+
+var _ controller.HealthModel = (*HealthModel)(nil)
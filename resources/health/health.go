@@ -0,0 +1,49 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package health
+
+// Check statuses
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+)
+
+// Check is the outcome of checking a single backend
+type Check struct {
+	Status string `json:"status"`
+
+	// Round trip time of the check request, in milliseconds
+	LatencyMs float64 `json:"latency_ms"`
+
+	// Failure reason, set if the backend is unavailable
+	Error string `json:"error,omitempty"`
+}
+
+// Readiness is the outcome of the readiness check
+type Readiness struct {
+	// Overall status, the worst of the checks
+	Status string `json:"status"`
+
+	// File storage check
+	Storage Check `json:"storage"`
+
+	// Set if the service is in maintenance (read-only) mode
+	Maintenance bool `json:"maintenance"`
+}
+
+// IsAvailable tells if the service can handle requests
+func (r *Readiness) IsAvailable() bool {
+	return r.Status != StatusUnavailable
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deployments/resources/health"
+)
+
+// Defaults
+const (
+	DefaultStorageLatencyThreshold = 500 * time.Millisecond
+	DefaultCheckTimeout            = 5 * time.Second
+)
+
+// StorageChecker performs a minimal request against the storage backend
+type StorageChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// MaintenanceChecker tells if the service is in maintenance mode
+type MaintenanceChecker interface {
+	Enabled() bool
+}
+
+type HealthModel struct {
+	storage          StorageChecker
+	latencyThreshold time.Duration
+	maintenance      MaintenanceChecker
+}
+
+// NewHealthModel creates health model; storage latency above the threshold
+// is reported as degraded. Maintenance mode is not reported if maintenance
+// is nil.
+func NewHealthModel(storage StorageChecker, latencyThreshold time.Duration,
+	maintenance MaintenanceChecker) *HealthModel {
+	return &HealthModel{
+		storage:          storage,
+		latencyThreshold: latencyThreshold,
+		maintenance:      maintenance,
+	}
+}
+
+// Readiness checks the storage backend and measures its round trip latency.
+func (h *HealthModel) Readiness(ctx context.Context) *health.Readiness {
+	storage := h.checkStorage(ctx)
+
+	return &health.Readiness{
+		Status:      storage.Status,
+		Storage:     storage,
+		Maintenance: h.maintenance != nil && h.maintenance.Enabled(),
+	}
+}
+
+func (h *HealthModel) checkStorage(ctx context.Context) health.Check {
+	ctx, cancel := context.WithTimeout(ctx, DefaultCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := h.storage.HealthCheck(ctx)
+	latency := time.Since(start)
+
+	check := health.Check{
+		Status:    health.StatusOK,
+		LatencyMs: float64(latency) / float64(time.Millisecond),
+	}
+
+	switch {
+	case err != nil:
+		log.FromContext(ctx).F(log.Ctx{"error": err.Error()}).
+			Error("storage health check failed")
+		check.Status = health.StatusUnavailable
+		check.Error = err.Error()
+	case latency > h.latencyThreshold:
+		log.FromContext(ctx).F(log.Ctx{"latency": latency.String()}).
+			Warn("storage latency above threshold")
+		check.Status = health.StatusDegraded
+	}
+
+	return check
+}
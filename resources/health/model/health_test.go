@@ -0,0 +1,84 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/health"
+)
+
+type FakeStorageChecker struct {
+	delay time.Duration
+	err   error
+}
+
+func (f *FakeStorageChecker) HealthCheck(ctx context.Context) error {
+	time.Sleep(f.delay)
+	return f.err
+}
+
+func TestReadiness(t *testing.T) {
+	testCases := []struct {
+		storage *FakeStorageChecker
+
+		status string
+		err    string
+	}{
+		{
+			storage: &FakeStorageChecker{},
+			status:  health.StatusOK,
+		},
+		{
+			storage: &FakeStorageChecker{delay: 20 * time.Millisecond},
+			status:  health.StatusDegraded,
+		},
+		{
+			storage: &FakeStorageChecker{err: errors.New("bucket not found")},
+			status:  health.StatusUnavailable,
+			err:     "bucket not found",
+		},
+	}
+
+	for _, tc := range testCases {
+		model := NewHealthModel(tc.storage, 10*time.Millisecond, nil)
+
+		readiness := model.Readiness(context.Background())
+		assert.Equal(t, tc.status, readiness.Status)
+		assert.Equal(t, tc.status, readiness.Storage.Status)
+		assert.Equal(t, tc.err, readiness.Storage.Error)
+		assert.True(t, readiness.Storage.LatencyMs >= float64(tc.storage.delay/time.Millisecond))
+		assert.False(t, readiness.Maintenance)
+	}
+}
+
+type FakeMaintenanceChecker bool
+
+func (f FakeMaintenanceChecker) Enabled() bool {
+	return bool(f)
+}
+
+func TestReadinessMaintenance(t *testing.T) {
+	model := NewHealthModel(&FakeStorageChecker{}, 10*time.Millisecond,
+		FakeMaintenanceChecker(true))
+
+	readiness := model.Readiness(context.Background())
+	assert.Equal(t, health.StatusOK, readiness.Status)
+	assert.True(t, readiness.Maintenance)
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package images
+
+import (
+	"path"
+	"strings"
+)
+
+// Artifacts bundle is a tar archive holding the manifest followed by
+// the artifact files, used to move the artifacts between the installations.
+const (
+	BundleContentType  = "application/x-tar"
+	BundleManifestName = "manifest.json"
+	BundleArtifactsDir = "artifacts"
+	BundleVersion      = 1
+
+	bundleArtifactExt = ".mender"
+)
+
+// BundleManifest lists the metadata of the bundled artifacts
+type BundleManifest struct {
+	// Version of the bundle format
+	Version int `json:"version"`
+
+	Images []*SoftwareImage `json:"images"`
+}
+
+// BundleArtifactName returns the name of the bundle entry
+// holding the file of the artifact with the given ID.
+func BundleArtifactName(id string) string {
+	return path.Join(BundleArtifactsDir, id+bundleArtifactExt)
+}
+
+// BundleArtifactID returns ID of the artifact, which file is held by
+// the bundle entry of the given name, empty string for other entries.
+func BundleArtifactID(name string) string {
+	dir, file := path.Split(path.Clean(name))
+	if path.Clean(dir) != BundleArtifactsDir || !strings.HasSuffix(file, bundleArtifactExt) {
+		return ""
+	}
+	return strings.TrimSuffix(file, bundleArtifactExt)
+}
+
+// ImportResult maps IDs of the bundled artifacts to the IDs of the artifacts
+// created out of them, and of the existing artifacts having the same
+// checksum, which were not imported again.
+type ImportResult struct {
+	Imported map[string]string `json:"imported"`
+	Skipped  map[string]string `json:"skipped"`
+}
+
+func NewImportResult() *ImportResult {
+	return &ImportResult{
+		Imported: map[string]string{},
+		Skipped:  map[string]string{},
+	}
+}
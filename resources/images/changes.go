@@ -0,0 +1,87 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"encoding/xml"
+	"errors"
+	"time"
+)
+
+// Kinds of the artifact changes
+const (
+	ImageChangeCreated  = "created"
+	ImageChangeModified = "modified"
+	ImageChangeDeleted  = "deleted"
+)
+
+// Number of the changes listed at once
+const (
+	DefaultImageChangesLimit = 20
+	MaxImageChangesLimit     = 100
+)
+
+var (
+	ErrInvalidChangesLimit = errors.New("Invalid limit: expected number between 1 and 100")
+)
+
+// ImageChange is an entry of the feed of the recently created, modified
+// and deleted artifacts
+type ImageChange struct {
+	XMLName xml.Name `json:"-" bson:"-" xml:"change"`
+
+	// ID and name of the artifact
+	Id   string `json:"id" bson:"_id" xml:"id"`
+	Name string `json:"name" bson:"name" xml:"name"`
+
+	// One of ImageChange* constants
+	Change string `json:"change" bson:"-" xml:"change"`
+
+	// Time of the change
+	Timestamp time.Time `json:"timestamp" bson:"-" xml:"timestamp"`
+}
+
+// ImageChangesQuery selects the changes of the feed
+type ImageChangesQuery struct {
+	// Only the changes made after the time, oldest first, so that the feed
+	// can be polled with the timestamp of the last change received;
+	// the most recent changes, newest first, if nil
+	Since *time.Time
+
+	// Maximum number of the changes listed
+	Limit int
+}
+
+// Validate checks the limit, zero limit is set to the default.
+func (q *ImageChangesQuery) Validate() error {
+	if q.Limit == 0 {
+		q.Limit = DefaultImageChangesLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxImageChangesLimit {
+		return ErrInvalidChangesLimit
+	}
+	return nil
+}
+
+// ChangeOf tells the kind of the last change of the existing image,
+// the images created before the creation time was recorded are always
+// reported as modified.
+func ChangeOf(image *SoftwareImage) string {
+	if image.Created != nil && image.Modified != nil &&
+		!image.Modified.After(*image.Created) {
+		return ImageChangeCreated
+	}
+	return ImageChangeModified
+}
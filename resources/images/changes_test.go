@@ -0,0 +1,50 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageChangesQueryValidate(t *testing.T) {
+	query := &ImageChangesQuery{}
+	assert.NoError(t, query.Validate())
+	assert.Equal(t, DefaultImageChangesLimit, query.Limit)
+
+	query = &ImageChangesQuery{Limit: MaxImageChangesLimit}
+	assert.NoError(t, query.Validate())
+	assert.Equal(t, MaxImageChangesLimit, query.Limit)
+
+	for _, limit := range []int{-1, MaxImageChangesLimit + 1} {
+		query = &ImageChangesQuery{Limit: limit}
+		assert.Equal(t, ErrInvalidChangesLimit, query.Validate())
+	}
+}
+
+func TestChangeOf(t *testing.T) {
+	image := NewSoftwareImage("id", NewSoftwareImageMetaConstructor(),
+		NewSoftwareImageMetaArtifactConstructor())
+	assert.Equal(t, ImageChangeCreated, ChangeOf(image))
+
+	image.SetModified(image.Modified.Add(time.Second))
+	assert.Equal(t, ImageChangeModified, ChangeOf(image))
+
+	// created before the creation time was recorded
+	image.Created = nil
+	assert.Equal(t, ImageChangeModified, ChangeOf(image))
+}
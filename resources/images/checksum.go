@@ -0,0 +1,203 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Checksum algorithms of the artifact file
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA256 = "sha256"
+)
+
+var (
+	ErrUnknownChecksumAlgorithm = errors.New("Unknown checksum algorithm: expected md5 or sha256")
+	ErrInvalidChecksum          = errors.New("Invalid checksum: expected hex encoded digest")
+	ErrConflictingChecksums     = errors.New("Conflicting checksum and checksums.sha256")
+	ErrInvalidChecksumsState    = errors.New("Invalid checksums state")
+)
+
+// newChecksumHash creates the hash of the supported algorithms
+var newChecksumHash = map[string]func() hash.Hash{
+	ChecksumMD5:    md5.New,
+	ChecksumSHA256: sha256.New,
+}
+
+// Checksums of the artifact file (hex encoded) by algorithm
+type Checksums map[string]string
+
+// Validate checks the algorithms are supported and the checksums are hex
+// encoded digests of the right length.
+func (c Checksums) Validate() error {
+	for algorithm, sum := range c {
+		newHash, ok := newChecksumHash[algorithm]
+		if !ok {
+			return ErrUnknownChecksumAlgorithm
+		}
+		digest, err := hex.DecodeString(sum)
+		if err != nil || len(digest) != newHash().Size() {
+			return ErrInvalidChecksum
+		}
+	}
+	return nil
+}
+
+// Matches checks all the checksums are the same as the computed ones,
+// algorithms not computed never match.
+func (c Checksums) Matches(computed Checksums) bool {
+	for algorithm, sum := range c {
+		if actual, ok := computed[algorithm]; !ok || !strings.EqualFold(actual, sum) {
+			return false
+		}
+	}
+	return true
+}
+
+// ChecksumsWriter computes the checksums of all the supported algorithms
+// of the data written.
+type ChecksumsWriter struct {
+	hashes map[string]hash.Hash
+}
+
+func NewChecksumsWriter() *ChecksumsWriter {
+	w := &ChecksumsWriter{hashes: make(map[string]hash.Hash, len(newChecksumHash))}
+	for algorithm, newHash := range newChecksumHash {
+		w.hashes[algorithm] = newHash()
+	}
+	return w
+}
+
+func (w *ChecksumsWriter) Write(p []byte) (int, error) {
+	for _, h := range w.hashes {
+		// hash writes never fail
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Checksums returns the checksums of the data written so far.
+func (w *ChecksumsWriter) Checksums() Checksums {
+	c := make(Checksums, len(w.hashes))
+	for algorithm, h := range w.hashes {
+		c[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return c
+}
+
+// ChecksumsState is the internal state of the checksums computation by
+// algorithm, so that it can be resumed with more data later on.
+type ChecksumsState map[string][]byte
+
+// State returns the state of the checksums of the data written so far.
+func (w *ChecksumsWriter) State() (ChecksumsState, error) {
+	state := make(ChecksumsState, len(w.hashes))
+	for algorithm, h := range w.hashes {
+		// all the supported hashes are marshalers
+		data, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		state[algorithm] = data
+	}
+	return state, nil
+}
+
+// NewChecksumsWriterFromState resumes computing the checksums of all the
+// supported algorithms from the state.
+func NewChecksumsWriterFromState(state ChecksumsState) (*ChecksumsWriter, error) {
+	w := &ChecksumsWriter{hashes: make(map[string]hash.Hash, len(newChecksumHash))}
+	for algorithm, newHash := range newChecksumHash {
+		data, ok := state[algorithm]
+		if !ok {
+			return nil, ErrInvalidChecksumsState
+		}
+		h := newHash()
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(data); err != nil {
+			return nil, ErrInvalidChecksumsState
+		}
+		w.hashes[algorithm] = h
+	}
+	return w, nil
+}
+
+// Number of the artifacts the checksums are recomputed of by single request
+const (
+	DefaultChecksumsBatch = 100
+	MaxChecksumsBatch     = 1000
+)
+
+var ErrInvalidChecksumsBatch = fmt.Errorf(
+	"Invalid limit: expected between 1 and %d artifacts", MaxChecksumsBatch)
+
+// ChecksumsRecomputation selects the batch of the artifacts the checksums
+// of the files are recomputed of, e.g. to add SHA256 checksums to the
+// artifacts imported with MD5 ones only. The artifacts are processed in
+// the order of the IDs, so that the recomputation can be resumed.
+type ChecksumsRecomputation struct {
+	// Artifacts with the IDs, any if empty
+	IDs []string `json:"ids,omitempty"`
+
+	// Artifacts following the one with the ID, from the first one if empty
+	After string `json:"after,omitempty"`
+
+	// Maximum number of the artifacts, DefaultChecksumsBatch if zero
+	Limit int `json:"limit,omitempty"`
+
+	// Recompute the checksums of the artifacts having SHA256 checksum too
+	Force bool `json:"force,omitempty"`
+}
+
+// Validate checks the batch size, setting the default one if not given.
+func (c *ChecksumsRecomputation) Validate() error {
+	if c.Limit == 0 {
+		c.Limit = DefaultChecksumsBatch
+	}
+	if c.Limit < 0 || c.Limit > MaxChecksumsBatch {
+		return ErrInvalidChecksumsBatch
+	}
+	return nil
+}
+
+// ChecksumsReport summarizes the recomputation of the checksums of the batch.
+type ChecksumsReport struct {
+	// Number of the artifacts the checksums were computed of
+	Checked int `json:"checked"`
+
+	// Number of the artifacts the checksums were recorded of
+	Updated int `json:"updated"`
+
+	// Number of the artifacts having SHA256 checksum already, or pending
+	Skipped int `json:"skipped"`
+
+	// IDs of the artifacts which files don't match the recorded checksums,
+	// left unchanged
+	Mismatched []string `json:"mismatched"`
+
+	// IDs of the artifacts which files could not be read
+	Failed []string `json:"failed"`
+
+	// ID of the last artifact processed, to resume the recomputation after;
+	// empty if all the artifacts were processed
+	Next string `json:"next,omitempty"`
+}
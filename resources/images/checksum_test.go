@@ -0,0 +1,152 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksums(t *testing.T) {
+	data := []byte("foobar")
+	md5Sum := md5.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+
+	w := NewChecksumsWriter()
+	w.Write(data[:3])
+	w.Write(data[3:])
+	computed := w.Checksums()
+	assert.Equal(t, Checksums{
+		ChecksumMD5:    hex.EncodeToString(md5Sum[:]),
+		ChecksumSHA256: hex.EncodeToString(sha256Sum[:]),
+	}, computed)
+
+	assert.True(t, Checksums{}.Matches(computed))
+	assert.True(t, Checksums{
+		ChecksumMD5: strings.ToUpper(hex.EncodeToString(md5Sum[:])),
+	}.Matches(computed))
+	assert.False(t, Checksums{
+		ChecksumMD5:    hex.EncodeToString(make([]byte, 16)),
+		ChecksumSHA256: hex.EncodeToString(sha256Sum[:]),
+	}.Matches(computed))
+	assert.False(t, Checksums{"sha1": "0000"}.Matches(computed))
+}
+
+func TestChecksumsState(t *testing.T) {
+	data := []byte("foobar")
+
+	w := NewChecksumsWriter()
+	w.Write(data[:3])
+	state, err := w.State()
+	assert.NoError(t, err)
+	assert.Len(t, state, 2)
+
+	resumed, err := NewChecksumsWriterFromState(state)
+	assert.NoError(t, err)
+	resumed.Write(data[3:])
+
+	w = NewChecksumsWriter()
+	w.Write(data)
+	assert.Equal(t, w.Checksums(), resumed.Checksums())
+
+	_, err = NewChecksumsWriterFromState(ChecksumsState{ChecksumMD5: state[ChecksumMD5]})
+	assert.Equal(t, ErrInvalidChecksumsState, err)
+
+	_, err = NewChecksumsWriterFromState(ChecksumsState{
+		ChecksumMD5:    state[ChecksumMD5],
+		ChecksumSHA256: []byte("garbage"),
+	})
+	assert.Equal(t, ErrInvalidChecksumsState, err)
+}
+
+func TestUploadSessionConstructorValidateChecksums(t *testing.T) {
+	sha256Sum := hex.EncodeToString(make([]byte, 32))
+	md5Sum := hex.EncodeToString(make([]byte, 16))
+
+	testCases := map[string]struct {
+		checksum  string
+		checksums Checksums
+
+		expected Checksums
+		err      error
+	}{
+		"none": {
+			expected: Checksums{},
+		},
+		"legacy checksum": {
+			checksum: sha256Sum,
+			expected: Checksums{ChecksumSHA256: sha256Sum},
+		},
+		"checksums": {
+			checksums: Checksums{ChecksumMD5: md5Sum, ChecksumSHA256: sha256Sum},
+			expected:  Checksums{ChecksumMD5: md5Sum, ChecksumSHA256: sha256Sum},
+		},
+		"both forms": {
+			checksum:  sha256Sum,
+			checksums: Checksums{ChecksumMD5: md5Sum, ChecksumSHA256: sha256Sum},
+			expected:  Checksums{ChecksumMD5: md5Sum, ChecksumSHA256: sha256Sum},
+		},
+		"conflicting sha256": {
+			checksum:  sha256Sum,
+			checksums: Checksums{ChecksumSHA256: strings.Repeat("1", 64)},
+			err:       ErrConflictingChecksums,
+		},
+		"unknown algorithm": {
+			checksums: Checksums{"sha1": strings.Repeat("0", 40)},
+			err:       ErrUnknownChecksumAlgorithm,
+		},
+		"wrong length": {
+			checksums: Checksums{ChecksumMD5: sha256Sum},
+			err:       ErrInvalidChecksum,
+		},
+		"not hex": {
+			checksums: Checksums{ChecksumMD5: strings.Repeat("x", 32)},
+			err:       ErrInvalidChecksum,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			constructor := &UploadSessionConstructor{
+				Size:      1,
+				Checksum:  tc.checksum,
+				Checksums: tc.checksums,
+			}
+			err := constructor.Validate()
+			if tc.err != nil {
+				assert.Equal(t, tc.err, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, constructor.ExpectedChecksums())
+		})
+	}
+}
+
+func TestValidateChecksumsRecomputation(t *testing.T) {
+	batch := &ChecksumsRecomputation{}
+	assert.NoError(t, batch.Validate())
+	assert.Equal(t, DefaultChecksumsBatch, batch.Limit)
+
+	for _, limit := range []int{-1, MaxChecksumsBatch + 1} {
+		batch = &ChecksumsRecomputation{Limit: limit}
+		assert.Equal(t, ErrInvalidChecksumsBatch, batch.Validate())
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+// Headers
+const (
+	HttpHeaderContentDisposition = "Content-Disposition"
+)
+
+// Name of the exported bundle file suggested to the client
+const DefaultBundleFileName = "artifacts.tar"
+
+// ExportImages streams the bundle of all the artifacts: the manifest with
+// the artifacts metadata followed by the artifact files.
+func (s *SoftwareImagesController) ExportImages(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	ctx, cancel := withTimeout(r.Context(), s.timeouts.Upload)
+	defer cancel()
+
+	out := &exportWriter{w: w.(http.ResponseWriter)}
+	err := s.model.ExportImages(ctx, out)
+	switch {
+	case err == nil:
+		out.start()
+	case !out.started:
+		s.view.RenderInternalError(w, r, err, l)
+	default:
+		// the response is on its way already, the client is left
+		// with the truncated bundle
+		l.F(log.Ctx{"error": err.Error()}).Error("artifacts export failed")
+	}
+}
+
+// exportWriter sends the response headers with the first chunk of the bundle,
+// so that the errors occurring before can still be rendered.
+type exportWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (e *exportWriter) start() {
+	if !e.started {
+		e.started = true
+		e.w.Header().Set(HttpHeaderContentType, images.BundleContentType)
+		e.w.Header().Set(HttpHeaderContentDisposition,
+			fmt.Sprintf("attachment; filename=%q", DefaultBundleFileName))
+		e.w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (e *exportWriter) Write(b []byte) (int, error) {
+	e.start()
+	return e.w.Write(b)
+}
+
+// ImportImages creates the artifacts out of the bundle sent in the request
+// body, skipping the ones already present. Responds with the IDs of
+// the imported and skipped artifacts.
+func (s *SoftwareImagesController) ImportImages(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	// stop reading the body as soon as the client goes away
+	// or the import times out
+	ctx, cancel := withTimeout(r.Context(), s.timeouts.Upload)
+	defer cancel()
+
+	result, err := s.model.ImportImages(ctx, &contextReader{ctx: ctx, r: r.Body})
+	if err != nil && r.Context().Err() != nil {
+		// nobody is listening for the response anymore
+		l.F(log.Ctx{"error": err.Error()}).
+			Warn("client disconnected, artifacts import aborted")
+		return
+	}
+	if timedOut(ctx, err) {
+		s.view.RenderError(w, r, ErrOperationTimeout, http.StatusGatewayTimeout, l)
+		return
+	}
+	if renderArtifactsLimitError(s.view, w, r, err, l) {
+		return
+	}
+
+	cause := errors.Cause(err)
+	switch cause {
+	default:
+		s.view.RenderInternalError(w, r, err, l)
+	case nil:
+		w.WriteJson(result)
+	case ErrModelArtifactNotUnique, ErrModelImageQuarantined:
+		s.view.RenderError(w, r, err, http.StatusUnprocessableEntity, l)
+	case ErrModelInvalidBundle, ErrModelUploadChecksumMismatch, ErrModelUploadSizeMismatch,
+		ErrModelInvalidMetadata, ErrModelArtifactFileTooLarge,
+		ErrModelParsingArtifactFailed, ErrModelArtifactNotSigned,
+		ErrModelArtifactSignatureInvalid, ErrModelDeltaNameMismatch:
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+	}
+}
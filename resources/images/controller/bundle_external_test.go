@@ -0,0 +1,122 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package controller_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	. "github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/resources/images/controller/mocks"
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+	h "github.com/mendersoftware/deployments/utils/testing"
+)
+
+func TestControllerExportImages(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/artifacts/export", rest.Get, controller.ExportImages)
+
+	// error before anything is written
+	imagesModel.On("ExportImages", h.ContextMatcher(), mock.Anything).Return(errors.New("error")).Once()
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/artifacts/export", nil))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// OK
+	imagesModel.On("ExportImages", h.ContextMatcher(), mock.Anything).
+		Run(func(args mock.Arguments) {
+			args.Get(1).(io.Writer).Write([]byte("bundle"))
+		}).Return(nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/artifacts/export", nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.HeaderIs(HttpHeaderContentType, images.BundleContentType)
+	recorded.HeaderIs(HttpHeaderContentDisposition, `attachment; filename="artifacts.tar"`)
+	recorded.BodyIs("bundle")
+
+	// empty bundle is still a response
+	imagesModel.On("ExportImages", h.ContextMatcher(), mock.Anything).Return(nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/artifacts/export", nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.HeaderIs(HttpHeaderContentType, images.BundleContentType)
+}
+
+func TestControllerImportImages(t *testing.T) {
+	testCases := map[string]struct {
+		modelResult *images.ImportResult
+		modelErr    error
+
+		code int
+	}{
+		"ok": {
+			modelResult: &images.ImportResult{
+				Imported: map[string]string{"a": "b"},
+				Skipped:  map[string]string{"c": "d"},
+			},
+			code: http.StatusOK,
+		},
+		"invalid bundle": {
+			modelErr: pkgerrors.Wrap(ErrModelInvalidBundle, "unexpected entry foo"),
+			code:     http.StatusBadRequest,
+		},
+		"checksum mismatch": {
+			modelErr: pkgerrors.Wrap(ErrModelUploadChecksumMismatch, "Importing image a"),
+			code:     http.StatusBadRequest,
+		},
+		"not unique": {
+			modelErr: pkgerrors.Wrap(ErrModelArtifactNotUnique, "Importing image a"),
+			code:     http.StatusUnprocessableEntity,
+		},
+		"internal error": {
+			modelErr: errors.New("error"),
+			code:     http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			imagesModel := &mocks.ImagesModel{}
+			controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+			api := setUpRestTest("/api/0.0.1/artifacts/import", rest.Post, controller.ImportImages)
+
+			imagesModel.On("ImportImages", h.ContextMatcher(), mock.Anything).Return(tc.modelResult, tc.modelErr)
+
+			req, _ := http.NewRequest("POST", "http://localhost/api/0.0.1/artifacts/import",
+				bytes.NewReader([]byte("bundle")))
+			req.Header.Set("Content-Type", images.BundleContentType)
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+			recorded.CodeIs(tc.code)
+
+			if tc.modelResult != nil {
+				var result images.ImportResult
+				assert.NoError(t, recorded.DecodeJsonPayload(&result))
+				assert.Equal(t, *tc.modelResult, result)
+			}
+		})
+	}
+}
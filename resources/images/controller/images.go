@@ -15,20 +15,101 @@
 package controller
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/asaskevich/govalidator"
+	"github.com/mendersoftware/go-lib-micro/identity"
 	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/rest_utils"
 	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/utils/readpref"
+	"github.com/mendersoftware/deployments/utils/restutil"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+// Headers
+const (
+	HttpHeaderLastModified = "Last-Modified"
+	HttpHeaderCacheControl = "Cache-Control"
+	HttpHeaderExpires      = "Expires"
+	HttpHeaderAccept       = "Accept"
+	HttpHeaderRetryAfter   = "Retry-After"
+	HttpHeaderContentType  = "Content-Type"
+	HttpHeaderETag         = "ETag"
+
+	// Storage region the client prefers to download the artifact from
+	HttpHeaderRegion = "X-Storage-Region"
+)
+
+// Query parameters
+const (
+	// Respond with redirect to the download link instead of rendering it
+	QueryRedirect = "redirect"
+
+	// Storage region the client prefers to download the artifact from,
+	// takes precedence over the header
+	QueryRegion = "region"
+
+	// List only the artifacts having the tag, can be repeated
+	QueryTag = "tag"
+
+	// Prefix of the parameters listing only the artifacts having the custom
+	// metadata value of the key following the prefix, e.g. meta.git_sha=...
+	QueryMetadataPrefix = "meta."
+
+	// List only the artifacts compatible with the device type
+	QueryDeviceType = "device_type"
+
+	// List only the artifacts of the file SHA256 checksum
+	QueryChecksum = "checksum"
+
+	// List only the artifacts of at least/at most the size in bytes
+	QueryMinSize = "min_size"
+	QueryMaxSize = "max_size"
+
+	// Sort order of the listed artifacts
+	QuerySort = "sort"
+
+	// List only the latest artifact of each device type
+	QueryLatestPerDeviceType = "latest_per_device_type"
+
+	// Comma separated fields of the listed artifacts, the others are
+	// left empty
+	QueryFields = "fields"
+
+	// List only the artifact changes made after the time (RFC3339)
+	QuerySince = "since"
+
+	// Maximum number of the listed artifact changes
+	QueryLimit = "limit"
+
+	// Confirms the removal of all the artifacts of the device type
+	QueryConfirm = "confirm"
+
+	// Abort the active deployments using the artifacts to be removed
+	QueryForce = "force"
+)
+
+// Media types
+const (
+	ContentTypeText     = "text/plain"
+	ContentTypeArtifact = "application/vnd.mender-artifact"
 )
 
 // API input validation constants
@@ -37,17 +118,153 @@ const (
 	DefaultDownloadLinkExpire = 15 * time.Minute
 
 	DefaultMaxMetaSize = 1024 * 1024 * 10
+
+	// Artifact file of the largest size accepted by the model (10GiB),
+	// along with the metadata fields and the multipart encoding overhead
+	DefaultMaxUploadRequestSize = 1024*1024*1024*10 + 2*DefaultMaxMetaSize
+
+	// Maximum number of artifacts fetched with single GetImages request
+	MaxGetImagesIDs = 100
+
+	// Maximum number of artifacts tagged with single request
+	MaxTagsUpdateIDs = 1000
+
+	// Prefix of the form parts setting the custom metadata value of the key
+	// following the prefix, e.g. meta.git_sha
+	MetadataFormPrefix = "meta."
+
+	// Suggested delay before retrying upload rejected due to the concurrency limit
+	DefaultUploadRetryAfter = 30 * time.Second
+
+	// Suggested delay before retrying request throttled by the file storage
+	DefaultStorageRetryAfter = 5 * time.Second
+)
+
+// Codes of the errors rendered along with the message
+const (
+	ErrCodeArtifactFileMissing  = "artifact_file_missing"
+	ErrCodeStorageAccessDenied  = "storage_access_denied"
+	ErrCodeStorageMisconfigured = "storage_misconfigured"
+	ErrCodeStorageThrottled     = "storage_throttled"
+	ErrCodeStorageUploadTimeout = "storage_upload_timeout"
+	ErrCodeNoMatches            = "no_matches"
+	ErrCodeArtifactsLimit       = "artifacts_limit_exceeded"
 )
 
 var (
 	ErrIDNotUUIDv4                    = errors.New("ID is not UUIDv4")
 	ErrArtifactUsedInActiveDeployment = errors.New("Artifact is used in active deployment")
 	ErrInvalidExpireParam             = errors.New("Invalid expire parameter")
+	ErrInvalidRedirectParam           = errors.New("Invalid redirect parameter, expected boolean")
+	ErrInvalidLatestParam             = errors.New("Invalid latest_per_device_type parameter, expected boolean")
+	ErrInvalidSinceParam              = errors.New("Invalid since parameter, expected RFC 3339 time")
+	ErrTooManyUploads                 = errors.New("Too many concurrent artifact uploads, try again later")
+	ErrOperationTimeout               = restutil.ErrOperationTimeout
+	ErrArtifactContentTypeNotAllowed  = errors.New("Content type of the artifact is not allowed")
+	ErrInvalidExpiresAt               = errors.New("Invalid expires_at, expected RFC 3339 time")
+	ErrUploadRequestTooLarge          = errors.New("Request body too large")
+	ErrDeviceTypeRequired             = errors.New("Device type required")
+	ErrDeleteNotConfirmed             = errors.New("Removal of all the artifacts of the device type not confirmed, expected confirm=true")
+	ErrInvalidForceParam              = errors.New("Invalid force parameter, expected boolean")
+	ErrNoArtifactsMatch               = errors.New("No artifacts match the query")
 )
 
+// EmptyListStatus is the status of the artifact listing response when no
+// artifacts match the query, configurable on startup: 200 with empty list
+// (default), 204 without body, or 404 with ErrCodeNoMatches code, so that it
+// can be told apart from the artifact not found.
+var EmptyListStatus = http.StatusOK
+
+// AllowedArtifactContentTypes lists the media types accepted for the artifact
+// part of the upload, configurable on startup; empty list accepts any.
+var AllowedArtifactContentTypes []string
+
+// FormFields are the canonical names of the parts of the artifact upload
+// form, besides the custom metadata ones prefixed with MetadataFormPrefix.
+var FormFields = []string{
+	"size",
+	"description",
+	"tags",
+	"expires_at",
+	"delta_from",
+	"delta_to",
+	"artifact",
+}
+
+// FormFieldAliases maps the alternate names of the upload form parts,
+// in lower case, to the canonical ones; configurable on startup.
+// Aliases are matched case insensitively, canonical names always take
+// precedence.
+var FormFieldAliases map[string]string
+
+// RejectUnknownFormFields fails the upload with the form parts which are
+// neither known nor aliased, instead of ignoring them; configurable on startup.
+var RejectUnknownFormFields bool
+
+// IsFormField tells if the name is the canonical name of an upload form part.
+func IsFormField(name string) bool {
+	if strings.HasPrefix(name, MetadataFormPrefix) {
+		return true
+	}
+	for _, field := range FormFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalFormName resolves the alias of the upload form part name,
+// other names are returned as they are.
+func canonicalFormName(name string) string {
+	if IsFormField(name) {
+		return name
+	}
+	if canonical, ok := FormFieldAliases[strings.ToLower(name)]; ok {
+		return canonical
+	}
+	return name
+}
+
+// MaxUploadRequestSize limits the total size of the artifact upload request
+// body, all the parts included.
+var MaxUploadRequestSize int64 = DefaultMaxUploadRequestSize
+
+// DownloadLinkClockSkew is subtracted from the expiry time of the download
+// links, so that the clients with clocks running behind don't use the links
+// after they expire; configurable on startup.
+var DownloadLinkClockSkew time.Duration
+
+// Artifact fields which can be changed with PatchImage
+var patchableImageFields = map[string]bool{
+	"description": true,
+	"tags":        true,
+	"expires_at":  true,
+	"metadata":    true,
+	"deprecated":  true,
+}
+
+// Request fields accepted by CloneImage
+var cloneImageFields = map[string]bool{
+	"tenant_id":   true,
+	"description": true,
+	"tags":        true,
+}
+
 type SoftwareImagesController struct {
-	view  RESTView
-	model ImagesModel
+	view          RESTView
+	model         ImagesModel
+	uploadLimiter *UploadLimiter
+	timeouts      Timeouts
+	metrics       UploadMetrics
+}
+
+// Timeouts bound the artifact operations, including the underlying storage
+// calls; zero means no timeout. The other operations are bounded with
+// restutil.WithOperationTimeout.
+type Timeouts struct {
+	// Artifact upload, import and export
+	Upload time.Duration
 }
 
 // MultipartUploadMsg is a structure with fields extracted from the mulitpart/form-data form
@@ -55,20 +272,656 @@ type SoftwareImagesController struct {
 type MultipartUploadMsg struct {
 	// user metadata constructor
 	MetaConstructor *images.SoftwareImageMetaConstructor
-	// size of the artifact file
+	// declared size of the artifact file, the file read has to match it
 	ArtifactSize int64
 	// reader pointing to the beginning of the artifact data
 	ArtifactReader io.Reader
+	// delta the artifact is, nil for full artifacts
+	Delta *images.DeltaUpdate
+	// expected checksums of the artifact file by algorithm, optional
+	Checksums images.Checksums
+	// name of the artifact file as sent by the client
+	ArtifactFileName string
 }
 
-func NewSoftwareImagesController(model ImagesModel, view RESTView) *SoftwareImagesController {
+// NewSoftwareImagesController creates the controller, nil uploadLimiter
+// means the uploads are not limited, nil timeouts mean no timeouts.
+func NewSoftwareImagesController(model ImagesModel, view RESTView,
+	uploadLimiter *UploadLimiter, timeouts *Timeouts) *SoftwareImagesController {
+	if uploadLimiter == nil {
+		uploadLimiter = NewUploadLimiter(0, 0)
+	}
+	if timeouts == nil {
+		timeouts = &Timeouts{}
+	}
 	return &SoftwareImagesController{
-		model: model,
-		view:  view,
+		model:         model,
+		view:          view,
+		uploadLimiter: uploadLimiter,
+		timeouts:      *timeouts,
+	}
+}
+
+// SetMetrics sets the metrics the uploads are recorded with,
+// nothing is recorded if nil.
+func (s *SoftwareImagesController) SetMetrics(metrics UploadMetrics) {
+	s.metrics = metrics
+}
+
+// withTimeout bounds the context with the timeout, if set.
+func withTimeout(ctx context.Context,
+	timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timedOut checks if the operation failed because its deadline passed.
+func timedOut(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == context.DeadlineExceeded
+}
+
+func (s *SoftwareImagesController) GetImage(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		s.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	// stale reads are fine for displaying the artifact
+	image, err := s.model.GetImage(readpref.WithSecondaryReads(r.Context()), id)
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if image == nil {
+		s.view.RenderErrorNotFound(w, r, l)
+		return
+	}
+
+	s.view.RenderSuccessGetNegotiated(w, r, image)
+}
+
+// GetImages fetches multiple artifacts by ID at once.
+// Artifacts which are not found are listed as missing instead of failing the request.
+func (s *SoftwareImagesController) GetImages(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := restutil.DecodeJsonObject(r.Body, &body); err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	if err := restutil.ValidateIDList(body.IDs, MaxGetImagesIDs); err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	lookup, err := s.model.GetImages(readpref.WithSecondaryReads(r.Context()), body.IDs)
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	s.view.RenderSuccessGet(w, r, lookup)
+}
+
+// ListInstallableImages lists the artifacts which can be installed on
+// the device with the provides given, directly or after the others.
+func (s *SoftwareImagesController) ListInstallableImages(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	var body struct {
+		Provides images.Provides `json:"provides"`
+	}
+	if err := restutil.DecodeJsonObject(r.Body, &body); err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	if err := body.Provides.Validate(); err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	steps, err := s.model.ListInstallableImages(
+		readpref.WithSecondaryReads(r.Context()), body.Provides)
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	s.view.RenderSuccessGet(w, r, steps)
+}
+
+func (s *SoftwareImagesController) ListImages(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	page, err := restutil.ParseCursorPage(r)
+	if err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	filter, err := parseImagesFilter(r.URL.Query(), page)
+	if err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	list, err := s.model.ListImages(readpref.WithSecondaryReads(r.Context()), filter)
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if len(list) == 0 {
+		switch EmptyListStatus {
+		case http.StatusNoContent:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case http.StatusNotFound:
+			s.view.RenderErrorWithCode(w, r, ErrNoArtifactsMatch,
+				http.StatusNotFound, ErrCodeNoMatches, l)
+			return
+		}
+		list = []*images.SoftwareImage{}
+	}
+
+	// one more image is listed to tell if there is the next page
+	if page != nil && len(list) > page.PerPage {
+		list = list[:page.PerPage]
+		w.Header().Add(rest_utils.LinkHdr, restutil.MakeCursorLinkHdr(r,
+			&restutil.Cursor{ID: list[len(list)-1].Id}))
+	}
+
+	s.view.RenderSuccessGetNegotiated(w, r, list)
+}
+
+// parseImagesFilter parses and validates the artifact list query parameters,
+// the images are listed by the page of the cursor pagination if given
+func parseImagesFilter(vals url.Values,
+	page *restutil.CursorPage) (*images.ImagesFilter, error) {
+	filter := &images.ImagesFilter{
+		Tags:       vals[QueryTag],
+		DeviceType: vals.Get(QueryDeviceType),
+		Checksum:   vals.Get(QueryChecksum),
+		Sort:       vals.Get(QuerySort),
+	}
+	for _, tag := range filter.Tags {
+		if err := images.ValidateTag(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	for param := range vals {
+		if !strings.HasPrefix(param, QueryMetadataPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(param, QueryMetadataPrefix)
+		if err := images.ValidateMetadataKey(key); err != nil {
+			return nil, err
+		}
+		if filter.Metadata == nil {
+			filter.Metadata = make(map[string]string)
+		}
+		filter.Metadata[key] = vals.Get(param)
+	}
+
+	for param, size := range map[string]*int64{
+		QueryMinSize: &filter.MinSize,
+		QueryMaxSize: &filter.MaxSize,
+	} {
+		if value := vals.Get(param); value != "" {
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, images.ErrInvalidSizeRange
+			}
+			*size = v
+		}
+	}
+
+	if value := vals.Get(QueryLatestPerDeviceType); value != "" {
+		latest, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, ErrInvalidLatestParam
+		}
+		filter.LatestPerDeviceType = latest
+	}
+
+	if values, ok := vals[QueryFields]; ok {
+		filter.Fields = []string{}
+		for _, value := range values {
+			for _, field := range strings.Split(value, ",") {
+				if field = strings.TrimSpace(field); field != "" {
+					filter.Fields = append(filter.Fields, field)
+				}
+			}
+		}
+		if len(filter.Fields) == 0 {
+			filter.Fields = images.DefaultListFields
+		}
+	}
+
+	if page != nil {
+		filter.Limit = page.PerPage + 1
+		if page.Cursor != nil {
+			filter.After = page.Cursor.ID
+		}
+	}
+
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// ListDeviceTypes lists the device types the artifacts are compatible with,
+// with the number of artifacts for each type.
+func (s *SoftwareImagesController) ListDeviceTypes(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	deviceTypes, err := s.model.ListDeviceTypes(readpref.WithSecondaryReads(r.Context()))
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	s.view.RenderSuccessGet(w, r, deviceTypes)
+}
+
+// ListImageChanges lists the recently created, modified and deleted
+// artifacts, for polling of the changes.
+func (s *SoftwareImagesController) ListImageChanges(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	query, err := parseImageChangesQuery(r.URL.Query())
+	if err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	changes, err := s.model.ListImageChanges(r.Context(), query)
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	s.view.RenderSuccessGet(w, r, changes)
+}
+
+// parseImageChangesQuery parses and validates the artifact changes
+// query parameters
+func parseImageChangesQuery(vals url.Values) (*images.ImageChangesQuery, error) {
+	query := &images.ImageChangesQuery{}
+
+	if value := vals.Get(QuerySince); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, ErrInvalidSinceParam
+		}
+		query.Since = &since
+	}
+
+	if value := vals.Get(QueryLimit); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit == 0 {
+			return nil, images.ErrInvalidChangesLimit
+		}
+		query.Limit = limit
+	}
+
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+func (s *SoftwareImagesController) DownloadLink(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		s.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	redirect, err := wantsRedirect(r)
+	if err != nil {
+		s.view.RenderError(w, r, ErrInvalidRedirectParam, http.StatusBadRequest, l)
+		return
+	}
+
+	link, err := s.model.DownloadLink(r.Context(), id, DefaultDownloadLinkExpire,
+		preferredRegion(r))
+	if renderStorageError(s.view, w, r, err, l) {
+		return
+	}
+	switch cause := errors.Cause(err); cause {
+	case ErrModelImagePending, ErrModelImageScanning, ErrModelImageQuarantined:
+		s.view.RenderError(w, r, cause, http.StatusConflict, l)
+		return
+	}
+	if errors.Cause(err) == ErrModelImageNotAssigned {
+		s.view.RenderError(w, r, ErrModelImageNotAssigned, http.StatusForbidden, l)
+		return
+	}
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if link == nil {
+		s.view.RenderErrorNotFound(w, r, l)
+		return
+	}
+
+	if !link.Expire.IsZero() {
+		link.Expire = link.Expire.Add(-DownloadLinkClockSkew)
+	}
+
+	setLinkCacheHeaders(w, link, time.Now())
+	if redirect {
+		s.view.RenderSuccessRedirect(w, link.Uri)
+		return
+	}
+	s.view.RenderSuccessGet(w, r, link)
+}
+
+// DownloadImage streams the artifact file through the service, for the clients
+// which can not use the download links. Range requests are supported,
+// so that interrupted downloads can be resumed; the file is read from
+// the storage as it is sent, never as a whole.
+func (s *SoftwareImagesController) DownloadImage(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		s.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	file, err := s.model.OpenImage(r.Context(), id)
+	switch cause := errors.Cause(err); cause {
+	case ErrModelImageNotAssigned:
+		s.view.RenderError(w, r, cause, http.StatusForbidden, l)
+		return
+	case ErrModelImagePending, ErrModelImageScanning, ErrModelImageQuarantined:
+		s.view.RenderError(w, r, cause, http.StatusConflict, l)
+		return
+	}
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if file == nil {
+		s.view.RenderErrorNotFound(w, r, l)
+		return
+	}
+	defer file.Close()
+
+	// content type is set upfront, so that the file is not read to detect it
+	w.Header().Set(HttpHeaderContentType, ContentTypeArtifact)
+	// the checksum identifies the file content, so that the conditional
+	// and resumed (If-Range) requests are matched against it
+	if file.Checksum != "" {
+		w.Header().Set(HttpHeaderETag, `"`+file.Checksum+`"`)
+	}
+	http.ServeContent(w.(http.ResponseWriter), r.Request, "", file.Modified, file)
+}
+
+// wantsRedirect tells if the client asked to be redirected to the download
+// link, with either redirect query parameter or plain text Accept header;
+// the query parameter takes precedence.
+func wantsRedirect(r *rest.Request) (bool, error) {
+	if value := r.URL.Query().Get(QueryRedirect); value != "" {
+		return strconv.ParseBool(value)
+	}
+
+	mediatype, _, err := mime.ParseMediaType(r.Header.Get(HttpHeaderAccept))
+	return err == nil && mediatype == ContentTypeText, nil
+}
+
+// preferredRegion tells the storage region the client asked to download
+// from, with either region query parameter or the header; empty if none.
+func preferredRegion(r *rest.Request) string {
+	if region := r.URL.Query().Get(QueryRegion); region != "" {
+		return region
+	}
+	return r.Header.Get(HttpHeaderRegion)
+}
+
+// setLinkCacheHeaders lets the clients reuse the link until it expires.
+// max-age is rounded down, so that it never exceeds the link validity.
+func setLinkCacheHeaders(w rest.ResponseWriter, link *images.Link, now time.Time) {
+	maxAge := int64(link.Expire.Sub(now) / time.Second)
+	if maxAge < 0 {
+		maxAge = 0
+	}
+
+	w.Header().Set(HttpHeaderLastModified, now.UTC().Format(http.TimeFormat))
+	w.Header().Set(HttpHeaderCacheControl, fmt.Sprintf("private, max-age=%d", maxAge))
+	w.Header().Set(HttpHeaderExpires, link.Expire.UTC().Format(http.TimeFormat))
+}
+
+// RotateDownloadLinks revokes the download links issued for the image so far.
+func (s *SoftwareImagesController) RotateDownloadLinks(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		s.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	err := s.model.RotateDownloadLinks(r.Context(), id)
+	switch err {
+	default:
+		s.view.RenderInternalError(w, r, err, l)
+	case nil:
+		s.view.RenderSuccessPut(w)
+	case ErrImageMetaNotFound:
+		s.view.RenderErrorNotFound(w, r, l)
+	case ErrModelLinkRotationNotSupported:
+		s.view.RenderError(w, r, err, http.StatusNotImplemented, l)
+	}
+}
+
+// ApproveImage allows the image to be deployed when the approval is required.
+func (s *SoftwareImagesController) ApproveImage(w rest.ResponseWriter, r *rest.Request) {
+	s.setImageState(w, r, images.ImageStateApproved)
+}
+
+// DeprecateImage withdraws the approval of the image.
+func (s *SoftwareImagesController) DeprecateImage(w rest.ResponseWriter, r *rest.Request) {
+	s.setImageState(w, r, images.ImageStateDeprecated)
+}
+
+func (s *SoftwareImagesController) setImageState(w rest.ResponseWriter, r *rest.Request,
+	state string) {
+
+	l := log.FromContext(r.Context())
+
+	if !restutil.HasRole(r, ArtifactReviewerRole) {
+		s.view.RenderError(w, r, ErrArtifactReviewerRoleRequired, http.StatusForbidden, l)
+		return
+	}
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		s.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	err := s.model.SetImageState(r.Context(), id, state)
+	switch err {
+	default:
+		s.view.RenderInternalError(w, r, err, l)
+	case nil:
+		s.view.RenderSuccessPut(w)
+	case ErrImageMetaNotFound:
+		s.view.RenderErrorNotFound(w, r, l)
+	case ErrModelImagePending, ErrModelImageScanning, ErrModelImageQuarantined,
+		ErrModelInvalidStateTransition:
+		s.view.RenderError(w, r, err, http.StatusConflict, l)
+	}
+}
+
+func (s *SoftwareImagesController) DeleteImage(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		s.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	if err := s.model.DeleteImage(r.Context(), id); err != nil {
+		switch err {
+		default:
+			s.view.RenderInternalError(w, r, err, l)
+		case ErrImageMetaNotFound:
+			s.view.RenderErrorNotFound(w, r, l)
+		case ErrModelImageInActiveDeployment:
+			s.view.RenderError(w, r, ErrArtifactUsedInActiveDeployment, http.StatusConflict, l)
+		}
+		return
+	}
+
+	s.view.RenderSuccessDelete(w)
+}
+
+// DeleteDeviceTypeImages removes all the artifacts compatible with the device
+// type; the removal has to be confirmed. Artifacts used in active deployments
+// are skipped, unless forced to abort the deployments.
+func (s *SoftwareImagesController) DeleteDeviceTypeImages(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	query := r.URL.Query()
+	deviceType := images.NormalizeDeviceType(query.Get(QueryDeviceType))
+	if deviceType == "" {
+		s.view.RenderError(w, r, ErrDeviceTypeRequired, http.StatusBadRequest, l)
+		return
+	}
+
+	if confirmed, err := strconv.ParseBool(query.Get(QueryConfirm)); err != nil || !confirmed {
+		s.view.RenderError(w, r, ErrDeleteNotConfirmed, http.StatusBadRequest, l)
+		return
+	}
+
+	var force bool
+	if value := query.Get(QueryForce); value != "" {
+		var err error
+		if force, err = strconv.ParseBool(value); err != nil {
+			s.view.RenderError(w, r, ErrInvalidForceParam, http.StatusBadRequest, l)
+			return
+		}
+	}
+
+	report, err := s.model.DeleteDeviceTypeImages(r.Context(), deviceType, force)
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	s.view.RenderSuccessGet(w, r, report)
+}
+
+// AddImagesTag adds the tag to all the artifacts selected by IDs and/or
+// the compatible device type at once. Responds with the number of artifacts
+// modified.
+func (s *SoftwareImagesController) AddImagesTag(w rest.ResponseWriter, r *rest.Request) {
+	s.updateImagesTags(w, r, s.model.AddImagesTag)
+}
+
+// RemoveImagesTag removes the tag from all the artifacts selected by IDs
+// and/or the compatible device type at once. Responds with the number of
+// artifacts modified.
+func (s *SoftwareImagesController) RemoveImagesTag(w rest.ResponseWriter, r *rest.Request) {
+	s.updateImagesTags(w, r, s.model.RemoveImagesTag)
+}
+
+func (s *SoftwareImagesController) updateImagesTags(w rest.ResponseWriter, r *rest.Request,
+	apply func(context.Context, *images.TagsUpdate) (*images.TagsUpdateReport, error)) {
+
+	l := log.FromContext(r.Context())
+
+	var update images.TagsUpdate
+	if err := restutil.DecodeJsonObject(r.Body, &update); err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	if len(update.IDs) > 0 {
+		if err := restutil.ValidateIDList(update.IDs, MaxTagsUpdateIDs); err != nil {
+			s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+			return
+		}
+	}
+
+	if err := update.Validate(); err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	report, err := apply(r.Context(), &update)
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	s.view.RenderSuccessGet(w, r, report)
+}
+
+func (s *SoftwareImagesController) EditImage(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		s.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	constructor, err := s.getSoftwareImageMetaConstructorFromBody(r)
+	if err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	found, err := s.model.EditImage(r.Context(), id, constructor)
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if !found {
+		s.view.RenderErrorNotFound(w, r, l)
+		return
 	}
+
+	s.view.RenderSuccessPut(w)
 }
 
-func (s *SoftwareImagesController) GetImage(w rest.ResponseWriter, r *rest.Request) {
+// PatchImage updates only the metadata fields present in the request body.
+// Attempts to change any other field are rejected.
+func (s *SoftwareImagesController) PatchImage(w rest.ResponseWriter, r *rest.Request) {
 	l := log.FromContext(r.Context())
 
 	id := r.PathParam("id")
@@ -78,34 +931,40 @@ func (s *SoftwareImagesController) GetImage(w rest.ResponseWriter, r *rest.Reque
 		return
 	}
 
-	image, err := s.model.GetImage(r.Context(), id)
+	patch, err := s.getSoftwareImageMetaPatchFromBody(r)
 	if err != nil {
-		s.view.RenderInternalError(w, r, err, l)
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
 		return
 	}
 
-	if image == nil {
-		s.view.RenderErrorNotFound(w, r, l)
+	found, err := s.model.PatchImage(r.Context(), id, patch)
+	switch errors.Cause(err) {
+	case ErrModelInvalidMetadata:
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	case ErrModelImagePending, ErrModelImageScanning, ErrModelImageQuarantined,
+		ErrModelInvalidStateTransition:
+		s.view.RenderError(w, r, err, http.StatusConflict, l)
 		return
 	}
-
-	s.view.RenderSuccessGet(w, image)
-}
-
-func (s *SoftwareImagesController) ListImages(w rest.ResponseWriter, r *rest.Request) {
-	l := log.FromContext(r.Context())
-
-	list, err := s.model.ListImages(r.Context(), r.PathParams)
 	if err != nil {
 		s.view.RenderInternalError(w, r, err, l)
 		return
 	}
 
-	s.view.RenderSuccessGet(w, list)
+	if !found {
+		s.view.RenderErrorNotFound(w, r, l)
+		return
+	}
+
+	s.view.RenderSuccessPut(w)
 }
 
-func (s *SoftwareImagesController) DownloadLink(w rest.ResponseWriter, r *rest.Request) {
-	l := log.FromContext(r.Context())
+// CloneImage copies the artifact, together with its file, optionally to another tenant.
+// Responds with ID of the copy.
+func (s *SoftwareImagesController) CloneImage(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
 
 	id := r.PathParam("id")
 
@@ -114,21 +973,37 @@ func (s *SoftwareImagesController) DownloadLink(w rest.ResponseWriter, r *rest.R
 		return
 	}
 
-	link, err := s.model.DownloadLink(r.Context(), id, DefaultDownloadLinkExpire)
+	clone, err := s.getSoftwareImageCloneFromBody(r)
 	if err != nil {
-		s.view.RenderInternalError(w, r, err, l)
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
 		return
 	}
 
-	if link == nil {
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: r.PathParam("tenant"),
+	})
+
+	cloneID, err := s.model.CloneImage(ctx, id, clone)
+	switch cause := errors.Cause(err); cause {
+	default:
+		s.view.RenderInternalError(w, r, err, l)
+	case ErrImageMetaNotFound:
 		s.view.RenderErrorNotFound(w, r, l)
-		return
+	case ErrModelArtifactNotUnique:
+		s.view.RenderError(w, r, cause, http.StatusUnprocessableEntity, l)
+	case ErrModelImagePending, ErrModelImageScanning, ErrModelImageQuarantined:
+		s.view.RenderError(w, r, cause, http.StatusConflict, l)
+	case nil:
+		// the copy may belong to another tenant, respond with its ID
+		// instead of the location
+		w.WriteHeader(http.StatusCreated)
+		w.WriteJson(map[string]string{"id": cloneID})
 	}
-
-	s.view.RenderSuccessGet(w, link)
 }
 
-func (s *SoftwareImagesController) DeleteImage(w rest.ResponseWriter, r *rest.Request) {
+// ShareImage replaces the tenants the artifact is shared with, only
+// the tenant owning the artifact can share it.
+func (s *SoftwareImagesController) ShareImage(w rest.ResponseWriter, r *rest.Request) {
 	l := log.FromContext(r.Context())
 
 	id := r.PathParam("id")
@@ -138,23 +1013,33 @@ func (s *SoftwareImagesController) DeleteImage(w rest.ResponseWriter, r *rest.Re
 		return
 	}
 
-	if err := s.model.DeleteImage(r.Context(), id); err != nil {
-		switch err {
-		default:
-			s.view.RenderInternalError(w, r, err, l)
-		case ErrImageMetaNotFound:
-			s.view.RenderErrorNotFound(w, r, l)
-		case ErrModelImageInActiveDeployment:
-			s.view.RenderError(w, r, ErrArtifactUsedInActiveDeployment, http.StatusConflict, l)
-		}
+	sharing, err := s.getImageSharingFromBody(r)
+	if err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
 		return
 	}
 
-	s.view.RenderSuccessDelete(w)
+	err = s.model.ShareImage(r.Context(), id, sharing)
+	switch cause := errors.Cause(err); cause {
+	default:
+		s.view.RenderInternalError(w, r, err, l)
+	case nil:
+		s.view.RenderSuccessPut(w)
+	case ErrImageMetaNotFound:
+		s.view.RenderErrorNotFound(w, r, l)
+	case ErrModelImageNotOwned:
+		s.view.RenderError(w, r, cause, http.StatusForbidden, l)
+	case ErrModelImageSharedWithOwner:
+		s.view.RenderError(w, r, cause, http.StatusBadRequest, l)
+	}
 }
 
-func (s *SoftwareImagesController) EditImage(w rest.ResponseWriter, r *rest.Request) {
-	l := log.FromContext(r.Context())
+// GetImageLocation responds with the location of the artifact file in the
+// file storage, for the operators; internal API only, as the storage layout
+// is not exposed to the tenants.
+func (s *SoftwareImagesController) GetImageLocation(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
 
 	id := r.PathParam("id")
 
@@ -163,39 +1048,175 @@ func (s *SoftwareImagesController) EditImage(w rest.ResponseWriter, r *rest.Requ
 		return
 	}
 
-	constructor, err := s.getSoftwareImageMetaConstructorFromBody(r)
-	if err != nil {
-		s.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
-		return
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: r.PathParam("tenant"),
+	})
+
+	location, err := s.model.ImageLocation(ctx, id)
+	switch errors.Cause(err) {
+	default:
+		s.view.RenderInternalError(w, r, err, l)
+	case ErrImageMetaNotFound:
+		s.view.RenderErrorNotFound(w, r, l)
+	case nil:
+		s.view.RenderSuccessGet(w, r, location)
 	}
+}
 
-	found, err := s.model.EditImage(r.Context(), id, constructor)
+// ComposeImage creates a new artifact out of the updates of the given artifacts.
+func (s *SoftwareImagesController) ComposeImage(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	compose, err := s.getSoftwareImageComposeFromBody(r)
 	if err != nil {
-		s.view.RenderInternalError(w, r, err, l)
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
 		return
 	}
 
-	if !found {
-		s.view.RenderErrorNotFound(w, r, l)
+	imgID, err := s.model.ComposeImage(ctx, compose)
+	if renderArtifactsLimitError(s.view, w, r, err, l) {
 		return
 	}
+	switch cause := errors.Cause(err); cause {
+	default:
+		s.view.RenderInternalError(w, r, err, l)
+	case ErrImageMetaNotFound:
+		s.view.RenderError(w, r, err, http.StatusNotFound, l)
+	case ErrModelUnsupportedUpdateType:
+		s.view.RenderError(w, r, err, http.StatusUnprocessableEntity, l)
+	case ErrModelImagePending, ErrModelImageScanning, ErrModelImageQuarantined:
+		s.view.RenderError(w, r, err, http.StatusConflict, l)
+	case ErrModelIncompatibleDeviceTypes, ErrModelArtifactNotUnique,
+		ErrModelArtifactNotSigned, ErrModelArtifactFileTooLarge:
+		s.view.RenderError(w, r, cause, http.StatusUnprocessableEntity, l)
+	case nil:
+		s.view.RenderSuccessPostLocation(w, path.Join(r.URL.Path, "..", imgID))
+	}
+}
 
-	s.view.RenderSuccessPut(w)
+func (s SoftwareImagesController) getSoftwareImageComposeFromBody(r *rest.Request) (*images.SoftwareImageCompose, error) {
+
+	var compose images.SoftwareImageCompose
+
+	if err := restutil.DecodeJsonObject(r.Body, &compose); err != nil {
+		return nil, err
+	}
+
+	if err := compose.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Validating request body")
+	}
+
+	return &compose, nil
+}
+
+func (s SoftwareImagesController) getSoftwareImagePendingFromBody(r *rest.Request) (*images.SoftwareImagePending, error) {
+
+	var pending images.SoftwareImagePending
+
+	if err := restutil.DecodeJsonObject(r.Body, &pending); err != nil {
+		return nil, err
+	}
+
+	if err := pending.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Validating request body")
+	}
+
+	return &pending, nil
+}
+
+func (s SoftwareImagesController) getImageSharingFromBody(r *rest.Request) (*images.ImageSharing, error) {
+
+	var sharing images.ImageSharing
+
+	if err := restutil.DecodeJsonObject(r.Body, &sharing); err != nil {
+		return nil, err
+	}
+
+	if err := sharing.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Validating request body")
+	}
+
+	return &sharing, nil
+}
+
+func (s SoftwareImagesController) getSoftwareImageCloneFromBody(r *rest.Request) (*images.SoftwareImageClone, error) {
+
+	var fields map[string]json.RawMessage
+
+	if err := restutil.DecodeJsonObject(r.Body, &fields); err != nil {
+		return nil, err
+	}
+
+	for field := range fields {
+		if !cloneImageFields[field] {
+			return nil, errors.Errorf("Validating request body: Field '%s' can not be set", field)
+		}
+	}
+
+	// fields are known to be valid, decode them into the clone
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var clone images.SoftwareImageClone
+	if err := restutil.DecodeJsonObject(bytes.NewReader(data), &clone); err != nil {
+		return nil, err
+	}
+
+	if err := clone.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Validating request body")
+	}
+
+	return &clone, nil
+}
+
+func (s SoftwareImagesController) getSoftwareImageMetaPatchFromBody(r *rest.Request) (*images.SoftwareImageMetaPatch, error) {
+
+	var fields map[string]json.RawMessage
+
+	if err := restutil.DecodeJsonObject(r.Body, &fields); err != nil {
+		return nil, err
+	}
+
+	for field := range fields {
+		if !patchableImageFields[field] {
+			return nil, errors.Errorf("Validating request body: Field '%s' can not be modified", field)
+		}
+	}
+
+	// fields are known to be valid, decode them into the patch
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var patch images.SoftwareImageMetaPatch
+	if err := restutil.DecodeJsonObject(bytes.NewReader(data), &patch); err != nil {
+		return nil, err
+	}
+
+	if err := patch.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Validating request body")
+	}
+
+	return &patch, nil
 }
 
 func (s SoftwareImagesController) getSoftwareImageMetaConstructorFromBody(r *rest.Request) (*images.SoftwareImageMetaConstructor, error) {
 
-	var constructor *images.SoftwareImageMetaConstructor
+	var constructor images.SoftwareImageMetaConstructor
 
-	if err := r.DecodeJsonPayload(&constructor); err != nil {
+	if err := restutil.DecodeJsonObject(r.Body, &constructor); err != nil {
 		return nil, err
 	}
 
 	if err := constructor.Validate(); err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Validating request body")
 	}
 
-	return constructor, nil
+	return &constructor, nil
 }
 
 // Multipart Image/Meta upload handler.
@@ -203,41 +1224,313 @@ func (s SoftwareImagesController) getSoftwareImageMetaConstructorFromBody(r *res
 // First part should contain Metadata file. This file should be of type "application/json".
 // Second part should contain artifact file.
 func (s *SoftwareImagesController) NewImage(w rest.ResponseWriter, r *rest.Request) {
+	imgID, ok := s.receiveArtifact(w, r,
+		func(ctx context.Context, msg *MultipartUploadMsg) (string, error) {
+			return s.model.CreateImage(ctx, msg)
+		})
+	if ok {
+		s.view.RenderSuccessPost(w, r, imgID)
+	}
+}
+
+// NewPendingImage registers the artifact with the metadata only,
+// the artifact file is uploaded later with UploadPendingImage.
+func (s *SoftwareImagesController) NewPendingImage(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	pending, err := s.getSoftwareImagePendingFromBody(r)
+	if err != nil {
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	imgID, err := s.model.CreatePendingImage(ctx, pending)
+	if renderArtifactsLimitError(s.view, w, r, err, l) {
+		return
+	}
+	switch cause := errors.Cause(err); cause {
+	default:
+		s.view.RenderInternalError(w, r, err, l)
+	case ErrModelInvalidMetadata:
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
+	case ErrModelArtifactNotUnique:
+		s.view.RenderError(w, r, cause, http.StatusUnprocessableEntity, l)
+	case nil:
+		s.view.RenderSuccessPostLocation(w, path.Join(r.URL.Path, "..", imgID))
+	}
+}
+
+// UploadPendingImage uploads the artifact file of the pending artifact.
+// Request is the same as for NewImage, description and tags are not changed.
+func (s *SoftwareImagesController) UploadPendingImage(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		s.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	_, ok := s.receiveArtifact(w, r,
+		func(ctx context.Context, msg *MultipartUploadMsg) (string, error) {
+			return id, s.model.UploadPendingImage(ctx, id, msg)
+		})
+	if ok {
+		s.view.RenderSuccessPut(w)
+	}
+}
+
+// ValidateImage checks the artifact sent the same way as NewImage does,
+// but nothing is stored. Responds with the metadata parsed and the problems
+// found; the request is rejected only if the upload itself is wrong.
+func (s *SoftwareImagesController) ValidateImage(w rest.ResponseWriter, r *rest.Request) {
+	var validation *images.ArtifactValidation
+	_, ok := s.receiveArtifact(w, r,
+		func(ctx context.Context, msg *MultipartUploadMsg) (string, error) {
+			var err error
+			validation, err = s.model.ValidateImage(ctx, msg)
+			return "", err
+		})
+	if ok {
+		s.view.RenderSuccessGet(w, r, validation)
+	}
+}
+
+// receiveArtifact parses the multipart artifact upload and passes it to the
+// upload function. Errors are rendered, the response on success is left
+// to the caller.
+// Returns image ID and true on success.
+func (s *SoftwareImagesController) receiveArtifact(w rest.ResponseWriter, r *rest.Request,
+	upload func(ctx context.Context, msg *MultipartUploadMsg) (string, error)) (string, bool) {
+
 	l := log.FromContext(r.Context())
 
+	// reserve the slot before anything is read from the request body;
+	// the slot is held until the handler returns, also when the client
+	// goes away in the middle of the upload
+	var tenant string
+	if id := identity.FromContext(r.Context()); id != nil {
+		tenant = id.Tenant
+	}
+	release, ok := s.uploadLimiter.Acquire(tenant)
+	if !ok {
+		l.F(log.Ctx{"tenant": tenant}).Warn("upload concurrency limit reached")
+		w.Header().Set(HttpHeaderRetryAfter,
+			strconv.Itoa(int(DefaultUploadRetryAfter/time.Second)))
+		s.view.RenderError(w, r, ErrTooManyUploads, http.StatusTooManyRequests, l)
+		return "", false
+	}
+	defer release()
+
+	if r.ContentLength > MaxUploadRequestSize {
+		s.view.RenderError(w, r, ErrUploadRequestTooLarge,
+			http.StatusRequestEntityTooLarge, l)
+		return "", false
+	}
+
 	// parse content type and params according to RFC 1521
 	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
 		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
-		return
+		return "", false
 	}
 
-	mr := multipart.NewReader(r.Body, params["boundary"])
+	// stop reading the body as soon as the client goes away
+	// or the upload times out; the length may be unknown beforehand,
+	// so the limit is enforced while reading too
+	ctx, cancel := withTimeout(r.Context(), s.timeouts.Upload)
+	defer cancel()
+
+	// the artifact file name is known once the multipart form is parsed,
+	// before most of the body is read
+	var fileName string
+	var meter *ThroughputMeter
+	meter = NewThroughputMeter(&contextReader{ctx: ctx, r: r.Body},
+		SlowUploadPeriod, SlowUploadThroughput, func(throughput float64) {
+			l.F(log.Ctx{
+				"name":           fileName,
+				"client_ip":      clientIP(r),
+				"throughput":     int64(throughput),
+				"read":           meter.Size(),
+				"min_throughput": SlowUploadThroughput,
+				"duration":       SlowUploadPeriod.String(),
+			}).Warn("slow artifact upload")
+		})
+	body := &limitedReader{
+		r: meter,
+		n: MaxUploadRequestSize,
+	}
+
+	mr := multipart.NewReader(body, params["boundary"])
 	// parse multipart message
+	_, span := tracing.StartSpan(ctx, "SoftwareImagesController.parseMultipart")
 	multipartUploadMsg, err := s.parseMultipart(mr, DefaultMaxMetaSize)
+	span.SetError(err)
+	span.End()
+	if multipartUploadMsg != nil {
+		fileName = multipartUploadMsg.ArtifactFileName
+	}
+	if r.Context().Err() != nil {
+		l.F(log.Ctx{"error": r.Context().Err().Error()}).
+			Warn("client disconnected, artifact upload aborted")
+		return "", false
+	}
+	if timedOut(ctx, err) {
+		s.view.RenderError(w, r, ErrOperationTimeout, http.StatusGatewayTimeout, l)
+		return "", false
+	}
+	if body.exceeded {
+		s.view.RenderError(w, r, ErrUploadRequestTooLarge,
+			http.StatusRequestEntityTooLarge, l)
+		return "", false
+	}
 	if err != nil {
 		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
-		return
+		return "", false
 	}
 
-	imgID, err := s.model.CreateImage(r.Context(), multipartUploadMsg)
+	imgID, err := upload(ctx, multipartUploadMsg)
+	if err != nil && r.Context().Err() != nil {
+		// nobody is listening for the response anymore
+		l.F(log.Ctx{"error": err.Error()}).
+			Warn("client disconnected, artifact upload aborted")
+		return "", false
+	}
+	if timedOut(ctx, err) {
+		s.view.RenderError(w, r, ErrOperationTimeout, http.StatusGatewayTimeout, l)
+		return "", false
+	}
+	if body.exceeded {
+		s.view.RenderError(w, r, ErrUploadRequestTooLarge,
+			http.StatusRequestEntityTooLarge, l)
+		return "", false
+	}
+	if renderStorageError(s.view, w, r, err, l) ||
+		renderArtifactsLimitError(s.view, w, r, err, l) {
+		return "", false
+	}
 	cause := errors.Cause(err)
 	switch cause {
 	default:
 		s.view.RenderInternalError(w, r, err, l)
 	case nil:
-		s.view.RenderSuccessPost(w, r, imgID)
-	case ErrModelArtifactNotUnique:
+		if s.metrics != nil {
+			s.metrics.ArtifactUploaded(r.Context(), meter.Size(), meter.Elapsed())
+		}
+		return imgID, true
+	case ErrImageMetaNotFound:
+		s.view.RenderErrorNotFound(w, r, l)
+	case ErrModelImageNotPending:
+		s.view.RenderError(w, r, cause, http.StatusConflict, l)
+	case ErrModelArtifactNotUnique, ErrModelImageQuarantined:
 		l.Error(err.Error())
 		s.view.RenderError(w, r, cause, http.StatusUnprocessableEntity, l)
+	case ErrModelInvalidMetadata:
+		// the details tell which field is wrong
+		l.Error(err.Error())
+		s.view.RenderError(w, r, err, http.StatusBadRequest, l)
 	case ErrModelMissingInputMetadata, ErrModelMissingInputArtifact,
-		ErrModelInvalidMetadata, ErrModelMultipartUploadMsgMalformed,
-		ErrModelArtifactFileTooLarge, ErrModelParsingArtifactFailed:
+		ErrModelMultipartUploadMsgMalformed,
+		ErrModelArtifactFileTooLarge, ErrModelParsingArtifactFailed,
+		ErrModelArtifactNotSigned, ErrModelArtifactSignatureInvalid,
+		ErrModelDeltaNameMismatch, ErrModelUploadSizeMismatch,
+		ErrModelPendingNameMismatch:
 		l.Error(err.Error())
 		s.view.RenderError(w, r, cause, http.StatusBadRequest, l)
 	}
 
-	return
+	return "", false
+}
+
+// renderStorageError renders the file storage failures, along with the codes
+// the clients and the operators can react to. Misconfiguration of the storage
+// is an internal error, throttled request can be retried, storing the uploaded
+// file taking too long is a gateway timeout.
+// Returns false if the error is not one of them.
+func renderStorageError(view RESTView, w rest.ResponseWriter, r *rest.Request,
+	err error, l *log.Logger) bool {
+
+	switch cause := errors.Cause(err); cause {
+	case ErrModelArtifactFileMissing:
+		view.RenderErrorWithCode(w, r, cause, http.StatusNotFound, ErrCodeArtifactFileMissing, l)
+	case ErrModelStorageAccessDenied:
+		l.Error(err.Error())
+		view.RenderErrorWithCode(w, r, cause, http.StatusInternalServerError,
+			ErrCodeStorageAccessDenied, l)
+	case ErrModelStorageMisconfigured:
+		l.Error(err.Error())
+		view.RenderErrorWithCode(w, r, cause, http.StatusInternalServerError,
+			ErrCodeStorageMisconfigured, l)
+	case ErrModelStorageThrottled:
+		l.Error(err.Error())
+		w.Header().Set(HttpHeaderRetryAfter,
+			strconv.Itoa(int(DefaultStorageRetryAfter/time.Second)))
+		view.RenderErrorWithCode(w, r, cause, http.StatusServiceUnavailable,
+			ErrCodeStorageThrottled, l)
+	case ErrModelStorageUploadTimeout:
+		l.Error(err.Error())
+		view.RenderErrorWithCode(w, r, cause, http.StatusGatewayTimeout,
+			ErrCodeStorageUploadTimeout, l)
+	default:
+		return false
+	}
+	return true
+}
+
+// renderArtifactsLimitError renders 403 when the tenant already has
+// the maximum number of artifacts, the message tells the counts.
+// Returns false if the error is not the limit one.
+func renderArtifactsLimitError(view RESTView, w rest.ResponseWriter, r *rest.Request,
+	err error, l *log.Logger) bool {
+
+	limitErr, ok := errors.Cause(err).(*ArtifactsLimitError)
+	if !ok {
+		return false
+	}
+	view.RenderErrorWithCode(w, r, limitErr, http.StatusForbidden, ErrCodeArtifactsLimit, l)
+	return true
+}
+
+// contextReader fails reading once the context is done, so that processing
+// of the request body is abandoned when the client disconnects.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// limitedReader fails the reads beyond n bytes, like http.MaxBytesReader
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, ErrUploadRequestTooLarge
+	}
+	// read one byte more, to tell the limit is exceeded
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) <= l.n {
+		l.n -= int64(n)
+		return n, err
+	}
+	n = int(l.n)
+	l.n = 0
+	l.exceeded = true
+	return n, ErrUploadRequestTooLarge
 }
 
 // parseMultipart parses multipart/form-data message.
@@ -250,7 +1543,8 @@ func (s *SoftwareImagesController) parseMultipart(mr *multipart.Reader, maxMetaS
 		if err != nil {
 			return nil, errors.Wrap(err, "Request does not contain artifact")
 		}
-		switch p.FormName() {
+		field := canonicalFormName(p.FormName())
+		switch field {
 		case "size":
 			size, err := s.getFormFieldValue(p, maxMetaSize)
 			if err != nil {
@@ -266,11 +1560,48 @@ func (s *SoftwareImagesController) parseMultipart(mr *multipart.Reader, maxMetaS
 				return nil, err
 			}
 			multipartUploadMsg.MetaConstructor.Description = *desc
+		case "tags":
+			tag, err := s.getFormFieldValue(p, maxMetaSize)
+			if err != nil {
+				return nil, err
+			}
+			multipartUploadMsg.MetaConstructor.Tags = append(
+				multipartUploadMsg.MetaConstructor.Tags, *tag)
+		case "expires_at":
+			value, err := s.getFormFieldValue(p, maxMetaSize)
+			if err != nil {
+				return nil, err
+			}
+			expiresAt, err := time.Parse(time.RFC3339, *value)
+			if err != nil {
+				return nil, ErrInvalidExpiresAt
+			}
+			multipartUploadMsg.MetaConstructor.ExpiresAt = &expiresAt
+		case "delta_from", "delta_to":
+			name, err := s.getFormFieldValue(p, maxMetaSize)
+			if err != nil {
+				return nil, err
+			}
+			if multipartUploadMsg.Delta == nil {
+				multipartUploadMsg.Delta = &images.DeltaUpdate{}
+			}
+			if field == "delta_from" {
+				multipartUploadMsg.Delta.From = *name
+			} else {
+				multipartUploadMsg.Delta.To = *name
+			}
 		case "artifact":
-			// valide metadata provided by the user and the image size
-			if err := multipartUploadMsg.MetaConstructor.Validate(); err != nil {
+			// valide metadata provided by the user and the image size;
+			// the required fields are checked by the model, as the metadata
+			// of the pending artifacts is given on registration
+			if err := multipartUploadMsg.MetaConstructor.ValidateFields(); err != nil {
 				return nil, err
 			}
+			if multipartUploadMsg.Delta != nil {
+				if err := multipartUploadMsg.Delta.Validate(); err != nil {
+					return nil, err
+				}
+			}
 			// artifact size part should be provided before artifact part
 			// artifact size value should be greater then 0
 			if multipartUploadMsg.ArtifactSize <= 0 {
@@ -280,18 +1611,65 @@ func (s *SoftwareImagesController) parseMultipart(mr *multipart.Reader, maxMetaS
 			if p.Header.Get("Content-Type") == "" {
 				return nil, errors.New("The last part of the multipart/form-data message should be an artifact.")
 			}
+			if !artifactContentTypeAllowed(p.Header.Get("Content-Type")) {
+				return nil, ErrArtifactContentTypeNotAllowed
+			}
 			multipartUploadMsg.ArtifactReader = p
+			multipartUploadMsg.ArtifactFileName = p.FileName()
 			return multipartUploadMsg, nil
+		default:
+			// custom metadata, one part per key; other parts are ignored
+			// unless rejected
+			if !strings.HasPrefix(field, MetadataFormPrefix) {
+				if RejectUnknownFormFields {
+					return nil, errors.Errorf("Unknown form field: %s", p.FormName())
+				}
+				break
+			}
+			value, err := s.getFormFieldValue(p, maxMetaSize)
+			if err != nil {
+				return nil, err
+			}
+			if multipartUploadMsg.MetaConstructor.Metadata == nil {
+				multipartUploadMsg.MetaConstructor.Metadata = make(map[string]string)
+			}
+			key := strings.TrimPrefix(field, MetadataFormPrefix)
+			multipartUploadMsg.MetaConstructor.Metadata[key] = *value
 		}
 	}
 }
 
+// artifactContentTypeAllowed checks the media type of the artifact part
+// against AllowedArtifactContentTypes, parameters are ignored.
+func artifactContentTypeAllowed(contentType string) bool {
+	if len(AllowedArtifactContentTypes) == 0 {
+		return true
+	}
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range AllowedArtifactContentTypes {
+		if strings.EqualFold(allowed, mediatype) {
+			return true
+		}
+	}
+	return false
+}
+
+// getFormFieldValue reads the value of the form field, failing the values
+// over maxMetaSize bytes instead of cutting them off.
 func (s *SoftwareImagesController) getFormFieldValue(p *multipart.Part, maxMetaSize int64) (*string, error) {
-	metaReader := io.LimitReader(p, maxMetaSize)
+	// read one byte more, to tell the limit is exceeded
+	metaReader := io.LimitReader(p, maxMetaSize+1)
 	bytes, err := ioutil.ReadAll(metaReader)
 	if err != nil && err != io.EOF {
 		return nil, errors.Wrap(err, "Failed to obtain value for "+p.FormName())
 	}
+	if int64(len(bytes)) > maxMetaSize {
+		return nil, errors.Errorf("Form field %s too large: expected at most %d bytes",
+			p.FormName(), maxMetaSize)
+	}
 
 	strValue := string(bytes)
 	return &strValue, nil
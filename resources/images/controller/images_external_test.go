@@ -16,6 +16,9 @@ package controller_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
@@ -24,15 +27,19 @@ import (
 	"net/http"
 	"net/textproto"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/mendersoftware/go-lib-micro/identity"
 	"github.com/mendersoftware/go-lib-micro/requestid"
 	"github.com/mendersoftware/go-lib-micro/requestlog"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -41,6 +48,7 @@ import (
 	. "github.com/mendersoftware/deployments/resources/images/controller"
 	"github.com/mendersoftware/deployments/resources/images/controller/mocks"
 	"github.com/mendersoftware/deployments/utils/pointers"
+	"github.com/mendersoftware/deployments/utils/restutil"
 	"github.com/mendersoftware/deployments/utils/restutil/view"
 	h "github.com/mendersoftware/deployments/utils/testing"
 )
@@ -76,7 +84,7 @@ func setUpRestTest(route string, routeType routerTypeHandler, handler func(w res
 
 func TestControllerGetImage(t *testing.T) {
 	imagesModel := &mocks.ImagesModel{}
-	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView))
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
 
 	api := setUpRestTest("/api/0.0.1/images/:id", rest.Get, controller.GetImage)
 
@@ -119,9 +127,166 @@ func TestControllerGetImage(t *testing.T) {
 	}
 }
 
+func TestControllerImagesTimesUTC(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	// decoded by the storage driver in the local time zone
+	created := time.Date(2018, 3, 10, 11, 12, 1, 422000000, time.FixedZone("CEST", 2*60*60))
+	modified := time.Date(2018, 3, 10, 4, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+	image := images.NewSoftwareImage(validUUIDv4, images.NewSoftwareImageMetaConstructor(),
+		images.NewSoftwareImageMetaArtifactConstructor())
+	image.Created = &created
+	image.Modified = &modified
+
+	imagesModel.On("GetImage", h.ContextMatcher(), validUUIDv4).Return(image, nil)
+	imagesModel.On("ListImages", h.ContextMatcher(), mock.Anything).
+		Return([]*images.SoftwareImage{image}, nil)
+
+	type times struct {
+		Created  string `json:"created"`
+		Modified string `json:"modified"`
+	}
+	expected := times{
+		Created:  "2018-03-10T09:12:01.422Z",
+		Modified: "2018-03-10T09:30:00Z",
+	}
+
+	api := setUpRestTest("/api/0.0.1/images/:id", rest.Get, controller.GetImage)
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/images/"+validUUIDv4, nil))
+	recorded.CodeIs(http.StatusOK)
+	var received times
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Equal(t, expected, received)
+
+	api = setUpRestTest("/api/0.0.1/images", rest.Get, controller.ListImages)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/images", nil))
+	recorded.CodeIs(http.StatusOK)
+	var list []times
+	assert.NoError(t, recorded.DecodeJsonPayload(&list))
+	assert.Equal(t, []times{expected}, list)
+
+	// the model's image is left intact
+	assert.Equal(t, "CEST", image.Created.Location().String())
+}
+
+func TestControllerOperationTimeout(t *testing.T) {
+	id := uuid.NewV4().String()
+
+	// model calls block until the operation deadline passes
+	waitForDeadline := func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}
+
+	imagesModel := &mocks.ImagesModel{}
+	imagesModel.On("GetImage", h.ContextMatcher(), id).
+		Run(waitForDeadline).Return(nil, context.DeadlineExceeded)
+	imagesModel.On("ListImages", h.ContextMatcher(), mock.AnythingOfType("*images.ImagesFilter")).
+		Run(waitForDeadline).Return(nil, context.DeadlineExceeded)
+	imagesModel.On("DownloadLink", h.ContextMatcher(), id, DefaultDownloadLinkExpire, "").
+		Run(waitForDeadline).Return(nil, context.DeadlineExceeded)
+	imagesModel.On("DeleteImage", h.ContextMatcher(), id).
+		Run(waitForDeadline).Return(context.DeadlineExceeded)
+
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	testCases := []struct {
+		method    string
+		routeType routerTypeHandler
+		path      string
+		handler   rest.HandlerFunc
+		url       string
+	}{
+		{"GET", rest.Get, "/api/0.0.1/artifacts/:id", controller.GetImage,
+			"http://localhost/api/0.0.1/artifacts/" + id},
+		{"GET", rest.Get, "/api/0.0.1/artifacts", controller.ListImages,
+			"http://localhost/api/0.0.1/artifacts?tag=foo"},
+		{"GET", rest.Get, "/api/0.0.1/artifacts/:id/download", controller.DownloadLink,
+			"http://localhost/api/0.0.1/artifacts/" + id + "/download"},
+		{"DELETE", rest.Delete, "/api/0.0.1/artifacts/:id", controller.DeleteImage,
+			"http://localhost/api/0.0.1/artifacts/" + id},
+	}
+
+	for _, tc := range testCases {
+		routes := restutil.WithOperationTimeout(10*time.Millisecond, nil,
+			[]*rest.Route{tc.routeType(tc.path, tc.handler)})
+		api := setUpRestTest(tc.path, tc.routeType, routes[0].Func)
+
+		req := test.MakeSimpleRequest(tc.method, tc.url, nil)
+		req.Header.Add(requestid.RequestIdHeader, "test")
+		recorded := test.RunRequest(t, api.MakeHandler(), req)
+		h.CheckRecordedResponse(t, recorded, h.JSONResponseParams{
+			OutputStatus:     http.StatusGatewayTimeout,
+			OutputBodyObject: h.ErrorToErrStruct(ErrOperationTimeout),
+		})
+	}
+}
+
+func TestControllerDownloadLinkStorageError(t *testing.T) {
+	testCases := map[string]struct {
+		err        error
+		status     int
+		code       string
+		retryAfter string
+	}{
+		"file missing": {
+			err:    ErrModelArtifactFileMissing,
+			status: http.StatusNotFound,
+			code:   ErrCodeArtifactFileMissing,
+		},
+		"access denied": {
+			err:    pkgerrors.Wrap(ErrModelStorageAccessDenied, "AccessDenied"),
+			status: http.StatusInternalServerError,
+			code:   ErrCodeStorageAccessDenied,
+		},
+		"misconfigured": {
+			err:    pkgerrors.Wrap(ErrModelStorageMisconfigured, "NoSuchBucket"),
+			status: http.StatusInternalServerError,
+			code:   ErrCodeStorageMisconfigured,
+		},
+		"throttled": {
+			err:        pkgerrors.Wrap(ErrModelStorageThrottled, "SlowDown"),
+			status:     http.StatusServiceUnavailable,
+			code:       ErrCodeStorageThrottled,
+			retryAfter: "5",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			id := uuid.NewV4().String()
+
+			imagesModel := &mocks.ImagesModel{}
+			imagesModel.On("DownloadLink", h.ContextMatcher(), id, DefaultDownloadLinkExpire, "").
+				Return(nil, tc.err)
+			controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+			api := setUpRestTest("/api/0.0.1/artifacts/:id/download", rest.Get,
+				controller.DownloadLink)
+			req := test.MakeSimpleRequest("GET",
+				"http://localhost/api/0.0.1/artifacts/"+id+"/download", nil)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			recorded.CodeIs(tc.status)
+			recorded.HeaderIs(HttpHeaderRetryAfter, tc.retryAfter)
+
+			var body map[string]string
+			assert.NoError(t, recorded.DecodeJsonPayload(&body))
+			assert.Equal(t, map[string]string{
+				"error":      pkgerrors.Cause(tc.err).Error(),
+				"code":       tc.code,
+				"request_id": "test",
+			}, body)
+		})
+	}
+}
+
 func TestControllerListImages(t *testing.T) {
 	imagesModel := &mocks.ImagesModel{}
-	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView))
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
 
 	api := setUpRestTest("/api/0.0.1/images", rest.Get, controller.ListImages)
 
@@ -134,22 +299,414 @@ func TestControllerListImages(t *testing.T) {
 
 	//getting list OK
 	imagesModel = &mocks.ImagesModel{}
-	controller = NewSoftwareImagesController(imagesModel, new(view.RESTView))
+	controller = NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
 	api = setUpRestTest("/api/0.0.1/images", rest.Get, controller.ListImages)
 	imageMeta := images.NewSoftwareImageMetaConstructor()
 	imageMetaArtifact := images.NewSoftwareImageMetaArtifactConstructor()
 	constructorImage := images.NewSoftwareImage(validUUIDv4, imageMeta, imageMetaArtifact)
-	imagesModel.On("ListImages", h.ContextMatcher(), mock.Anything).
+	imagesModel.On("ListImages", h.ContextMatcher(), &images.ImagesFilter{}).
 		Return([]*images.SoftwareImage{constructorImage}, nil)
 	recorded = test.RunRequest(t, api.MakeHandler(),
 		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/images", nil))
 	recorded.CodeIs(http.StatusOK)
 	recorded.ContentTypeIsJson()
+
+	//filtered by tags
+	imagesModel.On("ListImages", h.ContextMatcher(),
+		&images.ImagesFilter{Tags: []string{"stable", "customer-x"}}).
+		Return([]*images.SoftwareImage{constructorImage}, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?tag=stable&tag=customer-x", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	//invalid tag
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?tag=%24ne", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	//cursor pagination, one more image tells there is the next page
+	nextImage := images.NewSoftwareImage(
+		"f2a1b5a4-3c1b-4a5e-8e5f-6a7b8c9d0e1f", imageMeta, imageMetaArtifact)
+	imagesModel.On("ListImages", h.ContextMatcher(),
+		&images.ImagesFilter{Limit: 2, After: "0e5bb27b-5c24-4b4c-8b2b-6d5f3c1c2c8a"}).
+		Return([]*images.SoftwareImage{constructorImage, nextImage}, nil)
+	cursor := (&restutil.Cursor{ID: "0e5bb27b-5c24-4b4c-8b2b-6d5f3c1c2c8a"}).Encode()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?per_page=1&cursor="+cursor, nil))
+	recorded.CodeIs(http.StatusOK)
+	var listed []images.SoftwareImage
+	assert.NoError(t, recorded.DecodeJsonPayload(&listed))
+	assert.Len(t, listed, 1)
+	assert.Equal(t, []string{"<http://localhost/api/0.0.1/images?cursor=" +
+		(&restutil.Cursor{ID: validUUIDv4}).Encode() + "&per_page=1>; rel=\"next\""},
+		recorded.Recorder.HeaderMap["Link"])
+
+	//invalid cursor
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?cursor=foo", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	//cursor pagination can't be sorted
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?cursor=&sort=size:asc", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	//filtered by custom metadata
+	imagesModel.On("ListImages", h.ContextMatcher(),
+		&images.ImagesFilter{Metadata: map[string]string{
+			"git_sha":   "abc",
+			"build_url": "https://ci/42",
+		}}).
+		Return([]*images.SoftwareImage{constructorImage}, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?meta.git_sha=abc&meta.build_url=https://ci/42", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	//invalid metadata key
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?meta.%24where=1", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	//filtered by device type and size, biggest first
+	imagesModel.On("ListImages", h.ContextMatcher(),
+		&images.ImagesFilter{
+			DeviceType: "beaglebone",
+			MinSize:    2147483648,
+			MaxSize:    4294967296,
+			Sort:       images.SortBySizeDesc,
+		}).
+		Return([]*images.SoftwareImage{constructorImage}, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?device_type=beaglebone"+
+				"&min_size=2147483648&max_size=4294967296&sort=size:desc", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	//filtered by checksum, normalized
+	imagesModel.On("ListImages", h.ContextMatcher(),
+		&images.ImagesFilter{
+			Checksum: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		}).
+		Return([]*images.SoftwareImage{constructorImage}, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?checksum="+
+				"E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	//invalid checksum
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?checksum=abc", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	//latest of each device type having the tag
+	imagesModel.On("ListImages", h.ContextMatcher(),
+		&images.ImagesFilter{
+			Tags:                []string{"stable"},
+			LatestPerDeviceType: true,
+		}).
+		Return([]*images.SoftwareImage{constructorImage}, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?tag=stable&latest_per_device_type=true", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	//selected fields
+	imagesModel.On("ListImages", h.ContextMatcher(),
+		&images.ImagesFilter{Fields: []string{"name", "size", "tags"}}).
+		Return([]*images.SoftwareImage{constructorImage}, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?fields=name,size&fields=tags", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	//default fields
+	imagesModel.On("ListImages", h.ContextMatcher(),
+		&images.ImagesFilter{Fields: images.DefaultListFields}).
+		Return([]*images.SoftwareImage{constructorImage}, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images?fields=", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	//invalid size range, sort order, latest flag and fields
+	for _, query := range []string{
+		"min_size=big",
+		"max_size=-1",
+		"min_size=2048&max_size=1024",
+		"sort=name:asc",
+		"latest_per_device_type=yes",
+		"fields=name,object_key",
+	} {
+		recorded = test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("GET",
+				"http://localhost/api/0.0.1/images?"+query, nil))
+		recorded.CodeIs(http.StatusBadRequest)
+	}
+
+	//getting list as XML
+	req := test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/images", nil)
+	req.Header.Set("Accept", "application/xml")
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusOK)
+	recorded.HeaderIs("Content-Type", "application/xml; charset=utf-8")
+
+	var receivedImages struct {
+		Images []images.SoftwareImage `xml:"artifact"`
+	}
+	assert.NoError(t, xml.Unmarshal(recorded.Recorder.Body.Bytes(), &receivedImages))
+	assert.Len(t, receivedImages.Images, 1)
+	assert.Equal(t, validUUIDv4, receivedImages.Images[0].Id)
+
+	//unsupported media type requested
+	req = test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/images", nil)
+	req.Header.Set("Accept", "text/html")
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusNotAcceptable)
+}
+
+func TestControllerListImagesEmpty(t *testing.T) {
+	defer func() {
+		EmptyListStatus = http.StatusOK
+	}()
+
+	imagesModel := &mocks.ImagesModel{}
+	imagesModel.On("ListImages", h.ContextMatcher(), mock.Anything).
+		Return([]*images.SoftwareImage{}, nil)
+	imagesModel.On("GetImage", h.ContextMatcher(), validUUIDv4).
+		Return(nil, nil)
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/artifacts", rest.Get, controller.ListImages)
+	getAPI := setUpRestTest("/api/0.0.1/artifacts/:id", rest.Get, controller.GetImage)
+
+	testCases := map[int]string{
+		http.StatusOK:        `[]`,
+		http.StatusNoContent: ``,
+		http.StatusNotFound: `{"code":"` + ErrCodeNoMatches + `","error":"` +
+			ErrNoArtifactsMatch.Error() + `","request_id":"test"}`,
+	}
+	for status, body := range testCases {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			EmptyListStatus = status
+
+			// no matches
+			req := test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/artifacts?tag=x", nil)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+			recorded.CodeIs(status)
+			recorded.BodyIs(body)
+
+			// artifact not found, regardless of the setting
+			req = test.MakeSimpleRequest("GET",
+				"http://localhost/api/0.0.1/artifacts/"+validUUIDv4, nil)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded = test.RunRequest(t, getAPI.MakeHandler(), req)
+			recorded.CodeIs(http.StatusNotFound)
+			recorded.BodyIs(`{"error":"Resource not found","request_id":"test"}`)
+		})
+	}
+}
+
+func TestControllerGetImages(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/lookup", rest.Post, controller.GetImages)
+	url := "http://localhost/api/0.0.1/images/lookup"
+
+	ids := []string{validUUIDv4, "0c13a0e6-6b63-475d-8260-ee42a590e8ff"}
+
+	//no payload
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url, nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	//invalid IDs
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url,
+			map[string]interface{}{"ids": []string{validUUIDv4, "foo", validUUIDv4}}))
+	recorded.CodeIs(http.StatusBadRequest)
+	assert.Contains(t, recorded.Recorder.Body.String(), "IDs not UUIDv4: foo")
+	assert.Contains(t, recorded.Recorder.Body.String(), "duplicated IDs: "+validUUIDv4)
+
+	//empty list
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url,
+			map[string]interface{}{"ids": []string{}}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	//model error
+	imagesModel.On("GetImages", h.ContextMatcher(), ids).
+		Return(nil, errors.New("error")).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url, map[string]interface{}{"ids": ids}))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	//OK
+	image := images.NewSoftwareImage(validUUIDv4, images.NewSoftwareImageMetaConstructor(),
+		images.NewSoftwareImageMetaArtifactConstructor())
+	imagesModel.On("GetImages", h.ContextMatcher(), ids).
+		Return(&images.ImagesLookup{
+			Artifacts: []*images.SoftwareImage{image},
+			Missing:   []string{ids[1]},
+		}, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url, map[string]interface{}{"ids": ids}))
+	recorded.CodeIs(http.StatusOK)
+	recorded.ContentTypeIsJson()
+
+	var received struct {
+		Artifacts []images.SoftwareImage `json:"artifacts"`
+		Missing   []string               `json:"missing"`
+	}
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Len(t, received.Artifacts, 1)
+	assert.Equal(t, validUUIDv4, received.Artifacts[0].Id)
+	assert.Equal(t, []string{ids[1]}, received.Missing)
+}
+
+func TestControllerListDeviceTypes(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/device_types", rest.Get, controller.ListDeviceTypes)
+
+	//getting list error
+	imagesModel.On("ListDeviceTypes", h.ContextMatcher()).
+		Return(nil, errors.New("error")).Once()
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/images/device_types", nil))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	//getting list OK
+	deviceTypes := []*images.DeviceTypeCount{
+		{DeviceType: "beaglebone", Count: 2},
+		{DeviceType: "qemu", Count: 1},
+	}
+	imagesModel.On("ListDeviceTypes", h.ContextMatcher()).
+		Return(deviceTypes, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/images/device_types", nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.ContentTypeIsJson()
+
+	var received []images.DeviceTypeCount
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Len(t, received, 2)
+	assert.Equal(t, "beaglebone", received[0].DeviceType)
+	assert.Equal(t, 2, received[0].Count)
+}
+
+func TestControllerListImageChanges(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/changes", rest.Get, controller.ListImageChanges)
+
+	// invalid parameters
+	for _, params := range []string{"since=yesterday", "limit=0", "limit=101", "limit=x"} {
+		recorded := test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/images/changes?"+params, nil))
+		recorded.CodeIs(http.StatusBadRequest)
+	}
+
+	// default limit, most recent changes
+	imagesModel.On("ListImageChanges", h.ContextMatcher(),
+		&images.ImageChangesQuery{Limit: images.DefaultImageChangesLimit}).
+		Return(nil, errors.New("error")).Once()
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/images/changes", nil))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// changes since
+	since := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	changes := []*images.ImageChange{
+		{Id: "1", Name: "foo", Change: images.ImageChangeDeleted, Timestamp: since.Add(time.Minute)},
+	}
+	imagesModel.On("ListImageChanges", h.ContextMatcher(),
+		&images.ImageChangesQuery{Since: &since, Limit: 5}).
+		Return(changes, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/images/changes?since=2018-01-02T03:04:05Z&limit=5", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	var received []images.ImageChange
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Len(t, received, 1)
+	assert.Equal(t, images.ImageChangeDeleted, received[0].Change)
+	assert.True(t, since.Add(time.Minute).Equal(received[0].Timestamp))
+
+	imagesModel.AssertExpectations(t)
+}
+
+func TestControllerListInstallableImages(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/artifacts/installable", rest.Post,
+		controller.ListInstallableImages)
+
+	// invalid bodies
+	for body, expected := range map[string]string{
+		`{"provides": `: "Malformed request body: unexpected end of JSON input",
+		`[]`:            "Malformed request body: JSON object expected",
+		`{}`:            images.ErrProvidesDeviceTypeMissing.Error(),
+		`{"provides": {"artifact_name": "release-1"}}`: images.ErrProvidesDeviceTypeMissing.Error(),
+	} {
+		req := test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/installable", nil)
+		req.Body = ioutil.NopCloser(strings.NewReader(body))
+		req.Header.Add(requestid.RequestIdHeader, "test")
+		recorded := test.RunRequest(t, api.MakeHandler(), req)
+		recorded.CodeIs(http.StatusBadRequest)
+		recorded.BodyIs(`{"error":"` + expected + `","request_id":"test"}`)
+	}
+
+	provides := images.Provides{
+		images.ProvidesArtifactName: "release-1",
+		images.ProvidesDeviceType:   "beaglebone",
+	}
+	body := map[string]interface{}{"provides": provides}
+
+	// model error
+	imagesModel.On("ListInstallableImages", h.ContextMatcher(), provides).
+		Return(nil, errors.New("error")).Once()
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/installable", body))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// steps
+	steps := []*images.UpdateStep{
+		{Artifact: &images.SoftwareImage{Id: "full"}, Path: []string{}},
+		{Artifact: &images.SoftwareImage{Id: "delta"}, Path: []string{"full"}},
+	}
+	imagesModel.On("ListInstallableImages", h.ContextMatcher(), provides).
+		Return(steps, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/installable", body))
+	recorded.CodeIs(http.StatusOK)
+
+	var received []images.UpdateStep
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Len(t, received, 2)
+	assert.Equal(t, "delta", received[1].Artifact.Id)
+	assert.Equal(t, []string{"full"}, received[1].Path)
+
+	imagesModel.AssertExpectations(t)
 }
 
 func TestControllerDeleteImage(t *testing.T) {
 	imagesModel := &mocks.ImagesModel{}
-	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView))
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
 
 	api := setUpRestTest("/api/0.0.1/images/:id", rest.Delete, controller.DeleteImage)
 
@@ -182,9 +739,135 @@ func TestControllerDeleteImage(t *testing.T) {
 	recorded.BodyIs("")
 }
 
+func TestControllerDeleteDeviceTypeImages(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/artifacts", rest.Delete, controller.DeleteDeviceTypeImages)
+
+	// invalid parameters
+	for params, expected := range map[string]error{
+		"confirm=true":                           ErrDeviceTypeRequired,
+		"device_type=%20&confirm=true":           ErrDeviceTypeRequired,
+		"device_type=rpi":                        ErrDeleteNotConfirmed,
+		"device_type=rpi&confirm=false":          ErrDeleteNotConfirmed,
+		"device_type=rpi&confirm=yes":            ErrDeleteNotConfirmed,
+		"device_type=rpi&confirm=true&force=yes": ErrInvalidForceParam,
+	} {
+		req := test.MakeSimpleRequest("DELETE", "http://localhost/api/0.0.1/artifacts?"+params, nil)
+		req.Header.Add(requestid.RequestIdHeader, "test")
+		recorded := test.RunRequest(t, api.MakeHandler(), req)
+		recorded.CodeIs(http.StatusBadRequest)
+		recorded.BodyIs(`{"error":"` + expected.Error() + `","request_id":"test"}`)
+	}
+
+	// model error
+	imagesModel.On("DeleteDeviceTypeImages", h.ContextMatcher(), "rpi", false).
+		Return(nil, errors.New("error")).Once()
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("DELETE",
+			"http://localhost/api/0.0.1/artifacts?device_type=RPi&confirm=true", nil))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// deleted, forced
+	report := &images.DeleteReport{
+		Deleted:            2,
+		Skipped:            1,
+		InUse:              []string{"a108ae14-bb4e-455f-9b40-2ef4bab97bb7"},
+		AbortedDeployments: 3,
+	}
+	imagesModel.On("DeleteDeviceTypeImages", h.ContextMatcher(), "rpi", true).
+		Return(report, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("DELETE",
+			"http://localhost/api/0.0.1/artifacts?device_type=rpi&confirm=1&force=true", nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs(`{"deleted":2,"skipped":1,` +
+		`"in_use":["a108ae14-bb4e-455f-9b40-2ef4bab97bb7"],"aborted_deployments":3}`)
+
+	imagesModel.AssertExpectations(t)
+}
+
+func TestControllerRotateDownloadLinks(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/:id/download/rotate", rest.Post, controller.RotateDownloadLinks)
+
+	// wrong id
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/images/wrong_id/download/rotate", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	testCases := []struct {
+		modelErr error
+		code     int
+	}{
+		{modelErr: nil, code: http.StatusNoContent},
+		{modelErr: ErrImageMetaNotFound, code: http.StatusNotFound},
+		{modelErr: ErrModelLinkRotationNotSupported, code: http.StatusNotImplemented},
+		{modelErr: errors.New("error"), code: http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		id := uuid.NewV4().String()
+		imagesModel.On("RotateDownloadLinks", h.ContextMatcher(), id).Return(tc.modelErr)
+
+		recorded = test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/images/"+id+"/download/rotate", nil))
+		recorded.CodeIs(tc.code)
+	}
+}
+
+func TestControllerApproveImage(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/:id/approve", rest.Post, controller.ApproveImage)
+
+	makeRequest := func(id, claims string) *http.Request {
+		req := test.MakeSimpleRequest("POST",
+			"http://localhost/api/0.0.1/images/"+id+"/approve", nil)
+		req.Header.Set("Authorization", "Bearer foo."+
+			base64.RawURLEncoding.EncodeToString([]byte(claims))+".bar")
+		return req
+	}
+	reviewer := `{"sub": "user-1", "mender.roles": ["admin", "artifact_reviewer"]}`
+
+	// no role
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		makeRequest(uuid.NewV4().String(), `{"sub": "user-1", "mender.roles": ["admin"]}`))
+	recorded.CodeIs(http.StatusForbidden)
+
+	// wrong id
+	recorded = test.RunRequest(t, api.MakeHandler(), makeRequest("wrong_id", reviewer))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	testCases := []struct {
+		modelErr error
+		code     int
+	}{
+		{modelErr: nil, code: http.StatusNoContent},
+		{modelErr: ErrImageMetaNotFound, code: http.StatusNotFound},
+		{modelErr: ErrModelImagePending, code: http.StatusConflict},
+		{modelErr: ErrModelImageQuarantined, code: http.StatusConflict},
+		{modelErr: ErrModelInvalidStateTransition, code: http.StatusConflict},
+		{modelErr: errors.New("error"), code: http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		id := uuid.NewV4().String()
+		imagesModel.On("SetImageState", h.ContextMatcher(), id, images.ImageStateApproved).
+			Return(tc.modelErr)
+
+		recorded = test.RunRequest(t, api.MakeHandler(), makeRequest(id, reviewer))
+		recorded.CodeIs(tc.code)
+	}
+}
+
 func TestControllerEditImage(t *testing.T) {
 	imagesModel := &mocks.ImagesModel{}
-	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView))
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
 
 	api := setUpRestTest("/api/0.0.1/images/:id", rest.Put, controller.EditImage)
 
@@ -230,12 +913,914 @@ func TestControllerEditImage(t *testing.T) {
 	recorded.BodyIs("")
 }
 
-func TestSoftwareImagesControllerNewImage(t *testing.T) {
-	t.Parallel()
+func TestControllerEditImageMalformedBody(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
 
-	file := createValidImageFile()
-	imageBody, err := ioutil.ReadAll(file)
-	assert.NoError(t, err)
+	handlers := map[string]rest.HandlerFunc{
+		"PUT":   controller.EditImage,
+		"PATCH": controller.PatchImage,
+	}
+
+	testCases := []struct {
+		body  string
+		error string
+	}{
+		{
+			body:  `{"description": "fo`,
+			error: "Malformed request body: unexpected end of JSON input",
+		},
+		{
+			body:  `["foo"]`,
+			error: "Malformed request body: JSON object expected",
+		},
+		{
+			body:  `"foo"`,
+			error: "Malformed request body: JSON object expected",
+		},
+	}
+
+	id := uuid.NewV4().String()
+	for method, handler := range handlers {
+		var routeType routerTypeHandler = rest.Put
+		if method == "PATCH" {
+			routeType = rest.Patch
+		}
+		api := setUpRestTest("/api/0.0.1/images/:id", routeType, handler)
+
+		for _, tc := range testCases {
+			req := test.MakeSimpleRequest(method, "http://localhost/api/0.0.1/images/"+id, nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(tc.body))
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+			recorded.CodeIs(http.StatusBadRequest)
+			recorded.BodyIs(`{"error":"` + tc.error + `","request_id":"test"}`)
+		}
+	}
+
+	imagesModel.AssertExpectations(t)
+}
+
+func TestControllerPatchImage(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/:id", rest.Patch, controller.PatchImage)
+
+	// wrong id
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/wrong_id", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// correct id; no payload
+	id := uuid.NewV4().String()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id, nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// immutable field
+	req := test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+		map[string]string{"description": "foo", "name": "myImage"})
+	req.Header.Add(requestid.RequestIdHeader, "test")
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusBadRequest)
+	recorded.BodyIs(`{"error":"Validating request body: Field 'name' can not be modified","request_id":"test"}`)
+
+	// invalid field type
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string]int{"description": 1}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	description := "foo"
+	patch := &images.SoftwareImageMetaPatch{Description: &description}
+
+	// patch error
+	imagesModel.On("PatchImage", h.ContextMatcher(), id, patch).
+		Return(false, errors.New("error")).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string]string{"description": "foo"}))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// patched metadata invalid, e.g. required field cleared
+	imagesModel.On("PatchImage", h.ContextMatcher(), id, patch).
+		Return(false, pkgerrors.Wrap(ErrModelInvalidMetadata, "description: required")).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string]string{"description": "foo"}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// no image
+	imagesModel.On("PatchImage", h.ContextMatcher(), id, patch).
+		Return(false, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string]string{"description": "foo"}))
+	recorded.CodeIs(http.StatusNotFound)
+
+	// OK
+	imagesModel.On("PatchImage", h.ContextMatcher(), id, patch).
+		Return(true, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string]string{"description": "foo"}))
+	recorded.CodeIs(http.StatusNoContent)
+	recorded.BodyIs("")
+
+	// invalid tags
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string][]string{"tags": {"with space"}}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// tags OK
+	tags := []string{"stable", "customer-x"}
+	imagesModel.On("PatchImage", h.ContextMatcher(), id,
+		&images.SoftwareImageMetaPatch{Tags: &tags}).
+		Return(true, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string][]string{"tags": tags}))
+	recorded.CodeIs(http.StatusNoContent)
+
+	// invalid expiry time
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string]string{"expires_at": "tomorrow"}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// expiry time OK
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	imagesModel.On("PatchImage", h.ContextMatcher(), id,
+		&images.SoftwareImageMetaPatch{ExpiresAt: &expiresAt}).
+		Return(true, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string]string{"expires_at": "2030-01-02T03:04:05Z"}))
+	recorded.CodeIs(http.StatusNoContent)
+
+	// invalid metadata key
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string]map[string]string{"metadata": {"with space": "foo"}}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// metadata OK
+	metadata := map[string]string{"build_url": "https://ci.example.com/42"}
+	imagesModel.On("PatchImage", h.ContextMatcher(), id,
+		&images.SoftwareImageMetaPatch{Metadata: &metadata}).
+		Return(true, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string]map[string]string{"metadata": metadata}))
+	recorded.CodeIs(http.StatusNoContent)
+
+	// deprecated OK
+	deprecated := true
+	imagesModel.On("PatchImage", h.ContextMatcher(), id,
+		&images.SoftwareImageMetaPatch{Deprecated: &deprecated}).
+		Return(true, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string]bool{"deprecated": true}))
+	recorded.CodeIs(http.StatusNoContent)
+
+	// deprecation of an unavailable artifact
+	imagesModel.On("PatchImage", h.ContextMatcher(), id,
+		&images.SoftwareImageMetaPatch{Deprecated: &deprecated}).
+		Return(false, ErrModelImageQuarantined).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PATCH", "http://localhost/api/0.0.1/images/"+id,
+			map[string]bool{"deprecated": true}))
+	recorded.CodeIs(http.StatusConflict)
+
+	imagesModel.AssertExpectations(t)
+}
+
+func TestControllerUpdateImagesTags(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/artifacts/tags/:action", rest.Post,
+		func(w rest.ResponseWriter, r *rest.Request) {
+			if r.PathParam("action") == "add" {
+				controller.AddImagesTag(w, r)
+			} else {
+				controller.RemoveImagesTag(w, r)
+			}
+		})
+	url := "http://localhost/api/0.0.1/artifacts/tags/"
+
+	// invalid bodies
+	for body, expected := range map[string]string{
+		`{"tag": `:          "Malformed request body: unexpected end of JSON input",
+		`{"tag": "stable"}`: images.ErrTagsUpdateNoArtifacts.Error(),
+		`{"tag": "a b", "ids": ["` + validUUIDv4 + `"]}`: images.ErrInvalidTag.Error(),
+		`{"tag": "stable", "ids": ["foo"]}`:              "Invalid ID list: IDs not UUIDv4: foo",
+	} {
+		req := test.MakeSimpleRequest("POST", url+"add", nil)
+		req.Body = ioutil.NopCloser(strings.NewReader(body))
+		req.Header.Add(requestid.RequestIdHeader, "test")
+		recorded := test.RunRequest(t, api.MakeHandler(), req)
+		recorded.CodeIs(http.StatusBadRequest)
+		assert.Contains(t, recorded.Recorder.Body.String(), expected, body)
+	}
+
+	update := &images.TagsUpdate{
+		Tag:        "stable",
+		IDs:        []string{validUUIDv4},
+		DeviceType: "beaglebone",
+	}
+
+	// model error
+	imagesModel.On("AddImagesTag", h.ContextMatcher(), update).
+		Return(nil, errors.New("error")).Once()
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url+"add", update))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// OK
+	imagesModel.On("AddImagesTag", h.ContextMatcher(), update).
+		Return(&images.TagsUpdateReport{Modified: 1}, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url+"add", update))
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs(`{"modified":1}`)
+
+	imagesModel.On("RemoveImagesTag", h.ContextMatcher(), update).
+		Return(&images.TagsUpdateReport{Modified: 0}, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url+"remove", update))
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs(`{"modified":0}`)
+
+	imagesModel.AssertExpectations(t)
+}
+
+func TestControllerGetImageLocation(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/tenants/:tenant/images/:id/location",
+		rest.Get, controller.GetImageLocation)
+	url := "http://localhost/api/0.0.1/tenants/tenant1/images/"
+
+	// wrong id
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url+"wrong_id/location", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	tenantCtx := mock.MatchedBy(func(ctx context.Context) bool {
+		id := identity.FromContext(ctx)
+		return id != nil && id.Tenant == "tenant1"
+	})
+
+	location := &images.StorageLocation{
+		Backend: "s3",
+		Bucket:  "mender-artifact-storage",
+		Key:     "tenant1/" + validUUIDv4,
+	}
+	imagesModel.On("ImageLocation", tenantCtx, validUUIDv4).Return(location, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url+validUUIDv4+"/location", nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs(`{"backend":"s3","bucket":"mender-artifact-storage","key":"tenant1/` +
+		validUUIDv4 + `"}`)
+
+	// not found
+	id := uuid.NewV4().String()
+	imagesModel.On("ImageLocation", tenantCtx, id).Return(nil, ErrImageMetaNotFound)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url+id+"/location", nil))
+	recorded.CodeIs(http.StatusNotFound)
+
+	// internal error
+	id = uuid.NewV4().String()
+	imagesModel.On("ImageLocation", tenantCtx, id).Return(nil, errors.New("db error"))
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url+id+"/location", nil))
+	recorded.CodeIs(http.StatusInternalServerError)
+}
+
+func TestControllerCloneImage(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/tenants/:tenant/images/:id/clone",
+		rest.Post, controller.CloneImage)
+	url := "http://localhost/api/0.0.1/tenants/tenant1/images/"
+
+	// wrong id
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url+"wrong_id/clone", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// name can not be overridden
+	id := uuid.NewV4().String()
+	req := test.MakeSimpleRequest("POST", url+id+"/clone",
+		map[string]string{"tenant_id": "tenant2", "name": "myImage"})
+	req.Header.Add(requestid.RequestIdHeader, "test")
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusBadRequest)
+	recorded.BodyIs(`{"error":"Validating request body: Field 'name' can not be set","request_id":"test"}`)
+
+	// invalid tags
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url+id+"/clone",
+			map[string][]string{"tags": {"with space"}}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	description := "foo"
+	clone := &images.SoftwareImageClone{
+		TenantID: "tenant2",
+		SoftwareImageMetaPatch: images.SoftwareImageMetaPatch{
+			Description: &description,
+		},
+	}
+	body := map[string]string{"tenant_id": "tenant2", "description": "foo"}
+	tenantCtx := mock.MatchedBy(func(ctx context.Context) bool {
+		id := identity.FromContext(ctx)
+		return id != nil && id.Tenant == "tenant1"
+	})
+
+	testCases := []struct {
+		err    error
+		status int
+	}{
+		{err: errors.New("error"), status: http.StatusInternalServerError},
+		{err: ErrImageMetaNotFound, status: http.StatusNotFound},
+		{err: ErrModelArtifactNotUnique, status: http.StatusUnprocessableEntity},
+	}
+	for _, tc := range testCases {
+		imagesModel.On("CloneImage", tenantCtx, id, clone).
+			Return("", tc.err).Once()
+		recorded = test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("POST", url+id+"/clone", body))
+		recorded.CodeIs(tc.status)
+	}
+
+	// OK
+	cloneID := uuid.NewV4().String()
+	imagesModel.On("CloneImage", tenantCtx, id, clone).
+		Return(cloneID, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url+id+"/clone", body))
+	recorded.CodeIs(http.StatusCreated)
+	recorded.BodyIs(`{"id":"` + cloneID + `"}`)
+
+	imagesModel.AssertExpectations(t)
+}
+
+func TestControllerShareImage(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/:id/sharing", rest.Put, controller.ShareImage)
+	url := "http://localhost/api/0.0.1/images/"
+
+	// wrong id
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PUT", url+"wrong_id/sharing", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// invalid tenant
+	id := uuid.NewV4().String()
+	req := test.MakeSimpleRequest("PUT", url+id+"/sharing",
+		map[string][]string{"shared_with": {"tenant/2"}})
+	req.Header.Add(requestid.RequestIdHeader, "test")
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusBadRequest)
+	recorded.BodyIs(`{"error":"Validating request body: ` +
+		images.ErrSharedTenantInvalid.Error() + `","request_id":"test"}`)
+
+	sharing := &images.ImageSharing{SharedWith: []string{"tenant2", "tenant3"}}
+	body := map[string][]string{"shared_with": {"tenant2", "tenant3"}}
+
+	testCases := []struct {
+		err    error
+		status int
+	}{
+		{err: errors.New("error"), status: http.StatusInternalServerError},
+		{err: ErrImageMetaNotFound, status: http.StatusNotFound},
+		{err: ErrModelImageNotOwned, status: http.StatusForbidden},
+		{err: ErrModelImageSharedWithOwner, status: http.StatusBadRequest},
+		{status: http.StatusNoContent},
+	}
+	for _, tc := range testCases {
+		imagesModel.On("ShareImage", h.ContextMatcher(), id, sharing).
+			Return(tc.err).Once()
+		recorded = test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("PUT", url+id+"/sharing", body))
+		recorded.CodeIs(tc.status)
+	}
+
+	imagesModel.AssertExpectations(t)
+}
+
+func TestControllerComposeImage(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/compose", rest.Post, controller.ComposeImage)
+	url := "http://localhost/api/0.0.1/images/compose"
+
+	base := uuid.NewV4().String()
+	overlay := uuid.NewV4().String()
+
+	// single artifact
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url, map[string]interface{}{
+			"name":      "composed",
+			"artifacts": []string{base},
+		}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// wrong id
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url, map[string]interface{}{
+			"name":      "composed",
+			"artifacts": []string{base, "wrong_id"},
+		}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// missing name
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url, map[string]interface{}{
+			"artifacts": []string{base, overlay},
+		}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	compose := &images.SoftwareImageCompose{
+		Name:      "composed",
+		Artifacts: []string{base, overlay},
+		SoftwareImageMetaConstructor: images.SoftwareImageMetaConstructor{
+			Description: "foo",
+		},
+	}
+	body := map[string]interface{}{
+		"name":        "composed",
+		"artifacts":   []string{base, overlay},
+		"description": "foo",
+	}
+
+	testCases := []struct {
+		err    error
+		status int
+	}{
+		{err: errors.New("error"), status: http.StatusInternalServerError},
+		{err: ErrImageMetaNotFound, status: http.StatusNotFound},
+		{err: ErrModelUnsupportedUpdateType, status: http.StatusUnprocessableEntity},
+		{err: ErrModelImageQuarantined, status: http.StatusConflict},
+		{err: ErrModelIncompatibleDeviceTypes, status: http.StatusUnprocessableEntity},
+		{err: ErrModelArtifactNotUnique, status: http.StatusUnprocessableEntity},
+		{err: ErrModelArtifactNotSigned, status: http.StatusUnprocessableEntity},
+	}
+	for _, tc := range testCases {
+		imagesModel.On("ComposeImage", h.ContextMatcher(), compose).
+			Return("", tc.err).Once()
+		recorded = test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("POST", url, body))
+		recorded.CodeIs(tc.status)
+	}
+
+	// OK
+	id := uuid.NewV4().String()
+	imagesModel.On("ComposeImage", h.ContextMatcher(), compose).
+		Return(id, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url, body))
+	recorded.CodeIs(http.StatusCreated)
+	recorded.HeaderIs("Location", "/api/0.0.1/images/"+id)
+
+	imagesModel.AssertExpectations(t)
+}
+
+func TestControllerNewPendingImage(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/pending", rest.Post, controller.NewPendingImage)
+	url := "http://localhost/api/0.0.1/images/pending"
+
+	// missing device types
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url, map[string]interface{}{
+			"name": "mender-1.1",
+		}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	pending := &images.SoftwareImagePending{
+		Name:                  "mender-1.1",
+		DeviceTypesCompatible: []string{"hammer"},
+		SoftwareImageMetaConstructor: images.SoftwareImageMetaConstructor{
+			Description: "foo",
+		},
+	}
+	body := map[string]interface{}{
+		"name":                    "mender-1.1",
+		"device_types_compatible": []string{"hammer"},
+		"description":             "foo",
+	}
+
+	testCases := []struct {
+		err    error
+		status int
+	}{
+		{err: errors.New("error"), status: http.StatusInternalServerError},
+		{err: ErrModelArtifactNotUnique, status: http.StatusUnprocessableEntity},
+	}
+	for _, tc := range testCases {
+		imagesModel.On("CreatePendingImage", h.ContextMatcher(), pending).
+			Return("", tc.err).Once()
+		recorded = test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("POST", url, body))
+		recorded.CodeIs(tc.status)
+	}
+
+	// OK
+	id := uuid.NewV4().String()
+	imagesModel.On("CreatePendingImage", h.ContextMatcher(), pending).
+		Return(id, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url, body))
+	recorded.CodeIs(http.StatusCreated)
+	recorded.HeaderIs("Location", "/api/0.0.1/images/"+id)
+
+	imagesModel.AssertExpectations(t)
+}
+
+func TestControllerNewPendingImageInvalidFields(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/pending", rest.Post, controller.NewPendingImage)
+	req := test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/images/pending",
+		map[string]interface{}{
+			"name":                    "mender-1.1",
+			"device_types_compatible": []string{"hammer"},
+			"tags":                    []string{"stable", "with space"},
+			"metadata":                map[string]string{"build.url": "https://ci/42"},
+		})
+	req.Header.Add(requestid.RequestIdHeader, "test")
+	recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+	recorded.CodeIs(http.StatusBadRequest)
+	var body struct {
+		Error   string
+		Details []images.FieldError
+	}
+	assert.NoError(t, recorded.DecodeJsonPayload(&body))
+	assert.Equal(t, []images.FieldError{
+		{Field: "tags[1]", Message: images.ErrInvalidTag.Error()},
+		{Field: "metadata.build.url", Message: images.ErrInvalidMetadataKey.Error()},
+	}, body.Details)
+	assert.Contains(t, body.Error, "Validating request body: tags[1]: ")
+
+	// details of the fields rejected by the model are rendered too
+	imagesModel.On("CreatePendingImage", h.ContextMatcher(), mock.Anything).
+		Return("", NewInvalidMetadataError(
+			&images.MissingFieldsError{Fields: []string{"description"}}))
+	req = test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/images/pending",
+		map[string]interface{}{
+			"name":                    "mender-1.1",
+			"device_types_compatible": []string{"hammer"},
+		})
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+
+	recorded.CodeIs(http.StatusBadRequest)
+	assert.NoError(t, recorded.DecodeJsonPayload(&body))
+	assert.Equal(t, []images.FieldError{
+		{Field: "description", Message: "Missing required field"},
+	}, body.Details)
+	assert.Equal(t, "Missing required metadata fields: description: Metadata invalid", body.Error)
+}
+
+func TestControllerNewPendingImageArtifactsLimit(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	imagesModel.On("CreatePendingImage", h.ContextMatcher(), mock.Anything).
+		Return("", pkgerrors.Wrap(&ArtifactsLimitError{Count: 10, Limit: 10}, "limit"))
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/pending", rest.Post, controller.NewPendingImage)
+	req := test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/images/pending",
+		map[string]interface{}{
+			"name":                    "mender-1.1",
+			"device_types_compatible": []string{"hammer"},
+		})
+	req.Header.Add(requestid.RequestIdHeader, "test")
+	recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+	recorded.CodeIs(http.StatusForbidden)
+	var body map[string]string
+	assert.NoError(t, recorded.DecodeJsonPayload(&body))
+	assert.Equal(t, map[string]string{
+		"error":      "Artifacts limit exceeded: 10 of maximum 10 artifacts stored",
+		"code":       ErrCodeArtifactsLimit,
+		"request_id": "test",
+	}, body)
+}
+
+func TestControllerUploadPendingImage(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewV4().String()
+	parts := []Part{
+		{
+			FieldName:  "size",
+			FieldValue: "1",
+		},
+		{
+			FieldName:   "artifact",
+			ContentType: "application/octet-stream",
+			ImageData:   []byte{0},
+		},
+	}
+
+	testCases := []struct {
+		h.JSONResponseParams
+
+		InputID         string
+		InputModelError error
+	}{
+		{
+			InputID: "wrong_id",
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrIDNotUUIDv4),
+			},
+		},
+		{
+			InputID:         id,
+			InputModelError: ErrImageMetaNotFound,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusNotFound,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("Resource not found")),
+			},
+		},
+		{
+			InputID:         id,
+			InputModelError: ErrModelImageNotPending,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusConflict,
+				OutputBodyObject: h.ErrorToErrStruct(ErrModelImageNotPending),
+			},
+		},
+		{
+			InputID:         id,
+			InputModelError: ErrModelPendingNameMismatch,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrModelPendingNameMismatch),
+			},
+		},
+		{
+			InputID: id,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusNoContent,
+				OutputBodyObject: nil,
+			},
+		},
+	}
+
+	for testCaseNumber, testCase := range testCases {
+		t.Run(fmt.Sprintf("test case %d", testCaseNumber+1), func(t *testing.T) {
+			model := &mocks.ImagesModel{}
+
+			model.On("UploadPendingImage", h.ContextMatcher(), testCase.InputID,
+				mock.AnythingOfType("*controller.MultipartUploadMsg")).
+				Return(testCase.InputModelError)
+
+			api := setUpRestTest("/r/:id/file", rest.Put,
+				NewSoftwareImagesController(model, new(view.RESTView), nil, nil).UploadPendingImage)
+
+			req := MakeMultipartRequest("PUT", "http://localhost/r/"+testCase.InputID+"/file",
+				"multipart/form-data", parts)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
+		})
+	}
+}
+
+func TestControllerValidateImage(t *testing.T) {
+	t.Parallel()
+
+	parts := []Part{
+		{
+			FieldName:  "size",
+			FieldValue: "1",
+		},
+		{
+			FieldName:   "artifact",
+			ContentType: "application/octet-stream",
+			ImageData:   []byte{0},
+		},
+	}
+	validation := &images.ArtifactValidation{
+		Size:      1,
+		Checksums: images.Checksums{images.ChecksumSHA256: "abc"},
+		Problems: []string{
+			pkgerrors.Wrap(ErrModelParsingArtifactFailed, "reading artifact error").Error(),
+		},
+	}
+
+	testCases := map[string]struct {
+		parts      []Part
+		validation *images.ArtifactValidation
+		err        error
+
+		h.JSONResponseParams
+	}{
+		"ok": {
+			parts:      parts,
+			validation: validation,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusOK,
+				OutputBodyObject: validation,
+			},
+		},
+		"no artifact": {
+			parts: parts[:1],
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(
+					errors.New("Request does not contain artifact: EOF")),
+			},
+		},
+		"size mismatch": {
+			parts: parts,
+			err:   ErrModelUploadSizeMismatch,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrModelUploadSizeMismatch),
+			},
+		},
+		"internal error": {
+			parts: parts,
+			err:   errors.New("db error"),
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			model := &mocks.ImagesModel{}
+			model.On("ValidateImage", h.ContextMatcher(),
+				mock.AnythingOfType("*controller.MultipartUploadMsg")).
+				Return(tc.validation, tc.err)
+
+			api := setUpRestTest("/r/validate", rest.Post,
+				NewSoftwareImagesController(model, new(view.RESTView), nil, nil).ValidateImage)
+
+			req := MakeMultipartRequest("POST", "http://localhost/r/validate",
+				"multipart/form-data", tc.parts)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			h.CheckRecordedResponse(t, recorded, tc.JSONResponseParams)
+		})
+	}
+}
+
+func TestControllerDownloadImage(t *testing.T) {
+	imagesModel := &mocks.ImagesModel{}
+	controller := NewSoftwareImagesController(imagesModel, new(view.RESTView), nil, nil)
+
+	api := setUpRestTest("/api/0.0.1/images/:id/download", rest.Get, controller.DownloadImage)
+	url := "http://localhost/api/0.0.1/images/"
+
+	// wrong id
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url+"wrong_id/download", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	id := uuid.NewV4().String()
+
+	// model error
+	imagesModel.On("OpenImage", h.ContextMatcher(), id).
+		Return(nil, errors.New("error")).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url+id+"/download", nil))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// not assigned to the device
+	imagesModel.On("OpenImage", h.ContextMatcher(), id).
+		Return(nil, ErrModelImageNotAssigned).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url+id+"/download", nil))
+	recorded.CodeIs(http.StatusForbidden)
+
+	// not found
+	imagesModel.On("OpenImage", h.ContextMatcher(), id).
+		Return(nil, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url+id+"/download", nil))
+	recorded.CodeIs(http.StatusNotFound)
+
+	data := "0123456789"
+	modified := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		rng          string
+		ifRange      string
+		ifNoneMatch  string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{
+			status: http.StatusOK,
+			body:   data,
+		},
+		{
+			ifNoneMatch: `"checksum"`,
+			status:      http.StatusNotModified,
+		},
+		{
+			rng:          "bytes=4-",
+			ifRange:      `"checksum"`,
+			status:       http.StatusPartialContent,
+			body:         "456789",
+			contentRange: "bytes 4-9/10",
+		},
+		{
+			// file changed since the download started
+			rng:     "bytes=4-",
+			ifRange: `"other"`,
+			status:  http.StatusOK,
+			body:    data,
+		},
+		{
+			rng:          "bytes=4-",
+			status:       http.StatusPartialContent,
+			body:         "456789",
+			contentRange: "bytes 4-9/10",
+		},
+		{
+			rng:          "bytes=2-4",
+			status:       http.StatusPartialContent,
+			body:         "234",
+			contentRange: "bytes 2-4/10",
+		},
+		{
+			rng:          "bytes=-3",
+			status:       http.StatusPartialContent,
+			body:         "789",
+			contentRange: "bytes 7-9/10",
+		},
+		{
+			rng:          "bytes=10-",
+			status:       http.StatusRequestedRangeNotSatisfiable,
+			contentRange: "bytes */10",
+		},
+	}
+
+	for _, tc := range testCases {
+		file := &images.ImageFile{
+			FileReader: nopCloser{bytes.NewReader([]byte(data))},
+			Modified:   modified,
+			Checksum:   "checksum",
+		}
+		imagesModel.On("OpenImage", h.ContextMatcher(), id).
+			Return(file, nil).Once()
+
+		req := test.MakeSimpleRequest("GET", url+id+"/download", nil)
+		if tc.rng != "" {
+			req.Header.Set("Range", tc.rng)
+		}
+		if tc.ifRange != "" {
+			req.Header.Set("If-Range", tc.ifRange)
+		}
+		if tc.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tc.ifNoneMatch)
+		}
+		recorded = test.RunRequest(t, api.MakeHandler(), req)
+		recorded.CodeIs(tc.status)
+		recorded.HeaderIs("Content-Range", tc.contentRange)
+		recorded.HeaderIs("ETag", `"checksum"`)
+		if tc.body != "" {
+			recorded.HeaderIs("Accept-Ranges", "bytes")
+			recorded.HeaderIs("Last-Modified", modified.Format(http.TimeFormat))
+			recorded.HeaderIs("Content-Type", ContentTypeArtifact)
+			recorded.HeaderIs("Content-Length", strconv.Itoa(len(tc.body)))
+			recorded.BodyIs(tc.body)
+		}
+	}
+
+	imagesModel.AssertExpectations(t)
+}
+
+func TestSoftwareImagesControllerNewImage(t *testing.T) {
+	t.Parallel()
+
+	file := createValidImageFile()
+	imageBody, err := ioutil.ReadAll(file)
+	assert.NoError(t, err)
 	assert.NotNil(t, imageBody)
 	defer os.Remove(file.Name())
 	defer file.Close()
@@ -310,8 +1895,147 @@ func TestSoftwareImagesControllerNewImage(t *testing.T) {
 			},
 			InputContentType: "multipart/form-data",
 			JSONResponseParams: h.JSONResponseParams{
-				OutputStatus:     http.StatusBadRequest,
-				OutputBodyObject: h.ErrorToErrStruct(errors.New("The last part of the multipart/form-data message should be an artifact.")),
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("The last part of the multipart/form-data message should be an artifact.")),
+			},
+		},
+		{
+			InputBodyObject: []Part{
+				{
+					FieldName:  "size",
+					FieldValue: strconv.Itoa(len(imageBody)),
+				},
+				{
+					FieldName:   "artifact",
+					ContentType: "application/octet-stream",
+					ImageData:   imageBody,
+				},
+			},
+			InputContentType: "multipart/mixed",
+			InputModelID:     "1234",
+			InputModelError:  errors.New("create image error"),
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusInternalServerError,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+			},
+		},
+		{
+			InputBodyObject: []Part{
+				{
+					FieldName:  "size",
+					FieldValue: strconv.Itoa(len(imageBody)),
+				},
+				{
+					FieldName:   "artifact",
+					ContentType: "application/octet-stream",
+					ImageData:   imageBody,
+				},
+			},
+			InputContentType: "multipart/form-data",
+			InputModelID:     "1234",
+			InputModelError:  ErrModelArtifactNotUnique,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusUnprocessableEntity,
+				OutputBodyObject: h.ErrorToErrStruct(ErrModelArtifactNotUnique),
+			},
+		},
+		{
+			InputBodyObject: []Part{
+				{
+					FieldName:  "size",
+					FieldValue: strconv.Itoa(len(imageBody)),
+				},
+				{
+					FieldName:   "artifact",
+					ContentType: "application/octet-stream",
+					ImageData:   imageBody,
+				},
+			},
+			InputContentType: "multipart/form-data",
+			InputModelID:     "1234",
+			InputModelError:  ErrModelImageQuarantined,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusUnprocessableEntity,
+				OutputBodyObject: h.ErrorToErrStruct(ErrModelImageQuarantined),
+			},
+		},
+		{
+			InputBodyObject: []Part{
+				{
+					FieldName:  "size",
+					FieldValue: strconv.Itoa(len(imageBody) + 1),
+				},
+				{
+					FieldName:   "artifact",
+					ContentType: "application/octet-stream",
+					ImageData:   imageBody,
+				},
+			},
+			InputContentType: "multipart/form-data",
+			InputModelError:  ErrModelUploadSizeMismatch,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrModelUploadSizeMismatch),
+			},
+		},
+		{
+			InputBodyObject: []Part{
+				{
+					FieldName:  "size",
+					FieldValue: strconv.Itoa(len(imageBody)),
+				},
+				{
+					FieldName:   "artifact",
+					ContentType: "application/octet-stream",
+					ImageData:   imageBody,
+				},
+			},
+			InputContentType: "multipart/form-data",
+			InputModelError: pkgerrors.Wrap(ErrModelInvalidMetadata,
+				"Invalid name: path separators are not allowed"),
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus: http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(errors.New(
+					"Invalid name: path separators are not allowed: Metadata invalid")),
+			},
+		},
+		{
+			InputBodyObject: []Part{
+				{
+					FieldName:  "size",
+					FieldValue: strconv.Itoa(len(imageBody)),
+				},
+				{
+					FieldName:   "artifact",
+					ContentType: "application/octet-stream",
+					ImageData:   imageBody,
+				},
+			},
+			InputContentType: "multipart/form-data",
+			InputModelError:  ErrModelArtifactSignatureInvalid,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrModelArtifactSignatureInvalid),
+			},
+		},
+		{
+			InputBodyObject: []Part{
+				{
+					FieldName:  "size",
+					FieldValue: strconv.Itoa(len(imageBody)),
+				},
+				{
+					FieldName:   "artifact",
+					ContentType: "application/octet-stream",
+					ImageData:   imageBody,
+				},
+			},
+			InputContentType: "multipart/form-data",
+			InputModelID:     "1234",
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusCreated,
+				OutputBodyObject: nil,
+				OutputHeaders:    map[string]string{"Location": "./r/1234"},
 			},
 		},
 		{
@@ -320,18 +2044,20 @@ func TestSoftwareImagesControllerNewImage(t *testing.T) {
 					FieldName:  "size",
 					FieldValue: strconv.Itoa(len(imageBody)),
 				},
+				{
+					FieldName:  "delta_from",
+					FieldValue: "foo-1.0",
+				},
 				{
 					FieldName:   "artifact",
 					ContentType: "application/octet-stream",
 					ImageData:   imageBody,
 				},
 			},
-			InputContentType: "multipart/mixed",
-			InputModelID:     "1234",
-			InputModelError:  errors.New("create image error"),
+			InputContentType: "multipart/form-data",
 			JSONResponseParams: h.JSONResponseParams{
-				OutputStatus:     http.StatusInternalServerError,
-				OutputBodyObject: h.ErrorToErrStruct(errors.New("internal error")),
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(images.ErrInvalidDelta),
 			},
 		},
 		{
@@ -340,6 +2066,14 @@ func TestSoftwareImagesControllerNewImage(t *testing.T) {
 					FieldName:  "size",
 					FieldValue: strconv.Itoa(len(imageBody)),
 				},
+				{
+					FieldName:  "delta_from",
+					FieldValue: "foo-1.0",
+				},
+				{
+					FieldName:  "delta_to",
+					FieldValue: "foo-2.0",
+				},
 				{
 					FieldName:   "artifact",
 					ContentType: "application/octet-stream",
@@ -347,11 +2081,10 @@ func TestSoftwareImagesControllerNewImage(t *testing.T) {
 				},
 			},
 			InputContentType: "multipart/form-data",
-			InputModelID:     "1234",
-			InputModelError:  ErrModelArtifactNotUnique,
+			InputModelError:  ErrModelDeltaNameMismatch,
 			JSONResponseParams: h.JSONResponseParams{
-				OutputStatus:     http.StatusUnprocessableEntity,
-				OutputBodyObject: h.ErrorToErrStruct(ErrModelArtifactNotUnique),
+				OutputStatus:     http.StatusBadRequest,
+				OutputBodyObject: h.ErrorToErrStruct(ErrModelDeltaNameMismatch),
 			},
 		},
 		{
@@ -360,6 +2093,14 @@ func TestSoftwareImagesControllerNewImage(t *testing.T) {
 					FieldName:  "size",
 					FieldValue: strconv.Itoa(len(imageBody)),
 				},
+				{
+					FieldName:  "delta_from",
+					FieldValue: "foo-1.0",
+				},
+				{
+					FieldName:  "delta_to",
+					FieldValue: "foo-2.0",
+				},
 				{
 					FieldName:   "artifact",
 					ContentType: "application/octet-stream",
@@ -387,7 +2128,7 @@ func TestSoftwareImagesControllerNewImage(t *testing.T) {
 				Return(testCase.InputModelID, testCase.InputModelError)
 
 			api := setUpRestTest("/r", rest.Post,
-				NewSoftwareImagesController(model, new(view.RESTView)).NewImage)
+				NewSoftwareImagesController(model, new(view.RESTView), nil, nil).NewImage)
 
 			req := MakeMultipartRequest("POST", "http://localhost/r",
 				testCase.InputContentType, testCase.InputBodyObject)
@@ -399,6 +2140,490 @@ func TestSoftwareImagesControllerNewImage(t *testing.T) {
 	}
 }
 
+func TestSoftwareImagesControllerNewImageTooLarge(t *testing.T) {
+	MaxUploadRequestSize = 8192
+	defer func() {
+		MaxUploadRequestSize = DefaultMaxUploadRequestSize
+	}()
+
+	// bigger than the limit and the multipart reader buffer
+	junk := strings.Repeat("x", 16384)
+
+	testCases := map[string]struct {
+		parts         []Part
+		unknownLength bool
+		modelCalled   bool
+		status        int
+	}{
+		"within limit": {
+			parts: []Part{
+				{FieldName: "size", FieldValue: "3"},
+				{FieldName: "artifact", ContentType: "application/octet-stream",
+					ImageData: []byte("foo")},
+			},
+			modelCalled: true,
+			status:      http.StatusCreated,
+		},
+		"declared length over limit": {
+			parts: []Part{
+				{FieldName: "junk", FieldValue: junk},
+				{FieldName: "size", FieldValue: "3"},
+				{FieldName: "artifact", ContentType: "application/octet-stream",
+					ImageData: []byte("foo")},
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		"junk parts over limit": {
+			parts: []Part{
+				{FieldName: "junk", FieldValue: junk},
+				{FieldName: "size", FieldValue: "3"},
+				{FieldName: "artifact", ContentType: "application/octet-stream",
+					ImageData: []byte("foo")},
+			},
+			unknownLength: true,
+			status:        http.StatusRequestEntityTooLarge,
+		},
+		"artifact over limit": {
+			parts: []Part{
+				{FieldName: "size", FieldValue: "16384"},
+				{FieldName: "artifact", ContentType: "application/octet-stream",
+					ImageData: []byte(junk)},
+			},
+			unknownLength: true,
+			modelCalled:   true,
+			status:        http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			model := &mocks.ImagesModel{}
+			model.On("CreateImage", h.ContextMatcher(),
+				mock.AnythingOfType("*controller.MultipartUploadMsg")).
+				Return("1234", func(ctx context.Context, msg *MultipartUploadMsg) error {
+					_, err := ioutil.ReadAll(msg.ArtifactReader)
+					return err
+				})
+
+			api := setUpRestTest("/r", rest.Post,
+				NewSoftwareImagesController(model, new(view.RESTView), nil, nil).NewImage)
+
+			req := MakeMultipartRequest("POST", "http://localhost/r",
+				"multipart/form-data", tc.parts)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			if tc.unknownLength {
+				req.ContentLength = -1
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+			recorded.CodeIs(tc.status)
+			if tc.status == http.StatusRequestEntityTooLarge {
+				assert.Contains(t, recorded.Recorder.Body.String(),
+					ErrUploadRequestTooLarge.Error())
+			}
+			if tc.modelCalled {
+				model.AssertNumberOfCalls(t, "CreateImage", 1)
+			} else {
+				model.AssertNotCalled(t, "CreateImage", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestSoftwareImagesControllerNewImageFieldTooLarge(t *testing.T) {
+	model := &mocks.ImagesModel{}
+	api := setUpRestTest("/r", rest.Post,
+		NewSoftwareImagesController(model, new(view.RESTView), nil, nil).NewImage)
+
+	parts := []Part{
+		{FieldName: "size", FieldValue: "16"},
+		{FieldName: "description",
+			FieldValue: strings.Repeat("a", DefaultMaxMetaSize+1)},
+		{FieldName: "artifact", ContentType: "application/octet-stream",
+			ImageData: []byte("0123456789abcdef")},
+	}
+	req := MakeMultipartRequest("POST", "http://localhost/r", "multipart/form-data", parts)
+	req.Header.Add(requestid.RequestIdHeader, "test")
+
+	recorded := test.RunRequest(t, api.MakeHandler(), req)
+	h.CheckRecordedResponse(t, recorded, h.JSONResponseParams{
+		OutputStatus: http.StatusBadRequest,
+		OutputBodyObject: h.ErrorToErrStruct(fmt.Errorf(
+			"Form field description too large: expected at most %d bytes",
+			DefaultMaxMetaSize)),
+	})
+	model.AssertNotCalled(t, "CreateImage", mock.Anything, mock.Anything)
+}
+
+func TestSoftwareImagesControllerNewImageContentType(t *testing.T) {
+	AllowedArtifactContentTypes = []string{"application/octet-stream",
+		"application/vnd.mender-artifact"}
+	defer func() {
+		AllowedArtifactContentTypes = nil
+	}()
+
+	testCases := map[string]struct {
+		contentType string
+		status      int
+	}{
+		"allowed": {
+			contentType: "application/octet-stream",
+			status:      http.StatusCreated,
+		},
+		"allowed, with parameters": {
+			contentType: "Application/Vnd.Mender-Artifact; version=3",
+			status:      http.StatusCreated,
+		},
+		"not allowed": {
+			contentType: "text/plain",
+			status:      http.StatusBadRequest,
+		},
+		"malformed": {
+			contentType: "application/",
+			status:      http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			model := &mocks.ImagesModel{}
+			model.On("CreateImage", h.ContextMatcher(),
+				mock.AnythingOfType("*controller.MultipartUploadMsg")).
+				Return("1234", nil)
+
+			api := setUpRestTest("/r", rest.Post,
+				NewSoftwareImagesController(model, new(view.RESTView), nil, nil).NewImage)
+
+			req := MakeMultipartRequest("POST", "http://localhost/r",
+				"multipart/form-data", []Part{
+					{FieldName: "size", FieldValue: "3"},
+					{FieldName: "artifact", ContentType: tc.contentType,
+						ImageData: []byte("foo")},
+				})
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			recorded.CodeIs(tc.status)
+			if tc.status != http.StatusCreated {
+				assert.Contains(t, recorded.Recorder.Body.String(),
+					ErrArtifactContentTypeNotAllowed.Error())
+				model.AssertNotCalled(t, "CreateImage", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestSoftwareImagesControllerNewImageFormAliases(t *testing.T) {
+	FormFieldAliases = map[string]string{
+		"artifactsize": "size",
+		"notes":        "description",
+		"gitsha":       "meta.git_sha",
+	}
+	defer func() {
+		FormFieldAliases = nil
+		RejectUnknownFormFields = false
+	}()
+
+	testCases := map[string]struct {
+		parts  []Part
+		reject bool
+
+		status int
+	}{
+		"canonical names": {
+			parts: []Part{
+				{FieldName: "size", FieldValue: "3"},
+				{FieldName: "description", FieldValue: "foo"},
+				{FieldName: "meta.git_sha", FieldValue: "abc"},
+			},
+			status: http.StatusCreated,
+		},
+		"aliases": {
+			parts: []Part{
+				{FieldName: "artifactSize", FieldValue: "3"},
+				{FieldName: "Notes", FieldValue: "foo"},
+				{FieldName: "gitSha", FieldValue: "abc"},
+			},
+			status: http.StatusCreated,
+		},
+		"unknown ignored": {
+			parts: []Part{
+				{FieldName: "size", FieldValue: "3"},
+				{FieldName: "description", FieldValue: "foo"},
+				{FieldName: "meta.git_sha", FieldValue: "abc"},
+				{FieldName: "deviceType", FieldValue: "bar"},
+			},
+			status: http.StatusCreated,
+		},
+		"unknown rejected": {
+			parts: []Part{
+				{FieldName: "size", FieldValue: "3"},
+				{FieldName: "deviceType", FieldValue: "bar"},
+			},
+			reject: true,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			RejectUnknownFormFields = tc.reject
+
+			model := &mocks.ImagesModel{}
+			model.On("CreateImage", h.ContextMatcher(),
+				mock.MatchedBy(func(msg *MultipartUploadMsg) bool {
+					return msg.ArtifactSize == 3 &&
+						msg.MetaConstructor.Description == "foo" &&
+						msg.MetaConstructor.Metadata["git_sha"] == "abc"
+				})).
+				Return("1234", nil)
+
+			api := setUpRestTest("/r", rest.Post,
+				NewSoftwareImagesController(model, new(view.RESTView), nil, nil).NewImage)
+
+			parts := append(tc.parts, Part{FieldName: "artifact",
+				ContentType: "application/octet-stream", ImageData: []byte("foo")})
+			req := MakeMultipartRequest("POST", "http://localhost/r",
+				"multipart/form-data", parts)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+			recorded.CodeIs(tc.status)
+			if tc.status != http.StatusCreated {
+				assert.Contains(t, recorded.Recorder.Body.String(),
+					"Unknown form field: deviceType")
+				model.AssertNotCalled(t, "CreateImage", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+type fakeUploadMetrics struct {
+	sizes []int64
+}
+
+func (f *fakeUploadMetrics) ArtifactUploaded(ctx context.Context, size int64,
+	elapsed time.Duration) {
+	f.sizes = append(f.sizes, size)
+}
+
+func TestSoftwareImagesControllerNewImageMetrics(t *testing.T) {
+	model := &mocks.ImagesModel{}
+	model.On("CreateImage", h.ContextMatcher(),
+		mock.MatchedBy(func(msg *MultipartUploadMsg) bool {
+			return msg.ArtifactFileName == "artifact-213.tar.gz"
+		})).
+		Return("1234", nil).Once()
+	model.On("CreateImage", h.ContextMatcher(), mock.Anything).
+		Return("", ErrModelParsingArtifactFailed).Once()
+
+	metrics := &fakeUploadMetrics{}
+	controller := NewSoftwareImagesController(model, new(view.RESTView), nil, nil)
+	controller.SetMetrics(metrics)
+	api := setUpRestTest("/r", rest.Post, controller.NewImage)
+
+	parts := []Part{
+		{FieldName: "size", FieldValue: "3"},
+		{FieldName: "artifact", ContentType: "application/octet-stream",
+			ImageData: []byte("foo")},
+	}
+
+	// uploaded
+	req := MakeMultipartRequest("POST", "http://localhost/r", "multipart/form-data", parts)
+	recorded := test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusCreated)
+	assert.Len(t, metrics.sizes, 1)
+	assert.True(t, metrics.sizes[0] > 0 && metrics.sizes[0] <= req.ContentLength)
+
+	// failed uploads are not recorded
+	req = MakeMultipartRequest("POST", "http://localhost/r", "multipart/form-data", parts)
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusBadRequest)
+	assert.Len(t, metrics.sizes, 1)
+
+	model.AssertExpectations(t)
+}
+
+func TestSoftwareImagesControllerNewImageStorageUploadTimeout(t *testing.T) {
+	model := &mocks.ImagesModel{}
+	model.On("CreateImage", h.ContextMatcher(), mock.Anything).
+		Return("", pkgerrors.Wrap(ErrModelStorageUploadTimeout,
+			"context deadline exceeded"))
+
+	controller := NewSoftwareImagesController(model, new(view.RESTView), nil, nil)
+	api := setUpRestTest("/r", rest.Post, controller.NewImage)
+
+	req := MakeMultipartRequest("POST", "http://localhost/r", "multipart/form-data",
+		[]Part{
+			{FieldName: "size", FieldValue: "3"},
+			{FieldName: "artifact", ContentType: "application/octet-stream",
+				ImageData: []byte("foo")},
+		})
+	req.Header.Add(requestid.RequestIdHeader, "test")
+	recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+	recorded.CodeIs(http.StatusGatewayTimeout)
+	var body map[string]string
+	assert.NoError(t, recorded.DecodeJsonPayload(&body))
+	assert.Equal(t, map[string]string{
+		"error":      ErrModelStorageUploadTimeout.Error(),
+		"code":       ErrCodeStorageUploadTimeout,
+		"request_id": "test",
+	}, body)
+}
+
+func TestSoftwareImagesControllerNewImageExpiresAt(t *testing.T) {
+	makeRequest := func(expiresAt string) *http.Request {
+		req := MakeMultipartRequest("POST", "http://localhost/r",
+			"multipart/form-data", []Part{
+				{FieldName: "size", FieldValue: "3"},
+				{FieldName: "expires_at", FieldValue: expiresAt},
+				{FieldName: "artifact", ContentType: "application/octet-stream",
+					ImageData: []byte("foo")},
+			})
+		req.Header.Add(requestid.RequestIdHeader, "test")
+		return req
+	}
+
+	model := &mocks.ImagesModel{}
+	model.On("CreateImage", h.ContextMatcher(),
+		mock.MatchedBy(func(msg *MultipartUploadMsg) bool {
+			return msg.MetaConstructor.ExpiresAt != nil &&
+				msg.MetaConstructor.ExpiresAt.Equal(
+					time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC))
+		})).
+		Return("1234", nil)
+
+	api := setUpRestTest("/r", rest.Post,
+		NewSoftwareImagesController(model, new(view.RESTView), nil, nil).NewImage)
+
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		makeRequest("2030-01-02T16:04:05+01:00"))
+	recorded.CodeIs(http.StatusCreated)
+
+	recorded = test.RunRequest(t, api.MakeHandler(), makeRequest("tomorrow"))
+	recorded.CodeIs(http.StatusBadRequest)
+	assert.Contains(t, recorded.Recorder.Body.String(), ErrInvalidExpiresAt.Error())
+	model.AssertNumberOfCalls(t, "CreateImage", 1)
+}
+
+func TestSoftwareImagesControllerNewImageMetadata(t *testing.T) {
+	makeRequest := func(key string) *http.Request {
+		req := MakeMultipartRequest("POST", "http://localhost/r",
+			"multipart/form-data", []Part{
+				{FieldName: "size", FieldValue: "3"},
+				{FieldName: "meta.build_url", FieldValue: "https://ci/42"},
+				{FieldName: "meta." + key, FieldValue: "abc"},
+				{FieldName: "artifact", ContentType: "application/octet-stream",
+					ImageData: []byte("foo")},
+			})
+		req.Header.Add(requestid.RequestIdHeader, "test")
+		return req
+	}
+
+	model := &mocks.ImagesModel{}
+	model.On("CreateImage", h.ContextMatcher(),
+		mock.MatchedBy(func(msg *MultipartUploadMsg) bool {
+			return reflect.DeepEqual(msg.MetaConstructor.Metadata, map[string]string{
+				"build_url": "https://ci/42",
+				"git_sha":   "abc",
+			})
+		})).
+		Return("1234", nil)
+
+	api := setUpRestTest("/r", rest.Post,
+		NewSoftwareImagesController(model, new(view.RESTView), nil, nil).NewImage)
+
+	recorded := test.RunRequest(t, api.MakeHandler(), makeRequest("git_sha"))
+	recorded.CodeIs(http.StatusCreated)
+
+	recorded = test.RunRequest(t, api.MakeHandler(), makeRequest("git.sha"))
+	recorded.CodeIs(http.StatusBadRequest)
+	assert.Contains(t, recorded.Recorder.Body.String(), images.ErrInvalidMetadataKey.Error())
+	model.AssertNumberOfCalls(t, "CreateImage", 1)
+}
+
+func TestSoftwareImagesControllerNewImageLimited(t *testing.T) {
+	model := &mocks.ImagesModel{}
+	limiter := NewUploadLimiter(0, 1)
+
+	api := setUpRestTest("/r", rest.Post,
+		NewSoftwareImagesController(model, new(view.RESTView), limiter, nil).NewImage)
+
+	makeRequest := func() *http.Request {
+		req := MakeMultipartRequest("POST", "http://localhost/r",
+			"multipart/form-data", []Part{{FieldName: "size", FieldValue: "foo"}})
+		req.Header.Add(requestid.RequestIdHeader, "test")
+		return req
+	}
+
+	// upload in progress; limit reached
+	release, ok := limiter.Acquire("")
+	assert.True(t, ok)
+
+	recorded := test.RunRequest(t, api.MakeHandler(), makeRequest())
+	recorded.CodeIs(http.StatusTooManyRequests)
+	recorded.HeaderIs(HttpHeaderRetryAfter, "30")
+
+	// slot is released after failed upload too
+	release()
+	recorded = test.RunRequest(t, api.MakeHandler(), makeRequest())
+	recorded.CodeIs(http.StatusBadRequest)
+
+	_, ok = limiter.Acquire("")
+	assert.True(t, ok)
+	model.AssertNotCalled(t, "CreateImage", mock.Anything, mock.Anything)
+}
+
+func TestSoftwareImagesControllerNewImageClientDisconnected(t *testing.T) {
+	// large enough not to be buffered completely by the multipart reader
+	artifact := make([]byte, 64*1024)
+	parts := []Part{
+		{FieldName: "size", FieldValue: strconv.Itoa(len(artifact))},
+		{FieldName: "artifact", ContentType: "application/octet-stream",
+			ImageData: artifact},
+	}
+
+	// client gone before the upload started
+	model := &mocks.ImagesModel{}
+	api := setUpRestTest("/r", rest.Post,
+		NewSoftwareImagesController(model, new(view.RESTView), nil, nil).NewImage)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := MakeMultipartRequest("POST", "http://localhost/r",
+		"multipart/form-data", parts).WithContext(ctx)
+	req.Header.Add(requestid.RequestIdHeader, "test")
+	recorded := test.RunRequest(t, api.MakeHandler(), req)
+	assert.Empty(t, recorded.Recorder.Body.String())
+	model.AssertNotCalled(t, "CreateImage", mock.Anything, mock.Anything)
+
+	// client gone in the middle of the upload; artifact reader fails
+	// and the model error is not rendered
+	model = &mocks.ImagesModel{}
+	api = setUpRestTest("/r", rest.Post,
+		NewSoftwareImagesController(model, new(view.RESTView), nil, nil).NewImage)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	model.On("CreateImage", h.ContextMatcher(),
+		mock.AnythingOfType("*controller.MultipartUploadMsg")).
+		Run(func(args mock.Arguments) {
+			msg := args.Get(1).(*MultipartUploadMsg)
+			cancel()
+			_, err := ioutil.ReadAll(msg.ArtifactReader)
+			assert.Equal(t, context.Canceled, err)
+		}).
+		Return("", ErrModelParsingArtifactFailed)
+
+	req = MakeMultipartRequest("POST", "http://localhost/r",
+		"multipart/form-data", parts).WithContext(ctx)
+	req.Header.Add(requestid.RequestIdHeader, "test")
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	assert.Empty(t, recorded.Recorder.Body.String())
+	model.AssertExpectations(t)
+}
+
 // MakeMultipartRequest returns a http.Request.
 func MakeMultipartRequest(method string, urlStr string, contentType string, payload []Part) *http.Request {
 	body_buf := new(bytes.Buffer)
@@ -481,6 +2706,33 @@ func TestSoftwareImagesControllerDownloadLink(t *testing.T) {
 				OutputBodyObject: h.ErrorToErrStruct(errors.New(`internal error`)),
 			},
 		},
+		// file not uploaded yet
+		{
+			InputID:         "83241c4b-6281-40dd-b6fa-932633e21bac",
+			InputModelError: ErrModelImagePending,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusConflict,
+				OutputBodyObject: h.ErrorToErrStruct(ErrModelImagePending),
+			},
+		},
+		// being scanned for malware
+		{
+			InputID:         "83241c4b-6281-40dd-b6fa-932633e21bac",
+			InputModelError: ErrModelImageScanning,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusConflict,
+				OutputBodyObject: h.ErrorToErrStruct(ErrModelImageScanning),
+			},
+		},
+		// not assigned to the device
+		{
+			InputID:         "83241c4b-6281-40dd-b6fa-932633e21bac",
+			InputModelError: ErrModelImageNotAssigned,
+			JSONResponseParams: h.JSONResponseParams{
+				OutputStatus:     http.StatusForbidden,
+				OutputBodyObject: h.ErrorToErrStruct(ErrModelImageNotAssigned),
+			},
+		},
 		// no file found
 		{
 			InputID: "83241c4b-6281-40dd-b6fa-932633e21baf",
@@ -504,11 +2756,11 @@ func TestSoftwareImagesControllerDownloadLink(t *testing.T) {
 		model := &mocks.ImagesModel{}
 
 		model.On("DownloadLink", h.ContextMatcher(),
-			testCase.InputID, DefaultDownloadLinkExpire).
+			testCase.InputID, DefaultDownloadLinkExpire, "").
 			Return(testCase.InputModelLink, testCase.InputModelError)
 
 		api := setUpRestTest("/:id", rest.Post,
-			NewSoftwareImagesController(model, new(view.RESTView)).DownloadLink)
+			NewSoftwareImagesController(model, new(view.RESTView), nil, nil).DownloadLink)
 
 		var expire string
 		if testCase.InputParamExpire != nil {
@@ -524,3 +2776,166 @@ func TestSoftwareImagesControllerDownloadLink(t *testing.T) {
 		h.CheckRecordedResponse(t, recorded, testCase.JSONResponseParams)
 	}
 }
+
+func TestSoftwareImagesControllerDownloadLinkRedirect(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewV4().String()
+	link := images.NewLink("http://come.and.get.me", time.Now().Add(DefaultDownloadLinkExpire))
+
+	model := &mocks.ImagesModel{}
+	model.On("DownloadLink", h.ContextMatcher(), id, DefaultDownloadLinkExpire, "").
+		Return(link, nil)
+
+	api := setUpRestTest("/:id", rest.Get,
+		NewSoftwareImagesController(model, new(view.RESTView), nil, nil).DownloadLink)
+
+	testCases := []struct {
+		query  string
+		accept string
+
+		code int
+	}{
+		{code: http.StatusOK},
+		{query: "?redirect=false", code: http.StatusOK},
+		{query: "?redirect=true", code: http.StatusFound},
+		{query: "?redirect=1", accept: ContentTypeText, code: http.StatusFound},
+		{accept: ContentTypeText, code: http.StatusFound},
+		{query: "?redirect=false", accept: ContentTypeText, code: http.StatusOK},
+		{query: "?redirect=maybe", code: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		req := test.MakeSimpleRequest("GET", "http://localhost/"+id+tc.query, nil)
+		if tc.accept != "" {
+			req.Header.Set(HttpHeaderAccept, tc.accept)
+		}
+
+		recorded := test.RunRequest(t, api.MakeHandler(), req)
+		recorded.CodeIs(tc.code)
+
+		switch tc.code {
+		case http.StatusFound:
+			recorded.HeaderIs("Location", link.Uri)
+			recorded.HeaderIs(HttpHeaderExpires, link.Expire.UTC().Format(http.TimeFormat))
+		case http.StatusOK:
+			recorded.ContentTypeIsJson()
+		}
+	}
+}
+
+func TestSoftwareImagesControllerDownloadLinkRegion(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		query  string
+		header string
+
+		region string
+	}{
+		"none": {},
+		"query": {
+			query:  "?region=eu-west-1",
+			region: "eu-west-1",
+		},
+		"header": {
+			header: "us-east-1",
+			region: "us-east-1",
+		},
+		"query takes precedence": {
+			query:  "?region=eu-west-1",
+			header: "us-east-1",
+			region: "eu-west-1",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			id := uuid.NewV4().String()
+			link := images.NewLink("http://come.and.get.me",
+				time.Now().Add(DefaultDownloadLinkExpire))
+
+			model := &mocks.ImagesModel{}
+			model.On("DownloadLink", h.ContextMatcher(), id,
+				DefaultDownloadLinkExpire, tc.region).
+				Return(link, nil)
+
+			api := setUpRestTest("/:id", rest.Get,
+				NewSoftwareImagesController(model, new(view.RESTView), nil, nil).DownloadLink)
+
+			req := test.MakeSimpleRequest("GET", "http://localhost/"+id+tc.query, nil)
+			if tc.header != "" {
+				req.Header.Set(HttpHeaderRegion, tc.header)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+			recorded.CodeIs(http.StatusOK)
+			model.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSoftwareImagesControllerDownloadLinkClockSkew(t *testing.T) {
+	DownloadLinkClockSkew = time.Minute
+	defer func() { DownloadLinkClockSkew = 0 }()
+
+	id := uuid.NewV4().String()
+	expire := time.Now().Add(DefaultDownloadLinkExpire).Truncate(time.Second)
+
+	model := &mocks.ImagesModel{}
+	model.On("DownloadLink", h.ContextMatcher(), id, DefaultDownloadLinkExpire, "").
+		Return(images.NewLink("http://come.and.get.me", expire), nil)
+
+	api := setUpRestTest("/:id", rest.Get,
+		NewSoftwareImagesController(model, new(view.RESTView), nil, nil).DownloadLink)
+
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/"+id, nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.HeaderIs(HttpHeaderExpires,
+		expire.Add(-time.Minute).UTC().Format(http.TimeFormat))
+
+	var link images.Link
+	assert.NoError(t, recorded.DecodeJsonPayload(&link))
+	assert.True(t, expire.Add(-time.Minute).Equal(link.Expire))
+}
+
+func TestSoftwareImagesControllerDownloadLinkCacheHeaders(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewV4().String()
+	expire := time.Now().Add(DefaultDownloadLinkExpire)
+
+	model := &mocks.ImagesModel{}
+	model.On("DownloadLink", h.ContextMatcher(), id, DefaultDownloadLinkExpire, "").
+		Return(images.NewLink("http://come.and.get.me", expire), nil)
+
+	api := setUpRestTest("/:id", rest.Get,
+		NewSoftwareImagesController(model, new(view.RESTView), nil, nil).DownloadLink)
+
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/"+id, nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.HeaderIs(HttpHeaderExpires, expire.UTC().Format(http.TimeFormat))
+
+	var maxAge int64
+	_, err := fmt.Sscanf(recorded.Recorder.HeaderMap.Get(HttpHeaderCacheControl),
+		"private, max-age=%d", &maxAge)
+	assert.NoError(t, err)
+	assert.True(t, maxAge <= int64(DefaultDownloadLinkExpire/time.Second))
+	assert.True(t, maxAge > int64(DefaultDownloadLinkExpire/time.Second)-10)
+
+	lastModified, err := http.ParseTime(recorded.Recorder.HeaderMap.Get(HttpHeaderLastModified))
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), lastModified, time.Minute)
+
+	// expired link is not cached
+	id = uuid.NewV4().String()
+	model.On("DownloadLink", h.ContextMatcher(), id, DefaultDownloadLinkExpire, "").
+		Return(images.NewLink("http://come.and.get.me", time.Now().Add(-time.Minute)), nil)
+
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/"+id, nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.HeaderIs(HttpHeaderCacheControl, "private, max-age=0")
+}
@@ -17,6 +17,8 @@ package controller
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	"github.com/mendersoftware/deployments/resources/images"
@@ -35,17 +37,110 @@ var (
 	ErrModelImageInActiveDeployment     = errors.New("Image is used in active deployment and cannot be removed")
 	ErrModelImageUsedInAnyDeployment    = errors.New("Image have been already used in deployment")
 	ErrModelParsingArtifactFailed       = errors.New("Cannot parse artifact file")
+	ErrModelLinkRotationNotSupported    = errors.New("Download links revocation not supported by the file storage")
+	ErrModelArtifactNotSigned           = errors.New("Artifact is not signed")
+	ErrModelArtifactSignatureInvalid    = errors.New("Artifact signature could not be verified with any of the trusted keys")
+	ErrModelIncompatibleDeviceTypes     = errors.New("Artifacts have no compatible device type in common")
+	ErrModelUnsupportedUpdateType       = errors.New("Only rootfs-image updates can be composed")
+	ErrModelDeltaNameMismatch           = errors.New("Name of the artifact the delta results in does not match the artifact name")
+	ErrModelInvalidBundle               = errors.New("Invalid artifacts bundle")
+	ErrModelArtifactFileMissing         = errors.New("Artifact file is missing from the file storage")
+	ErrModelStorageAccessDenied         = errors.New("File storage access denied")
+	ErrModelStorageMisconfigured        = errors.New("File storage misconfigured")
+	ErrModelStorageThrottled            = errors.New("File storage is busy, try again later")
+	ErrModelStorageUploadTimeout        = errors.New("Storing the artifact file timed out")
+	ErrModelImagePending                = errors.New("Artifact file is not uploaded yet")
+	ErrModelImageNotPending             = errors.New("Artifact file is already uploaded")
+	ErrModelImageScanning               = errors.New("Artifact file is being scanned for malware")
+	ErrModelImageQuarantined            = errors.New("Malware found in the artifact file, the artifact is quarantined")
+	ErrModelPendingNameMismatch         = errors.New("Artifact name does not match the pending artifact")
+	ErrModelInvalidStateTransition      = errors.New("Artifact can not be moved to the requested state")
+	ErrModelImageNotAssigned            = errors.New("Artifact is not assigned to the device by an active deployment")
+	ErrModelImageNotOwned               = errors.New("Artifact is shared by another tenant, only the owner can change it")
+	ErrModelImageSharedWithOwner        = errors.New("Artifact can not be shared with the tenant owning it")
 )
 
+// ArtifactsLimitError is returned when the tenant already has the maximum
+// number of artifacts allowed, reports the current and the maximum counts.
+type ArtifactsLimitError struct {
+	Count uint64
+	Limit uint64
+}
+
+func (e *ArtifactsLimitError) Error() string {
+	return fmt.Sprintf("Artifacts limit exceeded: %d of maximum %d artifacts stored",
+		e.Count, e.Limit)
+}
+
+// InvalidMetadataError is ErrModelInvalidMetadata caused by the failed
+// validation of the metadata; the details of the invalid fields are kept,
+// so that they can be rendered.
+type InvalidMetadataError struct {
+	Err error
+}
+
+// NewInvalidMetadataError wraps the validation failure as ErrModelInvalidMetadata.
+func NewInvalidMetadataError(err error) error {
+	return &InvalidMetadataError{Err: err}
+}
+
+func (e *InvalidMetadataError) Error() string {
+	return e.Err.Error() + ": " + ErrModelInvalidMetadata.Error()
+}
+
+func (e *InvalidMetadataError) Cause() error {
+	return ErrModelInvalidMetadata
+}
+
+// Details returns the details of the validation failure, if any.
+func (e *InvalidMetadataError) Details() interface{} {
+	if detailed, ok := e.Err.(interface{ Details() interface{} }); ok {
+		return detailed.Details()
+	}
+	return nil
+}
+
 type ImagesModel interface {
 	ListImages(ctx context.Context,
-		filters map[string]string) ([]*images.SoftwareImage, error)
+		filter *images.ImagesFilter) ([]*images.SoftwareImage, error)
+	ListDeviceTypes(ctx context.Context) ([]*images.DeviceTypeCount, error)
+	ListInstallableImages(ctx context.Context,
+		provides images.Provides) ([]*images.UpdateStep, error)
+	ListImageChanges(ctx context.Context,
+		query *images.ImageChangesQuery) ([]*images.ImageChange, error)
+	GetImages(ctx context.Context, ids []string) (*images.ImagesLookup, error)
 	DownloadLink(ctx context.Context, imageID string,
-		expire time.Duration) (*images.Link, error)
+		expire time.Duration, region string) (*images.Link, error)
+	RotateDownloadLinks(ctx context.Context, imageID string) error
+	SetImageState(ctx context.Context, imageID, state string) error
+	ImageLocation(ctx context.Context,
+		imageID string) (*images.StorageLocation, error)
+	OpenImage(ctx context.Context, imageID string) (*images.ImageFile, error)
 	GetImage(ctx context.Context, id string) (*images.SoftwareImage, error)
 	DeleteImage(ctx context.Context, imageID string) error
+	DeleteDeviceTypeImages(ctx context.Context,
+		deviceType string, force bool) (*images.DeleteReport, error)
 	CreateImage(ctx context.Context,
 		multipartUploadMsg *MultipartUploadMsg) (string, error)
+	CreatePendingImage(ctx context.Context,
+		pending *images.SoftwareImagePending) (string, error)
+	UploadPendingImage(ctx context.Context, id string,
+		multipartUploadMsg *MultipartUploadMsg) error
+	ValidateImage(ctx context.Context,
+		multipartUploadMsg *MultipartUploadMsg) (*images.ArtifactValidation, error)
 	EditImage(ctx context.Context, id string,
 		constructorData *images.SoftwareImageMetaConstructor) (bool, error)
+	PatchImage(ctx context.Context, id string,
+		patch *images.SoftwareImageMetaPatch) (bool, error)
+	AddImagesTag(ctx context.Context,
+		update *images.TagsUpdate) (*images.TagsUpdateReport, error)
+	RemoveImagesTag(ctx context.Context,
+		update *images.TagsUpdate) (*images.TagsUpdateReport, error)
+	CloneImage(ctx context.Context, id string,
+		clone *images.SoftwareImageClone) (string, error)
+	ShareImage(ctx context.Context, id string, sharing *images.ImageSharing) error
+	ComposeImage(ctx context.Context,
+		compose *images.SoftwareImageCompose) (string, error)
+	ExportImages(ctx context.Context, w io.Writer) error
+	ImportImages(ctx context.Context, bundle io.Reader) (*images.ImportResult, error)
 }
@@ -0,0 +1,108 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Only the uploads started longer ago than the duration, e.g. 24h
+	QueryOlderThan = "older_than"
+)
+
+var (
+	ErrInvalidOlderThanParam = errors.New("Invalid older_than parameter, expected non-negative duration, e.g. 24h")
+	ErrOlderThanRequired     = errors.New("Parameter older_than is required")
+)
+
+// IncompleteUploadsController lets the operators inspect and purge
+// the uploads of all the tenants which were never finished.
+type IncompleteUploadsController struct {
+	view  RESTView
+	model IncompleteUploadsModel
+}
+
+func NewIncompleteUploadsController(model IncompleteUploadsModel,
+	view RESTView) *IncompleteUploadsController {
+	return &IncompleteUploadsController{
+		model: model,
+		view:  view,
+	}
+}
+
+// ListIncompleteUploads lists the incomplete uploads, optionally only
+// the ones older than the older_than duration.
+func (c *IncompleteUploadsController) ListIncompleteUploads(w rest.ResponseWriter,
+	r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	olderThan, err := parseOlderThan(r.URL.Query().Get(QueryOlderThan))
+	if err != nil {
+		c.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	uploads, err := c.model.ListIncompleteUploads(r.Context(), olderThan)
+	if err != nil {
+		c.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	c.view.RenderSuccessGet(w, r, uploads)
+}
+
+// PurgeIncompleteUploads removes the incomplete uploads older than
+// the older_than duration, which is required so that the uploads
+// in progress are not removed by accident, and responds with the report.
+func (c *IncompleteUploadsController) PurgeIncompleteUploads(w rest.ResponseWriter,
+	r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	value := r.URL.Query().Get(QueryOlderThan)
+	if value == "" {
+		c.view.RenderError(w, r, ErrOlderThanRequired, http.StatusBadRequest, l)
+		return
+	}
+	olderThan, err := parseOlderThan(value)
+	if err != nil {
+		c.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	report, err := c.model.PurgeIncompleteUploads(r.Context(), olderThan)
+	if err != nil {
+		c.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	c.view.RenderSuccessGet(w, r, report)
+}
+
+// parseOlderThan parses the older_than duration, empty means 0.
+func parseOlderThan(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	olderThan, err := time.ParseDuration(value)
+	if err != nil || olderThan < 0 {
+		return 0, ErrInvalidOlderThanParam
+	}
+	return olderThan, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package controller_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	. "github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/resources/images/controller/mocks"
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+	h "github.com/mendersoftware/deployments/utils/testing"
+)
+
+func TestControllerListIncompleteUploads(t *testing.T) {
+	model := &mocks.IncompleteUploadsModel{}
+	controller := NewIncompleteUploadsController(model, new(view.RESTView))
+
+	api := setUpRestTest("/api/0.0.1/artifacts/uploads/incomplete",
+		rest.Get, controller.ListIncompleteUploads)
+
+	// invalid duration
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/artifacts/uploads/incomplete?older_than=-1h", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// error
+	model.On("ListIncompleteUploads", h.ContextMatcher(), time.Duration(0)).
+		Return(nil, errors.New("error"))
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/artifacts/uploads/incomplete", nil))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// OK
+	uploads := []*images.IncompleteUpload{{
+		Id:     "f826484e-1157-4109-af21-304e6d711560",
+		Tenant: "foo",
+		Kind:   images.IncompleteUploadChunked,
+		Size:   100,
+		Age:    7200,
+	}}
+	model.On("ListIncompleteUploads", h.ContextMatcher(), time.Hour).
+		Return(uploads, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET",
+			"http://localhost/api/0.0.1/artifacts/uploads/incomplete?older_than=1h", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	var received []*images.IncompleteUpload
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Equal(t, uploads, received)
+
+	model.AssertExpectations(t)
+}
+
+func TestControllerPurgeIncompleteUploads(t *testing.T) {
+	model := &mocks.IncompleteUploadsModel{}
+	controller := NewIncompleteUploadsController(model, new(view.RESTView))
+
+	api := setUpRestTest("/api/0.0.1/artifacts/uploads/incomplete",
+		rest.Delete, controller.PurgeIncompleteUploads)
+
+	// threshold required
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("DELETE",
+			"http://localhost/api/0.0.1/artifacts/uploads/incomplete", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("DELETE",
+			"http://localhost/api/0.0.1/artifacts/uploads/incomplete?older_than=day", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// OK
+	report := &images.IncompleteUploadsReport{
+		Purged: []*images.IncompleteUpload{{
+			Id:   "f826484e-1157-4109-af21-304e6d711560",
+			Kind: images.IncompleteUploadPending,
+		}},
+		Failed: []*images.IncompleteUpload{},
+	}
+	model.On("PurgeIncompleteUploads", h.ContextMatcher(), 24*time.Hour).
+		Return(report, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("DELETE",
+			"http://localhost/api/0.0.1/artifacts/uploads/incomplete?older_than=24h", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	var received images.IncompleteUploadsReport
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Equal(t, *report, received)
+
+	model.AssertExpectations(t)
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package controller
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/utils/restutil"
+)
+
+type IntegrityController struct {
+	view  RESTView
+	model IntegrityModel
+}
+
+func NewIntegrityController(model IntegrityModel, view RESTView) *IntegrityController {
+	return &IntegrityController{
+		model: model,
+		view:  view,
+	}
+}
+
+// VerifyTenantImages verifies artifact files of the tenant and responds with the report.
+func (c *IntegrityController) VerifyTenantImages(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: r.PathParam("tenant"),
+	})
+
+	report, err := c.model.VerifyImages(ctx)
+	if err != nil {
+		c.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	c.view.RenderSuccessGet(w, r, report)
+}
+
+// VerifyAllImages schedules verification of artifact files of all the tenants.
+// Verification runs in the background, results are recorded with the artifacts.
+func (c *IntegrityController) VerifyAllImages(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	if !c.model.ScheduleVerification() {
+		l.Info("image integrity verification already scheduled")
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RecomputeChecksums recomputes the checksums of the batch of the artifact
+// files of the tenant and responds with the report, telling where to resume.
+func (c *IntegrityController) RecomputeChecksums(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	var batch images.ChecksumsRecomputation
+	if err := restutil.DecodeJsonObject(r.Body, &batch); err != nil {
+		c.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+	if len(batch.IDs) > 0 {
+		if err := restutil.ValidateIDList(batch.IDs, images.MaxChecksumsBatch); err != nil {
+			c.view.RenderError(w, r, err, http.StatusBadRequest, l)
+			return
+		}
+	}
+	if err := batch.Validate(); err != nil {
+		c.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	ctx = identity.WithContext(ctx, &identity.Identity{
+		Tenant: r.PathParam("tenant"),
+	})
+
+	report, err := c.model.RecomputeChecksums(ctx, &batch)
+	if err != nil {
+		c.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	c.view.RenderSuccessGet(w, r, report)
+}
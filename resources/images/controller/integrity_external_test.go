@@ -0,0 +1,158 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	. "github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/resources/images/controller/mocks"
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+)
+
+func TestControllerVerifyTenantImages(t *testing.T) {
+	tenantMatcher := func(tenant string) interface{} {
+		return mock.MatchedBy(func(ctx context.Context) bool {
+			id := identity.FromContext(ctx)
+			return id != nil && id.Tenant == tenant
+		})
+	}
+
+	integrityModel := &mocks.IntegrityModel{}
+	controller := NewIntegrityController(integrityModel, new(view.RESTView))
+
+	api := setUpRestTest("/api/0.0.1/tenants/:tenant/artifacts/verify",
+		rest.Post, controller.VerifyTenantImages)
+
+	// error
+	integrityModel.On("VerifyImages", tenantMatcher("foo")).
+		Return(nil, errors.New("error"))
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST",
+			"http://localhost/api/0.0.1/tenants/foo/artifacts/verify", nil))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// OK
+	report := &images.IntegrityReport{
+		Checked:   2,
+		Corrupted: []string{"f826484e-1157-4109-af21-304e6d711560"},
+	}
+	integrityModel.On("VerifyImages", tenantMatcher("bar")).
+		Return(report, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST",
+			"http://localhost/api/0.0.1/tenants/bar/artifacts/verify", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	var received images.IntegrityReport
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Equal(t, *report, received)
+
+	integrityModel.AssertExpectations(t)
+}
+
+func TestControllerVerifyAllImages(t *testing.T) {
+	for _, scheduled := range []bool{true, false} {
+		integrityModel := &mocks.IntegrityModel{}
+		controller := NewIntegrityController(integrityModel, new(view.RESTView))
+
+		api := setUpRestTest("/api/0.0.1/artifacts/verify",
+			rest.Post, controller.VerifyAllImages)
+
+		integrityModel.On("ScheduleVerification").Return(scheduled)
+		recorded := test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/verify", nil))
+		recorded.CodeIs(http.StatusAccepted)
+
+		integrityModel.AssertExpectations(t)
+	}
+}
+
+func TestControllerRecomputeChecksums(t *testing.T) {
+	integrityModel := &mocks.IntegrityModel{}
+	controller := NewIntegrityController(integrityModel, new(view.RESTView))
+
+	api := setUpRestTest("/api/0.0.1/tenants/:tenant/artifacts/checksums/recompute",
+		rest.Post, controller.RecomputeChecksums)
+	url := "http://localhost/api/0.0.1/tenants/foo/artifacts/checksums/recompute"
+
+	tenantBatch := func(batch *images.ChecksumsRecomputation) []interface{} {
+		return []interface{}{
+			mock.MatchedBy(func(ctx context.Context) bool {
+				id := identity.FromContext(ctx)
+				return id != nil && id.Tenant == "foo"
+			}),
+			batch,
+		}
+	}
+
+	// invalid batches
+	for _, body := range []interface{}{
+		nil,
+		map[string]interface{}{"limit": images.MaxChecksumsBatch + 1},
+		map[string]interface{}{"ids": []string{"foo"}},
+	} {
+		recorded := test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("POST", url, body))
+		recorded.CodeIs(http.StatusBadRequest)
+	}
+
+	// error
+	integrityModel.On("RecomputeChecksums", tenantBatch(&images.ChecksumsRecomputation{
+		After: "f826484e-1157-4109-af21-304e6d711560",
+		Limit: images.DefaultChecksumsBatch,
+	})...).Return(nil, errors.New("error"))
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url,
+			map[string]interface{}{"after": "f826484e-1157-4109-af21-304e6d711560"}))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// OK
+	report := &images.ChecksumsReport{
+		Checked:    1,
+		Updated:    1,
+		Mismatched: []string{},
+		Failed:     []string{},
+		Next:       "f826484e-1157-4109-af21-304e6d711560",
+	}
+	integrityModel.On("RecomputeChecksums", tenantBatch(&images.ChecksumsRecomputation{
+		IDs:   []string{"f826484e-1157-4109-af21-304e6d711560"},
+		Limit: 1,
+		Force: true,
+	})...).Return(report, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", url, map[string]interface{}{
+			"ids":   []string{"f826484e-1157-4109-af21-304e6d711560"},
+			"limit": 1,
+			"force": true,
+		}))
+	recorded.CodeIs(http.StatusOK)
+
+	var received images.ChecksumsReport
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Equal(t, *report, received)
+
+	integrityModel.AssertExpectations(t)
+}
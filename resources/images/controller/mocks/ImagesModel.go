@@ -16,6 +16,7 @@ package mocks
 import context "context"
 import controller "github.com/mendersoftware/deployments/resources/images/controller"
 import images "github.com/mendersoftware/deployments/resources/images"
+import io "io"
 import mock "github.com/stretchr/testify/mock"
 import time "time"
 
@@ -24,6 +25,71 @@ type ImagesModel struct {
 	mock.Mock
 }
 
+// AddImagesTag provides a mock function with given fields: ctx, update
+func (_m *ImagesModel) AddImagesTag(ctx context.Context, update *images.TagsUpdate) (*images.TagsUpdateReport, error) {
+	ret := _m.Called(ctx, update)
+
+	var r0 *images.TagsUpdateReport
+	if rf, ok := ret.Get(0).(func(context.Context, *images.TagsUpdate) *images.TagsUpdateReport); ok {
+		r0 = rf(ctx, update)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.TagsUpdateReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *images.TagsUpdate) error); ok {
+		r1 = rf(ctx, update)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CloneImage provides a mock function with given fields: ctx, id, clone
+func (_m *ImagesModel) CloneImage(ctx context.Context, id string, clone *images.SoftwareImageClone) (string, error) {
+	ret := _m.Called(ctx, id, clone)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string, *images.SoftwareImageClone) string); ok {
+		r0 = rf(ctx, id, clone)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, *images.SoftwareImageClone) error); ok {
+		r1 = rf(ctx, id, clone)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ComposeImage provides a mock function with given fields: ctx, compose
+func (_m *ImagesModel) ComposeImage(ctx context.Context, compose *images.SoftwareImageCompose) (string, error) {
+	ret := _m.Called(ctx, compose)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, *images.SoftwareImageCompose) string); ok {
+		r0 = rf(ctx, compose)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *images.SoftwareImageCompose) error); ok {
+		r1 = rf(ctx, compose)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // CreateImage provides a mock function with given fields: ctx, multipartUploadMsg
 func (_m *ImagesModel) CreateImage(ctx context.Context, multipartUploadMsg *controller.MultipartUploadMsg) (string, error) {
 	ret := _m.Called(ctx, multipartUploadMsg)
@@ -45,6 +111,50 @@ func (_m *ImagesModel) CreateImage(ctx context.Context, multipartUploadMsg *cont
 	return r0, r1
 }
 
+// CreatePendingImage provides a mock function with given fields: ctx, pending
+func (_m *ImagesModel) CreatePendingImage(ctx context.Context, pending *images.SoftwareImagePending) (string, error) {
+	ret := _m.Called(ctx, pending)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, *images.SoftwareImagePending) string); ok {
+		r0 = rf(ctx, pending)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *images.SoftwareImagePending) error); ok {
+		r1 = rf(ctx, pending)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteDeviceTypeImages provides a mock function with given fields: ctx, deviceType, force
+func (_m *ImagesModel) DeleteDeviceTypeImages(ctx context.Context, deviceType string, force bool) (*images.DeleteReport, error) {
+	ret := _m.Called(ctx, deviceType, force)
+
+	var r0 *images.DeleteReport
+	if rf, ok := ret.Get(0).(func(context.Context, string, bool) *images.DeleteReport); ok {
+		r0 = rf(ctx, deviceType, force)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.DeleteReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, bool) error); ok {
+		r1 = rf(ctx, deviceType, force)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DeleteImage provides a mock function with given fields: ctx, imageID
 func (_m *ImagesModel) DeleteImage(ctx context.Context, imageID string) error {
 	ret := _m.Called(ctx, imageID)
@@ -59,13 +169,13 @@ func (_m *ImagesModel) DeleteImage(ctx context.Context, imageID string) error {
 	return r0
 }
 
-// DownloadLink provides a mock function with given fields: ctx, imageID, expire
-func (_m *ImagesModel) DownloadLink(ctx context.Context, imageID string, expire time.Duration) (*images.Link, error) {
-	ret := _m.Called(ctx, imageID, expire)
+// DownloadLink provides a mock function with given fields: ctx, imageID, expire, region
+func (_m *ImagesModel) DownloadLink(ctx context.Context, imageID string, expire time.Duration, region string) (*images.Link, error) {
+	ret := _m.Called(ctx, imageID, expire, region)
 
 	var r0 *images.Link
-	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration) *images.Link); ok {
-		r0 = rf(ctx, imageID, expire)
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration, string) *images.Link); ok {
+		r0 = rf(ctx, imageID, expire, region)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*images.Link)
@@ -73,8 +183,8 @@ func (_m *ImagesModel) DownloadLink(ctx context.Context, imageID string, expire
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, string, time.Duration) error); ok {
-		r1 = rf(ctx, imageID, expire)
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Duration, string) error); ok {
+		r1 = rf(ctx, imageID, expire, region)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -103,6 +213,20 @@ func (_m *ImagesModel) EditImage(ctx context.Context, id string, constructorData
 	return r0, r1
 }
 
+// ExportImages provides a mock function with given fields: ctx, w
+func (_m *ImagesModel) ExportImages(ctx context.Context, w io.Writer) error {
+	ret := _m.Called(ctx, w)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, io.Writer) error); ok {
+		r0 = rf(ctx, w)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // GetImage provides a mock function with given fields: ctx, id
 func (_m *ImagesModel) GetImage(ctx context.Context, id string) (*images.SoftwareImage, error) {
 	ret := _m.Called(ctx, id)
@@ -126,13 +250,128 @@ func (_m *ImagesModel) GetImage(ctx context.Context, id string) (*images.Softwar
 	return r0, r1
 }
 
-// ListImages provides a mock function with given fields: ctx, filters
-func (_m *ImagesModel) ListImages(ctx context.Context, filters map[string]string) ([]*images.SoftwareImage, error) {
-	ret := _m.Called(ctx, filters)
+// GetImages provides a mock function with given fields: ctx, ids
+func (_m *ImagesModel) GetImages(ctx context.Context, ids []string) (*images.ImagesLookup, error) {
+	ret := _m.Called(ctx, ids)
+
+	var r0 *images.ImagesLookup
+	if rf, ok := ret.Get(0).(func(context.Context, []string) *images.ImagesLookup); ok {
+		r0 = rf(ctx, ids)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.ImagesLookup)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, ids)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ImageLocation provides a mock function with given fields: ctx, imageID
+func (_m *ImagesModel) ImageLocation(ctx context.Context, imageID string) (*images.StorageLocation, error) {
+	ret := _m.Called(ctx, imageID)
+
+	var r0 *images.StorageLocation
+	if rf, ok := ret.Get(0).(func(context.Context, string) *images.StorageLocation); ok {
+		r0 = rf(ctx, imageID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.StorageLocation)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, imageID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ImportImages provides a mock function with given fields: ctx, bundle
+func (_m *ImagesModel) ImportImages(ctx context.Context, bundle io.Reader) (*images.ImportResult, error) {
+	ret := _m.Called(ctx, bundle)
+
+	var r0 *images.ImportResult
+	if rf, ok := ret.Get(0).(func(context.Context, io.Reader) *images.ImportResult); ok {
+		r0 = rf(ctx, bundle)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.ImportResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, io.Reader) error); ok {
+		r1 = rf(ctx, bundle)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListDeviceTypes provides a mock function with given fields: ctx
+func (_m *ImagesModel) ListDeviceTypes(ctx context.Context) ([]*images.DeviceTypeCount, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []*images.DeviceTypeCount
+	if rf, ok := ret.Get(0).(func(context.Context) []*images.DeviceTypeCount); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*images.DeviceTypeCount)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListImageChanges provides a mock function with given fields: ctx, query
+func (_m *ImagesModel) ListImageChanges(ctx context.Context, query *images.ImageChangesQuery) ([]*images.ImageChange, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []*images.ImageChange
+	if rf, ok := ret.Get(0).(func(context.Context, *images.ImageChangesQuery) []*images.ImageChange); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*images.ImageChange)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *images.ImageChangesQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListImages provides a mock function with given fields: ctx, filter
+func (_m *ImagesModel) ListImages(ctx context.Context, filter *images.ImagesFilter) ([]*images.SoftwareImage, error) {
+	ret := _m.Called(ctx, filter)
 
 	var r0 []*images.SoftwareImage
-	if rf, ok := ret.Get(0).(func(context.Context, map[string]string) []*images.SoftwareImage); ok {
-		r0 = rf(ctx, filters)
+	if rf, ok := ret.Get(0).(func(context.Context, *images.ImagesFilter) []*images.SoftwareImage); ok {
+		r0 = rf(ctx, filter)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*images.SoftwareImage)
@@ -140,8 +379,177 @@ func (_m *ImagesModel) ListImages(ctx context.Context, filters map[string]string
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, map[string]string) error); ok {
-		r1 = rf(ctx, filters)
+	if rf, ok := ret.Get(1).(func(context.Context, *images.ImagesFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListInstallableImages provides a mock function with given fields: ctx, provides
+func (_m *ImagesModel) ListInstallableImages(ctx context.Context, provides images.Provides) ([]*images.UpdateStep, error) {
+	ret := _m.Called(ctx, provides)
+
+	var r0 []*images.UpdateStep
+	if rf, ok := ret.Get(0).(func(context.Context, images.Provides) []*images.UpdateStep); ok {
+		r0 = rf(ctx, provides)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*images.UpdateStep)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, images.Provides) error); ok {
+		r1 = rf(ctx, provides)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// OpenImage provides a mock function with given fields: ctx, imageID
+func (_m *ImagesModel) OpenImage(ctx context.Context, imageID string) (*images.ImageFile, error) {
+	ret := _m.Called(ctx, imageID)
+
+	var r0 *images.ImageFile
+	if rf, ok := ret.Get(0).(func(context.Context, string) *images.ImageFile); ok {
+		r0 = rf(ctx, imageID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.ImageFile)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, imageID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PatchImage provides a mock function with given fields: ctx, id, patch
+func (_m *ImagesModel) PatchImage(ctx context.Context, id string, patch *images.SoftwareImageMetaPatch) (bool, error) {
+	ret := _m.Called(ctx, id, patch)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, *images.SoftwareImageMetaPatch) bool); ok {
+		r0 = rf(ctx, id, patch)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, *images.SoftwareImageMetaPatch) error); ok {
+		r1 = rf(ctx, id, patch)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RemoveImagesTag provides a mock function with given fields: ctx, update
+func (_m *ImagesModel) RemoveImagesTag(ctx context.Context, update *images.TagsUpdate) (*images.TagsUpdateReport, error) {
+	ret := _m.Called(ctx, update)
+
+	var r0 *images.TagsUpdateReport
+	if rf, ok := ret.Get(0).(func(context.Context, *images.TagsUpdate) *images.TagsUpdateReport); ok {
+		r0 = rf(ctx, update)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.TagsUpdateReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *images.TagsUpdate) error); ok {
+		r1 = rf(ctx, update)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RotateDownloadLinks provides a mock function with given fields: ctx, imageID
+func (_m *ImagesModel) RotateDownloadLinks(ctx context.Context, imageID string) error {
+	ret := _m.Called(ctx, imageID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, imageID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetImageState provides a mock function with given fields: ctx, imageID, state
+func (_m *ImagesModel) SetImageState(ctx context.Context, imageID string, state string) error {
+	ret := _m.Called(ctx, imageID, state)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, imageID, state)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ShareImage provides a mock function with given fields: ctx, id, sharing
+func (_m *ImagesModel) ShareImage(ctx context.Context, id string, sharing *images.ImageSharing) error {
+	ret := _m.Called(ctx, id, sharing)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, *images.ImageSharing) error); ok {
+		r0 = rf(ctx, id, sharing)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UploadPendingImage provides a mock function with given fields: ctx, id, multipartUploadMsg
+func (_m *ImagesModel) UploadPendingImage(ctx context.Context, id string, multipartUploadMsg *controller.MultipartUploadMsg) error {
+	ret := _m.Called(ctx, id, multipartUploadMsg)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, *controller.MultipartUploadMsg) error); ok {
+		r0 = rf(ctx, id, multipartUploadMsg)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ValidateImage provides a mock function with given fields: ctx, multipartUploadMsg
+func (_m *ImagesModel) ValidateImage(ctx context.Context, multipartUploadMsg *controller.MultipartUploadMsg) (*images.ArtifactValidation, error) {
+	ret := _m.Called(ctx, multipartUploadMsg)
+
+	var r0 *images.ArtifactValidation
+	if rf, ok := ret.Get(0).(func(context.Context, *controller.MultipartUploadMsg) *images.ArtifactValidation); ok {
+		r0 = rf(ctx, multipartUploadMsg)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.ArtifactValidation)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *controller.MultipartUploadMsg) error); ok {
+		r1 = rf(ctx, multipartUploadMsg)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -0,0 +1,73 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mocks
+
+import context "context"
+import controller "github.com/mendersoftware/deployments/resources/images/controller"
+import images "github.com/mendersoftware/deployments/resources/images"
+import mock "github.com/stretchr/testify/mock"
+import time "time"
+
+// IncompleteUploadsModel is an autogenerated mock type for the IncompleteUploadsModel type
+type IncompleteUploadsModel struct {
+	mock.Mock
+}
+
+// ListIncompleteUploads provides a mock function with given fields: ctx, olderThan
+func (_m *IncompleteUploadsModel) ListIncompleteUploads(ctx context.Context, olderThan time.Duration) ([]*images.IncompleteUpload, error) {
+	ret := _m.Called(ctx, olderThan)
+
+	var r0 []*images.IncompleteUpload
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) []*images.IncompleteUpload); ok {
+		r0 = rf(ctx, olderThan)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*images.IncompleteUpload)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Duration) error); ok {
+		r1 = rf(ctx, olderThan)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeIncompleteUploads provides a mock function with given fields: ctx, olderThan
+func (_m *IncompleteUploadsModel) PurgeIncompleteUploads(ctx context.Context, olderThan time.Duration) (*images.IncompleteUploadsReport, error) {
+	ret := _m.Called(ctx, olderThan)
+
+	var r0 *images.IncompleteUploadsReport
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration) *images.IncompleteUploadsReport); ok {
+		r0 = rf(ctx, olderThan)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.IncompleteUploadsReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Duration) error); ok {
+		r1 = rf(ctx, olderThan)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+var _ controller.IncompleteUploadsModel = (*IncompleteUploadsModel)(nil)
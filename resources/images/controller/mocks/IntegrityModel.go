@@ -0,0 +1,86 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mocks
+
+import context "context"
+import controller "github.com/mendersoftware/deployments/resources/images/controller"
+import images "github.com/mendersoftware/deployments/resources/images"
+import mock "github.com/stretchr/testify/mock"
+
+// IntegrityModel is an autogenerated mock type for the IntegrityModel type
+type IntegrityModel struct {
+	mock.Mock
+}
+
+// RecomputeChecksums provides a mock function with given fields: ctx, batch
+func (_m *IntegrityModel) RecomputeChecksums(ctx context.Context, batch *images.ChecksumsRecomputation) (*images.ChecksumsReport, error) {
+	ret := _m.Called(ctx, batch)
+
+	var r0 *images.ChecksumsReport
+	if rf, ok := ret.Get(0).(func(context.Context, *images.ChecksumsRecomputation) *images.ChecksumsReport); ok {
+		r0 = rf(ctx, batch)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.ChecksumsReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *images.ChecksumsRecomputation) error); ok {
+		r1 = rf(ctx, batch)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ScheduleVerification provides a mock function with given fields: 
+func (_m *IntegrityModel) ScheduleVerification() bool {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
+// VerifyImages provides a mock function with given fields: ctx
+func (_m *IntegrityModel) VerifyImages(ctx context.Context) (*images.IntegrityReport, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *images.IntegrityReport
+	if rf, ok := ret.Get(0).(func(context.Context) *images.IntegrityReport); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.IntegrityReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+var _ controller.IntegrityModel = (*IntegrityModel)(nil)
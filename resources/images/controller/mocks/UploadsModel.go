@@ -0,0 +1,173 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mocks
+
+import context "context"
+import controller "github.com/mendersoftware/deployments/resources/images/controller"
+import images "github.com/mendersoftware/deployments/resources/images"
+import io "io"
+import mock "github.com/stretchr/testify/mock"
+
+// UploadsModel is an autogenerated mock type for the UploadsModel type
+type UploadsModel struct {
+	mock.Mock
+}
+
+// AppendChunk provides a mock function with given fields: ctx, id, offset, size, chunk
+func (_m *UploadsModel) AppendChunk(ctx context.Context, id string, offset int64, size int64, chunk io.Reader) (int64, error) {
+	ret := _m.Called(ctx, id, offset, size, chunk)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, int64, io.Reader) int64); ok {
+		r0 = rf(ctx, id, offset, size, chunk)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int64, int64, io.Reader) error); ok {
+		r1 = rf(ctx, id, offset, size, chunk)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CancelUpload provides a mock function with given fields: ctx, id
+func (_m *UploadsModel) CancelUpload(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateDirectUpload provides a mock function with given fields: ctx, constructor
+func (_m *UploadsModel) CreateDirectUpload(ctx context.Context, constructor *images.UploadSessionConstructor) (*images.DirectUpload, error) {
+	ret := _m.Called(ctx, constructor)
+
+	var r0 *images.DirectUpload
+	if rf, ok := ret.Get(0).(func(context.Context, *images.UploadSessionConstructor) *images.DirectUpload); ok {
+		r0 = rf(ctx, constructor)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.DirectUpload)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *images.UploadSessionConstructor) error); ok {
+		r1 = rf(ctx, constructor)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateUpload provides a mock function with given fields: ctx, constructor
+func (_m *UploadsModel) CreateUpload(ctx context.Context, constructor *images.UploadSessionConstructor) (string, error) {
+	ret := _m.Called(ctx, constructor)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, *images.UploadSessionConstructor) string); ok {
+		r0 = rf(ctx, constructor)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *images.UploadSessionConstructor) error); ok {
+		r1 = rf(ctx, constructor)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FinalizeUpload provides a mock function with given fields: ctx, id
+func (_m *UploadsModel) FinalizeUpload(ctx context.Context, id string) (string, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUpload provides a mock function with given fields: ctx, id
+func (_m *UploadsModel) GetUpload(ctx context.Context, id string) (*images.UploadSession, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *images.UploadSession
+	if rf, ok := ret.Get(0).(func(context.Context, string) *images.UploadSession); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.UploadSession)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUploadProgress provides a mock function with given fields: ctx, id
+func (_m *UploadsModel) GetUploadProgress(ctx context.Context, id string) (*images.UploadProgress, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *images.UploadProgress
+	if rf, ok := ret.Get(0).(func(context.Context, string) *images.UploadProgress); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*images.UploadProgress)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+var _ controller.UploadsModel = (*UploadsModel)(nil)
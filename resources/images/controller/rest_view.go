@@ -21,8 +21,13 @@ import (
 
 type RESTView interface {
 	RenderSuccessPost(w rest.ResponseWriter, r *rest.Request, id string)
-	RenderSuccessGet(w rest.ResponseWriter, object interface{})
+	RenderSuccessPostLocation(w rest.ResponseWriter, location string)
+	RenderSuccessRedirect(w rest.ResponseWriter, location string)
+	RenderSuccessGet(w rest.ResponseWriter, r *rest.Request, object interface{})
+	RenderSuccessGetNegotiated(w rest.ResponseWriter, r *rest.Request, object interface{})
 	RenderError(w rest.ResponseWriter, r *rest.Request, err error, status int, l *log.Logger)
+	RenderErrorWithCode(w rest.ResponseWriter, r *rest.Request, err error,
+		status int, code string, l *log.Logger)
 	RenderInternalError(w rest.ResponseWriter, r *rest.Request, err error, l *log.Logger)
 	RenderErrorNotFound(w rest.ResponseWriter, r *rest.Request, l *log.Logger)
 	RenderSuccessDelete(w rest.ResponseWriter)
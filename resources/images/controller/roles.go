@@ -0,0 +1,34 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package controller
+
+import (
+	"errors"
+
+	"github.com/mendersoftware/deployments/utils/restutil"
+)
+
+// ClaimRoles is the claim of the user JWT listing the roles of the user
+const ClaimRoles = restutil.ClaimRoles
+
+// DefaultArtifactReviewerRole is the role allowed to approve and deprecate
+// the artifacts
+const DefaultArtifactReviewerRole = "artifact_reviewer"
+
+// ArtifactReviewerRole is the role required to change the lifecycle state
+// of the artifacts, configurable on startup.
+var ArtifactReviewerRole = DefaultArtifactReviewerRole
+
+var ErrArtifactReviewerRoleRequired = errors.New("Artifact reviewer role required")
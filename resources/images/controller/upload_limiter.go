@@ -0,0 +1,76 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package controller
+
+import (
+	"sync"
+)
+
+// UploadLimiter limits the number of artifact uploads processed at the same
+// time, both in total and per tenant, so that single tenant can't exhaust
+// the temporary disk space and memory. Zero limit means no limit.
+type UploadLimiter struct {
+	maxTotal     int
+	maxPerTenant int
+
+	mutex    sync.Mutex
+	total    int
+	byTenant map[string]int
+}
+
+func NewUploadLimiter(maxTotal, maxPerTenant int) *UploadLimiter {
+	return &UploadLimiter{
+		maxTotal:     maxTotal,
+		maxPerTenant: maxPerTenant,
+		byTenant:     map[string]int{},
+	}
+}
+
+// Acquire reserves an upload slot for the tenant. Returns false if
+// the limit is reached. Otherwise the returned function has to be called
+// to release the slot once the upload is done, calling it more than once
+// is harmless.
+func (u *UploadLimiter) Acquire(tenant string) (func(), bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.maxTotal > 0 && u.total >= u.maxTotal {
+		return nil, false
+	}
+	if u.maxPerTenant > 0 && u.byTenant[tenant] >= u.maxPerTenant {
+		return nil, false
+	}
+
+	u.total++
+	u.byTenant[tenant]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			u.release(tenant)
+		})
+	}, true
+}
+
+func (u *UploadLimiter) release(tenant string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.total--
+	u.byTenant[tenant]--
+	if u.byTenant[tenant] <= 0 {
+		delete(u.byTenant, tenant)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package controller_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+func TestUploadLimiter(t *testing.T) {
+	limiter := NewUploadLimiter(3, 2)
+
+	releaseA1, ok := limiter.Acquire("a")
+	assert.True(t, ok)
+	_, ok = limiter.Acquire("a")
+	assert.True(t, ok)
+
+	// tenant limit reached
+	_, ok = limiter.Acquire("a")
+	assert.False(t, ok)
+
+	_, ok = limiter.Acquire("b")
+	assert.True(t, ok)
+
+	// total limit reached
+	_, ok = limiter.Acquire("c")
+	assert.False(t, ok)
+
+	// releasing twice frees single slot only
+	releaseA1()
+	releaseA1()
+	_, ok = limiter.Acquire("c")
+	assert.True(t, ok)
+	_, ok = limiter.Acquire("c")
+	assert.False(t, ok)
+}
+
+func TestUploadLimiterUnlimited(t *testing.T) {
+	limiter := NewUploadLimiter(0, 0)
+
+	for i := 0; i < 100; i++ {
+		_, ok := limiter.Acquire("a")
+		assert.True(t, ok)
+	}
+}
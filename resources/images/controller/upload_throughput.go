@@ -0,0 +1,124 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+
+package controller
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// Defaults of the slow upload detection
+const (
+	DefaultSlowUploadThroughput = 64 * 1024
+	DefaultSlowUploadPeriod     = time.Minute
+)
+
+// Artifact uploads read slower than SlowUploadThroughput bytes per second
+// over SlowUploadPeriod are logged, configurable on startup. Zero throughput
+// disables the check.
+var (
+	SlowUploadThroughput int64 = DefaultSlowUploadThroughput
+	SlowUploadPeriod           = DefaultSlowUploadPeriod
+)
+
+// UploadMetrics records the artifact uploads.
+type UploadMetrics interface {
+	ArtifactUploaded(ctx context.Context, size int64, elapsed time.Duration)
+}
+
+// ThroughputMeter counts the bytes read through it and checks the throughput
+// over the consecutive periods, so that sustained slow reads are told apart
+// from short stalls.
+type ThroughputMeter struct {
+	r      io.Reader
+	period time.Duration
+	min    float64
+	onSlow func(throughput float64)
+
+	start       time.Time
+	n           int64
+	periodStart time.Time
+	periodN     int64
+	slow        bool
+}
+
+// NewThroughputMeter measures the reads from r. onSlow is called with the
+// throughput (bytes per second) the first time it falls below minThroughput
+// over the whole period, zero minThroughput or period disables the check.
+func NewThroughputMeter(r io.Reader, period time.Duration, minThroughput int64,
+	onSlow func(throughput float64)) *ThroughputMeter {
+	now := time.Now()
+	return &ThroughputMeter{
+		r:           r,
+		period:      period,
+		min:         float64(minThroughput),
+		onSlow:      onSlow,
+		start:       now,
+		periodStart: now,
+	}
+}
+
+func (m *ThroughputMeter) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.n += int64(n)
+	m.periodN += int64(n)
+
+	if m.min <= 0 || m.period <= 0 || m.slow {
+		return n, err
+	}
+	now := time.Now()
+	if elapsed := now.Sub(m.periodStart); elapsed >= m.period {
+		throughput := float64(m.periodN) / elapsed.Seconds()
+		if throughput < m.min {
+			m.slow = true
+			m.onSlow(throughput)
+		}
+		m.periodStart = now
+		m.periodN = 0
+	}
+	return n, err
+}
+
+// Size returns the number of the bytes read so far.
+func (m *ThroughputMeter) Size() int64 {
+	return m.n
+}
+
+// Elapsed returns the time since the meter was created.
+func (m *ThroughputMeter) Elapsed() time.Duration {
+	return time.Since(m.start)
+}
+
+// clientIP returns the address of the client the request comes from,
+// as forwarded by the API gateway if available.
+func clientIP(r *rest.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	if real := r.Header.Get("X-Real-IP"); real != "" {
+		return real
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
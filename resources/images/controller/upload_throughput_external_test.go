@@ -0,0 +1,76 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+
+package controller_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+// slowReader reads one byte at a time, with a delay
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return s.r.Read(p)
+}
+
+func TestThroughputMeter(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 20)
+
+	// fast enough
+	var reported []float64
+	meter := NewThroughputMeter(bytes.NewReader(data), time.Hour, 1024,
+		func(throughput float64) { reported = append(reported, throughput) })
+	n, err := io.Copy(ioutil.Discard, meter)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, int64(len(data)), meter.Size())
+	assert.True(t, meter.Elapsed() > 0)
+	assert.Empty(t, reported)
+
+	// slow over several periods, reported once
+	meter = NewThroughputMeter(&slowReader{r: bytes.NewReader(data), delay: 5 * time.Millisecond},
+		20*time.Millisecond, 1024,
+		func(throughput float64) { reported = append(reported, throughput) })
+	n, err = io.Copy(ioutil.Discard, meter)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Len(t, reported, 1)
+	assert.True(t, reported[0] < 1024)
+
+	// check disabled
+	reported = nil
+	meter = NewThroughputMeter(&slowReader{r: bytes.NewReader(data), delay: 5 * time.Millisecond},
+		20*time.Millisecond, 0,
+		func(throughput float64) { reported = append(reported, throughput) })
+	_, err = io.Copy(ioutil.Discard, meter)
+	assert.NoError(t, err)
+	assert.Empty(t, reported)
+}
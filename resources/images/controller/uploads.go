@@ -0,0 +1,336 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/asaskevich/govalidator"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/utils/restutil"
+)
+
+// Headers
+const (
+	// Offset of the chunk in the artifact file,
+	// in responses: number of bytes received so far
+	HttpHeaderUploadOffset = "Upload-Offset"
+)
+
+const (
+	ContentTypeEventStream = "text/event-stream"
+
+	// Name of the server-sent events reporting the upload progress
+	UploadProgressEvent = "progress"
+)
+
+// UploadProgressInterval is how often the progress of the upload is checked
+// while streamed to the client.
+var UploadProgressInterval = time.Second
+
+var (
+	ErrInvalidUploadOffset = errors.New("Invalid or missing Upload-Offset header")
+	ErrChunkLengthRequired = errors.New("Chunk length is required")
+)
+
+// UploadsController handles resumable artifact uploads.
+// The artifact file is sent in chunks appended to the upload session,
+// once all the data is received the session is finalized
+// and the artifact is created.
+type UploadsController struct {
+	view  RESTView
+	model UploadsModel
+}
+
+func NewUploadsController(model UploadsModel, view RESTView) *UploadsController {
+	return &UploadsController{
+		model: model,
+		view:  view,
+	}
+}
+
+func (u *UploadsController) NewUpload(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	var constructor images.UploadSessionConstructor
+	if err := restutil.DecodeJsonObject(r.Body, &constructor); err != nil {
+		u.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	if err := constructor.Validate(); err != nil {
+		u.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
+		return
+	}
+
+	id, err := u.model.CreateUpload(r.Context(), &constructor)
+	switch err {
+	default:
+		u.view.RenderInternalError(w, r, err, l)
+	case nil:
+		u.view.RenderSuccessPost(w, r, id)
+	case ErrModelUploadInvalidSize:
+		u.view.RenderError(w, r, err, http.StatusBadRequest, l)
+	}
+}
+
+// NewDirectUpload starts the upload session, which artifact file is uploaded
+// directly to the file storage with the pre-signed link from the response.
+// Once the file is uploaded the session has to be finalized.
+func (u *UploadsController) NewDirectUpload(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	var constructor images.UploadSessionConstructor
+	if err := restutil.DecodeJsonObject(r.Body, &constructor); err != nil {
+		u.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	if err := constructor.Validate(); err != nil {
+		u.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
+		return
+	}
+
+	upload, err := u.model.CreateDirectUpload(r.Context(), &constructor)
+	switch err {
+	default:
+		u.view.RenderInternalError(w, r, err, l)
+	case nil:
+		// upload sessions are a sibling collection: artifacts/directupload
+		w.Header().Add("Location", path.Join(r.URL.Path, "../uploads", upload.Id))
+		w.WriteHeader(http.StatusCreated)
+		w.WriteJson(upload)
+	case ErrModelUploadInvalidSize:
+		u.view.RenderError(w, r, err, http.StatusBadRequest, l)
+	}
+}
+
+func (u *UploadsController) GetUpload(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		u.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	upload, err := u.model.GetUpload(r.Context(), id)
+	if err != nil {
+		u.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if upload == nil {
+		u.view.RenderErrorNotFound(w, r, l)
+		return
+	}
+
+	w.Header().Set(HttpHeaderUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	u.view.RenderSuccessGet(w, r, upload)
+}
+
+// GetUploadProgress streams the progress of the upload session as
+// server-sent events, whenever it changes. The stream ends once the artifact
+// is created or finalizing the session fails, or if the session is gone.
+func (u *UploadsController) GetUploadProgress(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		u.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	progress, err := u.model.GetUploadProgress(ctx, id)
+	if err != nil {
+		u.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if progress == nil {
+		u.view.RenderErrorNotFound(w, r, l)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		u.view.RenderInternalError(w, r, errors.New("streaming not supported"), l)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(UploadProgressInterval)
+	defer ticker.Stop()
+
+	var last images.UploadProgress
+	for progress != nil {
+		if *progress != last {
+			if err := writeEvent(w, UploadProgressEvent, progress); err != nil {
+				l.F(log.Ctx{"upload_id": id, "error": err.Error()}).
+					Warn("failed to stream upload progress")
+				return
+			}
+			flusher.Flush()
+			last = *progress
+		}
+
+		if progress.IsFinal() {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		progress, err = u.model.GetUploadProgress(ctx, id)
+		if err != nil {
+			l.F(log.Ctx{"upload_id": id, "error": err.Error()}).
+				Error("failed to check upload progress")
+			return
+		}
+	}
+}
+
+// writeEvent writes the server-sent event with the JSON data.
+func writeEvent(w rest.ResponseWriter, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w.(http.ResponseWriter), "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
+
+// UploadChunk appends request body to the upload session.
+// Request has to specify the offset of the chunk with Upload-Offset header,
+// which has to be equal to the number of bytes already received.
+func (u *UploadsController) UploadChunk(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		u.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(HttpHeaderUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		u.view.RenderError(w, r, ErrInvalidUploadOffset, http.StatusBadRequest, l)
+		return
+	}
+
+	// chunk is stored with a single PUT request to the file storage,
+	// the size has to be known upfront
+	if r.ContentLength < 0 {
+		u.view.RenderError(w, r, ErrChunkLengthRequired, http.StatusLengthRequired, l)
+		return
+	}
+
+	newOffset, err := u.model.AppendChunk(r.Context(), id, offset, r.ContentLength, r.Body)
+	switch err {
+	default:
+		u.view.RenderInternalError(w, r, err, l)
+	case nil:
+		w.Header().Set(HttpHeaderUploadOffset, strconv.FormatInt(newOffset, 10))
+		u.view.RenderSuccessPut(w)
+	case ErrModelUploadNotFound:
+		u.view.RenderErrorNotFound(w, r, l)
+	case ErrModelUploadOffsetMismatch, ErrModelUploadDirect:
+		u.view.RenderError(w, r, err, http.StatusConflict, l)
+	case ErrModelUploadChunkEmpty, ErrModelUploadChunkTooLarge:
+		u.view.RenderError(w, r, err, http.StatusBadRequest, l)
+	}
+}
+
+// CancelUpload aborts the upload session along with the data received.
+// Responds with success also if the session doesn't exist anymore,
+// so that the request can be safely retried.
+func (u *UploadsController) CancelUpload(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		u.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	if err := u.model.CancelUpload(r.Context(), id); err != nil {
+		u.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	u.view.RenderSuccessDelete(w)
+}
+
+// FinalizeUpload creates the artifact from all the chunks received.
+// On success responds with the location of the new artifact.
+func (u *UploadsController) FinalizeUpload(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		u.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	imgID, err := u.model.FinalizeUpload(r.Context(), id)
+	if renderStorageError(u.view, w, r, err, l) ||
+		renderArtifactsLimitError(u.view, w, r, err, l) {
+		return
+	}
+	cause := errors.Cause(err)
+	switch cause {
+	default:
+		u.view.RenderInternalError(w, r, err, l)
+	case nil:
+		// artifacts collection is three levels up: artifacts/uploads/:id/finalize
+		u.view.RenderSuccessPostLocation(w, path.Join(r.URL.Path, "../../..", imgID))
+	case ErrModelUploadNotFound:
+		u.view.RenderErrorNotFound(w, r, l)
+	case ErrModelUploadIncomplete, ErrModelUploadFinalizing:
+		u.view.RenderError(w, r, cause, http.StatusConflict, l)
+	case ErrModelArtifactNotUnique, ErrModelImageQuarantined:
+		u.view.RenderError(w, r, cause, http.StatusUnprocessableEntity, l)
+	case ErrModelInvalidMetadata:
+		// the details tell which field is wrong
+		u.view.RenderError(w, r, err, http.StatusBadRequest, l)
+	case ErrModelUploadChecksumMismatch, ErrModelUploadSizeMismatch,
+		ErrModelMissingInputMetadata, ErrModelMissingInputArtifact,
+		ErrModelMultipartUploadMsgMalformed,
+		ErrModelArtifactFileTooLarge, ErrModelParsingArtifactFailed,
+		ErrModelArtifactNotSigned, ErrModelArtifactSignatureInvalid:
+		u.view.RenderError(w, r, cause, http.StatusBadRequest, l)
+	}
+}
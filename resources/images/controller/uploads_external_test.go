@@ -0,0 +1,424 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package controller_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	. "github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/resources/images/controller/mocks"
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+	h "github.com/mendersoftware/deployments/utils/testing"
+)
+
+func TestControllerNewUpload(t *testing.T) {
+	uploadsModel := &mocks.UploadsModel{}
+	controller := NewUploadsController(uploadsModel, new(view.RESTView))
+
+	api := setUpRestTest("/api/0.0.1/artifacts/uploads", rest.Post, controller.NewUpload)
+
+	// no payload
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/uploads", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// invalid checksum
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/uploads",
+			map[string]interface{}{"size": 100, "checksum": "xyz"}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// invalid size
+	uploadsModel.On("CreateUpload", h.ContextMatcher(),
+		&images.UploadSessionConstructor{Size: -1}).
+		Return("", ErrModelUploadInvalidSize)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/uploads",
+			map[string]interface{}{"size": -1}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// internal error
+	uploadsModel.On("CreateUpload", h.ContextMatcher(),
+		&images.UploadSessionConstructor{Size: 200}).
+		Return("", errors.New("error"))
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/uploads",
+			map[string]interface{}{"size": 200}))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// OK
+	id := uuid.NewV4().String()
+	uploadsModel.On("CreateUpload", h.ContextMatcher(),
+		&images.UploadSessionConstructor{Size: 100, Description: "foo"}).
+		Return(id, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/uploads",
+			map[string]interface{}{"size": 100, "description": "foo"}))
+	recorded.CodeIs(http.StatusCreated)
+}
+
+func TestControllerNewDirectUpload(t *testing.T) {
+	uploadsModel := &mocks.UploadsModel{}
+	controller := NewUploadsController(uploadsModel, new(view.RESTView))
+
+	api := setUpRestTest("/api/0.0.1/artifacts/directupload", rest.Post, controller.NewDirectUpload)
+
+	// no payload
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/directupload", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// invalid size
+	uploadsModel.On("CreateDirectUpload", h.ContextMatcher(),
+		&images.UploadSessionConstructor{Size: -1}).
+		Return(nil, ErrModelUploadInvalidSize)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/directupload",
+			map[string]interface{}{"size": -1}))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// internal error
+	uploadsModel.On("CreateDirectUpload", h.ContextMatcher(),
+		&images.UploadSessionConstructor{Size: 200}).
+		Return(nil, errors.New("error"))
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/directupload",
+			map[string]interface{}{"size": 200}))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// OK
+	upload := &images.DirectUpload{
+		Id:   uuid.NewV4().String(),
+		Link: &images.Link{Uri: "http://storage/upload", Expire: time.Now().UTC()},
+	}
+	uploadsModel.On("CreateDirectUpload", h.ContextMatcher(),
+		&images.UploadSessionConstructor{Size: 100, Description: "foo"}).
+		Return(upload, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/directupload",
+			map[string]interface{}{"size": 100, "description": "foo"}))
+	recorded.CodeIs(http.StatusCreated)
+	recorded.HeaderIs("Location", "/api/0.0.1/artifacts/uploads/"+upload.Id)
+
+	var received images.DirectUpload
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Equal(t, upload.Id, received.Id)
+	assert.Equal(t, upload.Link.Uri, received.Link.Uri)
+}
+
+func TestControllerGetUpload(t *testing.T) {
+	uploadsModel := &mocks.UploadsModel{}
+	controller := NewUploadsController(uploadsModel, new(view.RESTView))
+
+	api := setUpRestTest("/api/0.0.1/artifacts/uploads/:id", rest.Get, controller.GetUpload)
+
+	// no uuid provided
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/artifacts/uploads/123", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// not found
+	id := uuid.NewV4().String()
+	uploadsModel.On("GetUpload", h.ContextMatcher(), id).Return(nil, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/artifacts/uploads/"+id, nil))
+	recorded.CodeIs(http.StatusNotFound)
+
+	// error
+	id = uuid.NewV4().String()
+	uploadsModel.On("GetUpload", h.ContextMatcher(), id).Return(nil, errors.New("error"))
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/artifacts/uploads/"+id, nil))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// OK
+	id = uuid.NewV4().String()
+	session := images.NewUploadSession(&images.UploadSessionConstructor{Size: 100}, time.Hour)
+	session.Offset = 42
+	uploadsModel.On("GetUpload", h.ContextMatcher(), id).Return(session, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/artifacts/uploads/"+id, nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.ContentTypeIsJson()
+	recorded.HeaderIs(HttpHeaderUploadOffset, "42")
+
+	var received images.UploadSession
+	assert.NoError(t, recorded.DecodeJsonPayload(&received))
+	assert.Equal(t, session.Id, received.Id)
+	assert.Equal(t, int64(42), received.Offset)
+}
+
+func TestControllerGetUploadProgress(t *testing.T) {
+	uploadsModel := &mocks.UploadsModel{}
+	controller := NewUploadsController(uploadsModel, new(view.RESTView))
+
+	api := setUpRestTest("/api/0.0.1/artifacts/uploads/:id/progress", rest.Get,
+		controller.GetUploadProgress)
+
+	interval := UploadProgressInterval
+	UploadProgressInterval = time.Millisecond
+	defer func() { UploadProgressInterval = interval }()
+
+	url := func(id string) string {
+		return "http://localhost/api/0.0.1/artifacts/uploads/" + id + "/progress"
+	}
+
+	// no uuid provided
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url("123"), nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// not found
+	id := uuid.NewV4().String()
+	uploadsModel.On("GetUploadProgress", h.ContextMatcher(), id).Return(nil, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url(id), nil))
+	recorded.CodeIs(http.StatusNotFound)
+
+	// error
+	id = uuid.NewV4().String()
+	uploadsModel.On("GetUploadProgress", h.ContextMatcher(), id).
+		Return(nil, errors.New("error"))
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url(id), nil))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// streamed until done, unchanged progress is not repeated
+	id = uuid.NewV4().String()
+	receiving := images.NewUploadProgress(images.UploadStageReceiving, 50, 100)
+	processing := images.NewUploadProgress(images.UploadStageProcessing, 20, 100)
+	done := images.NewUploadProgress(images.UploadStageDone, 100, 100)
+	done.ImageId = id
+	uploadsModel.On("GetUploadProgress", h.ContextMatcher(), id).
+		Return(receiving, nil).Twice()
+	uploadsModel.On("GetUploadProgress", h.ContextMatcher(), id).
+		Return(processing, nil).Once()
+	uploadsModel.On("GetUploadProgress", h.ContextMatcher(), id).
+		Return(done, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url(id), nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.HeaderIs("Content-Type", ContentTypeEventStream)
+	recorded.BodyIs("event: progress\n" +
+		`data: {"stage":"receiving","bytes":50,"size":100,"percentage":50}` + "\n\n" +
+		"event: progress\n" +
+		`data: {"stage":"processing","bytes":20,"size":100,"percentage":20}` + "\n\n" +
+		"event: progress\n" +
+		`data: {"stage":"done","bytes":100,"size":100,"percentage":100,"image_id":"` +
+		id + `"}` + "\n\n")
+
+	// session gone
+	id = uuid.NewV4().String()
+	uploadsModel.On("GetUploadProgress", h.ContextMatcher(), id).
+		Return(receiving, nil).Once()
+	uploadsModel.On("GetUploadProgress", h.ContextMatcher(), id).
+		Return(nil, nil).Once()
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", url(id), nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs("event: progress\n" +
+		`data: {"stage":"receiving","bytes":50,"size":100,"percentage":50}` + "\n\n")
+
+	uploadsModel.AssertExpectations(t)
+}
+
+func TestControllerCancelUpload(t *testing.T) {
+	uploadsModel := &mocks.UploadsModel{}
+	controller := NewUploadsController(uploadsModel, new(view.RESTView))
+
+	api := setUpRestTest("/api/0.0.1/artifacts/uploads/:id", rest.Delete, controller.CancelUpload)
+
+	// no uuid provided
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("DELETE", "http://localhost/api/0.0.1/artifacts/uploads/123", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	// error
+	id := uuid.NewV4().String()
+	uploadsModel.On("CancelUpload", h.ContextMatcher(), id).Return(errors.New("error"))
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("DELETE", "http://localhost/api/0.0.1/artifacts/uploads/"+id, nil))
+	recorded.CodeIs(http.StatusInternalServerError)
+
+	// OK, also if the session is already gone
+	id = uuid.NewV4().String()
+	uploadsModel.On("CancelUpload", h.ContextMatcher(), id).Return(nil)
+	for i := 0; i < 2; i++ {
+		recorded = test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("DELETE", "http://localhost/api/0.0.1/artifacts/uploads/"+id, nil))
+		recorded.CodeIs(http.StatusNoContent)
+	}
+	uploadsModel.AssertExpectations(t)
+}
+
+func TestControllerUploadChunk(t *testing.T) {
+	id := uuid.NewV4().String()
+	url := "http://localhost/api/0.0.1/artifacts/uploads/" + id
+
+	makeRequest := func(offset string, body []byte) *http.Request {
+		req := test.MakeSimpleRequest("PATCH", url, nil)
+		req.Body = nopCloser{bytes.NewReader(body)}
+		req.ContentLength = int64(len(body))
+		if offset != "" {
+			req.Header.Set(HttpHeaderUploadOffset, offset)
+		}
+		return req
+	}
+
+	testCases := []struct {
+		offset     string
+		body       []byte
+		modelNew   int64
+		modelErr   error
+		callsModel bool
+
+		code   int
+		header string
+	}{
+		{
+			offset: "",
+			body:   []byte("foo"),
+			code:   http.StatusBadRequest,
+		},
+		{
+			offset: "-5",
+			body:   []byte("foo"),
+			code:   http.StatusBadRequest,
+		},
+		{
+			offset:     "0",
+			body:       []byte("foo"),
+			modelNew:   3,
+			callsModel: true,
+			code:       http.StatusNoContent,
+			header:     "3",
+		},
+		{
+			offset:     "3",
+			body:       []byte("bar"),
+			modelErr:   ErrModelUploadNotFound,
+			callsModel: true,
+			code:       http.StatusNotFound,
+		},
+		{
+			offset:     "6",
+			body:       []byte("bar"),
+			modelErr:   ErrModelUploadOffsetMismatch,
+			callsModel: true,
+			code:       http.StatusConflict,
+		},
+		{
+			offset:     "15",
+			body:       []byte("bar"),
+			modelErr:   ErrModelUploadDirect,
+			callsModel: true,
+			code:       http.StatusConflict,
+		},
+		{
+			offset:     "9",
+			body:       []byte("bar"),
+			modelErr:   ErrModelUploadChunkTooLarge,
+			callsModel: true,
+			code:       http.StatusBadRequest,
+		},
+		{
+			offset:     "12",
+			body:       []byte("bar"),
+			modelErr:   errors.New("error"),
+			callsModel: true,
+			code:       http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		uploadsModel := &mocks.UploadsModel{}
+		controller := NewUploadsController(uploadsModel, new(view.RESTView))
+		api := setUpRestTest("/api/0.0.1/artifacts/uploads/:id", rest.Patch, controller.UploadChunk)
+
+		if tc.callsModel {
+			uploadsModel.On("AppendChunk", h.ContextMatcher(), id, mock.AnythingOfType("int64"),
+				int64(len(tc.body)), mock.Anything).
+				Return(tc.modelNew, tc.modelErr)
+		}
+
+		recorded := test.RunRequest(t, api.MakeHandler(), makeRequest(tc.offset, tc.body))
+		recorded.CodeIs(tc.code)
+		if tc.header != "" {
+			recorded.HeaderIs(HttpHeaderUploadOffset, tc.header)
+		}
+
+		uploadsModel.AssertExpectations(t)
+	}
+}
+
+func TestControllerFinalizeUpload(t *testing.T) {
+	uploadsModel := &mocks.UploadsModel{}
+	controller := NewUploadsController(uploadsModel, new(view.RESTView))
+
+	api := setUpRestTest("/api/0.0.1/artifacts/uploads/:id/finalize", rest.Post, controller.FinalizeUpload)
+
+	// no uuid provided
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/uploads/123/finalize", nil))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	testCases := []struct {
+		modelErr error
+		code     int
+	}{
+		{modelErr: ErrModelUploadNotFound, code: http.StatusNotFound},
+		{modelErr: ErrModelUploadIncomplete, code: http.StatusConflict},
+		{modelErr: ErrModelUploadFinalizing, code: http.StatusConflict},
+		{modelErr: ErrModelUploadChecksumMismatch, code: http.StatusBadRequest},
+		{modelErr: ErrModelUploadSizeMismatch, code: http.StatusBadRequest},
+		{modelErr: ErrModelArtifactNotUnique, code: http.StatusUnprocessableEntity},
+		{modelErr: ErrModelImageQuarantined, code: http.StatusUnprocessableEntity},
+		{modelErr: errors.New("error"), code: http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		id := uuid.NewV4().String()
+		uploadsModel.On("FinalizeUpload", h.ContextMatcher(), id).Return("", tc.modelErr)
+		recorded = test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/uploads/"+id+"/finalize", nil))
+		recorded.CodeIs(tc.code)
+	}
+
+	// OK
+	id := uuid.NewV4().String()
+	imgID := uuid.NewV4().String()
+	uploadsModel.On("FinalizeUpload", h.ContextMatcher(), id).Return(imgID, nil)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/api/0.0.1/artifacts/uploads/"+id+"/finalize", nil))
+	recorded.CodeIs(http.StatusCreated)
+	recorded.HeaderIs("Location", "/api/0.0.1/artifacts/"+imgID)
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
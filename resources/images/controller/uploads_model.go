@@ -0,0 +1,50 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+// Errors expected from interface
+var (
+	ErrModelUploadNotFound         = errors.New("Upload session not found")
+	ErrModelUploadInvalidSize      = errors.New("Invalid artifact size")
+	ErrModelUploadOffsetMismatch   = errors.New("Upload offset does not match the session offset")
+	ErrModelUploadChunkTooLarge    = errors.New("Chunk exceeds declared artifact size")
+	ErrModelUploadChunkEmpty       = errors.New("Chunk is empty")
+	ErrModelUploadIncomplete       = errors.New("Upload is not complete")
+	ErrModelUploadChecksumMismatch = errors.New("Artifact checksum mismatch")
+	ErrModelUploadSizeMismatch     = errors.New("Artifact size does not match the declared size")
+	ErrModelUploadDirect           = errors.New("Operation not supported by the upload session")
+	ErrModelUploadFinalizing       = errors.New("Upload session is being finalized")
+)
+
+type UploadsModel interface {
+	CreateUpload(ctx context.Context,
+		constructor *images.UploadSessionConstructor) (string, error)
+	GetUpload(ctx context.Context, id string) (*images.UploadSession, error)
+	GetUploadProgress(ctx context.Context, id string) (*images.UploadProgress, error)
+	AppendChunk(ctx context.Context, id string, offset int64,
+		size int64, chunk io.Reader) (int64, error)
+	FinalizeUpload(ctx context.Context, id string) (string, error)
+	CancelUpload(ctx context.Context, id string) error
+	CreateDirectUpload(ctx context.Context,
+		constructor *images.UploadSessionConstructor) (*images.DirectUpload, error)
+}
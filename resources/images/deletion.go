@@ -0,0 +1,72 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+
+package images
+
+import (
+	"time"
+)
+
+// FailedDeletion records the artifact file which could not be removed from
+// the file storage after its image was deleted, so that the removal is
+// retried later rather than the file leaked.
+type FailedDeletion struct {
+	// Same for the repeated failures of the same file
+	Id string `json:"id" bson:"_id"`
+
+	// Key of the file in the file storage
+	ObjectKey string `json:"object_key" bson:"object_key"`
+
+	// Region of the replica the file is kept in, empty for the primary
+	// file storage
+	Region string `json:"region,omitempty" bson:"region,omitempty"`
+
+	// Error of the last removal attempt
+	Error string `json:"error" bson:"error"`
+
+	// Number of the failed removal attempts
+	Attempts int `json:"attempts" bson:"attempts"`
+
+	// Time of the first failure
+	Failed time.Time `json:"failed" bson:"failed"`
+
+	// Time the removal is retried at, the earliest
+	RetryAt time.Time `json:"retry_at" bson:"retry_at"`
+}
+
+// NewFailedDeletion records the first failed removal of the file,
+// retried right away.
+func NewFailedDeletion(region, objectKey string, err error, now time.Time) *FailedDeletion {
+	id := objectKey
+	if region != "" {
+		id = region + ":" + objectKey
+	}
+	return &FailedDeletion{
+		Id:        id,
+		ObjectKey: objectKey,
+		Region:    region,
+		Error:     err.Error(),
+		Attempts:  1,
+		Failed:    now,
+		RetryAt:   now,
+	}
+}
+
+// Retried records another failed removal attempt, retried after the backoff.
+func (d *FailedDeletion) Retried(err error, now time.Time, backoff time.Duration) {
+	d.Attempts++
+	d.Error = err.Error()
+	d.RetryAt = now.Add(backoff)
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"io"
+	"time"
+)
+
+// FileReader reads the stored artifact file from any position,
+// so that the file can be served partially.
+type FileReader interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// ImageFile is the artifact file of the image opened for reading.
+type ImageFile struct {
+	FileReader
+
+	// Last modification time of the image
+	Modified time.Time
+
+	// SHA256 checksum (hex encoded) of the file, empty if not known
+	Checksum string
+}
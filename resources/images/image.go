@@ -15,15 +15,300 @@
 package images
 
 import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/asaskevich/govalidator"
 )
 
+// Tags limits
+const (
+	MaxTagLength = 64
+	MaxTagsCount = 32
+)
+
+// Custom metadata limits
+const (
+	MaxMetadataKeyLength   = 64
+	MaxMetadataKeysCount   = 32
+	MaxMetadataValueLength = 1024
+)
+
+// Artifact name limits
+const (
+	DefaultMaxNameLength = 256
+	// Names are stored and queried with at most this length anyway
+	MaxNameLengthLimit = 4096
+)
+
+// MaxNameLength is the maximum length of the artifact name in bytes,
+// configurable on startup.
+var MaxNameLength = DefaultMaxNameLength
+
+// nameCharacters are the characters allowed in the artifact names: letters,
+// digits and the punctuation common in the version strings.
+var nameCharacters = regexp.MustCompile(`^[\p{L}\p{M}\p{N} ._+\-:,()\[\]@#~=]*$`)
+
+// NamePattern is the pattern the names of the new artifacts and the artifact
+// names of the new deployments have to match, configurable on startup;
+// no restriction by default.
+var NamePattern *regexp.Regexp
+
+// MetaFields are the user provided metadata fields, named as in the API
+var MetaFields = []string{
+	"description",
+	"tags",
+	"expires_at",
+	"metadata",
+}
+
+// RequiredMetaFields are the MetaFields which have to be given when
+// the artifact is created, configurable on startup; none by default.
+var RequiredMetaFields []string
+
+// Image statuses
+const (
+	// Registered with the metadata only, the artifact file is not uploaded yet
+	ImageStatusPending = "pending"
+	// Artifact file uploaded, images created before the statuses were
+	// introduced have no status recorded
+	ImageStatusReady = "ready"
+	// Artifact file uploaded, being scanned for malware
+	ImageStatusScanning = "scanning"
+	// Malware found in the artifact file, kept for the inspection
+	// but never deployed
+	ImageStatusQuarantined = "quarantined"
+)
+
+// UnavailableImageStatuses are the statuses of the images which can't be
+// deployed nor downloaded.
+var UnavailableImageStatuses = []string{
+	ImageStatusPending,
+	ImageStatusScanning,
+	ImageStatusQuarantined,
+}
+
+// Image lifecycle states
+const (
+	// Artifact not reviewed yet, images created before the states were
+	// introduced have no state recorded
+	ImageStateUploaded = "uploaded"
+	// Artifact reviewed, can be deployed when the approval is required
+	ImageStateApproved = "approved"
+	// Artifact superseded, deployments of it are warned about; no longer
+	// deployed when the approval is required
+	ImageStateDeprecated = "deprecated"
+)
+
+// imageStateTransitions lists the states each state can be changed to;
+// the artifacts no longer deprecated have to be reviewed again
+var imageStateTransitions = map[string][]string{
+	ImageStateUploaded:   {ImageStateApproved, ImageStateDeprecated},
+	ImageStateApproved:   {ImageStateDeprecated},
+	ImageStateDeprecated: {ImageStateUploaded},
+}
+
+// Composition limits
+const (
+	MinComposedArtifacts = 2
+	MaxComposedArtifacts = 16
+)
+
+// Errors
+var (
+	ErrInvalidTag   = errors.New("Invalid tag: expected 1-64 characters from 'a-zA-Z0-9_.:-' set")
+	ErrTooManyTags  = errors.New("Too many tags: at most 32 tags are allowed")
+	ErrDuplicateTag = errors.New("Duplicate tag")
+
+	ErrInvalidMetadataKey   = errors.New("Invalid metadata key: expected 1-64 characters from 'a-zA-Z0-9_-' set")
+	ErrTooManyMetadataKeys  = errors.New("Too many metadata keys: at most 32 keys are allowed")
+	ErrMetadataValueTooLong = errors.New("Metadata value too long: at most 1024 characters are allowed")
+
+	ErrComposeMissingName     = errors.New("Missing artifact name")
+	ErrComposeArtifactsCount  = errors.New("Between 2 and 16 artifacts can be composed")
+	ErrComposeInvalidArtifact = errors.New("Invalid artifact ID: expected UUIDv4")
+	ErrComposeDuplicate       = errors.New("Duplicate artifact")
+
+	ErrPendingMissingDeviceTypes = errors.New("Missing compatible device types")
+	ErrPendingInvalidDeviceType  = errors.New("Invalid device type: expected 1-4096 characters")
+
+	ErrEmptyDeviceType = errors.New("Invalid device type: empty")
+
+	ErrInvalidDelta = errors.New("Invalid delta: names of the artifacts it is applied to and results in are required and have to differ")
+
+	ErrInvalidSizeRange  = errors.New("Invalid size range: sizes can't be negative and the minimum can't exceed the maximum")
+	ErrInvalidSort       = errors.New("Invalid sort order: expected 'size:asc' or 'size:desc'")
+	ErrInvalidPagination = errors.New("Cursor pagination can't be combined with the sort order nor the latest artifacts per device type")
+	ErrUnknownField      = errors.New("Unknown artifact field")
+
+	tagRegexp = regexp.MustCompile("^[a-zA-Z0-9_.:-]+$")
+	// metadata keys are part of the storage paths and the query parameters,
+	// so the dots are not allowed
+	metadataKeyRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+)
+
+// MissingFieldsError lists the required metadata fields which are not set,
+// named as in the API.
+type MissingFieldsError struct {
+	Fields []string
+}
+
+func (e *MissingFieldsError) Error() string {
+	return fmt.Sprintf("Missing required metadata fields: %s",
+		strings.Join(e.Fields, ", "))
+}
+
+// Details lists the missing fields as the FieldErrors.
+func (e *MissingFieldsError) Details() interface{} {
+	return missingFieldErrors(e.Fields)
+}
+
+func missingFieldErrors(fields []string) []FieldError {
+	fieldErrors := make([]FieldError, 0, len(fields))
+	for _, field := range fields {
+		fieldErrors = append(fieldErrors, FieldError{
+			Field:   field,
+			Message: "Missing required field",
+		})
+	}
+	return fieldErrors
+}
+
+// NameError describes the artifact name rejected by the validation,
+// the field is named as in the API.
+type NameError struct {
+	Field  string
+	Reason string
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("Invalid %s: %s", e.Field, e.Reason)
+}
+
+// Details tells the rejected field as the FieldError.
+func (e *NameError) Details() interface{} {
+	return []FieldError{{Field: e.Field, Message: e.Reason}}
+}
+
+// FieldError is the problem with a single field of the request. The field
+// is named as in the API, with the path to the item if the field is
+// a collection, e.g. 'tags[1]' or 'metadata.build_url'.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists all the problems found by the validation,
+// so that the clients can point out each of the invalid fields.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		messages = append(messages, f.Field+": "+f.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Details lists the problems as the FieldErrors.
+func (e *ValidationError) Details() interface{} {
+	return e.Fields
+}
+
+// add records the problem with the field.
+func (e *ValidationError) add(field string, err error) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: err.Error()})
+}
+
+// errorOrNil returns the error if any problems were found, nil otherwise.
+func (e *ValidationError) errorOrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidateName checks if the artifact name is safe to be used in the object
+// keys and logs: names are limited to MaxNameLength bytes of letters, digits,
+// spaces and ._+-:,()[]@#~= punctuation. Empty names are left to the callers.
+func ValidateName(field, name string) error {
+	if len(name) > MaxNameLength {
+		return &NameError{
+			Field:  field,
+			Reason: fmt.Sprintf("expected at most %d characters", MaxNameLength),
+		}
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return &NameError{Field: field, Reason: "path separators are not allowed"}
+	}
+	if !nameCharacters.MatchString(name) {
+		return &NameError{
+			Field:  field,
+			Reason: "only letters, digits, spaces and ._+-:,()[]@#~= are allowed",
+		}
+	}
+	return nil
+}
+
+// ValidateNamePattern checks if the artifact name matches NamePattern,
+// the naming convention enforced for the new artifacts and deployments.
+func ValidateNamePattern(field, name string) error {
+	if NamePattern == nil || NamePattern.MatchString(name) {
+		return nil
+	}
+	return &NameError{
+		Field:  field,
+		Reason: fmt.Sprintf("expected to match pattern %s", NamePattern),
+	}
+}
+
+// NormalizeDeviceType trims and lowercases the device type, so that the device
+// types of the artifacts and the ones reported by the devices match
+// regardless of the letter case and the surrounding whitespace.
+func NormalizeDeviceType(deviceType string) string {
+	return strings.ToLower(strings.TrimSpace(deviceType))
+}
+
+// NormalizeDeviceTypes normalizes the device types, dropping the duplicates
+// resulting from the normalization.
+func NormalizeDeviceTypes(deviceTypes []string) []string {
+	if deviceTypes == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(deviceTypes))
+	normalized := make([]string, 0, len(deviceTypes))
+	for _, deviceType := range deviceTypes {
+		deviceType = NormalizeDeviceType(deviceType)
+		if !seen[deviceType] {
+			seen[deviceType] = true
+			normalized = append(normalized, deviceType)
+		}
+	}
+	return normalized
+}
+
 // Informations provided by the user
 type SoftwareImageMetaConstructor struct {
 	// Image description
-	Description string `json:"description,omitempty" valid:"length(1|4096),optional"`
+	Description string `json:"description,omitempty" xml:"description,omitempty" valid:"length(1|4096),optional"`
+
+	// Free form tags annotating the image
+	Tags []string `json:"tags,omitempty" bson:"tags,omitempty" xml:"tags>tag,omitempty" valid:"-"`
+
+	// Time the image expires at, expired images are removed; never if not set
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty" xml:"expires_at,omitempty" valid:"-"`
+
+	// Custom key-value pairs annotating the image, e.g. the build URL
+	Metadata map[string]string `json:"metadata,omitempty" bson:"metadata,omitempty" xml:"-" valid:"-"`
 }
 
 // Creates new, empty SoftwareImageMetaConstructor
@@ -31,53 +316,508 @@ func NewSoftwareImageMetaConstructor() *SoftwareImageMetaConstructor {
 	return &SoftwareImageMetaConstructor{}
 }
 
-// Validate checkes structure according to valid tags.
+// Validate checks the fields required by RequiredMetaFields are set,
+// and the structure according to valid tags. All the problems found
+// are listed by the returned ValidationError.
 func (s *SoftwareImageMetaConstructor) Validate() error {
-	_, err := govalidator.ValidateStruct(s)
-	return err
+	verr := &ValidationError{Fields: missingFieldErrors(s.missingFields())}
+	s.validateFields(verr)
+	return verr.errorOrNil()
+}
+
+// ValidateFields checkes structure according to valid tags, regardless
+// of the required fields, e.g. of the partial metadata. All the problems
+// found are listed by the returned ValidationError.
+func (s *SoftwareImageMetaConstructor) ValidateFields() error {
+	verr := &ValidationError{}
+	s.validateFields(verr)
+	return verr.errorOrNil()
+}
+
+func (s *SoftwareImageMetaConstructor) validateFields(verr *ValidationError) {
+	if _, err := govalidator.ValidateStruct(s); err != nil {
+		errs, ok := err.(govalidator.Errors)
+		if !ok {
+			errs = govalidator.Errors{err}
+		}
+		for _, fieldErr := range errs {
+			if e, ok := fieldErr.(govalidator.Error); ok {
+				verr.add(metaFieldName(e.Name), e.Err)
+			} else {
+				verr.add("", fieldErr)
+			}
+		}
+	}
+
+	validateTags(verr, s.Tags)
+	validateMetadata(verr, s.Metadata)
+}
+
+// metaFieldName returns the API name of the field of the metadata.
+func metaFieldName(name string) string {
+	field, ok := reflect.TypeOf(SoftwareImageMetaConstructor{}).FieldByName(name)
+	if !ok {
+		return name
+	}
+	if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag != "" {
+		return tag
+	}
+	return name
+}
+
+// ValidateRequired checks the fields required by RequiredMetaFields are set,
+// all the missing ones are listed by the returned MissingFieldsError.
+func (s *SoftwareImageMetaConstructor) ValidateRequired() error {
+	if missing := s.missingFields(); len(missing) > 0 {
+		return &MissingFieldsError{Fields: missing}
+	}
+	return nil
+}
+
+// missingFields returns the fields required by RequiredMetaFields,
+// which are not set.
+func (s *SoftwareImageMetaConstructor) missingFields() []string {
+	var missing []string
+	for _, field := range RequiredMetaFields {
+		set := true
+		switch field {
+		case "description":
+			set = s.Description != ""
+		case "tags":
+			set = len(s.Tags) > 0
+		case "expires_at":
+			set = s.ExpiresAt != nil
+		case "metadata":
+			set = len(s.Metadata) > 0
+		}
+		if !set {
+			missing = append(missing, field)
+		}
+	}
+	return missing
+}
+
+// ValidateTag checks if the tag is query safe.
+func ValidateTag(tag string) error {
+	if len(tag) > MaxTagLength || !tagRegexp.MatchString(tag) {
+		return ErrInvalidTag
+	}
+	return nil
+}
+
+// ValidateTags checks the tags and their count, all the problems found
+// are listed by the returned ValidationError.
+func ValidateTags(tags []string) error {
+	verr := &ValidationError{}
+	validateTags(verr, tags)
+	return verr.errorOrNil()
+}
+
+func validateTags(verr *ValidationError, tags []string) {
+	if len(tags) > MaxTagsCount {
+		verr.add("tags", ErrTooManyTags)
+	}
+
+	seen := make(map[string]bool, len(tags))
+	for i, tag := range tags {
+		field := fmt.Sprintf("tags[%d]", i)
+		if err := ValidateTag(tag); err != nil {
+			verr.add(field, err)
+		} else if seen[tag] {
+			verr.add(field, ErrDuplicateTag)
+		}
+		seen[tag] = true
+	}
+}
+
+// ValidateMetadataKey checks if the metadata key is query safe.
+func ValidateMetadataKey(key string) error {
+	if len(key) > MaxMetadataKeyLength || !metadataKeyRegexp.MatchString(key) {
+		return ErrInvalidMetadataKey
+	}
+	return nil
+}
+
+// ValidateMetadata checks the metadata keys, their count and the length
+// of the values, all the problems found are listed by the returned
+// ValidationError, in the order of the keys.
+func ValidateMetadata(metadata map[string]string) error {
+	verr := &ValidationError{}
+	validateMetadata(verr, metadata)
+	return verr.errorOrNil()
+}
+
+func validateMetadata(verr *ValidationError, metadata map[string]string) {
+	if len(metadata) > MaxMetadataKeysCount {
+		verr.add("metadata", ErrTooManyMetadataKeys)
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field := "metadata." + key
+		if err := ValidateMetadataKey(key); err != nil {
+			verr.add(field, err)
+		} else if len(metadata[key]) > MaxMetadataValueLength {
+			verr.add(field, ErrMetadataValueTooLong)
+		}
+	}
+}
+
+// SoftwareImageMetaPatch is a partial update of user provided image
+// metadata. Only fields which are set are changed.
+type SoftwareImageMetaPatch struct {
+	// Image description
+	Description *string `json:"description"`
+
+	// Image tags, replace the current ones
+	Tags *[]string `json:"tags"`
+
+	// Image expiry time, replaces the current one
+	ExpiresAt *time.Time `json:"expires_at"`
+
+	// Image custom metadata, replaces the current one
+	Metadata *map[string]string `json:"metadata"`
+
+	// Moves the image to the deprecated lifecycle state, or out of it;
+	// not part of the metadata, applied by the images model
+	Deprecated *bool `json:"deprecated"`
+}
+
+// Validate checks the fields which are set.
+func (p *SoftwareImageMetaPatch) Validate() error {
+	var meta SoftwareImageMetaConstructor
+	p.Apply(&meta)
+	return meta.ValidateFields()
+}
+
+// Apply updates the metadata with the fields which are set.
+func (p *SoftwareImageMetaPatch) Apply(meta *SoftwareImageMetaConstructor) {
+	if p.Description != nil {
+		meta.Description = *p.Description
+	}
+	if p.Tags != nil {
+		meta.Tags = *p.Tags
+	}
+	if p.ExpiresAt != nil {
+		meta.ExpiresAt = p.ExpiresAt
+	}
+	if p.Metadata != nil {
+		meta.Metadata = *p.Metadata
+	}
+}
+
+// SoftwareImageClone describes the copy of an existing image.
+// Artifact name is part of the artifact file, so it is kept as is.
+type SoftwareImageClone struct {
+	// Tenant the copy is created for, the tenant of the image if empty
+	TenantID string `json:"tenant_id"`
+
+	// User provided metadata of the copy, copied from the image if not set
+	SoftwareImageMetaPatch
+}
+
+// Validate checks the metadata fields which are set.
+func (c *SoftwareImageClone) Validate() error {
+	return c.SoftwareImageMetaPatch.Validate()
+}
+
+// SoftwareImageCompose describes the image composed of existing images,
+// e.g. a base image followed by overlays. Updates of the composed images
+// are put into the new artifact in the given order.
+type SoftwareImageCompose struct {
+	// Name of the composed artifact
+	Name string `json:"name"`
+
+	// IDs of the composed images
+	Artifacts []string `json:"artifacts"`
+
+	// User provided metadata of the composed image
+	SoftwareImageMetaConstructor
+}
+
+// Validate checks the name, the composed images and the metadata.
+func (c *SoftwareImageCompose) Validate() error {
+	if c.Name == "" {
+		return ErrComposeMissingName
+	}
+	if err := ValidateName("name", c.Name); err != nil {
+		return err
+	}
+	if err := ValidateNamePattern("name", c.Name); err != nil {
+		return err
+	}
+	if len(c.Artifacts) < MinComposedArtifacts || len(c.Artifacts) > MaxComposedArtifacts {
+		return ErrComposeArtifactsCount
+	}
+
+	seen := make(map[string]bool, len(c.Artifacts))
+	for _, id := range c.Artifacts {
+		if !govalidator.IsUUIDv4(id) {
+			return ErrComposeInvalidArtifact
+		}
+		if seen[id] {
+			return ErrComposeDuplicate
+		}
+		seen[id] = true
+	}
+
+	return c.SoftwareImageMetaConstructor.Validate()
+}
+
+// SoftwareImagePending describes the image registered ahead of its artifact
+// file, e.g. for an approval. The file is uploaded later and has to match
+// the artifact name.
+type SoftwareImagePending struct {
+	// Name of the artifact
+	Name string `json:"name"`
+
+	// Device types the artifact is going to be compatible with
+	DeviceTypesCompatible []string `json:"device_types_compatible"`
+
+	// User provided metadata of the image
+	SoftwareImageMetaConstructor
+}
+
+// Validate checks the name, the device types and the metadata.
+// The device types are normalized.
+func (p *SoftwareImagePending) Validate() error {
+	if p.Name == "" {
+		return ErrComposeMissingName
+	}
+	if err := ValidateName("name", p.Name); err != nil {
+		return err
+	}
+	if err := ValidateNamePattern("name", p.Name); err != nil {
+		return err
+	}
+	if len(p.DeviceTypesCompatible) == 0 {
+		return ErrPendingMissingDeviceTypes
+	}
+	p.DeviceTypesCompatible = NormalizeDeviceTypes(p.DeviceTypesCompatible)
+	for _, deviceType := range p.DeviceTypesCompatible {
+		if deviceType == "" || len(deviceType) > MaxNameLengthLimit {
+			return ErrPendingInvalidDeviceType
+		}
+	}
+
+	return p.SoftwareImageMetaConstructor.Validate()
+}
+
+// ImagesLookup is the result of fetching multiple images by ID at once
+type ImagesLookup struct {
+	XMLName xml.Name `json:"-" xml:"lookup"`
+
+	// Found images, in the order of the requested IDs
+	Artifacts []*SoftwareImage `json:"artifacts" xml:"artifacts>artifact"`
+
+	// Requested IDs which were not found
+	Missing []string `json:"missing" xml:"missing>id"`
+}
+
+// DeviceTypeCount tells how many images are compatible with the device type
+type DeviceTypeCount struct {
+	XMLName    xml.Name `json:"-" bson:"-" xml:"device_type"`
+	DeviceType string   `json:"device_type" bson:"_id" xml:"name"`
+	Count      int      `json:"count" bson:"count" xml:"count"`
+}
+
+// Sort orders of the listed images
+const (
+	SortBySizeAsc  = "size:asc"
+	SortBySizeDesc = "size:desc"
+)
+
+// ListFields are the artifact fields which can be selected for listing
+var ListFields = []string{
+	"id",
+	"name",
+	"description",
+	"tags",
+	"expires_at",
+	"metadata",
+	"device_types_compatible",
+	"info",
+	"signed",
+	"verified_by",
+	"updates",
+	"modified",
+	"created",
+	"size",
+	"checksum",
+	"checksums",
+	"integrity",
+	"scan",
+	"download_count",
+	"last_downloaded",
+	"delta",
+	"status",
+	"state",
+	"replicas",
+	"shared_with",
+}
+
+// DefaultListFields are listed if the field selection is empty
+var DefaultListFields = []string{
+	"id",
+	"name",
+	"device_types_compatible",
+	"modified",
+}
+
+// ImagesFilter narrows down the listed images
+type ImagesFilter struct {
+	// Images having all the tags
+	Tags []string
+
+	// Images compatible with the device type
+	DeviceType string
+
+	// Images of the artifact file of the SHA256 checksum (hex encoded)
+	Checksum string
+
+	// Images having all the custom metadata key-value pairs
+	Metadata map[string]string
+
+	// Images of the artifact file size in bytes within the range,
+	// zero means no limit
+	MinSize int64
+	MaxSize int64
+
+	// Images with the IDs, any if empty
+	IDs []string
+
+	// Images of the artifact name
+	Name string
+
+	// Images shared with the tenant
+	SharedWith string
+
+	// Sort order, one of SortBy* constants; unordered if empty
+	Sort string
+
+	// Only the most recently modified of the matching images for each
+	// compatible device type, ordered by device type unless sorted
+	LatestPerDeviceType bool
+
+	// Only the fields of ListFields set are listed, the others are left
+	// empty; all the fields if nil. The id is always listed.
+	Fields []string
+
+	// At most Limit images, ordered by ID, with the ID greater than After
+	// if set, for the cursor pagination; no limit if zero
+	Limit int
+	After string
+}
+
+// Validate checks the size range, the checksum, the sort order and
+// the pagination.
+// The device type and the checksum are normalized.
+func (f *ImagesFilter) Validate() error {
+	f.DeviceType = NormalizeDeviceType(f.DeviceType)
+
+	if f.Checksum != "" {
+		// checksums are stored lower case
+		f.Checksum = strings.ToLower(f.Checksum)
+		if err := (Checksums{ChecksumSHA256: f.Checksum}).Validate(); err != nil {
+			return err
+		}
+	}
+
+	if f.MinSize < 0 || f.MaxSize < 0 ||
+		(f.MaxSize > 0 && f.MinSize > f.MaxSize) {
+		return ErrInvalidSizeRange
+	}
+
+	switch f.Sort {
+	case "", SortBySizeAsc, SortBySizeDesc:
+	default:
+		return ErrInvalidSort
+	}
+
+	// the pages are ordered by ID
+	if f.Limit > 0 && (f.Sort != "" || f.LatestPerDeviceType) {
+		return ErrInvalidPagination
+	}
+
+	for _, field := range f.Fields {
+		known := false
+		for _, listField := range ListFields {
+			if field == listField {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return ErrUnknownField
+		}
+	}
+
+	return nil
 }
 
 // Structure with artifact version informations
 type ArtifactInfo struct {
 	// Mender artifact format - the only possible value is "mender"
 	//Format string `json:"format" valid:"string,equal("mender"),required"`
-	Format string `json:"format" valid:"required"`
+	Format string `json:"format" xml:"format" valid:"required"`
 
 	// Mender artifact format version
 	//Version uint `json:"version" valid:"uint,equal(1),required"`
-	Version uint `json:"version" valid:"required"`
+	Version uint `json:"version" xml:"version" valid:"required"`
 }
 
 // Information provided with YOCTO image
 type SoftwareImageMetaArtifactConstructor struct {
 	// artifact_name from artifact file
-	Name string `json:"name" bson:"name" valid:"length(1|4096),required"`
+	Name string `json:"name" bson:"name" xml:"name" valid:"length(1|4096),required"`
 
 	// Compatible device types for the application
-	DeviceTypesCompatible []string `json:"device_types_compatible" bson:"device_types_compatible" valid:"length(1|4096),required"`
+	DeviceTypesCompatible []string `json:"device_types_compatible" bson:"device_types_compatible" xml:"device_types_compatible>device_type" valid:"length(1|4096),required"`
 
 	// Artifact version info
-	Info *ArtifactInfo `json:"info" valid:"required"`
+	Info *ArtifactInfo `json:"info" xml:"info" valid:"required"`
 
 	// Flag that indicates if artifact is signed or not
-	Signed bool `json:"signed" bson:"signed"`
+	Signed bool `json:"signed" bson:"signed" xml:"signed"`
+
+	// Fingerprint of the trusted key the signature was verified with on upload
+	VerifiedBy string `json:"verified_by,omitempty" bson:"verified_by,omitempty" xml:"verified_by,omitempty" valid:"-"`
 
 	// List of updates
-	Updates []Update `json:"updates" valid:"-"`
+	Updates []Update `json:"updates" xml:"updates>update" valid:"-"`
 }
 
 func NewSoftwareImageMetaArtifactConstructor() *SoftwareImageMetaArtifactConstructor {
 	return &SoftwareImageMetaArtifactConstructor{}
 }
 
-// Validate checkes structure according to valid tags.
+// Validate checkes structure according to valid tags, the artifact name
+// and the device types; the device types are normalized.
 func (s *SoftwareImageMetaArtifactConstructor) Validate() error {
-	_, err := govalidator.ValidateStruct(s)
-	return err
+	s.DeviceTypesCompatible = NormalizeDeviceTypes(s.DeviceTypesCompatible)
+	for _, deviceType := range s.DeviceTypesCompatible {
+		if deviceType == "" {
+			return ErrEmptyDeviceType
+		}
+	}
+	if _, err := govalidator.ValidateStruct(s); err != nil {
+		return err
+	}
+	if err := ValidateName("name", s.Name); err != nil {
+		return err
+	}
+	return ValidateNamePattern("name", s.Name)
 }
 
 // SoftwareImage YOCTO image with user application
 type SoftwareImage struct {
+	// Root element name for XML representation
+	XMLName xml.Name `json:"-" bson:"-" xml:"artifact"`
+
 	// User provided field set
 	SoftwareImageMetaConstructor `bson:"meta"`
 
@@ -85,10 +825,216 @@ type SoftwareImage struct {
 	SoftwareImageMetaArtifactConstructor `bson:"meta_artifact"`
 
 	// Image ID
-	Id string `json:"id" bson:"_id" valid:"uuidv4,required"`
+	Id string `json:"id" bson:"_id" xml:"id" valid:"uuidv4,required"`
 
 	// Last modification time, including image upload time
-	Modified *time.Time `json:"modified" valid:"_"`
+	Modified *time.Time `json:"modified" xml:"modified" valid:"_"`
+
+	// Creation time, not known for the images created before the creation
+	// time was recorded
+	Created *time.Time `json:"created,omitempty" bson:"created,omitempty" xml:"created,omitempty" valid:"-"`
+
+	// Size of the artifact file in bytes, not known for the artifacts
+	// uploaded before the size was recorded
+	Size int64 `json:"size,omitempty" bson:"size,omitempty" xml:"size,omitempty" valid:"-"`
+
+	// SHA256 checksum (hex encoded) of the artifact file, not known for the
+	// artifacts uploaded before the checksum was recorded
+	Checksum string `json:"checksum,omitempty" bson:"checksum,omitempty" xml:"checksum,omitempty" valid:"-"`
+
+	// Checksums of the artifact file by algorithm, not known for the
+	// artifacts uploaded before the checksums were recorded
+	Checksums Checksums `json:"checksums,omitempty" bson:"checksums,omitempty" xml:"-" valid:"-"`
+
+	// Result of the last integrity verification of the stored artifact file
+	Integrity *ArtifactIntegrity `json:"integrity,omitempty" bson:"integrity,omitempty" xml:"integrity,omitempty" valid:"-"`
+
+	// Result of the malware scan of the artifact file, set only when
+	// the scanning is enabled
+	Scan *ArtifactScan `json:"scan,omitempty" bson:"scan,omitempty" xml:"scan,omitempty" valid:"-"`
+
+	// Number of download links generated for the artifact file
+	DownloadCount int64 `json:"download_count" bson:"download_count,omitempty" xml:"download_count" valid:"-"`
+
+	// Time the last download link was generated
+	LastDownloaded *time.Time `json:"last_downloaded,omitempty" bson:"last_downloaded,omitempty" xml:"last_downloaded,omitempty" valid:"-"`
+
+	// Key of the artifact file in the file storage
+	ObjectKey string `json:"-" bson:"object_key,omitempty" xml:"-" valid:"-"`
+
+	// Storage regions the artifact file has been replicated to, besides
+	// the primary one
+	Replicas []string `json:"replicas,omitempty" bson:"replicas,omitempty" xml:"replicas>region,omitempty" valid:"-"`
+
+	// Version of the artifact file in the primary file storage the download
+	// links are pinned to, so that they can be revoked by rotating the file;
+	// nil until the first link, empty if the storage is not versioned
+	FileVersion *string `json:"-" bson:"file_version,omitempty" xml:"-" valid:"-"`
+
+	// Versions of the replicas of the artifact file by region, as FileVersion
+	ReplicaVersions map[string]string `json:"-" bson:"replica_versions,omitempty" xml:"-" valid:"-"`
+
+	// Set for the delta artifacts only
+	Delta *DeltaUpdate `json:"delta,omitempty" bson:"delta,omitempty" xml:"delta,omitempty" valid:"-"`
+
+	// One of ImageStatus* constants
+	Status string `json:"status,omitempty" bson:"status,omitempty" xml:"status,omitempty" valid:"-"`
+
+	// One of ImageState* constants
+	State string `json:"state,omitempty" bson:"state,omitempty" xml:"state,omitempty" valid:"-"`
+
+	// Time and the user of the last lifecycle state change
+	StateModified   *time.Time `json:"state_modified,omitempty" bson:"state_modified,omitempty" xml:"state_modified,omitempty" valid:"-"`
+	StateModifiedBy string     `json:"state_modified_by,omitempty" bson:"state_modified_by,omitempty" xml:"state_modified_by,omitempty" valid:"-"`
+
+	// Tenants the image is shared with by the tenant owning it
+	SharedWith []string `json:"shared_with,omitempty" bson:"shared_with,omitempty" xml:"shared_with>tenant,omitempty" valid:"-"`
+
+	// Tenant owning the image, set only when the image is shared with
+	// the tenant it is read for
+	Owner string `json:"owner,omitempty" bson:"-" xml:"owner,omitempty" valid:"-"`
+
+	// Version of the schema the image was stored with, see Upgrade
+	SchemaVersion int `json:"-" bson:"schema_version,omitempty" xml:"-" valid:"-"`
+}
+
+// MarshalXML renders the image with the custom metadata and the checksums,
+// which as maps have no default XML representation.
+func (s SoftwareImage) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	// same fields, without the marshaler
+	type softwareImage SoftwareImage
+
+	start.Name = xml.Name{Local: "artifact"}
+	return e.EncodeElement(struct {
+		softwareImage
+		Metadata  *xmlEntries `xml:"metadata,omitempty"`
+		Checksums *xmlEntries `xml:"checksums,omitempty"`
+	}{
+		softwareImage: softwareImage(s),
+		Metadata:      newXMLEntries(s.Metadata),
+		Checksums:     newXMLEntries(s.Checksums),
+	}, start)
+}
+
+// xmlEntries is the XML representation of the map.
+type xmlEntries struct {
+	Entries []xmlEntry `xml:"entry"`
+}
+
+// xmlEntry is the XML representation of the map entry.
+type xmlEntry struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// newXMLEntries lists the entries of the map sorted by key, nil for
+// the empty map.
+func newXMLEntries(m map[string]string) *xmlEntries {
+	if len(m) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := &xmlEntries{Entries: make([]xmlEntry, 0, len(keys))}
+	for _, key := range keys {
+		entries.Entries = append(entries.Entries, xmlEntry{Key: key, Value: m[key]})
+	}
+	return entries
+}
+
+// DeltaUpdate describes the delta artifact, which can be installed only
+// on the devices having the From artifact installed, and results in the To
+// artifact installed.
+type DeltaUpdate struct {
+	// Name of the artifact the delta is applied to
+	From string `json:"from" bson:"from" xml:"from"`
+
+	// Name of the artifact the delta results in
+	To string `json:"to" bson:"to" xml:"to"`
+}
+
+// Validate checks that both artifact names are given and differ.
+func (d *DeltaUpdate) Validate() error {
+	if d.From == "" || d.To == "" || d.From == d.To {
+		return ErrInvalidDelta
+	}
+	if err := ValidateName("delta_from", d.From); err != nil {
+		return err
+	}
+	return ValidateName("delta_to", d.To)
+}
+
+// ArtifactIntegrity is the outcome of re-verifying the stored artifact file
+// against the payload checksums recorded on upload.
+type ArtifactIntegrity struct {
+	// Time of the verification
+	Checked *time.Time `json:"checked" bson:"checked" xml:"checked"`
+
+	// Flag that indicates the stored file no longer matches the recorded checksums
+	Corrupted bool `json:"corrupted" bson:"corrupted" xml:"corrupted"`
+
+	// Reason of the mismatch
+	Reason string `json:"reason,omitempty" bson:"reason,omitempty" xml:"reason,omitempty"`
+}
+
+// ArtifactScan is the outcome of scanning the artifact file for malware.
+type ArtifactScan struct {
+	// Time of the scan
+	Scanned *time.Time `json:"scanned" bson:"scanned" xml:"scanned"`
+
+	// Name of the threat found, empty if the artifact file is clean
+	Threat string `json:"threat,omitempty" bson:"threat,omitempty" xml:"threat,omitempty"`
+
+	// Reason the scan failed, the artifact is quarantined then
+	Error string `json:"error,omitempty" bson:"error,omitempty" xml:"error,omitempty"`
+}
+
+// NewArtifactScan records the scan done now, which found the threat,
+// or failed with the error.
+func NewArtifactScan(threat string, err error) *ArtifactScan {
+	now := time.Now()
+	scan := &ArtifactScan{Scanned: &now, Threat: threat}
+	if err != nil {
+		scan.Error = err.Error()
+	}
+	return scan
+}
+
+// IsClean tells if the scan completed and found no threat.
+func (s *ArtifactScan) IsClean() bool {
+	return s.Threat == "" && s.Error == ""
+}
+
+// IntegrityReport summarizes the integrity verification of the artifacts of a tenant.
+type IntegrityReport struct {
+	// Number of verified artifacts
+	Checked int `json:"checked"`
+
+	// Number of artifacts which could not be verified, e.g. due to storage errors
+	Skipped int `json:"skipped"`
+
+	// IDs of the artifacts flagged as corrupted
+	Corrupted []string `json:"corrupted"`
+}
+
+// DeleteReport summarizes the removal of the artifacts of a device type.
+type DeleteReport struct {
+	// Number of deleted artifacts
+	Deleted int `json:"deleted"`
+
+	// Number of artifacts which were not deleted, being used in active deployments
+	Skipped int `json:"skipped"`
+
+	// IDs of the skipped artifacts
+	InUse []string `json:"in_use"`
+
+	// Number of active deployments aborted to delete the artifacts
+	AbortedDeployments int `json:"aborted_deployments"`
 }
 
 // NewSoftwareImage creates new software image object.
@@ -102,11 +1048,105 @@ func NewSoftwareImage(
 	return &SoftwareImage{
 		SoftwareImageMetaConstructor:         *metaConstructor,
 		SoftwareImageMetaArtifactConstructor: *metaArtifactConstructor,
-		Modified: &now,
-		Id:       id,
+		Modified:      &now,
+		Created:       &now,
+		Id:            id,
+		Status:        ImageStatusReady,
+		State:         ImageStateUploaded,
+		SchemaVersion: ImageSchemaVersion,
 	}
 }
 
+// NewPendingSoftwareImage creates new software image object, which artifact
+// file is not uploaded yet.
+func NewPendingSoftwareImage(id string, pending *SoftwareImagePending) *SoftwareImage {
+	image := NewSoftwareImage(id, &pending.SoftwareImageMetaConstructor,
+		&SoftwareImageMetaArtifactConstructor{
+			Name:                  pending.Name,
+			DeviceTypesCompatible: pending.DeviceTypesCompatible,
+		})
+	image.Status = ImageStatusPending
+	return image
+}
+
+// IsPending tells if the artifact file of the image is not uploaded yet.
+func (s *SoftwareImage) IsPending() bool {
+	return s.Status == ImageStatusPending
+}
+
+// IsAvailable tells if the artifact file of the image is uploaded, and
+// neither being scanned nor quarantined, so that it can be deployed.
+func (s *SoftwareImage) IsAvailable() bool {
+	for _, status := range UnavailableImageStatuses {
+		if s.Status == status {
+			return false
+		}
+	}
+	return true
+}
+
+// IsQuarantined tells if malware was found in the artifact file.
+func (s *SoftwareImage) IsQuarantined() bool {
+	return s.Status == ImageStatusQuarantined
+}
+
+// IsShared tells if the image is owned by another tenant than the one
+// it is read for.
+func (s *SoftwareImage) IsShared() bool {
+	return s.Owner != ""
+}
+
+// LifecycleState returns the lifecycle state of the image, the images
+// created before the states were introduced are considered uploaded.
+func (s *SoftwareImage) LifecycleState() string {
+	if s.State == "" {
+		return ImageStateUploaded
+	}
+	return s.State
+}
+
+// IsApproved tells if the image was approved for the deployments.
+func (s *SoftwareImage) IsApproved() bool {
+	return s.LifecycleState() == ImageStateApproved
+}
+
+// CanChangeState tells if the image can be moved to the given lifecycle state.
+func (s *SoftwareImage) CanChangeState(state string) bool {
+	for _, to := range imageStateTransitions[s.LifecycleState()] {
+		if to == state {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDeprecated tells if the image is in the deprecated lifecycle state.
+func (s *SoftwareImage) IsDeprecated() bool {
+	return s.LifecycleState() == ImageStateDeprecated
+}
+
+// IsExpired tells if the image expired by the given time.
+func (s *SoftwareImage) IsExpired(now time.Time) bool {
+	return s.ExpiresAt != nil && !s.ExpiresAt.After(now)
+}
+
+// FileObjectKey returns the key of the image file of the tenant.
+// Images stored before the layout was configurable have no key recorded,
+// their files are stored with the default layout.
+func (s *SoftwareImage) FileObjectKey(tenant string) string {
+	if s.ObjectKey != "" {
+		return s.ObjectKey
+	}
+	return ObjectKey(tenant, s.Id)
+}
+
+// SetChecksums records the checksums of the image file, SHA256 one is also
+// kept as the checksum of the artifacts recorded before.
+func (s *SoftwareImage) SetChecksums(checksums Checksums) {
+	s.Checksums = checksums
+	s.Checksum = checksums[ChecksumSHA256]
+}
+
 // SetModified set last modification time for the image.
 func (s *SoftwareImage) SetModified(time time.Time) {
 	s.Modified = &time
@@ -14,7 +14,15 @@
 
 package images
 
-import "testing"
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
 
 const validUUIDv4 = "d50eda0d-2cea-4de1-8d42-9cd3e7e8670d"
 
@@ -26,6 +34,65 @@ func TestValidateEmptyImageMeta(t *testing.T) {
 	}
 }
 
+func TestValidateRequiredImageMeta(t *testing.T) {
+	RequiredMetaFields = []string{"description", "tags", "expires_at"}
+	defer func() { RequiredMetaFields = nil }()
+
+	expiresAt := time.Now().Add(time.Hour)
+	testCases := []struct {
+		meta    SoftwareImageMetaConstructor
+		missing []string
+	}{
+		{
+			meta:    SoftwareImageMetaConstructor{},
+			missing: []string{"description", "tags", "expires_at"},
+		},
+		{
+			meta:    SoftwareImageMetaConstructor{Description: "foo", Tags: []string{}},
+			missing: []string{"tags", "expires_at"},
+		},
+		{
+			meta: SoftwareImageMetaConstructor{
+				Description: "foo",
+				Tags:        []string{"bar"},
+				ExpiresAt:   &expiresAt,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		err := tc.meta.Validate()
+		if tc.missing == nil {
+			if err != nil {
+				t.Errorf("meta %+v: unexpected error %v", tc.meta, err)
+			}
+			continue
+		}
+		expected := &ValidationError{Fields: missingFieldErrors(tc.missing)}
+		if !reflect.DeepEqual(err, expected) {
+			t.Errorf("meta %+v: expected missing %q, got %v", tc.meta, tc.missing, err)
+		}
+		missingErr, ok := tc.meta.ValidateRequired().(*MissingFieldsError)
+		if !ok || !reflect.DeepEqual(missingErr.Fields, tc.missing) {
+			t.Errorf("meta %+v: expected missing %q, got %v", tc.meta, tc.missing, missingErr)
+		}
+	}
+
+	// partial metadata is not checked for the required fields
+	description := "foo"
+	patch := &SoftwareImageMetaPatch{Description: &description}
+	if err := patch.Validate(); err != nil {
+		t.Errorf("patch: unexpected error %v", err)
+	}
+
+	// only the description is given with the upload
+	upload := &UploadSessionConstructor{Size: 1, Description: "foo"}
+	if err := upload.Validate(); err == nil ||
+		err.Error() != "Missing required metadata fields: tags, expires_at" {
+		t.Errorf("upload: unexpected error %v", err)
+	}
+}
+
 func TestValidateEmptyImageMetaArtifact(t *testing.T) {
 	image := NewSoftwareImageMetaArtifactConstructor()
 
@@ -42,6 +109,170 @@ func TestValidateCorrectImageMeta(t *testing.T) {
 	}
 }
 
+func TestValidateImageMetaFieldNames(t *testing.T) {
+	RequiredMetaFields = []string{"expires_at"}
+	defer func() { RequiredMetaFields = nil }()
+
+	image := NewSoftwareImageMetaConstructor()
+	image.Description = strings.Repeat("a", 4097)
+	image.Tags = []string{"with space"}
+
+	err := image.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+
+	// all the problems are listed, fields named as in the API
+	var fields []string
+	for _, f := range verr.Fields {
+		fields = append(fields, f.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"expires_at", "description", "tags[0]"}) {
+		t.Errorf("unexpected fields %v", verr.Fields)
+	}
+	if !reflect.DeepEqual(verr.Details(), verr.Fields) {
+		t.Errorf("unexpected details %v", verr.Details())
+	}
+}
+
+func TestValidateImageMetaTags(t *testing.T) {
+	tooMany := make([]string, MaxTagsCount+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("tag-%d", i)
+	}
+
+	testCases := []struct {
+		tags   []string
+		fields []FieldError
+	}{
+		{tags: nil},
+		{tags: []string{"stable", "customer-x", "v1.2_rc:3"}},
+		{
+			tags:   []string{""},
+			fields: []FieldError{{"tags[0]", ErrInvalidTag.Error()}},
+		},
+		{
+			tags:   []string{"with space"},
+			fields: []FieldError{{"tags[0]", ErrInvalidTag.Error()}},
+		},
+		{
+			tags:   []string{"stable", "$where"},
+			fields: []FieldError{{"tags[1]", ErrInvalidTag.Error()}},
+		},
+		{
+			tags:   []string{strings.Repeat("a", MaxTagLength+1)},
+			fields: []FieldError{{"tags[0]", ErrInvalidTag.Error()}},
+		},
+		{
+			tags:   []string{"beta", "beta"},
+			fields: []FieldError{{"tags[1]", ErrDuplicateTag.Error()}},
+		},
+		{
+			tags:   tooMany,
+			fields: []FieldError{{"tags", ErrTooManyTags.Error()}},
+		},
+		{
+			// all the problems are listed
+			tags: []string{"with space", "beta", "beta"},
+			fields: []FieldError{
+				{"tags[0]", ErrInvalidTag.Error()},
+				{"tags[2]", ErrDuplicateTag.Error()},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		image := NewSoftwareImageMetaConstructor()
+		image.Tags = tc.tags
+
+		var expected error
+		if tc.fields != nil {
+			expected = &ValidationError{Fields: tc.fields}
+		}
+		if err := image.Validate(); !reflect.DeepEqual(err, expected) {
+			t.Errorf("tags %v: expected error %v, got %v", tc.tags, expected, err)
+		}
+	}
+}
+
+func TestValidateImageMetaMetadata(t *testing.T) {
+	tooMany := make(map[string]string, MaxMetadataKeysCount+1)
+	for i := 0; i <= MaxMetadataKeysCount; i++ {
+		tooMany[fmt.Sprintf("key_%d", i)] = "value"
+	}
+
+	longKey := strings.Repeat("a", MaxMetadataKeyLength+1)
+	testCases := []struct {
+		metadata map[string]string
+		fields   []FieldError
+	}{
+		{metadata: nil},
+		{metadata: map[string]string{"build_url": "https://ci/42", "git-sha": ""}},
+		{
+			metadata: map[string]string{"": "value"},
+			fields:   []FieldError{{"metadata.", ErrInvalidMetadataKey.Error()}},
+		},
+		{
+			metadata: map[string]string{"build.url": "value"},
+			fields:   []FieldError{{"metadata.build.url", ErrInvalidMetadataKey.Error()}},
+		},
+		{
+			metadata: map[string]string{"$where": "value"},
+			fields:   []FieldError{{"metadata.$where", ErrInvalidMetadataKey.Error()}},
+		},
+		{
+			metadata: map[string]string{longKey: "value"},
+			fields:   []FieldError{{"metadata." + longKey, ErrInvalidMetadataKey.Error()}},
+		},
+		{
+			metadata: map[string]string{"git_sha": strings.Repeat("a", MaxMetadataValueLength+1)},
+			fields:   []FieldError{{"metadata.git_sha", ErrMetadataValueTooLong.Error()}},
+		},
+		{
+			metadata: tooMany,
+			fields:   []FieldError{{"metadata", ErrTooManyMetadataKeys.Error()}},
+		},
+		{
+			// all the problems are listed, in the order of the keys
+			metadata: map[string]string{
+				"git_sha":   strings.Repeat("a", MaxMetadataValueLength+1),
+				"build.url": "value",
+			},
+			fields: []FieldError{
+				{"metadata.build.url", ErrInvalidMetadataKey.Error()},
+				{"metadata.git_sha", ErrMetadataValueTooLong.Error()},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		image := NewSoftwareImageMetaConstructor()
+		image.Metadata = tc.metadata
+
+		var expected error
+		if tc.fields != nil {
+			expected = &ValidationError{Fields: tc.fields}
+		}
+		if err := image.Validate(); !reflect.DeepEqual(err, expected) {
+			t.Errorf("metadata %v: expected error %v, got %v", tc.metadata, expected, err)
+		}
+	}
+
+	// metadata is replaced as a whole by the patch
+	image := NewSoftwareImageMetaConstructor()
+	image.Metadata = map[string]string{"build_url": "https://ci/42"}
+	metadata := map[string]string{"git_sha": "abc"}
+	patch := &SoftwareImageMetaPatch{Metadata: &metadata}
+	if err := patch.Validate(); err != nil {
+		t.Errorf("patch: unexpected error %v", err)
+	}
+	patch.Apply(image)
+	if !reflect.DeepEqual(image.Metadata, metadata) {
+		t.Errorf("patch: expected metadata %v, got %v", metadata, image.Metadata)
+	}
+}
+
 func TestValidateCorrectImageMetaYocot(t *testing.T) {
 	image := NewSoftwareImageMetaArtifactConstructor()
 	required := "required"
@@ -58,6 +289,131 @@ func TestValidateCorrectImageMetaYocot(t *testing.T) {
 	}
 }
 
+func TestValidateImageMetaArtifactDeviceTypes(t *testing.T) {
+	testCases := []struct {
+		deviceTypes []string
+		normalized  []string
+		err         error
+	}{
+		{
+			deviceTypes: []string{"raspberrypi3"},
+			normalized:  []string{"raspberrypi3"},
+		},
+		{
+			deviceTypes: []string{"Raspberry Pi3", "raspberry pi3 ", "BeagleBone"},
+			normalized:  []string{"raspberry pi3", "beaglebone"},
+		},
+		{
+			deviceTypes: []string{"raspberrypi3", " "},
+			err:         ErrEmptyDeviceType,
+		},
+	}
+
+	for _, tc := range testCases {
+		image := NewSoftwareImageMetaArtifactConstructor()
+		image.Name = "name"
+		image.DeviceTypesCompatible = tc.deviceTypes
+		image.Info = &ArtifactInfo{Format: "mender", Version: 2}
+
+		err := image.Validate()
+		if err != tc.err {
+			t.Errorf("device types %q: expected error %v, got %v", tc.deviceTypes, tc.err, err)
+		}
+		if err == nil && !reflect.DeepEqual(image.DeviceTypesCompatible, tc.normalized) {
+			t.Errorf("device types %q: expected %q, got %q",
+				tc.deviceTypes, tc.normalized, image.DeviceTypesCompatible)
+		}
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	const invalidCharacters = "only letters, digits, spaces and ._+-:,()[]@#~= are allowed"
+
+	testCases := []struct {
+		name   string
+		reason string
+	}{
+		{name: "release-1.0 (beta) ąę"},
+		{name: "core-image_2.1+git:[rc1],@#~=ü"},
+		{name: strings.Repeat("a", MaxNameLength)},
+		{
+			name:   strings.Repeat("a", MaxNameLength+1),
+			reason: "expected at most 256 characters",
+		},
+		{name: "../release", reason: "path separators are not allowed"},
+		{name: `release\1.0`, reason: "path separators are not allowed"},
+		{name: "release\n1.0", reason: invalidCharacters},
+		{name: "release\x001.0", reason: invalidCharacters},
+		{name: "release\xff", reason: invalidCharacters},
+		{name: "release\u200b1.0", reason: invalidCharacters},
+		{name: "release;rm", reason: invalidCharacters},
+		{name: "release$HOME", reason: invalidCharacters},
+		{name: "release*", reason: invalidCharacters},
+		{name: "release?v=1", reason: invalidCharacters},
+		{name: "release%2F", reason: invalidCharacters},
+		{name: `"release"`, reason: invalidCharacters},
+		{name: "<release>", reason: invalidCharacters},
+		{name: "release|1.0", reason: invalidCharacters},
+		{name: "release&1.0", reason: invalidCharacters},
+		{name: "release 🚀", reason: invalidCharacters},
+	}
+
+	for _, tc := range testCases {
+		err := ValidateName("name", tc.name)
+		if tc.reason == "" {
+			if err != nil {
+				t.Errorf("name %q: unexpected error %v", tc.name, err)
+			}
+			continue
+		}
+		expected := &NameError{Field: "name", Reason: tc.reason}
+		if nameErr, ok := err.(*NameError); !ok || *nameErr != *expected {
+			t.Errorf("name %q: expected error %v, got %v", tc.name, expected, err)
+		}
+	}
+
+	image := NewSoftwareImageMetaArtifactConstructor()
+	image.Name = "foo/bar"
+	image.DeviceTypesCompatible = []string{"required"}
+	image.Info = &ArtifactInfo{Format: "mender", Version: 1}
+	if err := image.Validate(); err == nil ||
+		err.Error() != "Invalid name: path separators are not allowed" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	delta := DeltaUpdate{From: "foo\tbar", To: "bar"}
+	if err := delta.Validate(); err == nil || err.(*NameError).Field != "delta_from" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestValidateNamePattern(t *testing.T) {
+	if err := ValidateNamePattern("name", "anything goes"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	NamePattern = regexp.MustCompile(`^product-\d+\.\d+\.\d+$`)
+	defer func() { NamePattern = nil }()
+
+	if err := ValidateNamePattern("name", "product-1.2.3"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	image := NewSoftwareImageMetaArtifactConstructor()
+	image.Name = "product-1.2"
+	image.DeviceTypesCompatible = []string{"required"}
+	image.Info = &ArtifactInfo{Format: "mender", Version: 1}
+	if err := image.Validate(); err == nil ||
+		err.Error() != `Invalid name: expected to match pattern ^product-\d+\.\d+\.\d+$` {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	compose := SoftwareImageCompose{Name: "release", Artifacts: []string{"a", "b"}}
+	if err := compose.Validate(); err == nil || err.(*NameError).Field != "name" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
 func TestValidateCorrectImage(t *testing.T) {
 	required := "required"
 	imageMeta := NewSoftwareImageMetaConstructor()
@@ -72,3 +428,372 @@ func TestValidateCorrectImage(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestImageMarshalXML(t *testing.T) {
+	image := NewSoftwareImage("id",
+		&SoftwareImageMetaConstructor{
+			Metadata: map[string]string{"url": "http://ci", "build": "1"},
+		},
+		&SoftwareImageMetaArtifactConstructor{Name: "name"})
+	image.Checksums = Checksums{ChecksumSHA256: "e3b0c442"}
+
+	data, err := xml.Marshal(image)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := string(data)
+	for _, expected := range []string{
+		"<artifact>",
+		"<name>name</name>",
+		`<metadata><entry key="build">1</entry><entry key="url">http://ci</entry></metadata>`,
+		`<checksums><entry key="sha256">e3b0c442</entry></checksums>`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %s in %s", expected, out)
+		}
+	}
+
+	image.Metadata = nil
+	image.Checksums = nil
+	data, err = xml.Marshal(image)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(string(data), "<metadata>") || strings.Contains(string(data), "<checksums>") {
+		t.Errorf("expected no metadata and checksums in %s", data)
+	}
+}
+
+func TestImageIsExpired(t *testing.T) {
+	now := time.Now()
+	before := now.Add(-time.Second)
+	after := now.Add(time.Second)
+
+	image := NewSoftwareImage(validUUIDv4, NewSoftwareImageMetaConstructor(),
+		NewSoftwareImageMetaArtifactConstructor())
+	if image.IsExpired(now) {
+		t.Error("image without expiry time expired")
+	}
+
+	patch := SoftwareImageMetaPatch{ExpiresAt: &after}
+	patch.Apply(&image.SoftwareImageMetaConstructor)
+	if image.IsExpired(now) {
+		t.Errorf("image expiring at %v expired at %v", after, now)
+	}
+
+	patch.ExpiresAt = &before
+	patch.Apply(&image.SoftwareImageMetaConstructor)
+	if !image.IsExpired(now) {
+		t.Errorf("image expiring at %v not expired at %v", before, now)
+	}
+	if !image.IsExpired(before) {
+		t.Error("image not expired at its expiry time")
+	}
+}
+
+func TestImageIsDeprecated(t *testing.T) {
+	image := NewSoftwareImage(validUUIDv4, NewSoftwareImageMetaConstructor(),
+		NewSoftwareImageMetaArtifactConstructor())
+	if image.IsDeprecated() {
+		t.Error("new image deprecated")
+	}
+
+	image.State = ImageStateDeprecated
+	if !image.IsDeprecated() {
+		t.Error("image in deprecated state not deprecated")
+	}
+}
+
+func TestImageIsAvailable(t *testing.T) {
+	testCases := map[string]bool{
+		"":                     true,
+		ImageStatusReady:       true,
+		ImageStatusPending:     false,
+		ImageStatusScanning:    false,
+		ImageStatusQuarantined: false,
+	}
+
+	for status, available := range testCases {
+		image := NewSoftwareImage(validUUIDv4, NewSoftwareImageMetaConstructor(),
+			NewSoftwareImageMetaArtifactConstructor())
+		image.Status = status
+		if image.IsAvailable() != available {
+			t.Errorf("image in %q status: expected available %v", status, available)
+		}
+		if image.IsQuarantined() != (status == ImageStatusQuarantined) {
+			t.Errorf("image in %q status: unexpected quarantine", status)
+		}
+	}
+
+	if scan := NewArtifactScan("", nil); !scan.IsClean() || scan.Scanned == nil {
+		t.Errorf("expected clean scan, got %v", scan)
+	}
+	if scan := NewArtifactScan("", fmt.Errorf("timeout")); scan.IsClean() ||
+		scan.Error != "timeout" {
+		t.Errorf("expected failed scan, got %v", scan)
+	}
+}
+
+func TestImageLifecycleState(t *testing.T) {
+	image := NewSoftwareImage(validUUIDv4, NewSoftwareImageMetaConstructor(),
+		NewSoftwareImageMetaArtifactConstructor())
+	if image.LifecycleState() != ImageStateUploaded || image.IsApproved() {
+		t.Errorf("new image in %s state", image.LifecycleState())
+	}
+
+	testCases := []struct {
+		state string
+
+		lifecycleState string
+		allowed        []string
+	}{
+		// recorded before the states were introduced
+		{"", ImageStateUploaded, []string{ImageStateApproved, ImageStateDeprecated}},
+		{ImageStateUploaded, ImageStateUploaded,
+			[]string{ImageStateApproved, ImageStateDeprecated}},
+		{ImageStateApproved, ImageStateApproved, []string{ImageStateDeprecated}},
+		// reviewed again once no longer deprecated
+		{ImageStateDeprecated, ImageStateDeprecated, []string{ImageStateUploaded}},
+	}
+
+	for _, tc := range testCases {
+		image.State = tc.state
+		if image.LifecycleState() != tc.lifecycleState {
+			t.Errorf("image with state %q in %s state", tc.state, image.LifecycleState())
+		}
+		if image.IsApproved() != (tc.lifecycleState == ImageStateApproved) {
+			t.Errorf("image in %s state approved: %v", tc.lifecycleState, image.IsApproved())
+		}
+		for _, to := range []string{ImageStateUploaded, ImageStateApproved, ImageStateDeprecated} {
+			allowed := false
+			for _, a := range tc.allowed {
+				allowed = allowed || a == to
+			}
+			if image.CanChangeState(to) != allowed {
+				t.Errorf("image in %s state can change to %s: %v",
+					tc.lifecycleState, to, image.CanChangeState(to))
+			}
+		}
+	}
+}
+
+func TestValidateImageCompose(t *testing.T) {
+	other := "0c17d9ad-6d1b-4a83-9bb1-5a5a2ea9b9f6"
+
+	testCases := []struct {
+		compose SoftwareImageCompose
+		err     error
+	}{
+		{
+			compose: SoftwareImageCompose{
+				Name:      "composed",
+				Artifacts: []string{validUUIDv4, other},
+			},
+		},
+		{
+			compose: SoftwareImageCompose{
+				Artifacts: []string{validUUIDv4, other},
+			},
+			err: ErrComposeMissingName,
+		},
+		{
+			compose: SoftwareImageCompose{
+				Name:      "composed/1.0",
+				Artifacts: []string{validUUIDv4, other},
+			},
+			err: &NameError{Field: "name", Reason: "path separators are not allowed"},
+		},
+		{
+			compose: SoftwareImageCompose{
+				Name:      "composed",
+				Artifacts: []string{validUUIDv4},
+			},
+			err: ErrComposeArtifactsCount,
+		},
+		{
+			compose: SoftwareImageCompose{
+				Name:      "composed",
+				Artifacts: make([]string, MaxComposedArtifacts+1),
+			},
+			err: ErrComposeArtifactsCount,
+		},
+		{
+			compose: SoftwareImageCompose{
+				Name:      "composed",
+				Artifacts: []string{validUUIDv4, "foo"},
+			},
+			err: ErrComposeInvalidArtifact,
+		},
+		{
+			compose: SoftwareImageCompose{
+				Name:      "composed",
+				Artifacts: []string{validUUIDv4, validUUIDv4},
+			},
+			err: ErrComposeDuplicate,
+		},
+		{
+			compose: SoftwareImageCompose{
+				Name:      "composed",
+				Artifacts: []string{validUUIDv4, other},
+				SoftwareImageMetaConstructor: SoftwareImageMetaConstructor{
+					Tags: []string{"with space"},
+				},
+			},
+			err: &ValidationError{Fields: []FieldError{{"tags[0]", ErrInvalidTag.Error()}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		if err := tc.compose.Validate(); !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("compose %v: expected error %v, got %v", tc.compose, tc.err, err)
+		}
+	}
+}
+
+func TestValidateImagePending(t *testing.T) {
+	testCases := []struct {
+		pending SoftwareImagePending
+		err     error
+	}{
+		{
+			pending: SoftwareImagePending{
+				Name:                  "pending",
+				DeviceTypesCompatible: []string{"hammer"},
+			},
+		},
+		{
+			pending: SoftwareImagePending{
+				DeviceTypesCompatible: []string{"hammer"},
+			},
+			err: ErrComposeMissingName,
+		},
+		{
+			pending: SoftwareImagePending{
+				Name:                  "pending/1.0",
+				DeviceTypesCompatible: []string{"hammer"},
+			},
+			err: &NameError{Field: "name", Reason: "path separators are not allowed"},
+		},
+		{
+			pending: SoftwareImagePending{
+				Name: "pending",
+			},
+			err: ErrPendingMissingDeviceTypes,
+		},
+		{
+			pending: SoftwareImagePending{
+				Name:                  "pending",
+				DeviceTypesCompatible: []string{"hammer", ""},
+			},
+			err: ErrPendingInvalidDeviceType,
+		},
+		{
+			pending: SoftwareImagePending{
+				Name:                  "pending",
+				DeviceTypesCompatible: []string{"hammer", "  "},
+			},
+			err: ErrPendingInvalidDeviceType,
+		},
+		{
+			pending: SoftwareImagePending{
+				Name:                  "pending",
+				DeviceTypesCompatible: []string{"hammer"},
+				SoftwareImageMetaConstructor: SoftwareImageMetaConstructor{
+					Tags: []string{"with space"},
+				},
+			},
+			err: &ValidationError{Fields: []FieldError{{"tags[0]", ErrInvalidTag.Error()}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		if err := tc.pending.Validate(); !reflect.DeepEqual(err, tc.err) {
+			t.Errorf("pending %v: expected error %v, got %v", tc.pending, tc.err, err)
+		}
+	}
+
+	image := NewPendingSoftwareImage(validUUIDv4, &testCases[0].pending)
+	if !image.IsPending() || image.Validate() != nil {
+		t.Errorf("expected valid pending image, got %v", image)
+	}
+
+	pending := SoftwareImagePending{
+		Name:                  "pending",
+		DeviceTypesCompatible: []string{" Hammer", "hammer"},
+	}
+	if err := pending.Validate(); err != nil ||
+		!reflect.DeepEqual(pending.DeviceTypesCompatible, []string{"hammer"}) {
+		t.Errorf("expected normalized device types, got %q (%v)",
+			pending.DeviceTypesCompatible, err)
+	}
+}
+
+func TestValidateImagesFilter(t *testing.T) {
+	testCases := []struct {
+		filter ImagesFilter
+		err    error
+	}{
+		{
+			filter: ImagesFilter{},
+		},
+		{
+			filter: ImagesFilter{MinSize: 1024, MaxSize: 1024, Sort: SortBySizeAsc},
+		},
+		{
+			filter: ImagesFilter{MinSize: 1024, Sort: SortBySizeDesc},
+		},
+		{
+			filter: ImagesFilter{MinSize: -1},
+			err:    ErrInvalidSizeRange,
+		},
+		{
+			filter: ImagesFilter{MinSize: 2048, MaxSize: 1024},
+			err:    ErrInvalidSizeRange,
+		},
+		{
+			filter: ImagesFilter{Sort: "size"},
+			err:    ErrInvalidSort,
+		},
+		{
+			filter: ImagesFilter{Fields: []string{"name", "size"}},
+		},
+		{
+			filter: ImagesFilter{Fields: []string{"name", "object_key"}},
+			err:    ErrUnknownField,
+		},
+		{
+			filter: ImagesFilter{Limit: 21, After: "a"},
+		},
+		{
+			filter: ImagesFilter{Limit: 21, Sort: SortBySizeAsc},
+			err:    ErrInvalidPagination,
+		},
+		{
+			filter: ImagesFilter{Limit: 21, LatestPerDeviceType: true},
+			err:    ErrInvalidPagination,
+		},
+	}
+
+	for _, tc := range testCases {
+		if err := tc.filter.Validate(); err != tc.err {
+			t.Errorf("filter %v: expected error %v, got %v", tc.filter, tc.err, err)
+		}
+	}
+
+	filter := ImagesFilter{DeviceType: " Raspberry Pi3"}
+	if err := filter.Validate(); err != nil || filter.DeviceType != "raspberry pi3" {
+		t.Errorf("expected normalized device type, got %q (%v)", filter.DeviceType, err)
+	}
+
+	checksum := "E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855"
+	filter = ImagesFilter{Checksum: checksum}
+	if err := filter.Validate(); err != nil || filter.Checksum != strings.ToLower(checksum) {
+		t.Errorf("expected normalized checksum, got %q (%v)", filter.Checksum, err)
+	}
+
+	filter = ImagesFilter{Checksum: "d41d8cd98f00b204e9800998ecf8427e"}
+	if err := filter.Validate(); err != ErrInvalidChecksum {
+		t.Errorf("expected error %v for md5 checksum, got %v", ErrInvalidChecksum, err)
+	}
+}
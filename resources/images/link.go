@@ -21,6 +21,16 @@ import (
 type Link struct {
 	Uri    string    `json:"uri"`
 	Expire time.Time `json:"expire,omitempty"`
+
+	// AcceptRanges tells that the link serves byte-range GET requests,
+	// so an interrupted download can be resumed by sending
+	// "Range: bytes=<offset>-" to the same (or a freshly signed) link.
+	AcceptRanges bool `json:"accept_ranges,omitempty"`
+
+	// Size and checksum of the linked file; a client resuming with a new
+	// link should check that the checksum did not change in between.
+	Size     int64  `json:"size,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
 }
 
 func NewLink(uri string, expire time.Time) *Link {
@@ -29,3 +39,15 @@ func NewLink(uri string, expire time.Time) *Link {
 		Expire: expire,
 	}
 }
+
+// StorageLocation tells where the artifact file is kept in the file storage
+type StorageLocation struct {
+	// File storage type, e.g. "s3"
+	Backend string `json:"backend"`
+
+	// Bucket holding the file
+	Bucket string `json:"bucket"`
+
+	// Key of the file in the bucket
+	Key string `json:"key"`
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package metrics records the state of the artifact storage
+// as Prometheus metrics labeled by tenant, and the artifact uploads.
+//
+// The gauges are set from the stored state, so all the service instances
+// report the same values, which should not be summed. The upload histograms
+// are recorded by the instance handling the upload, across the tenants.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	utilsMetrics "github.com/mendersoftware/deployments/utils/metrics"
+)
+
+// Metric names
+const (
+	NameFailedDeletions  = "deployments_artifact_failed_deletions"
+	NameUploadSize       = "deployments_artifact_upload_size_bytes"
+	NameUploadThroughput = "deployments_artifact_upload_throughput_bytes_per_second"
+)
+
+// Buckets of the upload histograms, from 64KiB to 4GiB (size)
+// and from 16KiB/s to 256MiB/s (throughput)
+var (
+	UploadSizeBuckets       = exponentialBuckets(64*1024, 4, 9)
+	UploadThroughputBuckets = exponentialBuckets(16*1024, 4, 8)
+)
+
+// Label names
+const (
+	LabelTenant = "tenant"
+)
+
+// Metrics of the artifact storage
+type Metrics struct {
+	failedDeletions  *utilsMetrics.Gauge
+	uploadSize       *utilsMetrics.Histogram
+	uploadThroughput *utilsMetrics.Histogram
+
+	tenants *utilsMetrics.TenantLabels
+}
+
+// NewMetrics registers the artifact storage metrics in the registry.
+func NewMetrics(r *utilsMetrics.Registry) *Metrics {
+	return &Metrics{
+		failedDeletions: r.NewGauge(NameFailedDeletions,
+			"Number of artifact files which failed to be removed, pending retry.",
+			LabelTenant),
+		uploadSize: r.NewHistogram(NameUploadSize,
+			"Size of the uploaded artifact requests.", UploadSizeBuckets),
+		uploadThroughput: r.NewHistogram(NameUploadThroughput,
+			"Throughput of the artifact uploads.", UploadThroughputBuckets),
+		tenants: r.Tenants(),
+	}
+}
+
+func exponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// tenant returns the tenant label value of the request.
+func (m *Metrics) tenant(ctx context.Context) string {
+	if id := identity.FromContext(ctx); id != nil {
+		return m.tenants.Label(id.Tenant)
+	}
+	return ""
+}
+
+// FailedDeletionsPending sets the number of the failed artifact file removals
+// of the tenant pending retry.
+func (m *Metrics) FailedDeletionsPending(ctx context.Context, count int) {
+	m.failedDeletions.Set(float64(count), m.tenant(ctx))
+}
+
+// ArtifactUploaded records the size of the artifact upload request read
+// and the throughput it was read with.
+func (m *Metrics) ArtifactUploaded(ctx context.Context, size int64, elapsed time.Duration) {
+	m.uploadSize.Observe(float64(size))
+	if elapsed > 0 {
+		m.uploadThroughput.Observe(float64(size) / elapsed.Seconds())
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+
+	utilsMetrics "github.com/mendersoftware/deployments/utils/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := utilsMetrics.NewRegistry()
+	m := NewMetrics(registry)
+
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "tenant1"})
+
+	m.FailedDeletionsPending(ctx, 3)
+	m.FailedDeletionsPending(ctx, 2)
+	m.FailedDeletionsPending(context.Background(), 1)
+
+	var buf bytes.Buffer
+	_, err := registry.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), `# HELP deployments_artifact_failed_deletions Number of artifact files which failed to be removed, pending retry.
+# TYPE deployments_artifact_failed_deletions gauge
+deployments_artifact_failed_deletions{tenant=""} 1
+deployments_artifact_failed_deletions{tenant="tenant1"} 2
+`), buf.String())
+}
+
+func TestMetricsArtifactUploaded(t *testing.T) {
+	registry := utilsMetrics.NewRegistry()
+	m := NewMetrics(registry)
+
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "tenant1"})
+
+	// 1MiB/s
+	m.ArtifactUploaded(ctx, 2*1024*1024, 2*time.Second)
+	// 32KiB/s
+	m.ArtifactUploaded(context.Background(), 64*1024, 2*time.Second)
+	// nothing read
+	m.ArtifactUploaded(context.Background(), 0, 0)
+
+	var buf bytes.Buffer
+	_, err := registry.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `# TYPE deployments_artifact_upload_size_bytes histogram
+deployments_artifact_upload_size_bytes_bucket{le="65536"} 2
+deployments_artifact_upload_size_bytes_bucket{le="262144"} 2
+deployments_artifact_upload_size_bytes_bucket{le="1.048576e+06"} 2
+deployments_artifact_upload_size_bytes_bucket{le="4.194304e+06"} 3
+`)
+	assert.Contains(t, buf.String(), `deployments_artifact_upload_size_bytes_count 3
+`)
+	assert.Contains(t, buf.String(), `# TYPE deployments_artifact_upload_throughput_bytes_per_second histogram
+deployments_artifact_upload_throughput_bytes_per_second_bucket{le="16384"} 0
+deployments_artifact_upload_throughput_bytes_per_second_bucket{le="65536"} 1
+deployments_artifact_upload_throughput_bytes_per_second_bucket{le="262144"} 1
+deployments_artifact_upload_throughput_bytes_per_second_bucket{le="1.048576e+06"} 2
+`)
+	assert.Contains(t, buf.String(), `deployments_artifact_upload_throughput_bytes_per_second_sum 1.081344e+06
+deployments_artifact_upload_throughput_bytes_per_second_count 2
+`)
+}
@@ -0,0 +1,249 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+const (
+	// maximum size of the bundle manifest accepted on import
+	MaxBundleManifestSize = 1024 * 1024 * 64
+)
+
+// ExportImages writes the bundle of all the images of the tenant:
+// the manifest with the images metadata followed by the image files.
+// Size and checksum of the files uploaded before they were recorded
+// are computed upfront, which requires reading such files twice.
+// Pending images have no files yet and are not exported, neither are
+// the ones being scanned or quarantined.
+func (i *ImagesModel) ExportImages(ctx context.Context, w io.Writer) error {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.ExportImages")
+	defer span.End()
+
+	found, err := i.imagesStorage.FindAll(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Searching for images")
+	}
+
+	list := make([]*images.SoftwareImage, 0, len(found))
+	for _, image := range found {
+		if image.IsAvailable() {
+			list = append(list, image)
+		}
+	}
+	span.SetAttribute("images", len(list))
+
+	tenant := tenantFromContext(ctx)
+
+	for _, image := range list {
+		if image.Size > 0 && image.Checksum != "" {
+			continue
+		}
+		if err := i.measureFile(ctx, image.FileObjectKey(tenant), image); err != nil {
+			return errors.Wrapf(err, "Measuring file of image %s", image.Id)
+		}
+	}
+
+	manifest, err := json.Marshal(&images.BundleManifest{
+		Version: images.BundleVersion,
+		Images:  list,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Encoding bundle manifest")
+	}
+
+	tw := tar.NewWriter(w)
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    images.BundleManifestName,
+		Mode:    0644,
+		Size:    int64(len(manifest)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return errors.Wrap(err, "Writing bundle manifest")
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return errors.Wrap(err, "Writing bundle manifest")
+	}
+
+	for _, image := range list {
+		if err := i.exportFile(ctx, tw, image.FileObjectKey(tenant), image); err != nil {
+			return errors.Wrapf(err, "Writing file of image %s", image.Id)
+		}
+	}
+
+	return tw.Close()
+}
+
+// measureFile sets the size and the checksums of the image file.
+func (i *ImagesModel) measureFile(ctx context.Context, objectKey string,
+	image *images.SoftwareImage) error {
+
+	file, err := i.fileStorage.GetObject(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	checksums := images.NewChecksumsWriter()
+	size, err := io.Copy(checksums, file)
+	if err != nil {
+		return err
+	}
+
+	image.Size = size
+	image.SetChecksums(checksums.Checksums())
+
+	return nil
+}
+
+func (i *ImagesModel) exportFile(ctx context.Context, tw *tar.Writer,
+	objectKey string, image *images.SoftwareImage) error {
+
+	file, err := i.fileStorage.GetObject(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := &tar.Header{
+		Name:    images.BundleArtifactName(image.Id),
+		Mode:    0644,
+		Size:    image.Size,
+		ModTime: time.Now(),
+	}
+	if image.Modified != nil {
+		header.ModTime = *image.Modified
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	// the tar writer fails if the file differs in size from the recorded one
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// bundledChecksums returns the checksums the bundled image file has to match,
+// the bundles exported before the checksums were recorded have SHA256 one only.
+func bundledChecksums(image *images.SoftwareImage) images.Checksums {
+	checksums := make(images.Checksums, len(image.Checksums)+1)
+	for algorithm, sum := range image.Checksums {
+		checksums[algorithm] = sum
+	}
+	if image.Checksum != "" {
+		checksums[images.ChecksumSHA256] = image.Checksum
+	}
+	return checksums
+}
+
+// ImportImages creates the images out of the bundle written by ExportImages.
+// Imported images are given new IDs, their files have to match the checksums
+// from the manifest. Images having the same checksum as the existing ones are
+// skipped, so that the import can be safely repeated, also after a failure.
+func (i *ImagesModel) ImportImages(ctx context.Context,
+	bundle io.Reader) (*images.ImportResult, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.ImportImages")
+	defer span.End()
+
+	tr := tar.NewReader(bundle)
+
+	header, err := tr.Next()
+	if err != nil {
+		return nil, errors.Wrap(controller.ErrModelInvalidBundle, err.Error())
+	}
+	if header.Name != images.BundleManifestName || header.Size > MaxBundleManifestSize {
+		return nil, errors.Wrap(controller.ErrModelInvalidBundle,
+			"manifest expected as the first entry")
+	}
+
+	var manifest images.BundleManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, errors.Wrap(controller.ErrModelInvalidBundle, err.Error())
+	}
+	if manifest.Version != images.BundleVersion {
+		return nil, errors.Wrapf(controller.ErrModelInvalidBundle,
+			"unsupported version %d", manifest.Version)
+	}
+
+	records := make(map[string]*images.SoftwareImage, len(manifest.Images))
+	for _, image := range manifest.Images {
+		records[image.Id] = image
+	}
+
+	result := images.NewImportResult()
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, errors.Wrap(controller.ErrModelInvalidBundle, err.Error())
+		}
+
+		id := images.BundleArtifactID(header.Name)
+		image, ok := records[id]
+		if !ok {
+			return result, errors.Wrapf(controller.ErrModelInvalidBundle,
+				"unexpected entry %s", header.Name)
+		}
+
+		if image.Checksum != "" {
+			existing, err := i.imagesStorage.FindByChecksum(ctx, image.Checksum)
+			if err != nil {
+				return result, errors.Wrap(err, "Searching for image with the checksum")
+			}
+			if existing != nil {
+				result.Skipped[id] = existing.Id
+				continue
+			}
+		}
+
+		imgID, err := i.CreateImage(ctx, &controller.MultipartUploadMsg{
+			MetaConstructor: &images.SoftwareImageMetaConstructor{
+				Description: image.Description,
+				Tags:        image.Tags,
+				Metadata:    image.Metadata,
+			},
+			ArtifactSize:   header.Size,
+			ArtifactReader: tr,
+			Delta:          image.Delta,
+			Checksums:      bundledChecksums(image),
+		})
+		if err != nil {
+			return result, errors.Wrapf(err, "Importing image %s", id)
+		}
+		result.Imported[id] = imgID
+	}
+
+	span.SetAttribute("imported", len(result.Imported))
+	span.SetAttribute("skipped", len(result.Skipped))
+
+	return result, nil
+}
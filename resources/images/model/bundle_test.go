@@ -0,0 +1,167 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+func TestExportImportImages(t *testing.T) {
+	image, data := makeStoredImage(t, validUUIDv4)
+	image.Tags = []string{"production"}
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	// size and checksum not recorded, computed on export
+	exportIS := &FakeImageStorage{findAllImages: []*images.SoftwareImage{image}}
+	exportFS := &FakeFileStorage{objects: map[string][]byte{image.Id: data}}
+	exporter := NewImagesModel(exportFS, nil, exportIS, nil, nil)
+
+	var bundle bytes.Buffer
+	assert.NoError(t, exporter.ExportImages(context.Background(), &bundle))
+
+	tr := tar.NewReader(bytes.NewReader(bundle.Bytes()))
+	header, err := tr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, images.BundleManifestName, header.Name)
+	var manifest images.BundleManifest
+	assert.NoError(t, json.NewDecoder(tr).Decode(&manifest))
+	assert.Len(t, manifest.Images, 1)
+	assert.Equal(t, checksum, manifest.Images[0].Checksum)
+	assert.Equal(t, int64(len(data)), manifest.Images[0].Size)
+
+	header, err = tr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, images.BundleArtifactName(image.Id), header.Name)
+	content, err := ioutil.ReadAll(tr)
+	assert.NoError(t, err)
+	assert.Equal(t, data, content)
+
+	// import
+	importIS := &FakeImageStorage{isArtifactUnique: true}
+	importFS := &FakeFileStorage{objects: map[string][]byte{}}
+	importer := NewImagesModel(importFS, nil, importIS, nil, nil)
+
+	result, err := importer.ImportImages(context.Background(), bytes.NewReader(bundle.Bytes()))
+	assert.NoError(t, err)
+	assert.Empty(t, result.Skipped)
+	assert.Contains(t, result.Imported, image.Id)
+	assert.NotEqual(t, image.Id, result.Imported[image.Id])
+	assert.Equal(t, result.Imported[image.Id], importIS.inserted.Id)
+	assert.Equal(t, checksum, importIS.inserted.Checksum)
+	assert.Equal(t, image.Name, importIS.inserted.Name)
+	assert.Equal(t, image.Tags, importIS.inserted.Tags)
+	assert.Equal(t, data, importFS.objects[result.Imported[image.Id]])
+
+	// repeated import skips the image with the same checksum
+	importIS.findByChecksumImages = map[string]*images.SoftwareImage{
+		checksum: importIS.inserted,
+	}
+	result, err = importer.ImportImages(context.Background(), bytes.NewReader(bundle.Bytes()))
+	assert.NoError(t, err)
+	assert.Empty(t, result.Imported)
+	assert.Equal(t, map[string]string{image.Id: importIS.inserted.Id}, result.Skipped)
+}
+
+func TestImportImagesInvalidBundle(t *testing.T) {
+	image, data := makeStoredImage(t, validUUIDv4)
+
+	makeBundle := func(manifest *images.BundleManifest, entries map[string][]byte) []byte {
+		var b bytes.Buffer
+		tw := tar.NewWriter(&b)
+		if manifest != nil {
+			m, _ := json.Marshal(manifest)
+			tw.WriteHeader(&tar.Header{Name: images.BundleManifestName, Mode: 0644, Size: int64(len(m))})
+			tw.Write(m)
+		}
+		for name, content := range entries {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+			tw.Write(content)
+		}
+		tw.Close()
+		return b.Bytes()
+	}
+
+	withChecksum := *image
+	withChecksum.Checksum = hex.EncodeToString(make([]byte, 32))
+	withChecksums := *image
+	withChecksums.Checksums = images.Checksums{
+		images.ChecksumMD5: hex.EncodeToString(make([]byte, 16)),
+	}
+
+	testCases := map[string]struct {
+		bundle []byte
+		err    error
+	}{
+		"not a tar": {
+			bundle: []byte("foobar"),
+			err:    controller.ErrModelInvalidBundle,
+		},
+		"no manifest": {
+			bundle: makeBundle(nil, map[string][]byte{
+				images.BundleArtifactName(image.Id): data,
+			}),
+			err: controller.ErrModelInvalidBundle,
+		},
+		"unsupported version": {
+			bundle: makeBundle(&images.BundleManifest{Version: 2}, nil),
+			err:    controller.ErrModelInvalidBundle,
+		},
+		"unknown artifact": {
+			bundle: makeBundle(&images.BundleManifest{Version: images.BundleVersion},
+				map[string][]byte{images.BundleArtifactName(image.Id): data}),
+			err: controller.ErrModelInvalidBundle,
+		},
+		"checksum mismatch": {
+			bundle: makeBundle(&images.BundleManifest{
+				Version: images.BundleVersion,
+				Images:  []*images.SoftwareImage{&withChecksum},
+			}, map[string][]byte{images.BundleArtifactName(image.Id): data}),
+			err: controller.ErrModelUploadChecksumMismatch,
+		},
+		"md5 checksum mismatch": {
+			bundle: makeBundle(&images.BundleManifest{
+				Version: images.BundleVersion,
+				Images:  []*images.SoftwareImage{&withChecksums},
+			}, map[string][]byte{images.BundleArtifactName(image.Id): data}),
+			err: controller.ErrModelUploadChecksumMismatch,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fakeIS := &FakeImageStorage{isArtifactUnique: true}
+			fakeFS := &FakeFileStorage{objects: map[string][]byte{}}
+			iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+
+			_, err := iModel.ImportImages(context.Background(), bytes.NewReader(tc.bundle))
+			assert.Equal(t, tc.err, errors.Cause(err))
+			assert.Nil(t, fakeIS.inserted)
+			assert.Empty(t, fakeFS.objects)
+		})
+	}
+}
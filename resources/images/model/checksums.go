@@ -0,0 +1,203 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+// DefaultChecksumsRate is the default limit of the bytes per second read
+// from the file storage to recompute the checksums
+const DefaultChecksumsRate = 16 * 1024 * 1024
+
+// SetChecksumsRate limits the bytes per second read from the file storage to
+// recompute the checksums, by all the recomputations together; zero disables
+// the limit.
+func (m *IntegrityModel) SetChecksumsRate(rate int64) {
+	m.throttle = nil
+	if rate > 0 {
+		m.throttle = &throttle{rate: rate}
+	}
+}
+
+// RecomputeChecksums computes the checksums of the files of the batch of
+// the images of the tenant from the context, and records them with the images.
+// Images which files don't match the checksums recorded already are left
+// unchanged, as the ones which files could not be read. If the context is
+// cancelled, the report of the images processed so far is returned.
+func (m *IntegrityModel) RecomputeChecksums(ctx context.Context,
+	batch *images.ChecksumsRecomputation) (*images.ChecksumsReport, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "IntegrityModel.RecomputeChecksums")
+	defer span.End()
+
+	l := log.FromContext(ctx)
+
+	// one more image is listed to tell if there are more
+	imageList, err := m.imagesStorage.Find(ctx, &images.ImagesFilter{
+		IDs:   batch.IDs,
+		After: batch.After,
+		Limit: batch.Limit + 1,
+	})
+	if err != nil {
+		span.SetError(err)
+		return nil, errors.Wrap(err, "Searching for image metadata")
+	}
+
+	report := &images.ChecksumsReport{
+		Mismatched: []string{},
+		Failed:     []string{},
+	}
+	if len(imageList) > batch.Limit {
+		imageList = imageList[:batch.Limit]
+		report.Next = imageList[len(imageList)-1].Id
+	}
+
+	for i, image := range imageList {
+		if ctx.Err() != nil {
+			report.Next = batch.After
+			if i > 0 {
+				report.Next = imageList[i-1].Id
+			}
+			break
+		}
+
+		if image.IsPending() ||
+			(!batch.Force && image.Checksums[images.ChecksumSHA256] != "") {
+			report.Skipped++
+			continue
+		}
+
+		computed, err := m.computeChecksums(ctx, image)
+		if err == nil && !recordedChecksums(image).Matches(computed) {
+			l.F(log.Ctx{"image_id": image.Id}).
+				Warn("image file does not match the recorded checksums")
+			report.Checked++
+			report.Mismatched = append(report.Mismatched, image.Id)
+			continue
+		}
+		if err == nil {
+			_, err = m.imagesStorage.SetChecksums(ctx, image.Id, computed)
+		}
+		if err != nil {
+			l.F(log.Ctx{"image_id": image.Id, "error": err.Error()}).
+				Error("failed to recompute image checksums")
+			report.Failed = append(report.Failed, image.Id)
+			continue
+		}
+
+		report.Checked++
+		report.Updated++
+	}
+
+	span.SetAttribute("checked", report.Checked)
+	span.SetAttribute("updated", report.Updated)
+
+	return report, nil
+}
+
+// recordedChecksums returns the checksums recorded with the image, including
+// the SHA256 one recorded as the checksum only.
+func recordedChecksums(image *images.SoftwareImage) images.Checksums {
+	recorded := make(images.Checksums, len(image.Checksums)+1)
+	for algorithm, sum := range image.Checksums {
+		recorded[algorithm] = sum
+	}
+	if _, ok := recorded[images.ChecksumSHA256]; !ok && image.Checksum != "" {
+		recorded[images.ChecksumSHA256] = image.Checksum
+	}
+	return recorded
+}
+
+// computeChecksums reads the stored image file computing the checksums.
+func (m *IntegrityModel) computeChecksums(ctx context.Context,
+	image *images.SoftwareImage) (images.Checksums, error) {
+
+	file, err := m.fileStorage.GetObject(ctx,
+		image.FileObjectKey(tenantFromContext(ctx)))
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching image file")
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if m.throttle != nil {
+		r = &throttledReader{ctx: ctx, r: file, throttle: m.throttle}
+	}
+
+	checksums := images.NewChecksumsWriter()
+	if _, err := io.Copy(checksums, r); err != nil {
+		return nil, errors.Wrap(err, "Reading image file")
+	}
+	return checksums.Checksums(), nil
+}
+
+// throttle limits the rate of the bytes read by all the readers sharing it.
+type throttle struct {
+	rate int64
+
+	mutex sync.Mutex
+	// time the bytes read so far are due at, at the rate
+	due time.Time
+}
+
+// wait accounts for n bytes read, waiting until they are due.
+func (t *throttle) wait(ctx context.Context, n int) error {
+	t.mutex.Lock()
+	now := time.Now()
+	if t.due.Before(now) {
+		t.due = now
+	}
+	t.due = t.due.Add(time.Duration(int64(n) * int64(time.Second) / t.rate))
+	wait := t.due.Sub(now)
+	t.mutex.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	throttle *throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.throttle.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+func TestRecomputeChecksums(t *testing.T) {
+	data := []byte("artifact")
+	md5sum := md5.Sum(data)
+	sha256sum := sha256.Sum256(data)
+	checksums := images.Checksums{
+		images.ChecksumMD5:    hex.EncodeToString(md5sum[:]),
+		images.ChecksumSHA256: hex.EncodeToString(sha256sum[:]),
+	}
+
+	legacy := &images.SoftwareImage{Id: "1", Checksums: images.Checksums{
+		images.ChecksumMD5: checksums[images.ChecksumMD5],
+	}}
+	upToDate := &images.SoftwareImage{Id: "2"}
+	upToDate.SetChecksums(checksums)
+	mismatch := &images.SoftwareImage{Id: "3", Checksums: images.Checksums{
+		images.ChecksumMD5: "d41d8cd98f00b204e9800998ecf8427e",
+	}}
+	missing := &images.SoftwareImage{Id: "4"}
+	next := &images.SoftwareImage{Id: "5"}
+
+	fakeFS := &FakeFileStorage{objects: map[string][]byte{
+		legacy.Id:   data,
+		upToDate.Id: data,
+		mismatch.Id: data,
+		next.Id:     data,
+	}}
+	fakeIS := &FakeImageStorage{
+		findAllImages: []*images.SoftwareImage{legacy, upToDate, mismatch, missing, next},
+		checksums:     map[string]images.Checksums{},
+	}
+
+	model := NewIntegrityModel(fakeFS, fakeIS, nil, 0)
+
+	batch := &images.ChecksumsRecomputation{After: "0", Limit: 4}
+	report, err := model.RecomputeChecksums(context.Background(), batch)
+	assert.NoError(t, err)
+	assert.Equal(t, &images.ChecksumsReport{
+		Checked:    2,
+		Updated:    1,
+		Skipped:    1,
+		Mismatched: []string{mismatch.Id},
+		Failed:     []string{missing.Id},
+		Next:       missing.Id,
+	}, report)
+	assert.Equal(t, &images.ImagesFilter{After: "0", Limit: 5}, fakeIS.filter)
+	assert.Equal(t, map[string]images.Checksums{legacy.Id: checksums}, fakeIS.checksums)
+
+	// recomputed even if recorded already
+	fakeIS.findAllImages = []*images.SoftwareImage{upToDate}
+	batch = &images.ChecksumsRecomputation{IDs: []string{upToDate.Id}, Limit: 4, Force: true}
+	report, err = model.RecomputeChecksums(context.Background(), batch)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Updated)
+	assert.Empty(t, report.Next)
+
+	fakeIS.setChecksumsError = errors.New("db error")
+	report, err = model.RecomputeChecksums(context.Background(), batch)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{upToDate.Id}, report.Failed)
+
+	// cancelled batch is resumed after the images processed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fakeIS.findAllImages = []*images.SoftwareImage{legacy, next}
+	batch = &images.ChecksumsRecomputation{After: "0", Limit: 4}
+	report, err = model.RecomputeChecksums(ctx, batch)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", report.Next)
+	assert.Equal(t, 0, report.Checked)
+
+	fakeIS.findAllError = errors.New("db error")
+	_, err = model.RecomputeChecksums(context.Background(), batch)
+	assert.Error(t, err)
+}
+
+func TestThrottle(t *testing.T) {
+	th := &throttle{rate: 1000}
+
+	start := time.Now()
+	assert.NoError(t, th.wait(context.Background(), 50))
+	assert.NoError(t, th.wait(context.Background(), 50))
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	// shared by the readers, waiting is cancelled with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, th.wait(ctx, 1000))
+}
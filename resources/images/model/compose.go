@@ -0,0 +1,296 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/awriter"
+	"github.com/mendersoftware/mender-artifact/handlers"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+const (
+	// the only update type the artifact writer can compose
+	UpdateTypeRootfsImage = "rootfs-image"
+
+	// format and version of the composed artifacts
+	ComposedArtifactFormat  = "mender"
+	ComposedArtifactVersion = 2
+)
+
+// ComposeScratch is the local disk space ComposeImage uses: the updates of
+// the composed images are extracted to the directory, and the composed
+// artifact written next to them, as the artifact writer works with files only.
+type ComposeScratch struct {
+	// Directory of the temporary files, the OS temporary directory if empty
+	Dir string
+	// Maximum size of the updates of the composed images, the composed
+	// artifact is about the same size; MaxImageSize if zero.
+	MaxSize int64
+}
+
+// SetComposeScratch sets the disk space used by ComposeImage,
+// a single composition uses up to twice the maximum size.
+func (i *ImagesModel) SetComposeScratch(scratch ComposeScratch) {
+	i.compose = scratch
+}
+
+// maxSize returns the maximum size of the composition.
+func (s ComposeScratch) maxSize() int64 {
+	if s.MaxSize > 0 && s.MaxSize < MaxImageSize {
+		return s.MaxSize
+	}
+	return MaxImageSize
+}
+
+// scratchWriter fails the writes once more than left bytes are written,
+// shared by all the files of the composition.
+type scratchWriter struct {
+	w        io.Writer
+	left     *int64
+	exceeded bool
+}
+
+func (s *scratchWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > *s.left {
+		s.exceeded = true
+		return 0, controller.ErrModelArtifactFileTooLarge
+	}
+	n, err := s.w.Write(p)
+	*s.left -= int64(n)
+	return n, err
+}
+
+// ComposeImage creates a new image out of the updates of existing images,
+// so that clients do not have to download, combine and upload them again.
+// The composed artifact is compatible with the device types all the images
+// are compatible with. It is stored like an uploaded one, so the checksums
+// of its payloads are computed and recorded the same way.
+// Returns ID of the composed image.
+func (i *ImagesModel) ComposeImage(ctx context.Context,
+	compose *images.SoftwareImageCompose) (string, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.ComposeImage")
+	defer span.End()
+
+	if err := compose.Validate(); err != nil {
+		return "", errors.Wrap(err, "Validating image metadata")
+	}
+
+	// composed artifact can not be signed by the service
+	if len(i.trustedKeys) > 0 {
+		return "", controller.ErrModelArtifactNotSigned
+	}
+
+	layers := make([]*images.SoftwareImage, 0, len(compose.Artifacts))
+	for _, id := range compose.Artifacts {
+		image, err := i.imagesStorage.FindByID(ctx, id)
+		if err != nil {
+			return "", errors.Wrap(err, "Searching for image with specified ID")
+		}
+		if image == nil {
+			return "", errors.Wrapf(controller.ErrImageMetaNotFound, "Artifact %s", id)
+		}
+		if err := unavailableError(image); err != nil {
+			return "", errors.Wrapf(err, "Artifact %s", id)
+		}
+		// delta can be applied only to the artifact it was made for
+		if image.Delta != nil {
+			return "", errors.Wrapf(controller.ErrModelUnsupportedUpdateType,
+				"Artifact %s is a delta", id)
+		}
+		for _, update := range image.Updates {
+			if update.TypeInfo.Type != UpdateTypeRootfsImage {
+				return "", errors.Wrapf(controller.ErrModelUnsupportedUpdateType,
+					"Artifact %s", id)
+			}
+		}
+		layers = append(layers, image)
+	}
+
+	deviceTypes := commonDeviceTypes(layers)
+	if len(deviceTypes) == 0 {
+		return "", controller.ErrModelIncompatibleDeviceTypes
+	}
+
+	// fail early, before the images are downloaded
+	isArtifactUnique, err := i.imagesStorage.IsArtifactUnique(ctx,
+		compose.Name, deviceTypes)
+	if err != nil {
+		return "", errors.Wrap(err, "Fail to check if artifact is unique")
+	}
+	if !isArtifactUnique {
+		return "", controller.ErrModelArtifactNotUnique
+	}
+
+	// fail early if the known sizes of the images exceed the limit,
+	// the updates are limited while extracted anyway
+	maxSize := i.compose.maxSize()
+	var total int64
+	for _, layer := range layers {
+		total += layer.Size
+	}
+	if total > maxSize {
+		return "", controller.ErrModelArtifactFileTooLarge
+	}
+
+	// the artifact writer works with files only
+	dir, err := ioutil.TempDir(i.compose.Dir, "compose")
+	if err != nil {
+		return "", errors.Wrap(err, "Creating temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	left := maxSize
+	var updates []handlers.Composer
+	for n, layer := range layers {
+		files, err := i.extractUpdates(ctx, layer,
+			filepath.Join(dir, strconv.Itoa(n)), &left)
+		if err == controller.ErrModelArtifactFileTooLarge {
+			return "", err
+		}
+		if err != nil {
+			return "", errors.Wrapf(err, "Extracting updates of artifact %s", layer.Id)
+		}
+		for _, file := range files {
+			updates = append(updates, handlers.NewRootfsV2(file))
+		}
+	}
+
+	file, err := ioutil.TempFile(dir, "artifact")
+	if err != nil {
+		return "", errors.Wrap(err, "Creating temporary artifact file")
+	}
+	defer file.Close()
+
+	// the composed artifact is an upload like any other
+	left = MaxImageSize
+	w := &scratchWriter{w: file, left: &left}
+	aw := awriter.NewWriter(w)
+	err = aw.WriteArtifact(ComposedArtifactFormat, ComposedArtifactVersion,
+		deviceTypes, compose.Name, &awriter.Updates{U: updates}, nil)
+	if w.exceeded {
+		return "", controller.ErrModelArtifactFileTooLarge
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "Writing composed artifact")
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", errors.Wrap(err, "Reading composed artifact")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", errors.Wrap(err, "Reading composed artifact")
+	}
+
+	return i.CreateImage(ctx, &controller.MultipartUploadMsg{
+		MetaConstructor: &compose.SoftwareImageMetaConstructor,
+		ArtifactSize:    size,
+		ArtifactReader:  file,
+	})
+}
+
+// extractUpdates stores the update files of the image in the directory,
+// each one in its own subdirectory, as the artifact keeps their names.
+// At most left bytes are stored, the number stored is subtracted from it;
+// ErrModelArtifactFileTooLarge is returned if there are more.
+// Returns paths of the files in the order of the updates.
+func (i *ImagesModel) extractUpdates(ctx context.Context,
+	image *images.SoftwareImage, dir string, left *int64) ([]string, error) {
+
+	file, err := i.fileStorage.GetObject(ctx,
+		image.FileObjectKey(tenantFromContext(ctx)))
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching image file")
+	}
+	defer file.Close()
+
+	var paths []string
+	w := &scratchWriter{left: left}
+	rootfs := handlers.NewRootfsInstaller()
+	rootfs.InstallHandler = func(r io.Reader, df *handlers.DataFile) error {
+		updateDir := filepath.Join(dir, strconv.Itoa(len(paths)))
+		if err := os.MkdirAll(updateDir, 0700); err != nil {
+			return err
+		}
+
+		path := filepath.Join(updateDir, filepath.Base(df.Name))
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w.w = f
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	}
+
+	aReader := areader.NewReader(file)
+	if err := aReader.RegisterHandler(rootfs); err != nil {
+		return nil, err
+	}
+	if err := aReader.ReadArtifact(); err != nil {
+		if w.exceeded {
+			return nil, controller.ErrModelArtifactFileTooLarge
+		}
+		return nil, errors.Wrap(controller.ErrModelParsingArtifactFailed, err.Error())
+	}
+
+	return paths, nil
+}
+
+// commonDeviceTypes returns the device types all the images are compatible with,
+// in the order of the first image.
+func commonDeviceTypes(layers []*images.SoftwareImage) []string {
+	var common []string
+	for _, deviceType := range layers[0].DeviceTypesCompatible {
+		compatible := true
+		for _, layer := range layers[1:] {
+			if !containsString(layer.DeviceTypesCompatible, deviceType) {
+				compatible = false
+				break
+			}
+		}
+		if compatible {
+			common = append(common, deviceType)
+		}
+	}
+	return common
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
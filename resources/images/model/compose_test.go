@@ -0,0 +1,147 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+func TestComposeImage(t *testing.T) {
+	layer, data := makeStoredImage(t, validUUIDv4)
+
+	fakeIS := new(FakeImageStorage)
+	fakeFS := &FakeFileStorage{objects: map[string][]byte{}}
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+
+	ctx := context.Background()
+	compose := &images.SoftwareImageCompose{
+		Name:      "composed",
+		Artifacts: []string{validUUIDv4, "0c17d9ad-6d1b-4a83-9bb1-5a5a2ea9b9f6"},
+		SoftwareImageMetaConstructor: images.SoftwareImageMetaConstructor{
+			Description: "base with overlay",
+		},
+	}
+
+	// invalid request
+	_, err := iModel.ComposeImage(ctx, &images.SoftwareImageCompose{
+		Name:      "composed",
+		Artifacts: []string{validUUIDv4},
+	})
+	assert.Error(t, err)
+
+	// composed artifact would not be signed
+	signedModel := NewImagesModel(fakeFS, nil, fakeIS, nil,
+		[]*TrustedKey{new(TrustedKey)})
+	_, err = signedModel.ComposeImage(ctx, compose)
+	assert.Equal(t, controller.ErrModelArtifactNotSigned, err)
+
+	// finding error
+	fakeIS.findByIdError = errors.New("error")
+	_, err = iModel.ComposeImage(ctx, compose)
+	assert.Error(t, err)
+
+	// cannot find image
+	fakeIS.findByIdError = nil
+	_, err = iModel.ComposeImage(ctx, compose)
+	assert.Equal(t, controller.ErrImageMetaNotFound, errors.Cause(err))
+
+	// unsupported update type
+	unsupported := *layer
+	unsupported.Updates = []images.Update{
+		{TypeInfo: images.ArtifactUpdateTypeInfo{Type: "docker"}},
+	}
+	fakeIS.findByIdImage = &unsupported
+	_, err = iModel.ComposeImage(ctx, compose)
+	assert.Equal(t, controller.ErrModelUnsupportedUpdateType, errors.Cause(err))
+
+	// no common device type
+	incompatible := *layer
+	incompatible.DeviceTypesCompatible = nil
+	fakeIS.findByIdImage = &incompatible
+	_, err = iModel.ComposeImage(ctx, compose)
+	assert.Equal(t, controller.ErrModelIncompatibleDeviceTypes, err)
+
+	// not unique
+	fakeIS.findByIdImage = layer
+	_, err = iModel.ComposeImage(ctx, compose)
+	assert.Equal(t, controller.ErrModelArtifactNotUnique, err)
+
+	// image file missing
+	fakeIS.isArtifactUnique = true
+	_, err = iModel.ComposeImage(ctx, compose)
+	assert.Error(t, err)
+	assert.Nil(t, fakeIS.inserted)
+
+	// OK
+	fakeFS.objects[validUUIDv4] = data
+	id, err := iModel.ComposeImage(ctx, compose)
+	assert.NoError(t, err)
+	if assert.NotNil(t, fakeIS.inserted) {
+		composed := fakeIS.inserted
+		assert.Equal(t, id, composed.Id)
+		assert.Equal(t, "composed", composed.Name)
+		assert.Equal(t, "base with overlay", composed.Description)
+		assert.Equal(t, layer.DeviceTypesCompatible, composed.DeviceTypesCompatible)
+		assert.Equal(t, uint(ComposedArtifactVersion), composed.Info.Version)
+		if assert.Len(t, composed.Updates, 2) {
+			for _, update := range composed.Updates {
+				assert.Equal(t, UpdateTypeRootfsImage, update.TypeInfo.Type)
+				assert.Equal(t, layer.Updates[0].Files[0].Checksum,
+					update.Files[0].Checksum)
+			}
+		}
+	}
+	assert.Contains(t, fakeFS.objects, id)
+
+	// scratch space in the configured directory, removed afterwards
+	dir, err := ioutil.TempDir("", "scratch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	iModel.SetComposeScratch(ComposeScratch{Dir: dir})
+	_, err = iModel.ComposeImage(ctx, compose)
+	assert.NoError(t, err)
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	// known sizes of the images exceed the limit
+	fakeIS.inserted = nil
+	large := *layer
+	large.Size = 10
+	fakeIS.findByIdImage = &large
+	iModel.SetComposeScratch(ComposeScratch{Dir: dir, MaxSize: 15})
+	_, err = iModel.ComposeImage(ctx, compose)
+	assert.Equal(t, controller.ErrModelArtifactFileTooLarge, err)
+
+	// sizes not known, updates exceed the limit when extracted
+	fakeIS.findByIdImage = layer
+	iModel.SetComposeScratch(ComposeScratch{Dir: dir, MaxSize: 1})
+	_, err = iModel.ComposeImage(ctx, compose)
+	assert.Equal(t, controller.ErrModelArtifactFileTooLarge, err)
+	assert.Nil(t, fakeIS.inserted)
+	files, err = ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
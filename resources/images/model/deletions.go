@@ -0,0 +1,224 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+// ErrReplicaNotConfigured is the failure of the file removal from the region
+// which is no longer replicated to; retried in case the region comes back.
+var ErrReplicaNotConfigured = errors.New("Replica region not configured")
+
+// maxDeletionBackoffShift caps the backoff of the removal retries
+// at 1024 times the initial one.
+const maxDeletionBackoffShift = 10
+
+// DeletionMetrics records the failed file removals pending retry
+type DeletionMetrics interface {
+	FailedDeletionsPending(ctx context.Context, count int)
+}
+
+// deleteFile removes the image file from the file storage of the region,
+// empty for the primary one. The image is gone already, so the failure
+// is not returned, but recorded for DeletionsModel to retry the removal.
+func (i *ImagesModel) deleteFile(ctx context.Context, storage FileStorage,
+	region, objectKey string) {
+
+	err := storage.Delete(ctx, objectKey)
+	if err == nil {
+		return
+	}
+
+	l := log.FromContext(ctx).F(log.Ctx{"object_key": objectKey, "region": region})
+	l.F(log.Ctx{"error": err.Error()}).Error("failed to delete image file, to be retried")
+
+	if err := i.imagesStorage.SaveFailedDeletion(ctx,
+		images.NewFailedDeletion(region, objectKey, err, time.Now())); err != nil {
+		l.F(log.Ctx{"error": err.Error()}).
+			Error("failed to record failed deletion of image file")
+	}
+}
+
+// DeletionsModel retries the removal of the image files which failed when
+// their images were deleted, so that the files are not leaked.
+type DeletionsModel struct {
+	fileStorage   FileStorage
+	replicas      map[string]FileStorage
+	imagesStorage SoftwareImagesStorage
+	tenants       TenantsLister
+	metrics       DeletionMetrics
+	interval      time.Duration
+	backoff       time.Duration
+}
+
+// NewDeletionsModel creates the model. Failed removals of all the tenants
+// are retried every interval by Run, interval of 0 disables the retries.
+// Each failed retry doubles the backoff before the next one, starting with
+// the given one.
+func NewDeletionsModel(
+	fileStorage FileStorage,
+	imagesStorage SoftwareImagesStorage,
+	tenants TenantsLister,
+	interval time.Duration,
+	backoff time.Duration,
+) *DeletionsModel {
+	return &DeletionsModel{
+		fileStorage:   fileStorage,
+		imagesStorage: imagesStorage,
+		tenants:       tenants,
+		interval:      interval,
+		backoff:       backoff,
+	}
+}
+
+// SetReplicas configures the file storages of the regions the image files
+// are replicated to, by region name, same as for ImagesModel.
+func (m *DeletionsModel) SetReplicas(replicas map[string]FileStorage) {
+	m.replicas = replicas
+}
+
+// SetMetrics configures recording of the failed removals pending retry,
+// not recorded by default.
+func (m *DeletionsModel) SetMetrics(metrics DeletionMetrics) {
+	m.metrics = metrics
+}
+
+// RetryDeletions retries the failed removals of the tenant from the context
+// which are due. Removals failing again are retried after the backoff.
+// Returns the number of removed files.
+func (m *DeletionsModel) RetryDeletions(ctx context.Context) (int, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "DeletionsModel.RetryDeletions")
+	defer span.End()
+
+	l := log.FromContext(ctx)
+
+	now := time.Now()
+	due, err := m.imagesStorage.FindFailedDeletions(ctx, now)
+	if err != nil {
+		span.SetError(err)
+		return 0, errors.Wrap(err, "Searching for failed deletions")
+	}
+
+	removed := 0
+	for _, deletion := range due {
+		ld := l.F(log.Ctx{"object_key": deletion.ObjectKey, "region": deletion.Region})
+
+		err := m.deleteFile(ctx, deletion)
+		if err == nil {
+			removed++
+			if err := m.imagesStorage.DeleteFailedDeletion(ctx, deletion.Id); err != nil {
+				ld.F(log.Ctx{"error": err.Error()}).Error("failed to remove failed deletion")
+			}
+			continue
+		}
+
+		deletion.Retried(err, now, m.retryBackoff(deletion.Attempts))
+		ld.F(log.Ctx{"attempts": deletion.Attempts, "error": err.Error()}).
+			Error("failed to delete image file again")
+		if err := m.imagesStorage.SaveFailedDeletion(ctx, deletion); err != nil {
+			ld.F(log.Ctx{"error": err.Error()}).Error("failed to record failed deletion")
+		}
+	}
+
+	span.SetAttribute("removed", removed)
+
+	if m.metrics != nil {
+		pending, err := m.imagesStorage.CountFailedDeletions(ctx)
+		if err != nil {
+			return removed, errors.Wrap(err, "Counting failed deletions")
+		}
+		m.metrics.FailedDeletionsPending(ctx, pending)
+	}
+
+	return removed, nil
+}
+
+// deleteFile removes the file of the failed deletion from the file storage
+// of its region.
+func (m *DeletionsModel) deleteFile(ctx context.Context,
+	deletion *images.FailedDeletion) error {
+
+	storage := m.fileStorage
+	if deletion.Region != "" {
+		replica, ok := m.replicas[deletion.Region]
+		if !ok {
+			return ErrReplicaNotConfigured
+		}
+		storage = replica
+	}
+	return storage.Delete(ctx, deletion.ObjectKey)
+}
+
+// retryBackoff returns the delay of the next retry after the failed
+// attempts made so far.
+func (m *DeletionsModel) retryBackoff(attempts int) time.Duration {
+	shift := attempts - 1
+	if shift > maxDeletionBackoffShift {
+		shift = maxDeletionBackoffShift
+	}
+	return m.backoff << uint(shift)
+}
+
+// RetryAllDeletions retries the failed removals of all the tenants.
+func (m *DeletionsModel) RetryAllDeletions(ctx context.Context) error {
+	return forEachTenant(ctx, m.tenants, func(tenantCtx context.Context, tenant string) error {
+		removed, err := m.RetryDeletions(tenantCtx)
+		if err != nil {
+			return errors.Wrapf(err, "Retrying failed deletions of tenant '%s'", tenant)
+		}
+
+		if removed > 0 {
+			log.FromContext(ctx).F(log.Ctx{
+				"tenant_id": tenant,
+				"removed":   removed,
+			}).Info("image files of failed deletions removed")
+		}
+		return nil
+	})
+}
+
+// Run retries the failed removals of all the tenants periodically,
+// until the context is cancelled.
+func (m *DeletionsModel) Run(ctx context.Context) {
+	if m.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := m.RetryAllDeletions(ctx); err != nil {
+			log.FromContext(ctx).F(log.Ctx{"error": err.Error()}).
+				Error("retry of failed deletions failed")
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+type FakeDeletionMetrics struct {
+	pending int
+}
+
+func (m *FakeDeletionMetrics) FailedDeletionsPending(ctx context.Context, count int) {
+	m.pending = count
+}
+
+func TestDeleteImageFailedDeletion(t *testing.T) {
+	image := images.NewSoftwareImage(validUUIDv4,
+		createValidImageMeta(), createValidImageMetaArtifact())
+	image.Replicas = []string{"eu-west-1", "us-east-1"}
+
+	fakeIS := &FakeImageStorage{findByIdImage: image}
+	iModel := NewImagesModel(&FakeFileStorage{deleteError: errors.New("timeout")},
+		new(FakeUseChecker), fakeIS, nil, nil)
+	iModel.SetReplicas(map[string]FileStorage{
+		"eu-west-1": &FakeFileStorage{deleteError: errors.New("access denied")},
+		"us-east-1": new(FakeFileStorage),
+	})
+
+	assert.NoError(t, iModel.DeleteImage(context.Background(), validUUIDv4))
+
+	if assert.Len(t, fakeIS.failedDeletions, 2) {
+		primary := fakeIS.failedDeletions[validUUIDv4]
+		if assert.NotNil(t, primary) {
+			assert.Equal(t, validUUIDv4, primary.ObjectKey)
+			assert.Equal(t, "", primary.Region)
+			assert.Equal(t, "timeout", primary.Error)
+			assert.Equal(t, 1, primary.Attempts)
+		}
+		replica := fakeIS.failedDeletions["eu-west-1:"+validUUIDv4]
+		if assert.NotNil(t, replica) {
+			assert.Equal(t, "eu-west-1", replica.Region)
+			assert.Equal(t, "access denied", replica.Error)
+		}
+	}
+
+	// failure to record is not a failure of the removal
+	fakeIS.failedDeletionsError = errors.New("db error")
+	assert.NoError(t, iModel.DeleteImage(context.Background(), validUUIDv4))
+}
+
+func TestRetryDeletions(t *testing.T) {
+	now := time.Now()
+	failed := errors.New("timeout")
+
+	primary := images.NewFailedDeletion("", "1", failed, now.Add(-time.Hour))
+	replica := images.NewFailedDeletion("eu-west-1", "1", failed, now.Add(-time.Hour))
+	replica.Attempts = 3
+	unknown := images.NewFailedDeletion("ap-south-1", "1", failed, now.Add(-time.Hour))
+	notDue := images.NewFailedDeletion("", "2", failed, now)
+	notDue.RetryAt = now.Add(time.Hour)
+
+	fakeIS := &FakeImageStorage{failedDeletions: map[string]*images.FailedDeletion{
+		primary.Id: primary,
+		replica.Id: replica,
+		unknown.Id: unknown,
+		notDue.Id:  notDue,
+	}}
+	metrics := new(FakeDeletionMetrics)
+
+	model := NewDeletionsModel(new(FakeFileStorage), fakeIS, nil, 0, time.Minute)
+	model.SetReplicas(map[string]FileStorage{
+		"eu-west-1": &FakeFileStorage{deleteError: errors.New("access denied")},
+	})
+	model.SetMetrics(metrics)
+
+	removed, err := model.RetryDeletions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.NotContains(t, fakeIS.failedDeletions, primary.Id)
+	assert.Equal(t, 3, metrics.pending)
+
+	// failed again, retried after the doubled backoff
+	retried := fakeIS.failedDeletions[replica.Id]
+	assert.Equal(t, 4, retried.Attempts)
+	assert.Equal(t, "access denied", retried.Error)
+	assert.True(t, retried.RetryAt.After(now.Add(4*time.Minute-time.Second)))
+	assert.True(t, retried.RetryAt.Before(now.Add(4*time.Minute+time.Second)))
+
+	retried = fakeIS.failedDeletions[unknown.Id]
+	assert.Equal(t, 2, retried.Attempts)
+	assert.Equal(t, ErrReplicaNotConfigured.Error(), retried.Error)
+
+	assert.Equal(t, notDue, fakeIS.failedDeletions[notDue.Id])
+
+	fakeIS.failedDeletionsError = errors.New("db error")
+	_, err = model.RetryDeletions(context.Background())
+	assert.EqualError(t, err, "Searching for failed deletions: db error")
+}
+
+func TestRetryDeletionsBackoff(t *testing.T) {
+	model := NewDeletionsModel(nil, nil, nil, 0, time.Second)
+
+	assert.Equal(t, time.Second, model.retryBackoff(1))
+	assert.Equal(t, 2*time.Second, model.retryBackoff(2))
+	assert.Equal(t, 8*time.Second, model.retryBackoff(4))
+	assert.Equal(t, 1024*time.Second, model.retryBackoff(11))
+	assert.Equal(t, 1024*time.Second, model.retryBackoff(100))
+}
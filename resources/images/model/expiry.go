@@ -0,0 +1,137 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+// ImageRemover removes the image metadata along with the image file
+type ImageRemover interface {
+	DeleteImage(ctx context.Context, imageID string) error
+}
+
+// ExpiryModel removes the images past their expiry time.
+type ExpiryModel struct {
+	images        ImageRemover
+	imagesStorage SoftwareImagesStorage
+	tenants       TenantsLister
+	interval      time.Duration
+}
+
+// NewExpiryModel creates the model. Expired images of all the tenants are
+// removed every interval by Run, interval of 0 disables the removal.
+func NewExpiryModel(
+	images ImageRemover,
+	imagesStorage SoftwareImagesStorage,
+	tenants TenantsLister,
+	interval time.Duration,
+) *ExpiryModel {
+	return &ExpiryModel{
+		images:        images,
+		imagesStorage: imagesStorage,
+		tenants:       tenants,
+		interval:      interval,
+	}
+}
+
+// RemoveExpiredImages removes the expired images of the tenant from
+// the context. Images used in active deployments are kept until the
+// deployments finish; failures are only logged, next run will retry.
+// Returns the number of removed images.
+func (m *ExpiryModel) RemoveExpiredImages(ctx context.Context) (int, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ExpiryModel.RemoveExpiredImages")
+	defer span.End()
+
+	l := log.FromContext(ctx)
+
+	expired, err := m.imagesStorage.FindExpired(ctx, time.Now())
+	if err != nil {
+		span.SetError(err)
+		return 0, errors.Wrap(err, "Searching for expired images")
+	}
+
+	removed := 0
+	for _, image := range expired {
+		err := m.images.DeleteImage(ctx, image.Id)
+		switch errors.Cause(err) {
+		case nil:
+			removed++
+			l.F(log.Ctx{"image_id": image.Id}).Info("expired image removed")
+		case controller.ErrImageMetaNotFound:
+			// removed in the meantime
+		case controller.ErrModelImageInActiveDeployment:
+			l.F(log.Ctx{"image_id": image.Id}).
+				Info("expired image is used in active deployment, not removed")
+		default:
+			l.F(log.Ctx{"image_id": image.Id, "error": err.Error()}).
+				Error("failed to remove expired image")
+		}
+	}
+
+	span.SetAttribute("removed", removed)
+
+	return removed, nil
+}
+
+// RemoveAllExpiredImages removes the expired images of all the tenants.
+func (m *ExpiryModel) RemoveAllExpiredImages(ctx context.Context) error {
+	return forEachTenant(ctx, m.tenants, func(tenantCtx context.Context, tenant string) error {
+		removed, err := m.RemoveExpiredImages(tenantCtx)
+		if err != nil {
+			return errors.Wrapf(err, "Removing expired images of tenant '%s'", tenant)
+		}
+
+		if removed > 0 {
+			log.FromContext(ctx).F(log.Ctx{
+				"tenant_id": tenant,
+				"removed":   removed,
+			}).Info("expired images removed")
+		}
+		return nil
+	})
+}
+
+// Run removes the expired images of all the tenants periodically,
+// until the context is cancelled.
+func (m *ExpiryModel) Run(ctx context.Context) {
+	if m.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := m.RemoveAllExpiredImages(ctx); err != nil {
+			log.FromContext(ctx).F(log.Ctx{"error": err.Error()}).
+				Error("removal of expired images failed")
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+// FakeImageRemover records the removed images of each tenant
+type FakeImageRemover struct {
+	errs    map[string]error
+	removed map[string][]string
+}
+
+func (fir *FakeImageRemover) DeleteImage(ctx context.Context, imageID string) error {
+	if err := fir.errs[imageID]; err != nil {
+		return err
+	}
+	tenant := ""
+	if id := identity.FromContext(ctx); id != nil {
+		tenant = id.Tenant
+	}
+	if fir.removed == nil {
+		fir.removed = map[string][]string{}
+	}
+	fir.removed[tenant] = append(fir.removed[tenant], imageID)
+	return nil
+}
+
+func TestRemoveExpiredImages(t *testing.T) {
+	fakeIS := &FakeImageStorage{
+		expiredImages: []*images.SoftwareImage{
+			{Id: "1"}, {Id: "2"}, {Id: "3"}, {Id: "4"},
+		},
+	}
+	remover := &FakeImageRemover{
+		errs: map[string]error{
+			"2": controller.ErrModelImageInActiveDeployment,
+			"3": controller.ErrImageMetaNotFound,
+			"4": errors.New("storage error"),
+		},
+	}
+
+	model := NewExpiryModel(remover, fakeIS, nil, 0)
+
+	// failures are retried with the next run
+	removed, err := model.RemoveExpiredImages(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, map[string][]string{"": {"1"}}, remover.removed)
+
+	fakeIS.findExpiredError = errors.New("db error")
+	_, err = model.RemoveExpiredImages(context.Background())
+	assert.EqualError(t, err, "Searching for expired images: db error")
+}
+
+func TestRemoveAllExpiredImages(t *testing.T) {
+	testCases := []struct {
+		tenants []string
+		listErr error
+
+		removed map[string][]string
+		err     bool
+	}{
+		{
+			tenants: []string{},
+			removed: map[string][]string{"": {"1"}},
+		},
+		{
+			tenants: []string{"foo", "bar"},
+			removed: map[string][]string{"foo": {"1"}, "bar": {"1"}},
+		},
+		{
+			listErr: errors.New("db error"),
+			err:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		fakeIS := &FakeImageStorage{
+			expiredImages: []*images.SoftwareImage{{Id: "1"}},
+		}
+		remover := &FakeImageRemover{}
+		model := NewExpiryModel(remover, fakeIS,
+			&FakeTenantsLister{tenants: tc.tenants, err: tc.listErr}, 0)
+
+		err := model.RemoveAllExpiredImages(context.Background())
+		if tc.err {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, tc.removed, remover.removed)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+// FileRetry bounds the lookups of the recently written image files, which
+// eventually consistent file storages may report missing for a while
+// after the write. Files written earlier are reported missing right away,
+// so that the files which are really missing are not masked.
+type FileRetry struct {
+	// Window after the write the missing file is looked up again in;
+	// no retries if zero
+	Window time.Duration
+	// Attempts is the number of lookups before the file is reported missing
+	Attempts int
+	// Backoff is the delay before the first retry, doubled with each
+	// subsequent one
+	Backoff time.Duration
+}
+
+// SetFileRetry configures the lookups of the recently written image files,
+// no retries by default.
+func (i *ImagesModel) SetFileRetry(retry FileRetry) {
+	i.fileRetry = retry
+}
+
+// retries tells if the missing file of the image is looked up again
+// after the attempts made so far. Image files are written on creation
+// and on upload of the pending images, both recorded as the modification.
+func (r FileRetry) retries(image *images.SoftwareImage, attempts int) bool {
+	return attempts < r.Attempts && image.Modified != nil &&
+		time.Since(*image.Modified) < r.Window
+}
+
+// wait sleeps for the backoff of the retry following the attempts made,
+// false if the context is done first.
+func (r FileRetry) wait(ctx context.Context, attempts int) bool {
+	timer := time.NewTimer(r.Backoff << uint(attempts-1))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// fileExists checks if the image file exists, looking up the recently
+// written file again while it is not found.
+func (i *ImagesModel) fileExists(ctx context.Context,
+	image *images.SoftwareImage, objectKey string) (bool, error) {
+
+	for attempts := 1; ; attempts++ {
+		found, err := i.fileStorage.Exists(ctx, objectKey)
+		if err != nil || found ||
+			!i.fileRetry.retries(image, attempts) || !i.fileRetry.wait(ctx, attempts) {
+			return found, err
+		}
+	}
+}
+
+// openFile opens the image file, looking up the recently written file
+// again while it is not found.
+func (i *ImagesModel) openFile(ctx context.Context,
+	image *images.SoftwareImage, objectKey string) (images.FileReader, error) {
+
+	for attempts := 1; ; attempts++ {
+		file, err := i.fileStorage.OpenObject(ctx, objectKey)
+		if errors.Cause(err) != ErrFileStorageFileNotFound ||
+			!i.fileRetry.retries(image, attempts) || !i.fileRetry.wait(ctx, attempts) {
+			return file, err
+		}
+	}
+}
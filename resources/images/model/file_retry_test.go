@@ -0,0 +1,166 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+// EventualFileStorage reports the files missing for the first lookups,
+// like the eventually consistent storages right after the write
+type EventualFileStorage struct {
+	FakeFileStorage
+	missingLookups int
+	lookups        int
+}
+
+func (efs *EventualFileStorage) Exists(ctx context.Context, objectId string) (bool, error) {
+	efs.lookups++
+	return efs.lookups > efs.missingLookups, nil
+}
+
+func (efs *EventualFileStorage) OpenObject(ctx context.Context,
+	objectId string) (images.FileReader, error) {
+	efs.lookups++
+	if efs.lookups <= efs.missingLookups {
+		return nil, ErrFileStorageFileNotFound
+	}
+	return efs.FakeFileStorage.OpenObject(ctx, objectId)
+}
+
+func TestFileRetry(t *testing.T) {
+	retry := FileRetry{
+		Window:   time.Minute,
+		Attempts: 3,
+		Backoff:  time.Millisecond,
+	}
+	recent := time.Now()
+	old := recent.Add(-time.Hour)
+
+	testCases := map[string]struct {
+		retry          FileRetry
+		modified       *time.Time
+		missingLookups int
+
+		found   bool
+		lookups int
+	}{
+		"found": {
+			retry:    retry,
+			modified: &recent,
+			found:    true,
+			lookups:  1,
+		},
+		"found on retry": {
+			retry:          retry,
+			modified:       &recent,
+			missingLookups: 2,
+			found:          true,
+			lookups:        3,
+		},
+		"attempts exhausted": {
+			retry:          retry,
+			modified:       &recent,
+			missingLookups: 3,
+			lookups:        3,
+		},
+		"written before the window": {
+			retry:          retry,
+			modified:       &old,
+			missingLookups: 1,
+			lookups:        1,
+		},
+		"modification not known": {
+			retry:          retry,
+			missingLookups: 1,
+			lookups:        1,
+		},
+		"retries disabled": {
+			modified:       &recent,
+			missingLookups: 1,
+			lookups:        1,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			image := images.NewSoftwareImage(validUUIDv4,
+				createValidImageMeta(), createValidImageMetaArtifact())
+			image.Modified = tc.modified
+
+			fakeIS := new(FakeImageStorage)
+			fakeIS.findByIdImage = image
+
+			// file downloaded with the link
+			fakeFS := &EventualFileStorage{missingLookups: tc.missingLookups}
+			fakeFS.getReq = images.NewLink("uri", time.Now())
+			iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+			iModel.SetFileRetry(tc.retry)
+
+			link, err := iModel.DownloadLink(context.Background(), validUUIDv4, time.Hour, "")
+			if tc.found {
+				assert.NoError(t, err)
+				assert.NotNil(t, link)
+			} else {
+				assert.Equal(t, controller.ErrModelArtifactFileMissing, err)
+			}
+			assert.Equal(t, tc.lookups, fakeFS.lookups)
+
+			// file served through the service
+			fakeFS = &EventualFileStorage{missingLookups: tc.missingLookups}
+			fakeFS.objects = map[string][]byte{validUUIDv4: []byte("artifact")}
+			iModel = NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+			iModel.SetFileRetry(tc.retry)
+
+			file, err := iModel.OpenImage(context.Background(), validUUIDv4)
+			if tc.found {
+				assert.NoError(t, err)
+				assert.NotNil(t, file)
+			} else {
+				assert.Error(t, err)
+			}
+			assert.Equal(t, tc.lookups, fakeFS.lookups)
+		})
+	}
+}
+
+func TestFileRetryContextDone(t *testing.T) {
+	image := images.NewSoftwareImage(validUUIDv4,
+		createValidImageMeta(), createValidImageMetaArtifact())
+
+	fakeIS := new(FakeImageStorage)
+	fakeIS.findByIdImage = image
+	fakeFS := &EventualFileStorage{missingLookups: 10}
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+	iModel.SetFileRetry(FileRetry{
+		Window:   time.Minute,
+		Attempts: 10,
+		Backoff:  time.Hour,
+	})
+
+	// client gone, no point in waiting for the file
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := iModel.DownloadLink(ctx, validUUIDv4, time.Hour, "")
+	assert.Equal(t, controller.ErrModelArtifactFileMissing, err)
+	assert.Equal(t, 1, fakeFS.lookups)
+}
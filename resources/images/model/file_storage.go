@@ -26,17 +26,31 @@ import (
 // Errors specific to interface
 var (
 	ErrFileStorageFileNotFound = errors.New("File not found")
+	ErrFileStorageNotSupported = errors.New("Operation not supported by the file storage")
+
+	// Failures of the file storage itself, rather than of the request
+	ErrFileStorageAccessDenied  = errors.New("File storage access denied")
+	ErrFileStorageMisconfigured = errors.New("File storage misconfigured")
+	ErrFileStorageThrottled     = errors.New("File storage throttled the request")
 )
 
-// FileStorage allows to store and manage large files
+// FileStorage allows to store and manage large files.
+// Files are identified by their keys in the storage, see images.ObjectKeyTemplate.
 type FileStorage interface {
 	Delete(ctx context.Context, objectId string) error
 	Exists(ctx context.Context, objectId string) (bool, error)
 	LastModified(ctx context.Context, objectId string) (time.Time, error)
 	PutRequest(ctx context.Context, objectId string,
 		duration time.Duration) (*images.Link, error)
-	GetRequest(ctx context.Context, objectId string,
+	GetRequest(ctx context.Context, objectId, versionId string,
 		duration time.Duration, responseContentType string) (*images.Link, error)
 	UploadArtifact(ctx context.Context, objectId string,
 		artifactSize int64, artifact io.Reader, contentType string) error
+	GetObject(ctx context.Context, objectId string) (io.ReadCloser, error)
+	OpenObject(ctx context.Context, objectId string) (images.FileReader, error)
+	ObjectVersion(ctx context.Context, objectId string) (string, error)
+	RotateObject(ctx context.Context, objectId string) (string, error)
+	CopyObject(ctx context.Context, objectId, newObjectId string) error
+	MoveObject(ctx context.Context, objectId, newObjectId string) error
+	Location(objectId string) *images.StorageLocation
 }
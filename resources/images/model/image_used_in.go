@@ -23,3 +23,13 @@ type ImageUsedIn interface {
 	ImageUsedInActiveDeployment(ctx context.Context, imageId string) (bool, error)
 	ImageUsedInDeployment(ctx context.Context, imageId string) (bool, error)
 }
+
+// Allows to abort the active deployments using the image, so that it can be removed
+type DeploymentsAborter interface {
+	AbortImageDeployments(ctx context.Context, imageID string) (int, error)
+}
+
+// Allows to check if the image is assigned to the device by an active deployment
+type DeviceAssignmentChecker interface {
+	ImageAssignedToDevice(ctx context.Context, deviceID, imageID string) (bool, error)
+}
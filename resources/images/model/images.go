@@ -18,8 +18,11 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"sort"
 	"time"
 
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
 	"github.com/mendersoftware/mender-artifact/areader"
 	"github.com/mendersoftware/mender-artifact/artifact"
 	"github.com/mendersoftware/mender-artifact/handlers"
@@ -28,28 +31,84 @@ import (
 
 	"github.com/mendersoftware/deployments/resources/images"
 	"github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/utils/tracing"
 )
 
 const (
 	ArtifactContentType = "application/vnd.mender-artifact"
+
+	// maximum image size is 10G
+	MaxImageSize = 1024 * 1024 * 1024 * 10
 )
 
 type ImagesModel struct {
 	fileStorage   FileStorage
 	deployments   ImageUsedIn
 	imagesStorage SoftwareImagesStorage
+	keyTemplate   *images.ObjectKeyTemplate
+	trustedKeys   []*TrustedKey
+	fileRetry     FileRetry
+	replicas      map[string]FileStorage
+	aborter       DeploymentsAborter
+	limits        LimitGetter
+	assignments   DeviceAssignmentChecker
+	uploadTimeout time.Duration
+	scanning      ArtifactScanning
+	compose       ComposeScratch
 }
 
+// NewImagesModel creates the model, artifact files are stored according
+// to keyTemplate, nil means the default layout.
+// If trustedKeys are given, only the artifacts signed with one of them are accepted.
 func NewImagesModel(
 	fileStorage FileStorage,
 	checker ImageUsedIn,
 	imagesStorage SoftwareImagesStorage,
+	keyTemplate *images.ObjectKeyTemplate,
+	trustedKeys []*TrustedKey,
 ) *ImagesModel {
+	if keyTemplate == nil {
+		keyTemplate, _ = images.NewObjectKeyTemplate(images.DefaultObjectKeyTemplate)
+	}
 	return &ImagesModel{
 		fileStorage:   fileStorage,
 		deployments:   checker,
 		imagesStorage: imagesStorage,
+		keyTemplate:   keyTemplate,
+		trustedKeys:   trustedKeys,
+	}
+}
+
+// tenantFromContext returns ID of the tenant the request is made for,
+// empty string if there's none.
+func tenantFromContext(ctx context.Context) string {
+	if id := identity.FromContext(ctx); id != nil {
+		return id.Tenant
+	}
+	return ""
+}
+
+// countUsage adds to the usage of the tenant. Failures are only logged,
+// the usage is reconciled with the stored images periodically anyway.
+func (i *ImagesModel) countUsage(ctx context.Context, artifacts, size int64) {
+	if err := i.imagesStorage.IncUsage(ctx, artifacts, size); err != nil {
+		log.FromContext(ctx).F(log.Ctx{"error": err.Error()}).
+			Error("failed to count artifacts usage")
+	}
+}
+
+// unavailableError tells why the image can't be deployed nor downloaded,
+// nil if it can.
+func unavailableError(image *images.SoftwareImage) error {
+	switch image.Status {
+	case images.ImageStatusPending:
+		return controller.ErrModelImagePending
+	case images.ImageStatusScanning:
+		return controller.ErrModelImageScanning
+	case images.ImageStatusQuarantined:
+		return controller.ErrModelImageQuarantined
 	}
+	return nil
 }
 
 // CreateImage parses artifact and uploads artifact file to the file storage - in parallel,
@@ -58,45 +117,178 @@ func NewImagesModel(
 func (i *ImagesModel) CreateImage(ctx context.Context,
 	multipartUploadMsg *controller.MultipartUploadMsg) (string, error) {
 
-	// maximum image size is 10G
-	const MaxImageSize = 1024 * 1024 * 1024 * 10
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.CreateImage")
+	defer span.End()
+
+	if err := checkUploadMsg(multipartUploadMsg); err != nil {
+		return "", err
+	}
+
+	if err := multipartUploadMsg.MetaConstructor.ValidateRequired(); err != nil {
+		return "", controller.NewInvalidMetadataError(err)
+	}
+
+	if err := i.reserveArtifact(ctx); err != nil {
+		return "", err
+	}
+
+	span.SetAttribute("artifact_size", multipartUploadMsg.ArtifactSize)
+
+	artifactID := uuid.NewV4().String()
+	span.SetAttribute("image_id", artifactID)
+
+	objectKey, err := i.handleArtifact(ctx, artifactID, multipartUploadMsg, i.storeImage)
+	span.SetError(err)
+	// the file of the quarantined image is kept for the inspection
+	if errors.Cause(err) == controller.ErrModelImageQuarantined {
+		return "", err
+	}
+	// try to remove artifact file from file storage on error
+	if err != nil {
+		i.releaseArtifact(ctx)
+		if cleanupErr := i.fileStorage.Delete(ctx,
+			objectKey); cleanupErr != nil {
+			return "", errors.Wrap(err, cleanupErr.Error())
+		}
+		return "", err
+	}
+
+	i.replicateImage(ctx, artifactID)
+	return artifactID, nil
+}
 
+// checkUploadMsg checks if the upload message is complete.
+func checkUploadMsg(multipartUploadMsg *controller.MultipartUploadMsg) error {
 	switch {
 	case multipartUploadMsg == nil:
-		return "", controller.ErrModelMultipartUploadMsgMalformed
+		return controller.ErrModelMultipartUploadMsgMalformed
 	case multipartUploadMsg.MetaConstructor == nil:
-		return "", controller.ErrModelMissingInputMetadata
+		return controller.ErrModelMissingInputMetadata
 	case multipartUploadMsg.ArtifactReader == nil:
-		return "", controller.ErrModelMissingInputArtifact
+		return controller.ErrModelMissingInputArtifact
 	case multipartUploadMsg.ArtifactSize > MaxImageSize:
-		return "", controller.ErrModelArtifactFileTooLarge
+		return controller.ErrModelArtifactFileTooLarge
+	}
+	return nil
+}
+
+// CreatePendingImage registers the image ahead of its artifact file, which
+// is uploaded later with UploadPendingImage. The image reserves the artifact
+// name for its device types, but can't be deployed until the file is uploaded.
+// Returns image ID and nil on success.
+func (i *ImagesModel) CreatePendingImage(ctx context.Context,
+	pending *images.SoftwareImagePending) (string, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.CreatePendingImage")
+	defer span.End()
+
+	if err := pending.Validate(); err != nil {
+		return "", controller.NewInvalidMetadataError(err)
+	}
+
+	isArtifactUnique, err := i.isArtifactUnique(ctx,
+		pending.Name, pending.DeviceTypesCompatible, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "Fail to check if artifact is unique")
+	}
+	if !isArtifactUnique {
+		return "", controller.ErrModelArtifactNotUnique
+	}
+
+	if err := i.reserveArtifact(ctx); err != nil {
+		return "", err
+	}
+
+	image := images.NewPendingSoftwareImage(uuid.NewV4().String(), pending)
+	span.SetAttribute("image_id", image.Id)
+
+	if err := i.imagesStorage.Insert(ctx, image); err != nil {
+		i.releaseArtifact(ctx)
+		return "", errors.Wrap(err, "Fail to store the metadata")
+	}
+
+	return image.Id, nil
+}
+
+// UploadPendingImage parses the artifact and uploads the artifact file of the
+// pending image, which becomes ready then. Name of the artifact has to match
+// the pending image, the user provided metadata of the image is kept.
+func (i *ImagesModel) UploadPendingImage(ctx context.Context, id string,
+	multipartUploadMsg *controller.MultipartUploadMsg) error {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.UploadPendingImage")
+	defer span.End()
+	span.SetAttribute("image_id", id)
+
+	if err := checkUploadMsg(multipartUploadMsg); err != nil {
+		return err
+	}
+	if multipartUploadMsg.Delta != nil {
+		return errors.Wrap(controller.ErrModelInvalidMetadata,
+			"pending artifacts can't be delta updates")
+	}
+
+	span.SetAttribute("artifact_size", multipartUploadMsg.ArtifactSize)
+
+	pending, err := i.imagesStorage.FindByID(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "Searching for image with specified ID")
+	}
+	if pending == nil {
+		return controller.ErrImageMetaNotFound
+	}
+	if !pending.IsPending() {
+		return controller.ErrModelImageNotPending
 	}
 
-	artifactID, err := i.handleArtifact(ctx, multipartUploadMsg)
+	objectKey, err := i.handleArtifact(ctx, id, multipartUploadMsg,
+		func(ctx context.Context, objectKey string,
+			image *images.SoftwareImage) (string, error) {
+			return i.attachImageFile(ctx, objectKey, pending, image)
+		})
+	span.SetError(err)
+	// the file of the quarantined image is kept for the inspection
+	if errors.Cause(err) == controller.ErrModelImageQuarantined {
+		return err
+	}
 	// try to remove artifact file from file storage on error
 	if err != nil {
 		if cleanupErr := i.fileStorage.Delete(ctx,
-			artifactID); cleanupErr != nil {
-			return "", errors.Wrap(err, cleanupErr.Error())
+			objectKey); cleanupErr != nil {
+			return errors.Wrap(err, cleanupErr.Error())
 		}
+		return err
 	}
-	return artifactID, err
+
+	i.replicateImage(ctx, id)
+	return nil
 }
 
+// imageStoreFunc saves the image, which file is stored under the objectKey.
+// Returns the key of the artifact file, also on error, so that it can be removed.
+type imageStoreFunc func(ctx context.Context, objectKey string,
+	image *images.SoftwareImage) (string, error)
+
 // handleArtifact parses artifact and uploads artifact file to the file storage - in parallel,
-// and creates image structure in the system.
-// Returns image ID, artifact file ID and nil on success.
-func (i *ImagesModel) handleArtifact(ctx context.Context,
-	multipartUploadMsg *controller.MultipartUploadMsg) (string, error) {
+// and saves the image structure with the store function.
+// Returns the key of the artifact file, also on error, so that it can be removed.
+func (i *ImagesModel) handleArtifact(ctx context.Context, artifactID string,
+	multipartUploadMsg *controller.MultipartUploadMsg, store imageStoreFunc) (string, error) {
 
 	// create pipe
 	pR, pW := io.Pipe()
 
 	// limit reader to the size provided with the upload message
-	lr := io.LimitReader(multipartUploadMsg.ArtifactReader, multipartUploadMsg.ArtifactSize)
-	tee := io.TeeReader(lr, pW)
+	counter := &countingReader{
+		r: io.LimitReader(multipartUploadMsg.ArtifactReader, multipartUploadMsg.ArtifactSize),
+	}
+	checksums := images.NewChecksumsWriter()
+	tee := io.TeeReader(counter, io.MultiWriter(pW, checksums))
 
-	artifactID := uuid.NewV4().String()
+	// the file is uploaded with the default layout first,
+	// metadata the layout may depend on is known once the artifact is parsed
+	tenant := tenantFromContext(ctx)
+	objectKey := images.ObjectKey(tenant, artifactID)
 
 	ch := make(chan error)
 	// create goroutine for artifact upload
@@ -106,30 +298,73 @@ func (i *ImagesModel) handleArtifact(ctx context.Context,
 	//
 	// uploading and parsing artifact in the same process will cause in a deadlock!
 	go func() {
-		err := i.fileStorage.UploadArtifact(ctx,
-			artifactID, multipartUploadMsg.ArtifactSize, pR, ArtifactContentType)
+		uploadCtx, uploadSpan := tracing.StartSpan(ctx, "FileStorage.UploadArtifact")
+		uploadSpan.SetAttribute("image_id", artifactID)
+		uploadSpan.SetAttribute("artifact_size", multipartUploadMsg.ArtifactSize)
+		if i.uploadTimeout > 0 {
+			var cancel context.CancelFunc
+			uploadCtx, cancel = context.WithTimeout(uploadCtx, i.uploadTimeout)
+			defer cancel()
+		}
+
+		err := i.fileStorage.UploadArtifact(uploadCtx,
+			objectKey, multipartUploadMsg.ArtifactSize, pR, ArtifactContentType)
+		// the request may still go on, tell the storage step timed out
+		if err != nil && uploadCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			err = errors.Wrap(controller.ErrModelStorageUploadTimeout, err.Error())
+		}
 		if err != nil {
 			pR.CloseWithError(err)
 		}
+
+		uploadSpan.SetError(err)
+		uploadSpan.End()
 		ch <- err
 	}()
 
 	// parse artifact
 	// artifact library reads all the data from the given reader
-	metaArtifactConstructor, err := getMetaFromArchive(&tee)
+	_, parseSpan := tracing.StartSpan(ctx, "ImagesModel.parseArtifact")
+	metaArtifactConstructor, err := getMetaFromArchive(&tee, i.trustedKeys)
+	parseSpan.SetError(err)
+	parseSpan.End()
 	if err != nil {
-		pW.Close()
-		<-ch
-		return artifactID, errors.Wrap(controller.ErrModelParsingArtifactFailed, err.Error())
+		// abort the upload, artifact file is not stored partially
+		pW.CloseWithError(err)
+		// parsing fails also when the upload does, report the storage failure then
+		uploadErr := fileStorageError(<-ch)
+		switch errors.Cause(uploadErr) {
+		case controller.ErrModelStorageAccessDenied, controller.ErrModelStorageMisconfigured,
+			controller.ErrModelStorageThrottled, controller.ErrModelStorageUploadTimeout:
+			return objectKey, uploadErr
+		}
+		// artifact cut short is reported as such, rather than as malformed
+		if counter.n < multipartUploadMsg.ArtifactSize && isExhausted(counter) {
+			return objectKey, controller.ErrModelUploadSizeMismatch
+		}
+		switch errors.Cause(err) {
+		case controller.ErrModelArtifactNotSigned, controller.ErrModelArtifactSignatureInvalid:
+			return objectKey, err
+		}
+		return objectKey, errors.Wrap(controller.ErrModelParsingArtifactFailed, err.Error())
 	}
 
 	// read the rest of the data,
 	// just in case the artifact library did not read all the data from the reader
 	_, err = io.Copy(ioutil.Discard, tee)
 	if err != nil {
-		pW.Close()
+		pW.CloseWithError(err)
 		<-ch
-		return "", err
+		return objectKey, err
+	}
+
+	// the file has to be exactly of the declared size, neither truncated
+	// nor followed by more data
+	if counter.n != multipartUploadMsg.ArtifactSize ||
+		!isExhausted(multipartUploadMsg.ArtifactReader) {
+		pW.CloseWithError(controller.ErrModelUploadSizeMismatch)
+		<-ch
+		return objectKey, controller.ErrModelUploadSizeMismatch
 	}
 
 	// close the pipe
@@ -137,215 +372,1335 @@ func (i *ImagesModel) handleArtifact(ctx context.Context,
 
 	// collect output from the goroutine
 	if uploadResponseErr := <-ch; uploadResponseErr != nil {
-		return "", uploadResponseErr
+		return objectKey, fileStorageError(uploadResponseErr)
+	}
+
+	image := images.NewSoftwareImage(
+		artifactID, multipartUploadMsg.MetaConstructor, metaArtifactConstructor)
+	image.Delta = multipartUploadMsg.Delta
+	image.Size = multipartUploadMsg.ArtifactSize
+	image.SetChecksums(checksums.Checksums())
+
+	if !multipartUploadMsg.Checksums.Matches(image.Checksums) {
+		return objectKey, controller.ErrModelUploadChecksumMismatch
 	}
 
+	return store(ctx, objectKey, image)
+}
+
+// storeImage validates the metadata of the image, which file is stored under
+// the objectKey, and saves it. The file is moved to the key defined by the key
+// template if it differs. The image has to be reserved with reserveArtifact.
+// Returns the key of the artifact file, also on error, so that it can be removed.
+func (i *ImagesModel) storeImage(ctx context.Context, objectKey string,
+	image *images.SoftwareImage) (string, error) {
+
 	// validate artifact metadata
-	if err = metaArtifactConstructor.Validate(); err != nil {
-		return "", controller.ErrModelInvalidMetadata
+	if err := image.SoftwareImageMetaArtifactConstructor.Validate(); err != nil {
+		return objectKey, errors.Wrap(controller.ErrModelInvalidMetadata, err.Error())
+	}
+
+	if image.Delta != nil && image.Delta.To != image.Name {
+		return objectKey, controller.ErrModelDeltaNameMismatch
 	}
 
 	// check if artifact is unique
 	// artifact is considered to be unique if there is no artifact with the same name
 	// and supporing the same platform in the system
-	isArtifactUnique, err := i.imagesStorage.IsArtifactUnique(ctx,
-		metaArtifactConstructor.Name, metaArtifactConstructor.DeviceTypesCompatible)
+	storeCtx, storeSpan := tracing.StartSpan(ctx, "SoftwareImagesStorage.Insert")
+	defer storeSpan.End()
+	storeSpan.SetAttribute("image_id", image.Id)
+
+	isArtifactUnique, err := i.isArtifactUnique(storeCtx,
+		image.Name, image.DeviceTypesCompatible, image.Delta)
 	if err != nil {
-		return "", errors.Wrap(err, "Fail to check if artifact is unique")
+		return objectKey, errors.Wrap(err, "Fail to check if artifact is unique")
 	}
 	if !isArtifactUnique {
-		return "", controller.ErrModelArtifactNotUnique
+		return objectKey, controller.ErrModelArtifactNotUnique
 	}
 
-	image := images.NewSoftwareImage(
-		artifactID, multipartUploadMsg.MetaConstructor, metaArtifactConstructor)
+	objectKey, err = i.moveImageFile(ctx, objectKey, image)
+	if err != nil {
+		return objectKey, err
+	}
+
+	if err := i.scanImage(ctx, objectKey, image); err != nil {
+		return objectKey, err
+	}
 
 	// save image structure in the system
-	if err = i.imagesStorage.Insert(ctx, image); err != nil {
-		return "", errors.Wrap(err, "Fail to store the metadata")
+	if err = i.imagesStorage.Insert(storeCtx, image); err != nil {
+		return objectKey, errors.Wrap(err, "Fail to store the metadata")
 	}
+	i.countUsage(ctx, 0, image.Size)
 
-	return artifactID, nil
+	if image.IsQuarantined() {
+		return objectKey, controller.ErrModelImageQuarantined
+	}
+	i.scanImageAsync(ctx, objectKey, image)
+
+	return objectKey, nil
 }
 
-// GetImage allows to fetch image obeject with specified id
-// Nil if not found
-func (i *ImagesModel) GetImage(ctx context.Context, id string) (*images.SoftwareImage, error) {
+// attachImageFile validates the metadata of the artifact file of the pending
+// image, which is stored under the objectKey, and saves the image as ready.
+// The file is moved to the key defined by the key template if it differs.
+// Returns the key of the artifact file, also on error, so that it can be removed.
+func (i *ImagesModel) attachImageFile(ctx context.Context, objectKey string,
+	pending, image *images.SoftwareImage) (string, error) {
 
-	image, err := i.imagesStorage.FindByID(ctx, id)
-	if err != nil {
-		return nil, errors.Wrap(err, "Searching for image with specified ID")
+	if err := image.SoftwareImageMetaArtifactConstructor.Validate(); err != nil {
+		return objectKey, errors.Wrap(controller.ErrModelInvalidMetadata, err.Error())
 	}
 
-	if image == nil {
-		return nil, nil
+	if image.Name != pending.Name {
+		return objectKey, controller.ErrModelPendingNameMismatch
 	}
 
-	return image, nil
-}
+	// the pending image reserves the name for its own device types only
+	reserved := make(map[string]bool, len(pending.DeviceTypesCompatible))
+	for _, deviceType := range pending.DeviceTypesCompatible {
+		reserved[deviceType] = true
+	}
+	var added []string
+	for _, deviceType := range image.DeviceTypesCompatible {
+		if !reserved[deviceType] {
+			added = append(added, deviceType)
+		}
+	}
+	if len(added) > 0 {
+		isArtifactUnique, err := i.imagesStorage.IsArtifactUnique(ctx, image.Name, added)
+		if err != nil {
+			return objectKey, errors.Wrap(err, "Fail to check if artifact is unique")
+		}
+		if !isArtifactUnique {
+			return objectKey, controller.ErrModelArtifactNotUnique
+		}
+	}
 
-// DeleteImage removes metadata and image file
-// Noop for not exisitng images
-// Allowed to remove image only if image is not scheduled or in progress for an updates - then image file is needed
-// In case of already finished updates only image file is not needed, metadata is attached directly to device deployment
-// therefore we still have some information about image that have been used (but not the file)
-func (i *ImagesModel) DeleteImage(ctx context.Context, imageID string) error {
-	found, err := i.GetImage(ctx, imageID)
+	image.SoftwareImageMetaConstructor = pending.SoftwareImageMetaConstructor
 
+	objectKey, err := i.moveImageFile(ctx, objectKey, image)
 	if err != nil {
-		return errors.Wrap(err, "Getting image metadata")
+		return objectKey, err
 	}
 
-	if found == nil {
-		return controller.ErrImageMetaNotFound
+	if err := i.scanImage(ctx, objectKey, image); err != nil {
+		return objectKey, err
 	}
 
-	inUse, err := i.deployments.ImageUsedInActiveDeployment(ctx, imageID)
+	updated, err := i.imagesStorage.Update(ctx, image)
 	if err != nil {
-		return errors.Wrap(err, "Checking if image is used in active deployment")
+		return objectKey, errors.Wrap(err, "Fail to store the metadata")
 	}
-
-	// Image is in use, not allowed to delete
-	if inUse {
-		return controller.ErrModelImageInActiveDeployment
+	// removed in the meantime
+	if !updated {
+		return objectKey, controller.ErrImageMetaNotFound
 	}
+	// the pending image is counted already
+	i.countUsage(ctx, 0, image.Size)
 
-	// Delete image file (call to external service)
-	// Noop for not existing file
-	if err := i.fileStorage.Delete(ctx, imageID); err != nil {
-		return errors.Wrap(err, "Deleting image file")
+	if image.IsQuarantined() {
+		return objectKey, controller.ErrModelImageQuarantined
 	}
+	i.scanImageAsync(ctx, objectKey, image)
 
-	// Delete metadata
-	if err := i.imagesStorage.Delete(ctx, imageID); err != nil {
-		return errors.Wrap(err, "Deleting image metadata")
+	return objectKey, nil
+}
+
+// moveImageFile moves the artifact file of the image, stored under the
+// objectKey, to the key defined by the key template if it differs.
+// Returns the key of the artifact file, also on error.
+func (i *ImagesModel) moveImageFile(ctx context.Context, objectKey string,
+	image *images.SoftwareImage) (string, error) {
+
+	image.ObjectKey = i.keyTemplate.ObjectKey(tenantFromContext(ctx), image)
+	if image.ObjectKey != objectKey {
+		if err := i.fileStorage.MoveObject(ctx, objectKey, image.ObjectKey); err != nil {
+			return objectKey, errors.Wrap(fileStorageError(err), "Moving artifact file")
+		}
 	}
 
-	return nil
+	return image.ObjectKey, nil
 }
 
-// ListImages according to specified filers.
-func (i *ImagesModel) ListImages(ctx context.Context,
-	filters map[string]string) ([]*images.SoftwareImage, error) {
+// CreateImageFromUpload creates the image out of the artifact file uploaded
+// directly to the file storage within the upload session. The file is
+// expected under the default key of the image having the ID of the session.
+// Size and, if given, checksums of the file have to match the session.
+// The file is kept on error, so that the upload can be retried.
+// Returns image ID and nil on success.
+func (i *ImagesModel) CreateImageFromUpload(ctx context.Context,
+	session *images.UploadSession) (string, error) {
 
-	imageList, err := i.imagesStorage.FindAll(ctx)
-	if err != nil {
-		return nil, errors.Wrap(err, "Searching for image metadata")
-	}
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.CreateImageFromUpload")
+	defer span.End()
+	span.SetAttribute("image_id", session.Id)
+	span.SetAttribute("artifact_size", session.Size)
 
-	if imageList == nil {
-		return make([]*images.SoftwareImage, 0), nil
+	if err := i.reserveArtifact(ctx); err != nil {
+		return "", err
 	}
+	stored := false
+	defer func() {
+		if !stored {
+			i.releaseArtifact(ctx)
+		}
+	}()
 
-	return imageList, nil
-}
-
-// EditObject allows editing only if image have not been used yet in any deployment.
-func (i *ImagesModel) EditImage(ctx context.Context, imageID string,
-	constructor *images.SoftwareImageMetaConstructor) (bool, error) {
+	objectKey := images.ObjectKey(tenantFromContext(ctx), session.Id)
 
-	if err := constructor.Validate(); err != nil {
-		return false, errors.Wrap(err, "Validating image metadata")
+	file, err := i.fileStorage.GetObject(ctx, objectKey)
+	if err != nil {
+		return "", errors.Wrap(err, "Opening artifact file")
 	}
+	defer file.Close()
 
-	found, err := i.deployments.ImageUsedInDeployment(ctx, imageID)
+	// read at most one byte more than declared to detect the size mismatch
+	counter := &countingReader{r: io.LimitReader(file, session.Size+1)}
+	checksums := images.NewChecksumsWriter()
+	var tee io.Reader = io.TeeReader(counter, checksums)
+
+	metaArtifactConstructor, err := getMetaFromArchive(&tee, i.trustedKeys)
 	if err != nil {
-		return false, errors.Wrap(err, "Searching for usage of the image among deployments")
+		span.SetError(err)
+		switch errors.Cause(err) {
+		case controller.ErrModelArtifactNotSigned, controller.ErrModelArtifactSignatureInvalid:
+			return "", err
+		}
+		return "", errors.Wrap(controller.ErrModelParsingArtifactFailed, err.Error())
 	}
 
-	if found {
-		return false, controller.ErrModelImageUsedInAnyDeployment
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+		return "", errors.Wrap(err, "Reading artifact file")
 	}
 
-	foundImage, err := i.imagesStorage.FindByID(ctx, imageID)
-	if err != nil {
-		return false, errors.Wrap(err, "Searching for image with specified ID")
+	if counter.n != session.Size {
+		return "", controller.ErrModelUploadSizeMismatch
 	}
 
-	if foundImage == nil {
-		return false, nil
+	computed := checksums.Checksums()
+	if !session.ExpectedChecksums().Matches(computed) {
+		return "", controller.ErrModelUploadChecksumMismatch
 	}
 
-	foundImage.SetModified(time.Now())
-	foundImage.SoftwareImageMetaConstructor = *constructor
+	image := images.NewSoftwareImage(session.Id,
+		&images.SoftwareImageMetaConstructor{Description: session.Description},
+		metaArtifactConstructor)
+	image.Size = session.Size
+	image.SetChecksums(computed)
 
-	_, err = i.imagesStorage.Update(ctx, foundImage)
+	key, err := i.storeImage(ctx, objectKey, image)
+	span.SetError(err)
+	stored = err == nil || errors.Cause(err) == controller.ErrModelImageQuarantined
 	if err != nil {
-		return false, errors.Wrap(err, "Updating image matadata")
+		// the file moved in place is out of reach of the upload session,
+		// the file of the quarantined image is kept for the inspection
+		if key != objectKey && errors.Cause(err) != controller.ErrModelImageQuarantined {
+			if cleanupErr := i.fileStorage.Delete(ctx, key); cleanupErr != nil {
+				return "", errors.Wrap(err, cleanupErr.Error())
+			}
+		}
+		return "", err
 	}
 
-	return true, nil
+	i.replicateImage(ctx, image.Id)
+	return image.Id, nil
 }
 
-// DownloadLink presigned GET link to download image file.
-// Returns error if image have not been uploaded.
-func (i *ImagesModel) DownloadLink(ctx context.Context, imageID string,
-	expire time.Duration) (*images.Link, error) {
-
-	found, err := i.imagesStorage.Exists(ctx, imageID)
-	if err != nil {
-		return nil, errors.Wrap(err, "Searching for image with specified ID")
+// fileStorageError translates the file storage failures the clients
+// or the operators can react to, keeping the details; other errors are
+// returned as they are.
+func fileStorageError(err error) error {
+	var modelErr error
+	switch errors.Cause(err) {
+	case ErrFileStorageAccessDenied:
+		modelErr = controller.ErrModelStorageAccessDenied
+	case ErrFileStorageMisconfigured:
+		modelErr = controller.ErrModelStorageMisconfigured
+	case ErrFileStorageThrottled:
+		modelErr = controller.ErrModelStorageThrottled
+	default:
+		return err
 	}
+	return errors.Wrap(modelErr, err.Error())
+}
 
-	if !found {
-		return nil, nil
+// countingReader counts the bytes read
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// isExhausted tells if there's no more data to read from the reader.
+func isExhausted(r io.Reader) bool {
+	n, err := r.Read(make([]byte, 1))
+	return n == 0 && err == io.EOF
+}
+
+// isArtifactUnique checks the uniqueness of a full artifact, or of a delta
+// among the deltas applied to the same artifact.
+func (i *ImagesModel) isArtifactUnique(ctx context.Context, name string,
+	deviceTypes []string, delta *images.DeltaUpdate) (bool, error) {
+
+	if delta != nil {
+		return i.imagesStorage.IsDeltaUnique(ctx, delta.From, name, deviceTypes)
 	}
+	return i.imagesStorage.IsArtifactUnique(ctx, name, deviceTypes)
+}
 
-	found, err = i.fileStorage.Exists(ctx, imageID)
+// GetImage allows to fetch image obeject with specified id
+// Nil if not found or expired
+func (i *ImagesModel) GetImage(ctx context.Context, id string) (*images.SoftwareImage, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.GetImage")
+	defer span.End()
+	span.SetAttribute("image_id", id)
+
+	image, err := i.findImage(ctx, id)
 	if err != nil {
-		return nil, errors.Wrap(err, "Searching for image file")
+		return nil, err
 	}
 
-	if !found {
+	if image == nil || image.IsExpired(time.Now()) {
 		return nil, nil
 	}
 
-	link, err := i.fileStorage.GetRequest(ctx, imageID,
-		expire, ArtifactContentType)
+	return image, nil
+}
+
+// GetImages fetches the images with the given IDs at once, including
+// the ones shared with the tenant.
+// IDs which are not found or expired are listed as missing.
+func (i *ImagesModel) GetImages(ctx context.Context, ids []string) (*images.ImagesLookup, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.GetImages")
+	defer span.End()
+	span.SetAttribute("ids_count", len(ids))
+
+	found, err := i.imagesStorage.FindByIDs(ctx, ids)
 	if err != nil {
-		return nil, errors.Wrap(err, "Generating download link")
+		return nil, errors.Wrap(err, "Searching for images with specified IDs")
 	}
 
-	return link, nil
-}
+	now := time.Now()
+	byID := make(map[string]*images.SoftwareImage, len(found))
+	for _, image := range found {
+		if !image.IsExpired(now) {
+			byID[image.Id] = image
+		}
+	}
 
-func getArtifactInfo(info artifact.Info) *images.ArtifactInfo {
-	return &images.ArtifactInfo{
-		Format:  info.Format,
-		Version: uint(info.Version),
+	// the IDs not found among the images of the tenant may be shared with it
+	var notFound []string
+	for _, id := range ids {
+		if _, ok := byID[id]; !ok {
+			notFound = append(notFound, id)
+		}
+	}
+	if len(notFound) > 0 {
+		shared, err := i.imagesStorage.FindShared(ctx,
+			&images.ImagesFilter{IDs: notFound})
+		if err != nil {
+			return nil, errors.Wrap(err, "Searching for shared images with specified IDs")
+		}
+		for _, image := range shared {
+			if !image.IsExpired(now) {
+				byID[image.Id] = image
+			}
+		}
 	}
-}
 
-func getUpdateFiles(uFiles []*handlers.DataFile) ([]images.UpdateFile, error) {
-	var files []images.UpdateFile
-	for _, u := range uFiles {
-		files = append(files, images.UpdateFile{
-			Name:     u.Name,
-			Size:     u.Size,
-			Date:     &u.Date,
-			Checksum: string(u.Checksum),
-		})
+	lookup := &images.ImagesLookup{
+		Artifacts: make([]*images.SoftwareImage, 0, len(found)),
+		Missing:   make([]string, 0),
 	}
-	return files, nil
+	for _, id := range ids {
+		if image, ok := byID[id]; ok {
+			lookup.Artifacts = append(lookup.Artifacts, image)
+		} else {
+			lookup.Missing = append(lookup.Missing, id)
+		}
+	}
+
+	return lookup, nil
 }
 
-func getMetaFromArchive(r *io.Reader) (*images.SoftwareImageMetaArtifactConstructor, error) {
-	metaArtifact := images.NewSoftwareImageMetaArtifactConstructor()
+// DeleteImage removes metadata and image file
+// Noop for not exisitng images
+// Allowed to remove image only if image is not scheduled or in progress for an updates - then image file is needed
+// In case of already finished updates only image file is not needed, metadata is attached directly to device deployment
+// therefore we still have some information about image that have been used (but not the file)
+func (i *ImagesModel) DeleteImage(ctx context.Context, imageID string) error {
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.DeleteImage")
+	defer span.End()
+	span.SetAttribute("image_id", imageID)
 
-	aReader := areader.NewReader(*r)
+	// expired images are removed this way too
+	found, err := i.imagesStorage.FindByID(ctx, imageID)
 
-	// There is no signature verification here.
-	// It is just simple check if artifact is signed or not.
-	aReader.VerifySignatureCallback = func(message, sig []byte) error {
-		metaArtifact.Signed = true
-		return nil
+	if err != nil {
+		return errors.Wrap(err, "Getting image metadata")
 	}
 
-	err := aReader.ReadArtifact()
+	if found == nil {
+		return controller.ErrImageMetaNotFound
+	}
+
+	inUse, err := i.deployments.ImageUsedInActiveDeployment(ctx, imageID)
+	if err != nil {
+		return errors.Wrap(err, "Checking if image is used in active deployment")
+	}
+
+	// Image is in use, not allowed to delete
+	if inUse {
+		return controller.ErrModelImageInActiveDeployment
+	}
+
+	// Delete metadata first, failed removal of the file is retried later
+	deleted, err := i.imagesStorage.Delete(ctx, imageID)
+	// removed concurrently, counted by the other removal
+	if deleted {
+		i.countUsage(ctx, -1, -found.Size)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Deleting image metadata")
+	}
+
+	for _, tenant := range found.SharedWith {
+		i.unshareImage(ctx, tenant, imageID)
+	}
+
+	// Delete image file (call to external service)
+	// Noop for not existing file
+	objectKey := found.FileObjectKey(tenantFromContext(ctx))
+	i.deleteFile(ctx, i.fileStorage, "", objectKey)
+	for region, replica := range i.imageReplicas(found) {
+		i.deleteFile(ctx, replica, region, objectKey)
+	}
+
+	return nil
+}
+
+// SetUploadTimeout bounds storing the artifact files in the file storage
+// during the uploads, independently of the deadline of the upload itself;
+// the partially stored file is removed on timeout. No timeout by default.
+func (i *ImagesModel) SetUploadTimeout(timeout time.Duration) {
+	i.uploadTimeout = timeout
+}
+
+// SetDeploymentsAborter sets the aborter of the deployments using the images
+// removed with DeleteDeviceTypeImages in force mode; without it the images
+// used in active deployments are always skipped.
+func (i *ImagesModel) SetDeploymentsAborter(aborter DeploymentsAborter) {
+	i.aborter = aborter
+}
+
+// RestrictDeviceDownloads lets the devices download only the images assigned
+// to them by an active deployment, checked with the checker; the other
+// callers, e.g. the users, are not restricted. Unrestricted by default.
+func (i *ImagesModel) RestrictDeviceDownloads(checker DeviceAssignmentChecker) {
+	i.assignments = checker
+}
+
+// checkDeviceDownload fails with controller.ErrModelImageNotAssigned if the
+// downloads are restricted and the image is not assigned to the device
+// from the context.
+func (i *ImagesModel) checkDeviceDownload(ctx context.Context, imageID string) error {
+	if i.assignments == nil {
+		return nil
+	}
+
+	id := identity.FromContext(ctx)
+	if id == nil || !id.IsDevice {
+		return nil
+	}
+
+	assigned, err := i.assignments.ImageAssignedToDevice(ctx, id.Subject, imageID)
+	if err != nil {
+		return errors.Wrap(err, "Checking if image is assigned to the device")
+	}
+	if !assigned {
+		return controller.ErrModelImageNotAssigned
+	}
+	return nil
+}
+
+// DeleteDeviceTypeImages removes all the images compatible with the device
+// type, e.g. when the hardware is retired. Images used in active deployments
+// are skipped, unless force is set: then the deployments are aborted first.
+func (i *ImagesModel) DeleteDeviceTypeImages(ctx context.Context,
+	deviceType string, force bool) (*images.DeleteReport, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.DeleteDeviceTypeImages")
+	defer span.End()
+	span.SetAttribute("device_type", deviceType)
+
+	found, err := i.imagesStorage.Find(ctx, &images.ImagesFilter{
+		DeviceType: images.NormalizeDeviceType(deviceType),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Searching for image metadata")
+	}
+
+	report := &images.DeleteReport{InUse: []string{}}
+	for _, image := range found {
+		if force && i.aborter != nil {
+			aborted, err := i.aborter.AbortImageDeployments(ctx, image.Id)
+			report.AbortedDeployments += aborted
+			if err != nil {
+				return nil, errors.Wrapf(err, "Aborting deployments of image %s", image.Id)
+			}
+		}
+
+		err := i.DeleteImage(ctx, image.Id)
+		switch errors.Cause(err) {
+		case nil:
+			report.Deleted++
+		case controller.ErrImageMetaNotFound:
+			// removed in the meantime
+		case controller.ErrModelImageInActiveDeployment:
+			report.Skipped++
+			report.InUse = append(report.InUse, image.Id)
+		default:
+			return nil, errors.Wrapf(err, "Deleting image %s", image.Id)
+		}
+	}
+
+	log.FromContext(ctx).F(log.Ctx{
+		"device_type":         deviceType,
+		"deleted":             report.Deleted,
+		"skipped":             report.Skipped,
+		"aborted_deployments": report.AbortedDeployments,
+	}).Info("images of device type deleted")
+
+	return report, nil
+}
+
+// ListImages according to specified filter, nil filter lists all the images.
+// Expired images are not listed.
+func (i *ImagesModel) ListImages(ctx context.Context,
+	filter *images.ImagesFilter) ([]*images.SoftwareImage, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.ListImages")
+	defer span.End()
+
+	var imageList []*images.SoftwareImage
+	var err error
+	if filter != nil {
+		imageList, err = i.imagesStorage.Find(ctx, filter)
+	} else {
+		imageList, err = i.imagesStorage.FindAll(ctx)
+		filter = &images.ImagesFilter{}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Searching for image metadata")
+	}
+
+	// images shared with the tenant are listed after its own ones
+	shared, err := i.imagesStorage.FindShared(ctx, filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "Searching for shared image metadata")
+	}
+	if filter.Limit > 0 {
+		imageList = mergePage(imageList, shared, filter)
+	} else {
+		imageList = append(imageList, shared...)
+	}
+	// the latest images of the tenant may be older than the shared ones
+	if filter.LatestPerDeviceType && len(shared) > 0 {
+		imageList = latestPerDeviceType(imageList, filter)
+	}
+
+	if imageList == nil {
+		return make([]*images.SoftwareImage, 0), nil
+	}
+
+	return imageList, nil
+}
+
+// mergePage merges the page of the images of the tenant, ordered by ID,
+// with the images shared with it, into the page of both.
+func mergePage(page, shared []*images.SoftwareImage,
+	filter *images.ImagesFilter) []*images.SoftwareImage {
+
+	for _, image := range shared {
+		if image.Id > filter.After {
+			page = append(page, image)
+		}
+	}
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].Id < page[j].Id
+	})
+	if len(page) > filter.Limit {
+		page = page[:filter.Limit]
+	}
+	return page
+}
+
+// latestPerDeviceType selects the most recently modified of the images for
+// each compatible device type, ordered by device type unless sorted,
+// the same way the images storage does.
+func latestPerDeviceType(list []*images.SoftwareImage,
+	filter *images.ImagesFilter) []*images.SoftwareImage {
+
+	latest := make(map[string]*images.SoftwareImage)
+	for _, image := range list {
+		for _, deviceType := range image.DeviceTypesCompatible {
+			if filter.DeviceType != "" && deviceType != filter.DeviceType {
+				continue
+			}
+			current, ok := latest[deviceType]
+			if !ok || (image.Modified != nil &&
+				(current.Modified == nil || image.Modified.After(*current.Modified))) {
+				latest[deviceType] = image
+			}
+		}
+	}
+
+	selected := make([]*images.SoftwareImage, 0, len(latest))
+	seen := make(map[string]bool, len(latest))
+	if filter.Sort != "" {
+		// keep the sort order
+		for _, image := range latest {
+			seen[image.Id] = true
+		}
+		for _, image := range list {
+			if seen[image.Id] {
+				selected = append(selected, image)
+			}
+		}
+		return selected
+	}
+
+	deviceTypes := make([]string, 0, len(latest))
+	for deviceType := range latest {
+		deviceTypes = append(deviceTypes, deviceType)
+	}
+	sort.Strings(deviceTypes)
+	for _, deviceType := range deviceTypes {
+		if image := latest[deviceType]; !seen[image.Id] {
+			seen[image.Id] = true
+			selected = append(selected, image)
+		}
+	}
+	return selected
+}
+
+// ListInstallableImages lists the images which can be installed on the device
+// with the provides, the device type is required. Following the delta
+// updates, the images installable only after the others are listed too,
+// along with the shortest path of the images to install first. Images not
+// available, e.g. pending, and the ones providing the artifact already
+// installed are not listed.
+func (i *ImagesModel) ListInstallableImages(ctx context.Context,
+	provides images.Provides) ([]*images.UpdateStep, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.ListInstallableImages")
+	defer span.End()
+
+	candidates, err := i.imagesStorage.Find(ctx, &images.ImagesFilter{
+		DeviceType: images.NormalizeDeviceType(provides[images.ProvidesDeviceType]),
+	})
+	if err != nil {
+		span.SetError(err)
+		return nil, errors.Wrap(err, "Searching for image metadata")
+	}
+
+	type hop struct {
+		provides images.Provides
+		path     []string
+	}
+
+	// breadth first, so that the paths are the shortest
+	installed := provides[images.ProvidesArtifactName]
+	steps := []*images.UpdateStep{}
+	listed := make(map[string]bool)
+	visited := map[string]bool{installed: true}
+	queue := []hop{{provides: provides, path: []string{}}}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+
+		for _, image := range candidates {
+			if listed[image.Id] || !image.IsAvailable() || image.Name == installed ||
+				!image.DependsSatisfied(h.provides) {
+				continue
+			}
+
+			listed[image.Id] = true
+			steps = append(steps, &images.UpdateStep{Artifact: image, Path: h.path})
+
+			if !visited[image.Name] {
+				visited[image.Name] = true
+				path := append(append([]string{}, h.path...), image.Id)
+				queue = append(queue, hop{
+					provides: image.InstalledProvides(h.provides),
+					path:     path,
+				})
+			}
+		}
+	}
+
+	span.SetAttribute("installable", len(steps))
+
+	return steps, nil
+}
+
+// ListDeviceTypes lists the device types of the images with the number
+// of images compatible with each type.
+func (i *ImagesModel) ListDeviceTypes(ctx context.Context) ([]*images.DeviceTypeCount, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.ListDeviceTypes")
+	defer span.End()
+
+	deviceTypes, err := i.imagesStorage.CountDeviceTypes(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Counting image device types")
+	}
+
+	if deviceTypes == nil {
+		return make([]*images.DeviceTypeCount, 0), nil
+	}
+
+	return deviceTypes, nil
+}
+
+// ListImageChanges lists the recently created, modified and deleted images.
+func (i *ImagesModel) ListImageChanges(ctx context.Context,
+	query *images.ImageChangesQuery) ([]*images.ImageChange, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.ListImageChanges")
+	defer span.End()
+
+	changes, err := i.imagesStorage.FindChanges(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "Searching for image changes")
+	}
+
+	if changes == nil {
+		return make([]*images.ImageChange, 0), nil
+	}
+
+	return changes, nil
+}
+
+// EditObject allows editing only if image have not been used yet in any deployment.
+func (i *ImagesModel) EditImage(ctx context.Context, imageID string,
+	constructor *images.SoftwareImageMetaConstructor) (bool, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.EditImage")
+	defer span.End()
+	span.SetAttribute("image_id", imageID)
+
+	if err := constructor.Validate(); err != nil {
+		return false, errors.Wrap(err, "Validating image metadata")
+	}
+
+	found, err := i.deployments.ImageUsedInDeployment(ctx, imageID)
+	if err != nil {
+		return false, errors.Wrap(err, "Searching for usage of the image among deployments")
+	}
+
+	if found {
+		return false, controller.ErrModelImageUsedInAnyDeployment
+	}
+
+	foundImage, err := i.imagesStorage.FindByID(ctx, imageID)
+	if err != nil {
+		return false, errors.Wrap(err, "Searching for image with specified ID")
+	}
+
+	if foundImage == nil {
+		return false, nil
+	}
+
+	foundImage.SetModified(time.Now())
+	foundImage.SoftwareImageMetaConstructor = *constructor
+
+	_, err = i.imagesStorage.Update(ctx, foundImage)
+	if err != nil {
+		return false, errors.Wrap(err, "Updating image matadata")
+	}
+
+	return true, nil
+}
+
+// PatchImage updates the user provided metadata fields set in the patch.
+// Unlike EditImage it is allowed for images used in deployments,
+// as the metadata does not affect them.
+func (i *ImagesModel) PatchImage(ctx context.Context, imageID string,
+	patch *images.SoftwareImageMetaPatch) (bool, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.PatchImage")
+	defer span.End()
+	span.SetAttribute("image_id", imageID)
+
+	if err := patch.Validate(); err != nil {
+		return false, errors.Wrap(err, "Validating image metadata")
+	}
+
+	foundImage, err := i.imagesStorage.FindByID(ctx, imageID)
+	if err != nil {
+		return false, errors.Wrap(err, "Searching for image with specified ID")
+	}
+
+	if foundImage == nil {
+		return false, nil
+	}
+
+	// the patched metadata has to be valid as a whole,
+	// e.g. the required fields cannot be cleared
+	meta := foundImage.SoftwareImageMetaConstructor
+	patch.Apply(&meta)
+	if err := meta.Validate(); err != nil {
+		return false, errors.Wrap(controller.ErrModelInvalidMetadata, err.Error())
+	}
+
+	foundImage.SetModified(time.Now())
+	foundImage.SoftwareImageMetaConstructor = meta
+
+	_, err = i.imagesStorage.Update(ctx, foundImage)
+	if err != nil {
+		return false, errors.Wrap(err, "Updating image matadata")
+	}
+
+	// deprecation is the lifecycle state of the image
+	if patch.Deprecated != nil && *patch.Deprecated != foundImage.IsDeprecated() {
+		state := images.ImageStateDeprecated
+		if !*patch.Deprecated {
+			state = images.ImageStateUploaded
+		}
+		if err := i.SetImageState(ctx, imageID, state); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// AddImagesTag adds the tag to all the images selected by the update at once.
+// Images already tagged, or having the maximum number of tags, are skipped.
+func (i *ImagesModel) AddImagesTag(ctx context.Context,
+	update *images.TagsUpdate) (*images.TagsUpdateReport, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.AddImagesTag")
+	defer span.End()
+	span.SetAttribute("tag", update.Tag)
+
+	return i.updateImagesTags(ctx, update, i.imagesStorage.AddTag)
+}
+
+// RemoveImagesTag removes the tag from all the images selected by the update
+// at once.
+func (i *ImagesModel) RemoveImagesTag(ctx context.Context,
+	update *images.TagsUpdate) (*images.TagsUpdateReport, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.RemoveImagesTag")
+	defer span.End()
+	span.SetAttribute("tag", update.Tag)
+
+	return i.updateImagesTags(ctx, update, i.imagesStorage.RemoveTag)
+}
+
+func (i *ImagesModel) updateImagesTags(ctx context.Context, update *images.TagsUpdate,
+	apply func(context.Context, *images.TagsUpdate, time.Time) (int, error)) (*images.TagsUpdateReport, error) {
+
+	if err := update.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Validating tags update")
+	}
+
+	normalized := *update
+	normalized.DeviceType = images.NormalizeDeviceType(update.DeviceType)
+
+	modified, err := apply(ctx, &normalized, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "Updating image tags")
+	}
+
+	return &images.TagsUpdateReport{Modified: modified}, nil
+}
+
+// CloneImage creates a copy of the image, optionally for another tenant.
+// The artifact file is copied within the file storage, so the checksums
+// and the size of the copy are the same as of the image.
+// The metadata is stored only when the file is copied successfully.
+// Returns ID of the copy.
+func (i *ImagesModel) CloneImage(ctx context.Context, imageID string,
+	clone *images.SoftwareImageClone) (string, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.CloneImage")
+	defer span.End()
+	span.SetAttribute("image_id", imageID)
+
+	if err := clone.Validate(); err != nil {
+		return "", errors.Wrap(err, "Validating image metadata")
+	}
+
+	image, err := i.findImage(ctx, imageID)
+	if err != nil {
+		return "", err
+	}
+
+	if image == nil {
+		return "", controller.ErrImageMetaNotFound
+	}
+
+	if err := unavailableError(image); err != nil {
+		return "", err
+	}
+
+	tenant := tenantFromContext(ctx)
+	sourceKey := image.FileObjectKey(tenant)
+
+	// the copy is stored in the database of the target tenant
+	targetCtx := ctx
+	if clone.TenantID != "" {
+		tenant = clone.TenantID
+		targetCtx = identity.WithContext(ctx, &identity.Identity{Tenant: tenant})
+	}
+
+	isArtifactUnique, err := i.isArtifactUnique(targetCtx,
+		image.Name, image.DeviceTypesCompatible, image.Delta)
+	if err != nil {
+		return "", errors.Wrap(err, "Fail to check if artifact is unique")
+	}
+	if !isArtifactUnique {
+		return "", controller.ErrModelArtifactNotUnique
+	}
+
+	copied := images.NewSoftwareImage(uuid.NewV4().String(),
+		&image.SoftwareImageMetaConstructor, &image.SoftwareImageMetaArtifactConstructor)
+	copied.Delta = image.Delta
+	copied.Size = image.Size
+	copied.Checksum = image.Checksum
+	copied.Checksums = image.Checksums
+	clone.Apply(&copied.SoftwareImageMetaConstructor)
+	copied.ObjectKey = i.keyTemplate.ObjectKey(tenant, copied)
+	span.SetAttribute("clone_id", copied.Id)
+
+	if err := i.fileStorage.CopyObject(ctx, sourceKey, copied.ObjectKey); err != nil {
+		return "", errors.Wrap(err, "Copying artifact file")
+	}
+
+	if err := i.imagesStorage.Insert(targetCtx, copied); err != nil {
+		err = errors.Wrap(err, "Fail to store the metadata")
+		// try to remove the copied file, it is not referenced by any image
+		if cleanupErr := i.fileStorage.Delete(ctx, copied.ObjectKey); cleanupErr != nil {
+			return "", errors.Wrap(err, cleanupErr.Error())
+		}
+		return "", err
+	}
+	i.countUsage(targetCtx, 1, copied.Size)
+
+	return copied.Id, nil
+}
+
+// OpenImage opens the image file for reading, so that it can be served
+// through the service instead of with the download link.
+// Nil if image not found.
+func (i *ImagesModel) OpenImage(ctx context.Context, imageID string) (*images.ImageFile, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.OpenImage")
+	defer span.End()
+	span.SetAttribute("image_id", imageID)
+
+	image, err := i.findImage(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if image == nil {
+		return nil, nil
+	}
+
+	if err := unavailableError(image); err != nil {
+		return nil, err
+	}
+
+	if err := i.checkDeviceDownload(ctx, imageID); err != nil {
+		return nil, err
+	}
+
+	file, err := i.openFile(ctx, image, image.FileObjectKey(tenantFromContext(ctx)))
+	if err != nil {
+		return nil, errors.Wrap(err, "Opening image file")
+	}
+
+	imageFile := &images.ImageFile{
+		FileReader: file,
+		Checksum:   image.Checksum,
+	}
+	if image.Modified != nil {
+		imageFile.Modified = *image.Modified
+	}
+	return imageFile, nil
+}
+
+// DownloadLink presigned GET link to download image file.
+// The link points to the replica of the region, if given and the image file
+// has been replicated there, otherwise to the primary file storage.
+// Returns error if image have not been uploaded.
+func (i *ImagesModel) DownloadLink(ctx context.Context, imageID string,
+	expire time.Duration, region string) (*images.Link, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.DownloadLink")
+	defer span.End()
+	span.SetAttribute("image_id", imageID)
+
+	image, err := i.findImage(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if image == nil {
+		return nil, nil
+	}
+
+	if err := unavailableError(image); err != nil {
+		return nil, err
+	}
+
+	if err := i.checkDeviceDownload(ctx, imageID); err != nil {
+		return nil, err
+	}
+
+	objectKey := image.FileObjectKey(tenantFromContext(ctx))
+
+	if replica, ok := i.imageReplicas(image)[region]; ok {
+		versionID, err := i.fileVersion(ctx, image, region, replica, objectKey)
+		var link *images.Link
+		if err == nil {
+			link, err = replica.GetRequest(ctx, objectKey, versionID,
+				expire, ArtifactContentType)
+		}
+		if err == nil {
+			i.countDownload(ownerContext(ctx, image), imageID)
+			return describeLink(link, image), nil
+		}
+		log.FromContext(ctx).F(log.Ctx{"region": region, "error": err.Error()}).
+			Warn("failed to generate download link, falling back to the primary")
+	}
+
+	found, err := i.fileExists(ctx, image, objectKey)
+	if err != nil {
+		return nil, errors.Wrap(fileStorageError(err), "Searching for image file")
+	}
+
+	if !found {
+		return nil, controller.ErrModelArtifactFileMissing
+	}
+
+	versionID, err := i.fileVersion(ctx, image, "", i.fileStorage, objectKey)
 	if err != nil {
+		return nil, errors.Wrap(fileStorageError(err), "Searching for image file version")
+	}
+
+	link, err := i.fileStorage.GetRequest(ctx, objectKey, versionID,
+		expire, ArtifactContentType)
+	if err != nil {
+		return nil, errors.Wrap(fileStorageError(err), "Generating download link")
+	}
+
+	i.countDownload(ownerContext(ctx, image), imageID)
+
+	return describeLink(link, image), nil
+}
+
+// FileVersion returns the version of the image file in the primary file
+// storage the download links are to be pinned to, empty if the image or
+// its file is not found.
+func (i *ImagesModel) FileVersion(ctx context.Context, imageID string) (string, error) {
+	image, err := i.findImage(ctx, imageID)
+	if err != nil || image == nil {
+		return "", err
+	}
+
+	versionID, err := i.fileVersion(ctx, image, "", i.fileStorage,
+		image.FileObjectKey(tenantFromContext(ctx)))
+	if err == ErrFileStorageFileNotFound {
+		return "", nil
+	}
+	return versionID, err
+}
+
+// fileVersion returns the version of the image file in the region, empty
+// for the primary file storage, the download links are pinned to. The file
+// storage is asked for the first link only, then the version is recorded
+// with the image, until the links are rotated.
+func (i *ImagesModel) fileVersion(ctx context.Context, image *images.SoftwareImage,
+	region string, storage FileStorage, objectKey string) (string, error) {
+
+	if region == "" && image.FileVersion != nil {
+		return *image.FileVersion, nil
+	}
+	if versionID, ok := image.ReplicaVersions[region]; ok && region != "" {
+		return versionID, nil
+	}
+
+	versionID, err := storage.ObjectVersion(ctx, objectKey)
+	if err != nil {
+		return "", err
+	}
+
+	// the link is pinned anyway, the version is looked up again next time
+	if err := i.imagesStorage.SetFileVersion(ownerContext(ctx, image), image.Id,
+		region, versionID, false); err != nil {
+		log.FromContext(ctx).F(log.Ctx{
+			"image_id": image.Id,
+			"region":   region,
+			"error":    err.Error(),
+		}).Warn("failed to record image file version")
+	}
+
+	return versionID, nil
+}
+
+// describeLink adds the size and checksum of the image file to the links
+// serving byte ranges, so that the clients can resume interrupted downloads
+// and tell if the file changed in between.
+func describeLink(link *images.Link, image *images.SoftwareImage) *images.Link {
+	if link != nil && link.AcceptRanges {
+		link.Size = image.Size
+		link.Checksum = image.Checksum
+	}
+	return link
+}
+
+// ImageLocation tells where the image file is kept in the file storage,
+// without checking if the file exists.
+func (i *ImagesModel) ImageLocation(ctx context.Context,
+	imageID string) (*images.StorageLocation, error) {
+
+	image, err := i.findImage(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if image == nil {
+		return nil, controller.ErrImageMetaNotFound
+	}
+
+	return i.fileStorage.Location(image.FileObjectKey(tenantFromContext(ctx))), nil
+}
+
+// countDownload counts the image download in the background, so that
+// the link generation is not delayed; failures are only logged.
+func (i *ImagesModel) countDownload(ctx context.Context, imageID string) {
+	l := log.FromContext(ctx).F(log.Ctx{"image_id": imageID})
+
+	// counting outlives the request, keep the tenant and the logger only
+	countCtx := log.WithContext(context.Background(), l)
+	if id := identity.FromContext(ctx); id != nil {
+		countCtx = identity.WithContext(countCtx, id)
+	}
+
+	downloaded := time.Now()
+	go func() {
+		if err := i.imagesStorage.IncDownloadCount(countCtx, imageID,
+			downloaded); err != nil {
+			l.F(log.Ctx{"error": err.Error()}).Error("failed to count image download")
+		}
+	}()
+}
+
+// RotateDownloadLinks invalidates all the download links issued for the image
+// so far. New links can be generated as usual.
+func (i *ImagesModel) RotateDownloadLinks(ctx context.Context, imageID string) error {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.RotateDownloadLinks")
+	defer span.End()
+	span.SetAttribute("image_id", imageID)
+
+	image, err := i.findImage(ctx, imageID)
+	if err != nil {
+		return err
+	}
+
+	if image == nil {
+		return controller.ErrImageMetaNotFound
+	}
+
+	// the versions are recorded with the image of the owner
+	ownerCtx := ownerContext(ctx, image)
+	objectKey := image.FileObjectKey(tenantFromContext(ctx))
+
+	versionID, err := i.fileStorage.RotateObject(ctx, objectKey)
+	switch err {
+	case nil:
+	case ErrFileStorageFileNotFound:
+		return controller.ErrImageMetaNotFound
+	case ErrFileStorageNotSupported:
+		return controller.ErrModelLinkRotationNotSupported
+	default:
+		return errors.Wrap(err, "Rotating image file")
+	}
+
+	// the new links are pinned to the copy, the old version is gone
+	if err := i.imagesStorage.SetFileVersion(ownerCtx, imageID, "", versionID,
+		true); err != nil {
+		return errors.Wrap(err, "Recording image file version")
+	}
+
+	// the links to the replicas have to be revoked too
+	for region, replica := range i.imageReplicas(image) {
+		versionID, err := replica.RotateObject(ctx, objectKey)
+		if err != nil {
+			return errors.Wrapf(err, "Rotating image file replica in %s", region)
+		}
+		if err := i.imagesStorage.SetFileVersion(ownerCtx, imageID, region,
+			versionID, true); err != nil {
+			return errors.Wrapf(err, "Recording image file replica version in %s", region)
+		}
+	}
+
+	// audit trail of the revocation
+	l := log.FromContext(ctx).F(log.Ctx{
+		"audit":    "download_links_rotated",
+		"image_id": imageID,
+	})
+	if id := identity.FromContext(ctx); id != nil {
+		l = l.F(log.Ctx{
+			"user_id":   id.Subject,
+			"tenant_id": id.Tenant,
+		})
+	}
+	l.Info("download links for the image revoked")
+
+	return nil
+}
+
+// SetImageState moves the image to the given lifecycle state, recording
+// the user and the time of the change. Pending images can't be reviewed.
+func (i *ImagesModel) SetImageState(ctx context.Context, imageID, state string) error {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.SetImageState")
+	defer span.End()
+	span.SetAttribute("image_id", imageID)
+	span.SetAttribute("state", state)
+
+	image, err := i.imagesStorage.FindByID(ctx, imageID)
+	if err != nil {
+		return errors.Wrap(err, "Searching for image with specified ID")
+	}
+
+	if image == nil {
+		return controller.ErrImageMetaNotFound
+	}
+
+	if err := unavailableError(image); err != nil {
+		return err
+	}
+
+	if !image.CanChangeState(state) {
+		return controller.ErrModelInvalidStateTransition
+	}
+
+	var user string
+	if id := identity.FromContext(ctx); id != nil {
+		user = id.Subject
+	}
+
+	from := image.LifecycleState()
+	modified := time.Now()
+	changed, err := i.imagesStorage.SetState(ctx, imageID, from, state, user, modified)
+	if err != nil {
+		return errors.Wrap(err, "Changing image state")
+	}
+
+	// changed or removed in the meantime
+	if !changed {
+		return controller.ErrModelInvalidStateTransition
+	}
+
+	// audit trail of the review
+	l := log.FromContext(ctx).F(log.Ctx{
+		"audit":      "artifact_state_changed",
+		"image_id":   imageID,
+		"from":       from,
+		"to":         state,
+		"changed_at": modified.UTC().Format(time.RFC3339),
+	})
+	if id := identity.FromContext(ctx); id != nil {
+		l = l.F(log.Ctx{
+			"user_id":   id.Subject,
+			"tenant_id": id.Tenant,
+		})
+	}
+	l.Info("artifact lifecycle state changed")
+
+	return nil
+}
+
+func getArtifactInfo(info artifact.Info) *images.ArtifactInfo {
+	return &images.ArtifactInfo{
+		Format:  info.Format,
+		Version: uint(info.Version),
+	}
+}
+
+func getUpdateFiles(uFiles []*handlers.DataFile) ([]images.UpdateFile, error) {
+	var files []images.UpdateFile
+	for _, u := range uFiles {
+		files = append(files, images.UpdateFile{
+			Name:     u.Name,
+			Size:     u.Size,
+			Date:     &u.Date,
+			Checksum: string(u.Checksum),
+		})
+	}
+	return files, nil
+}
+
+// getMetaFromArchive reads the artifact metadata. If trusted keys are given,
+// the artifact has to be signed with one of them, the signature is verified
+// with the header, before the payload is read.
+func getMetaFromArchive(r *io.Reader,
+	trustedKeys []*TrustedKey) (*images.SoftwareImageMetaArtifactConstructor, error) {
+	metaArtifact := images.NewSoftwareImageMetaArtifactConstructor()
+
+	var aReader *areader.Reader
+	var verifyErr error
+	if len(trustedKeys) > 0 {
+		aReader = areader.NewReaderSigned(*r)
+		aReader.VerifySignatureCallback = func(message, sig []byte) error {
+			metaArtifact.Signed = true
+			metaArtifact.VerifiedBy, verifyErr = verifySignature(trustedKeys, message, sig)
+			return verifyErr
+		}
+	} else {
+		aReader = areader.NewReader(*r)
+		// There is no signature verification here.
+		// It is just simple check if artifact is signed or not.
+		aReader.VerifySignatureCallback = func(message, sig []byte) error {
+			metaArtifact.Signed = true
+			return nil
+		}
+	}
+
+	err := aReader.ReadArtifact()
+	if verifyErr != nil {
+		return nil, errors.Wrap(controller.ErrModelArtifactSignatureInvalid, verifyErr.Error())
+	}
+	if err != nil {
+		if len(trustedKeys) > 0 && !metaArtifact.Signed {
+			return nil, errors.Wrap(controller.ErrModelArtifactNotSigned, err.Error())
+		}
 		return nil, errors.Wrap(err, "reading artifact error")
 	}
 
+	// version 1 artifacts can't be signed
+	if len(trustedKeys) > 0 && !metaArtifact.Signed {
+		return nil, controller.ErrModelArtifactNotSigned
+	}
+
 	metaArtifact.Info = getArtifactInfo(aReader.GetInfo())
 	metaArtifact.DeviceTypesCompatible = aReader.GetCompatibleDevices()
 	metaArtifact.Name = aReader.GetArtifactName()
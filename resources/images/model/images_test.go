@@ -17,17 +17,23 @@ package model
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/mendersoftware/go-lib-micro/identity"
 	"github.com/mendersoftware/mender-artifact/artifact"
 	"github.com/mendersoftware/mender-artifact/awriter"
 	"github.com/mendersoftware/mender-artifact/handlers"
+	pkgerrors "github.com/pkg/errors"
 
 	"github.com/stretchr/testify/assert"
 
@@ -38,14 +44,14 @@ import (
 const validUUIDv4 = "d50eda0d-2cea-4de1-8d42-9cd3e7e8670d"
 
 func TestCreateImageEmptyMessage(t *testing.T) {
-	iModel := NewImagesModel(nil, nil, nil)
+	iModel := NewImagesModel(nil, nil, nil, nil, nil)
 	if _, err := iModel.CreateImage(context.Background(),
 		nil); err != controller.ErrModelMultipartUploadMsgMalformed {
 		t.FailNow()
 	}
 }
 func TestCreateImageEmptyMetaConstructor(t *testing.T) {
-	iModel := NewImagesModel(nil, nil, nil)
+	iModel := NewImagesModel(nil, nil, nil, nil, nil)
 	multipartUploadMessage := &controller.MultipartUploadMsg{}
 	if _, err := iModel.CreateImage(context.Background(),
 		multipartUploadMessage); err != controller.ErrModelMissingInputMetadata {
@@ -53,8 +59,24 @@ func TestCreateImageEmptyMetaConstructor(t *testing.T) {
 	}
 }
 
+func TestCreateImageMissingRequiredMeta(t *testing.T) {
+	images.RequiredMetaFields = []string{"description"}
+	defer func() { images.RequiredMetaFields = nil }()
+
+	iModel := NewImagesModel(nil, nil, nil, nil, nil)
+	multipartUploadMessage := &controller.MultipartUploadMsg{
+		MetaConstructor: images.NewSoftwareImageMetaConstructor(),
+		ArtifactReader:  bytes.NewReader([]byte("artifact")),
+		ArtifactSize:    8,
+	}
+
+	_, err := iModel.CreateImage(context.Background(), multipartUploadMessage)
+	assert.Equal(t, controller.ErrModelInvalidMetadata, pkgerrors.Cause(err))
+	assert.Contains(t, err.Error(), "Missing required metadata fields: description")
+}
+
 func TestCreateImageMissingFields(t *testing.T) {
-	iModel := NewImagesModel(nil, nil, nil)
+	iModel := NewImagesModel(nil, nil, nil, nil, nil)
 	multipartUploadMessage := &controller.MultipartUploadMsg{
 		MetaConstructor: images.NewSoftwareImageMetaConstructor(),
 	}
@@ -78,7 +100,45 @@ type FakeImageStorage struct {
 	updateError           error
 	uploadArtifactError   error
 	isArtifactUnique      bool
+	isDeltaUnique         bool
 	isArtifactUniqueError error
+	integrity             map[string]*images.ArtifactIntegrity
+	setIntegrityError     error
+	scanned               chan *images.SoftwareImage
+	checksums             map[string]images.Checksums
+	setChecksumsError     error
+	filter                *images.ImagesFilter
+	deviceTypes           []*images.DeviceTypeCount
+	inserted              *images.SoftwareImage
+	updated               *images.SoftwareImage
+	findByIdsImages       []*images.SoftwareImage
+	findByIdsError        error
+	deviceTypesError      error
+	downloads             chan string
+	replicated            chan string
+	fileVersions          map[string]string
+	setFileVersionError   error
+	findByChecksumImages  map[string]*images.SoftwareImage
+	expiredImages         []*images.SoftwareImage
+	findExpiredError      error
+	pendingImages         []*images.SoftwareImage
+	findPendingError      error
+	setStateError         error
+	usage                 images.Usage
+	usageError            error
+	reconciledUsage       *images.Usage
+	changes               []*images.ImageChange
+	changesQuery          *images.ImageChangesQuery
+	changesError          error
+	failedDeletions       map[string]*images.FailedDeletion
+	failedDeletionsError  error
+	tagsUpdate            *images.TagsUpdate
+	tagsModified          int
+	tagsError             error
+	sharedRecords         map[string]map[string]string
+	sharedImages          []*images.SoftwareImage
+	sharedFilter          *images.ImagesFilter
+	sharingError          error
 }
 
 func (fis *FakeImageStorage) Exists(ctx context.Context, id string) (bool, error) {
@@ -87,11 +147,17 @@ func (fis *FakeImageStorage) Exists(ctx context.Context, id string) (bool, error
 
 func (fis *FakeImageStorage) Update(ctx context.Context,
 	image *images.SoftwareImage) (bool, error) {
+	if fis.updateError == nil {
+		fis.updated = image
+	}
 	return fis.update, fis.updateError
 }
 
 func (fis *FakeImageStorage) Insert(ctx context.Context,
 	image *images.SoftwareImage) error {
+	if fis.insertError == nil {
+		fis.inserted = image
+	}
 	return fis.insertError
 }
 
@@ -100,19 +166,265 @@ func (fis *FakeImageStorage) FindByID(ctx context.Context,
 	return fis.findByIdImage, fis.findByIdError
 }
 
-func (fis *FakeImageStorage) Delete(ctx context.Context, id string) error {
-	return fis.deleteError
+func (fis *FakeImageStorage) FindByIDs(ctx context.Context,
+	ids []string) ([]*images.SoftwareImage, error) {
+	return fis.findByIdsImages, fis.findByIdsError
+}
+
+func (fis *FakeImageStorage) FindByChecksum(ctx context.Context,
+	checksum string) (*images.SoftwareImage, error) {
+	return fis.findByChecksumImages[checksum], nil
+}
+
+func (fis *FakeImageStorage) Delete(ctx context.Context, id string) (bool, error) {
+	return fis.deleteError == nil, fis.deleteError
 }
 
 func (fis *FakeImageStorage) FindAll(ctx context.Context) ([]*images.SoftwareImage, error) {
 	return fis.findAllImages, fis.findAllError
 }
 
+func (fis *FakeImageStorage) FindExpired(ctx context.Context,
+	now time.Time) ([]*images.SoftwareImage, error) {
+	return fis.expiredImages, fis.findExpiredError
+}
+
+func (fis *FakeImageStorage) FindPending(ctx context.Context,
+	created time.Time) ([]*images.SoftwareImage, error) {
+	return fis.pendingImages, fis.findPendingError
+}
+
+func (fis *FakeImageStorage) Find(ctx context.Context,
+	filter *images.ImagesFilter) ([]*images.SoftwareImage, error) {
+	fis.filter = filter
+	return fis.findAllImages, fis.findAllError
+}
+
+func (fis *FakeImageStorage) CountDeviceTypes(
+	ctx context.Context) ([]*images.DeviceTypeCount, error) {
+	return fis.deviceTypes, fis.deviceTypesError
+}
+
+func (fis *FakeImageStorage) FindChanges(ctx context.Context,
+	query *images.ImageChangesQuery) ([]*images.ImageChange, error) {
+	fis.changesQuery = query
+	return fis.changes, fis.changesError
+}
+
 func (fis *FakeImageStorage) IsArtifactUnique(ctx context.Context,
 	artifactName string, deviceTypesCompatible []string) (bool, error) {
 	return fis.isArtifactUnique, fis.isArtifactUniqueError
 }
 
+func (fis *FakeImageStorage) IsDeltaUnique(ctx context.Context,
+	from, artifactName string, deviceTypesCompatible []string) (bool, error) {
+	return fis.isDeltaUnique, fis.isArtifactUniqueError
+}
+
+func (fis *FakeImageStorage) IncDownloadCount(ctx context.Context, id string,
+	downloaded time.Time) error {
+	if fis.downloads != nil {
+		fis.downloads <- id
+	}
+	return nil
+}
+
+func (fis *FakeImageStorage) AddReplica(ctx context.Context, id, region string) error {
+	if fis.replicated != nil {
+		fis.replicated <- region
+	}
+	return nil
+}
+
+func (fis *FakeImageStorage) SetFileVersion(ctx context.Context,
+	id, region, version string, replace bool) error {
+	if fis.setFileVersionError != nil {
+		return fis.setFileVersionError
+	}
+	if fis.fileVersions == nil {
+		fis.fileVersions = map[string]string{}
+	}
+	if _, ok := fis.fileVersions[region]; ok && !replace {
+		return nil
+	}
+	fis.fileVersions[region] = version
+	return nil
+}
+
+func (fis *FakeImageStorage) AddTag(ctx context.Context, update *images.TagsUpdate,
+	modified time.Time) (int, error) {
+	fis.tagsUpdate = update
+	return fis.tagsModified, fis.tagsError
+}
+
+func (fis *FakeImageStorage) RemoveTag(ctx context.Context, update *images.TagsUpdate,
+	modified time.Time) (int, error) {
+	fis.tagsUpdate = update
+	return fis.tagsModified, fis.tagsError
+}
+
+func (fis *FakeImageStorage) SaveFailedDeletion(ctx context.Context,
+	deletion *images.FailedDeletion) error {
+	if fis.failedDeletionsError != nil {
+		return fis.failedDeletionsError
+	}
+	if fis.failedDeletions == nil {
+		fis.failedDeletions = make(map[string]*images.FailedDeletion)
+	}
+	copied := *deletion
+	fis.failedDeletions[deletion.Id] = &copied
+	return nil
+}
+
+func (fis *FakeImageStorage) FindFailedDeletions(ctx context.Context,
+	retryAt time.Time) ([]*images.FailedDeletion, error) {
+	if fis.failedDeletionsError != nil {
+		return nil, fis.failedDeletionsError
+	}
+	due := []*images.FailedDeletion{}
+	for _, d := range fis.failedDeletions {
+		if !d.RetryAt.After(retryAt) {
+			copied := *d
+			due = append(due, &copied)
+		}
+	}
+	return due, nil
+}
+
+func (fis *FakeImageStorage) DeleteFailedDeletion(ctx context.Context, id string) error {
+	delete(fis.failedDeletions, id)
+	return fis.failedDeletionsError
+}
+
+func (fis *FakeImageStorage) CountFailedDeletions(ctx context.Context) (int, error) {
+	return len(fis.failedDeletions), fis.failedDeletionsError
+}
+
+func (fis *FakeImageStorage) SetIntegrity(ctx context.Context, id string,
+	integrity *images.ArtifactIntegrity) (bool, error) {
+	if fis.integrity != nil && fis.setIntegrityError == nil {
+		fis.integrity[id] = integrity
+	}
+	return fis.setIntegrityError == nil, fis.setIntegrityError
+}
+
+func (fis *FakeImageStorage) SetChecksums(ctx context.Context, id string,
+	checksums images.Checksums) (bool, error) {
+	if fis.checksums != nil && fis.setChecksumsError == nil {
+		fis.checksums[id] = checksums
+	}
+	return fis.setChecksumsError == nil, fis.setChecksumsError
+}
+
+func (fis *FakeImageStorage) SetScanResult(ctx context.Context, id, status string,
+	scan *images.ArtifactScan) (bool, error) {
+	if fis.scanned != nil {
+		fis.scanned <- &images.SoftwareImage{Id: id, Status: status, Scan: scan}
+	}
+	return true, nil
+}
+
+func (fis *FakeImageStorage) SetState(ctx context.Context, id, from, to, user string,
+	modified time.Time) (bool, error) {
+	if fis.setStateError != nil {
+		return false, fis.setStateError
+	}
+	image := fis.findByIdImage
+	if image == nil || image.LifecycleState() != from {
+		return false, nil
+	}
+	image.State = to
+	image.StateModified = &modified
+	image.StateModifiedBy = user
+	return true, nil
+}
+
+func (fis *FakeImageStorage) IncUsage(ctx context.Context, artifacts, size int64) error {
+	if fis.usageError == nil {
+		fis.usage.Artifacts += artifacts
+		fis.usage.Size += size
+	}
+	return fis.usageError
+}
+
+func (fis *FakeImageStorage) ReserveArtifact(ctx context.Context, limit uint64) (bool, error) {
+	if fis.usageError != nil {
+		return false, fis.usageError
+	}
+	if fis.usage.Artifacts >= 0 && uint64(fis.usage.Artifacts) >= limit {
+		return false, nil
+	}
+	fis.usage.Artifacts++
+	return true, nil
+}
+
+func (fis *FakeImageStorage) GetUsage(ctx context.Context) (*images.Usage, error) {
+	if fis.usageError != nil {
+		return nil, fis.usageError
+	}
+	usage := fis.usage
+	return &usage, nil
+}
+
+func (fis *FakeImageStorage) ReconcileUsage(ctx context.Context) (*images.Usage, error) {
+	if fis.usageError != nil {
+		return nil, fis.usageError
+	}
+	fis.usage = *fis.reconciledUsage
+	usage := fis.usage
+	return &usage, nil
+}
+
+func (fis *FakeImageStorage) SetSharedWith(ctx context.Context, id string,
+	tenants []string) (bool, error) {
+	if fis.sharingError != nil || fis.findByIdImage == nil {
+		return false, fis.sharingError
+	}
+	fis.findByIdImage.SharedWith = tenants
+	return true, nil
+}
+
+// sharedRecords are kept by the tenant, then the image ID, pointing to the owner
+func (fis *FakeImageStorage) SaveSharedImage(ctx context.Context,
+	shared *images.SharedImage) error {
+	if fis.sharingError != nil {
+		return fis.sharingError
+	}
+	if fis.sharedRecords == nil {
+		fis.sharedRecords = make(map[string]map[string]string)
+	}
+	tenant := tenantFromContext(ctx)
+	if fis.sharedRecords[tenant] == nil {
+		fis.sharedRecords[tenant] = make(map[string]string)
+	}
+	fis.sharedRecords[tenant][shared.Id] = shared.Owner
+	return nil
+}
+
+func (fis *FakeImageStorage) DeleteSharedImage(ctx context.Context, id string) error {
+	delete(fis.sharedRecords[tenantFromContext(ctx)], id)
+	return nil
+}
+
+func (fis *FakeImageStorage) FindShared(ctx context.Context,
+	filter *images.ImagesFilter) ([]*images.SoftwareImage, error) {
+	fis.sharedFilter = filter
+	if fis.sharingError != nil {
+		return nil, fis.sharingError
+	}
+	list := []*images.SoftwareImage{}
+	for _, image := range fis.sharedImages {
+		listed := len(filter.IDs) == 0
+		for _, id := range filter.IDs {
+			listed = listed || id == image.Id
+		}
+		if listed {
+			list = append(list, image)
+		}
+	}
+	return list, nil
+}
+
 func createValidImageMeta() *images.SoftwareImageMetaConstructor {
 	return images.NewSoftwareImageMetaConstructor()
 }
@@ -134,7 +446,7 @@ func TestCreateImageInsertError(t *testing.T) {
 	fakeIS := new(FakeImageStorage)
 	fakeIS.insertError = errors.New("insert error")
 
-	iModel := NewImagesModel(nil, nil, fakeIS)
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
 	multipartUploadMessage := &controller.MultipartUploadMsg{
 		MetaConstructor: createValidImageMeta(),
 	}
@@ -152,7 +464,7 @@ func TestCreateImageArtifactUploadError(t *testing.T) {
 	fakeFS := new(FakeFileStorage)
 	fakeFS.uploadArtifactError = errors.New("Cannot upload artifact")
 
-	iModel := NewImagesModel(fakeFS, nil, fakeIS)
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
 
 	td, _ := ioutil.TempDir("", "mender-install-update-")
 	defer os.RemoveAll(td)
@@ -170,22 +482,152 @@ func TestCreateImageArtifactUploadError(t *testing.T) {
 	}
 }
 
+// abortedReader returns the data and fails then, like request body
+// of the client which disconnected in the middle of the upload
+type abortedReader struct {
+	r   io.Reader
+	err error
+}
+
+func (a *abortedReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if err == io.EOF {
+		return n, a.err
+	}
+	return n, err
+}
+
+func TestCreateImageStorageFailure(t *testing.T) {
+	fakeIS := new(FakeImageStorage)
+	fakeIS.isArtifactUnique = true
+	fakeFS := new(FakeFileStorage)
+	fakeFS.uploadArtifactError = pkgerrors.Wrap(ErrFileStorageAccessDenied,
+		"S3 request failed with code AccessDenied")
+
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+
+	upd, err := MakeRootfsImageArtifact(1, false)
+	assert.NoError(t, err)
+
+	_, err = iModel.CreateImage(context.Background(), &controller.MultipartUploadMsg{
+		MetaConstructor: createValidImageMeta(),
+		ArtifactSize:    int64(upd.Len()),
+		ArtifactReader:  upd,
+	})
+	assert.Equal(t, controller.ErrModelStorageAccessDenied, pkgerrors.Cause(err))
+	assert.Contains(t, err.Error(), "AccessDenied")
+}
+
+func TestCreateImageStorageUploadTimeout(t *testing.T) {
+	fakeIS := new(FakeImageStorage)
+	fakeIS.isArtifactUnique = true
+	fakeFS := &FakeFileStorage{objects: map[string][]byte{}, uploadStalled: true}
+
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+	iModel.SetUploadTimeout(10 * time.Millisecond)
+
+	upd, err := MakeRootfsImageArtifact(1, false)
+	assert.NoError(t, err)
+
+	_, err = iModel.CreateImage(context.Background(), &controller.MultipartUploadMsg{
+		MetaConstructor: createValidImageMeta(),
+		ArtifactSize:    int64(upd.Len()),
+		ArtifactReader:  upd,
+	})
+	assert.Equal(t, controller.ErrModelStorageUploadTimeout, pkgerrors.Cause(err))
+	// partial artifact file is removed
+	assert.Empty(t, fakeFS.objects)
+	assert.Nil(t, fakeIS.inserted)
+}
+
+func TestCreateImageReaderAborted(t *testing.T) {
+	fakeIS := new(FakeImageStorage)
+	fakeIS.isArtifactUnique = true
+	fakeFS := &FakeFileStorage{objects: map[string][]byte{}}
+
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+
+	upd, err := MakeRootfsImageArtifact(1, false)
+	assert.NoError(t, err)
+	size := upd.Len()
+
+	multipartUploadMessage := &controller.MultipartUploadMsg{
+		MetaConstructor: createValidImageMeta(),
+		ArtifactSize:    int64(size),
+		ArtifactReader: &abortedReader{
+			r:   io.LimitReader(upd, int64(size/2)),
+			err: context.Canceled,
+		},
+	}
+
+	_, err = iModel.CreateImage(context.Background(), multipartUploadMessage)
+	assert.Error(t, err)
+	// partial artifact file is not stored
+	assert.Empty(t, fakeFS.objects)
+}
+
+func TestCreateImageSizeMismatch(t *testing.T) {
+	testCases := map[string]struct {
+		// bytes of the artifact sent, negative count truncates it
+		extra int
+		// bytes declared above the size of the artifact
+		declaredExtra int
+	}{
+		"truncated": {
+			extra: -100,
+		},
+		"declared too large": {
+			declaredExtra: 100,
+		},
+		"trailing data": {
+			extra: 100,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fakeIS := new(FakeImageStorage)
+			fakeIS.isArtifactUnique = true
+			fakeFS := &FakeFileStorage{objects: map[string][]byte{}}
+
+			iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+
+			upd, err := MakeRootfsImageArtifact(1, false)
+			assert.NoError(t, err)
+			size := upd.Len()
+
+			data := append(upd.Bytes(), make([]byte, 100)...)[:size+tc.extra]
+			multipartUploadMessage := &controller.MultipartUploadMsg{
+				MetaConstructor: createValidImageMeta(),
+				ArtifactSize:    int64(size + tc.declaredExtra),
+				ArtifactReader:  bytes.NewReader(data),
+			}
+
+			_, err = iModel.CreateImage(context.Background(), multipartUploadMessage)
+			assert.Equal(t, controller.ErrModelUploadSizeMismatch, err)
+			assert.Nil(t, fakeIS.inserted)
+			assert.Empty(t, fakeFS.objects)
+		})
+	}
+}
+
 func TestCreateImageCreateOK(t *testing.T) {
 	fakeIS := new(FakeImageStorage)
 	fakeIS.insertError = nil
 	fakeIS.isArtifactUnique = true
 	fakeFS := new(FakeFileStorage)
 
-	iModel := NewImagesModel(fakeFS, nil, fakeIS)
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
 
 	td, _ := ioutil.TempDir("", "mender-install-update-")
 	defer os.RemoveAll(td)
 	upd, err := MakeRootfsImageArtifact(1, false)
 	assert.NoError(t, err)
 
+	size := int64(upd.Len())
 	multipartUploadMessage := &controller.MultipartUploadMsg{
 		MetaConstructor: createValidImageMeta(),
-		ArtifactSize:    int64(upd.Len()),
+		ArtifactSize:    size,
 		ArtifactReader:  upd,
 	}
 
@@ -194,6 +636,337 @@ func TestCreateImageCreateOK(t *testing.T) {
 
 		t.FailNow()
 	}
+	assert.Equal(t, size, fakeIS.inserted.Size)
+	assert.Len(t, fakeIS.inserted.Checksum, 64)
+	assert.Len(t, fakeIS.inserted.Checksums[images.ChecksumMD5], 32)
+	assert.Equal(t, fakeIS.inserted.Checksum,
+		fakeIS.inserted.Checksums[images.ChecksumSHA256])
+}
+
+func TestCreateImageDelta(t *testing.T) {
+	testCases := map[string]struct {
+		delta         *images.DeltaUpdate
+		isDeltaUnique bool
+
+		err error
+	}{
+		"ok": {
+			delta:         &images.DeltaUpdate{From: "mender-1.0", To: "mender-1.1"},
+			isDeltaUnique: true,
+		},
+		"name mismatch": {
+			delta:         &images.DeltaUpdate{From: "mender-1.0", To: "mender-1.2"},
+			isDeltaUnique: true,
+			err:           controller.ErrModelDeltaNameMismatch,
+		},
+		"not unique": {
+			delta: &images.DeltaUpdate{From: "mender-1.0", To: "mender-1.1"},
+			err:   controller.ErrModelArtifactNotUnique,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fakeIS := new(FakeImageStorage)
+			// full artifact of the same name exists already
+			fakeIS.isArtifactUnique = false
+			fakeIS.isDeltaUnique = tc.isDeltaUnique
+			fakeFS := new(FakeFileStorage)
+
+			iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+
+			upd, err := MakeRootfsImageArtifact(1, false)
+			assert.NoError(t, err)
+
+			_, err = iModel.CreateImage(context.Background(),
+				&controller.MultipartUploadMsg{
+					MetaConstructor: createValidImageMeta(),
+					ArtifactSize:    int64(upd.Len()),
+					ArtifactReader:  upd,
+					Delta:           tc.delta,
+				})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCreateImageObjectKeyTemplate(t *testing.T) {
+	fakeIS := new(FakeImageStorage)
+	fakeIS.isArtifactUnique = true
+	fakeFS := &FakeFileStorage{objects: map[string][]byte{}}
+
+	template, err := images.NewObjectKeyTemplate("{tenant}/{device_type}/{id}")
+	assert.NoError(t, err)
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, template, nil)
+
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "tenant"})
+
+	upd, err := MakeRootfsImageArtifact(1, false)
+	assert.NoError(t, err)
+
+	id, err := iModel.CreateImage(ctx, &controller.MultipartUploadMsg{
+		MetaConstructor: createValidImageMeta(),
+		ArtifactSize:    int64(upd.Len()),
+		ArtifactReader:  upd,
+	})
+	assert.NoError(t, err)
+
+	key := "tenant/" + fakeIS.inserted.DeviceTypesCompatible[0] + "/" + id
+	assert.Equal(t, key, fakeIS.inserted.ObjectKey)
+	assert.Len(t, fakeFS.objects, 1)
+	assert.Contains(t, fakeFS.objects, key)
+
+	// file is removed if it can't be moved in place
+	fakeFS.moveObjectError = errors.New("move error")
+	upd, err = MakeRootfsImageArtifact(1, false)
+	assert.NoError(t, err)
+	_, err = iModel.CreateImage(ctx, &controller.MultipartUploadMsg{
+		MetaConstructor: createValidImageMeta(),
+		ArtifactSize:    int64(upd.Len()),
+		ArtifactReader:  upd,
+	})
+	assert.Error(t, err)
+	assert.Len(t, fakeFS.objects, 1)
+}
+
+func TestCreateImageFromUpload(t *testing.T) {
+	upd, err := MakeRootfsImageArtifact(1, false)
+	assert.NoError(t, err)
+	data := upd.Bytes()
+	sum := sha256.Sum256(data)
+	md5Sum := md5.Sum(data)
+
+	testCases := map[string]struct {
+		data      []byte
+		size      int64
+		checksum  string
+		checksums images.Checksums
+
+		err error
+	}{
+		"ok": {
+			data:     data,
+			size:     int64(len(data)),
+			checksum: hex.EncodeToString(sum[:]),
+		},
+		"ok, no checksum": {
+			data: data,
+			size: int64(len(data)),
+		},
+		"ok, checksums": {
+			data: data,
+			size: int64(len(data)),
+			checksums: images.Checksums{
+				images.ChecksumMD5:    hex.EncodeToString(md5Sum[:]),
+				images.ChecksumSHA256: hex.EncodeToString(sum[:]),
+			},
+		},
+		"size mismatch": {
+			data: data,
+			size: int64(len(data)) - 1,
+			err:  controller.ErrModelUploadSizeMismatch,
+		},
+		"md5 checksum mismatch": {
+			data: data,
+			size: int64(len(data)),
+			checksums: images.Checksums{
+				images.ChecksumMD5:    hex.EncodeToString(make([]byte, 16)),
+				images.ChecksumSHA256: hex.EncodeToString(sum[:]),
+			},
+			err: controller.ErrModelUploadChecksumMismatch,
+		},
+		"checksum mismatch": {
+			data:     data,
+			size:     int64(len(data)),
+			checksum: hex.EncodeToString(make([]byte, 32)),
+			err:      controller.ErrModelUploadChecksumMismatch,
+		},
+		"not an artifact": {
+			data: []byte("foobar"),
+			size: 6,
+			err:  controller.ErrModelParsingArtifactFailed,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fakeIS := &FakeImageStorage{isArtifactUnique: true}
+			fakeFS := &FakeFileStorage{objects: map[string][]byte{}}
+			iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+
+			session := images.NewUploadSession(&images.UploadSessionConstructor{
+				Size:        tc.size,
+				Description: "description",
+				Checksum:    tc.checksum,
+				Checksums:   tc.checksums,
+			}, time.Hour)
+			fakeFS.objects[images.ObjectKey("", session.Id)] = tc.data
+
+			id, err := iModel.CreateImageFromUpload(context.Background(), session)
+			if tc.err != nil {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err.Error())
+				assert.Nil(t, fakeIS.inserted)
+				assert.Len(t, fakeFS.objects, 1)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, session.Id, id)
+			assert.Equal(t, "mender-1.1", fakeIS.inserted.Name)
+			assert.Equal(t, "description", fakeIS.inserted.Description)
+			assert.Equal(t, tc.size, fakeIS.inserted.Size)
+			assert.Equal(t, hex.EncodeToString(sum[:]), fakeIS.inserted.Checksum)
+			assert.Equal(t, images.Checksums{
+				images.ChecksumMD5:    hex.EncodeToString(md5Sum[:]),
+				images.ChecksumSHA256: hex.EncodeToString(sum[:]),
+			}, fakeIS.inserted.Checksums)
+		})
+	}
+}
+
+func TestCreatePendingImage(t *testing.T) {
+	pending := &images.SoftwareImagePending{
+		Name:                  "mender-1.1",
+		DeviceTypesCompatible: []string{"vexpress-qemu"},
+		SoftwareImageMetaConstructor: images.SoftwareImageMetaConstructor{
+			Description: "awaiting approval",
+		},
+	}
+
+	fakeIS := new(FakeImageStorage)
+	iModel := NewImagesModel(new(FakeFileStorage), nil, fakeIS, nil, nil)
+
+	// invalid metadata
+	_, err := iModel.CreatePendingImage(context.Background(),
+		&images.SoftwareImagePending{Name: "mender-1.1"})
+	assert.Equal(t, controller.ErrModelInvalidMetadata, pkgerrors.Cause(err))
+
+	// name taken
+	_, err = iModel.CreatePendingImage(context.Background(), pending)
+	assert.Equal(t, controller.ErrModelArtifactNotUnique, err)
+
+	// success
+	fakeIS.isArtifactUnique = true
+	id, err := iModel.CreatePendingImage(context.Background(), pending)
+	assert.NoError(t, err)
+	if assert.NotNil(t, fakeIS.inserted) {
+		assert.Equal(t, id, fakeIS.inserted.Id)
+		assert.True(t, fakeIS.inserted.IsPending())
+		assert.Equal(t, pending.Name, fakeIS.inserted.Name)
+		assert.Equal(t, pending.Description, fakeIS.inserted.Description)
+	}
+}
+
+func TestUploadPendingImage(t *testing.T) {
+	meta := images.SoftwareImageMetaConstructor{Description: "awaiting approval"}
+
+	testCases := map[string]struct {
+		image            *images.SoftwareImage
+		isArtifactUnique bool
+		update           bool
+
+		err error
+	}{
+		"ok": {
+			image: images.NewPendingSoftwareImage(validUUIDv4,
+				&images.SoftwareImagePending{
+					Name:                         "mender-1.1",
+					DeviceTypesCompatible:        []string{"vexpress-qemu"},
+					SoftwareImageMetaConstructor: meta,
+				}),
+			update: true,
+		},
+		"ok, more device types": {
+			image: images.NewPendingSoftwareImage(validUUIDv4,
+				&images.SoftwareImagePending{
+					Name:                         "mender-1.1",
+					DeviceTypesCompatible:        []string{"beaglebone"},
+					SoftwareImageMetaConstructor: meta,
+				}),
+			isArtifactUnique: true,
+			update:           true,
+		},
+		"device type taken": {
+			image: images.NewPendingSoftwareImage(validUUIDv4,
+				&images.SoftwareImagePending{
+					Name:                  "mender-1.1",
+					DeviceTypesCompatible: []string{"beaglebone"},
+				}),
+			err: controller.ErrModelArtifactNotUnique,
+		},
+		"name mismatch": {
+			image: images.NewPendingSoftwareImage(validUUIDv4,
+				&images.SoftwareImagePending{
+					Name:                  "mender-1.2",
+					DeviceTypesCompatible: []string{"vexpress-qemu"},
+				}),
+			err: controller.ErrModelPendingNameMismatch,
+		},
+		"not found": {
+			err: controller.ErrImageMetaNotFound,
+		},
+		"already uploaded": {
+			image: images.NewSoftwareImage(validUUIDv4, &meta,
+				&images.SoftwareImageMetaArtifactConstructor{
+					Name:                  "mender-1.1",
+					DeviceTypesCompatible: []string{"vexpress-qemu"},
+				}),
+			err: controller.ErrModelImageNotPending,
+		},
+		"removed in the meantime": {
+			image: images.NewPendingSoftwareImage(validUUIDv4,
+				&images.SoftwareImagePending{
+					Name:                  "mender-1.1",
+					DeviceTypesCompatible: []string{"vexpress-qemu"},
+				}),
+			err: controller.ErrImageMetaNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fakeIS := new(FakeImageStorage)
+			fakeIS.findByIdImage = tc.image
+			fakeIS.isArtifactUnique = tc.isArtifactUnique
+			fakeIS.update = tc.update
+			fakeFS := &FakeFileStorage{objects: map[string][]byte{}}
+
+			iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+
+			upd, err := MakeRootfsImageArtifact(1, false)
+			assert.NoError(t, err)
+			size := int64(upd.Len())
+
+			err = iModel.UploadPendingImage(context.Background(), validUUIDv4,
+				&controller.MultipartUploadMsg{
+					MetaConstructor: createValidImageMeta(),
+					ArtifactSize:    size,
+					ArtifactReader:  upd,
+				})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				assert.Empty(t, fakeFS.objects)
+				return
+			}
+
+			assert.NoError(t, err)
+			if assert.NotNil(t, fakeIS.updated) {
+				assert.Equal(t, validUUIDv4, fakeIS.updated.Id)
+				assert.False(t, fakeIS.updated.IsPending())
+				assert.Equal(t, []string{"vexpress-qemu"},
+					fakeIS.updated.DeviceTypesCompatible)
+				assert.Equal(t, meta, fakeIS.updated.SoftwareImageMetaConstructor)
+				assert.Equal(t, size, fakeIS.updated.Size)
+			}
+			assert.Contains(t, fakeFS.objects, images.ObjectKey("", validUUIDv4))
+		})
+	}
 }
 
 func TestCreateSignedImageCreateOK(t *testing.T) {
@@ -202,7 +975,7 @@ func TestCreateSignedImageCreateOK(t *testing.T) {
 	fakeIS.isArtifactUnique = true
 	fakeFS := new(FakeFileStorage)
 
-	iModel := NewImagesModel(fakeFS, nil, fakeIS)
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
 
 	td, _ := ioutil.TempDir("", "mender-install-update-")
 	defer os.RemoveAll(td)
@@ -226,7 +999,7 @@ func TestGetImageFindByIDError(t *testing.T) {
 	fakeIS := new(FakeImageStorage)
 	fakeIS.findByIdError = errors.New("find by id error")
 
-	iModel := NewImagesModel(nil, nil, fakeIS)
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
 	if _, err := iModel.GetImage(context.Background(), ""); err == nil {
 		t.FailNow()
 	}
@@ -236,7 +1009,7 @@ func TestGetImageFindByIDEmptyImage(t *testing.T) {
 	fakeIS := new(FakeImageStorage)
 	fakeIS.findByIdImage = nil
 
-	iModel := NewImagesModel(nil, nil, fakeIS)
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
 	if image, err := iModel.GetImage(context.Background(),
 		""); err != nil || image != nil {
 
@@ -255,9 +1028,26 @@ type FakeFileStorage struct {
 	getReq              *images.Link
 	getError            error
 	uploadArtifactError error
+	getObjectError      error
+	rotateObjectError   error
+	rotatedVersion      string
+	objectVersion       string
+	objectVersionError  error
+	objectVersionCalls  int
+	getVersion          string
+	moveObjectError     error
+	copyObjectError     error
+	openObjectError     error
+	// uploaded objects are kept if initialized
+	objects map[string][]byte
+	// upload does not complete until its context is done
+	uploadStalled bool
 }
 
 func (ffs *FakeFileStorage) Delete(ctx context.Context, objectId string) error {
+	if ffs.objects != nil {
+		delete(ffs.objects, objectId)
+	}
 	return ffs.deleteError
 }
 
@@ -275,19 +1065,101 @@ func (ffs *FakeFileStorage) PutRequest(ctx context.Context, objectId string,
 	return ffs.putReq, ffs.putError
 }
 
-func (ffs *FakeFileStorage) GetRequest(ctx context.Context, objectId string,
+func (ffs *FakeFileStorage) GetRequest(ctx context.Context, objectId, versionId string,
 	duration time.Duration, responseContentType string) (*images.Link, error) {
+	ffs.getVersion = versionId
 	return ffs.getReq, ffs.getError
 }
 
+func (ffs *FakeFileStorage) ObjectVersion(ctx context.Context,
+	objectId string) (string, error) {
+	ffs.objectVersionCalls++
+	return ffs.objectVersion, ffs.objectVersionError
+}
+
 func (fis *FakeFileStorage) UploadArtifact(ctx context.Context, id string,
 	size int64, img io.Reader, contentType string) error {
-	if _, err := io.Copy(ioutil.Discard, img); err != nil {
+	data, err := ioutil.ReadAll(img)
+	if err != nil {
 		return err
 	}
+	if fis.objects != nil && fis.uploadArtifactError == nil {
+		fis.objects[id] = data
+	}
+	if fis.uploadStalled {
+		<-ctx.Done()
+		return ctx.Err()
+	}
 	return fis.uploadArtifactError
 }
 
+func (ffs *FakeFileStorage) RotateObject(ctx context.Context,
+	objectId string) (string, error) {
+	if ffs.rotateObjectError != nil {
+		return "", ffs.rotateObjectError
+	}
+	return ffs.rotatedVersion, nil
+}
+
+func (ffs *FakeFileStorage) CopyObject(ctx context.Context,
+	objectId, newObjectId string) error {
+	if ffs.copyObjectError != nil {
+		return ffs.copyObjectError
+	}
+	if ffs.objects != nil {
+		ffs.objects[newObjectId] = ffs.objects[objectId]
+	}
+	return nil
+}
+
+func (ffs *FakeFileStorage) MoveObject(ctx context.Context,
+	objectId, newObjectId string) error {
+	if ffs.moveObjectError != nil {
+		return ffs.moveObjectError
+	}
+	if ffs.objects != nil {
+		ffs.objects[newObjectId] = ffs.objects[objectId]
+		delete(ffs.objects, objectId)
+	}
+	return nil
+}
+
+func (ffs *FakeFileStorage) Location(objectId string) *images.StorageLocation {
+	return &images.StorageLocation{Backend: "fake", Bucket: "bucket", Key: objectId}
+}
+
+func (ffs *FakeFileStorage) OpenObject(ctx context.Context,
+	objectId string) (images.FileReader, error) {
+	if ffs.openObjectError != nil {
+		return nil, ffs.openObjectError
+	}
+	data, ok := ffs.objects[objectId]
+	if !ok {
+		return nil, ErrFileStorageFileNotFound
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func (ffs *FakeFileStorage) GetObject(ctx context.Context,
+	objectId string) (io.ReadCloser, error) {
+	if ffs.getObjectError != nil {
+		return nil, ffs.getObjectError
+	}
+	data, ok := ffs.objects[objectId]
+	if !ok {
+		return nil, ErrFileStorageFileNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
 func TestGetImageOK(t *testing.T) {
 	imageMeta := createValidImageMeta()
 	imageMetaArtifact := createValidImageMetaArtifact()
@@ -300,7 +1172,7 @@ func TestGetImageOK(t *testing.T) {
 	fakeFS := new(FakeFileStorage)
 	fakeFS.lastModifiedTime = time.Now()
 
-	iModel := NewImagesModel(fakeFS, nil, fakeIS)
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
 	if image, err := iModel.GetImage(context.Background(),
 		""); err != nil || image == nil {
 
@@ -308,6 +1180,60 @@ func TestGetImageOK(t *testing.T) {
 	}
 }
 
+func TestGetImageExpired(t *testing.T) {
+	expiresAt := time.Now().Add(-time.Minute)
+	imageMeta := createValidImageMeta()
+	imageMeta.ExpiresAt = &expiresAt
+	image := images.NewSoftwareImage(validUUIDv4, imageMeta, createValidImageMetaArtifact())
+
+	fakeIS := new(FakeImageStorage)
+	fakeIS.findByIdImage = image
+	fakeIS.findByIdsImages = []*images.SoftwareImage{image}
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
+
+	found, err := iModel.GetImage(context.Background(), validUUIDv4)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+
+	lookup, err := iModel.GetImages(context.Background(), []string{validUUIDv4})
+	assert.NoError(t, err)
+	assert.Empty(t, lookup.Artifacts)
+	assert.Equal(t, []string{validUUIDv4}, lookup.Missing)
+
+	// not expired yet
+	expiresAt = time.Now().Add(time.Hour)
+	found, err = iModel.GetImage(context.Background(), validUUIDv4)
+	assert.NoError(t, err)
+	assert.Equal(t, image, found)
+}
+
+func TestImageLocation(t *testing.T) {
+	image := images.NewSoftwareImage(validUUIDv4,
+		createValidImageMeta(), createValidImageMetaArtifact())
+
+	fakeIS := new(FakeImageStorage)
+	iModel := NewImagesModel(new(FakeFileStorage), nil, fakeIS, nil, nil)
+
+	location, err := iModel.ImageLocation(context.Background(), validUUIDv4)
+	assert.Equal(t, controller.ErrImageMetaNotFound, err)
+	assert.Nil(t, location)
+
+	fakeIS.findByIdImage = image
+	ctx := identity.WithContext(context.Background(), &identity.Identity{Tenant: "tenant1"})
+	location, err = iModel.ImageLocation(ctx, validUUIDv4)
+	assert.NoError(t, err)
+	assert.Equal(t, &images.StorageLocation{
+		Backend: "fake",
+		Bucket:  "bucket",
+		Key:     image.FileObjectKey("tenant1"),
+	}, location)
+
+	fakeIS.findByIdImage = nil
+	fakeIS.findByIdError = errors.New("db error")
+	_, err = iModel.ImageLocation(context.Background(), validUUIDv4)
+	assert.EqualError(t, err, "Searching for image with specified ID: db error")
+}
+
 type FakeUseChecker struct {
 	usedInActiveDeploymentsErr error
 	isUsedInActiveDeployment   bool
@@ -325,6 +1251,97 @@ func (fus *FakeUseChecker) ImageUsedInDeployment(ctx context.Context, imageId st
 	return fus.isUsedInDeployment, fus.usedInDeploymentsErr
 }
 
+// fakeDeployments tells the images in use, the active deployments
+// of the image are aborted by AbortImageDeployments
+type fakeDeployments struct {
+	FakeUseChecker
+	active     map[string]int
+	abortError error
+}
+
+func (f *fakeDeployments) ImageUsedInActiveDeployment(ctx context.Context,
+	imageId string) (bool, error) {
+	return f.active[imageId] > 0, nil
+}
+
+func (f *fakeDeployments) AbortImageDeployments(ctx context.Context,
+	imageID string) (int, error) {
+	if f.abortError != nil {
+		return 0, f.abortError
+	}
+	aborted := f.active[imageID]
+	delete(f.active, imageID)
+	return aborted, nil
+}
+
+func TestDeleteDeviceTypeImages(t *testing.T) {
+	found := []*images.SoftwareImage{
+		{Id: "image-1"},
+		{Id: "image-2"},
+		{Id: "image-3"},
+	}
+
+	testCases := map[string]struct {
+		force      bool
+		abortError error
+		findError  error
+
+		report *images.DeleteReport
+		err    string
+	}{
+		"in use skipped": {
+			report: &images.DeleteReport{
+				Deleted: 1,
+				Skipped: 2,
+				InUse:   []string{"image-2", "image-3"},
+			},
+		},
+		"in use forced": {
+			force: true,
+			report: &images.DeleteReport{
+				Deleted:            3,
+				InUse:              []string{},
+				AbortedDeployments: 3,
+			},
+		},
+		"abort error": {
+			force:      true,
+			abortError: errors.New("db error"),
+			err:        "Aborting deployments of image image-1: db error",
+		},
+		"find error": {
+			findError: errors.New("db error"),
+			err:       "Searching for image metadata: db error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fakeIS := &FakeImageStorage{
+				findAllImages: found,
+				findAllError:  tc.findError,
+				findByIdImage: &images.SoftwareImage{Id: "image"},
+			}
+			deployments := &fakeDeployments{
+				active:     map[string]int{"image-2": 1, "image-3": 2},
+				abortError: tc.abortError,
+			}
+			iModel := NewImagesModel(new(FakeFileStorage), deployments, fakeIS, nil, nil)
+			iModel.SetDeploymentsAborter(deployments)
+
+			report, err := iModel.DeleteDeviceTypeImages(context.Background(),
+				"Raspberrypi3", tc.force)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.report, report)
+			assert.Equal(t, "raspberrypi3", fakeIS.filter.DeviceType)
+		})
+	}
+}
+
 func TestDeleteImage(t *testing.T) {
 	imageMeta := createValidImageMeta()
 	imageMetaArtifact := createValidImageMetaArtifact()
@@ -338,7 +1355,7 @@ func TestDeleteImage(t *testing.T) {
 
 	fakeChecker.usedInActiveDeploymentsErr = errors.New("error")
 
-	iModel := NewImagesModel(fakeFS, fakeChecker, fakeIS)
+	iModel := NewImagesModel(fakeFS, fakeChecker, fakeIS, nil, nil)
 
 	if err := iModel.DeleteImage(context.Background(), ""); err == nil {
 		t.FailNow()
@@ -357,10 +1374,13 @@ func TestDeleteImage(t *testing.T) {
 		t.FailNow()
 	}
 
+	// image is gone, failed file removal is retried later
 	fakeFS.deleteError = errors.New("error")
-	if err := iModel.DeleteImage(context.Background(), ""); err == nil {
+	if err := iModel.DeleteImage(context.Background(), ""); err != nil {
 		t.FailNow()
 	}
+	assert.Contains(t, fakeIS.failedDeletions,
+		constructorImage.FileObjectKey(""))
 
 	fakeFS.deleteError = nil
 	fakeIS.deleteError = errors.New("error")
@@ -386,7 +1406,7 @@ func TestListImages(t *testing.T) {
 	fakeChecker := new(FakeUseChecker)
 	fakeFS := new(FakeFileStorage)
 	fakeIS := new(FakeImageStorage)
-	iModel := NewImagesModel(fakeFS, fakeChecker, fakeIS)
+	iModel := NewImagesModel(fakeFS, fakeChecker, fakeIS, nil, nil)
 
 	fakeIS.findAllError = errors.New("error")
 	if _, err := iModel.ListImages(context.Background(), nil); err == nil {
@@ -395,9 +1415,17 @@ func TestListImages(t *testing.T) {
 
 	//no error; empty images list
 	fakeIS.findAllError = nil
-	if _, err := iModel.ListImages(context.Background(), nil); err != nil {
+	list, err := iModel.ListImages(context.Background(), nil)
+	if err != nil {
 		t.FailNow()
 	}
+	// no matches are listed as empty list, never nil
+	assert.NotNil(t, list)
+	assert.Empty(t, list)
+	list, err = iModel.ListImages(context.Background(), &images.ImagesFilter{Tags: []string{"x"}})
+	assert.NoError(t, err)
+	assert.NotNil(t, list)
+	fakeIS.filter = nil
 
 	//have some valid image
 	imageMeta := createValidImageMeta()
@@ -411,6 +1439,110 @@ func TestListImages(t *testing.T) {
 	if _, err := iModel.ListImages(context.Background(), nil); err != nil {
 		t.FailNow()
 	}
+	assert.Nil(t, fakeIS.filter)
+
+	//filtered by tags
+	filter := &images.ImagesFilter{Tags: []string{"stable", "beta"}}
+	list, err = iModel.ListImages(context.Background(), filter)
+	assert.NoError(t, err)
+	assert.Equal(t, listedImages, list)
+	assert.Equal(t, filter, fakeIS.filter)
+}
+
+func TestMergePage(t *testing.T) {
+	page := []*images.SoftwareImage{{Id: "b"}, {Id: "d"}}
+	shared := []*images.SoftwareImage{{Id: "a"}, {Id: "e"}, {Id: "c"}}
+
+	// the shared images before the cursor are left out
+	merged := mergePage(page, shared, &images.ImagesFilter{Limit: 3, After: "a"})
+	assert.Equal(t, []*images.SoftwareImage{{Id: "b"}, {Id: "c"}, {Id: "d"}}, merged)
+
+	merged = mergePage(nil, shared, &images.ImagesFilter{Limit: 3})
+	assert.Equal(t, []*images.SoftwareImage{{Id: "a"}, {Id: "c"}, {Id: "e"}}, merged)
+}
+
+func TestGetImages(t *testing.T) {
+	fakeIS := new(FakeImageStorage)
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
+
+	ids := []string{
+		"a81bd4b5-2a8c-4cfa-bc3a-c21b0c3c9b1b",
+		validUUIDv4,
+		"0c13a0e6-6b63-475d-8260-ee42a590e8ff",
+	}
+
+	fakeIS.findByIdsError = errors.New("error")
+	_, err := iModel.GetImages(context.Background(), ids)
+	assert.Error(t, err)
+
+	//none found
+	fakeIS.findByIdsError = nil
+	lookup, err := iModel.GetImages(context.Background(), ids)
+	assert.NoError(t, err)
+	assert.NotNil(t, lookup.Artifacts)
+	assert.Empty(t, lookup.Artifacts)
+	assert.Equal(t, ids, lookup.Missing)
+
+	//found in the requested order, missing listed
+	fakeIS.findByIdsImages = []*images.SoftwareImage{
+		{Id: ids[2]},
+		{Id: ids[0]},
+	}
+	lookup, err = iModel.GetImages(context.Background(), ids)
+	assert.NoError(t, err)
+	assert.Equal(t, []*images.SoftwareImage{{Id: ids[0]}, {Id: ids[2]}}, lookup.Artifacts)
+	assert.Equal(t, []string{validUUIDv4}, lookup.Missing)
+}
+
+func TestListDeviceTypes(t *testing.T) {
+	fakeIS := new(FakeImageStorage)
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
+
+	fakeIS.deviceTypesError = errors.New("error")
+	_, err := iModel.ListDeviceTypes(context.Background())
+	assert.Error(t, err)
+
+	//no images; empty list
+	fakeIS.deviceTypesError = nil
+	deviceTypes, err := iModel.ListDeviceTypes(context.Background())
+	assert.NoError(t, err)
+	assert.NotNil(t, deviceTypes)
+	assert.Empty(t, deviceTypes)
+
+	fakeIS.deviceTypes = []*images.DeviceTypeCount{
+		{DeviceType: "beaglebone", Count: 2},
+		{DeviceType: "qemu", Count: 1},
+	}
+	deviceTypes, err = iModel.ListDeviceTypes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, fakeIS.deviceTypes, deviceTypes)
+}
+
+func TestListImageChanges(t *testing.T) {
+	fakeIS := new(FakeImageStorage)
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
+
+	query := &images.ImageChangesQuery{Limit: 10}
+
+	fakeIS.changesError = errors.New("error")
+	_, err := iModel.ListImageChanges(context.Background(), query)
+	assert.Error(t, err)
+
+	// no changes; empty list
+	fakeIS.changesError = nil
+	changes, err := iModel.ListImageChanges(context.Background(), query)
+	assert.NoError(t, err)
+	assert.NotNil(t, changes)
+	assert.Empty(t, changes)
+	assert.Equal(t, query, fakeIS.changesQuery)
+
+	fakeIS.changes = []*images.ImageChange{
+		{Id: "1", Name: "foo", Change: images.ImageChangeDeleted, Timestamp: time.Now()},
+		{Id: "2", Name: "bar", Change: images.ImageChangeCreated, Timestamp: time.Now()},
+	}
+	changes, err = iModel.ListImageChanges(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Equal(t, fakeIS.changes, changes)
 }
 
 func TestEditImage(t *testing.T) {
@@ -419,7 +1551,7 @@ func TestEditImage(t *testing.T) {
 
 	fakeChecker := new(FakeUseChecker)
 	fakeIS := new(FakeImageStorage)
-	iModel := NewImagesModel(nil, fakeChecker, fakeIS)
+	iModel := NewImagesModel(nil, fakeChecker, fakeIS, nil, nil)
 
 	// error checking if image is used in deployments
 	fakeChecker.usedInDeploymentsErr = errors.New("error")
@@ -469,54 +1601,578 @@ func TestEditImage(t *testing.T) {
 	}
 }
 
+func TestPatchImage(t *testing.T) {
+	imageMeta := createValidImageMeta()
+	imageMetaArtifact := createValidImageMetaArtifact()
+
+	fakeChecker := new(FakeUseChecker)
+	fakeIS := new(FakeImageStorage)
+	iModel := NewImagesModel(nil, fakeChecker, fakeIS, nil, nil)
+
+	description := "new description"
+	patch := &images.SoftwareImageMetaPatch{Description: &description}
+
+	// invalid patch
+	tooLong := strings.Repeat("a", 4097)
+	_, err := iModel.PatchImage(context.Background(), validUUIDv4,
+		&images.SoftwareImageMetaPatch{Description: &tooLong})
+	assert.Error(t, err)
+
+	// finding error
+	fakeIS.findByIdError = errors.New("error")
+	_, err = iModel.PatchImage(context.Background(), validUUIDv4, patch)
+	assert.Error(t, err)
+
+	// cannot find image
+	fakeIS.findByIdError = nil
+	found, err := iModel.PatchImage(context.Background(), validUUIDv4, patch)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// update error
+	image := images.NewSoftwareImage(validUUIDv4, imageMeta, imageMetaArtifact)
+	fakeIS.findByIdImage = image
+	fakeIS.updateError = errors.New("error")
+	_, err = iModel.PatchImage(context.Background(), validUUIDv4, patch)
+	assert.Error(t, err)
+
+	// update OK; usage in deployments does not matter
+	fakeIS.updateError = nil
+	fakeChecker.isUsedInDeployment = true
+	found, err = iModel.PatchImage(context.Background(), validUUIDv4, patch)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, description, image.Description)
+	assert.Equal(t, imageMetaArtifact.Name, image.Name)
+
+	// empty patch leaves description untouched
+	found, err = iModel.PatchImage(context.Background(), validUUIDv4,
+		&images.SoftwareImageMetaPatch{})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, description, image.Description)
+
+	// deprecation moves the image to the deprecated state
+	deprecated := true
+	found, err = iModel.PatchImage(context.Background(), validUUIDv4,
+		&images.SoftwareImageMetaPatch{Deprecated: &deprecated})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, images.ImageStateDeprecated, image.State)
+	assert.True(t, image.IsDeprecated())
+
+	// and back to uploaded, to be reviewed again
+	deprecated = false
+	found, err = iModel.PatchImage(context.Background(), validUUIDv4,
+		&images.SoftwareImageMetaPatch{Deprecated: &deprecated})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, images.ImageStateUploaded, image.State)
+
+	// state change error
+	deprecated = true
+	fakeIS.setStateError = errors.New("error")
+	_, err = iModel.PatchImage(context.Background(), validUUIDv4,
+		&images.SoftwareImageMetaPatch{Deprecated: &deprecated})
+	assert.Error(t, err)
+	fakeIS.setStateError = nil
+
+	// required fields cannot be cleared, the image is left untouched
+	images.RequiredMetaFields = []string{"description"}
+	defer func() { images.RequiredMetaFields = nil }()
+	empty := ""
+	found, err = iModel.PatchImage(context.Background(), validUUIDv4,
+		&images.SoftwareImageMetaPatch{Description: &empty})
+	assert.Equal(t, controller.ErrModelInvalidMetadata, pkgerrors.Cause(err))
+	assert.False(t, found)
+	assert.Equal(t, description, image.Description)
+}
+
+func TestUpdateImagesTags(t *testing.T) {
+	fakeIS := new(FakeImageStorage)
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
+
+	// invalid tag
+	_, err := iModel.AddImagesTag(context.Background(),
+		&images.TagsUpdate{Tag: "with space", IDs: []string{validUUIDv4}})
+	assert.EqualError(t, err, "Validating tags update: "+images.ErrInvalidTag.Error())
+	assert.Nil(t, fakeIS.tagsUpdate)
+
+	// storage error
+	fakeIS.tagsError = errors.New("error")
+	_, err = iModel.RemoveImagesTag(context.Background(),
+		&images.TagsUpdate{Tag: "stable", IDs: []string{validUUIDv4}})
+	assert.EqualError(t, err, "Updating image tags: error")
+
+	// device type is normalized
+	fakeIS.tagsError = nil
+	fakeIS.tagsModified = 3
+	report, err := iModel.AddImagesTag(context.Background(),
+		&images.TagsUpdate{Tag: "stable", DeviceType: " BeagleBone "})
+	assert.NoError(t, err)
+	assert.Equal(t, &images.TagsUpdateReport{Modified: 3}, report)
+	assert.Equal(t, &images.TagsUpdate{Tag: "stable", DeviceType: "beaglebone"},
+		fakeIS.tagsUpdate)
+
+	report, err = iModel.RemoveImagesTag(context.Background(),
+		&images.TagsUpdate{Tag: "stable", IDs: []string{validUUIDv4}})
+	assert.NoError(t, err)
+	assert.Equal(t, &images.TagsUpdateReport{Modified: 3}, report)
+	assert.Equal(t, &images.TagsUpdate{Tag: "stable", IDs: []string{validUUIDv4}},
+		fakeIS.tagsUpdate)
+}
+
+func TestCloneImage(t *testing.T) {
+	imageMeta := createValidImageMeta()
+	imageMeta.Description = "original"
+	imageMetaArtifact := createValidImageMetaArtifact()
+
+	fakeIS := new(FakeImageStorage)
+	fakeFS := &FakeFileStorage{objects: map[string][]byte{}}
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "tenant1"})
+	description := "copy"
+	clone := &images.SoftwareImageClone{
+		TenantID: "tenant2",
+		SoftwareImageMetaPatch: images.SoftwareImageMetaPatch{
+			Description: &description,
+		},
+	}
+
+	// invalid metadata
+	tooLong := strings.Repeat("a", 4097)
+	_, err := iModel.CloneImage(ctx, validUUIDv4, &images.SoftwareImageClone{
+		SoftwareImageMetaPatch: images.SoftwareImageMetaPatch{Description: &tooLong},
+	})
+	assert.Error(t, err)
+
+	// finding error
+	fakeIS.findByIdError = errors.New("error")
+	_, err = iModel.CloneImage(ctx, validUUIDv4, clone)
+	assert.Error(t, err)
+
+	// cannot find image
+	fakeIS.findByIdError = nil
+	_, err = iModel.CloneImage(ctx, validUUIDv4, clone)
+	assert.Equal(t, controller.ErrImageMetaNotFound, err)
+
+	// not unique for the target tenant
+	image := images.NewSoftwareImage(validUUIDv4, imageMeta, imageMetaArtifact)
+	fakeIS.findByIdImage = image
+	_, err = iModel.CloneImage(ctx, validUUIDv4, clone)
+	assert.Equal(t, controller.ErrModelArtifactNotUnique, err)
+
+	// copying error; no metadata stored
+	fakeIS.isArtifactUnique = true
+	fakeFS.copyObjectError = errors.New("error")
+	_, err = iModel.CloneImage(ctx, validUUIDv4, clone)
+	assert.Error(t, err)
+	assert.Nil(t, fakeIS.inserted)
+
+	// insert error; copied file removed
+	fakeFS.copyObjectError = nil
+	fakeFS.objects["tenant1/"+validUUIDv4] = []byte("artifact")
+	fakeIS.insertError = errors.New("error")
+	_, err = iModel.CloneImage(ctx, validUUIDv4, clone)
+	assert.Error(t, err)
+	assert.Len(t, fakeFS.objects, 1)
+
+	// OK
+	fakeIS.insertError = nil
+	id, err := iModel.CloneImage(ctx, validUUIDv4, clone)
+	assert.NoError(t, err)
+	assert.NotEqual(t, validUUIDv4, id)
+	if assert.NotNil(t, fakeIS.inserted) {
+		assert.Equal(t, id, fakeIS.inserted.Id)
+		assert.Equal(t, "copy", fakeIS.inserted.Description)
+		assert.Equal(t, imageMetaArtifact.Name, fakeIS.inserted.Name)
+		assert.Equal(t, "tenant2/"+id, fakeIS.inserted.ObjectKey)
+	}
+	assert.Equal(t, []byte("artifact"), fakeFS.objects["tenant2/"+id])
+	assert.Equal(t, []byte("artifact"), fakeFS.objects["tenant1/"+validUUIDv4])
+	assert.Equal(t, "original", image.Description)
+}
+
+func TestOpenImage(t *testing.T) {
+	fakeIS := new(FakeImageStorage)
+	fakeFS := &FakeFileStorage{objects: map[string][]byte{}}
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "tenant1"})
+
+	// searching for image error
+	fakeIS.findByIdError = errors.New("error")
+	_, err := iModel.OpenImage(ctx, validUUIDv4)
+	assert.Error(t, err)
+
+	// image not found
+	fakeIS.findByIdError = nil
+	file, err := iModel.OpenImage(ctx, validUUIDv4)
+	assert.NoError(t, err)
+	assert.Nil(t, file)
+
+	// file not found
+	image := images.NewSoftwareImage(validUUIDv4,
+		createValidImageMeta(), createValidImageMetaArtifact())
+	image.Checksum = "0123456789abcdef"
+	fakeIS.findByIdImage = image
+	_, err = iModel.OpenImage(ctx, validUUIDv4)
+	assert.Error(t, err)
+
+	// OK
+	fakeFS.objects["tenant1/"+validUUIDv4] = []byte("artifact")
+	file, err = iModel.OpenImage(ctx, validUUIDv4)
+	assert.NoError(t, err)
+	if assert.NotNil(t, file) {
+		data, err := ioutil.ReadAll(file)
+		assert.NoError(t, err)
+		assert.Equal(t, "artifact", string(data))
+		assert.Equal(t, *image.Modified, file.Modified)
+		assert.Equal(t, "0123456789abcdef", file.Checksum)
+	}
+}
+
 func TestDownloadLink(t *testing.T) {
 	fakeChecker := new(FakeUseChecker)
 	fakeIS := new(FakeImageStorage)
 	fakeFS := new(FakeFileStorage)
-	iModel := NewImagesModel(fakeFS, fakeChecker, fakeIS)
+	iModel := NewImagesModel(fakeFS, fakeChecker, fakeIS, nil, nil)
 
-	// image exists error
-	fakeIS.imageEsistsError = errors.New("error")
+	// searching for image error
+	fakeIS.findByIdError = errors.New("error")
 	if _, err := iModel.DownloadLink(context.Background(),
-		"iamge", time.Hour); err == nil {
+		"iamge", time.Hour, ""); err == nil {
 		t.FailNow()
 	}
 
 	// searching for image failed
-	fakeIS.imageEsistsError = errors.New("Serarching for image failed")
-	fakeIS.imageExists = false
+	fakeIS.findByIdError = errors.New("Serarching for image failed")
+	fakeIS.findByIdImage = nil
 	if link, err := iModel.DownloadLink(context.Background(),
-		"iamge", time.Hour); err == nil || link != nil {
+		"iamge", time.Hour, ""); err == nil || link != nil {
 		t.FailNow()
 	}
 
 	// iamge does not esists
-	fakeIS.imageEsistsError = nil
-	fakeIS.imageExists = false
+	fakeIS.findByIdError = nil
+	fakeIS.findByIdImage = nil
 	if link, err := iModel.DownloadLink(context.Background(),
-		"iamge", time.Hour); err != nil || link != nil {
+		"iamge", time.Hour, ""); err != nil || link != nil {
 		t.FailNow()
 	}
 
+	// image file is not uploaded yet
+	fakeIS.findByIdImage = &images.SoftwareImage{
+		Id:     validUUIDv4,
+		Status: images.ImageStatusPending,
+	}
+	_, err := iModel.DownloadLink(context.Background(), "image", time.Hour, "")
+	assert.Equal(t, controller.ErrModelImagePending, err)
+
+	// image file is missing
+	fakeIS.findByIdImage = &images.SoftwareImage{Id: validUUIDv4}
+	_, err = iModel.DownloadLink(context.Background(), "image", time.Hour, "")
+	assert.Equal(t, controller.ErrModelArtifactFileMissing, err)
+
 	// can not generate link
-	fakeIS.imageExists = true
 	fakeFS.imageExists = true
 	fakeFS.getError = errors.New("error")
 	if _, err := iModel.DownloadLink(context.Background(),
-		"iamge", time.Hour); err == nil {
+		"iamge", time.Hour, ""); err == nil {
 		t.FailNow()
 	}
 
+	// file storage throttled the request
+	fakeFS.getError = pkgerrors.Wrap(ErrFileStorageThrottled, "SlowDown")
+	_, err = iModel.DownloadLink(context.Background(), "image", time.Hour, "")
+	assert.Equal(t, controller.ErrModelStorageThrottled, pkgerrors.Cause(err))
+
 	// upload link generation success
 	fakeFS.getError = nil
 	link := images.NewLink("uri", time.Now())
 	fakeFS.getReq = link
+	fakeIS.downloads = make(chan string, 1)
 
 	receivedLink, err := iModel.DownloadLink(context.Background(),
-		"image", time.Hour)
+		"image", time.Hour, "")
 	if err != nil || !reflect.DeepEqual(link, receivedLink) {
 		t.FailNow()
 	}
+
+	// download counted in the background
+	select {
+	case id := <-fakeIS.downloads:
+		assert.Equal(t, "image", id)
+	case <-time.After(time.Second):
+		t.Fatal("download not counted")
+	}
+
+	// link serving byte ranges is described with the file size and checksum
+	fakeIS.findByIdImage.Size = 1024
+	fakeIS.findByIdImage.Checksum = "sha256sum"
+	fakeFS.getReq = &images.Link{Uri: "uri", AcceptRanges: true}
+	receivedLink, err = iModel.DownloadLink(context.Background(),
+		"image", time.Hour, "")
+	assert.NoError(t, err)
+	assert.Equal(t, &images.Link{
+		Uri:          "uri",
+		AcceptRanges: true,
+		Size:         1024,
+		Checksum:     "sha256sum",
+	}, receivedLink)
+	<-fakeIS.downloads
+}
+
+type FakeAssignmentChecker struct {
+	assigned map[string]string
+	err      error
+}
+
+func (fac *FakeAssignmentChecker) ImageAssignedToDevice(ctx context.Context,
+	deviceID, imageID string) (bool, error) {
+
+	return fac.assigned[deviceID] == imageID, fac.err
+}
+
+func TestDownloadLinkRestrictDevices(t *testing.T) {
+	fakeIS := &FakeImageStorage{
+		findByIdImage: &images.SoftwareImage{Id: validUUIDv4},
+	}
+	fakeFS := &FakeFileStorage{
+		imageExists: true,
+		getReq:      images.NewLink("uri", time.Now()),
+	}
+	fakeAssignments := &FakeAssignmentChecker{
+		assigned: map[string]string{"device1": validUUIDv4},
+	}
+	iModel := NewImagesModel(fakeFS, new(FakeUseChecker), fakeIS, nil, nil)
+	iModel.RestrictDeviceDownloads(fakeAssignments)
+
+	deviceCtx := func(id string) context.Context {
+		return identity.WithContext(context.Background(),
+			&identity.Identity{Subject: id, IsDevice: true})
+	}
+
+	// assigned to the device
+	link, err := iModel.DownloadLink(deviceCtx("device1"), validUUIDv4, time.Hour, "")
+	assert.NoError(t, err)
+	assert.Equal(t, fakeFS.getReq, link)
+
+	// not assigned to the device
+	_, err = iModel.DownloadLink(deviceCtx("device2"), validUUIDv4, time.Hour, "")
+	assert.Equal(t, controller.ErrModelImageNotAssigned, err)
+
+	_, err = iModel.OpenImage(deviceCtx("device2"), validUUIDv4)
+	assert.Equal(t, controller.ErrModelImageNotAssigned, err)
+
+	// users are not restricted
+	userCtx := identity.WithContext(context.Background(),
+		&identity.Identity{Subject: "user"})
+	link, err = iModel.DownloadLink(userCtx, validUUIDv4, time.Hour, "")
+	assert.NoError(t, err)
+	assert.Equal(t, fakeFS.getReq, link)
+
+	// checking failed
+	fakeAssignments.err = errors.New("db error")
+	_, err = iModel.DownloadLink(deviceCtx("device1"), validUUIDv4, time.Hour, "")
+	assert.EqualError(t, err,
+		"Checking if image is assigned to the device: db error")
+}
+
+func TestRotateDownloadLinks(t *testing.T) {
+	fakeChecker := new(FakeUseChecker)
+	fakeIS := new(FakeImageStorage)
+	fakeFS := new(FakeFileStorage)
+	iModel := NewImagesModel(fakeFS, fakeChecker, fakeIS, nil, nil)
+
+	// searching for image error
+	fakeIS.findByIdError = errors.New("error")
+	err := iModel.RotateDownloadLinks(context.Background(), validUUIDv4)
+	assert.Error(t, err)
+
+	// image does not exist
+	fakeIS.findByIdError = nil
+	fakeIS.findByIdImage = nil
+	err = iModel.RotateDownloadLinks(context.Background(), validUUIDv4)
+	assert.Equal(t, controller.ErrImageMetaNotFound, err)
+
+	// image file does not exist
+	fakeIS.findByIdImage = &images.SoftwareImage{Id: validUUIDv4}
+	fakeFS.rotateObjectError = ErrFileStorageFileNotFound
+	err = iModel.RotateDownloadLinks(context.Background(), validUUIDv4)
+	assert.Equal(t, controller.ErrImageMetaNotFound, err)
+
+	// file storage not supporting revocation
+	fakeFS.rotateObjectError = ErrFileStorageNotSupported
+	err = iModel.RotateDownloadLinks(context.Background(), validUUIDv4)
+	assert.Equal(t, controller.ErrModelLinkRotationNotSupported, err)
+
+	// file storage error
+	fakeFS.rotateObjectError = errors.New("error")
+	err = iModel.RotateDownloadLinks(context.Background(), validUUIDv4)
+	assert.Error(t, err)
+
+	// success, new links are pinned to the copy
+	fakeFS.rotateObjectError = nil
+	fakeFS.rotatedVersion = "v2"
+	fakeIS.fileVersions = map[string]string{"": "v1"}
+	err = iModel.RotateDownloadLinks(context.Background(), validUUIDv4)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"": "v2"}, fakeIS.fileVersions)
+
+	// replicas are rotated too
+	replica := &FakeFileStorage{rotatedVersion: "r2"}
+	iModel.SetReplicas(map[string]FileStorage{"eu": replica})
+	fakeIS.findByIdImage.Replicas = []string{"eu"}
+	err = iModel.RotateDownloadLinks(context.Background(), validUUIDv4)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"": "v2", "eu": "r2"}, fakeIS.fileVersions)
+
+	replica.rotateObjectError = errors.New("error")
+	err = iModel.RotateDownloadLinks(context.Background(), validUUIDv4)
+	assert.EqualError(t, err, "Rotating image file replica in eu: error")
+
+	// version not recorded, old links would be handed out
+	fakeIS.setFileVersionError = errors.New("db error")
+	err = iModel.RotateDownloadLinks(context.Background(), validUUIDv4)
+	assert.EqualError(t, err, "Recording image file version: db error")
+}
+
+func TestDownloadLinkFileVersion(t *testing.T) {
+	fakeIS := &FakeImageStorage{
+		findByIdImage: &images.SoftwareImage{Id: validUUIDv4},
+		downloads:     make(chan string, 10),
+	}
+	fakeFS := &FakeFileStorage{
+		imageExists:   true,
+		getReq:        images.NewLink("uri", time.Now()),
+		objectVersion: "v1",
+	}
+	iModel := NewImagesModel(fakeFS, new(FakeUseChecker), fakeIS, nil, nil)
+
+	// looked up for the first link and recorded
+	_, err := iModel.DownloadLink(context.Background(), validUUIDv4, time.Hour, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", fakeFS.getVersion)
+	assert.Equal(t, 1, fakeFS.objectVersionCalls)
+	assert.Equal(t, map[string]string{"": "v1"}, fakeIS.fileVersions)
+
+	// recorded version is used as is
+	recorded := "v2"
+	fakeIS.findByIdImage.FileVersion = &recorded
+	_, err = iModel.DownloadLink(context.Background(), validUUIDv4, time.Hour, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", fakeFS.getVersion)
+	assert.Equal(t, 1, fakeFS.objectVersionCalls)
+
+	// replica links are pinned to the replica versions
+	replica := &FakeFileStorage{getReq: images.NewLink("replica", time.Now())}
+	iModel.SetReplicas(map[string]FileStorage{"eu": replica})
+	fakeIS.findByIdImage.Replicas = []string{"eu"}
+	fakeIS.findByIdImage.ReplicaVersions = map[string]string{"eu": "r1"}
+	link, err := iModel.DownloadLink(context.Background(), validUUIDv4, time.Hour, "eu")
+	assert.NoError(t, err)
+	assert.Equal(t, "replica", link.Uri)
+	assert.Equal(t, "r1", replica.getVersion)
+	assert.Equal(t, 0, replica.objectVersionCalls)
+
+	// failing lookup in the replica falls back to the primary
+	fakeIS.findByIdImage.ReplicaVersions = nil
+	replica.objectVersionError = errors.New("error")
+	link, err = iModel.DownloadLink(context.Background(), validUUIDv4, time.Hour, "eu")
+	assert.NoError(t, err)
+	assert.Equal(t, "uri", link.Uri)
+
+	// failing lookup in the primary
+	fakeIS.findByIdImage.FileVersion = nil
+	fakeFS.objectVersionError = errors.New("error")
+	_, err = iModel.DownloadLink(context.Background(), validUUIDv4, time.Hour, "")
+	assert.EqualError(t, err, "Searching for image file version: error")
+
+	// exported for the device download links
+	version, err := iModel.FileVersion(context.Background(), validUUIDv4)
+	assert.EqualError(t, err, "error")
+	assert.Equal(t, "", version)
+
+	fakeFS.objectVersionError = ErrFileStorageFileNotFound
+	version, err = iModel.FileVersion(context.Background(), validUUIDv4)
+	assert.NoError(t, err)
+	assert.Equal(t, "", version)
+
+	fakeIS.findByIdImage.FileVersion = &recorded
+	version, err = iModel.FileVersion(context.Background(), validUUIDv4)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", version)
+
+	fakeIS.findByIdImage = nil
+	version, err = iModel.FileVersion(context.Background(), validUUIDv4)
+	assert.NoError(t, err)
+	assert.Equal(t, "", version)
+}
+
+func TestSetImageState(t *testing.T) {
+	fakeIS := new(FakeImageStorage)
+	iModel := NewImagesModel(new(FakeFileStorage), new(FakeUseChecker), fakeIS, nil, nil)
+
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Subject: "user-1", Tenant: "tenant-1"})
+
+	// searching for image error
+	fakeIS.findByIdError = errors.New("error")
+	err := iModel.SetImageState(ctx, validUUIDv4, images.ImageStateApproved)
+	assert.Error(t, err)
+
+	// image does not exist
+	fakeIS.findByIdError = nil
+	err = iModel.SetImageState(ctx, validUUIDv4, images.ImageStateApproved)
+	assert.Equal(t, controller.ErrImageMetaNotFound, err)
+
+	// pending image
+	fakeIS.findByIdImage = &images.SoftwareImage{
+		Id:     validUUIDv4,
+		Status: images.ImageStatusPending,
+	}
+	err = iModel.SetImageState(ctx, validUUIDv4, images.ImageStateApproved)
+	assert.Equal(t, controller.ErrModelImagePending, err)
+
+	// image recorded before the states can't be moved back to uploaded
+	fakeIS.findByIdImage = &images.SoftwareImage{Id: validUUIDv4}
+	err = iModel.SetImageState(ctx, validUUIDv4, images.ImageStateUploaded)
+	assert.Equal(t, controller.ErrModelInvalidStateTransition, err)
+
+	// storage error
+	fakeIS.setStateError = errors.New("error")
+	err = iModel.SetImageState(ctx, validUUIDv4, images.ImageStateApproved)
+	assert.Error(t, err)
+
+	// approved
+	fakeIS.setStateError = nil
+	err = iModel.SetImageState(ctx, validUUIDv4, images.ImageStateApproved)
+	assert.NoError(t, err)
+	assert.Equal(t, images.ImageStateApproved, fakeIS.findByIdImage.State)
+	assert.Equal(t, "user-1", fakeIS.findByIdImage.StateModifiedBy)
+	assert.NotNil(t, fakeIS.findByIdImage.StateModified)
+
+	// approved again
+	err = iModel.SetImageState(ctx, validUUIDv4, images.ImageStateApproved)
+	assert.Equal(t, controller.ErrModelInvalidStateTransition, err)
+
+	// deprecated
+	err = iModel.SetImageState(ctx, validUUIDv4, images.ImageStateDeprecated)
+	assert.NoError(t, err)
+	assert.Equal(t, images.ImageStateDeprecated, fakeIS.findByIdImage.State)
+
+	// deprecated images can't be approved again without a review
+	err = iModel.SetImageState(ctx, validUUIDv4, images.ImageStateApproved)
+	assert.Equal(t, controller.ErrModelInvalidStateTransition, err)
+
+	// no longer deprecated
+	err = iModel.SetImageState(ctx, validUUIDv4, images.ImageStateUploaded)
+	assert.NoError(t, err)
+	assert.Equal(t, images.ImageStateUploaded, fakeIS.findByIdImage.State)
 }
 
 func MakeFakeUpdate(data string) (string, error) {
@@ -581,3 +2237,104 @@ func MakeRootfsImageArtifact(version int, signed bool) (*bytes.Buffer, error) {
 	}
 	return art, nil
 }
+
+func TestListInstallableImages(t *testing.T) {
+	newImage := func(id, name string, delta *images.DeltaUpdate,
+		deviceTypes ...string) *images.SoftwareImage {
+		return &images.SoftwareImage{
+			Id: id,
+			SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+				Name:                  name,
+				DeviceTypesCompatible: deviceTypes,
+			},
+			Delta: delta,
+		}
+	}
+
+	pending := newImage("full-v5", "v5", nil, "rpi")
+	pending.Status = images.ImageStatusPending
+
+	candidates := []*images.SoftwareImage{
+		newImage("full-v1", "v1", nil, "rpi"),
+		newImage("full-v2", "v2", nil, "rpi", "bbb"),
+		newImage("delta-v1-v2", "v2", &images.DeltaUpdate{From: "v1", To: "v2"}, "rpi"),
+		newImage("delta-v2-v3", "v3", &images.DeltaUpdate{From: "v2", To: "v3"}, "rpi"),
+		newImage("delta-v3-v4", "v4", &images.DeltaUpdate{From: "v3", To: "v4"}, "rpi"),
+		newImage("delta-x-y", "y", &images.DeltaUpdate{From: "x", To: "y"}, "rpi"),
+		newImage("full-other", "other", nil, "bbb"),
+		pending,
+	}
+
+	testCases := map[string]struct {
+		provides images.Provides
+
+		// paths by artifact ID
+		steps map[string][]string
+	}{
+		"oldest installed": {
+			provides: images.Provides{
+				images.ProvidesArtifactName: "v1",
+				images.ProvidesDeviceType:   "rpi",
+			},
+			steps: map[string][]string{
+				"full-v2":     {},
+				"delta-v1-v2": {},
+				"delta-v2-v3": {"full-v2"},
+				"delta-v3-v4": {"full-v2", "delta-v2-v3"},
+			},
+		},
+		"newer installed": {
+			provides: images.Provides{
+				images.ProvidesArtifactName: "v3",
+				images.ProvidesDeviceType:   "RPi",
+			},
+			steps: map[string][]string{
+				"full-v1":     {},
+				"full-v2":     {},
+				"delta-v3-v4": {},
+				"delta-v1-v2": {"full-v1"},
+			},
+		},
+		"nothing installed": {
+			provides: images.Provides{
+				images.ProvidesDeviceType: "bbb",
+			},
+			steps: map[string][]string{
+				"full-v2":    {},
+				"full-other": {},
+			},
+		},
+		"unknown device type": {
+			provides: images.Provides{
+				images.ProvidesArtifactName: "v1",
+				images.ProvidesDeviceType:   "qemu",
+			},
+			steps: map[string][]string{},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fakeIS := &FakeImageStorage{findAllImages: candidates}
+			iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
+
+			steps, err := iModel.ListInstallableImages(context.Background(), tc.provides)
+			assert.NoError(t, err)
+
+			paths := make(map[string][]string)
+			for _, step := range steps {
+				paths[step.Artifact.Id] = step.Path
+			}
+			assert.Equal(t, tc.steps, paths)
+			assert.Equal(t, images.NormalizeDeviceType(tc.provides[images.ProvidesDeviceType]),
+				fakeIS.filter.DeviceType)
+		})
+	}
+
+	fakeIS := &FakeImageStorage{findAllError: errors.New("db error")}
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
+	_, err := iModel.ListInstallableImages(context.Background(), images.Provides{
+		images.ProvidesDeviceType: "rpi",
+	})
+	assert.EqualError(t, err, "Searching for image metadata: db error")
+}
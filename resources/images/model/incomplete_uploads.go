@@ -0,0 +1,159 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+// IncompleteUploadsModel lets the operators inspect and remove the uploads
+// which were never finished: upload sessions, along with the chunks or the
+// file stored so far, and pending images, which file was never uploaded.
+// Expired upload sessions are removed automatically too, this covers
+// the ones not expired yet and the pending images, which never expire.
+type IncompleteUploadsModel struct {
+	uploads       *UploadsModel
+	images        ImageRemover
+	imagesStorage SoftwareImagesStorage
+	tenants       TenantsLister
+}
+
+// NewIncompleteUploadsModel creates the model, uploads of all the tenants
+// are listed and purged.
+func NewIncompleteUploadsModel(
+	uploads *UploadsModel,
+	images ImageRemover,
+	imagesStorage SoftwareImagesStorage,
+	tenants TenantsLister,
+) *IncompleteUploadsModel {
+	return &IncompleteUploadsModel{
+		uploads:       uploads,
+		images:        images,
+		imagesStorage: imagesStorage,
+		tenants:       tenants,
+	}
+}
+
+// ListIncompleteUploads lists the incomplete uploads of all the tenants
+// started more than olderThan ago.
+func (m *IncompleteUploadsModel) ListIncompleteUploads(ctx context.Context,
+	olderThan time.Duration) ([]*images.IncompleteUpload, error) {
+
+	now := time.Now()
+	uploads := []*images.IncompleteUpload{}
+	err := m.forEachIncomplete(ctx, now.Add(-olderThan),
+		func(tenantCtx context.Context, tenant string,
+			sessions []*images.UploadSession, pending []*images.SoftwareImage) error {
+
+			for _, session := range sessions {
+				uploads = append(uploads, images.NewIncompleteUpload(tenant, session, now))
+			}
+			for _, image := range pending {
+				uploads = append(uploads, images.NewPendingUpload(tenant, image, now))
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
+
+// PurgeIncompleteUploads removes the incomplete uploads of all the tenants
+// started more than olderThan ago, along with the data stored so far.
+// Failures are only logged and reported, purge can be retried.
+func (m *IncompleteUploadsModel) PurgeIncompleteUploads(ctx context.Context,
+	olderThan time.Duration) (*images.IncompleteUploadsReport, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "IncompleteUploadsModel.PurgeIncompleteUploads")
+	defer span.End()
+
+	l := log.FromContext(ctx)
+
+	now := time.Now()
+	report := &images.IncompleteUploadsReport{
+		Purged: []*images.IncompleteUpload{},
+		Failed: []*images.IncompleteUpload{},
+	}
+	err := m.forEachIncomplete(ctx, now.Add(-olderThan),
+		func(tenantCtx context.Context, tenant string,
+			sessions []*images.UploadSession, pending []*images.SoftwareImage) error {
+
+			for _, session := range sessions {
+				upload := images.NewIncompleteUpload(tenant, session, now)
+				if err := m.uploads.deleteSession(tenantCtx, session); err != nil {
+					l.F(log.Ctx{"tenant": tenant, "upload_id": session.Id,
+						"error": err.Error()}).Error("failed to purge upload session")
+					report.Failed = append(report.Failed, upload)
+					continue
+				}
+				report.Purged = append(report.Purged, upload)
+			}
+
+			for _, image := range pending {
+				upload := images.NewPendingUpload(tenant, image, now)
+				err := m.images.DeleteImage(tenantCtx, image.Id)
+				switch errors.Cause(err) {
+				case nil, controller.ErrImageMetaNotFound:
+					// removed in the meantime counts as purged
+					report.Purged = append(report.Purged, upload)
+				default:
+					l.F(log.Ctx{"tenant": tenant, "image_id": image.Id,
+						"error": err.Error()}).Error("failed to purge pending image")
+					report.Failed = append(report.Failed, upload)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+
+	span.SetAttribute("purged", len(report.Purged))
+	span.SetAttribute("failed", len(report.Failed))
+
+	return report, nil
+}
+
+// forEachIncomplete calls f with the upload sessions and the pending images
+// of each tenant started before the given time.
+func (m *IncompleteUploadsModel) forEachIncomplete(ctx context.Context, before time.Time,
+	f func(ctx context.Context, tenant string,
+		sessions []*images.UploadSession, pending []*images.SoftwareImage) error) error {
+
+	return forEachTenant(ctx, m.tenants, func(tenantCtx context.Context, tenant string) error {
+		sessions, err := m.uploads.uploadsStorage.FindCreatedBefore(tenantCtx, before)
+		if err != nil {
+			return errors.Wrapf(err, "Searching for upload sessions of tenant '%s'", tenant)
+		}
+
+		pending, err := m.imagesStorage.FindPending(tenantCtx, before)
+		if err != nil {
+			return errors.Wrapf(err, "Searching for pending images of tenant '%s'", tenant)
+		}
+
+		return f(tenantCtx, tenant, sessions, pending)
+	})
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+func TestListIncompleteUploads(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	recent := now.Add(-time.Minute)
+
+	uploads := NewFakeUploadSessionsStorage()
+	uploads.sessions["1"] = &images.UploadSession{
+		UploadSessionConstructor: images.UploadSessionConstructor{Size: 100},
+		Id:                       "1",
+		Offset:                   40,
+		Created:                  old,
+	}
+	uploads.sessions["2"] = &images.UploadSession{Id: "2", Created: recent}
+	fakeIS := &FakeImageStorage{
+		pendingImages: []*images.SoftwareImage{{Id: "3", Created: &old}},
+	}
+
+	model := NewIncompleteUploadsModel(
+		NewUploadsModel(&FakeFileStorage{}, uploads, &FakeImageCreator{}),
+		&FakeImageRemover{}, fakeIS, &FakeTenantsLister{tenants: []string{"foo"}})
+
+	listed, err := model.ListIncompleteUploads(context.Background(), 24*time.Hour)
+	assert.NoError(t, err)
+	if assert.Len(t, listed, 2) {
+		assert.Equal(t, "1", listed[0].Id)
+		assert.Equal(t, "foo", listed[0].Tenant)
+		assert.Equal(t, images.IncompleteUploadChunked, listed[0].Kind)
+		assert.Equal(t, int64(100), listed[0].Size)
+		assert.Equal(t, int64(40), listed[0].Received)
+		assert.True(t, listed[0].Age >= int64(48*time.Hour/time.Second))
+
+		assert.Equal(t, "3", listed[1].Id)
+		assert.Equal(t, images.IncompleteUploadPending, listed[1].Kind)
+	}
+
+	fakeIS.findPendingError = errors.New("db error")
+	_, err = model.ListIncompleteUploads(context.Background(), 24*time.Hour)
+	assert.EqualError(t, err,
+		"Searching for pending images of tenant 'foo': db error")
+}
+
+func TestPurgeIncompleteUploads(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+
+	files := &FakeFileStorage{objects: map[string][]byte{
+		"chunk-1": []byte("foo"),
+	}}
+	uploads := NewFakeUploadSessionsStorage()
+	uploads.sessions["1"] = &images.UploadSession{
+		Id:      "1",
+		Parts:   []images.UploadPart{{Size: 3, ObjectID: "chunk-1"}},
+		Created: old,
+	}
+	fakeIS := &FakeImageStorage{
+		pendingImages: []*images.SoftwareImage{
+			{Id: "2", Created: &old}, {Id: "3", Created: &old},
+		},
+	}
+	remover := &FakeImageRemover{
+		errs: map[string]error{"3": errors.New("storage error")},
+	}
+
+	model := NewIncompleteUploadsModel(
+		NewUploadsModel(files, uploads, &FakeImageCreator{}),
+		remover, fakeIS, &FakeTenantsLister{})
+
+	report, err := model.PurgeIncompleteUploads(context.Background(), time.Hour)
+	assert.NoError(t, err)
+	if assert.Len(t, report.Purged, 2) {
+		assert.Equal(t, "1", report.Purged[0].Id)
+		assert.Equal(t, "2", report.Purged[1].Id)
+	}
+	if assert.Len(t, report.Failed, 1) {
+		assert.Equal(t, "3", report.Failed[0].Id)
+	}
+
+	// chunks are removed along with the session
+	assert.Empty(t, uploads.sessions)
+	assert.Empty(t, files.objects)
+	assert.Equal(t, map[string][]string{"": {"2"}}, remover.removed)
+}
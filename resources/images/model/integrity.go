@@ -0,0 +1,287 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+// Reasons of flagging the artifact as corrupted
+const (
+	IntegrityReasonFileNotFound     = "artifact file not found"
+	IntegrityReasonChecksumMismatch = "payload checksums do not match the recorded ones"
+
+	IntegrityReasonFileChecksumMismatch = "file checksums do not match the recorded ones"
+)
+
+// TenantsLister lists the tenants having their own storage
+type TenantsLister interface {
+	ListTenants(ctx context.Context) ([]string, error)
+}
+
+// forEachTenant calls f with the context of each tenant having its own
+// storage, or with the context as is in single tenant setup.
+func forEachTenant(ctx context.Context, tenants TenantsLister,
+	f func(ctx context.Context, tenant string) error) error {
+
+	list, err := tenants.ListTenants(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Listing tenants")
+	}
+
+	// single tenant setup
+	if len(list) == 0 {
+		list = []string{""}
+	}
+
+	for _, tenant := range list {
+		tenantCtx := ctx
+		if tenant != "" {
+			tenantCtx = identity.WithContext(ctx, &identity.Identity{Tenant: tenant})
+		}
+
+		if err := f(tenantCtx, tenant); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IntegrityModel re-verifies the stored artifact files.
+//
+// Files are compared to the checksums of the whole file recorded with
+// the image. Files of the images uploaded before these were recorded
+// are parsed again instead: the artifact reader verifies the payloads
+// against the checksums from the artifact manifest, which in turn are
+// compared to the checksums recorded with the image.
+type IntegrityModel struct {
+	fileStorage   FileStorage
+	imagesStorage SoftwareImagesStorage
+	tenants       TenantsLister
+	interval      time.Duration
+	throttle      *throttle
+
+	scheduled chan struct{}
+}
+
+// NewIntegrityModel creates the model. Verification of all the tenants is run
+// every interval by Run, interval of 0 disables the periodic runs.
+func NewIntegrityModel(
+	fileStorage FileStorage,
+	imagesStorage SoftwareImagesStorage,
+	tenants TenantsLister,
+	interval time.Duration,
+) *IntegrityModel {
+	return &IntegrityModel{
+		fileStorage:   fileStorage,
+		imagesStorage: imagesStorage,
+		tenants:       tenants,
+		interval:      interval,
+		throttle:      &throttle{rate: DefaultChecksumsRate},
+		scheduled:     make(chan struct{}, 1),
+	}
+}
+
+// VerifyImages verifies all the images of the tenant from the context
+// and records the results with the images.
+// Images which could not be verified due to storage errors are skipped,
+// pending images having no files yet are not verified at all.
+func (m *IntegrityModel) VerifyImages(ctx context.Context) (*images.IntegrityReport, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "IntegrityModel.VerifyImages")
+	defer span.End()
+
+	l := log.FromContext(ctx)
+
+	imageList, err := m.imagesStorage.FindAll(ctx)
+	if err != nil {
+		span.SetError(err)
+		return nil, errors.Wrap(err, "Searching for image metadata")
+	}
+
+	report := &images.IntegrityReport{
+		Corrupted: []string{},
+	}
+
+	for _, image := range imageList {
+		if image.IsPending() {
+			continue
+		}
+		integrity, err := m.verifyImage(ctx, image)
+		if err == nil {
+			_, err = m.imagesStorage.SetIntegrity(ctx, image.Id, integrity)
+		}
+		if err != nil {
+			l.F(log.Ctx{"image_id": image.Id, "error": err.Error()}).
+				Error("failed to verify image integrity")
+			report.Skipped++
+			continue
+		}
+
+		report.Checked++
+		if integrity.Corrupted {
+			l.F(log.Ctx{
+				"image_id": image.Id,
+				"reason":   integrity.Reason,
+			}).Warn("image file is corrupted")
+			report.Corrupted = append(report.Corrupted, image.Id)
+		}
+	}
+
+	span.SetAttribute("checked", report.Checked)
+	span.SetAttribute("corrupted", len(report.Corrupted))
+
+	return report, nil
+}
+
+// verifyImage checks the stored image file.
+// Returns error only if the file could not be verified.
+func (m *IntegrityModel) verifyImage(ctx context.Context,
+	image *images.SoftwareImage) (*images.ArtifactIntegrity, error) {
+
+	now := time.Now()
+	integrity := &images.ArtifactIntegrity{
+		Checked: &now,
+	}
+
+	if recorded := recordedChecksums(image); len(recorded) > 0 {
+		computed, err := m.computeChecksums(ctx, image)
+		switch errors.Cause(err) {
+		case nil:
+		case ErrFileStorageFileNotFound:
+			integrity.Corrupted = true
+			integrity.Reason = IntegrityReasonFileNotFound
+			return integrity, nil
+		default:
+			return nil, err
+		}
+
+		if !recorded.Matches(computed) {
+			integrity.Corrupted = true
+			integrity.Reason = IntegrityReasonFileChecksumMismatch
+		}
+		return integrity, nil
+	}
+
+	file, err := m.fileStorage.GetObject(ctx,
+		image.FileObjectKey(tenantFromContext(ctx)))
+	switch err {
+	case nil:
+	case ErrFileStorageFileNotFound:
+		integrity.Corrupted = true
+		integrity.Reason = IntegrityReasonFileNotFound
+		return integrity, nil
+	default:
+		return nil, errors.Wrap(err, "Fetching image file")
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	meta, err := getMetaFromArchive(&r, nil)
+	if err != nil {
+		integrity.Corrupted = true
+		integrity.Reason = err.Error()
+		return integrity, nil
+	}
+
+	if !sameUpdateChecksums(image.Updates, meta.Updates) {
+		integrity.Corrupted = true
+		integrity.Reason = IntegrityReasonChecksumMismatch
+	}
+
+	return integrity, nil
+}
+
+func sameUpdateChecksums(recorded, actual []images.Update) bool {
+	if len(recorded) != len(actual) {
+		return false
+	}
+	for i := range recorded {
+		if len(recorded[i].Files) != len(actual[i].Files) {
+			return false
+		}
+		for j := range recorded[i].Files {
+			if recorded[i].Files[j].Checksum != actual[i].Files[j].Checksum {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// VerifyAllImages verifies the images of all the tenants.
+func (m *IntegrityModel) VerifyAllImages(ctx context.Context) error {
+	return forEachTenant(ctx, m.tenants, func(tenantCtx context.Context, tenant string) error {
+		report, err := m.VerifyImages(tenantCtx)
+		if err != nil {
+			return errors.Wrapf(err, "Verifying images of tenant '%s'", tenant)
+		}
+
+		log.FromContext(ctx).F(log.Ctx{
+			"tenant_id": tenant,
+			"checked":   report.Checked,
+			"skipped":   report.Skipped,
+			"corrupted": len(report.Corrupted),
+		}).Info("image integrity verified")
+		return nil
+	})
+}
+
+// ScheduleVerification requests verification of all the tenants to be run
+// by Run as soon as possible.
+// Returns false if the verification is already scheduled.
+func (m *IntegrityModel) ScheduleVerification() bool {
+	select {
+	case m.scheduled <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run verifies images of all the tenants periodically and on request,
+// until the context is cancelled.
+func (m *IntegrityModel) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if m.interval > 0 {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-m.scheduled:
+		}
+
+		if err := m.VerifyAllImages(ctx); err != nil {
+			log.FromContext(ctx).F(log.Ctx{"error": err.Error()}).
+				Error("image integrity verification failed")
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+func makeStoredImage(t *testing.T, id string) (*images.SoftwareImage, []byte) {
+	art, err := MakeRootfsImageArtifact(2, false)
+	assert.NoError(t, err)
+	data := art.Bytes()
+
+	var r io.Reader = art
+	meta, err := getMetaFromArchive(&r, nil)
+	assert.NoError(t, err)
+
+	return images.NewSoftwareImage(id, createValidImageMeta(), meta), data
+}
+
+func TestVerifyImages(t *testing.T) {
+	valid, validData := makeStoredImage(t, "valid")
+	missing, _ := makeStoredImage(t, "missing")
+	truncated, truncatedData := makeStoredImage(t, "truncated")
+	mismatch, mismatchData := makeStoredImage(t, "mismatch")
+	mismatch.Updates[0].Files[0].Checksum = "0000"
+
+	fakeFS := &FakeFileStorage{objects: map[string][]byte{
+		valid.Id:     validData,
+		truncated.Id: truncatedData[:len(truncatedData)/2],
+		mismatch.Id:  mismatchData,
+	}}
+	fakeIS := &FakeImageStorage{
+		findAllImages: []*images.SoftwareImage{valid, missing, truncated, mismatch},
+		integrity:     map[string]*images.ArtifactIntegrity{},
+	}
+
+	model := NewIntegrityModel(fakeFS, fakeIS, nil, 0)
+
+	report, err := model.VerifyImages(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &images.IntegrityReport{
+		Checked:   4,
+		Corrupted: []string{missing.Id, truncated.Id, mismatch.Id},
+	}, report)
+
+	assert.False(t, fakeIS.integrity[valid.Id].Corrupted)
+	assert.NotNil(t, fakeIS.integrity[valid.Id].Checked)
+	assert.Equal(t, IntegrityReasonFileNotFound, fakeIS.integrity[missing.Id].Reason)
+	assert.True(t, fakeIS.integrity[truncated.Id].Corrupted)
+	assert.NotEmpty(t, fakeIS.integrity[truncated.Id].Reason)
+	assert.Equal(t, IntegrityReasonChecksumMismatch, fakeIS.integrity[mismatch.Id].Reason)
+
+	// storage errors skip the image
+	fakeFS.getObjectError = errors.New("connection reset")
+	report, err = model.VerifyImages(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Checked)
+	assert.Equal(t, 4, report.Skipped)
+	fakeFS.getObjectError = nil
+
+	fakeIS.setIntegrityError = errors.New("db error")
+	report, err = model.VerifyImages(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, report.Skipped)
+
+	fakeIS.findAllError = errors.New("db error")
+	_, err = model.VerifyImages(context.Background())
+	assert.Error(t, err)
+}
+
+func TestVerifyImagesFileChecksums(t *testing.T) {
+	valid, validData := makeStoredImage(t, "valid")
+	valid.Checksums = computeTestChecksums(validData)
+	// recorded as the checksum only
+	legacy, legacyData := makeStoredImage(t, "legacy")
+	legacy.Checksum = computeTestChecksums(legacyData)[images.ChecksumSHA256]
+	missing, missingData := makeStoredImage(t, "missing")
+	missing.Checksums = computeTestChecksums(missingData)
+	// payloads still match, the file does not
+	mismatch, mismatchData := makeStoredImage(t, "mismatch")
+	mismatch.Checksums = computeTestChecksums(append(mismatchData, 0))
+
+	fakeFS := &FakeFileStorage{objects: map[string][]byte{
+		valid.Id:    validData,
+		legacy.Id:   legacyData,
+		mismatch.Id: mismatchData,
+	}}
+	fakeIS := &FakeImageStorage{
+		findAllImages: []*images.SoftwareImage{valid, legacy, missing, mismatch},
+		integrity:     map[string]*images.ArtifactIntegrity{},
+	}
+
+	model := NewIntegrityModel(fakeFS, fakeIS, nil, 0)
+
+	report, err := model.VerifyImages(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &images.IntegrityReport{
+		Checked:   4,
+		Corrupted: []string{missing.Id, mismatch.Id},
+	}, report)
+
+	assert.False(t, fakeIS.integrity[valid.Id].Corrupted)
+	assert.False(t, fakeIS.integrity[legacy.Id].Corrupted)
+	assert.Equal(t, IntegrityReasonFileNotFound, fakeIS.integrity[missing.Id].Reason)
+	assert.Equal(t, IntegrityReasonFileChecksumMismatch, fakeIS.integrity[mismatch.Id].Reason)
+
+	fakeFS.getObjectError = errors.New("connection reset")
+	report, err = model.VerifyImages(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, report.Skipped)
+}
+
+func computeTestChecksums(data []byte) images.Checksums {
+	w := images.NewChecksumsWriter()
+	w.Write(data)
+	return w.Checksums()
+}
+
+type FakeTenantsLister struct {
+	tenants []string
+	err     error
+}
+
+func (ftl *FakeTenantsLister) ListTenants(ctx context.Context) ([]string, error) {
+	return ftl.tenants, ftl.err
+}
+
+// tenantRecordingStorage records the tenants the images were listed for
+type tenantRecordingStorage struct {
+	*FakeImageStorage
+	tenants []string
+}
+
+func (s *tenantRecordingStorage) FindAll(ctx context.Context) ([]*images.SoftwareImage, error) {
+	tenant := ""
+	if id := identity.FromContext(ctx); id != nil {
+		tenant = id.Tenant
+	}
+	s.tenants = append(s.tenants, tenant)
+	return s.FakeImageStorage.FindAll(ctx)
+}
+
+func TestVerifyAllImages(t *testing.T) {
+	testCases := []struct {
+		tenants    []string
+		listErr    error
+		findAllErr error
+
+		verified []string
+		err      bool
+	}{
+		{
+			tenants:  []string{},
+			verified: []string{""},
+		},
+		{
+			tenants:  []string{"foo", "bar"},
+			verified: []string{"foo", "bar"},
+		},
+		{
+			listErr: errors.New("db error"),
+			err:     true,
+		},
+		{
+			tenants:    []string{"foo", "bar"},
+			findAllErr: errors.New("db error"),
+			verified:   []string{"foo"},
+			err:        true,
+		},
+	}
+
+	for _, tc := range testCases {
+		storage := &tenantRecordingStorage{
+			FakeImageStorage: &FakeImageStorage{findAllError: tc.findAllErr},
+		}
+		model := NewIntegrityModel(&FakeFileStorage{}, storage,
+			&FakeTenantsLister{tenants: tc.tenants, err: tc.listErr}, 0)
+
+		err := model.VerifyAllImages(context.Background())
+		if tc.err {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, tc.verified, storage.tenants)
+	}
+}
+
+func TestScheduleVerification(t *testing.T) {
+	storage := &tenantRecordingStorage{FakeImageStorage: &FakeImageStorage{}}
+	model := NewIntegrityModel(&FakeFileStorage{}, storage, &FakeTenantsLister{}, 0)
+
+	assert.True(t, model.ScheduleVerification())
+	assert.False(t, model.ScheduleVerification())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		model.Run(ctx)
+		close(done)
+	}()
+
+	// scheduled run is picked up, so the next one can be scheduled
+	scheduled := false
+	for i := 0; i < 100 && !scheduled; i++ {
+		time.Sleep(10 * time.Millisecond)
+		scheduled = model.ScheduleVerification()
+	}
+	assert.True(t, scheduled)
+
+	cancel()
+	<-done
+}
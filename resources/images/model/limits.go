@@ -0,0 +1,85 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/resources/limits"
+)
+
+// LimitGetter returns the limits of the tenant from the context,
+// value of 0 means no limit.
+type LimitGetter interface {
+	GetLimit(ctx context.Context, name string) (*limits.Limit, error)
+}
+
+// SetLimits sets the source of the limits of the tenants, enforced when
+// the images are created; no limits by default.
+func (i *ImagesModel) SetLimits(getter LimitGetter) {
+	i.limits = getter
+}
+
+// reserveArtifact counts one more image in the usage of the tenant ahead of
+// storing it, failing with controller.ArtifactsLimitError if the tenant
+// can't have one more image. The limit is checked and the image counted
+// at once, so concurrent uploads can't overshoot the limit.
+// The reservation has to be released with releaseArtifact if the image
+// is not stored in the end.
+func (i *ImagesModel) reserveArtifact(ctx context.Context) error {
+	var limit uint64
+	if i.limits != nil {
+		l, err := i.limits.GetLimit(ctx, limits.LimitArtifacts)
+		if err != nil {
+			return errors.Wrap(err, "Getting artifacts limit")
+		}
+		limit = l.Value
+	}
+	if limit == 0 {
+		i.countUsage(ctx, 1, 0)
+		return nil
+	}
+
+	reserved, err := i.imagesStorage.ReserveArtifact(ctx, limit)
+	if err != nil {
+		return errors.Wrap(err, "Reserving artifact")
+	}
+	if reserved {
+		return nil
+	}
+
+	usage, err := i.imagesStorage.GetUsage(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Getting usage")
+	}
+
+	var count uint64
+	// drift is corrected by the reconciliation
+	if usage.Artifacts > 0 {
+		count = uint64(usage.Artifacts)
+	}
+	return &controller.ArtifactsLimitError{
+		Count: count,
+		Limit: limit,
+	}
+}
+
+// releaseArtifact releases the reservation of the image not stored.
+func (i *ImagesModel) releaseArtifact(ctx context.Context) {
+	i.countUsage(ctx, -1, 0)
+}
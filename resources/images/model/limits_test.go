@@ -0,0 +1,172 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/resources/limits"
+)
+
+type fakeLimitGetter struct {
+	limit uint64
+	err   error
+}
+
+func (f *fakeLimitGetter) GetLimit(ctx context.Context, name string) (*limits.Limit, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &limits.Limit{Name: name, Value: f.limit}, nil
+}
+
+func TestCreateImageArtifactsLimit(t *testing.T) {
+	testCases := map[string]struct {
+		limits     *fakeLimitGetter
+		usage      images.Usage
+		usageError error
+		notUnique  bool
+
+		created bool
+		err     error
+	}{
+		"no limits": {
+			usage:   images.Usage{Artifacts: 10},
+			created: true,
+		},
+		"unlimited": {
+			limits:  &fakeLimitGetter{},
+			usage:   images.Usage{Artifacts: 10},
+			created: true,
+		},
+		"below limit": {
+			limits:  &fakeLimitGetter{limit: 10},
+			usage:   images.Usage{Artifacts: 9},
+			created: true,
+		},
+		"negative drift": {
+			limits:  &fakeLimitGetter{limit: 1},
+			usage:   images.Usage{Artifacts: -1},
+			created: true,
+		},
+		"limit reached": {
+			limits: &fakeLimitGetter{limit: 10},
+			usage:  images.Usage{Artifacts: 10},
+			err:    &controller.ArtifactsLimitError{Count: 10, Limit: 10},
+		},
+		"limit exceeded": {
+			limits: &fakeLimitGetter{limit: 10},
+			usage:  images.Usage{Artifacts: 12},
+			err:    &controller.ArtifactsLimitError{Count: 12, Limit: 10},
+		},
+		"limit error": {
+			limits: &fakeLimitGetter{err: errors.New("db error")},
+			err:    errors.New("Getting artifacts limit: db error"),
+		},
+		"usage error": {
+			limits:     &fakeLimitGetter{limit: 10},
+			usageError: errors.New("db error"),
+			err:        errors.New("Reserving artifact: db error"),
+		},
+		"reservation released": {
+			limits:    &fakeLimitGetter{limit: 10},
+			usage:     images.Usage{Artifacts: 9},
+			notUnique: true,
+			err:       controller.ErrModelArtifactNotUnique,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fakeIS := new(FakeImageStorage)
+			fakeIS.isArtifactUnique = !tc.notUnique
+			fakeIS.usage = tc.usage
+			fakeIS.usageError = tc.usageError
+
+			iModel := NewImagesModel(new(FakeFileStorage), nil, fakeIS, nil, nil)
+			if tc.limits != nil {
+				iModel.SetLimits(tc.limits)
+			}
+
+			upd, err := MakeRootfsImageArtifact(1, false)
+			assert.NoError(t, err)
+
+			_, err = iModel.CreateImage(context.Background(),
+				&controller.MultipartUploadMsg{
+					MetaConstructor: createValidImageMeta(),
+					ArtifactSize:    int64(upd.Len()),
+					ArtifactReader:  upd,
+				})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				if limitErr, ok := tc.err.(*controller.ArtifactsLimitError); ok {
+					assert.Equal(t, limitErr, pkgerrors.Cause(err))
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.created, fakeIS.inserted != nil)
+			if tc.created {
+				assert.Equal(t, tc.usage.Artifacts+1, fakeIS.usage.Artifacts)
+			} else if tc.usageError == nil {
+				assert.Equal(t, tc.usage.Artifacts, fakeIS.usage.Artifacts)
+			}
+		})
+	}
+}
+
+func TestCreatePendingImageArtifactsLimit(t *testing.T) {
+	fakeIS := new(FakeImageStorage)
+	fakeIS.isArtifactUnique = true
+	fakeIS.usage = images.Usage{Artifacts: 3}
+
+	iModel := NewImagesModel(new(FakeFileStorage), nil, fakeIS, nil, nil)
+	iModel.SetLimits(&fakeLimitGetter{limit: 3})
+
+	_, err := iModel.CreatePendingImage(context.Background(),
+		&images.SoftwareImagePending{
+			Name:                  "mender-1.1",
+			DeviceTypesCompatible: []string{"vexpress-qemu"},
+		})
+	assert.Equal(t, &controller.ArtifactsLimitError{Count: 3, Limit: 3}, err)
+	assert.Nil(t, fakeIS.inserted)
+
+	// reservation of the image not stored is released
+	fakeIS.usage = images.Usage{Artifacts: 2}
+	fakeIS.insertError = errors.New("db error")
+	_, err = iModel.CreatePendingImage(context.Background(),
+		&images.SoftwareImagePending{
+			Name:                  "mender-1.1",
+			DeviceTypesCompatible: []string{"vexpress-qemu"},
+		})
+	assert.Error(t, err)
+	assert.Equal(t, int64(2), fakeIS.usage.Artifacts)
+
+	fakeIS.insertError = nil
+	_, err = iModel.CreatePendingImage(context.Background(),
+		&images.SoftwareImagePending{
+			Name:                  "mender-1.1",
+			DeviceTypesCompatible: []string{"vexpress-qemu"},
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), fakeIS.usage.Artifacts)
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+
+package model
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+// SetReplicas configures the file storages of the regions the image files
+// are replicated to, by region name; no replication by default.
+// The primary file storage stays the source of truth of the image files.
+func (i *ImagesModel) SetReplicas(replicas map[string]FileStorage) {
+	i.replicas = replicas
+}
+
+// replicateImage copies the image file to all the replicas in the background,
+// so that the upload is not delayed. Each region is recorded with the image
+// once the copy is complete; until then the primary file is served instead.
+// Failures are only logged.
+func (i *ImagesModel) replicateImage(ctx context.Context, imageID string) {
+	if len(i.replicas) == 0 {
+		return
+	}
+
+	l := log.FromContext(ctx).F(log.Ctx{"image_id": imageID})
+
+	// replication outlives the request, keep the tenant and the logger only
+	replicateCtx := log.WithContext(context.Background(), l)
+	if id := identity.FromContext(ctx); id != nil {
+		replicateCtx = identity.WithContext(replicateCtx, id)
+	}
+
+	go func() {
+		image, err := i.imagesStorage.FindByID(replicateCtx, imageID)
+		if err != nil {
+			l.F(log.Ctx{"error": err.Error()}).Error("failed to find image to replicate")
+			return
+		}
+		if image == nil {
+			l.Error("image to replicate not found")
+			return
+		}
+
+		for region, replica := range i.replicas {
+			lr := l.F(log.Ctx{"region": region})
+			if err := i.replicateFile(replicateCtx, image, replica); err != nil {
+				lr.F(log.Ctx{"error": err.Error()}).Error("failed to replicate image file")
+				continue
+			}
+			if err := i.imagesStorage.AddReplica(replicateCtx, imageID,
+				region); err != nil {
+				lr.F(log.Ctx{"error": err.Error()}).Error("failed to record image replica")
+			}
+		}
+	}()
+}
+
+// replicateFile streams the image file from the primary file storage
+// to the replica, under the same key.
+func (i *ImagesModel) replicateFile(ctx context.Context,
+	image *images.SoftwareImage, replica FileStorage) error {
+
+	objectKey := image.FileObjectKey(tenantFromContext(ctx))
+
+	file, err := i.fileStorage.GetObject(ctx, objectKey)
+	if err != nil {
+		return errors.Wrap(err, "opening image file")
+	}
+	defer file.Close()
+
+	if err := replica.UploadArtifact(ctx, objectKey, image.Size, file,
+		ArtifactContentType); err != nil {
+		return errors.Wrap(err, "uploading image file")
+	}
+	return nil
+}
+
+// imageReplicas returns the file storages the image file has been
+// replicated to, by region name. Regions no longer configured are left out.
+func (i *ImagesModel) imageReplicas(image *images.SoftwareImage) map[string]FileStorage {
+	replicas := make(map[string]FileStorage)
+	for _, region := range image.Replicas {
+		if replica, ok := i.replicas[region]; ok {
+			replicas[region] = replica
+		}
+	}
+	return replicas
+}
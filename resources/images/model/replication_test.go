@@ -0,0 +1,126 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+func TestReplicateImage(t *testing.T) {
+	image := images.NewSoftwareImage(validUUIDv4,
+		createValidImageMeta(), createValidImageMetaArtifact())
+	image.Size = 8
+
+	fakeIS := new(FakeImageStorage)
+	fakeIS.findByIdImage = image
+	fakeIS.replicated = make(chan string, 2)
+
+	fakeFS := &FakeFileStorage{objects: map[string][]byte{
+		validUUIDv4: []byte("artifact"),
+	}}
+	replica := &FakeFileStorage{objects: map[string][]byte{}}
+	failing := &FakeFileStorage{
+		objects:             map[string][]byte{},
+		uploadArtifactError: errors.New("upload failed"),
+	}
+
+	iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+	iModel.SetReplicas(map[string]FileStorage{
+		"eu-west-1": replica,
+		"us-east-1": failing,
+	})
+
+	iModel.replicateImage(context.Background(), validUUIDv4)
+
+	select {
+	case region := <-fakeIS.replicated:
+		assert.Equal(t, "eu-west-1", region)
+	case <-time.After(time.Second):
+		t.Fatal("image not replicated")
+	}
+	assert.Equal(t, []byte("artifact"), replica.objects[validUUIDv4])
+
+	// failed replication is not recorded
+	select {
+	case region := <-fakeIS.replicated:
+		t.Fatalf("unexpected replica in %s", region)
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Empty(t, failing.objects)
+}
+
+func TestDownloadLinkReplica(t *testing.T) {
+	primaryLink := images.NewLink("primary", time.Now())
+	replicaLink := images.NewLink("replica", time.Now())
+
+	testCases := map[string]struct {
+		region     string
+		replicaErr error
+
+		link *images.Link
+	}{
+		"no region": {
+			link: primaryLink,
+		},
+		"replicated": {
+			region: "eu-west-1",
+			link:   replicaLink,
+		},
+		"not replicated yet": {
+			region: "us-east-1",
+			link:   primaryLink,
+		},
+		"unknown region": {
+			region: "ap-south-1",
+			link:   primaryLink,
+		},
+		"replica failure": {
+			region:     "eu-west-1",
+			replicaErr: errors.New("error"),
+			link:       primaryLink,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			image := images.NewSoftwareImage(validUUIDv4,
+				createValidImageMeta(), createValidImageMetaArtifact())
+			image.Replicas = []string{"eu-west-1"}
+
+			fakeIS := new(FakeImageStorage)
+			fakeIS.findByIdImage = image
+			fakeFS := &FakeFileStorage{imageExists: true, getReq: primaryLink}
+
+			iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+			iModel.SetReplicas(map[string]FileStorage{
+				"eu-west-1": &FakeFileStorage{getReq: replicaLink, getError: tc.replicaErr},
+				"us-east-1": &FakeFileStorage{getReq: replicaLink},
+			})
+
+			link, err := iModel.DownloadLink(context.Background(),
+				validUUIDv4, time.Hour, tc.region)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.link, link)
+		})
+	}
+}
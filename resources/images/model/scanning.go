@@ -0,0 +1,147 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+// ArtifactScanner scans the artifact files for malware, e.g. with ClamAV.
+type ArtifactScanner interface {
+	// Scan reads the artifact file and returns the name of the threat
+	// found in it, empty if the file is clean.
+	Scan(ctx context.Context, artifact io.Reader) (string, error)
+}
+
+// ArtifactScanning configures scanning of the uploaded artifact files
+// before the images become available.
+type ArtifactScanning struct {
+	Scanner ArtifactScanner
+	// The upload completes before the scan does, the image is being
+	// scanned in the meantime
+	Async bool
+	// Bounds the scan of a single file, zero means no timeout
+	Timeout time.Duration
+}
+
+// SetArtifactScanning enables scanning of the uploaded artifact files;
+// no scanning by default.
+func (i *ImagesModel) SetArtifactScanning(scanning ArtifactScanning) {
+	i.scanning = scanning
+}
+
+// scanImage scans the artifact file of the image, stored under the objectKey,
+// before the image is saved; the image is quarantined if malware is found.
+// In async mode the image is marked as being scanned instead, and scanned
+// with scanImageAsync once saved.
+func (i *ImagesModel) scanImage(ctx context.Context, objectKey string,
+	image *images.SoftwareImage) error {
+
+	if i.scanning.Scanner == nil {
+		return nil
+	}
+	if i.scanning.Async {
+		image.Status = images.ImageStatusScanning
+		return nil
+	}
+
+	threat, err := i.scanFile(ctx, objectKey)
+	if err != nil {
+		return errors.Wrap(err, "Scanning artifact file")
+	}
+	image.Scan = images.NewArtifactScan(threat, nil)
+	if threat != "" {
+		image.Status = images.ImageStatusQuarantined
+		log.FromContext(ctx).F(log.Ctx{"image_id": image.Id, "threat": threat}).
+			Warn("malware found in image file, image quarantined")
+	}
+	return nil
+}
+
+// scanImageAsync scans the artifact file of the image marked as being scanned
+// in the background, so that the upload is not delayed. The image becomes
+// ready if the file is clean, and is quarantined otherwise, also when the scan
+// fails.
+func (i *ImagesModel) scanImageAsync(ctx context.Context, objectKey string,
+	image *images.SoftwareImage) {
+
+	if image.Status != images.ImageStatusScanning {
+		return
+	}
+
+	l := log.FromContext(ctx).F(log.Ctx{"image_id": image.Id})
+
+	// scanning outlives the request, keep the tenant and the logger only
+	scanCtx := log.WithContext(context.Background(), l)
+	if id := identity.FromContext(ctx); id != nil {
+		scanCtx = identity.WithContext(scanCtx, id)
+	}
+
+	go func() {
+		threat, scanErr := i.scanFile(scanCtx, objectKey)
+		status := images.ImageStatusReady
+		if threat != "" || scanErr != nil {
+			status = images.ImageStatusQuarantined
+		}
+
+		changed, err := i.imagesStorage.SetScanResult(scanCtx, image.Id, status,
+			images.NewArtifactScan(threat, scanErr))
+		switch {
+		case err != nil:
+			l.F(log.Ctx{"error": err.Error()}).Error("failed to record image scan result")
+		case !changed:
+			// removed in the meantime
+		case scanErr != nil:
+			l.F(log.Ctx{"error": scanErr.Error()}).
+				Error("failed to scan image file, image quarantined")
+		case threat != "":
+			l.F(log.Ctx{"threat": threat}).
+				Warn("malware found in image file, image quarantined")
+		}
+	}()
+}
+
+// scanFile scans the artifact file stored under the objectKey,
+// returns the name of the threat found, if any.
+func (i *ImagesModel) scanFile(ctx context.Context, objectKey string) (string, error) {
+	ctx, span := tracing.StartSpan(ctx, "ArtifactScanner.Scan")
+	defer span.End()
+
+	if i.scanning.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.scanning.Timeout)
+		defer cancel()
+	}
+
+	file, err := i.fileStorage.GetObject(ctx, objectKey)
+	if err != nil {
+		span.SetError(err)
+		return "", errors.Wrap(err, "Opening artifact file")
+	}
+	defer file.Close()
+
+	threat, err := i.scanning.Scanner.Scan(ctx, file)
+	span.SetError(err)
+	return threat, err
+}
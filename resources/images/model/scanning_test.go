@@ -0,0 +1,200 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+// fakeScanner reports the threat, or fails, once the artifact is read
+type fakeScanner struct {
+	threat string
+	err    error
+}
+
+func (s *fakeScanner) Scan(ctx context.Context, artifact io.Reader) (string, error) {
+	if _, err := io.Copy(ioutil.Discard, artifact); err != nil {
+		return "", err
+	}
+	return s.threat, s.err
+}
+
+func TestCreateImageScan(t *testing.T) {
+	scanErr := errors.New("can't connect to clamd")
+
+	testCases := map[string]struct {
+		scanner *fakeScanner
+		async   bool
+
+		err    error
+		status string
+		threat string
+		// artifact file is kept
+		stored bool
+	}{
+		"clean": {
+			scanner: &fakeScanner{},
+
+			status: images.ImageStatusReady,
+			stored: true,
+		},
+		"malware found": {
+			scanner: &fakeScanner{threat: "Eicar-Signature"},
+
+			err:    controller.ErrModelImageQuarantined,
+			status: images.ImageStatusQuarantined,
+			threat: "Eicar-Signature",
+			stored: true,
+		},
+		"scan failed": {
+			scanner: &fakeScanner{err: scanErr},
+
+			err: scanErr,
+		},
+		"async, clean": {
+			scanner: &fakeScanner{},
+			async:   true,
+
+			status: images.ImageStatusReady,
+			stored: true,
+		},
+		"async, malware found": {
+			scanner: &fakeScanner{threat: "Eicar-Signature"},
+			async:   true,
+
+			status: images.ImageStatusQuarantined,
+			threat: "Eicar-Signature",
+			stored: true,
+		},
+		"async, scan failed": {
+			scanner: &fakeScanner{err: scanErr},
+			async:   true,
+
+			status: images.ImageStatusQuarantined,
+			stored: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fakeIS := new(FakeImageStorage)
+			fakeIS.isArtifactUnique = true
+			fakeIS.scanned = make(chan *images.SoftwareImage, 1)
+			fakeFS := &FakeFileStorage{objects: map[string][]byte{}}
+
+			iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+			iModel.SetArtifactScanning(ArtifactScanning{
+				Scanner: tc.scanner,
+				Async:   tc.async,
+				Timeout: time.Minute,
+			})
+
+			upd, err := MakeRootfsImageArtifact(1, false)
+			assert.NoError(t, err)
+
+			_, err = iModel.CreateImage(context.Background(), &controller.MultipartUploadMsg{
+				MetaConstructor: createValidImageMeta(),
+				ArtifactSize:    int64(upd.Len()),
+				ArtifactReader:  upd,
+			})
+			assert.Equal(t, tc.err, pkgerrors.Cause(err))
+			assert.Equal(t, tc.stored, len(fakeFS.objects) == 1)
+			if tc.status == "" {
+				assert.Nil(t, fakeIS.inserted)
+				return
+			}
+
+			scanned := fakeIS.inserted
+			if tc.async {
+				assert.Equal(t, images.ImageStatusScanning, scanned.Status)
+				select {
+				case scanned = <-fakeIS.scanned:
+					assert.Equal(t, fakeIS.inserted.Id, scanned.Id)
+				case <-time.After(time.Second):
+					t.Fatal("image not scanned")
+				}
+			}
+			assert.Equal(t, tc.status, scanned.Status)
+			if assert.NotNil(t, scanned.Scan) {
+				assert.Equal(t, tc.threat, scanned.Scan.Threat)
+			}
+		})
+	}
+}
+
+func TestUnavailableImage(t *testing.T) {
+	testCases := map[string]struct {
+		status string
+
+		err error
+	}{
+		"ready": {
+			status: images.ImageStatusReady,
+		},
+		"pending": {
+			status: images.ImageStatusPending,
+
+			err: controller.ErrModelImagePending,
+		},
+		"scanning": {
+			status: images.ImageStatusScanning,
+
+			err: controller.ErrModelImageScanning,
+		},
+		"quarantined": {
+			status: images.ImageStatusQuarantined,
+
+			err: controller.ErrModelImageQuarantined,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			image := images.NewSoftwareImage(validUUIDv4,
+				createValidImageMeta(), createValidImageMetaArtifact())
+			image.Status = tc.status
+
+			fakeIS := new(FakeImageStorage)
+			fakeIS.findByIdImage = image
+			fakeFS := &FakeFileStorage{
+				imageExists: true,
+				getReq:      &images.Link{Uri: "https://example.com/artifact"},
+				objects: map[string][]byte{
+					validUUIDv4: []byte("artifact"),
+				},
+			}
+
+			iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, nil)
+
+			_, err := iModel.DownloadLink(context.Background(), validUUIDv4,
+				time.Minute, "")
+			assert.Equal(t, tc.err, err)
+
+			_, err = iModel.OpenImage(context.Background(), validUUIDv4)
+			assert.Equal(t, tc.err, pkgerrors.Cause(err))
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+// ShareImage replaces the tenants the image is shared with, who can then
+// list, deploy and download it; only the tenant owning the image can change
+// it, or the sharing.
+func (i *ImagesModel) ShareImage(ctx context.Context, imageID string,
+	sharing *images.ImageSharing) error {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.ShareImage")
+	defer span.End()
+	span.SetAttribute("image_id", imageID)
+
+	if err := sharing.Validate(); err != nil {
+		return errors.Wrap(err, "Validating image sharing")
+	}
+
+	image, err := i.findImage(ctx, imageID)
+	if err != nil {
+		return err
+	}
+	if image == nil {
+		return controller.ErrImageMetaNotFound
+	}
+	if image.IsShared() {
+		return controller.ErrModelImageNotOwned
+	}
+
+	owner := tenantFromContext(ctx)
+	shared := make(map[string]bool, len(sharing.SharedWith))
+	for _, tenant := range sharing.SharedWith {
+		if tenant == owner {
+			return controller.ErrModelImageSharedWithOwner
+		}
+		shared[tenant] = true
+	}
+
+	// the tenants find the image with the records kept in their databases,
+	// the records of the tenants the image is not shared with are ignored
+	for _, tenant := range sharing.SharedWith {
+		if err := i.imagesStorage.SaveSharedImage(tenantContext(ctx, tenant),
+			&images.SharedImage{Id: imageID, Owner: owner}); err != nil {
+			return errors.Wrapf(err, "Sharing image with tenant %s", tenant)
+		}
+	}
+
+	previous := image.SharedWith
+	found, err := i.imagesStorage.SetSharedWith(ctx, imageID, sharing.SharedWith)
+	if err != nil {
+		return errors.Wrap(err, "Updating image sharing")
+	}
+	if !found {
+		return controller.ErrImageMetaNotFound
+	}
+
+	for _, tenant := range previous {
+		if !shared[tenant] {
+			i.unshareImage(ctx, tenant, imageID)
+		}
+	}
+
+	return nil
+}
+
+// unshareImage removes the record of the image from the database of
+// the tenant it is no longer shared with. Failures are only logged, the record
+// is ignored anyway.
+func (i *ImagesModel) unshareImage(ctx context.Context, tenant, imageID string) {
+	if err := i.imagesStorage.DeleteSharedImage(tenantContext(ctx, tenant),
+		imageID); err != nil {
+		log.FromContext(ctx).F(log.Ctx{
+			"image_id":  imageID,
+			"tenant_id": tenant,
+			"error":     err.Error(),
+		}).Warn("failed to remove shared image")
+	}
+}
+
+// findImage finds the image of the tenant, or the one shared with it.
+// Nil if not found.
+func (i *ImagesModel) findImage(ctx context.Context,
+	imageID string) (*images.SoftwareImage, error) {
+
+	image, err := i.imagesStorage.FindByID(ctx, imageID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Searching for image with specified ID")
+	}
+	if image != nil {
+		return image, nil
+	}
+
+	shared, err := i.imagesStorage.FindShared(ctx,
+		&images.ImagesFilter{IDs: []string{imageID}})
+	if err != nil {
+		return nil, errors.Wrap(err, "Searching for shared image with specified ID")
+	}
+	if len(shared) == 0 {
+		return nil, nil
+	}
+	return shared[0], nil
+}
+
+// ownerContext returns the context of the tenant owning the image.
+func ownerContext(ctx context.Context, image *images.SoftwareImage) context.Context {
+	if image.IsShared() {
+		return tenantContext(ctx, image.Owner)
+	}
+	return ctx
+}
+
+// tenantContext returns the context of the tenant, for accessing its database.
+func tenantContext(ctx context.Context, tenant string) context.Context {
+	return identity.WithContext(ctx, &identity.Identity{Tenant: tenant})
+}
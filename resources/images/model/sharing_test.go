@@ -0,0 +1,207 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+func TestShareImage(t *testing.T) {
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "parent"})
+
+	image := images.NewSoftwareImage(validUUIDv4,
+		createValidImageMeta(), createValidImageMetaArtifact())
+	image.SharedWith = []string{"child-1", "child-2"}
+
+	fakeIS := &FakeImageStorage{
+		findByIdImage: image,
+		sharedRecords: map[string]map[string]string{
+			"child-1": {validUUIDv4: "parent"},
+			"child-2": {validUUIDv4: "parent"},
+		},
+	}
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
+
+	err := iModel.ShareImage(ctx, validUUIDv4,
+		&images.ImageSharing{SharedWith: []string{"child-2", "child-3"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"child-2", "child-3"}, image.SharedWith)
+	assert.Equal(t, map[string]map[string]string{
+		"child-1": {},
+		"child-2": {validUUIDv4: "parent"},
+		"child-3": {validUUIDv4: "parent"},
+	}, fakeIS.sharedRecords)
+
+	err = iModel.ShareImage(ctx, validUUIDv4, &images.ImageSharing{})
+	assert.NoError(t, err)
+	assert.Empty(t, image.SharedWith)
+	assert.Empty(t, fakeIS.sharedRecords["child-2"])
+	assert.Empty(t, fakeIS.sharedRecords["child-3"])
+
+	err = iModel.ShareImage(ctx, validUUIDv4,
+		&images.ImageSharing{SharedWith: []string{"parent"}})
+	assert.Equal(t, controller.ErrModelImageSharedWithOwner, err)
+
+	err = iModel.ShareImage(ctx, validUUIDv4,
+		&images.ImageSharing{SharedWith: []string{"child/1"}})
+	assert.EqualError(t, err, "Validating image sharing: "+
+		images.ErrSharedTenantInvalid.Error())
+}
+
+func TestShareImageNotOwned(t *testing.T) {
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "child"})
+
+	shared := images.NewSoftwareImage(validUUIDv4,
+		createValidImageMeta(), createValidImageMetaArtifact())
+	shared.Owner = "parent"
+
+	fakeIS := &FakeImageStorage{sharedImages: []*images.SoftwareImage{shared}}
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
+
+	err := iModel.ShareImage(ctx, validUUIDv4,
+		&images.ImageSharing{SharedWith: []string{"other"}})
+	assert.Equal(t, controller.ErrModelImageNotOwned, err)
+	assert.Empty(t, fakeIS.sharedRecords)
+
+	fakeIS.sharedImages = nil
+	err = iModel.ShareImage(ctx, validUUIDv4,
+		&images.ImageSharing{SharedWith: []string{"other"}})
+	assert.Equal(t, controller.ErrImageMetaNotFound, err)
+}
+
+func TestSharedImageRead(t *testing.T) {
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "child"})
+
+	own := images.NewSoftwareImage("0c3a7a4b-0c4c-4e5d-9d9c-ae1a6b0c3f4e",
+		createValidImageMeta(), createValidImageMetaArtifact())
+	shared := images.NewSoftwareImage(validUUIDv4,
+		createValidImageMeta(), createValidImageMetaArtifact())
+	shared.Owner = "parent"
+	shared.ObjectKey = "parent/" + validUUIDv4
+
+	fakeIS := &FakeImageStorage{
+		findAllImages:    []*images.SoftwareImage{own},
+		sharedImages:     []*images.SoftwareImage{shared},
+		downloads:        make(chan string, 1),
+		isArtifactUnique: true,
+	}
+	link := &images.Link{Uri: "https://example.com/artifact"}
+	iModel := NewImagesModel(&FakeFileStorage{
+		imageExists: true,
+		getReq:      link,
+		objects:     map[string][]byte{shared.ObjectKey: []byte("artifact")},
+	}, nil, fakeIS, nil, nil)
+
+	filter := &images.ImagesFilter{DeviceType: "required"}
+	list, err := iModel.ListImages(ctx, filter)
+	assert.NoError(t, err)
+	assert.Equal(t, []*images.SoftwareImage{own, shared}, list)
+	assert.Equal(t, filter, fakeIS.sharedFilter)
+
+	found, err := iModel.GetImage(ctx, validUUIDv4)
+	assert.NoError(t, err)
+	assert.Equal(t, shared, found)
+	assert.Equal(t, []string{validUUIDv4}, fakeIS.sharedFilter.IDs)
+
+	downloadLink, err := iModel.DownloadLink(ctx, validUUIDv4, time.Minute, "")
+	assert.NoError(t, err)
+	assert.Equal(t, link, downloadLink)
+	select {
+	case id := <-fakeIS.downloads:
+		assert.Equal(t, validUUIDv4, id)
+	case <-time.After(time.Second):
+		t.Error("download not counted")
+	}
+
+	lookup, err := iModel.GetImages(ctx, []string{validUUIDv4, "missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*images.SoftwareImage{shared}, lookup.Artifacts)
+	assert.Equal(t, []string{"missing"}, lookup.Missing)
+
+	location, err := iModel.ImageLocation(ctx, validUUIDv4)
+	assert.NoError(t, err)
+	assert.Equal(t, shared.ObjectKey, location.Key)
+
+	file, err := iModel.OpenImage(ctx, validUUIDv4)
+	assert.NoError(t, err)
+	assert.NotNil(t, file)
+
+	cloneID, err := iModel.CloneImage(ctx, validUUIDv4, &images.SoftwareImageClone{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cloneID)
+
+	assert.NoError(t, iModel.RotateDownloadLinks(ctx, validUUIDv4))
+
+	fakeIS.sharedImages = nil
+	found, err = iModel.GetImage(ctx, validUUIDv4)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+
+	_, err = iModel.ImageLocation(ctx, validUUIDv4)
+	assert.Equal(t, controller.ErrImageMetaNotFound, err)
+}
+
+func TestSharedImageLatestPerDeviceType(t *testing.T) {
+	ctx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "child"})
+
+	older := time.Now().Add(-time.Hour)
+	newer := time.Now()
+
+	own := images.NewSoftwareImage("0c3a7a4b-0c4c-4e5d-9d9c-ae1a6b0c3f4e",
+		createValidImageMeta(), createValidImageMetaArtifact())
+	own.DeviceTypesCompatible = []string{"a", "b"}
+	own.Modified = &older
+	shared := images.NewSoftwareImage(validUUIDv4,
+		createValidImageMeta(), createValidImageMetaArtifact())
+	shared.DeviceTypesCompatible = []string{"a"}
+	shared.Modified = &newer
+	shared.Owner = "parent"
+
+	fakeIS := &FakeImageStorage{
+		findAllImages: []*images.SoftwareImage{own},
+		sharedImages:  []*images.SoftwareImage{shared},
+	}
+	iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
+
+	list, err := iModel.ListImages(ctx,
+		&images.ImagesFilter{LatestPerDeviceType: true})
+	assert.NoError(t, err)
+	// ordered by device type
+	assert.Equal(t, []*images.SoftwareImage{shared, own}, list)
+
+	list, err = iModel.ListImages(ctx,
+		&images.ImagesFilter{LatestPerDeviceType: true, DeviceType: "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*images.SoftwareImage{shared}, list)
+
+	list, err = iModel.ListImages(ctx, &images.ImagesFilter{
+		LatestPerDeviceType: true,
+		Sort:                images.SortBySizeAsc,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []*images.SoftwareImage{own, shared}, list)
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/pkg/errors"
+)
+
+// TrustedKey is a public key the artifact signatures are verified with.
+type TrustedKey struct {
+	// SHA256 fingerprint of the key, recorded with the artifacts it verified
+	Fingerprint string
+
+	verifier *artifact.PKISigner
+}
+
+// NewTrustedKey parses PEM encoded RSA or ECDSA public key.
+func NewTrustedKey(publicKeyPEM []byte) (*TrustedKey, error) {
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil {
+		return nil, errors.New("Failed to decode PEM public key")
+	}
+
+	if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse public key")
+	}
+
+	sum := sha256.Sum256(block.Bytes)
+
+	return &TrustedKey{
+		Fingerprint: "SHA256:" + hex.EncodeToString(sum[:]),
+		verifier:    artifact.NewVerifier(publicKeyPEM),
+	}, nil
+}
+
+// LoadTrustedKeys reads the public keys from the PEM files.
+func LoadTrustedKeys(paths []string) ([]*TrustedKey, error) {
+	keys := make([]*TrustedKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "Reading trusted key")
+		}
+
+		key, err := NewTrustedKey(data)
+		if err != nil {
+			return nil, errors.Wrap(err, path)
+		}
+
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// verifySignature checks the signature against all the keys.
+// Returns fingerprint of the key which verified the signature.
+func verifySignature(keys []*TrustedKey, message, sig []byte) (string, error) {
+	for _, key := range keys {
+		if err := key.verifier.Verify(message, sig); err == nil {
+			return key.Fingerprint, nil
+		}
+	}
+	return "", errors.New("Signature not verified by any of the trusted keys")
+}
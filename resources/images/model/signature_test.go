@@ -0,0 +1,148 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+// PublicKey matches PrivateKey the test artifacts are signed with
+const PublicKey = `-----BEGIN PUBLIC KEY-----
+MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDSTLzZ9hQq3yBB+dMDVbKem6ia
+v1J6opg6DICKkQ4M/yhlw32BCGm2ArM3VwQRgq6Q1sNSq953n5c1EO3Xcy/qTAKc
+XwaUNml5EhW79AdibBXZiZt8fMhCjUd/4ce3rLNjnbIn1o9L6pzV4CcVJ8+iNhne
+5vbA+63vRCnrc8QuYwIDAQAB
+-----END PUBLIC KEY-----`
+
+const OtherPublicKey = `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEhFJ3uhY+j2Rc4LTNeZK6XxEMQba4
+AECaKeJgM+JSmogq29TSB8uWZRzpyKF2x4GQDXiged8oDR5BryzK1NAgig==
+-----END PUBLIC KEY-----`
+
+func TestNewTrustedKey(t *testing.T) {
+	_, err := NewTrustedKey([]byte("foo"))
+	assert.Error(t, err)
+
+	_, err = NewTrustedKey([]byte(PrivateKey))
+	assert.Error(t, err)
+
+	key, err := NewTrustedKey([]byte(PublicKey))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key.Fingerprint, "SHA256:"))
+
+	other, err := NewTrustedKey([]byte(OtherPublicKey))
+	assert.NoError(t, err)
+	assert.NotEqual(t, key.Fingerprint, other.Fingerprint)
+}
+
+func TestLoadTrustedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trusted-keys-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "key.pem")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(PublicKey), 0600))
+
+	keys, err := LoadTrustedKeys([]string{path})
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	_, err = LoadTrustedKeys([]string{path, filepath.Join(dir, "missing.pem")})
+	assert.Error(t, err)
+
+	keys, err = LoadTrustedKeys(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestCreateImageSignatureVerification(t *testing.T) {
+	trusted, err := NewTrustedKey([]byte(PublicKey))
+	assert.NoError(t, err)
+	other, err := NewTrustedKey([]byte(OtherPublicKey))
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		keys    []*TrustedKey
+		version int
+		signed  bool
+
+		verifiedBy string
+		err        error
+	}{
+		{
+			keys:       []*TrustedKey{other, trusted},
+			version:    2,
+			signed:     true,
+			verifiedBy: trusted.Fingerprint,
+		},
+		{
+			keys:    []*TrustedKey{other},
+			version: 2,
+			signed:  true,
+			err:     controller.ErrModelArtifactSignatureInvalid,
+		},
+		{
+			keys:    []*TrustedKey{trusted},
+			version: 2,
+			err:     controller.ErrModelArtifactNotSigned,
+		},
+		{
+			keys:    []*TrustedKey{trusted},
+			version: 1,
+			err:     controller.ErrModelArtifactNotSigned,
+		},
+		{
+			// no verification
+			version: 2,
+			signed:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		fakeIS := new(FakeImageStorage)
+		fakeIS.isArtifactUnique = true
+		fakeFS := &FakeFileStorage{objects: map[string][]byte{}}
+
+		iModel := NewImagesModel(fakeFS, nil, fakeIS, nil, tc.keys)
+
+		upd, err := MakeRootfsImageArtifact(tc.version, tc.signed)
+		assert.NoError(t, err)
+
+		_, err = iModel.CreateImage(context.Background(), &controller.MultipartUploadMsg{
+			MetaConstructor: createValidImageMeta(),
+			ArtifactSize:    int64(upd.Len()),
+			ArtifactReader:  upd,
+		})
+		if tc.err != nil {
+			assert.Equal(t, tc.err, errors.Cause(err))
+			assert.Empty(t, fakeFS.objects)
+			continue
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.signed, fakeIS.inserted.Signed)
+		assert.Equal(t, tc.verifiedBy, fakeIS.inserted.VerifiedBy)
+	}
+}
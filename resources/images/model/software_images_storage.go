@@ -17,6 +17,7 @@ package model
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/mendersoftware/deployments/resources/images"
 )
@@ -28,6 +29,7 @@ var (
 	ErrSoftwareImagesStorageInvalidName         = errors.New("Invalid name")
 	ErrSoftwareImagesStorageInvalidDeviceType   = errors.New("Invalid device type")
 	ErrSoftwareImagesStorageInvalidImage        = errors.New("Invalid image")
+	ErrSoftwareImagesStorageInvalidChecksum     = errors.New("Invalid checksum")
 )
 
 // SoftwareImagesStorage allow to store and manage image.SoftwareImages
@@ -36,8 +38,45 @@ type SoftwareImagesStorage interface {
 	Update(ctx context.Context, image *images.SoftwareImage) (bool, error)
 	Insert(ctx context.Context, image *images.SoftwareImage) error
 	FindByID(ctx context.Context, id string) (*images.SoftwareImage, error)
+	FindByIDs(ctx context.Context, ids []string) ([]*images.SoftwareImage, error)
+	FindByChecksum(ctx context.Context, checksum string) (*images.SoftwareImage, error)
 	IsArtifactUnique(ctx context.Context, artifactName string,
 		deviceTypesCompatible []string) (bool, error)
-	Delete(ctx context.Context, id string) error
+	IsDeltaUnique(ctx context.Context, from, artifactName string,
+		deviceTypesCompatible []string) (bool, error)
+	Delete(ctx context.Context, id string) (bool, error)
 	FindAll(ctx context.Context) ([]*images.SoftwareImage, error)
+	FindExpired(ctx context.Context, now time.Time) ([]*images.SoftwareImage, error)
+	FindPending(ctx context.Context, created time.Time) ([]*images.SoftwareImage, error)
+	Find(ctx context.Context, filter *images.ImagesFilter) ([]*images.SoftwareImage, error)
+	CountDeviceTypes(ctx context.Context) ([]*images.DeviceTypeCount, error)
+	FindChanges(ctx context.Context,
+		query *images.ImageChangesQuery) ([]*images.ImageChange, error)
+	SetIntegrity(ctx context.Context, id string,
+		integrity *images.ArtifactIntegrity) (bool, error)
+	SetScanResult(ctx context.Context, id, status string,
+		scan *images.ArtifactScan) (bool, error)
+	SetChecksums(ctx context.Context, id string, checksums images.Checksums) (bool, error)
+	IncDownloadCount(ctx context.Context, id string, downloaded time.Time) error
+	AddReplica(ctx context.Context, id, region string) error
+	SetFileVersion(ctx context.Context, id, region, version string, replace bool) error
+	AddTag(ctx context.Context, update *images.TagsUpdate,
+		modified time.Time) (int, error)
+	RemoveTag(ctx context.Context, update *images.TagsUpdate,
+		modified time.Time) (int, error)
+	SetState(ctx context.Context, id, from, to, user string,
+		modified time.Time) (bool, error)
+	IncUsage(ctx context.Context, artifacts, size int64) error
+	ReserveArtifact(ctx context.Context, limit uint64) (bool, error)
+	GetUsage(ctx context.Context) (*images.Usage, error)
+	ReconcileUsage(ctx context.Context) (*images.Usage, error)
+	SaveFailedDeletion(ctx context.Context, deletion *images.FailedDeletion) error
+	FindFailedDeletions(ctx context.Context,
+		retryAt time.Time) ([]*images.FailedDeletion, error)
+	DeleteFailedDeletion(ctx context.Context, id string) error
+	CountFailedDeletions(ctx context.Context) (int, error)
+	SetSharedWith(ctx context.Context, id string, tenants []string) (bool, error)
+	SaveSharedImage(ctx context.Context, shared *images.SharedImage) error
+	DeleteSharedImage(ctx context.Context, id string) error
+	FindShared(ctx context.Context, filter *images.ImagesFilter) ([]*images.SoftwareImage, error)
 }
@@ -0,0 +1,135 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+// UploadProgressRetention is for how long the outcome of finalizing the
+// upload session is reported after it is done.
+const UploadProgressRetention = 10 * time.Minute
+
+// uploadProgressTracker keeps the progress of the upload sessions being
+// finalized by this instance, the session is not stored while the artifact
+// is processed.
+type uploadProgressTracker struct {
+	retention time.Duration
+
+	mutex   sync.Mutex
+	uploads map[string]*images.UploadProgress
+}
+
+func newUploadProgressTracker(retention time.Duration) *uploadProgressTracker {
+	return &uploadProgressTracker{
+		retention: retention,
+		uploads:   map[string]*images.UploadProgress{},
+	}
+}
+
+// progressKey identifies the upload session of the tenant.
+func progressKey(ctx context.Context, id string) string {
+	return tenantFromContext(ctx) + "/" + id
+}
+
+// start begins processing of the upload.
+func (t *uploadProgressTracker) start(key string, size int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.uploads[key] = images.NewUploadProgress(images.UploadStageProcessing, 0, size)
+}
+
+// reader reports the bytes read with it as processed.
+func (t *uploadProgressTracker) reader(key string, r io.Reader) io.Reader {
+	return &progressReader{r: r, tracker: t, key: key}
+}
+
+func (t *uploadProgressTracker) add(key string, n int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	progress, ok := t.uploads[key]
+	if !ok || progress.IsFinal() {
+		return
+	}
+	t.uploads[key] = images.NewUploadProgress(images.UploadStageProcessing,
+		progress.Bytes+int64(n), progress.Size)
+}
+
+// finish records the outcome of processing the upload, reported until
+// the retention passes or the processing is started again.
+func (t *uploadProgressTracker) finish(key, imageID string, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	started, ok := t.uploads[key]
+	if !ok {
+		return
+	}
+
+	var progress *images.UploadProgress
+	if err != nil {
+		progress = images.NewUploadProgress(images.UploadStageFailed,
+			started.Bytes, started.Size)
+	} else {
+		progress = images.NewUploadProgress(images.UploadStageDone,
+			started.Size, started.Size)
+		progress.ImageId = imageID
+	}
+	t.uploads[key] = progress
+
+	time.AfterFunc(t.retention, func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+
+		if t.uploads[key] == progress {
+			delete(t.uploads, key)
+		}
+	})
+}
+
+// get returns the copy of the upload progress, nil if not tracked.
+func (t *uploadProgressTracker) get(key string) *images.UploadProgress {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	progress, ok := t.uploads[key]
+	if !ok {
+		return nil
+	}
+	copied := *progress
+	return &copied
+}
+
+// progressReader reports the bytes read to the tracker.
+type progressReader struct {
+	r       io.Reader
+	tracker *uploadProgressTracker
+	key     string
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.tracker.add(p.key, n)
+	}
+	return n, err
+}
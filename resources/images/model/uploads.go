@@ -0,0 +1,565 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+const (
+	// Upload sessions not finalized within this time are garbage collected
+	DefaultUploadSessionExpire = 24 * time.Hour
+
+	ChunkContentType = "application/octet-stream"
+
+	// maximum size of the artifact uploaded directly to the file storage,
+	// limited by the size of the single PUT request accepted by S3
+	MaxDirectUploadSize = 1024 * 1024 * 1024 * 5
+)
+
+// ImageCreator creates the artifact from the assembled upload
+type ImageCreator interface {
+	CreateImage(ctx context.Context,
+		multipartUploadMsg *controller.MultipartUploadMsg) (string, error)
+	CreateImageFromUpload(ctx context.Context,
+		session *images.UploadSession) (string, error)
+	GetImage(ctx context.Context, id string) (*images.SoftwareImage, error)
+}
+
+type UploadsModel struct {
+	fileStorage    FileStorage
+	uploadsStorage UploadSessionsStorage
+	imageCreator   ImageCreator
+	progress       *uploadProgressTracker
+	tenants        TenantsLister
+	purgeInterval  time.Duration
+}
+
+func NewUploadsModel(
+	fileStorage FileStorage,
+	uploadsStorage UploadSessionsStorage,
+	imageCreator ImageCreator,
+) *UploadsModel {
+	return &UploadsModel{
+		fileStorage:    fileStorage,
+		uploadsStorage: uploadsStorage,
+		imageCreator:   imageCreator,
+		progress:       newUploadProgressTracker(UploadProgressRetention),
+	}
+}
+
+// SetPurgeInterval enables removal of the expired upload sessions of all
+// the tenants every interval by Run, interval of 0 disables the removal.
+func (u *UploadsModel) SetPurgeInterval(tenants TenantsLister, interval time.Duration) {
+	u.tenants = tenants
+	u.purgeInterval = interval
+}
+
+// CreateUpload starts new upload session.
+// Returns upload session ID and nil on success.
+func (u *UploadsModel) CreateUpload(ctx context.Context,
+	constructor *images.UploadSessionConstructor) (string, error) {
+
+	if constructor.Size <= 0 || constructor.Size > MaxImageSize {
+		return "", controller.ErrModelUploadInvalidSize
+	}
+
+	session := images.NewUploadSession(constructor, DefaultUploadSessionExpire)
+	if err := u.uploadsStorage.Insert(ctx, session); err != nil {
+		return "", errors.Wrap(err, "Storing upload session")
+	}
+
+	return session.Id, nil
+}
+
+// CreateDirectUpload starts new upload session, which artifact file is
+// uploaded directly to the file storage with the returned pre-signed link.
+// The link is valid as long as the session is.
+func (u *UploadsModel) CreateDirectUpload(ctx context.Context,
+	constructor *images.UploadSessionConstructor) (*images.DirectUpload, error) {
+
+	if constructor.Size <= 0 || constructor.Size > MaxDirectUploadSize {
+		return nil, controller.ErrModelUploadInvalidSize
+	}
+
+	session := images.NewUploadSession(constructor, DefaultUploadSessionExpire)
+	session.Direct = true
+	if err := u.uploadsStorage.Insert(ctx, session); err != nil {
+		return nil, errors.Wrap(err, "Storing upload session")
+	}
+
+	link, err := u.fileStorage.PutRequest(ctx,
+		images.ObjectKey(tenantFromContext(ctx), session.Id), DefaultUploadSessionExpire)
+	if err != nil {
+		if cleanupErr := u.uploadsStorage.Delete(ctx, session.Id); cleanupErr != nil {
+			return nil, errors.Wrap(err, cleanupErr.Error())
+		}
+		return nil, errors.Wrap(err, "Generating upload link")
+	}
+
+	return &images.DirectUpload{Id: session.Id, Link: link}, nil
+}
+
+// GetUpload returns active upload session, nil if not found or expired.
+func (u *UploadsModel) GetUpload(ctx context.Context,
+	id string) (*images.UploadSession, error) {
+
+	session, err := u.uploadsStorage.FindByID(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "Searching for upload session")
+	}
+
+	if session == nil || session.IsExpired(time.Now()) {
+		return nil, nil
+	}
+
+	return session, nil
+}
+
+// AppendChunk stores the chunk in the file storage and appends it to the session.
+// Offset of the chunk has to be equal to the number of bytes already received.
+// Returns the new session offset.
+func (u *UploadsModel) AppendChunk(ctx context.Context, id string,
+	offset int64, size int64, chunk io.Reader) (int64, error) {
+
+	session, err := u.GetUpload(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case session == nil:
+		return 0, controller.ErrModelUploadNotFound
+	case session.Direct:
+		return 0, controller.ErrModelUploadDirect
+	case size == 0:
+		return 0, controller.ErrModelUploadChunkEmpty
+	case offset != session.Offset:
+		return 0, controller.ErrModelUploadOffsetMismatch
+	case offset+size > session.Size:
+		return 0, controller.ErrModelUploadChunkTooLarge
+	}
+
+	// each attempt is stored under a unique ID,
+	// so that concurrent uploads of the same chunk don't overwrite each other
+	part := images.UploadPart{
+		Offset: offset,
+		Size:   size,
+		ObjectID: images.ObjectKey(tenantFromContext(ctx),
+			fmt.Sprintf("uploads/%s/%s", id, uuid.NewV4().String())),
+	}
+
+	// checksums of the artifact file are computed as the chunks arrive,
+	// so that the chunks don't have to be read again on finalize
+	reader := io.LimitReader(chunk, size)
+	checksums := resumeChecksums(session)
+	if checksums != nil {
+		reader = io.TeeReader(reader, checksums)
+	}
+
+	if err := u.fileStorage.UploadArtifact(ctx, part.ObjectID, size,
+		reader, ChunkContentType); err != nil {
+		return 0, errors.Wrap(err, "Storing chunk")
+	}
+
+	if checksums != nil {
+		part.ChecksumsState, err = checksums.State()
+	}
+
+	var appended bool
+	if err == nil {
+		appended, err = u.uploadsStorage.AppendPart(ctx, id, part)
+	}
+	if err == nil && !appended {
+		err = controller.ErrModelUploadOffsetMismatch
+	}
+	if err != nil {
+		if cleanupErr := u.fileStorage.Delete(ctx, part.ObjectID); cleanupErr != nil {
+			return 0, errors.Wrap(err, cleanupErr.Error())
+		}
+		return 0, err
+	}
+
+	return offset + size, nil
+}
+
+// FinalizeUpload assembles the chunks and creates the artifact.
+// If checksum was provided with the session it's verified before the
+// artifact is created. Session is removed once the artifact is created.
+// The session is claimed first, so that it's finalized only once; it fails
+// with controller.ErrModelUploadFinalizing if it's being finalized already.
+// Returns image ID and nil on success.
+func (u *UploadsModel) FinalizeUpload(ctx context.Context, id string) (string, error) {
+
+	session, err := u.GetUpload(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	if session == nil {
+		return "", controller.ErrModelUploadNotFound
+	}
+
+	claimed, err := u.uploadsStorage.SetStatus(ctx, id,
+		images.UploadStatusOpen, images.UploadStatusFinalizing)
+	if err != nil {
+		return "", errors.Wrap(err, "Claiming upload session")
+	}
+	if !claimed {
+		return "", controller.ErrModelUploadFinalizing
+	}
+
+	var imgID string
+	if session.Direct {
+		imgID, err = u.finalizeDirectUpload(ctx, session)
+	} else {
+		imgID, err = u.finalizeChunkedUpload(ctx, session)
+	}
+	// the session kept on failure is open again, so that it can be retried
+	if err != nil {
+		if _, reopenErr := u.uploadsStorage.SetStatus(ctx, id,
+			images.UploadStatusFinalizing, images.UploadStatusOpen); reopenErr != nil {
+			log.FromContext(ctx).F(log.Ctx{"upload_id": id, "error": reopenErr.Error()}).
+				Warn("failed to reopen upload session")
+		}
+	}
+
+	return imgID, err
+}
+
+// finalizeChunkedUpload creates the artifact out of the chunks received.
+// On failure the session is kept.
+func (u *UploadsModel) finalizeChunkedUpload(ctx context.Context,
+	session *images.UploadSession) (string, error) {
+
+	id := session.Id
+
+	if !session.IsComplete() {
+		return "", controller.ErrModelUploadIncomplete
+	}
+
+	if len(session.ExpectedChecksums()) > 0 {
+		if err := u.verifyChecksums(ctx, session); err != nil {
+			return "", err
+		}
+	}
+
+	reader := newPartsReader(ctx, u.fileStorage, session.Parts)
+	defer reader.Close()
+
+	// the artifact is parsed and stored as it is read
+	key := progressKey(ctx, id)
+	u.progress.start(key, session.Size)
+	imgID, err := u.imageCreator.CreateImage(ctx, &controller.MultipartUploadMsg{
+		MetaConstructor: &images.SoftwareImageMetaConstructor{
+			Description: session.Description,
+		},
+		ArtifactSize:   session.Size,
+		ArtifactReader: u.progress.reader(key, reader),
+	})
+	u.progress.finish(key, imgID, err)
+	if err != nil {
+		return "", err
+	}
+
+	if err := u.deleteSession(ctx, session); err != nil {
+		log.FromContext(ctx).F(log.Ctx{"upload_id": id, "error": err.Error()}).
+			Warn("failed to remove upload session")
+	}
+
+	return imgID, nil
+}
+
+// finalizeDirectUpload creates the artifact out of the file uploaded with
+// the pre-signed link. On failure both the file and the session are kept,
+// so that the upload can be retried until the session expires.
+func (u *UploadsModel) finalizeDirectUpload(ctx context.Context,
+	session *images.UploadSession) (string, error) {
+
+	exists, err := u.fileStorage.Exists(ctx,
+		images.ObjectKey(tenantFromContext(ctx), session.Id))
+	if err != nil {
+		return "", errors.Wrap(err, "Searching for artifact file")
+	}
+	if !exists {
+		return "", controller.ErrModelUploadIncomplete
+	}
+
+	// the file is read by the image creator, only the outcome is tracked
+	key := progressKey(ctx, session.Id)
+	u.progress.start(key, session.Size)
+	imgID, err := u.imageCreator.CreateImageFromUpload(ctx, session)
+	u.progress.finish(key, imgID, err)
+	if err != nil && errors.Cause(err) != controller.ErrModelImageQuarantined {
+		return "", err
+	}
+
+	// the file belongs to the image now, also to the quarantined one,
+	// only the session is removed
+	if err := u.uploadsStorage.Delete(ctx, session.Id); err != nil {
+		log.FromContext(ctx).F(log.Ctx{"upload_id": session.Id, "error": err.Error()}).
+			Warn("failed to remove upload session")
+	}
+
+	return imgID, err
+}
+
+// GetUploadProgress returns the progress of the upload session: the chunks
+// received, or the artifact processed if the session is being finalized
+// by this instance. Returns nil if the session is not found or expired and
+// it's not being processed.
+func (u *UploadsModel) GetUploadProgress(ctx context.Context,
+	id string) (*images.UploadProgress, error) {
+
+	if progress := u.progress.get(progressKey(ctx, id)); progress != nil {
+		return progress, nil
+	}
+
+	session, err := u.GetUpload(ctx, id)
+	if err != nil || session == nil {
+		return nil, err
+	}
+
+	return images.NewUploadProgress(images.UploadStageReceiving,
+		session.Offset, session.Size), nil
+}
+
+// CancelUpload aborts the upload session, removing the chunks or the file
+// uploaded so far. Cancelling the session which is already gone, e.g. on
+// retry, is not an error.
+func (u *UploadsModel) CancelUpload(ctx context.Context, id string) error {
+
+	session, err := u.uploadsStorage.FindByID(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "Searching for upload session")
+	}
+
+	if session == nil {
+		return nil
+	}
+
+	return u.deleteSession(ctx, session)
+}
+
+func (u *UploadsModel) verifyChecksums(ctx context.Context, session *images.UploadSession) error {
+	checksums := resumeChecksums(session)
+	if checksums == nil {
+		// the session predates the checksums state, the chunks are read again
+		reader := newPartsReader(ctx, u.fileStorage, session.Parts)
+		defer reader.Close()
+
+		checksums = images.NewChecksumsWriter()
+		if _, err := io.Copy(checksums, reader); err != nil {
+			return errors.Wrap(err, "Computing artifact checksums")
+		}
+	}
+
+	if !session.ExpectedChecksums().Matches(checksums.Checksums()) {
+		return controller.ErrModelUploadChecksumMismatch
+	}
+
+	return nil
+}
+
+// resumeChecksums returns the checksums of the chunks received so far,
+// resumed from the state recorded with the last one. Returns nil if the
+// state is missing or invalid.
+func resumeChecksums(session *images.UploadSession) *images.ChecksumsWriter {
+	if len(session.Parts) == 0 {
+		return images.NewChecksumsWriter()
+	}
+
+	state := session.Parts[len(session.Parts)-1].ChecksumsState
+	if state == nil {
+		return nil
+	}
+
+	checksums, err := images.NewChecksumsWriterFromState(state)
+	if err != nil {
+		return nil
+	}
+	return checksums
+}
+
+// PurgeExpired removes the abandoned upload sessions of the tenant from
+// the context, along with the stored chunks. Failures of single sessions
+// are only logged, next purge will retry. Returns the number of removed
+// sessions.
+func (u *UploadsModel) PurgeExpired(ctx context.Context) (int, error) {
+	l := log.FromContext(ctx)
+
+	expired, err := u.uploadsStorage.FindExpired(ctx, time.Now())
+	if err != nil {
+		return 0, errors.Wrap(err, "Searching for expired upload sessions")
+	}
+
+	removed := 0
+	for _, session := range expired {
+		if err := u.deleteSession(ctx, session); err != nil {
+			l.F(log.Ctx{"upload_id": session.Id, "error": err.Error()}).
+				Warn("failed to remove expired upload session")
+			continue
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// PurgeAllExpired removes the expired upload sessions of all the tenants.
+func (u *UploadsModel) PurgeAllExpired(ctx context.Context) error {
+	return forEachTenant(ctx, u.tenants, func(tenantCtx context.Context, tenant string) error {
+		removed, err := u.PurgeExpired(tenantCtx)
+		if err != nil {
+			return errors.Wrapf(err, "Removing expired upload sessions of tenant '%s'", tenant)
+		}
+
+		if removed > 0 {
+			log.FromContext(ctx).F(log.Ctx{
+				"tenant_id": tenant,
+				"removed":   removed,
+			}).Info("expired upload sessions removed")
+		}
+		return nil
+	})
+}
+
+// Run removes the expired upload sessions of all the tenants periodically,
+// until the context is cancelled.
+func (u *UploadsModel) Run(ctx context.Context) {
+	if u.purgeInterval <= 0 || u.tenants == nil {
+		return
+	}
+
+	ticker := time.NewTicker(u.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := u.PurgeAllExpired(ctx); err != nil {
+			log.FromContext(ctx).F(log.Ctx{"error": err.Error()}).
+				Error("removal of expired upload sessions failed")
+		}
+	}
+}
+
+func (u *UploadsModel) deleteSession(ctx context.Context, session *images.UploadSession) error {
+	for _, part := range session.Parts {
+		if err := u.fileStorage.Delete(ctx, part.ObjectID); err != nil {
+			return errors.Wrap(err, "Deleting chunk")
+		}
+	}
+
+	if session.Direct {
+		if err := u.deleteDirectUploadFile(ctx, session); err != nil {
+			return err
+		}
+	}
+
+	if err := u.uploadsStorage.Delete(ctx, session.Id); err != nil {
+		return errors.Wrap(err, "Deleting upload session")
+	}
+
+	return nil
+}
+
+// deleteDirectUploadFile removes the file uploaded with the pre-signed link
+// unless the artifact was created out of it.
+func (u *UploadsModel) deleteDirectUploadFile(ctx context.Context,
+	session *images.UploadSession) error {
+
+	image, err := u.imageCreator.GetImage(ctx, session.Id)
+	if err != nil {
+		return errors.Wrap(err, "Searching for artifact")
+	}
+	if image != nil {
+		return nil
+	}
+
+	err = u.fileStorage.Delete(ctx, images.ObjectKey(tenantFromContext(ctx), session.Id))
+	if err != nil && errors.Cause(err) != ErrFileStorageFileNotFound {
+		return errors.Wrap(err, "Deleting artifact file")
+	}
+
+	return nil
+}
+
+// partsReader reads the stored chunks one after another
+type partsReader struct {
+	ctx     context.Context
+	storage FileStorage
+	parts   []images.UploadPart
+	current io.ReadCloser
+}
+
+func newPartsReader(ctx context.Context, storage FileStorage,
+	parts []images.UploadPart) *partsReader {
+
+	return &partsReader{
+		ctx:     ctx,
+		storage: storage,
+		parts:   parts,
+	}
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if len(p.parts) == 0 {
+				return 0, io.EOF
+			}
+
+			current, err := p.storage.GetObject(p.ctx, p.parts[0].ObjectID)
+			if err != nil {
+				return 0, errors.Wrap(err, "Opening chunk")
+			}
+			p.current = current
+			p.parts = p.parts[1:]
+		}
+
+		n, err := p.current.Read(b)
+		if err == io.EOF {
+			p.current.Close()
+			p.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.current != nil {
+		return p.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+var (
+	ErrUploadSessionsStorageInvalidID      = errors.New("Invalid id")
+	ErrUploadSessionsStorageInvalidSession = errors.New("Invalid upload session")
+)
+
+type UploadSessionsStorage interface {
+	Insert(ctx context.Context, session *images.UploadSession) error
+	FindByID(ctx context.Context, id string) (*images.UploadSession, error)
+	// AppendPart adds the part to the session only if the session offset
+	// equals to the part offset. Returns false if the offset did not match.
+	AppendPart(ctx context.Context, id string, part images.UploadPart) (bool, error)
+	// SetStatus changes the status of the session only if it's in the
+	// from status. Returns false if it was not.
+	SetStatus(ctx context.Context, id, from, to string) (bool, error)
+	FindExpired(ctx context.Context, when time.Time) ([]*images.UploadSession, error)
+	FindCreatedBefore(ctx context.Context, when time.Time) ([]*images.UploadSession, error)
+	Delete(ctx context.Context, id string) error
+}
@@ -0,0 +1,658 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+type FakeUploadSessionsStorage struct {
+	sessions map[string]*images.UploadSession
+	err      error
+}
+
+func NewFakeUploadSessionsStorage() *FakeUploadSessionsStorage {
+	return &FakeUploadSessionsStorage{
+		sessions: map[string]*images.UploadSession{},
+	}
+}
+
+func (fus *FakeUploadSessionsStorage) Insert(ctx context.Context,
+	upload *images.UploadSession) error {
+	if fus.err == nil {
+		fus.sessions[upload.Id] = upload
+	}
+	return fus.err
+}
+
+func (fus *FakeUploadSessionsStorage) FindByID(ctx context.Context,
+	id string) (*images.UploadSession, error) {
+	return fus.sessions[id], fus.err
+}
+
+func (fus *FakeUploadSessionsStorage) AppendPart(ctx context.Context,
+	id string, part images.UploadPart) (bool, error) {
+	if fus.err != nil {
+		return false, fus.err
+	}
+	session, ok := fus.sessions[id]
+	if !ok || session.Offset != part.Offset {
+		return false, nil
+	}
+	session.Offset += part.Size
+	session.Parts = append(session.Parts, part)
+	return true, nil
+}
+
+func (fus *FakeUploadSessionsStorage) SetStatus(ctx context.Context,
+	id, from, to string) (bool, error) {
+	if fus.err != nil {
+		return false, fus.err
+	}
+	session, ok := fus.sessions[id]
+	if !ok {
+		return false, nil
+	}
+	status := session.Status
+	if status == "" {
+		status = images.UploadStatusOpen
+	}
+	if status != from {
+		return false, nil
+	}
+	session.Status = to
+	return true, nil
+}
+
+func (fus *FakeUploadSessionsStorage) FindCreatedBefore(ctx context.Context,
+	when time.Time) ([]*images.UploadSession, error) {
+	var found []*images.UploadSession
+	for _, session := range fus.sessions {
+		if session.Created.Before(when) {
+			found = append(found, session)
+		}
+	}
+	return found, fus.err
+}
+
+func (fus *FakeUploadSessionsStorage) FindExpired(ctx context.Context,
+	when time.Time) ([]*images.UploadSession, error) {
+	var expired []*images.UploadSession
+	for _, session := range fus.sessions {
+		if session.IsExpired(when) {
+			expired = append(expired, session)
+		}
+	}
+	return expired, fus.err
+}
+
+func (fus *FakeUploadSessionsStorage) Delete(ctx context.Context, id string) error {
+	delete(fus.sessions, id)
+	return fus.err
+}
+
+type FakeImageCreator struct {
+	data    []byte
+	id      string
+	err     error
+	session *images.UploadSession
+	image   *images.SoftwareImage
+}
+
+func (fic *FakeImageCreator) CreateImage(ctx context.Context,
+	multipartUploadMsg *controller.MultipartUploadMsg) (string, error) {
+	data, err := ioutil.ReadAll(multipartUploadMsg.ArtifactReader)
+	if err != nil {
+		return "", err
+	}
+	fic.data = data
+	return fic.id, fic.err
+}
+
+func (fic *FakeImageCreator) CreateImageFromUpload(ctx context.Context,
+	session *images.UploadSession) (string, error) {
+	fic.session = session
+	return fic.id, fic.err
+}
+
+func (fic *FakeImageCreator) GetImage(ctx context.Context,
+	id string) (*images.SoftwareImage, error) {
+	return fic.image, nil
+}
+
+func TestCreateUpload(t *testing.T) {
+	uploads := NewFakeUploadSessionsStorage()
+	model := NewUploadsModel(&FakeFileStorage{}, uploads, &FakeImageCreator{})
+
+	_, err := model.CreateUpload(context.Background(),
+		&images.UploadSessionConstructor{Size: 0})
+	assert.Equal(t, controller.ErrModelUploadInvalidSize, err)
+
+	_, err = model.CreateUpload(context.Background(),
+		&images.UploadSessionConstructor{Size: MaxImageSize + 1})
+	assert.Equal(t, controller.ErrModelUploadInvalidSize, err)
+
+	id, err := model.CreateUpload(context.Background(),
+		&images.UploadSessionConstructor{Size: 10})
+	assert.NoError(t, err)
+	assert.Contains(t, uploads.sessions, id)
+
+	session, err := model.GetUpload(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), session.Size)
+	assert.Equal(t, int64(0), session.Offset)
+
+	uploads.err = errors.New("storage error")
+	_, err = model.CreateUpload(context.Background(),
+		&images.UploadSessionConstructor{Size: 10})
+	assert.Error(t, err)
+}
+
+func TestGetUploadExpired(t *testing.T) {
+	uploads := NewFakeUploadSessionsStorage()
+	model := NewUploadsModel(&FakeFileStorage{}, uploads, &FakeImageCreator{})
+
+	expired := images.NewUploadSession(&images.UploadSessionConstructor{Size: 10}, -time.Hour)
+	uploads.sessions[expired.Id] = expired
+
+	session, err := model.GetUpload(context.Background(), expired.Id)
+	assert.NoError(t, err)
+	assert.Nil(t, session)
+}
+
+func TestAppendChunk(t *testing.T) {
+	files := &FakeFileStorage{objects: map[string][]byte{}}
+	uploads := NewFakeUploadSessionsStorage()
+	model := NewUploadsModel(files, uploads, &FakeImageCreator{})
+
+	ctx := context.Background()
+
+	id, err := model.CreateUpload(ctx, &images.UploadSessionConstructor{Size: 6})
+	assert.NoError(t, err)
+
+	_, err = model.AppendChunk(ctx, validUUIDv4, 0, 3, bytes.NewReader([]byte("foo")))
+	assert.Equal(t, controller.ErrModelUploadNotFound, err)
+
+	_, err = model.AppendChunk(ctx, id, 0, 0, bytes.NewReader(nil))
+	assert.Equal(t, controller.ErrModelUploadChunkEmpty, err)
+
+	_, err = model.AppendChunk(ctx, id, 3, 3, bytes.NewReader([]byte("bar")))
+	assert.Equal(t, controller.ErrModelUploadOffsetMismatch, err)
+
+	_, err = model.AppendChunk(ctx, id, 0, 7, bytes.NewReader([]byte("foobarb")))
+	assert.Equal(t, controller.ErrModelUploadChunkTooLarge, err)
+
+	offset, err := model.AppendChunk(ctx, id, 0, 3, bytes.NewReader([]byte("foo")))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), offset)
+	assert.Len(t, files.objects, 1)
+
+	// file storage failure
+	files.uploadArtifactError = errors.New("upload error")
+	_, err = model.AppendChunk(ctx, id, 3, 3, bytes.NewReader([]byte("bar")))
+	assert.Error(t, err)
+	files.uploadArtifactError = nil
+
+	// chunk stored, but session moved on in the meantime; chunk is removed
+	racing := &racingSessionsStorage{uploads}
+	model = NewUploadsModel(files, racing, &FakeImageCreator{})
+	_, err = model.AppendChunk(ctx, id, 3, 3, bytes.NewReader([]byte("bar")))
+	assert.Equal(t, controller.ErrModelUploadOffsetMismatch, err)
+	assert.Len(t, files.objects, 1)
+}
+
+// racingSessionsStorage simulates concurrent upload moving the session offset
+// between the offset check and appending the part
+type racingSessionsStorage struct {
+	*FakeUploadSessionsStorage
+}
+
+func (r *racingSessionsStorage) AppendPart(ctx context.Context,
+	id string, part images.UploadPart) (bool, error) {
+	r.sessions[id].Offset++
+	return r.FakeUploadSessionsStorage.AppendPart(ctx, id, part)
+}
+
+func TestFinalizeUpload(t *testing.T) {
+	data := []byte("foobar")
+	sum := sha256.Sum256(data)
+	md5Sum := md5.Sum(data)
+
+	testCases := []struct {
+		checksum  string
+		checksums images.Checksums
+		chunks    [][]byte
+		imgErr    error
+		// chunks received before the checksums state was recorded
+		legacy bool
+
+		err error
+	}{
+		{
+			chunks: [][]byte{data[:3]},
+			err:    controller.ErrModelUploadIncomplete,
+		},
+		{
+			checksum: hex.EncodeToString(sum[:]),
+			chunks:   [][]byte{data[:3], data[3:]},
+		},
+		{
+			chunks: [][]byte{data[:1], data[1:4], data[4:]},
+		},
+		{
+			checksum: hex.EncodeToString(make([]byte, 32)),
+			chunks:   [][]byte{data},
+			err:      controller.ErrModelUploadChecksumMismatch,
+		},
+		{
+			checksum: hex.EncodeToString(sum[:]),
+			chunks:   [][]byte{data[:3], data[3:]},
+			legacy:   true,
+		},
+		{
+			checksum: hex.EncodeToString(make([]byte, 32)),
+			chunks:   [][]byte{data[:3], data[3:]},
+			legacy:   true,
+			err:      controller.ErrModelUploadChecksumMismatch,
+		},
+		{
+			checksums: images.Checksums{
+				images.ChecksumMD5: hex.EncodeToString(md5Sum[:]),
+			},
+			chunks: [][]byte{data},
+		},
+		{
+			checksums: images.Checksums{
+				images.ChecksumMD5:    hex.EncodeToString(make([]byte, 16)),
+				images.ChecksumSHA256: hex.EncodeToString(sum[:]),
+			},
+			chunks: [][]byte{data},
+			err:    controller.ErrModelUploadChecksumMismatch,
+		},
+		{
+			chunks: [][]byte{data},
+			imgErr: controller.ErrModelArtifactNotUnique,
+			err:    controller.ErrModelArtifactNotUnique,
+		},
+	}
+
+	for _, tc := range testCases {
+		files := &FakeFileStorage{objects: map[string][]byte{}}
+		uploads := NewFakeUploadSessionsStorage()
+		creator := &FakeImageCreator{id: validUUIDv4, err: tc.imgErr}
+		model := NewUploadsModel(files, uploads, creator)
+
+		ctx := context.Background()
+
+		id, err := model.CreateUpload(ctx, &images.UploadSessionConstructor{
+			Size:      int64(len(data)),
+			Checksum:  tc.checksum,
+			Checksums: tc.checksums,
+		})
+		assert.NoError(t, err)
+
+		var offset int64
+		for _, chunk := range tc.chunks {
+			offset, err = model.AppendChunk(ctx, id, offset,
+				int64(len(chunk)), bytes.NewReader(chunk))
+			assert.NoError(t, err)
+		}
+		if tc.legacy {
+			for i := range uploads.sessions[id].Parts {
+				uploads.sessions[id].Parts[i].ChecksumsState = nil
+			}
+		}
+
+		imgID, err := model.FinalizeUpload(ctx, id)
+		if tc.err != nil {
+			assert.Equal(t, tc.err, err)
+			if assert.Contains(t, uploads.sessions, id) {
+				assert.Equal(t, images.UploadStatusOpen, uploads.sessions[id].Status)
+			}
+			continue
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, validUUIDv4, imgID)
+		assert.Equal(t, data, creator.data)
+		assert.NotContains(t, uploads.sessions, id)
+		assert.Empty(t, files.objects)
+	}
+
+	_, err := NewUploadsModel(&FakeFileStorage{}, NewFakeUploadSessionsStorage(),
+		&FakeImageCreator{}).FinalizeUpload(context.Background(), validUUIDv4)
+	assert.Equal(t, controller.ErrModelUploadNotFound, err)
+
+	// checksums are verified without reading the chunks again
+	files := &FakeFileStorage{objects: map[string][]byte{}}
+	model := NewUploadsModel(files, NewFakeUploadSessionsStorage(), &FakeImageCreator{})
+	id, err := model.CreateUpload(context.Background(), &images.UploadSessionConstructor{
+		Size:     int64(len(data)),
+		Checksum: hex.EncodeToString(make([]byte, 32)),
+	})
+	assert.NoError(t, err)
+	_, err = model.AppendChunk(context.Background(), id, 0,
+		int64(len(data)), bytes.NewReader(data))
+	assert.NoError(t, err)
+	files.getObjectError = errors.New("read error")
+	_, err = model.FinalizeUpload(context.Background(), id)
+	assert.Equal(t, controller.ErrModelUploadChecksumMismatch, err)
+}
+
+// claimingImageCreator finalizes the upload session again while the
+// artifact is being created.
+type claimingImageCreator struct {
+	FakeImageCreator
+	model    *UploadsModel
+	uploadID string
+	err      error
+}
+
+func (c *claimingImageCreator) CreateImage(ctx context.Context,
+	multipartUploadMsg *controller.MultipartUploadMsg) (string, error) {
+	_, c.err = c.model.FinalizeUpload(ctx, c.uploadID)
+	return c.FakeImageCreator.CreateImage(ctx, multipartUploadMsg)
+}
+
+func TestFinalizeUploadClaimed(t *testing.T) {
+	data := []byte("foobar")
+
+	files := &FakeFileStorage{objects: map[string][]byte{}}
+	uploads := NewFakeUploadSessionsStorage()
+	creator := &claimingImageCreator{FakeImageCreator: FakeImageCreator{id: validUUIDv4}}
+	model := NewUploadsModel(files, uploads, creator)
+	creator.model = model
+
+	ctx := context.Background()
+	id, err := model.CreateUpload(ctx, &images.UploadSessionConstructor{
+		Size: int64(len(data)),
+	})
+	assert.NoError(t, err)
+	creator.uploadID = id
+	_, err = model.AppendChunk(ctx, id, 0, int64(len(data)), bytes.NewReader(data))
+	assert.NoError(t, err)
+
+	// finalized only once
+	imgID, err := model.FinalizeUpload(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, validUUIDv4, imgID)
+	assert.Equal(t, controller.ErrModelUploadFinalizing, creator.err)
+	assert.NotContains(t, uploads.sessions, id)
+
+	// session created before the status was recorded is open
+	id, err = model.CreateUpload(ctx, &images.UploadSessionConstructor{
+		Size: int64(len(data)),
+	})
+	assert.NoError(t, err)
+	uploads.sessions[id].Status = ""
+	creator.uploadID = id
+	creator.err = nil
+	_, err = model.AppendChunk(ctx, id, 0, int64(len(data)), bytes.NewReader(data))
+	assert.NoError(t, err)
+	imgID, err = model.FinalizeUpload(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, validUUIDv4, imgID)
+	assert.Equal(t, controller.ErrModelUploadFinalizing, creator.err)
+}
+
+// progressImageCreator checks the upload progress half way through
+// the artifact.
+type progressImageCreator struct {
+	FakeImageCreator
+	model    *UploadsModel
+	uploadID string
+	progress *images.UploadProgress
+}
+
+func (p *progressImageCreator) CreateImage(ctx context.Context,
+	multipartUploadMsg *controller.MultipartUploadMsg) (string, error) {
+	half := make([]byte, multipartUploadMsg.ArtifactSize/2)
+	if _, err := io.ReadFull(multipartUploadMsg.ArtifactReader, half); err != nil {
+		return "", err
+	}
+	p.progress, _ = p.model.GetUploadProgress(ctx, p.uploadID)
+	return p.FakeImageCreator.CreateImage(ctx, multipartUploadMsg)
+}
+
+func TestGetUploadProgress(t *testing.T) {
+	files := &FakeFileStorage{objects: map[string][]byte{}}
+	uploads := NewFakeUploadSessionsStorage()
+	creator := &progressImageCreator{FakeImageCreator: FakeImageCreator{id: validUUIDv4}}
+	model := NewUploadsModel(files, uploads, creator)
+	creator.model = model
+
+	ctx := context.Background()
+
+	progress, err := model.GetUploadProgress(ctx, validUUIDv4)
+	assert.NoError(t, err)
+	assert.Nil(t, progress)
+
+	id, err := model.CreateUpload(ctx, &images.UploadSessionConstructor{Size: 6})
+	assert.NoError(t, err)
+	creator.uploadID = id
+
+	_, err = model.AppendChunk(ctx, id, 0, 3, bytes.NewReader([]byte("foo")))
+	assert.NoError(t, err)
+
+	progress, err = model.GetUploadProgress(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, images.NewUploadProgress(images.UploadStageReceiving, 3, 6), progress)
+
+	_, err = model.AppendChunk(ctx, id, 3, 3, bytes.NewReader([]byte("bar")))
+	assert.NoError(t, err)
+
+	_, err = model.FinalizeUpload(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, images.NewUploadProgress(images.UploadStageProcessing, 3, 6),
+		creator.progress)
+
+	// reported after the session is removed
+	progress, err = model.GetUploadProgress(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, &images.UploadProgress{
+		Stage:      images.UploadStageDone,
+		Bytes:      6,
+		Size:       6,
+		Percentage: 100,
+		ImageId:    validUUIDv4,
+	}, progress)
+
+	// failed finalization can be retried
+	creator.err = controller.ErrModelArtifactNotUnique
+	id, err = model.CreateUpload(ctx, &images.UploadSessionConstructor{Size: 6})
+	assert.NoError(t, err)
+	creator.uploadID = id
+	_, err = model.AppendChunk(ctx, id, 0, 6, bytes.NewReader([]byte("foobar")))
+	assert.NoError(t, err)
+
+	_, err = model.FinalizeUpload(ctx, id)
+	assert.Equal(t, controller.ErrModelArtifactNotUnique, err)
+
+	progress, err = model.GetUploadProgress(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, images.NewUploadProgress(images.UploadStageFailed, 6, 6), progress)
+	assert.True(t, progress.IsFinal())
+}
+
+func TestCancelUpload(t *testing.T) {
+	files := &FakeFileStorage{objects: map[string][]byte{}}
+	uploads := NewFakeUploadSessionsStorage()
+	model := NewUploadsModel(files, uploads, &FakeImageCreator{})
+
+	ctx := context.Background()
+
+	id, err := model.CreateUpload(ctx, &images.UploadSessionConstructor{Size: 6})
+	assert.NoError(t, err)
+	_, err = model.AppendChunk(ctx, id, 0, 3, bytes.NewReader([]byte("foo")))
+	assert.NoError(t, err)
+
+	direct, err := model.CreateDirectUpload(ctx, &images.UploadSessionConstructor{Size: 3})
+	assert.NoError(t, err)
+	files.objects[images.ObjectKey("", direct.Id)] = []byte("foo")
+
+	for _, id := range []string{id, direct.Id} {
+		assert.NoError(t, model.CancelUpload(ctx, id))
+		assert.NotContains(t, uploads.sessions, id)
+
+		// cancelling again is fine
+		assert.NoError(t, model.CancelUpload(ctx, id))
+	}
+	assert.Empty(t, files.objects)
+
+	uploads.err = errors.New("db error")
+	assert.Error(t, model.CancelUpload(ctx, validUUIDv4))
+}
+
+func TestCreateDirectUpload(t *testing.T) {
+	link := &images.Link{Uri: "http://storage/upload", Expire: time.Now()}
+	files := &FakeFileStorage{objects: map[string][]byte{}, putReq: link}
+	uploads := NewFakeUploadSessionsStorage()
+	creator := &FakeImageCreator{}
+	model := NewUploadsModel(files, uploads, creator)
+
+	ctx := context.Background()
+
+	_, err := model.CreateDirectUpload(ctx,
+		&images.UploadSessionConstructor{Size: MaxDirectUploadSize + 1})
+	assert.Equal(t, controller.ErrModelUploadInvalidSize, err)
+
+	upload, err := model.CreateDirectUpload(ctx, &images.UploadSessionConstructor{Size: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, link, upload.Link)
+	assert.Contains(t, uploads.sessions, upload.Id)
+	assert.True(t, uploads.sessions[upload.Id].Direct)
+
+	// chunks are not accepted
+	_, err = model.AppendChunk(ctx, upload.Id, 0, 3, bytes.NewReader([]byte("foo")))
+	assert.Equal(t, controller.ErrModelUploadDirect, err)
+
+	// no link, no session
+	files.putError = errors.New("presign error")
+	_, err = model.CreateDirectUpload(ctx, &images.UploadSessionConstructor{Size: 3})
+	assert.Error(t, err)
+	assert.Len(t, uploads.sessions, 1)
+}
+
+func TestPurgeExpiredUploads(t *testing.T) {
+	files := &FakeFileStorage{objects: map[string][]byte{}}
+	uploads := NewFakeUploadSessionsStorage()
+	creator := &FakeImageCreator{}
+	model := NewUploadsModel(files, uploads, creator)
+	model.SetPurgeInterval(&FakeTenantsLister{}, time.Minute)
+
+	ctx := context.Background()
+
+	active, err := model.CreateUpload(ctx, &images.UploadSessionConstructor{Size: 6})
+	assert.NoError(t, err)
+	_, err = model.AppendChunk(ctx, active, 0, 3, bytes.NewReader([]byte("foo")))
+	assert.NoError(t, err)
+
+	// abandoned chunked upload is purged along with the chunks
+	expired := images.NewUploadSession(&images.UploadSessionConstructor{Size: 6}, time.Hour)
+	uploads.sessions[expired.Id] = expired
+	_, err = model.AppendChunk(ctx, expired.Id, 0, 3, bytes.NewReader([]byte("bar")))
+	assert.NoError(t, err)
+	expired.Expire = time.Now().Add(-time.Hour)
+
+	// abandoned direct upload is purged along with the uploaded file
+	direct := images.NewUploadSession(&images.UploadSessionConstructor{Size: 3}, -time.Hour)
+	direct.Direct = true
+	uploads.sessions[direct.Id] = direct
+	files.objects[images.ObjectKey("", direct.Id)] = []byte("foo")
+
+	assert.NoError(t, model.PurgeAllExpired(ctx))
+	assert.Contains(t, uploads.sessions, active)
+	assert.NotContains(t, uploads.sessions, expired.Id)
+	assert.NotContains(t, uploads.sessions, direct.Id)
+	assert.Len(t, files.objects, 1)
+
+	// file of the expired session already turned into the artifact is kept
+	uploads.sessions[direct.Id] = direct
+	files.objects[images.ObjectKey("", direct.Id)] = []byte("foo")
+	creator.image = &images.SoftwareImage{Id: direct.Id}
+
+	removed, err := model.PurgeExpired(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.NotContains(t, uploads.sessions, direct.Id)
+	assert.Len(t, files.objects, 2)
+
+	uploads.err = errors.New("storage error")
+	assert.Error(t, model.PurgeAllExpired(ctx))
+
+	uploads.err = nil
+	model.SetPurgeInterval(&FakeTenantsLister{err: errors.New("list error")}, time.Minute)
+	assert.Error(t, model.PurgeAllExpired(ctx))
+}
+
+func TestFinalizeDirectUpload(t *testing.T) {
+	testCases := []struct {
+		uploaded bool
+		imgErr   error
+
+		err error
+	}{
+		{
+			err: controller.ErrModelUploadIncomplete,
+		},
+		{
+			uploaded: true,
+			imgErr:   controller.ErrModelUploadSizeMismatch,
+			err:      controller.ErrModelUploadSizeMismatch,
+		},
+		{
+			uploaded: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		files := &FakeFileStorage{imageExists: tc.uploaded}
+		uploads := NewFakeUploadSessionsStorage()
+		creator := &FakeImageCreator{id: validUUIDv4, err: tc.imgErr}
+		model := NewUploadsModel(files, uploads, creator)
+
+		ctx := context.Background()
+
+		upload, err := model.CreateDirectUpload(ctx,
+			&images.UploadSessionConstructor{Size: 3})
+		assert.NoError(t, err)
+
+		imgID, err := model.FinalizeUpload(ctx, upload.Id)
+		if tc.err != nil {
+			assert.Equal(t, tc.err, err)
+			assert.Contains(t, uploads.sessions, upload.Id)
+			continue
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, validUUIDv4, imgID)
+		assert.Equal(t, upload.Id, creator.session.Id)
+		assert.NotContains(t, uploads.sessions, upload.Id)
+	}
+}
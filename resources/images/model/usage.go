@@ -0,0 +1,110 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+// UsageModel reports the usage of the tenants, counted as the images are
+// created and removed, and corrects it periodically.
+type UsageModel struct {
+	imagesStorage SoftwareImagesStorage
+	tenants       TenantsLister
+	interval      time.Duration
+}
+
+// NewUsageModel creates the model. Usage of all the tenants is recomputed
+// every interval by Run, interval of 0 disables the reconciliation.
+func NewUsageModel(
+	imagesStorage SoftwareImagesStorage,
+	tenants TenantsLister,
+	interval time.Duration,
+) *UsageModel {
+	return &UsageModel{
+		imagesStorage: imagesStorage,
+		tenants:       tenants,
+		interval:      interval,
+	}
+}
+
+// GetUsage returns the usage of the tenant from the context.
+func (m *UsageModel) GetUsage(ctx context.Context) (*images.Usage, error) {
+	usage, err := m.imagesStorage.GetUsage(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Getting usage")
+	}
+	return usage, nil
+}
+
+// ReconcileUsage recomputes the usage of the tenant from the context out of
+// the stored images, correcting the drift, e.g. due to failed updates.
+func (m *UsageModel) ReconcileUsage(ctx context.Context) (*images.Usage, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "UsageModel.ReconcileUsage")
+	defer span.End()
+
+	usage, err := m.imagesStorage.ReconcileUsage(ctx)
+	if err != nil {
+		span.SetError(err)
+		return nil, errors.Wrap(err, "Recomputing usage")
+	}
+
+	span.SetAttribute("artifacts", usage.Artifacts)
+	span.SetAttribute("size", usage.Size)
+
+	return usage, nil
+}
+
+// ReconcileAllUsage recomputes the usage of all the tenants.
+func (m *UsageModel) ReconcileAllUsage(ctx context.Context) error {
+	return forEachTenant(ctx, m.tenants, func(tenantCtx context.Context, tenant string) error {
+		if _, err := m.ReconcileUsage(tenantCtx); err != nil {
+			return errors.Wrapf(err, "Reconciling usage of tenant '%s'", tenant)
+		}
+		return nil
+	})
+}
+
+// Run recomputes the usage of all the tenants periodically,
+// until the context is cancelled.
+func (m *UsageModel) Run(ctx context.Context) {
+	if m.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := m.ReconcileAllUsage(ctx); err != nil {
+			log.FromContext(ctx).F(log.Ctx{"error": err.Error()}).
+				Error("usage reconciliation failed")
+		}
+	}
+}
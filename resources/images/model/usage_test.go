@@ -0,0 +1,116 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+func TestImagesUsageCounting(t *testing.T) {
+	fakeIS := &FakeImageStorage{isArtifactUnique: true}
+	iModel := NewImagesModel(new(FakeFileStorage), new(FakeUseChecker), fakeIS, nil, nil)
+
+	upd, err := MakeRootfsImageArtifact(1, false)
+	assert.NoError(t, err)
+	size := int64(upd.Len())
+
+	_, err = iModel.CreateImage(context.Background(), &controller.MultipartUploadMsg{
+		MetaConstructor: createValidImageMeta(),
+		ArtifactSize:    size,
+		ArtifactReader:  upd,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, images.Usage{Artifacts: 1, Size: size}, fakeIS.usage)
+
+	fakeIS.findByIdImage = fakeIS.inserted
+	assert.NoError(t, iModel.DeleteImage(context.Background(), fakeIS.inserted.Id))
+	assert.Equal(t, images.Usage{}, fakeIS.usage)
+
+	// failed count doesn't fail the removal, the usage is reconciled later
+	fakeIS.usageError = errors.New("db error")
+	assert.NoError(t, iModel.DeleteImage(context.Background(), fakeIS.inserted.Id))
+}
+
+func TestReconcileUsage(t *testing.T) {
+	fakeIS := &FakeImageStorage{
+		usage:           images.Usage{Artifacts: 3, Size: -10},
+		reconciledUsage: &images.Usage{Artifacts: 2, Size: 100},
+	}
+	model := NewUsageModel(fakeIS, nil, 0)
+
+	usage, err := model.ReconcileUsage(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &images.Usage{Artifacts: 2, Size: 100}, usage)
+
+	usage, err = model.GetUsage(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &images.Usage{Artifacts: 2, Size: 100}, usage)
+
+	fakeIS.usageError = errors.New("db error")
+	_, err = model.ReconcileUsage(context.Background())
+	assert.EqualError(t, err, "Recomputing usage: db error")
+	_, err = model.GetUsage(context.Background())
+	assert.EqualError(t, err, "Getting usage: db error")
+}
+
+func TestReconcileAllUsage(t *testing.T) {
+	testCases := []struct {
+		tenants    []string
+		listErr    error
+		usageError error
+
+		err bool
+	}{
+		{
+			tenants: []string{},
+		},
+		{
+			tenants: []string{"foo", "bar"},
+		},
+		{
+			tenants:    []string{"foo", "bar"},
+			usageError: errors.New("db error"),
+			err:        true,
+		},
+		{
+			listErr: errors.New("db error"),
+			err:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		fakeIS := &FakeImageStorage{
+			reconciledUsage: &images.Usage{Artifacts: 1, Size: 10},
+			usageError:      tc.usageError,
+		}
+		model := NewUsageModel(fakeIS,
+			&FakeTenantsLister{tenants: tc.tenants, err: tc.listErr}, 0)
+
+		err := model.ReconcileAllUsage(context.Background())
+		if tc.err {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, images.Usage{Artifacts: 1, Size: 10}, fakeIS.usage)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/utils/tracing"
+)
+
+// ValidateImage parses the artifact and verifies its signature the same way
+// CreateImage does, but stores neither the artifact file nor the image; the
+// file is only read through, so that nothing outlives the call.
+// Problems with the artifact are reported along with the metadata parsed,
+// the error is returned if the upload itself is wrong or the checks could
+// not be completed.
+func (i *ImagesModel) ValidateImage(ctx context.Context,
+	multipartUploadMsg *controller.MultipartUploadMsg) (*images.ArtifactValidation, error) {
+
+	ctx, span := tracing.StartSpan(ctx, "ImagesModel.ValidateImage")
+	defer span.End()
+
+	if err := checkUploadMsg(multipartUploadMsg); err != nil {
+		return nil, err
+	}
+
+	span.SetAttribute("artifact_size", multipartUploadMsg.ArtifactSize)
+
+	validation := &images.ArtifactValidation{
+		Valid:    true,
+		Problems: []string{},
+	}
+
+	if err := multipartUploadMsg.MetaConstructor.ValidateRequired(); err != nil {
+		validation.AddProblem(errors.Wrap(controller.ErrModelInvalidMetadata, err.Error()))
+	}
+
+	// limit reader to the size provided with the upload message
+	counter := &countingReader{
+		r: io.LimitReader(multipartUploadMsg.ArtifactReader, multipartUploadMsg.ArtifactSize),
+	}
+	checksums := images.NewChecksumsWriter()
+	var tee io.Reader = io.TeeReader(counter, checksums)
+
+	metaArtifactConstructor, parseErr := getMetaFromArchive(&tee, i.trustedKeys)
+
+	// read the rest of the data, also when parsing failed,
+	// so that the size and the checksums are known
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+		return nil, errors.Wrap(err, "Reading artifact file")
+	}
+
+	// the file has to be exactly of the declared size, neither truncated
+	// nor followed by more data
+	if counter.n != multipartUploadMsg.ArtifactSize ||
+		!isExhausted(multipartUploadMsg.ArtifactReader) {
+		return nil, controller.ErrModelUploadSizeMismatch
+	}
+
+	validation.Size = counter.n
+	validation.Checksums = checksums.Checksums()
+
+	if !multipartUploadMsg.Checksums.Matches(validation.Checksums) {
+		validation.AddProblem(controller.ErrModelUploadChecksumMismatch)
+	}
+
+	if parseErr != nil {
+		span.SetError(parseErr)
+		switch errors.Cause(parseErr) {
+		case controller.ErrModelArtifactNotSigned, controller.ErrModelArtifactSignatureInvalid:
+			validation.AddProblem(parseErr)
+		default:
+			validation.AddProblem(
+				errors.Wrap(controller.ErrModelParsingArtifactFailed, parseErr.Error()))
+		}
+		return validation, nil
+	}
+
+	validation.Artifact = metaArtifactConstructor
+
+	if err := metaArtifactConstructor.Validate(); err != nil {
+		validation.AddProblem(errors.Wrap(controller.ErrModelInvalidMetadata, err.Error()))
+	}
+
+	delta := multipartUploadMsg.Delta
+	if delta != nil && delta.To != metaArtifactConstructor.Name {
+		validation.AddProblem(controller.ErrModelDeltaNameMismatch)
+	}
+
+	isArtifactUnique, err := i.isArtifactUnique(ctx,
+		metaArtifactConstructor.Name, metaArtifactConstructor.DeviceTypesCompatible, delta)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fail to check if artifact is unique")
+	}
+	if !isArtifactUnique {
+		validation.AddProblem(controller.ErrModelArtifactNotUnique)
+	}
+
+	span.SetAttribute("valid", validation.Valid)
+
+	return validation, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/controller"
+)
+
+func TestValidateImage(t *testing.T) {
+	testCases := map[string]struct {
+		data                  []byte
+		size                  int64
+		delta                 *images.DeltaUpdate
+		checksums             images.Checksums
+		isArtifactUnique      bool
+		isArtifactUniqueError error
+
+		parsed   bool
+		problems []string
+		err      error
+	}{
+		"ok": {
+			isArtifactUnique: true,
+			parsed:           true,
+		},
+		"not unique": {
+			parsed:   true,
+			problems: []string{controller.ErrModelArtifactNotUnique.Error()},
+		},
+		"delta name mismatch": {
+			delta:            &images.DeltaUpdate{From: "mender-1.0", To: "mender-1.2"},
+			isArtifactUnique: true,
+			parsed:           true,
+			problems:         []string{controller.ErrModelDeltaNameMismatch.Error()},
+		},
+		"checksum mismatch": {
+			checksums:        images.Checksums{images.ChecksumMD5: "00112233445566778899aabbccddeeff"},
+			isArtifactUnique: true,
+			parsed:           true,
+			problems:         []string{controller.ErrModelUploadChecksumMismatch.Error()},
+		},
+		"malformed": {
+			data: []byte("not an artifact"),
+			problems: []string{
+				controller.ErrModelParsingArtifactFailed.Error(),
+			},
+		},
+		"size mismatch": {
+			data: []byte("not an artifact"),
+			size: 100,
+			err:  controller.ErrModelUploadSizeMismatch,
+		},
+		"uniqueness check error": {
+			isArtifactUniqueError: errors.New("db error"),
+			err:                   errors.New("Fail to check if artifact is unique: db error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fakeIS := new(FakeImageStorage)
+			fakeIS.isArtifactUnique = tc.isArtifactUnique
+			fakeIS.isDeltaUnique = tc.isArtifactUnique
+			fakeIS.isArtifactUniqueError = tc.isArtifactUniqueError
+
+			// no file storage, nothing is uploaded
+			iModel := NewImagesModel(nil, nil, fakeIS, nil, nil)
+
+			data := tc.data
+			if data == nil {
+				upd, err := MakeRootfsImageArtifact(2, false)
+				assert.NoError(t, err)
+				data = upd.Bytes()
+			}
+			size := tc.size
+			if size == 0 {
+				size = int64(len(data))
+			}
+
+			validation, err := iModel.ValidateImage(context.Background(),
+				&controller.MultipartUploadMsg{
+					MetaConstructor: createValidImageMeta(),
+					ArtifactSize:    size,
+					ArtifactReader:  bytes.NewReader(data),
+					Delta:           tc.delta,
+					Checksums:       tc.checksums,
+				})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				assert.Nil(t, validation)
+				return
+			}
+			assert.NoError(t, err)
+			if !assert.NotNil(t, validation) {
+				return
+			}
+
+			// nothing is stored
+			assert.Nil(t, fakeIS.inserted)
+
+			assert.Equal(t, len(tc.problems) == 0, validation.Valid)
+			if assert.Len(t, validation.Problems, len(tc.problems)) {
+				for i, problem := range tc.problems {
+					assert.Contains(t, validation.Problems[i], problem)
+				}
+			}
+			assert.Equal(t, int64(len(data)), validation.Size)
+			assert.Len(t, validation.Checksums[images.ChecksumSHA256], 64)
+			if tc.parsed {
+				if assert.NotNil(t, validation.Artifact) {
+					assert.Equal(t, "mender-1.1", validation.Artifact.Name)
+				}
+			} else {
+				assert.Nil(t, validation.Artifact)
+			}
+		})
+	}
+}
+
+func TestValidateImageEmptyMessage(t *testing.T) {
+	iModel := NewImagesModel(nil, nil, nil, nil, nil)
+	_, err := iModel.ValidateImage(context.Background(), nil)
+	assert.Equal(t, controller.ErrModelMultipartUploadMsgMalformed, pkgerrors.Cause(err))
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/store"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+// Database KEYS
+const (
+	StorageKeyFailedDeletionRetryAt = "retry_at"
+)
+
+// Database
+const (
+	CollectionFailedDeletions = "failed_deletions"
+)
+
+// SaveFailedDeletion inserts the failed deletion, or replaces the one
+// of the same file.
+func (i *SoftwareImagesStorage) SaveFailedDeletion(ctx context.Context,
+	deletion *images.FailedDeletion) error {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	_, err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionFailedDeletions).UpsertId(deletion.Id, deletion)
+	return err
+}
+
+// FindFailedDeletions lists the failed deletions due for retry at the time,
+// the longest overdue first.
+func (i *SoftwareImagesStorage) FindFailedDeletions(ctx context.Context,
+	retryAt time.Time) ([]*images.FailedDeletion, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	deletions := []*images.FailedDeletion{}
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionFailedDeletions).
+		Find(bson.M{StorageKeyFailedDeletionRetryAt: bson.M{"$lte": retryAt}}).
+		Sort(StorageKeyFailedDeletionRetryAt).
+		All(&deletions); err != nil {
+		return nil, err
+	}
+
+	return deletions, nil
+}
+
+// DeleteFailedDeletion removes the failed deletion once the file is removed.
+// Missing one is not an error.
+func (i *SoftwareImagesStorage) DeleteFailedDeletion(ctx context.Context, id string) error {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionFailedDeletions).RemoveId(id)
+	if err != nil && err.Error() != mgo.ErrNotFound.Error() {
+		return err
+	}
+
+	return nil
+}
+
+// CountFailedDeletions counts the failed deletions pending retry.
+func (i *SoftwareImagesStorage) CountFailedDeletions(ctx context.Context) (int, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	return session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionFailedDeletions).Count()
+}
@@ -16,6 +16,8 @@ package mongo
 
 import (
 	"context"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/asaskevich/govalidator"
@@ -25,6 +27,7 @@ import (
 
 	"github.com/mendersoftware/deployments/resources/images"
 	"github.com/mendersoftware/deployments/resources/images/model"
+	"github.com/mendersoftware/deployments/utils/readpref"
 )
 
 // Database KEYS
@@ -35,46 +38,222 @@ const (
 	StorageKeySoftwareImageDeviceTypes = "meta_artifact.device_types_compatible"
 	StorageKeySoftwareImageName        = "meta_artifact.name"
 	StorageKeySoftwareImageId          = "_id"
+	StorageKeySoftwareImageIntegrity   = "integrity"
+	StorageKeySoftwareImageScan        = "scan"
+	StorageKeySoftwareImageDownloads   = "download_count"
+	StorageKeySoftwareImageDownloaded  = "last_downloaded"
+	StorageKeySoftwareImageTags        = "meta.tags"
+	StorageKeySoftwareImageSize        = "size"
+	StorageKeySoftwareImageModified    = "modified"
+	StorageKeySoftwareImageCreated     = "created"
+	StorageKeySoftwareImageChecksum    = "checksum"
+	StorageKeySoftwareImageChecksums   = "checksums"
+	StorageKeySoftwareImageDelta       = "delta"
+	StorageKeySoftwareImageDeltaFrom   = "delta.from"
+	StorageKeySoftwareImageStatus      = "status"
+	StorageKeySoftwareImageState       = "state"
+	StorageKeySoftwareImageStateTime   = "state_modified"
+	StorageKeySoftwareImageStateUser   = "state_modified_by"
+	StorageKeySoftwareImageExpiresAt   = "meta.expires_at"
+	StorageKeySoftwareImageMetadata    = "meta.metadata"
+	StorageKeySoftwareImageReplicas    = "replicas"
+	StorageKeySoftwareImageSharedWith  = "shared_with"
+
+	// Versions of the image file the download links are pinned to
+	StorageKeySoftwareImageFileVersion     = "file_version"
+	StorageKeySoftwareImageReplicaVersions = "replica_versions"
+
+	StorageKeyDeletedImageName    = "name"
+	StorageKeyDeletedImageDeleted = "deleted"
 )
 
+// Keys of the fields which can be listed, by images.ListFields name
+var listFieldKeys = map[string]string{
+	"id":                      StorageKeySoftwareImageId,
+	"name":                    StorageKeySoftwareImageName,
+	"description":             "meta.description",
+	"tags":                    StorageKeySoftwareImageTags,
+	"expires_at":              StorageKeySoftwareImageExpiresAt,
+	"metadata":                StorageKeySoftwareImageMetadata,
+	"device_types_compatible": StorageKeySoftwareImageDeviceTypes,
+	"info":                    "meta_artifact.info",
+	"signed":                  "meta_artifact.signed",
+	"verified_by":             "meta_artifact.verified_by",
+	"updates":                 "meta_artifact.updates",
+	"modified":                StorageKeySoftwareImageModified,
+	"created":                 StorageKeySoftwareImageCreated,
+	"size":                    StorageKeySoftwareImageSize,
+	"checksum":                StorageKeySoftwareImageChecksum,
+	"checksums":               StorageKeySoftwareImageChecksums,
+	"integrity":               StorageKeySoftwareImageIntegrity,
+	"scan":                    StorageKeySoftwareImageScan,
+	"download_count":          StorageKeySoftwareImageDownloads,
+	"last_downloaded":         StorageKeySoftwareImageDownloaded,
+	"delta":                   StorageKeySoftwareImageDelta,
+	"status":                  StorageKeySoftwareImageStatus,
+	"state":                   StorageKeySoftwareImageState,
+	"replicas":                StorageKeySoftwareImageReplicas,
+	"shared_with":             StorageKeySoftwareImageSharedWith,
+}
+
 // Indexes
 const (
-	IndexUniqeNameAndDeviceTypeStr = "uniqueNameAndDeviceTypeIndex"
+	// replaced by IndexUniqueNameDeviceTypeAndDeltaStr, kept for migrations
+	IndexUniqeNameAndDeviceTypeStr       = "uniqueNameAndDeviceTypeIndex"
+	IndexUniqueNameDeviceTypeAndDeltaStr = "uniqueNameDeviceTypeAndDeltaIndex"
+	IndexTagsStr                         = "tagsIndex"
+	IndexExpiresAtStr                    = "expiresAtIndex"
+	IndexModifiedStr                     = "modifiedIndex"
+	IndexDeletedStr                      = "deletedIndex"
+	IndexChecksumStr                     = "checksumIndex"
 )
 
 // Database
 const (
 	DatabaseName     = "deployment_service"
 	CollectionImages = "images"
+
+	// Records of the deleted images for the changes feed, removed
+	// after DeletedImagesTTL
+	CollectionDeletedImages = "deleted_images"
 )
 
+// DeletedImagesTTL is how long the deletions are listed in the changes feed
+const DeletedImagesTTL = 30 * 24 * time.Hour
+
 // SoftwareImagesStorage is a data layer for SoftwareImages based on MongoDB
 // Implements model.SoftwareImagesStorage
 type SoftwareImagesStorage struct {
-	session *mgo.Session
+	session  *mgo.Session
+	readMode mgo.Mode
 }
 
 // NewSoftwareImagesStorage new data layer object
 func NewSoftwareImagesStorage(session *mgo.Session) *SoftwareImagesStorage {
 
 	return &SoftwareImagesStorage{
-		session: session,
+		session:  session,
+		readMode: mgo.Primary,
+	}
+}
+
+// SetReadMode sets the mode of the reads allowed to be served
+// by the secondaries, see readpref.WithSecondaryReads.
+func (i *SoftwareImagesStorage) SetReadMode(mode mgo.Mode) {
+	i.readMode = mode
+}
+
+// copySession copies the session for a single operation. Socket operations
+// of the copy are bounded by the deadline of the context, if any, so that
+// a hanging database doesn't block the caller past the deadline.
+// The read mode applies only if the context allows the secondary reads.
+func (i *SoftwareImagesStorage) copySession(ctx context.Context) *mgo.Session {
+	session := i.session.Copy()
+	readpref.SetMode(ctx, session, i.readMode)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := deadline.Sub(time.Now())
+		if timeout <= 0 {
+			// zero means no timeout, fail immediately instead
+			timeout = time.Nanosecond
+		}
+		session.SetSocketTimeout(timeout)
+	}
+
+	return session
+}
+
+// upgradeImages brings the images read to the current schema version
+func upgradeImages(list []*images.SoftwareImage) {
+	for _, image := range list {
+		image.Upgrade()
 	}
 }
 
 // Ensure required indexes exists; create if not.
 func (i *SoftwareImagesStorage) ensureIndexing(ctx context.Context, session *mgo.Session) error {
+	return i.DoEnsureIndexing(store.DbFromContext(ctx, DatabaseName), session)
+}
+
+// DoEnsureIndexing creates the indexes in the given database.
+func (i *SoftwareImagesStorage) DoEnsureIndexing(db string, session *mgo.Session) error {
 
+	// full artifacts have no delta, so they are unique by name and
+	// device type, deltas also by the artifact they are applied to
 	uniqueNameVersionIndex := mgo.Index{
-		Key:    []string{StorageKeySoftwareImageName, StorageKeySoftwareImageDeviceTypes},
+		Key: []string{
+			StorageKeySoftwareImageName,
+			StorageKeySoftwareImageDeviceTypes,
+			StorageKeySoftwareImageDeltaFrom,
+		},
 		Unique: true,
-		Name:   IndexUniqeNameAndDeviceTypeStr,
+		Name:   IndexUniqueNameDeviceTypeAndDeltaStr,
 		// Build index upfront - make sure this index is allways on.
 		Background: false,
 	}
 
-	return session.DB(store.DbFromContext(ctx, DatabaseName)).
-		C(CollectionImages).EnsureIndex(uniqueNameVersionIndex)
+	tagsIndex := mgo.Index{
+		Key:        []string{StorageKeySoftwareImageTags},
+		Name:       IndexTagsStr,
+		Background: true,
+	}
+
+	// used for finding the expired images, only the images
+	// with expiry time are indexed
+	expiresAtIndex := mgo.Index{
+		Key:        []string{StorageKeySoftwareImageExpiresAt},
+		Name:       IndexExpiresAtStr,
+		Sparse:     true,
+		Background: true,
+	}
+
+	// used for the changes feed
+	modifiedIndex := mgo.Index{
+		Key:        []string{StorageKeySoftwareImageModified},
+		Name:       IndexModifiedStr,
+		Background: true,
+	}
+
+	// used for finding the images by the file checksum, the images
+	// uploaded before the checksum was recorded are not indexed
+	checksumIndex := mgo.Index{
+		Key:        []string{StorageKeySoftwareImageChecksum},
+		Name:       IndexChecksumStr,
+		Sparse:     true,
+		Background: true,
+	}
+
+	// used for the changes feed, and expires the deletion records
+	deletedIndex := mgo.Index{
+		Key:         []string{StorageKeyDeletedImageDeleted},
+		Name:        IndexDeletedStr,
+		ExpireAfter: DeletedImagesTTL,
+		Background:  true,
+	}
+
+	collection := session.DB(db).C(CollectionImages)
+
+	if err := collection.EnsureIndex(uniqueNameVersionIndex); err != nil {
+		return err
+	}
+
+	if err := collection.EnsureIndex(tagsIndex); err != nil {
+		return err
+	}
+
+	if err := collection.EnsureIndex(expiresAtIndex); err != nil {
+		return err
+	}
+
+	if err := collection.EnsureIndex(modifiedIndex); err != nil {
+		return err
+	}
+
+	if err := collection.EnsureIndex(checksumIndex); err != nil {
+		return err
+	}
+
+	return session.DB(db).C(CollectionDeletedImages).EnsureIndex(deletedIndex)
 }
 
 // Exists checks if object with ID exists
@@ -84,7 +263,7 @@ func (i *SoftwareImagesStorage) Exists(ctx context.Context, id string) (bool, er
 		return false, model.ErrSoftwareImagesStorageInvalidID
 	}
 
-	session := i.session.Copy()
+	session := i.copySession(ctx)
 	defer session.Close()
 
 	var image *images.SoftwareImage
@@ -108,10 +287,11 @@ func (i *SoftwareImagesStorage) Update(ctx context.Context,
 		return false, err
 	}
 
-	session := i.session.Copy()
+	session := i.copySession(ctx)
 	defer session.Close()
 
 	image.SetModified(time.Now())
+	image.SchemaVersion = images.ImageSchemaVersion
 	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
 		C(CollectionImages).UpdateId(image.Id, image); err != nil {
 		if err.Error() == mgo.ErrNotFound.Error() {
@@ -123,6 +303,274 @@ func (i *SoftwareImagesStorage) Update(ctx context.Context,
 	return true, nil
 }
 
+// SetIntegrity records the result of the image file integrity verification.
+// Image modification time is not changed.
+// Return false if not found
+func (i *SoftwareImagesStorage) SetIntegrity(ctx context.Context, id string,
+	integrity *images.ArtifactIntegrity) (bool, error) {
+
+	if govalidator.IsNull(id) {
+		return false, model.ErrSoftwareImagesStorageInvalidID
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).UpdateId(id,
+		bson.M{"$set": bson.M{StorageKeySoftwareImageIntegrity: integrity}}); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SetChecksums records the checksums of the image file, SHA256 one is also
+// recorded as the checksum. Image modification time is not changed.
+// Return false if not found.
+func (i *SoftwareImagesStorage) SetChecksums(ctx context.Context, id string,
+	checksums images.Checksums) (bool, error) {
+
+	if govalidator.IsNull(id) {
+		return false, model.ErrSoftwareImagesStorageInvalidID
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).UpdateId(id,
+		bson.M{"$set": bson.M{
+			StorageKeySoftwareImageChecksums: checksums,
+			StorageKeySoftwareImageChecksum:  checksums[images.ChecksumSHA256],
+		}}); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SetScanResult records the result of the malware scan of the image file,
+// along with the status the image is moved to, as long as it's still being
+// scanned. Image modification time is not changed.
+// Return false if not found or not being scanned.
+func (i *SoftwareImagesStorage) SetScanResult(ctx context.Context, id, status string,
+	scan *images.ArtifactScan) (bool, error) {
+
+	if govalidator.IsNull(id) {
+		return false, model.ErrSoftwareImagesStorageInvalidID
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeySoftwareImageId:     id,
+		StorageKeySoftwareImageStatus: images.ImageStatusScanning,
+	}
+
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).Update(query, bson.M{"$set": bson.M{
+		StorageKeySoftwareImageStatus: status,
+		StorageKeySoftwareImageScan:   scan,
+	}}); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// IncDownloadCount counts the download of the image file. Image modification
+// time is not changed. Missing image is not an error, it may have been
+// removed in the meantime.
+func (i *SoftwareImagesStorage) IncDownloadCount(ctx context.Context, id string,
+	downloaded time.Time) error {
+
+	if govalidator.IsNull(id) {
+		return model.ErrSoftwareImagesStorageInvalidID
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).UpdateId(id, bson.M{
+		"$inc": bson.M{StorageKeySoftwareImageDownloads: 1},
+		"$set": bson.M{StorageKeySoftwareImageDownloaded: downloaded},
+	})
+	if err != nil && err.Error() != mgo.ErrNotFound.Error() {
+		return err
+	}
+
+	return nil
+}
+
+// AddReplica records the region the image file has been replicated to.
+// Image modification time is not changed. Missing image is not an error,
+// it may have been removed in the meantime.
+func (i *SoftwareImagesStorage) AddReplica(ctx context.Context, id, region string) error {
+
+	if govalidator.IsNull(id) {
+		return model.ErrSoftwareImagesStorageInvalidID
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).UpdateId(id, bson.M{
+		"$addToSet": bson.M{StorageKeySoftwareImageReplicas: region},
+	})
+	if err != nil && err.Error() != mgo.ErrNotFound.Error() {
+		return err
+	}
+
+	return nil
+}
+
+// SetFileVersion records the version of the image file in the region,
+// empty for the primary file storage. The version recorded already is
+// replaced only if replace is set, so that the version recorded on rotation
+// is not overwritten by the one looked up concurrently. Image modification
+// time is not changed. Missing image is not an error.
+func (i *SoftwareImagesStorage) SetFileVersion(ctx context.Context,
+	id, region, version string, replace bool) error {
+
+	if govalidator.IsNull(id) {
+		return model.ErrSoftwareImagesStorageInvalidID
+	}
+
+	key := StorageKeySoftwareImageFileVersion
+	if region != "" {
+		key = StorageKeySoftwareImageReplicaVersions + "." + region
+	}
+
+	query := bson.M{StorageKeySoftwareImageId: id}
+	if !replace {
+		query[key] = bson.M{"$exists": false}
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).Update(query, bson.M{
+		"$set": bson.M{key: version},
+	})
+	if err != nil && err.Error() != mgo.ErrNotFound.Error() {
+		return err
+	}
+
+	return nil
+}
+
+// AddTag adds the tag to all the images selected by the update with
+// a single update, together with their modification time.
+// Images already tagged, or having the maximum number of tags, are left intact.
+// Returns the number of images modified.
+func (i *SoftwareImagesStorage) AddTag(ctx context.Context,
+	update *images.TagsUpdate, modified time.Time) (int, error) {
+
+	query := tagsUpdateQuery(update)
+	query[StorageKeySoftwareImageTags] = bson.M{"$ne": update.Tag}
+	// tags are indexed from 0, so the last one allowed must not exist
+	query[StorageKeySoftwareImageTags+"."+strconv.Itoa(images.MaxTagsCount-1)] =
+		bson.M{"$exists": false}
+
+	return i.updateTags(ctx, query, bson.M{
+		"$addToSet": bson.M{StorageKeySoftwareImageTags: update.Tag},
+		"$set":      bson.M{StorageKeySoftwareImageModified: modified},
+	})
+}
+
+// RemoveTag removes the tag from all the images selected by the update with
+// a single update, together with their modification time.
+// Returns the number of images modified.
+func (i *SoftwareImagesStorage) RemoveTag(ctx context.Context,
+	update *images.TagsUpdate, modified time.Time) (int, error) {
+
+	query := tagsUpdateQuery(update)
+	query[StorageKeySoftwareImageTags] = update.Tag
+
+	return i.updateTags(ctx, query, bson.M{
+		"$pull": bson.M{StorageKeySoftwareImageTags: update.Tag},
+		"$set":  bson.M{StorageKeySoftwareImageModified: modified},
+	})
+}
+
+// tagsUpdateQuery selects the images of the tags update
+func tagsUpdateQuery(update *images.TagsUpdate) bson.M {
+	query := bson.M{}
+	if len(update.IDs) > 0 {
+		query[StorageKeySoftwareImageId] = bson.M{"$in": update.IDs}
+	}
+	if update.DeviceType != "" {
+		query[StorageKeySoftwareImageDeviceTypes] = update.DeviceType
+	}
+	return query
+}
+
+func (i *SoftwareImagesStorage) updateTags(ctx context.Context,
+	query, update bson.M) (int, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	info, err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).UpdateAll(query, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Updated, nil
+}
+
+// SetState changes the lifecycle state of the image, as long as it is still
+// in the from state; images without the state recorded are in uploaded state.
+// Image modification time is not changed.
+// Return false if not found or in other state.
+func (i *SoftwareImagesStorage) SetState(ctx context.Context, id, from, to,
+	user string, modified time.Time) (bool, error) {
+
+	if govalidator.IsNull(id) {
+		return false, model.ErrSoftwareImagesStorageInvalidID
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeySoftwareImageId:    id,
+		StorageKeySoftwareImageState: from,
+	}
+	if from == images.ImageStateUploaded {
+		query[StorageKeySoftwareImageState] = bson.M{"$in": []interface{}{from, nil}}
+	}
+
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).Update(query, bson.M{"$set": bson.M{
+		StorageKeySoftwareImageState:     to,
+		StorageKeySoftwareImageStateTime: modified,
+		StorageKeySoftwareImageStateUser: user,
+	}}); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
 // ImageByNameAndDeviceType finds image with speficied application name and targed device type
 func (i *SoftwareImagesStorage) ImageByNameAndDeviceType(ctx context.Context,
 	name, deviceType string) (*images.SoftwareImage, error) {
@@ -136,13 +584,17 @@ func (i *SoftwareImagesStorage) ImageByNameAndDeviceType(ctx context.Context,
 		return nil, model.ErrSoftwareImagesStorageInvalidDeviceType
 	}
 
-	// equal to device type & software version (application name + version)
+	// equal to device type & software version (application name + version),
+	// pending images have no artifact file to install, nor are the ones
+	// being scanned or quarantined installed
 	query := bson.M{
 		StorageKeySoftwareImageDeviceTypes: deviceType,
 		StorageKeySoftwareImageName:        name,
+		StorageKeySoftwareImageDelta:       bson.M{"$exists": false},
+		StorageKeySoftwareImageStatus:      bson.M{"$nin": images.UnavailableImageStatuses},
 	}
 
-	session := i.session.Copy()
+	session := i.copySession(ctx)
 	defer session.Close()
 
 	// Both we lookup uniqe object, should be one or none.
@@ -155,9 +607,36 @@ func (i *SoftwareImagesStorage) ImageByNameAndDeviceType(ctx context.Context,
 		return nil, err
 	}
 
+	image.Upgrade()
 	return &image, nil
 }
 
+// FindByIDs finds the images with the given IDs,
+// IDs which are not found are skipped.
+func (i *SoftwareImagesStorage) FindByIDs(ctx context.Context,
+	ids []string) ([]*images.SoftwareImage, error) {
+
+	if len(ids) == 0 {
+		return nil, model.ErrSoftwareImagesStorageInvalidID
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeySoftwareImageId: bson.M{"$in": ids},
+	}
+
+	var found []*images.SoftwareImage
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).Find(query).All(&found); err != nil {
+		return nil, err
+	}
+
+	upgradeImages(found)
+	return found, nil
+}
+
 // ImageByIdsAndDeviceType finds image with id from ids and targed device type
 func (i *SoftwareImagesStorage) ImageByIdsAndDeviceType(ctx context.Context,
 	ids []string, deviceType string) (*images.SoftwareImage, error) {
@@ -175,23 +654,34 @@ func (i *SoftwareImagesStorage) ImageByIdsAndDeviceType(ctx context.Context,
 		StorageKeySoftwareImageId:          bson.M{"$in": ids},
 	}
 
-	session := i.session.Copy()
+	session := i.copySession(ctx)
 	defer session.Close()
 
 	// Both we lookup uniqe object, should be one or none.
 	var image images.SoftwareImage
 	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
 		C(CollectionImages).Find(query).One(&image); err != nil {
-		if err.Error() == mgo.ErrNotFound.Error() {
-			return nil, nil
+		if err.Error() != mgo.ErrNotFound.Error() {
+			return nil, err
 		}
-		return nil, err
+
+		// the deployment may be of an image shared with the tenant
+		shared, err := i.FindShared(ctx, &images.ImagesFilter{
+			IDs:        ids,
+			DeviceType: deviceType,
+		})
+		if err != nil || len(shared) == 0 {
+			return nil, err
+		}
+		return shared[0], nil
 	}
 
+	image.Upgrade()
 	return &image, nil
 }
 
-// ImagesByName finds images with speficied artifact name
+// ImagesByName finds images with speficied artifact name, including
+// the images shared with the tenant
 func (i *SoftwareImagesStorage) ImagesByName(
 	ctx context.Context, name string) ([]*images.SoftwareImage, error) {
 
@@ -200,22 +690,75 @@ func (i *SoftwareImagesStorage) ImagesByName(
 
 	}
 
-	// equal to artifact name
+	// equal to artifact name, deltas are selected separately,
+	// pending, being scanned and quarantined images can't be deployed
 	query := bson.M{
-		StorageKeySoftwareImageName: name,
+		StorageKeySoftwareImageName:   name,
+		StorageKeySoftwareImageDelta:  bson.M{"$exists": false},
+		StorageKeySoftwareImageStatus: bson.M{"$nin": images.UnavailableImageStatuses},
 	}
 
-	session := i.session.Copy()
+	session := i.copySession(ctx)
 	defer session.Close()
 
 	// Both we lookup uniqe object, should be one or none.
-	var images []*images.SoftwareImage
+	var found []*images.SoftwareImage
 	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
-		C(CollectionImages).Find(query).All(&images); err != nil {
+		C(CollectionImages).Find(query).All(&found); err != nil {
 		return nil, err
 	}
+	upgradeImages(found)
 
-	return images, nil
+	shared, err := i.FindShared(ctx, &images.ImagesFilter{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range shared {
+		if image.Delta == nil && image.IsAvailable() {
+			found = append(found, image)
+		}
+	}
+
+	return found, nil
+}
+
+// DeltaImageByNameAndDeviceType finds the delta resulting in the artifact
+// with the given name, applicable to the device type having the from artifact
+// installed. Nil if there is none.
+func (i *SoftwareImagesStorage) DeltaImageByNameAndDeviceType(ctx context.Context,
+	from, name, deviceType string) (*images.SoftwareImage, error) {
+
+	if govalidator.IsNull(from) || govalidator.IsNull(name) {
+		return nil, model.ErrSoftwareImagesStorageInvalidName
+	}
+
+	if govalidator.IsNull(deviceType) {
+		return nil, model.ErrSoftwareImagesStorageInvalidDeviceType
+	}
+
+	// deltas being scanned or quarantined can't be installed
+	query := bson.M{
+		StorageKeySoftwareImageDeviceTypes: deviceType,
+		StorageKeySoftwareImageName:        name,
+		StorageKeySoftwareImageDeltaFrom:   from,
+		StorageKeySoftwareImageStatus:      bson.M{"$nin": images.UnavailableImageStatuses},
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	// unique index guarantees one or none
+	var image images.SoftwareImage
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).Find(query).One(&image); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	image.Upgrade()
+	return &image, nil
 }
 
 // Insert persists object
@@ -229,13 +772,14 @@ func (i *SoftwareImagesStorage) Insert(ctx context.Context, image *images.Softwa
 		return err
 	}
 
-	session := i.session.Copy()
+	session := i.copySession(ctx)
 	defer session.Close()
 
 	if err := i.ensureIndexing(ctx, session); err != nil {
 		return err
 	}
 
+	image.SchemaVersion = images.ImageSchemaVersion
 	return session.DB(store.DbFromContext(ctx, DatabaseName)).
 		C(CollectionImages).Insert(image)
 }
@@ -248,7 +792,7 @@ func (i *SoftwareImagesStorage) FindByID(ctx context.Context,
 		return nil, model.ErrSoftwareImagesStorageInvalidID
 	}
 
-	session := i.session.Copy()
+	session := i.copySession(ctx)
 	defer session.Close()
 
 	var image *images.SoftwareImage
@@ -260,6 +804,34 @@ func (i *SoftwareImagesStorage) FindByID(ctx context.Context,
 		return nil, err
 	}
 
+	image.Upgrade()
+	return image, nil
+}
+
+// FindByChecksum search storage for image with the file checksum,
+// returns nil if not found
+func (i *SoftwareImagesStorage) FindByChecksum(ctx context.Context,
+	checksum string) (*images.SoftwareImage, error) {
+
+	if govalidator.IsNull(checksum) {
+		return nil, model.ErrSoftwareImagesStorageInvalidChecksum
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	var image *images.SoftwareImage
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).
+		Find(bson.M{StorageKeySoftwareImageChecksum: checksum}).
+		One(&image); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	image.Upgrade()
 	return image, nil
 }
 
@@ -275,7 +847,7 @@ func (i *SoftwareImagesStorage) IsArtifactUnique(ctx context.Context,
 		return false, model.ErrSoftwareImagesStorageInvalidArtifactName
 	}
 
-	session := i.session.Copy()
+	session := i.copySession(ctx)
 	defer session.Close()
 
 	query := bson.M{
@@ -286,9 +858,51 @@ func (i *SoftwareImagesStorage) IsArtifactUnique(ctx context.Context,
 			{
 				StorageKeySoftwareImageDeviceTypes: bson.M{"$in": deviceTypesCompatible},
 			},
+			{
+				StorageKeySoftwareImageDelta: bson.M{"$exists": false},
+			},
 		},
 	}
 
+	return i.isUnique(ctx, session, query)
+}
+
+// IsDeltaUnique checks if there is no delta with the same artifactName,
+// applied to the from artifact, supporting one of the device types
+// from deviceTypesCompatible list.
+// Returns true, nil if delta is unique;
+// false, nil if delta is not unique;
+// false, error in case of error.
+func (i *SoftwareImagesStorage) IsDeltaUnique(ctx context.Context,
+	from, artifactName string, deviceTypesCompatible []string) (bool, error) {
+
+	if govalidator.IsNull(artifactName) || govalidator.IsNull(from) {
+		return false, model.ErrSoftwareImagesStorageInvalidArtifactName
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	query := bson.M{
+		"$and": []bson.M{
+			{
+				StorageKeySoftwareImageName: artifactName,
+			},
+			{
+				StorageKeySoftwareImageDeviceTypes: bson.M{"$in": deviceTypesCompatible},
+			},
+			{
+				StorageKeySoftwareImageDeltaFrom: from,
+			},
+		},
+	}
+
+	return i.isUnique(ctx, session, query)
+}
+
+func (i *SoftwareImagesStorage) isUnique(ctx context.Context,
+	session *mgo.Session, query bson.M) (bool, error) {
+
 	var image *images.SoftwareImage
 	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
 		C(CollectionImages).Find(query).One(&image); err != nil {
@@ -302,41 +916,404 @@ func (i *SoftwareImagesStorage) IsArtifactUnique(ctx context.Context,
 }
 
 // Delete image specified by ID
-// Noop on if not found.
-func (i *SoftwareImagesStorage) Delete(ctx context.Context, id string) error {
+// Noop on if not found, false is returned then.
+// The deletion is recorded for the changes feed; true is returned along
+// with the error if the image was removed, but recording failed.
+func (i *SoftwareImagesStorage) Delete(ctx context.Context, id string) (bool, error) {
 
 	if govalidator.IsNull(id) {
-		return model.ErrSoftwareImagesStorageInvalidID
+		return false, model.ErrSoftwareImagesStorageInvalidID
 	}
 
-	session := i.session.Copy()
+	session := i.copySession(ctx)
 	defer session.Close()
 
-	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
-		C(CollectionImages).RemoveId(id); err != nil {
+	db := session.DB(store.DbFromContext(ctx, DatabaseName))
+
+	var removed images.SoftwareImage
+	if _, err := db.C(CollectionImages).FindId(id).
+		Select(bson.M{StorageKeySoftwareImageName: 1}).
+		Apply(mgo.Change{Remove: true}, &removed); err != nil {
 		if err.Error() == mgo.ErrNotFound.Error() {
-			return nil
+			return false, nil
 		}
-		return err
+		return false, err
 	}
 
-	return nil
+	if err := i.ensureIndexing(ctx, session); err != nil {
+		return true, err
+	}
+
+	if _, err := db.C(CollectionDeletedImages).UpsertId(id, bson.M{
+		"$set": bson.M{
+			StorageKeyDeletedImageName:    removed.Name,
+			StorageKeyDeletedImageDeleted: time.Now(),
+		},
+	}); err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// FindChanges lists the recently created, modified and deleted images,
+// as selected by the query.
+func (i *SoftwareImagesStorage) FindChanges(ctx context.Context,
+	query *images.ImageChangesQuery) ([]*images.ImageChange, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	db := session.DB(store.DbFromContext(ctx, DatabaseName))
+
+	// both collections are ordered by the indexed time, and at most
+	// the limit of each is listed
+	order := "-"
+	modified := bson.M{}
+	deleted := bson.M{}
+	if query.Since != nil {
+		order = ""
+		modified[StorageKeySoftwareImageModified] = bson.M{"$gt": *query.Since}
+		deleted[StorageKeyDeletedImageDeleted] = bson.M{"$gt": *query.Since}
+	}
+
+	var list []*images.SoftwareImage
+	if err := db.C(CollectionImages).Find(modified).
+		Select(bson.M{
+			StorageKeySoftwareImageName:     1,
+			StorageKeySoftwareImageModified: 1,
+			StorageKeySoftwareImageCreated:  1,
+		}).
+		Sort(order + StorageKeySoftwareImageModified).
+		Limit(query.Limit).All(&list); err != nil &&
+		err.Error() != mgo.ErrNotFound.Error() {
+		return nil, err
+	}
+
+	var removed []struct {
+		Id      string    `bson:"_id"`
+		Name    string    `bson:"name"`
+		Deleted time.Time `bson:"deleted"`
+	}
+	if err := db.C(CollectionDeletedImages).Find(deleted).
+		Sort(order + StorageKeyDeletedImageDeleted).
+		Limit(query.Limit).All(&removed); err != nil &&
+		err.Error() != mgo.ErrNotFound.Error() {
+		return nil, err
+	}
+
+	changes := make([]*images.ImageChange, 0, len(list)+len(removed))
+	for _, image := range list {
+		change := &images.ImageChange{
+			Id:     image.Id,
+			Name:   image.Name,
+			Change: images.ChangeOf(image),
+		}
+		if image.Modified != nil {
+			change.Timestamp = *image.Modified
+		}
+		changes = append(changes, change)
+	}
+	for _, r := range removed {
+		changes = append(changes, &images.ImageChange{
+			Id:        r.Id,
+			Name:      r.Name,
+			Change:    images.ImageChangeDeleted,
+			Timestamp: r.Deleted,
+		})
+	}
+
+	sort.SliceStable(changes, func(a, b int) bool {
+		if query.Since != nil {
+			return changes[a].Timestamp.Before(changes[b].Timestamp)
+		}
+		return changes[a].Timestamp.After(changes[b].Timestamp)
+	})
+	if len(changes) > query.Limit {
+		changes = changes[:query.Limit]
+	}
+
+	return changes, nil
 }
 
-// FindAll lists all images
+// FindAll lists all images which have not expired
 func (i *SoftwareImagesStorage) FindAll(ctx context.Context) ([]*images.SoftwareImage, error) {
 
-	session := i.session.Copy()
+	session := i.copySession(ctx)
 	defer session.Close()
 
 	var images []*images.SoftwareImage
 	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
-		C(CollectionImages).Find(nil).All(&images); err != nil {
+		C(CollectionImages).Find(notExpired(time.Now())).All(&images); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return images, nil
+		}
+		return nil, err
+	}
+
+	upgradeImages(images)
+	return images, nil
+}
+
+// CountDeviceTypes lists the device types images are compatible with,
+// with the number of images for each type, ordered by device type.
+func (i *SoftwareImagesStorage) CountDeviceTypes(ctx context.Context) ([]*images.DeviceTypeCount, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	unwind := bson.M{
+		"$unwind": "$" + StorageKeySoftwareImageDeviceTypes,
+	}
+	group := bson.M{
+		"$group": bson.M{
+			"_id": "$" + StorageKeySoftwareImageDeviceTypes,
+			"count": bson.M{
+				"$sum": 1,
+			},
+		},
+	}
+	sort := bson.M{
+		"$sort": bson.M{
+			"_id": 1,
+		},
+	}
+	pipe := []bson.M{
+		unwind,
+		group,
+		sort,
+	}
+
+	var results []*images.DeviceTypeCount
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).Pipe(&pipe).All(&results)
+	if err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return results, nil
+		}
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// FindExpired lists images which expired by the given time
+func (i *SoftwareImagesStorage) FindExpired(ctx context.Context,
+	now time.Time) ([]*images.SoftwareImage, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeySoftwareImageExpiresAt: bson.M{"$lte": now},
+	}
+
+	var images []*images.SoftwareImage
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).Find(query).All(&images); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return images, nil
+		}
+		return nil, err
+	}
+
+	upgradeImages(images)
+	return images, nil
+}
+
+// FindPending lists pending images, which artifact file is not uploaded yet,
+// created before the given time
+func (i *SoftwareImagesStorage) FindPending(ctx context.Context,
+	created time.Time) ([]*images.SoftwareImage, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeySoftwareImageStatus:  images.ImageStatusPending,
+		StorageKeySoftwareImageCreated: bson.M{"$lt": created},
+	}
+
+	var images []*images.SoftwareImage
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).Find(query).All(&images); err != nil {
 		if err.Error() == mgo.ErrNotFound.Error() {
 			return images, nil
 		}
 		return nil, err
 	}
 
+	upgradeImages(images)
 	return images, nil
 }
+
+// notExpired selects the images which have no expiry time
+// or expire after the given time.
+func notExpired(now time.Time) bson.M {
+	return bson.M{
+		"$or": []bson.M{
+			{StorageKeySoftwareImageExpiresAt: bson.M{"$exists": false}},
+			{StorageKeySoftwareImageExpiresAt: bson.M{"$gt": now}},
+		},
+	}
+}
+
+// Find lists images matching the filter, in the filter sort order;
+// expired images are not listed
+func (i *SoftwareImagesStorage) Find(ctx context.Context,
+	filter *images.ImagesFilter) ([]*images.SoftwareImage, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	query := notExpired(time.Now())
+	id := bson.M{}
+	if len(filter.IDs) > 0 {
+		id["$in"] = filter.IDs
+	}
+	if filter.After != "" {
+		id["$gt"] = filter.After
+	}
+	if len(id) > 0 {
+		query[StorageKeySoftwareImageId] = id
+	}
+	if filter.Name != "" {
+		query[StorageKeySoftwareImageName] = filter.Name
+	}
+	if filter.SharedWith != "" {
+		query[StorageKeySoftwareImageSharedWith] = filter.SharedWith
+	}
+	if len(filter.Tags) > 0 {
+		query[StorageKeySoftwareImageTags] = bson.M{"$all": filter.Tags}
+	}
+	if filter.DeviceType != "" {
+		query[StorageKeySoftwareImageDeviceTypes] = filter.DeviceType
+	}
+	if filter.Checksum != "" {
+		query[StorageKeySoftwareImageChecksum] = filter.Checksum
+	}
+	for key, value := range filter.Metadata {
+		query[StorageKeySoftwareImageMetadata+"."+key] = value
+	}
+	if filter.MinSize > 0 || filter.MaxSize > 0 {
+		size := bson.M{}
+		if filter.MinSize > 0 {
+			size["$gte"] = filter.MinSize
+		}
+		if filter.MaxSize > 0 {
+			size["$lte"] = filter.MaxSize
+		}
+		query[StorageKeySoftwareImageSize] = size
+	}
+
+	coll := session.DB(store.DbFromContext(ctx, DatabaseName)).C(CollectionImages)
+
+	var latestIDs []string
+	if filter.LatestPerDeviceType {
+		var err error
+		latestIDs, err = latestPerDeviceType(coll, query, filter.DeviceType)
+		if err != nil {
+			return nil, err
+		}
+		query = bson.M{StorageKeySoftwareImageId: bson.M{"$in": latestIDs}}
+	}
+
+	q := coll.Find(query)
+	if filter.Fields != nil {
+		q = q.Select(listProjection(filter.Fields))
+	}
+	switch filter.Sort {
+	case images.SortBySizeAsc:
+		q = q.Sort(StorageKeySoftwareImageSize)
+	case images.SortBySizeDesc:
+		q = q.Sort("-" + StorageKeySoftwareImageSize)
+	}
+	// the pages are ordered by ID, which does not change
+	if filter.Limit > 0 {
+		q = q.Sort(StorageKeySoftwareImageId).Limit(filter.Limit)
+	}
+
+	var list []*images.SoftwareImage
+	if err := q.All(&list); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return list, nil
+		}
+		return nil, err
+	}
+	// the fields not selected are left empty
+	if filter.Fields == nil {
+		upgradeImages(list)
+	}
+
+	if filter.LatestPerDeviceType && filter.Sort == "" {
+		// keep the device type order
+		byID := make(map[string]*images.SoftwareImage, len(list))
+		for _, image := range list {
+			byID[image.Id] = image
+		}
+		list = list[:0]
+		for _, id := range latestIDs {
+			if image, ok := byID[id]; ok {
+				list = append(list, image)
+			}
+		}
+	}
+
+	return list, nil
+}
+
+// listProjection selects the fields to list, always including the id
+func listProjection(fields []string) bson.M {
+	projection := bson.M{StorageKeySoftwareImageId: 1}
+	for _, field := range fields {
+		if key, ok := listFieldKeys[field]; ok {
+			projection[key] = 1
+		}
+	}
+	return projection
+}
+
+// latestPerDeviceType groups the images matching the query by the compatible
+// device types, or the given one only, and returns IDs of the most recently
+// modified image of each group, ordered by device type. Image which is
+// the latest for multiple device types is returned once.
+func latestPerDeviceType(coll *mgo.Collection, query bson.M,
+	deviceType string) ([]string, error) {
+
+	pipe := []bson.M{
+		{"$match": query},
+		{"$sort": bson.M{StorageKeySoftwareImageModified: -1}},
+		{"$unwind": "$" + StorageKeySoftwareImageDeviceTypes},
+	}
+	if deviceType != "" {
+		pipe = append(pipe, bson.M{
+			"$match": bson.M{StorageKeySoftwareImageDeviceTypes: deviceType},
+		})
+	}
+	pipe = append(pipe,
+		bson.M{"$group": bson.M{
+			"_id":   "$" + StorageKeySoftwareImageDeviceTypes,
+			"image": bson.M{"$first": "$" + StorageKeySoftwareImageId},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	)
+
+	var groups []struct {
+		DeviceType string `bson:"_id"`
+		Image      string `bson:"image"`
+	}
+	if err := coll.Pipe(&pipe).All(&groups); err != nil &&
+		err.Error() != mgo.ErrNotFound.Error() {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(groups))
+	seen := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		if !seen[group.Image] {
+			seen[group.Image] = true
+			ids = append(ids, group.Image)
+		}
+	}
+	return ids, nil
+}
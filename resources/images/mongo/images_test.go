@@ -16,10 +16,16 @@ package mongo_test
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/mendersoftware/go-lib-micro/identity"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
 
 	"github.com/mendersoftware/deployments/resources/images"
 	model "github.com/mendersoftware/deployments/resources/images/model"
@@ -260,3 +266,1079 @@ func TestIsArtifactUnique(t *testing.T) {
 	}
 
 }
+
+func TestDeltaImages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeltaImages in short mode.")
+	}
+
+	//image dataset - common for all cases
+	inputImgs := []interface{}{
+		&images.SoftwareImage{
+			Id: "1",
+			SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+				Name:                  "app1-v2.0",
+				DeviceTypesCompatible: []string{"foo", "bar"},
+				Updates:               []images.Update{},
+			},
+		},
+		&images.SoftwareImage{
+			Id: "2",
+			SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+				Name:                  "app1-v2.0",
+				DeviceTypesCompatible: []string{"foo", "bar"},
+				Updates:               []images.Update{},
+			},
+			Delta: &images.DeltaUpdate{
+				From: "app1-v1.0",
+				To:   "app1-v2.0",
+			},
+		},
+	}
+
+	//setup db - common for all cases
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	store := NewSoftwareImagesStorage(session)
+	assert.NoError(t, store.DoEnsureIndexing(DatabaseName, session))
+
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	assert.NoError(t, coll.Insert(inputImgs...))
+
+	ctx := context.Background()
+
+	// full artifact lookups ignore the delta
+	img, err := store.ImageByNameAndDeviceType(ctx, "app1-v2.0", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", img.Id)
+
+	img, err = store.DeltaImageByNameAndDeviceType(ctx, "app1-v1.0", "app1-v2.0", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", img.Id)
+
+	img, err = store.DeltaImageByNameAndDeviceType(ctx, "app1-v0.9", "app1-v2.0", "foo")
+	assert.NoError(t, err)
+	assert.Nil(t, img)
+
+	isUnique, err := store.IsDeltaUnique(ctx, "app1-v1.0", "app1-v2.0", []string{"bar", "baz"})
+	assert.NoError(t, err)
+	assert.False(t, isUnique)
+
+	isUnique, err = store.IsDeltaUnique(ctx, "app1-v0.9", "app1-v2.0", []string{"bar", "baz"})
+	assert.NoError(t, err)
+	assert.True(t, isUnique)
+
+	_, err = store.IsDeltaUnique(ctx, "", "app1-v2.0", []string{"bar"})
+	assert.EqualError(t, err, model.ErrSoftwareImagesStorageInvalidArtifactName.Error())
+}
+
+func TestPendingImages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestPendingImages in short mode.")
+	}
+
+	created := time.Now().Add(-time.Hour).UTC().Truncate(time.Millisecond)
+	inputImgs := []interface{}{
+		&images.SoftwareImage{
+			Id: "1",
+			SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+				Name:                  "app1-v1.0",
+				DeviceTypesCompatible: []string{"foo"},
+				Updates:               []images.Update{},
+			},
+			Status:  images.ImageStatusPending,
+			Created: &created,
+		},
+		&images.SoftwareImage{
+			Id: "2",
+			SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+				Name:                  "app1-v2.0",
+				DeviceTypesCompatible: []string{"foo"},
+				Updates:               []images.Update{},
+			},
+			Status:  images.ImageStatusReady,
+			Created: &created,
+		},
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	store := NewSoftwareImagesStorage(session)
+	assert.NoError(t, store.DoEnsureIndexing(DatabaseName, session))
+
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	assert.NoError(t, coll.Insert(inputImgs...))
+
+	ctx := context.Background()
+
+	// pending artifacts can't be deployed
+	img, err := store.ImageByNameAndDeviceType(ctx, "app1-v1.0", "foo")
+	assert.NoError(t, err)
+	assert.Nil(t, img)
+
+	imgs, err := store.ImagesByName(ctx, "app1-v1.0")
+	assert.NoError(t, err)
+	assert.Len(t, imgs, 0)
+
+	img, err = store.ImageByNameAndDeviceType(ctx, "app1-v2.0", "foo")
+	assert.NoError(t, err)
+	if assert.NotNil(t, img) {
+		assert.Equal(t, "2", img.Id)
+	}
+
+	// but are still listed
+	img, err = store.FindByID(ctx, "1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, img) {
+		assert.True(t, img.IsPending())
+	}
+
+	// by creation time too
+	imgs, err = store.FindPending(ctx, time.Now())
+	assert.NoError(t, err)
+	if assert.Len(t, imgs, 1) {
+		assert.Equal(t, "1", imgs[0].Id)
+	}
+
+	imgs, err = store.FindPending(ctx, created)
+	assert.NoError(t, err)
+	assert.Len(t, imgs, 0)
+}
+
+func TestIncDownloadCount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestIncDownloadCount in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	assert.NoError(t, coll.Insert(&images.SoftwareImage{
+		Id: "1",
+		SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+			Name:                  "app1-v1.0",
+			DeviceTypesCompatible: []string{"foo"},
+			Updates:               []images.Update{},
+		},
+	}))
+
+	ctx := context.Background()
+	store := NewSoftwareImagesStorage(session)
+
+	first := time.Now().Add(-time.Hour).Round(time.Millisecond)
+	last := time.Now().Round(time.Millisecond)
+	assert.NoError(t, store.IncDownloadCount(ctx, "1", first))
+	assert.NoError(t, store.IncDownloadCount(ctx, "1", last))
+
+	// removed image is not an error
+	assert.NoError(t, store.IncDownloadCount(ctx, "2", last))
+
+	assert.EqualError(t, store.IncDownloadCount(ctx, "", last),
+		model.ErrSoftwareImagesStorageInvalidID.Error())
+
+	img, err := store.FindByID(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), img.DownloadCount)
+	if assert.NotNil(t, img.LastDownloaded) {
+		assert.True(t, last.Equal(*img.LastDownloaded))
+	}
+}
+
+func TestAddReplica(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestAddReplica in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	assert.NoError(t, coll.Insert(&images.SoftwareImage{
+		Id: "1",
+		SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+			Name:                  "app1-v1.0",
+			DeviceTypesCompatible: []string{"foo"},
+			Updates:               []images.Update{},
+		},
+	}))
+
+	ctx := context.Background()
+	store := NewSoftwareImagesStorage(session)
+
+	assert.NoError(t, store.AddReplica(ctx, "1", "eu-west-1"))
+	assert.NoError(t, store.AddReplica(ctx, "1", "us-east-1"))
+	// recorded once
+	assert.NoError(t, store.AddReplica(ctx, "1", "eu-west-1"))
+
+	// removed image is not an error
+	assert.NoError(t, store.AddReplica(ctx, "2", "eu-west-1"))
+
+	assert.EqualError(t, store.AddReplica(ctx, "", "eu-west-1"),
+		model.ErrSoftwareImagesStorageInvalidID.Error())
+
+	img, err := store.FindByID(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eu-west-1", "us-east-1"}, img.Replicas)
+}
+
+func TestSetFileVersion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSetFileVersion in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	assert.NoError(t, coll.Insert(&images.SoftwareImage{
+		Id: "1",
+		SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+			Name:                  "app1-v1.0",
+			DeviceTypesCompatible: []string{"foo"},
+			Updates:               []images.Update{},
+		},
+	}))
+
+	ctx := context.Background()
+	store := NewSoftwareImagesStorage(session)
+
+	assert.NoError(t, store.SetFileVersion(ctx, "1", "", "v1", false))
+	assert.NoError(t, store.SetFileVersion(ctx, "1", "eu-west-1", "r1", false))
+	// looked up version does not overwrite the recorded one
+	assert.NoError(t, store.SetFileVersion(ctx, "1", "", "v0", false))
+
+	img, err := store.FindByID(ctx, "1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, img.FileVersion) {
+		assert.Equal(t, "v1", *img.FileVersion)
+	}
+	assert.Equal(t, map[string]string{"eu-west-1": "r1"}, img.ReplicaVersions)
+
+	// rotated version does
+	assert.NoError(t, store.SetFileVersion(ctx, "1", "", "v2", true))
+	img, err = store.FindByID(ctx, "1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, img.FileVersion) {
+		assert.Equal(t, "v2", *img.FileVersion)
+	}
+
+	// removed image is not an error
+	assert.NoError(t, store.SetFileVersion(ctx, "2", "", "v1", true))
+
+	assert.EqualError(t, store.SetFileVersion(ctx, "", "", "v1", true),
+		model.ErrSoftwareImagesStorageInvalidID.Error())
+}
+
+func TestSetState(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSetState in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	// recorded before the states were introduced
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	assert.NoError(t, coll.Insert(&images.SoftwareImage{
+		Id: "1",
+		SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+			Name:                  "app1-v1.0",
+			DeviceTypesCompatible: []string{"foo"},
+			Updates:               []images.Update{},
+		},
+	}))
+
+	ctx := context.Background()
+	store := NewSoftwareImagesStorage(session)
+
+	modified := time.Now().Round(time.Millisecond)
+
+	// not in the expected state
+	changed, err := store.SetState(ctx, "1", images.ImageStateApproved,
+		images.ImageStateDeprecated, "user-1", modified)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	changed, err = store.SetState(ctx, "1", images.ImageStateUploaded,
+		images.ImageStateApproved, "user-1", modified)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	// already changed
+	changed, err = store.SetState(ctx, "1", images.ImageStateUploaded,
+		images.ImageStateApproved, "user-2", modified)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// not found
+	changed, err = store.SetState(ctx, "2", images.ImageStateUploaded,
+		images.ImageStateApproved, "user-1", modified)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = store.SetState(ctx, "", images.ImageStateUploaded,
+		images.ImageStateApproved, "user-1", modified)
+	assert.EqualError(t, err, model.ErrSoftwareImagesStorageInvalidID.Error())
+
+	img, err := store.FindByID(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, images.ImageStateApproved, img.State)
+	assert.Equal(t, "user-1", img.StateModifiedBy)
+	if assert.NotNil(t, img.StateModified) {
+		assert.True(t, modified.Equal(*img.StateModified))
+	}
+}
+
+func TestSetScanResult(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSetScanResult in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	for _, name := range []string{"app1-v1.0", "app1-v2.0"} {
+		assert.NoError(t, coll.Insert(&images.SoftwareImage{
+			Id: name,
+			SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+				Name:                  name,
+				DeviceTypesCompatible: []string{"foo"},
+				Updates:               []images.Update{},
+			},
+			Status: images.ImageStatusScanning,
+		}))
+	}
+
+	ctx := context.Background()
+	store := NewSoftwareImagesStorage(session)
+
+	changed, err := store.SetScanResult(ctx, "app1-v1.0", images.ImageStatusQuarantined,
+		images.NewArtifactScan("Eicar-Signature", nil))
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	// scanned already
+	changed, err = store.SetScanResult(ctx, "app1-v1.0", images.ImageStatusReady,
+		images.NewArtifactScan("", nil))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// not found
+	changed, err = store.SetScanResult(ctx, "app1-v3.0", images.ImageStatusReady,
+		images.NewArtifactScan("", nil))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = store.SetScanResult(ctx, "", images.ImageStatusReady,
+		images.NewArtifactScan("", nil))
+	assert.EqualError(t, err, model.ErrSoftwareImagesStorageInvalidID.Error())
+
+	img, err := store.FindByID(ctx, "app1-v1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, images.ImageStatusQuarantined, img.Status)
+	if assert.NotNil(t, img.Scan) {
+		assert.Equal(t, "Eicar-Signature", img.Scan.Threat)
+	}
+
+	// quarantined and being scanned artifacts can't be deployed
+	for _, name := range []string{"app1-v1.0", "app1-v2.0"} {
+		img, err = store.ImageByNameAndDeviceType(ctx, name, "foo")
+		assert.NoError(t, err)
+		assert.Nil(t, img)
+	}
+}
+
+func TestSetChecksums(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSetChecksums in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	assert.NoError(t, coll.Insert(&images.SoftwareImage{
+		Id: "1",
+		SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+			Name:                  "app1-v1.0",
+			DeviceTypesCompatible: []string{"foo"},
+			Updates:               []images.Update{},
+		},
+		Checksums: images.Checksums{
+			images.ChecksumMD5: "d41d8cd98f00b204e9800998ecf8427e",
+		},
+	}))
+
+	ctx := context.Background()
+	store := NewSoftwareImagesStorage(session)
+
+	checksums := images.Checksums{
+		images.ChecksumMD5:    "d41d8cd98f00b204e9800998ecf8427e",
+		images.ChecksumSHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}
+	changed, err := store.SetChecksums(ctx, "1", checksums)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	// not found
+	changed, err = store.SetChecksums(ctx, "2", checksums)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = store.SetChecksums(ctx, "", checksums)
+	assert.EqualError(t, err, model.ErrSoftwareImagesStorageInvalidID.Error())
+
+	img, err := store.FindByID(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, checksums, img.Checksums)
+	assert.Equal(t, checksums[images.ChecksumSHA256], img.Checksum)
+}
+
+func TestUpdateTags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestUpdateTags in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	fullTags := make([]string, images.MaxTagsCount)
+	for i := range fullTags {
+		fullTags[i] = "tag-" + strconv.Itoa(i)
+	}
+
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	for _, image := range []struct {
+		id          string
+		deviceTypes []string
+		tags        []string
+	}{
+		{id: "1", deviceTypes: []string{"foo"}},
+		{id: "2", deviceTypes: []string{"foo", "bar"}, tags: []string{"stable"}},
+		{id: "3", deviceTypes: []string{"bar"}},
+		{id: "4", deviceTypes: []string{"foo"}, tags: fullTags},
+	} {
+		assert.NoError(t, coll.Insert(&images.SoftwareImage{
+			Id: image.id,
+			SoftwareImageMetaConstructor: images.SoftwareImageMetaConstructor{
+				Tags: image.tags,
+			},
+			SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+				Name:                  "app-" + image.id,
+				DeviceTypesCompatible: image.deviceTypes,
+				Updates:               []images.Update{},
+			},
+		}))
+	}
+
+	ctx := context.Background()
+	store := NewSoftwareImagesStorage(session)
+
+	modified := time.Now().Round(time.Millisecond)
+
+	// already tagged and full images are not modified
+	count, err := store.AddTag(ctx, &images.TagsUpdate{
+		Tag:        "stable",
+		DeviceType: "foo",
+	}, modified)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	count, err = store.AddTag(ctx, &images.TagsUpdate{
+		Tag: "stable",
+		IDs: []string{"3", "5"},
+	}, modified)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	tags := func(id string) []string {
+		img, err := store.FindByID(ctx, id)
+		assert.NoError(t, err)
+		return img.Tags
+	}
+	assert.Equal(t, []string{"stable"}, tags("1"))
+	assert.Equal(t, []string{"stable"}, tags("2"))
+	assert.Equal(t, []string{"stable"}, tags("3"))
+	assert.Equal(t, fullTags, tags("4"))
+
+	img, err := store.FindByID(ctx, "1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, img.Modified) {
+		assert.True(t, modified.Equal(*img.Modified))
+	}
+
+	// both IDs and device type have to match
+	count, err = store.RemoveTag(ctx, &images.TagsUpdate{
+		Tag:        "stable",
+		IDs:        []string{"1", "2", "3"},
+		DeviceType: "bar",
+	}, modified)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	assert.Equal(t, []string{"stable"}, tags("1"))
+	assert.Empty(t, tags("2"))
+	assert.Empty(t, tags("3"))
+
+	// not tagged
+	count, err = store.RemoveTag(ctx, &images.TagsUpdate{
+		Tag: "stable",
+		IDs: []string{"2"},
+	}, modified)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestFailedDeletions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestFailedDeletions in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := context.Background()
+	store := NewSoftwareImagesStorage(session)
+
+	now := time.Now().Round(time.Millisecond)
+	first := images.NewFailedDeletion("", "1", errors.New("timeout"), now.Add(-time.Hour))
+	second := images.NewFailedDeletion("eu-west-1", "1", errors.New("timeout"), now)
+	later := images.NewFailedDeletion("", "2", errors.New("timeout"), now)
+	later.RetryAt = now.Add(time.Hour)
+
+	for _, d := range []*images.FailedDeletion{second, first, later} {
+		assert.NoError(t, store.SaveFailedDeletion(ctx, d))
+	}
+
+	count, err := store.CountFailedDeletions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// due ones, the longest overdue first
+	due, err := store.FindFailedDeletions(ctx, now)
+	assert.NoError(t, err)
+	if assert.Len(t, due, 2) {
+		assert.Equal(t, first.Id, due[0].Id)
+		assert.Equal(t, second.Id, due[1].Id)
+		assert.Equal(t, "eu-west-1", due[1].Region)
+	}
+
+	// failed again
+	first.Retried(errors.New("access denied"), now, 90*time.Minute)
+	assert.NoError(t, store.SaveFailedDeletion(ctx, first))
+
+	due, err = store.FindFailedDeletions(ctx, now)
+	assert.NoError(t, err)
+	if assert.Len(t, due, 1) {
+		assert.Equal(t, second.Id, due[0].Id)
+	}
+
+	due, err = store.FindFailedDeletions(ctx, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	if assert.Len(t, due, 3) {
+		assert.Equal(t, first.Id, due[2].Id)
+		assert.Equal(t, 2, due[2].Attempts)
+		assert.Equal(t, "access denied", due[2].Error)
+	}
+
+	// removed, also the missing one
+	assert.NoError(t, store.DeleteFailedDeletion(ctx, first.Id))
+	assert.NoError(t, store.DeleteFailedDeletion(ctx, first.Id))
+
+	count, err = store.CountFailedDeletions(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestSharedImages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSharedImages in short mode.")
+	}
+
+	image := func(id, name string, sharedWith ...string) *images.SoftwareImage {
+		return &images.SoftwareImage{
+			Id: id,
+			SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+				Name:                  name,
+				DeviceTypesCompatible: []string{"foo"},
+				Updates:               []images.Update{},
+			},
+			SharedWith: sharedWith,
+		}
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	store := NewSoftwareImagesStorage(session)
+
+	parentCtx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "parent"})
+	childCtx := identity.WithContext(context.Background(),
+		&identity.Identity{Tenant: "child"})
+
+	assert.NoError(t, session.DB(DatabaseName+"-parent").C(CollectionImages).Insert(
+		image("1", "app1-v1.0", "child"),
+		image("2", "app1-v2.0", "child", "other"),
+		image("3", "app1-v3.0", "other"),
+	))
+	assert.NoError(t, session.DB(DatabaseName+"-child").C(CollectionImages).Insert(
+		image("4", "app2-v1.0"),
+	))
+	for _, id := range []string{"1", "2", "3"} {
+		assert.NoError(t, store.SaveSharedImage(childCtx,
+			&images.SharedImage{Id: id, Owner: "parent"}))
+	}
+	// saved again
+	assert.NoError(t, store.SaveSharedImage(childCtx,
+		&images.SharedImage{Id: "1", Owner: "parent"}))
+
+	// the record of the image not shared with the tenant is ignored
+	shared, err := store.FindShared(childCtx, &images.ImagesFilter{})
+	assert.NoError(t, err)
+	if assert.Len(t, shared, 2) {
+		for _, img := range shared {
+			assert.Equal(t, "parent", img.Owner)
+			assert.Empty(t, img.SharedWith)
+			assert.Equal(t, images.ObjectKey("parent", img.Id), img.ObjectKey)
+		}
+	}
+
+	shared, err = store.FindShared(childCtx, &images.ImagesFilter{IDs: []string{"2", "4"}})
+	assert.NoError(t, err)
+	if assert.Len(t, shared, 1) {
+		assert.Equal(t, "2", shared[0].Id)
+	}
+
+	// shared images can be deployed
+	imgs, err := store.ImagesByName(childCtx, "app1-v1.0")
+	assert.NoError(t, err)
+	if assert.Len(t, imgs, 1) {
+		assert.Equal(t, "1", imgs[0].Id)
+	}
+	img, err := store.ImageByIdsAndDeviceType(childCtx, []string{"2"}, "foo")
+	assert.NoError(t, err)
+	if assert.NotNil(t, img) {
+		assert.Equal(t, "parent", img.Owner)
+	}
+
+	// no longer shared
+	found, err := store.SetSharedWith(parentCtx, "1", nil)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.NoError(t, store.DeleteSharedImage(childCtx, "1"))
+	assert.NoError(t, store.DeleteSharedImage(childCtx, "1"))
+
+	shared, err = store.FindShared(childCtx, &images.ImagesFilter{})
+	assert.NoError(t, err)
+	if assert.Len(t, shared, 1) {
+		assert.Equal(t, "2", shared[0].Id)
+	}
+
+	img, err = store.FindByID(parentCtx, "1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, img) {
+		assert.Empty(t, img.SharedWith)
+	}
+
+	found, err = store.SetSharedWith(parentCtx, "5", []string{"child"})
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// nothing shared without the tenant
+	shared, err = store.FindShared(context.Background(), &images.ImagesFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, shared)
+}
+
+func TestUsage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestUsage in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := context.Background()
+	store := NewSoftwareImagesStorage(session)
+
+	// nothing counted yet
+	usage, err := store.GetUsage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &images.Usage{}, usage)
+
+	// concurrent changes are all counted
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, store.IncUsage(ctx, 1, 100))
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, store.IncUsage(ctx, -1, -100))
+
+	usage, err = store.GetUsage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &images.Usage{Artifacts: 9, Size: 900}, usage)
+
+	// reconciled with the stored images, also the ones without size
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	for id, size := range map[string]int64{"1": 10, "2": 20, "3": 0} {
+		assert.NoError(t, coll.Insert(&images.SoftwareImage{
+			Id: id,
+			SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+				Name:                  "app-" + id,
+				DeviceTypesCompatible: []string{"foo"},
+				Updates:               []images.Update{},
+			},
+			Size: size,
+		}))
+	}
+
+	usage, err = store.ReconcileUsage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &images.Usage{Artifacts: 3, Size: 30}, usage)
+
+	usage, err = store.GetUsage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &images.Usage{Artifacts: 3, Size: 30}, usage)
+
+	// no images
+	assert.NoError(t, coll.DropCollection())
+	usage, err = store.ReconcileUsage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &images.Usage{}, usage)
+}
+
+func TestReserveArtifact(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestReserveArtifact in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := context.Background()
+	store := NewSoftwareImagesStorage(session)
+
+	// concurrent reservations don't overshoot the limit
+	var wg sync.WaitGroup
+	var reserved int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := store.ReserveArtifact(ctx, 3)
+			assert.NoError(t, err)
+			if ok {
+				atomic.AddInt32(&reserved, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), reserved)
+
+	usage, err := store.GetUsage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &images.Usage{Artifacts: 3}, usage)
+
+	// released slot can be reserved again
+	assert.NoError(t, store.IncUsage(ctx, -1, 0))
+	ok, err := store.ReserveArtifact(ctx, 3)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = store.ReserveArtifact(ctx, 3)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestFindImages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestFindImages in short mode.")
+	}
+
+	newImage := func(id string, size int64, deviceType string, tags ...string) interface{} {
+		return &images.SoftwareImage{
+			Id: id,
+			SoftwareImageMetaConstructor: images.SoftwareImageMetaConstructor{
+				Tags: tags,
+			},
+			SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+				Name:                  "app-" + id,
+				DeviceTypesCompatible: []string{deviceType},
+				Updates:               []images.Update{},
+			},
+			Size: size,
+		}
+	}
+
+	//setup db - common for all cases
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	assert.NoError(t, coll.Insert(
+		newImage("1", 1024, "foo", "stable"),
+		newImage("2", 3*1024*1024*1024, "foo"),
+		newImage("3", 4*1024*1024*1024, "bar", "stable"),
+		// uploaded before the size was recorded
+		newImage("4", 0, "foo"),
+	))
+	assert.NoError(t, coll.UpdateId("3", bson.M{"$set": bson.M{
+		StorageKeySoftwareImageMetadata: bson.M{
+			"git_sha":   "abc",
+			"build_url": "https://ci/42",
+		},
+	}}))
+	assert.NoError(t, coll.UpdateId("2", bson.M{"$set": bson.M{
+		StorageKeySoftwareImageChecksum: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}}))
+
+	testCases := map[string]struct {
+		filter images.ImagesFilter
+		ids    []string
+	}{
+		"over 2GB, biggest first": {
+			filter: images.ImagesFilter{
+				MinSize: 2 * 1024 * 1024 * 1024,
+				Sort:    images.SortBySizeDesc,
+			},
+			ids: []string{"3", "2"},
+		},
+		"over 2GB, device type": {
+			filter: images.ImagesFilter{
+				MinSize:    2 * 1024 * 1024 * 1024,
+				DeviceType: "foo",
+			},
+			ids: []string{"2"},
+		},
+		"at most 1MB": {
+			filter: images.ImagesFilter{
+				MaxSize: 1024 * 1024,
+			},
+			ids: []string{"1"},
+		},
+		"tags, smallest first": {
+			filter: images.ImagesFilter{
+				Tags: []string{"stable"},
+				Sort: images.SortBySizeAsc,
+			},
+			ids: []string{"1", "3"},
+		},
+		"custom metadata": {
+			filter: images.ImagesFilter{
+				Metadata: map[string]string{"git_sha": "abc"},
+			},
+			ids: []string{"3"},
+		},
+		"checksum": {
+			filter: images.ImagesFilter{
+				Checksum: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			},
+			ids: []string{"2"},
+		},
+		"custom metadata, not all matching": {
+			filter: images.ImagesFilter{
+				Metadata: map[string]string{"git_sha": "abc", "build_url": "https://ci/43"},
+			},
+		},
+	}
+
+	store := NewSoftwareImagesStorage(session)
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			list, err := store.Find(context.Background(), &tc.filter)
+			assert.NoError(t, err)
+
+			var ids []string
+			for _, img := range list {
+				ids = append(ids, img.Id)
+			}
+			assert.Equal(t, tc.ids, ids)
+		})
+	}
+
+	t.Run("selected fields", func(t *testing.T) {
+		list, err := store.Find(context.Background(), &images.ImagesFilter{
+			Tags:   []string{"stable"},
+			Sort:   images.SortBySizeAsc,
+			Fields: []string{"name", "size"},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, list, 2)
+
+		assert.Equal(t, "1", list[0].Id)
+		assert.Equal(t, "app-1", list[0].Name)
+		assert.Equal(t, int64(1024), list[0].Size)
+		assert.Nil(t, list[0].Tags)
+		assert.Nil(t, list[0].DeviceTypesCompatible)
+		assert.Nil(t, list[0].Updates)
+	})
+}
+
+func TestFindLatestPerDeviceType(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestFindLatestPerDeviceType in short mode.")
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	newImage := func(id string, age time.Duration, size int64,
+		deviceTypes []string, tags ...string) interface{} {
+		modified := now.Add(-age)
+		return &images.SoftwareImage{
+			Id: id,
+			SoftwareImageMetaConstructor: images.SoftwareImageMetaConstructor{
+				Tags: tags,
+			},
+			SoftwareImageMetaArtifactConstructor: images.SoftwareImageMetaArtifactConstructor{
+				Name:                  "app-" + id,
+				DeviceTypesCompatible: deviceTypes,
+				Updates:               []images.Update{},
+			},
+			Modified: &modified,
+			Size:     size,
+		}
+	}
+
+	//setup db - common for all cases
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	assert.NoError(t, coll.Insert(
+		newImage("1", 3*time.Hour, 1024, []string{"foo"}, "stable"),
+		newImage("2", 2*time.Hour, 4096, []string{"foo", "bar"}, "stable"),
+		newImage("3", time.Hour, 2048, []string{"foo"}),
+		newImage("4", 4*time.Hour, 512, []string{"baz"}, "stable"),
+	))
+
+	testCases := map[string]struct {
+		filter images.ImagesFilter
+		ids    []string
+	}{
+		"all": {
+			filter: images.ImagesFilter{LatestPerDeviceType: true},
+			// bar, baz, foo
+			ids: []string{"2", "4", "3"},
+		},
+		"tags": {
+			filter: images.ImagesFilter{
+				Tags:                []string{"stable"},
+				LatestPerDeviceType: true,
+			},
+			// the latest one for bar and foo is listed once
+			ids: []string{"2", "4"},
+		},
+		"device type": {
+			filter: images.ImagesFilter{
+				DeviceType:          "foo",
+				LatestPerDeviceType: true,
+			},
+			ids: []string{"3"},
+		},
+		"smallest first": {
+			filter: images.ImagesFilter{
+				LatestPerDeviceType: true,
+				Sort:                images.SortBySizeAsc,
+			},
+			ids: []string{"4", "3", "2"},
+		},
+	}
+
+	store := NewSoftwareImagesStorage(session)
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			list, err := store.Find(context.Background(), &tc.filter)
+			assert.NoError(t, err)
+
+			var ids []string
+			for _, img := range list {
+				ids = append(ids, img.Id)
+			}
+			assert.Equal(t, tc.ids, ids)
+		})
+	}
+}
+
+func TestFindChanges(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestFindChanges in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	created := time.Now().Add(-time.Hour).Round(time.Millisecond)
+	modified := created.Add(time.Minute)
+
+	coll := session.DB(DatabaseName).C(CollectionImages)
+	for _, image := range []*images.SoftwareImage{
+		{Id: "1", Created: &created, Modified: &created},
+		{Id: "2", Created: &created, Modified: &modified},
+		{Id: "3", Created: &created, Modified: &created},
+	} {
+		image.Name = "app-" + image.Id
+		image.DeviceTypesCompatible = []string{"foo"}
+		assert.NoError(t, coll.Insert(image))
+	}
+
+	ctx := context.Background()
+	store := NewSoftwareImagesStorage(session)
+
+	deleted, err := store.Delete(ctx, "3")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+
+	changes, err := store.FindChanges(ctx, &images.ImageChangesQuery{Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 3) {
+		assert.Equal(t, "3", changes[0].Id)
+		assert.Equal(t, "app-3", changes[0].Name)
+		assert.Equal(t, images.ImageChangeDeleted, changes[0].Change)
+		assert.Equal(t, "2", changes[1].Id)
+		assert.Equal(t, images.ImageChangeModified, changes[1].Change)
+		assert.True(t, modified.Equal(changes[1].Timestamp))
+		assert.Equal(t, "1", changes[2].Id)
+		assert.Equal(t, images.ImageChangeCreated, changes[2].Change)
+	}
+
+	changes, err = store.FindChanges(ctx, &images.ImageChangesQuery{Limit: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, "3", changes[0].Id)
+	}
+
+	// oldest first after the time
+	changes, err = store.FindChanges(ctx, &images.ImageChangesQuery{
+		Since: &created,
+		Limit: 10,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 2) {
+		assert.Equal(t, "2", changes[0].Id)
+		assert.Equal(t, "3", changes[1].Id)
+	}
+}
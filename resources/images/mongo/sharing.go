@@ -0,0 +1,162 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/store"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/model"
+)
+
+// Database
+const (
+	// Images shared with the tenant by the other tenants
+	CollectionSharedImages = "shared_images"
+)
+
+// SetSharedWith replaces the tenants the image is shared with.
+// Image modification time is not changed. Return false if not found.
+func (i *SoftwareImagesStorage) SetSharedWith(ctx context.Context, id string,
+	tenants []string) (bool, error) {
+
+	if govalidator.IsNull(id) {
+		return false, model.ErrSoftwareImagesStorageInvalidID
+	}
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	update := bson.M{"$set": bson.M{StorageKeySoftwareImageSharedWith: tenants}}
+	if len(tenants) == 0 {
+		update = bson.M{"$unset": bson.M{StorageKeySoftwareImageSharedWith: ""}}
+	}
+
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionImages).UpdateId(id, update); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SaveSharedImage records the image shared with the tenant, or replaces
+// the record of the same image.
+func (i *SoftwareImagesStorage) SaveSharedImage(ctx context.Context,
+	shared *images.SharedImage) error {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	_, err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionSharedImages).UpsertId(shared.Id, shared)
+	return err
+}
+
+// DeleteSharedImage removes the record of the image no longer shared with
+// the tenant. Missing one is not an error.
+func (i *SoftwareImagesStorage) DeleteSharedImage(ctx context.Context, id string) error {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionSharedImages).RemoveId(id)
+	if err != nil && err.Error() != mgo.ErrNotFound.Error() {
+		return err
+	}
+
+	return nil
+}
+
+// FindShared lists the images shared with the tenant by the other tenants,
+// matching the filter; the images of each owning tenant are listed together,
+// in the filter sort order. The owner is set, the tenants the images are
+// shared with are left for the owner to know.
+func (i *SoftwareImagesStorage) FindShared(ctx context.Context,
+	filter *images.ImagesFilter) ([]*images.SoftwareImage, error) {
+
+	id := identity.FromContext(ctx)
+	if id == nil || id.Tenant == "" {
+		return []*images.SoftwareImage{}, nil
+	}
+
+	shared, err := i.findSharedImages(ctx, filter.IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var owners []string
+	ids := make(map[string][]string)
+	for _, image := range shared {
+		if _, ok := ids[image.Owner]; !ok {
+			owners = append(owners, image.Owner)
+		}
+		ids[image.Owner] = append(ids[image.Owner], image.Id)
+	}
+
+	list := []*images.SoftwareImage{}
+	for _, owner := range owners {
+		// records of the images no longer shared are not matched
+		ownerFilter := *filter
+		ownerFilter.IDs = ids[owner]
+		ownerFilter.SharedWith = id.Tenant
+
+		found, err := i.Find(identity.WithContext(ctx,
+			&identity.Identity{Tenant: owner}), &ownerFilter)
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range found {
+			image.ObjectKey = image.FileObjectKey(owner)
+			image.Owner = owner
+			image.SharedWith = nil
+		}
+		list = append(list, found...)
+	}
+
+	return list, nil
+}
+
+// findSharedImages lists the records of the images shared with the tenant,
+// restricted to the IDs if any are given.
+func (i *SoftwareImagesStorage) findSharedImages(ctx context.Context,
+	ids []string) ([]*images.SharedImage, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	query := bson.M{}
+	if len(ids) > 0 {
+		query["_id"] = bson.M{"$in": ids}
+	}
+
+	shared := []*images.SharedImage{}
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionSharedImages).Find(query).All(&shared); err != nil {
+		return nil, err
+	}
+
+	return shared, nil
+}
@@ -0,0 +1,234 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/mendersoftware/go-lib-micro/store"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/mendersoftware/deployments/resources/images"
+	"github.com/mendersoftware/deployments/resources/images/model"
+)
+
+// Database KEYS
+const (
+	StorageKeyUploadId      = "_id"
+	StorageKeyUploadOffset  = "offset"
+	StorageKeyUploadParts   = "parts"
+	StorageKeyUploadExpire  = "expire"
+	StorageKeyUploadCreated = "created"
+	StorageKeyUploadStatus  = "status"
+)
+
+// Database
+const (
+	CollectionUploads = "uploads"
+)
+
+// UploadSessionsStorage is a data layer for upload sessions based on MongoDB
+// Implements model.UploadSessionsStorage
+type UploadSessionsStorage struct {
+	session *mgo.Session
+}
+
+// NewUploadSessionsStorage new data layer object
+func NewUploadSessionsStorage(session *mgo.Session) *UploadSessionsStorage {
+
+	return &UploadSessionsStorage{
+		session: session,
+	}
+}
+
+// Insert persists object
+func (u *UploadSessionsStorage) Insert(ctx context.Context,
+	upload *images.UploadSession) error {
+
+	if upload == nil {
+		return model.ErrUploadSessionsStorageInvalidSession
+	}
+
+	session := u.session.Copy()
+	defer session.Close()
+
+	return session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionUploads).Insert(upload)
+}
+
+// FindByID search storage for upload session with ID, returns nil if not found
+func (u *UploadSessionsStorage) FindByID(ctx context.Context,
+	id string) (*images.UploadSession, error) {
+
+	if govalidator.IsNull(id) {
+		return nil, model.ErrUploadSessionsStorageInvalidID
+	}
+
+	session := u.session.Copy()
+	defer session.Close()
+
+	var upload *images.UploadSession
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionUploads).FindId(id).One(&upload); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// AppendPart adds part to the upload session and moves session offset
+// past the part. Update is done only if the session offset is equal
+// to the part offset, returns false otherwise.
+func (u *UploadSessionsStorage) AppendPart(ctx context.Context,
+	id string, part images.UploadPart) (bool, error) {
+
+	if govalidator.IsNull(id) {
+		return false, model.ErrUploadSessionsStorageInvalidID
+	}
+
+	session := u.session.Copy()
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeyUploadId:     id,
+		StorageKeyUploadOffset: part.Offset,
+	}
+	update := bson.M{
+		"$inc": bson.M{
+			StorageKeyUploadOffset: part.Size,
+		},
+		"$push": bson.M{
+			StorageKeyUploadParts: part,
+		},
+	}
+
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionUploads).Update(query, update); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SetStatus changes the status of the upload session, only if the session
+// is in the from status, so that concurrent changes can't both succeed.
+// Returns false if the session is not found or not in the from status.
+func (u *UploadSessionsStorage) SetStatus(ctx context.Context,
+	id, from, to string) (bool, error) {
+
+	if govalidator.IsNull(id) {
+		return false, model.ErrUploadSessionsStorageInvalidID
+	}
+
+	session := u.session.Copy()
+	defer session.Close()
+
+	var status interface{} = from
+	// the sessions created before the status was recorded are open
+	if from == images.UploadStatusOpen {
+		status = bson.M{"$in": []interface{}{nil, from}}
+	}
+	query := bson.M{
+		StorageKeyUploadId:     id,
+		StorageKeyUploadStatus: status,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			StorageKeyUploadStatus: to,
+		},
+	}
+
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionUploads).Update(query, update); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// FindExpired lists upload sessions expired at the given time
+func (u *UploadSessionsStorage) FindExpired(ctx context.Context,
+	when time.Time) ([]*images.UploadSession, error) {
+
+	session := u.session.Copy()
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeyUploadExpire: bson.M{"$lt": when},
+	}
+
+	var uploads []*images.UploadSession
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionUploads).Find(query).All(&uploads); err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
+
+// FindCreatedBefore lists upload sessions started before the given time,
+// expired or not
+func (u *UploadSessionsStorage) FindCreatedBefore(ctx context.Context,
+	when time.Time) ([]*images.UploadSession, error) {
+
+	session := u.session.Copy()
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeyUploadCreated: bson.M{"$lt": when},
+	}
+
+	var uploads []*images.UploadSession
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionUploads).Find(query).All(&uploads); err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
+
+// Delete upload session specified by ID
+// Noop on if not found.
+func (u *UploadSessionsStorage) Delete(ctx context.Context, id string) error {
+
+	if govalidator.IsNull(id) {
+		return model.ErrUploadSessionsStorageInvalidID
+	}
+
+	session := u.session.Copy()
+	defer session.Close()
+
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionUploads).RemoveId(id); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
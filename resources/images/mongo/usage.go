@@ -0,0 +1,150 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/store"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/mendersoftware/deployments/resources/images"
+)
+
+// Database KEYS
+const (
+	StorageKeyUsageArtifacts = "artifacts"
+	StorageKeyUsageSize      = "size"
+)
+
+// Database
+const (
+	CollectionTenantStats = "tenant_stats"
+
+	// ID of the tenant stats document holding the usage
+	TenantStatsUsageID = "usage"
+)
+
+// IncUsage adds to the usage of the tenant atomically, so that concurrent
+// changes are all accounted. Negative values decrease the usage.
+func (i *SoftwareImagesStorage) IncUsage(ctx context.Context, artifacts, size int64) error {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	_, err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionTenantStats).UpsertId(TenantStatsUsageID, bson.M{
+		"$inc": bson.M{
+			StorageKeyUsageArtifacts: artifacts,
+			StorageKeyUsageSize:      size,
+		},
+	})
+	return err
+}
+
+// ReserveArtifact counts one more artifact in the usage of the tenant,
+// only if fewer than limit artifacts are counted. The check and the count
+// are a single conditional update, so that concurrent reservations can't
+// overshoot the limit.
+// Returns false if the limit is reached.
+func (i *SoftwareImagesStorage) ReserveArtifact(ctx context.Context,
+	limit uint64) (bool, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	collection := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionTenantStats)
+
+	// the usage has to exist for the conditional update to match
+	if _, err := collection.UpsertId(TenantStatsUsageID, bson.M{
+		"$inc": bson.M{StorageKeyUsageArtifacts: 0},
+	}); err != nil {
+		return false, err
+	}
+
+	err := collection.Update(bson.M{
+		"_id":                    TenantStatsUsageID,
+		StorageKeyUsageArtifacts: bson.M{"$lt": limit},
+	}, bson.M{
+		"$inc": bson.M{StorageKeyUsageArtifacts: 1},
+	})
+	if err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetUsage returns the usage of the tenant, zero if nothing was counted yet.
+func (i *SoftwareImagesStorage) GetUsage(ctx context.Context) (*images.Usage, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	var usage images.Usage
+	if err := session.DB(store.DbFromContext(ctx, DatabaseName)).
+		C(CollectionTenantStats).FindId(TenantStatsUsageID).One(&usage); err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return &images.Usage{}, nil
+		}
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+// ReconcileUsage recomputes the usage of the tenant out of the stored
+// images, expired ones included, and replaces the counted one.
+// Changes made while the usage is recomputed may be missed or accounted
+// twice, until the next reconciliation.
+// Returns the recomputed usage.
+func (i *SoftwareImagesStorage) ReconcileUsage(ctx context.Context) (*images.Usage, error) {
+
+	session := i.copySession(ctx)
+	defer session.Close()
+
+	db := session.DB(store.DbFromContext(ctx, DatabaseName))
+
+	pipe := []bson.M{
+		{
+			"$group": bson.M{
+				"_id":                    nil,
+				StorageKeyUsageArtifacts: bson.M{"$sum": 1},
+				StorageKeyUsageSize:      bson.M{"$sum": "$" + StorageKeySoftwareImageSize},
+			},
+		},
+	}
+
+	usage := images.Usage{}
+	if err := db.C(CollectionImages).Pipe(&pipe).One(&usage); err != nil &&
+		err.Error() != mgo.ErrNotFound.Error() {
+		return nil, err
+	}
+
+	if _, err := db.C(CollectionTenantStats).UpsertId(TenantStatsUsageID, bson.M{
+		"$set": bson.M{
+			StorageKeyUsageArtifacts: usage.Artifacts,
+			StorageKeyUsageSize:      usage.Size,
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Placeholders of the object key template
+const (
+	ObjectKeyTenant     = "{tenant}"
+	ObjectKeyID         = "{id}"
+	ObjectKeyDeviceType = "{device_type}"
+	ObjectKeyName       = "{name}"
+)
+
+// DefaultObjectKeyTemplate is the layout used for the artifact files
+// stored before the layout was configurable.
+const DefaultObjectKeyTemplate = ObjectKeyTenant + "/" + ObjectKeyID
+
+var (
+	ErrObjectKeyTemplateEmpty       = errors.New("Object key template is empty")
+	ErrObjectKeyTemplateNoID        = errors.New("Object key template has to contain " + ObjectKeyID + " as a separate path segment")
+	ErrObjectKeyTemplatePlaceholder = errors.New("Object key template contains unknown placeholder")
+
+	objectKeyPlaceholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+)
+
+// ObjectKeyTemplate describes the layout of the artifact files in the file storage.
+// Keys are built by replacing the placeholders with the artifact and tenant
+// values, path segments which end up empty (e.g. no tenant) are dropped.
+type ObjectKeyTemplate struct {
+	template string
+}
+
+// NewObjectKeyTemplate validates the template. Artifact ID has to be
+// a path segment on its own, so that the keys are unique.
+func NewObjectKeyTemplate(template string) (*ObjectKeyTemplate, error) {
+	if strings.TrimSpace(template) == "" {
+		return nil, ErrObjectKeyTemplateEmpty
+	}
+
+	rest := objectKeyPlaceholderRegexp.ReplaceAllStringFunc(template,
+		func(placeholder string) string {
+			switch placeholder {
+			case ObjectKeyTenant, ObjectKeyID, ObjectKeyDeviceType, ObjectKeyName:
+				return ""
+			}
+			return placeholder
+		})
+	if strings.ContainsAny(rest, "{}") {
+		return nil, errors.Wrap(ErrObjectKeyTemplatePlaceholder, template)
+	}
+
+	hasID := false
+	for _, segment := range strings.Split(template, "/") {
+		if segment == ObjectKeyID {
+			hasID = true
+		}
+	}
+	if !hasID {
+		return nil, ErrObjectKeyTemplateNoID
+	}
+
+	return &ObjectKeyTemplate{template: template}, nil
+}
+
+// ObjectKey returns the key of the image file of the tenant.
+// First compatible device type is used for the device type placeholder.
+func (t *ObjectKeyTemplate) ObjectKey(tenant string, image *SoftwareImage) string {
+	var deviceType string
+	if len(image.DeviceTypesCompatible) > 0 {
+		deviceType = image.DeviceTypesCompatible[0]
+	}
+
+	key := strings.NewReplacer(
+		ObjectKeyTenant, objectKeySegment(tenant),
+		ObjectKeyID, objectKeySegment(image.Id),
+		ObjectKeyDeviceType, objectKeySegment(deviceType),
+		ObjectKeyName, objectKeySegment(image.Name),
+	).Replace(t.template)
+
+	return cleanObjectKey(key)
+}
+
+// ObjectKey returns the key of the tenant's object stored with
+// the default layout, e.g. upload chunks.
+func ObjectKey(tenant, id string) string {
+	return cleanObjectKey(tenant + "/" + id)
+}
+
+// objectKeySegment makes sure the value doesn't change the path structure of the key
+func objectKeySegment(value string) string {
+	return strings.Replace(value, "/", "_", -1)
+}
+
+func cleanObjectKey(key string) string {
+	segments := strings.Split(key, "/")
+	cleaned := segments[:0]
+	for _, segment := range segments {
+		if segment != "" {
+			cleaned = append(cleaned, segment)
+		}
+	}
+	return strings.Join(cleaned, "/")
+}
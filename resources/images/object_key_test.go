@@ -0,0 +1,94 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewObjectKeyTemplate(t *testing.T) {
+	testCases := []struct {
+		template string
+		err      error
+	}{
+		{template: DefaultObjectKeyTemplate},
+		{template: "{tenant}/{device_type}/{id}"},
+		{template: "artifacts/{tenant}/{name}/{id}"},
+		{template: "{id}"},
+		{template: "", err: ErrObjectKeyTemplateEmpty},
+		{template: "{tenant}/{name}", err: ErrObjectKeyTemplateNoID},
+		{template: "{tenant}/{name}-{id}", err: ErrObjectKeyTemplateNoID},
+		{template: "{tenant}/{version}/{id}", err: ErrObjectKeyTemplatePlaceholder},
+		{template: "{tenant/{id}", err: ErrObjectKeyTemplatePlaceholder},
+	}
+
+	for _, tc := range testCases {
+		_, err := NewObjectKeyTemplate(tc.template)
+		if tc.err != nil {
+			assert.Error(t, err, tc.template)
+			assert.Contains(t, err.Error(), tc.err.Error(), tc.template)
+		} else {
+			assert.NoError(t, err, tc.template)
+		}
+	}
+}
+
+func TestObjectKeyTemplateObjectKey(t *testing.T) {
+	image := &SoftwareImage{
+		Id: "d50eda0d-2cea-4de1-8d42-9cd3e7e8670d",
+		SoftwareImageMetaArtifactConstructor: SoftwareImageMetaArtifactConstructor{
+			Name:                  "release/1",
+			DeviceTypesCompatible: []string{"beaglebone", "qemu"},
+		},
+	}
+
+	testCases := []struct {
+		template string
+		tenant   string
+		key      string
+	}{
+		{
+			template: DefaultObjectKeyTemplate,
+			key:      "d50eda0d-2cea-4de1-8d42-9cd3e7e8670d",
+		},
+		{
+			template: DefaultObjectKeyTemplate,
+			tenant:   "tenant",
+			key:      "tenant/d50eda0d-2cea-4de1-8d42-9cd3e7e8670d",
+		},
+		{
+			template: "{tenant}/{device_type}/{id}",
+			tenant:   "tenant",
+			key:      "tenant/beaglebone/d50eda0d-2cea-4de1-8d42-9cd3e7e8670d",
+		},
+		{
+			template: "/artifacts/{tenant}/{name}/{id}",
+			key:      "artifacts/release_1/d50eda0d-2cea-4de1-8d42-9cd3e7e8670d",
+		},
+	}
+
+	for _, tc := range testCases {
+		template, err := NewObjectKeyTemplate(tc.template)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.key, template.ObjectKey(tc.tenant, image), tc.template)
+	}
+
+	// default layout is used for the images without the key recorded
+	assert.Equal(t, "tenant/"+image.Id, image.FileObjectKey("tenant"))
+	image.ObjectKey = "foo/bar"
+	assert.Equal(t, "foo/bar", image.FileObjectKey("tenant"))
+}
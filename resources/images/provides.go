@@ -0,0 +1,85 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"errors"
+)
+
+// Keys of the provides of the devices, which the artifacts depend on
+const (
+	// Name of the artifact installed on the device
+	ProvidesArtifactName = "artifact_name"
+
+	// Type of the device
+	ProvidesDeviceType = "device_type"
+)
+
+var (
+	ErrProvidesDeviceTypeMissing = errors.New("Invalid provides: device_type required")
+)
+
+// Provides describe the software installed on the device, by key.
+//
+// The artifacts depend on the device type being one of the compatible ones,
+// and the delta artifacts also on the artifact they are applied to being
+// installed; installed artifact provides its name. Other keys are kept
+// as they are.
+type Provides map[string]string
+
+// Validate checks the device type is given.
+func (p Provides) Validate() error {
+	if NormalizeDeviceType(p[ProvidesDeviceType]) == "" {
+		return ErrProvidesDeviceTypeMissing
+	}
+	return nil
+}
+
+// DependsSatisfied tells if the image can be installed on the device
+// with the provides.
+func (s *SoftwareImage) DependsSatisfied(provides Provides) bool {
+	if s.Delta != nil && s.Delta.From != provides[ProvidesArtifactName] {
+		return false
+	}
+
+	deviceType := NormalizeDeviceType(provides[ProvidesDeviceType])
+	for _, compatible := range s.DeviceTypesCompatible {
+		if NormalizeDeviceType(compatible) == deviceType {
+			return true
+		}
+	}
+	return false
+}
+
+// InstalledProvides returns the provides of the device once the image
+// is installed.
+func (s *SoftwareImage) InstalledProvides(provides Provides) Provides {
+	installed := make(Provides, len(provides))
+	for key, value := range provides {
+		installed[key] = value
+	}
+	installed[ProvidesArtifactName] = s.Name
+	return installed
+}
+
+// UpdateStep is the artifact which can be installed on the device, once
+// the artifacts on the path are installed, in order. Artifacts installable
+// right away have empty path.
+type UpdateStep struct {
+	Artifact *SoftwareImage `json:"artifact"`
+
+	// IDs of the artifacts to install first, the shortest path
+	Path []string `json:"path"`
+}
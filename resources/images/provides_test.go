@@ -0,0 +1,111 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+
+package images
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvidesValidate(t *testing.T) {
+	assert.NoError(t, Provides{ProvidesDeviceType: "rpi"}.Validate())
+	assert.Equal(t, ErrProvidesDeviceTypeMissing, Provides{}.Validate())
+	assert.Equal(t, ErrProvidesDeviceTypeMissing, Provides{
+		ProvidesArtifactName: "release-1",
+		ProvidesDeviceType:   " ",
+	}.Validate())
+}
+
+func TestSoftwareImageDependsSatisfied(t *testing.T) {
+	full := &SoftwareImage{
+		SoftwareImageMetaArtifactConstructor: SoftwareImageMetaArtifactConstructor{
+			Name:                  "release-2",
+			DeviceTypesCompatible: []string{"rpi", "BeagleBone"},
+		},
+	}
+	delta := &SoftwareImage{
+		SoftwareImageMetaArtifactConstructor: SoftwareImageMetaArtifactConstructor{
+			Name:                  "release-2",
+			DeviceTypesCompatible: []string{"rpi"},
+		},
+		Delta: &DeltaUpdate{From: "release-1", To: "release-2"},
+	}
+
+	testCases := map[string]struct {
+		image    *SoftwareImage
+		provides Provides
+		expected bool
+	}{
+		"full, compatible": {
+			image:    full,
+			provides: Provides{ProvidesDeviceType: "beaglebone"},
+			expected: true,
+		},
+		"full, incompatible": {
+			image:    full,
+			provides: Provides{ProvidesDeviceType: "qemu"},
+		},
+		"delta, base installed": {
+			image: delta,
+			provides: Provides{
+				ProvidesArtifactName: "release-1",
+				ProvidesDeviceType:   "rpi",
+			},
+			expected: true,
+		},
+		"delta, other installed": {
+			image: delta,
+			provides: Provides{
+				ProvidesArtifactName: "release-0",
+				ProvidesDeviceType:   "rpi",
+			},
+		},
+		"delta, incompatible": {
+			image: delta,
+			provides: Provides{
+				ProvidesArtifactName: "release-1",
+				ProvidesDeviceType:   "beaglebone",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.image.DependsSatisfied(tc.provides))
+		})
+	}
+}
+
+func TestSoftwareImageInstalledProvides(t *testing.T) {
+	image := &SoftwareImage{
+		SoftwareImageMetaArtifactConstructor: SoftwareImageMetaArtifactConstructor{
+			Name: "release-2",
+		},
+	}
+	provides := Provides{
+		ProvidesArtifactName: "release-1",
+		ProvidesDeviceType:   "rpi",
+		"rootfs.checksum":    "abc",
+	}
+
+	assert.Equal(t, Provides{
+		ProvidesArtifactName: "release-2",
+		ProvidesDeviceType:   "rpi",
+		"rootfs.checksum":    "abc",
+	}, image.InstalledProvides(provides))
+	assert.Equal(t, "release-1", provides[ProvidesArtifactName])
+}
@@ -18,9 +18,62 @@ import (
 	"encoding/xml"
 	"net/http"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/resources/images/model"
 )
 
+// S3 error codes of the storage failures, by the model error they translate to
+var storageErrorCodes = map[string]error{
+	"AccessDenied":          model.ErrFileStorageAccessDenied,
+	"InvalidAccessKeyId":    model.ErrFileStorageAccessDenied,
+	"SignatureDoesNotMatch": model.ErrFileStorageAccessDenied,
+	"ExpiredToken":          model.ErrFileStorageAccessDenied,
+	"InvalidToken":          model.ErrFileStorageAccessDenied,
+
+	"NoSuchBucket":                 model.ErrFileStorageMisconfigured,
+	"InvalidBucketName":            model.ErrFileStorageMisconfigured,
+	"PermanentRedirect":            model.ErrFileStorageMisconfigured,
+	"AuthorizationHeaderMalformed": model.ErrFileStorageMisconfigured,
+
+	"SlowDown":             model.ErrFileStorageThrottled,
+	"Throttling":           model.ErrFileStorageThrottled,
+	"ThrottlingException":  model.ErrFileStorageThrottled,
+	"RequestLimitExceeded": model.ErrFileStorageThrottled,
+	"ServiceUnavailable":   model.ErrFileStorageThrottled,
+}
+
+// storageError translates S3 error code, or HTTP status of the response
+// if the code is not known, into the model error; nil if there's none.
+func storageError(code string, status int) error {
+	if err, ok := storageErrorCodes[code]; ok {
+		return err
+	}
+	if status == http.StatusServiceUnavailable {
+		return model.ErrFileStorageThrottled
+	}
+	return nil
+}
+
+// classifyError makes the model error the cause of the S3 client error
+// if it's one of the storage failures, keeping the original message.
+func classifyError(err error) error {
+	var code string
+	var status int
+	if awsErr, ok := err.(awserr.Error); ok {
+		code = awsErr.Code()
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		status = reqErr.StatusCode()
+	}
+
+	if modelErr := storageError(code, status); modelErr != nil {
+		return errors.Wrap(modelErr, err.Error())
+	}
+	return err
+}
+
 // getS3Error tries to extract S3 error information from HTTP response. Response
 // body is partially consumed. Returns an error with whatever error information returned
 // by S3 or just a generic description of a problem in case the response is not
@@ -40,8 +93,12 @@ func getS3Error(r *http.Response) error {
 	if r.StatusCode < 300 ||
 		r.Header.Get("Content-Type") != "application/xml" {
 
-		return errors.Errorf("unexpected S3 error response, status: %v, type: %s",
+		err := errors.Errorf("unexpected S3 error response, status: %v, type: %s",
 			r.StatusCode, r.Header.Get("Content-Type"))
+		if modelErr := storageError("", r.StatusCode); modelErr != nil {
+			return errors.Wrap(modelErr, err.Error())
+		}
+		return err
 	}
 
 	dec := xml.NewDecoder(r.Body)
@@ -50,6 +107,10 @@ func getS3Error(r *http.Response) error {
 		return errors.Wrap(err, "failed to decode XML encoded error response")
 	}
 
-	return errors.Errorf("S3 request failed with code %s: %s, request ID: %s",
+	err = errors.Errorf("S3 request failed with code %s: %s, request ID: %s",
 		s3rsp.Code, s3rsp.Message, s3rsp.RequestId)
+	if modelErr := storageError(s3rsp.Code, r.StatusCode); modelErr != nil {
+		return errors.Wrap(modelErr, err.Error())
+	}
+	return err
 }
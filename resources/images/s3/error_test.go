@@ -23,7 +23,11 @@ import (
 	"net/http"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images/model"
 )
 
 func bytesBuffer(data string) io.ReadCloser {
@@ -85,6 +89,31 @@ func TestGetS3Error(t *testing.T) {
 			},
 			err: errors.New("S3 request failed with code NoSuchKey: The resource you requested does not exist, request ID: 4442587FB7D0A2F9"),
 		},
+		{
+			rsp: &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Header: http.Header{
+					"Content-Type": []string{"application/xml"},
+				},
+				Body: bytesBuffer(`<?xml version="1.0" encoding="UTF-8"?>
+<Error>
+  <Code>SlowDown</Code>
+  <Message>Please reduce your request rate.</Message>
+  <RequestId>4442587FB7D0A2F9</RequestId>
+</Error>
+`),
+			},
+			err: errors.New("S3 request failed with code SlowDown: Please reduce your request rate., request ID: 4442587FB7D0A2F9: File storage throttled the request"),
+		},
+		{
+			rsp: &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Header: http.Header{
+					"Content-Type": []string{"text/html"},
+				},
+			},
+			err: errors.New("unexpected S3 error response, status: 503, type: text/html: File storage throttled the request"),
+		},
 	}
 
 	for idx, tc := range tcs {
@@ -95,3 +124,45 @@ func TestGetS3Error(t *testing.T) {
 		})
 	}
 }
+
+func TestClassifyError(t *testing.T) {
+
+	t.Parallel()
+
+	tcs := map[string]struct {
+		err   error
+		cause error
+	}{
+		"access denied": {
+			err:   awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "id"),
+			cause: model.ErrFileStorageAccessDenied,
+		},
+		"bucket missing": {
+			err:   awserr.New("NoSuchBucket", "The specified bucket does not exist", nil),
+			cause: model.ErrFileStorageMisconfigured,
+		},
+		"throttled": {
+			err:   awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), 503, "id"),
+			cause: model.ErrFileStorageThrottled,
+		},
+		"unavailable": {
+			err:   awserr.NewRequestFailure(awserr.New("Unknown", "", nil), 503, "id"),
+			cause: model.ErrFileStorageThrottled,
+		},
+		"other": {
+			err: awserr.NewRequestFailure(awserr.New("InternalError", "", nil), 500, "id"),
+		},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			err := classifyError(tc.err)
+			if tc.cause == nil {
+				assert.Equal(t, tc.err, err)
+				return
+			}
+			assert.Equal(t, tc.cause, pkgerrors.Cause(err))
+			assert.Contains(t, err.Error(), tc.err.Error())
+		})
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -31,6 +32,7 @@ import (
 
 	"github.com/mendersoftware/deployments/resources/images"
 	"github.com/mendersoftware/deployments/resources/images/model"
+	"github.com/mendersoftware/deployments/utils/tracing"
 	"github.com/mendersoftware/go-lib-micro/identity"
 
 	"github.com/mendersoftware/go-lib-micro/log"
@@ -40,6 +42,14 @@ const (
 	ExpireMaxLimit                 = 7 * 24 * time.Hour
 	ExpireMinLimit                 = 1 * time.Minute
 	ErrCodeBucketAlreadyOwnedByYou = "BucketAlreadyOwnedByYou"
+	ErrCodeNotFound                = "NotFound"
+)
+
+// S3 copies at most maxCopyObjectSize bytes with a single request, larger
+// objects are copied in copyPartSize parts; variables for testing.
+var (
+	maxCopyObjectSize int64 = 5 * 1024 * 1024 * 1024
+	copyPartSize      int64 = 512 * 1024 * 1024
 )
 
 // SimpleStorageService - AWS S3 client.
@@ -116,19 +126,9 @@ func NewSimpleStorageServiceDefaults(bucket, region string) (*SimpleStorageServi
 	}, nil
 }
 
-func getArtifactByTenant(ctx context.Context, objectID string) string {
-	if id := identity.FromContext(ctx); id != nil && len(id.Tenant) > 0 {
-		return fmt.Sprintf("%s/%s", id.Tenant, objectID)
-	}
-
-	return objectID
-}
-
 // Delete removes delected file from storage.
 // Noop if ID does not exist.
 func (s *SimpleStorageService) Delete(ctx context.Context, objectID string) error {
-	objectID = getArtifactByTenant(ctx, objectID)
-
 	params := &s3.DeleteObjectInput{
 		// Required
 		Bucket: aws.String(s.bucket),
@@ -140,9 +140,9 @@ func (s *SimpleStorageService) Delete(ctx context.Context, objectID string) erro
 
 	// ignore return response which contains charing info
 	// and file versioning data which are not in interest
-	_, err := s.client.DeleteObject(params)
+	_, err := s.client.DeleteObjectWithContext(ctx, params)
 	if err != nil {
-		return errors.Wrap(err, "Removing file")
+		return errors.Wrap(classifyError(err), "Removing file")
 	}
 
 	return nil
@@ -150,8 +150,6 @@ func (s *SimpleStorageService) Delete(ctx context.Context, objectID string) erro
 
 // Exists check if selected object exists in the storage
 func (s *SimpleStorageService) Exists(ctx context.Context, objectID string) (bool, error) {
-	objectID = getArtifactByTenant(ctx, objectID)
-
 	params := &s3.ListObjectsInput{
 		// Required
 		Bucket: aws.String(s.bucket),
@@ -161,9 +159,9 @@ func (s *SimpleStorageService) Exists(ctx context.Context, objectID string) (boo
 		Prefix:  aws.String(objectID),
 	}
 
-	resp, err := s.client.ListObjects(params)
+	resp, err := s.client.ListObjectsWithContext(ctx, params)
 	if err != nil {
-		return false, errors.Wrap(err, "Searching for file")
+		return false, errors.Wrap(classifyError(err), "Searching for file")
 	}
 
 	if len(resp.Contents) == 0 {
@@ -182,8 +180,16 @@ func (s *SimpleStorageService) Exists(ctx context.Context, objectID string) (boo
 // UploadArtifact uploads given artifact into the file server (AWS S3 or minio)
 // using objectID as a key
 func (s *SimpleStorageService) UploadArtifact(ctx context.Context,
-	objectID string, size int64, artifact io.Reader, contentType string) error {
-	objectID = getArtifactByTenant(ctx, objectID)
+	objectID string, size int64, artifact io.Reader, contentType string) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "SimpleStorageService.UploadArtifact")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	span.SetAttribute("backend", "s3")
+	span.SetAttribute("bucket", s.bucket)
+	span.SetAttribute("object_id", objectID)
+	span.SetAttribute("size", size)
 
 	params := &s3.PutObjectInput{
 		// Required
@@ -207,7 +213,7 @@ func (s *SimpleStorageService) UploadArtifact(ctx context.Context,
 	}
 	request.Header.Set("Content-Type", contentType)
 	request.ContentLength = size
-	resp, err := client.Do(request)
+	resp, err := client.Do(request.WithContext(ctx))
 	if err != nil {
 		return err
 	}
@@ -219,34 +225,87 @@ func (s *SimpleStorageService) UploadArtifact(ctx context.Context,
 			"Artifact upload failed with HTTP status %v", resp.Status)
 	}
 
-	if id := identity.FromContext(ctx); id != nil && len(id.Tenant) > 0 && s.tagArtifact {
-		input := &s3.PutObjectTaggingInput{
-			Bucket: params.Bucket,
-			Key:    params.Key,
-			Tagging: &s3.Tagging{
-				TagSet: []*s3.Tag{
-					{
-						Key:   aws.String("tenant_id"),
-						Value: aws.String(id.Tenant),
-					},
+	s.tagObject(ctx, objectID)
+
+	return nil
+}
+
+// tagObject tags the object with the tenant from the context, if configured.
+// Failures are only logged.
+func (s *SimpleStorageService) tagObject(ctx context.Context, objectID string) {
+	id := identity.FromContext(ctx)
+	if id == nil || len(id.Tenant) == 0 || !s.tagArtifact {
+		return
+	}
+
+	input := &s3.PutObjectTaggingInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectID),
+		Tagging: &s3.Tagging{
+			TagSet: []*s3.Tag{
+				{
+					Key:   aws.String("tenant_id"),
+					Value: aws.String(id.Tenant),
 				},
 			},
+		},
+	}
+	if _, err := s.client.PutObjectTagging(input); err != nil {
+		l := log.FromContext(ctx)
+		l.F(log.Ctx{"object_id": objectID, "error": err.Error()}).
+			Warn("failed to tag artifact")
+	}
+}
+
+// GetObject opens the object for reading.
+// If object not found return ErrFileStorageFileNotFound
+func (s *SimpleStorageService) GetObject(ctx context.Context,
+	objectID string) (io.ReadCloser, error) {
+
+	params := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectID),
+	}
+
+	resp, err := s.client.GetObjectWithContext(ctx, params)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, model.ErrFileStorageFileNotFound
 		}
-		if _, err := s.client.PutObjectTagging(input); err != nil {
-			l := log.FromContext(r.Context())
-			l.Warnf("failed to tag artifact : %s\n", objectID)
+		return nil, errors.Wrap(classifyError(err), "Fetching file")
+	}
+
+	return resp.Body, nil
+}
+
+// OpenObject opens the object for reading from any position.
+// The object is fetched with range requests as it is read.
+// If object not found return ErrFileStorageFileNotFound
+func (s *SimpleStorageService) OpenObject(ctx context.Context,
+	objectID string) (images.FileReader, error) {
+
+	resp, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectID),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == ErrCodeNotFound {
+			return nil, model.ErrFileStorageFileNotFound
 		}
+		return nil, errors.Wrap(classifyError(err), "Searching for file")
 	}
 
-	return nil
+	return &objectReader{
+		s:        s,
+		objectID: objectID,
+		size:     aws.Int64Value(resp.ContentLength),
+	}, nil
 }
 
 // PutRequest duration is limited to 7 days (AWS limitation)
 func (s *SimpleStorageService) PutRequest(ctx context.Context, objectID string,
 	duration time.Duration) (*images.Link, error) {
 
-	objectID = getArtifactByTenant(ctx, objectID)
-
 	if err := s.validateDurationLimits(duration); err != nil {
 		return nil, err
 	}
@@ -269,20 +328,24 @@ func (s *SimpleStorageService) PutRequest(ctx context.Context, objectID string,
 }
 
 // GetRequest duration is limited to 7 days (AWS limitation)
-func (s *SimpleStorageService) GetRequest(ctx context.Context, objectID string,
+func (s *SimpleStorageService) GetRequest(ctx context.Context, objectID, versionID string,
 	duration time.Duration, responseContentType string) (*images.Link, error) {
 
 	if err := s.validateDurationLimits(duration); err != nil {
 		return nil, err
 	}
 
-	objectID = getArtifactByTenant(ctx, objectID)
-
 	params := &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(objectID),
 	}
 
+	// pin the link to the version of the object,
+	// so that it can be revoked by rotating the object
+	if versionID != "" {
+		params.VersionId = aws.String(versionID)
+	}
+
 	if responseContentType != "" {
 		params.ResponseContentType = &responseContentType
 	}
@@ -295,7 +358,247 @@ func (s *SimpleStorageService) GetRequest(ctx context.Context, objectID string,
 		return nil, errors.Wrap(err, "Signing GET request")
 	}
 
-	return images.NewLink(uri, req.Time.Add(req.ExpireTime)), nil
+	link := images.NewLink(uri, req.Time.Add(req.ExpireTime))
+	// only the host header is signed, so the client is free to add
+	// a Range header to resume an interrupted download
+	link.AcceptRanges = true
+
+	return link, nil
+}
+
+// Location of the object in the bucket, whether it exists or not
+func (s *SimpleStorageService) Location(objectID string) *images.StorageLocation {
+	return &images.StorageLocation{
+		Backend: "s3",
+		Bucket:  s.bucket,
+		Key:     objectID,
+	}
+}
+
+// ObjectVersion returns the current version ID of the object,
+// empty string if the bucket is not versioned.
+// If object not found return ErrFileStorageFileNotFound
+func (s *SimpleStorageService) ObjectVersion(ctx context.Context,
+	objectID string) (string, error) {
+
+	head, err := s.headObject(ctx, objectID, "")
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(head.VersionId), nil
+}
+
+// RotateObject replaces the object with its copy and removes the old version,
+// which invalidates all the GET links pinned to it so far.
+// Returns the version ID of the copy, the new links are to be pinned to.
+// Requires bucket versioning enabled, returns ErrFileStorageNotSupported otherwise.
+// If object not found return ErrFileStorageFileNotFound
+func (s *SimpleStorageService) RotateObject(ctx context.Context,
+	objectID string) (string, error) {
+
+	versioning, err := s.client.GetBucketVersioning(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(s.bucket),
+	})
+	if err != nil {
+		return "", errors.Wrap(classifyError(err), "Checking bucket versioning")
+	}
+
+	if aws.StringValue(versioning.Status) != s3.BucketVersioningStatusEnabled {
+		return "", model.ErrFileStorageNotSupported
+	}
+
+	head, err := s.headObject(ctx, objectID, "")
+	if err != nil {
+		return "", err
+	}
+
+	// copy keeps content type, metadata and tags of the source
+	newVersionID, err := s.copyObject(ctx, objectID, head, objectID)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket:    aws.String(s.bucket),
+		Key:       aws.String(objectID),
+		VersionId: head.VersionId,
+	})
+	if err != nil {
+		return "", errors.Wrap(classifyError(err), "Removing old file version")
+	}
+
+	return newVersionID, nil
+}
+
+// CopyObject copies the object to the new key, leaving the original in place.
+// Server side copy is used, in parts for the objects larger than 5GB.
+// If object not found return ErrFileStorageFileNotFound
+func (s *SimpleStorageService) CopyObject(ctx context.Context, objectID, newObjectID string) error {
+	head, err := s.headObject(ctx, objectID, "")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.copyObject(ctx, objectID, head, newObjectID)
+	return err
+}
+
+// MoveObject moves the object to the new key.
+// Server side copy is used, in parts for the objects larger than 5GB.
+// If object not found return ErrFileStorageFileNotFound
+func (s *SimpleStorageService) MoveObject(ctx context.Context, objectID, newObjectID string) error {
+	if err := s.CopyObject(ctx, objectID, newObjectID); err != nil {
+		return err
+	}
+
+	if err := s.Delete(ctx, objectID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// copyObject copies the version of the object described by head to the new
+// key, returns the version ID of the copy. S3 copies at most
+// maxCopyObjectSize bytes with a single request, larger objects are copied
+// in parts.
+func (s *SimpleStorageService) copyObject(ctx context.Context, objectID string,
+	head *s3.HeadObjectOutput, newObjectID string) (string, error) {
+
+	source := s.copySource(objectID, aws.StringValue(head.VersionId))
+	if aws.Int64Value(head.ContentLength) <= maxCopyObjectSize {
+		copied, err := s.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(newObjectID),
+			CopySource: aws.String(source),
+		})
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+				return "", model.ErrFileStorageFileNotFound
+			}
+			return "", errors.Wrap(classifyError(err), "Copying file")
+		}
+		return aws.StringValue(copied.VersionId), nil
+	}
+
+	return s.copyObjectParts(ctx, source, head, newObjectID)
+}
+
+// copyObjectParts copies the object with the multipart upload, copying
+// copyPartSize bytes ranges of the source. Unlike the single request copy,
+// the multipart one does not keep content type, metadata and tags,
+// so they are set again.
+func (s *SimpleStorageService) copyObjectParts(ctx context.Context, source string,
+	head *s3.HeadObjectOutput, newObjectID string) (string, error) {
+
+	upload, err := s.client.CreateMultipartUploadWithContext(ctx,
+		&s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(newObjectID),
+			ContentType: head.ContentType,
+			Metadata:    head.Metadata,
+		})
+	if err != nil {
+		return "", errors.Wrap(classifyError(err), "Starting file copy")
+	}
+
+	size := aws.Int64Value(head.ContentLength)
+	parts := []*s3.CompletedPart{}
+	for start, n := int64(0), int64(1); start < size; start, n = start+copyPartSize, n+1 {
+		end := start + copyPartSize
+		if end > size {
+			end = size
+		}
+
+		part, err := s.client.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             aws.String(newObjectID),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int64(n),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end-1)),
+		})
+		if err != nil {
+			s.abortUpload(ctx, newObjectID, upload.UploadId)
+			return "", errors.Wrap(classifyError(err), "Copying file part")
+		}
+		parts = append(parts, &s3.CompletedPart{
+			ETag:       part.CopyPartResult.ETag,
+			PartNumber: aws.Int64(n),
+		})
+	}
+
+	completed, err := s.client.CompleteMultipartUploadWithContext(ctx,
+		&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             aws.String(newObjectID),
+			UploadId:        upload.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+	if err != nil {
+		s.abortUpload(ctx, newObjectID, upload.UploadId)
+		return "", errors.Wrap(classifyError(err), "Finishing file copy")
+	}
+
+	s.tagObject(ctx, newObjectID)
+	return aws.StringValue(completed.VersionId), nil
+}
+
+// abortUpload removes the parts of the multipart upload which failed.
+func (s *SimpleStorageService) abortUpload(ctx context.Context, objectID string,
+	uploadID *string) {
+
+	_, err := s.client.AbortMultipartUploadWithContext(ctx,
+		&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(objectID),
+			UploadId: uploadID,
+		})
+	if err != nil {
+		log.FromContext(ctx).F(log.Ctx{"object_id": objectID, "error": err.Error()}).
+			Warn("failed to abort multipart upload")
+	}
+}
+
+// copySource returns the URL encoded source of the copy of the object,
+// the version of the object if given. The plus sign is escaped as well,
+// as S3 decodes it to the space.
+func (s *SimpleStorageService) copySource(objectID, versionID string) string {
+	segments := strings.Split(objectID, "/")
+	for i := range segments {
+		segments[i] = strings.Replace(url.PathEscape(segments[i]), "+", "%2B", -1)
+	}
+
+	source := s.bucket + "/" + strings.Join(segments, "/")
+	if versionID != "" {
+		source += "?versionId=" + url.QueryEscape(versionID)
+	}
+	return source
+}
+
+// headObject returns the metadata of the object, of the version if given.
+// If object not found return ErrFileStorageFileNotFound
+func (s *SimpleStorageService) headObject(ctx context.Context,
+	objectID, versionID string) (*s3.HeadObjectOutput, error) {
+
+	params := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectID),
+	}
+	if versionID != "" {
+		params.VersionId = aws.String(versionID)
+	}
+
+	head, err := s.client.HeadObjectWithContext(ctx, params)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == ErrCodeNotFound {
+			return nil, model.ErrFileStorageFileNotFound
+		}
+		return nil, errors.Wrap(classifyError(err), "Searching for file")
+	}
+
+	return head, nil
 }
 
 func (s *SimpleStorageService) validateDurationLimits(duration time.Duration) error {
@@ -311,8 +614,6 @@ func (s *SimpleStorageService) validateDurationLimits(duration time.Duration) er
 // If object not found return ErrFileStorageFileNotFound
 func (s *SimpleStorageService) LastModified(ctx context.Context, objectID string) (time.Time, error) {
 
-	objectID = getArtifactByTenant(ctx, objectID)
-
 	params := &s3.ListObjectsInput{
 		// Required
 		Bucket: aws.String(s.bucket),
@@ -324,7 +625,7 @@ func (s *SimpleStorageService) LastModified(ctx context.Context, objectID string
 
 	resp, err := s.client.ListObjects(params)
 	if err != nil {
-		return time.Time{}, errors.Wrap(err, "Searching for file")
+		return time.Time{}, errors.Wrap(classifyError(err), "Searching for file")
 	}
 
 	if len(resp.Contents) == 0 {
@@ -339,3 +640,16 @@ func (s *SimpleStorageService) LastModified(ctx context.Context, objectID string
 
 	return *resp.Contents[0].LastModified, nil
 }
+
+// HealthCheck checks if the bucket is reachable with a HEAD request.
+func (s *SimpleStorageService) HealthCheck(ctx context.Context) error {
+	params := &s3.HeadBucketInput{
+		Bucket: aws.String(s.bucket),
+	}
+
+	if _, err := s.client.HeadBucketWithContext(ctx, params); err != nil {
+		return errors.Wrap(err, "Checking bucket")
+	}
+
+	return nil
+}
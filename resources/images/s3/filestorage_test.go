@@ -13,3 +13,155 @@
 //    limitations under the License.
 
 package s3
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images/model"
+)
+
+func TestGetRequestResume(t *testing.T) {
+	data := "0123456789"
+	var requests []string
+
+	srv := newFakeS3(t, "tenant/artifact", data, &requests)
+	defer srv.Close()
+
+	storage := newTestStorage(srv.URL)
+
+	link, err := storage.GetRequest(context.Background(), "tenant/artifact", "",
+		time.Hour, "application/vnd.mender-artifact")
+	assert.NoError(t, err)
+	assert.True(t, link.AcceptRanges)
+
+	// the range header is not part of the signature
+	uri, err := url.Parse(link.Uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "host", uri.Query().Get("X-Amz-SignedHeaders"))
+	assert.Empty(t, uri.Query().Get("versionId"))
+
+	// resuming the download from the given offset
+	req, _ := http.NewRequest(http.MethodGet, link.Uri, nil)
+	req.Header.Set("Range", "bytes=4-")
+	rsp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer rsp.Body.Close()
+
+	assert.Equal(t, http.StatusPartialContent, rsp.StatusCode)
+	body, err := ioutil.ReadAll(rsp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "456789", string(body))
+	assert.Equal(t, []string{"bytes=4-"}, requests)
+
+	// the link is pinned to the given version
+	link, err = storage.GetRequest(context.Background(), "tenant/artifact", "v+1",
+		time.Hour, "application/vnd.mender-artifact")
+	assert.NoError(t, err)
+	uri, err = url.Parse(link.Uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "v+1", uri.Query().Get("versionId"))
+	assert.Equal(t, []string{"bytes=4-"}, requests)
+}
+
+// newFakeS3Copy serves the server side copies of the object, recording
+// the copy requests.
+func newFakeS3Copy(t *testing.T, key string, size int, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		_, uploads := query["uploads"]
+		switch {
+		case r.Method == http.MethodHead:
+			if r.URL.Path != "/bucket/"+key {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+			w.Header().Set("X-Amz-Version-Id", "v+1")
+		case r.Method == http.MethodPost && uploads:
+			*requests = append(*requests, "create "+r.URL.Path)
+			w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>`))
+		case r.Method == http.MethodPut && query.Get("partNumber") != "":
+			*requests = append(*requests, "part "+query.Get("partNumber")+" "+
+				r.Header.Get("X-Amz-Copy-Source")+" "+
+				r.Header.Get("X-Amz-Copy-Source-Range"))
+			w.Write([]byte(`<CopyPartResult><ETag>"etag"</ETag></CopyPartResult>`))
+		case r.Method == http.MethodPut:
+			*requests = append(*requests, "copy "+r.URL.Path+" "+
+				r.Header.Get("X-Amz-Copy-Source"))
+			w.Write([]byte(`<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`))
+		case r.Method == http.MethodPost:
+			*requests = append(*requests, "complete "+query.Get("uploadId"))
+			w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"etag"</ETag></CompleteMultipartUploadResult>`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+}
+
+func TestCopyObject(t *testing.T) {
+	defer func(maxSize, partSize int64) {
+		maxCopyObjectSize, copyPartSize = maxSize, partSize
+	}(maxCopyObjectSize, copyPartSize)
+	maxCopyObjectSize, copyPartSize = 4, 3
+
+	testCases := map[string]struct {
+		size     int
+		requests []string
+	}{
+		"single request": {
+			size: 4,
+			requests: []string{
+				"copy /bucket/tenant/copy bucket/tenant/my%20artifact%2B1?versionId=v%2B1",
+			},
+		},
+		"in parts": {
+			size: 10,
+			requests: []string{
+				"create /bucket/tenant/copy",
+				"part 1 bucket/tenant/my%20artifact%2B1?versionId=v%2B1 bytes=0-2",
+				"part 2 bucket/tenant/my%20artifact%2B1?versionId=v%2B1 bytes=3-5",
+				"part 3 bucket/tenant/my%20artifact%2B1?versionId=v%2B1 bytes=6-8",
+				"part 4 bucket/tenant/my%20artifact%2B1?versionId=v%2B1 bytes=9-9",
+				"complete upload",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var requests []string
+			srv := newFakeS3Copy(t, "tenant/my artifact+1", tc.size, &requests)
+			defer srv.Close()
+
+			storage := newTestStorage(srv.URL)
+
+			err := storage.CopyObject(context.Background(),
+				"tenant/missing", "tenant/copy")
+			assert.Equal(t, model.ErrFileStorageFileNotFound, err)
+
+			err = storage.CopyObject(context.Background(),
+				"tenant/my artifact+1", "tenant/copy")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.requests, requests)
+		})
+	}
+}
+
+func TestCopySource(t *testing.T) {
+	storage := &SimpleStorageService{bucket: "bucket"}
+
+	assert.Equal(t, "bucket/tenant/artifact",
+		storage.copySource("tenant/artifact", ""))
+	assert.Equal(t, "bucket/tenant/a%3Fb%23c%20d?versionId=v%2B1",
+		storage.copySource("tenant/a?b#c d", "v+1"))
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var (
+	errNegativeOffset = errors.New("Seeking to negative offset")
+	errInvalidWhence  = errors.New("Invalid whence")
+)
+
+// objectReader reads the object with range requests, starting from
+// the current offset. The object is fetched lazily on read, seeking
+// drops the current response so that the next read starts at the new offset.
+type objectReader struct {
+	s        *SimpleStorageService
+	objectID string
+	size     int64
+	offset   int64
+	body     io.ReadCloser
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		resp, err := o.s.client.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(o.s.bucket),
+			Key:    aws.String(o.objectID),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", o.offset)),
+		})
+		if err != nil {
+			return 0, err
+		}
+		o.body = resp.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return o.offset, errInvalidWhence
+	}
+
+	if offset < 0 {
+		return o.offset, errNegativeOffset
+	}
+
+	if offset != o.offset {
+		o.closeBody()
+		o.offset = offset
+	}
+	return offset, nil
+}
+
+func (o *objectReader) Close() error {
+	return o.closeBody()
+}
+
+func (o *objectReader) closeBody() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
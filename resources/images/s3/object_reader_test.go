@@ -0,0 +1,138 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/resources/images/model"
+)
+
+// newFakeS3 serves the object from memory, supporting open ended
+// range requests only, as used by objectReader.
+func newFakeS3(t *testing.T, key, data string, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bucket/"+key {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		case http.MethodGet:
+			rng := r.Header.Get("Range")
+			*requests = append(*requests, rng)
+
+			var start int
+			_, err := fmt.Sscanf(rng, "bytes=%d-", &start)
+			assert.NoError(t, err)
+			w.Header().Set("Content-Range",
+				fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, data[start:])
+		}
+	}))
+}
+
+func newTestStorage(url string) *SimpleStorageService {
+	config := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("key", "secret", "")).
+		WithRegion("us-east-1").
+		WithEndpoint(url).
+		WithDisableSSL(true).
+		WithS3ForcePathStyle(true)
+
+	return &SimpleStorageService{
+		client: s3.New(session.New(config)),
+		bucket: "bucket",
+	}
+}
+
+func TestOpenObject(t *testing.T) {
+	data := "0123456789"
+	var requests []string
+
+	srv := newFakeS3(t, "tenant/artifact", data, &requests)
+	defer srv.Close()
+
+	storage := newTestStorage(srv.URL)
+
+	_, err := storage.OpenObject(context.Background(), "tenant/missing")
+	assert.Equal(t, model.ErrFileStorageFileNotFound, err)
+
+	file, err := storage.OpenObject(context.Background(), "tenant/artifact")
+	assert.NoError(t, err)
+	defer file.Close()
+
+	// size is known without fetching the object
+	size, err := file.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), size)
+	assert.Empty(t, requests)
+
+	// reading at the end
+	n, err := file.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+
+	// reading from the middle
+	_, err = file.Seek(4, io.SeekStart)
+	assert.NoError(t, err)
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(file, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "456", string(buf))
+
+	// the response is reused while reading sequentially
+	rest, err := ioutil.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "789", string(rest))
+	assert.Equal(t, []string{"bytes=4-"}, requests)
+
+	// seeking back fetches the object again
+	_, err = file.Seek(-8, io.SeekCurrent)
+	assert.NoError(t, err)
+	rest, err = ioutil.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, data[2:], string(rest))
+	assert.Equal(t, []string{"bytes=4-", "bytes=2-"}, requests)
+
+	// invalid seeks
+	_, err = file.Seek(-1, io.SeekStart)
+	assert.Error(t, err)
+	_, err = file.Seek(0, 42)
+	assert.Error(t, err)
+
+	// reading the whole object
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	all, err := ioutil.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, data, string(all))
+}
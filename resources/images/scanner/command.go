@@ -0,0 +1,110 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package scanner scans the artifact files for malware with the external
+// tools, before the artifacts become available.
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Exit codes of the scan command, as of clamscan and clamdscan
+const (
+	ExitClean    = 0
+	ExitInfected = 1
+)
+
+// UnknownThreat is reported when malware is found but the command
+// does not name it
+const UnknownThreat = "unknown"
+
+// ErrEmptyCommand is returned when no scan command is given
+var ErrEmptyCommand = errors.New("Scan command is empty")
+
+// CommandScanner scans the artifact files with an external command, e.g.
+// clamdscan, which reads the file from the standard input. The command exits
+// with ExitClean if the file is clean, and with ExitInfected if malware is
+// found; the threat is named in the output line ending with "FOUND", as in
+// "stdin: Eicar-Signature FOUND". Any other exit code is a failure.
+type CommandScanner struct {
+	name string
+	args []string
+}
+
+// NewCommandScanner creates the scanner running the command, given as the
+// program followed by its arguments, e.g. "clamdscan", "--no-summary", "-".
+func NewCommandScanner(command []string) (*CommandScanner, error) {
+	if len(command) == 0 || command[0] == "" {
+		return nil, ErrEmptyCommand
+	}
+	return &CommandScanner{name: command[0], args: command[1:]}, nil
+}
+
+// Scan runs the command with the artifact file as its input, returns the name
+// of the threat found, empty if the file is clean. The command is killed
+// once the context is done.
+func (s *CommandScanner) Scan(ctx context.Context, artifact io.Reader) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, s.name, s.args...)
+	cmd.Stdin = artifact
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err == nil {
+		return "", nil
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		if ok && status.ExitStatus() == ExitInfected {
+			return threatName(stdout.String()), nil
+		}
+	}
+
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return "", fmt.Errorf("%s failed: %v: %s", s.name, err, msg)
+	}
+	return "", fmt.Errorf("%s failed: %v", s.name, err)
+}
+
+// threatName finds the name of the threat in the output of the command.
+func threatName(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasSuffix(line, " FOUND") {
+			continue
+		}
+		line = strings.TrimSuffix(line, " FOUND")
+		if i := strings.LastIndex(line, ": "); i >= 0 {
+			line = line[i+2:]
+		}
+		if line != "" {
+			return line
+		}
+	}
+	return UnknownThreat
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package scanner
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCommandScanner(t *testing.T) {
+	_, err := NewCommandScanner(nil)
+	assert.Equal(t, ErrEmptyCommand, err)
+
+	_, err = NewCommandScanner([]string{""})
+	assert.Equal(t, ErrEmptyCommand, err)
+
+	_, err = NewCommandScanner([]string{"clamdscan", "-"})
+	assert.NoError(t, err)
+}
+
+func TestCommandScannerScan(t *testing.T) {
+	testCases := map[string]struct {
+		script string
+
+		threat string
+		err    string
+	}{
+		"clean": {
+			script: `cat >/dev/null; echo "stdin: OK"`,
+		},
+		"infected": {
+			script: `cat >/dev/null; echo "stdin: Eicar-Signature FOUND"; exit 1`,
+
+			threat: "Eicar-Signature",
+		},
+		"infected, threat not named": {
+			script: `cat >/dev/null; exit 1`,
+
+			threat: UnknownThreat,
+		},
+		"reads the artifact": {
+			script: `grep -q "^artifact$" || exit 1`,
+		},
+		"failure": {
+			script: `echo "can't connect to clamd" >&2; exit 2`,
+
+			err: "sh failed: exit status 2: can't connect to clamd",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			scanner, err := NewCommandScanner([]string{"sh", "-c", tc.script})
+			assert.NoError(t, err)
+
+			threat, err := scanner.Scan(context.Background(),
+				strings.NewReader("artifact\n"))
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.threat, threat)
+		})
+	}
+}
+
+func TestCommandScannerScanTimeout(t *testing.T) {
+	scanner, err := NewCommandScanner([]string{"sleep", "10"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = scanner.Scan(ctx, strings.NewReader("artifact"))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+// ImageSchemaVersion is the schema version of the stored images, written
+// on create and edit. Adding a field the older images lack requires
+// bumping the version along with the upgrade step filling its default.
+const ImageSchemaVersion = 1
+
+// imageUpgrades fill the defaults of the fields missing in the image
+// of the version of the index, resulting in the next version. Fields set
+// since by the partial updates are kept.
+var imageUpgrades = []func(*SoftwareImage){
+	// images stored before the versioning, predating the statuses,
+	// the lifecycle states and the checksums by algorithm
+	func(s *SoftwareImage) {
+		if s.Status == "" {
+			s.Status = ImageStatusReady
+		}
+		if s.State == "" {
+			s.State = ImageStateUploaded
+		}
+		if s.Checksums == nil && s.Checksum != "" {
+			s.Checksums = Checksums{ChecksumSHA256: s.Checksum}
+		}
+	},
+}
+
+// Upgrade brings the image read from the storage to the current schema
+// version, so that the readers don't have to special-case the images
+// stored with the older versions. Images of newer versions, written by
+// a newer release, are left intact.
+func (s *SoftwareImage) Upgrade() {
+	for s.SchemaVersion >= 0 && s.SchemaVersion < ImageSchemaVersion {
+		imageUpgrades[s.SchemaVersion](s)
+		s.SchemaVersion++
+	}
+}
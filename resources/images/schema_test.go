@@ -0,0 +1,51 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftwareImageUpgrade(t *testing.T) {
+	sha256Sum := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	assert.Len(t, imageUpgrades, ImageSchemaVersion)
+
+	legacy := &SoftwareImage{Checksum: sha256Sum}
+	legacy.Upgrade()
+	assert.Equal(t, ImageSchemaVersion, legacy.SchemaVersion)
+	assert.Equal(t, ImageStatusReady, legacy.Status)
+	assert.Equal(t, ImageStateUploaded, legacy.State)
+	assert.Equal(t, Checksums{ChecksumSHA256: sha256Sum}, legacy.Checksums)
+
+	pending := &SoftwareImage{
+		Status:    ImageStatusPending,
+		State:     ImageStateApproved,
+		Checksums: Checksums{ChecksumMD5: "md5"},
+	}
+	pending.Upgrade()
+	assert.Equal(t, ImageSchemaVersion, pending.SchemaVersion)
+	assert.Equal(t, ImageStatusPending, pending.Status)
+	assert.Equal(t, ImageStateApproved, pending.State)
+	assert.Equal(t, Checksums{ChecksumMD5: "md5"}, pending.Checksums)
+
+	newer := &SoftwareImage{SchemaVersion: ImageSchemaVersion + 1}
+	newer.Upgrade()
+	assert.Equal(t, ImageSchemaVersion+1, newer.SchemaVersion)
+	assert.Empty(t, newer.Status)
+	assert.Empty(t, newer.State)
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// MaxSharedTenants is the maximal number of tenants an image can be shared with
+const MaxSharedTenants = 100
+
+// same as the IDs of the tenants the databases are provisioned for
+var sharedTenantRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,44}$`)
+
+var (
+	ErrSharedTenantsCount   = errors.Errorf("Artifact can be shared with at most %d tenants", MaxSharedTenants)
+	ErrSharedTenantInvalid  = errors.New("Invalid tenant ID: expected letters, digits, '_' and '-' only")
+	ErrSharedTenantRepeated = errors.New("Tenant listed more than once")
+)
+
+// ImageSharing lists the tenants the image is shared with, besides
+// the tenant owning it. The image is private if the list is empty.
+type ImageSharing struct {
+	SharedWith []string `json:"shared_with"`
+}
+
+// Validate checks the tenant IDs are valid and not repeated.
+func (s *ImageSharing) Validate() error {
+	if len(s.SharedWith) > MaxSharedTenants {
+		return ErrSharedTenantsCount
+	}
+
+	seen := make(map[string]bool, len(s.SharedWith))
+	for _, tenant := range s.SharedWith {
+		if !sharedTenantRegexp.MatchString(tenant) {
+			return ErrSharedTenantInvalid
+		}
+		if seen[tenant] {
+			return ErrSharedTenantRepeated
+		}
+		seen[tenant] = true
+	}
+	return nil
+}
+
+// SharedImage is kept by the tenant the image is shared with, pointing
+// to the tenant owning the image.
+type SharedImage struct {
+	// Image ID
+	Id string `json:"id" bson:"_id"`
+
+	// Tenant owning the image
+	Owner string `json:"owner" bson:"owner"`
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageSharingValidate(t *testing.T) {
+	tooMany := make([]string, MaxSharedTenants+1)
+	for i := range tooMany {
+		tooMany[i] = strings.Repeat("a", i%44+1) + strings.Repeat("b", i/44)
+	}
+
+	testCases := map[string]struct {
+		sharing ImageSharing
+		err     error
+	}{
+		"shared": {
+			sharing: ImageSharing{SharedWith: []string{
+				"58be8208dd77460001fe0d78", "child_tenant-2",
+			}},
+		},
+		"private": {
+			sharing: ImageSharing{},
+		},
+		"invalid tenant": {
+			sharing: ImageSharing{SharedWith: []string{"child/tenant"}},
+			err:     ErrSharedTenantInvalid,
+		},
+		"empty tenant": {
+			sharing: ImageSharing{SharedWith: []string{""}},
+			err:     ErrSharedTenantInvalid,
+		},
+		"tenant too long": {
+			sharing: ImageSharing{SharedWith: []string{strings.Repeat("a", 45)}},
+			err:     ErrSharedTenantInvalid,
+		},
+		"repeated tenant": {
+			sharing: ImageSharing{SharedWith: []string{"child", "child"}},
+			err:     ErrSharedTenantRepeated,
+		},
+		"too many tenants": {
+			sharing: ImageSharing{SharedWith: tooMany},
+			err:     ErrSharedTenantsCount,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.err, tc.sharing.Validate())
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"errors"
+)
+
+var ErrTagsUpdateNoArtifacts = errors.New("Artifacts to update required: expected ids or device_type")
+
+// TagsUpdate adds or removes the tag of all the selected images at once.
+// Images are selected by the IDs, the device type they are compatible with,
+// or both.
+type TagsUpdate struct {
+	// Tag to add or remove
+	Tag string `json:"tag"`
+
+	// IDs of the images
+	IDs []string `json:"ids"`
+
+	// Images compatible with the device type
+	DeviceType string `json:"device_type"`
+}
+
+// Validate checks the tag format, same as of the tags of single image,
+// and that any images are selected.
+func (u *TagsUpdate) Validate() error {
+	if err := ValidateTag(u.Tag); err != nil {
+		return err
+	}
+	if len(u.IDs) == 0 && NormalizeDeviceType(u.DeviceType) == "" {
+		return ErrTagsUpdateNoArtifacts
+	}
+	return nil
+}
+
+// TagsUpdateReport summarizes the bulk update of the tags.
+type TagsUpdateReport struct {
+	// Number of images the tag was added to or removed from
+	Modified int `json:"modified"`
+}
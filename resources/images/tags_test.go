@@ -0,0 +1,64 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagsUpdateValidate(t *testing.T) {
+	testCases := map[string]struct {
+		update TagsUpdate
+		err    error
+	}{
+		"ids": {
+			update: TagsUpdate{Tag: "stable", IDs: []string{validUUIDv4}},
+		},
+		"device type": {
+			update: TagsUpdate{Tag: "stable", DeviceType: "beaglebone"},
+		},
+		"both": {
+			update: TagsUpdate{
+				Tag:        "stable",
+				IDs:        []string{validUUIDv4},
+				DeviceType: "beaglebone",
+			},
+		},
+		"no tag": {
+			update: TagsUpdate{IDs: []string{validUUIDv4}},
+			err:    ErrInvalidTag,
+		},
+		"invalid tag": {
+			update: TagsUpdate{Tag: "with space", IDs: []string{validUUIDv4}},
+			err:    ErrInvalidTag,
+		},
+		"no artifacts": {
+			update: TagsUpdate{Tag: "stable"},
+			err:    ErrTagsUpdateNoArtifacts,
+		},
+		"blank device type": {
+			update: TagsUpdate{Tag: "stable", DeviceType: " "},
+			err:    ErrTagsUpdateNoArtifacts,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.err, tc.update.Validate())
+		})
+	}
+}
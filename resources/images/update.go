@@ -18,27 +18,28 @@ import "time"
 
 // Type info structure
 type ArtifactUpdateTypeInfo struct {
-	Type string `json:"type" valid:"required"`
+	Type string `json:"type" xml:"type" valid:"required"`
 }
 
 // Update file structure
 type UpdateFile struct {
 	// Image name
-	Name string `json:"name" valid:"required"`
+	Name string `json:"name" xml:"name" valid:"required"`
 
 	// Image file checksum
-	Checksum string `json:"checksum" valid:"optional"`
+	Checksum string `json:"checksum" xml:"checksum" valid:"optional"`
 
 	// Image size
-	Size int64 `json:"size" valid:"optional"`
+	Size int64 `json:"size" xml:"size" valid:"optional"`
 
 	// Date build
-	Date *time.Time `json:"date" valid:"optional"`
+	Date *time.Time `json:"date" xml:"date" valid:"optional"`
 }
 
 // Update structure
 type Update struct {
-	TypeInfo ArtifactUpdateTypeInfo `json:"type_info" valid:"required"`
-	Files    []UpdateFile           `json:"files"`
-	MetaData interface{}            `json:"meta_data,omitempty" valid:"optional"`
+	TypeInfo ArtifactUpdateTypeInfo `json:"type_info" xml:"type_info" valid:"required"`
+	Files    []UpdateFile           `json:"files" xml:"files>file"`
+	// Free form metadata has no XML representation
+	MetaData interface{} `json:"meta_data,omitempty" xml:"-" valid:"optional"`
 }
@@ -0,0 +1,286 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+import (
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/satori/go.uuid"
+)
+
+// UploadSessionConstructor is the user provided part of the upload session
+type UploadSessionConstructor struct {
+	// Total size of the artifact file
+	Size int64 `json:"size" bson:"size" valid:"required"`
+
+	// Image description
+	Description string `json:"description,omitempty" bson:"description" valid:"length(1|4096),optional"`
+
+	// Optional SHA256 checksum (hex encoded) of the whole artifact file,
+	// kept for the clients predating the checksums
+	Checksum string `json:"checksum,omitempty" bson:"checksum" valid:"hexadecimal,length(64|64),optional"`
+
+	// Optional checksums of the whole artifact file by algorithm
+	Checksums Checksums `json:"checksums,omitempty" bson:"checksums,omitempty" valid:"-"`
+}
+
+// Validate checks the constructor, the checksum can be given with either
+// of the fields, but SHA256 one given with both has to be the same.
+func (s *UploadSessionConstructor) Validate() error {
+	if _, err := govalidator.ValidateStruct(s); err != nil {
+		return err
+	}
+	if err := s.Checksums.Validate(); err != nil {
+		return err
+	}
+	if sum, ok := s.Checksums[ChecksumSHA256]; ok && s.Checksum != "" &&
+		!strings.EqualFold(sum, s.Checksum) {
+		return ErrConflictingChecksums
+	}
+	// the description is the only metadata given with the upload,
+	// so it is rejected early if any other field is required
+	meta := &SoftwareImageMetaConstructor{Description: s.Description}
+	return meta.ValidateRequired()
+}
+
+// ExpectedChecksums returns all the checksums the artifact file has to match,
+// given with either of the fields.
+func (s *UploadSessionConstructor) ExpectedChecksums() Checksums {
+	expected := make(Checksums, len(s.Checksums)+1)
+	for algorithm, sum := range s.Checksums {
+		expected[algorithm] = sum
+	}
+	if s.Checksum != "" {
+		expected[ChecksumSHA256] = s.Checksum
+	}
+	return expected
+}
+
+// UploadPart describes a single chunk of the artifact file
+// already stored in the file storage
+type UploadPart struct {
+	Offset int64 `json:"offset" bson:"offset"`
+	Size   int64 `json:"size" bson:"size"`
+
+	// ID of the object holding the chunk data in the file storage
+	ObjectID string `json:"-" bson:"object_id"`
+
+	// State of the checksums of the artifact file up to the end of the
+	// chunk; missing for the chunks received before it was recorded
+	ChecksumsState ChecksumsState `json:"-" bson:"checksums_state,omitempty"`
+}
+
+// UploadSession tracks the state of a resumable artifact upload
+type UploadSession struct {
+	UploadSessionConstructor `bson:",inline"`
+
+	// Upload session ID
+	Id string `json:"id" bson:"_id" valid:"uuidv4,required"`
+
+	// Number of bytes received so far
+	Offset int64 `json:"offset" bson:"offset"`
+
+	// Chunks received so far, ordered by offset
+	Parts []UploadPart `json:"-" bson:"parts"`
+
+	// Session creation time
+	Created time.Time `json:"created" bson:"created"`
+
+	// Time after which the session is considered abandoned
+	Expire time.Time `json:"expire" bson:"expire"`
+
+	// Set if the artifact file is uploaded directly to the file storage
+	// with the pre-signed link instead of in chunks
+	Direct bool `json:"direct,omitempty" bson:"direct,omitempty"`
+
+	// Either open or finalizing; missing for the sessions created before
+	// it was recorded, these are open
+	Status string `json:"-" bson:"status,omitempty"`
+}
+
+// Statuses of the upload session
+const (
+	// The artifact file is being uploaded
+	UploadStatusOpen = "open"
+	// The session is claimed to create the artifact, it can't be
+	// finalized again until it's open again
+	UploadStatusFinalizing = "finalizing"
+)
+
+// DirectUpload is the upload session, which artifact file is uploaded
+// directly to the file storage using the pre-signed link
+type DirectUpload struct {
+	// Upload session ID
+	Id string `json:"id"`
+
+	// Pre-signed link the artifact file has to be uploaded with
+	Link *Link `json:"link"`
+}
+
+// NewUploadSession creates new upload session valid for the given duration.
+func NewUploadSession(constructor *UploadSessionConstructor,
+	expire time.Duration) *UploadSession {
+
+	now := time.Now()
+
+	return &UploadSession{
+		UploadSessionConstructor: *constructor,
+		Id:                       uuid.NewV4().String(),
+		Parts:                    []UploadPart{},
+		Created:                  now,
+		Expire:                   now.Add(expire),
+		Status:                   UploadStatusOpen,
+	}
+}
+
+// IsExpired checks if the session expired at the given time.
+func (s *UploadSession) IsExpired(when time.Time) bool {
+	return when.After(s.Expire)
+}
+
+// IsComplete checks if all the artifact bytes were received.
+func (s *UploadSession) IsComplete() bool {
+	return s.Offset == s.Size
+}
+
+// Stages of the upload session
+const (
+	// Chunks are being received
+	UploadStageReceiving = "receiving"
+	// The session is finalized: the artifact is parsed and stored
+	UploadStageProcessing = "processing"
+	// The artifact is created
+	UploadStageDone = "done"
+	// Finalizing the session failed, it can be retried
+	UploadStageFailed = "failed"
+)
+
+// UploadProgress tells how far the upload session is, in the stage it is in.
+type UploadProgress struct {
+	Stage string `json:"stage"`
+
+	// Number of bytes received or processed so far
+	Bytes int64 `json:"bytes"`
+
+	// Size of the artifact
+	Size int64 `json:"size"`
+
+	// Bytes in percents of the size, rounded down
+	Percentage int `json:"percentage"`
+
+	// ID of the artifact created, once done
+	ImageId string `json:"image_id,omitempty"`
+}
+
+// NewUploadProgress creates the progress of the stage.
+func NewUploadProgress(stage string, bytes, size int64) *UploadProgress {
+	progress := &UploadProgress{
+		Stage: stage,
+		Bytes: bytes,
+		Size:  size,
+	}
+	if size > 0 {
+		progress.Percentage = int(bytes * 100 / size)
+	}
+	if progress.Percentage > 100 {
+		progress.Percentage = 100
+	}
+	return progress
+}
+
+// IsFinal checks if the progress won't change anymore.
+func (p *UploadProgress) IsFinal() bool {
+	return p.Stage == UploadStageDone || p.Stage == UploadStageFailed
+}
+
+// Kinds of the incomplete uploads
+const (
+	// Resumable upload session, the artifact file is sent in chunks
+	IncompleteUploadChunked = "chunked"
+	// Upload session, the artifact file is uploaded with the pre-signed link
+	IncompleteUploadDirect = "direct"
+	// Pending artifact, the artifact file is not uploaded yet
+	IncompleteUploadPending = "pending"
+)
+
+// IncompleteUpload describes the upload not finished yet, for the operators
+type IncompleteUpload struct {
+	// ID of the upload session or of the pending artifact
+	Id string `json:"id"`
+
+	// Tenant the upload belongs to, empty in single tenant setup
+	Tenant string `json:"tenant,omitempty"`
+
+	// One of IncompleteUpload* constants
+	Kind string `json:"kind"`
+
+	// Declared size of the artifact file, 0 if not known
+	Size int64 `json:"size"`
+
+	// Number of bytes received so far, 0 if not known
+	Received int64 `json:"received"`
+
+	// Upload start time
+	Created time.Time `json:"created"`
+
+	// Seconds since the upload started
+	Age int64 `json:"age"`
+}
+
+// NewIncompleteUpload describes the upload session as seen at the given time.
+func NewIncompleteUpload(tenant string, session *UploadSession,
+	now time.Time) *IncompleteUpload {
+
+	upload := &IncompleteUpload{
+		Id:       session.Id,
+		Tenant:   tenant,
+		Kind:     IncompleteUploadChunked,
+		Size:     session.Size,
+		Received: session.Offset,
+		Created:  session.Created,
+		Age:      int64(now.Sub(session.Created) / time.Second),
+	}
+	if session.Direct {
+		upload.Kind = IncompleteUploadDirect
+	}
+	return upload
+}
+
+// NewPendingUpload describes the pending image as seen at the given time.
+func NewPendingUpload(tenant string, image *SoftwareImage,
+	now time.Time) *IncompleteUpload {
+
+	upload := &IncompleteUpload{
+		Id:     image.Id,
+		Tenant: tenant,
+		Kind:   IncompleteUploadPending,
+	}
+	if image.Created != nil {
+		upload.Created = *image.Created
+		upload.Age = int64(now.Sub(*image.Created) / time.Second)
+	}
+	return upload
+}
+
+// IncompleteUploadsReport summarizes the purge of the incomplete uploads.
+type IncompleteUploadsReport struct {
+	// Uploads removed along with their stored data
+	Purged []*IncompleteUpload `json:"purged"`
+
+	// Uploads which could not be removed, e.g. due to storage errors
+	Failed []*IncompleteUpload `json:"failed"`
+}
@@ -0,0 +1,25 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+// Usage sums up the artifacts of the tenant
+type Usage struct {
+	// Number of the artifacts, pending ones included
+	Artifacts int64 `json:"artifacts" bson:"artifacts"`
+
+	// Total size of the artifact files in bytes, the artifacts uploaded
+	// before the size was recorded are not accounted
+	Size int64 `json:"size" bson:"size"`
+}
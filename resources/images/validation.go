@@ -0,0 +1,41 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package images
+
+// ArtifactValidation reports the artifact file checked without storing it:
+// the metadata parsed out of it and the problems found, which would make
+// the upload of the same file fail.
+type ArtifactValidation struct {
+	// Valid is set if no problems were found
+	Valid bool `json:"valid"`
+
+	// Metadata of the artifact, unset if the file could not be parsed
+	Artifact *SoftwareImageMetaArtifactConstructor `json:"artifact,omitempty"`
+
+	// Size of the artifact file in bytes
+	Size int64 `json:"size"`
+
+	// Checksums of the artifact file by algorithm
+	Checksums Checksums `json:"checksums,omitempty"`
+
+	// Problems found, in the order of the checks
+	Problems []string `json:"problems"`
+}
+
+// AddProblem records the problem found, the artifact is not valid then.
+func (v *ArtifactValidation) AddProblem(err error) {
+	v.Problems = append(v.Problems, err.Error())
+	v.Valid = false
+}
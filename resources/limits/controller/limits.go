@@ -61,8 +61,14 @@ func (s *LimitsController) GetLimit(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
-	s.view.RenderSuccessGet(w, limitResponse{
+	usage, err := s.model.GetUsage(r.Context(), name)
+	if err != nil {
+		s.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	s.view.RenderSuccessGet(w, r, limitResponse{
 		Limit: limit.Value,
-		Usage: 0, // TODO fill this when ready
+		Usage: usage,
 	})
 }
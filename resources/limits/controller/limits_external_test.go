@@ -63,26 +63,38 @@ func setUpRestTest(route string, routeType routerTypeHandler,
 func TestGetLimits(t *testing.T) {
 
 	testCases := []struct {
-		name  string
-		code  int
-		body  string
-		err   error
-		limit *limits.Limit
+		name     string
+		code     int
+		body     string
+		err      error
+		limit    *limits.Limit
+		usage    uint64
+		usageErr error
 	}{
 		{
 			name: "storage",
 			code: http.StatusOK,
-			body: `{"limit":200,"usage":0}`,
+			body: `{"limit":200,"usage":150}`,
 			limit: &limits.Limit{
 				Name:  "storage",
 				Value: 200,
 			},
+			usage: 150,
 		},
 		{
 			name: "storage",
 			code: http.StatusInternalServerError,
 			err:  errors.New("failed"),
 		},
+		{
+			name: "storage",
+			code: http.StatusInternalServerError,
+			limit: &limits.Limit{
+				Name:  "storage",
+				Value: 200,
+			},
+			usageErr: errors.New("failed"),
+		},
 		{
 			name: "foobar",
 			code: http.StatusBadRequest,
@@ -102,6 +114,10 @@ func TestGetLimits(t *testing.T) {
 				limitsModel.On("GetLimit", contextMatcher(), tc.name).
 					Return(tc.limit, tc.err)
 			}
+			if tc.limit != nil {
+				limitsModel.On("GetUsage", contextMatcher(), tc.name).
+					Return(tc.usage, tc.usageErr)
+			}
 
 			recorded := test.RunRequest(t, api.MakeHandler(),
 				test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/limits/"+tc.name,
@@ -25,4 +25,5 @@ var ()
 
 type LimitsModel interface {
 	GetLimit(ctx context.Context, name string) (*limits.Limit, error)
+	GetUsage(ctx context.Context, name string) (uint64, error)
 }
@@ -47,4 +47,25 @@ func (_m *LimitsModel) GetLimit(ctx context.Context, name string) (*limits.Limit
 	return r0, r1
 }
 
+// GetUsage provides a mock function with given fields: ctx, name
+func (_m *LimitsModel) GetUsage(ctx context.Context, name string) (uint64, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 uint64
+	if rf, ok := ret.Get(0).(func(context.Context, string) uint64); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Get(0).(uint64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 var _ controller.LimitsModel = (*LimitsModel)(nil)
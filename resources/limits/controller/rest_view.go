@@ -20,7 +20,7 @@ import (
 )
 
 type RESTView interface {
-	RenderSuccessGet(w rest.ResponseWriter, object interface{})
+	RenderSuccessGet(w rest.ResponseWriter, r *rest.Request, object interface{})
 	RenderError(w rest.ResponseWriter, r *rest.Request, err error, status int, l *log.Logger)
 	RenderInternalError(w rest.ResponseWriter, r *rest.Request, err error, l *log.Logger)
 }
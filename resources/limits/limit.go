@@ -16,10 +16,12 @@ package limits
 
 const (
 	LimitStorage = "storage"
+	// LimitArtifacts caps the number of the artifacts of the tenant
+	LimitArtifacts = "artifacts"
 )
 
 var (
-	ValidLimits = []string{LimitStorage}
+	ValidLimits = []string{LimitStorage, LimitArtifacts}
 )
 
 type Limit struct {
@@ -24,4 +24,5 @@ func TestValidLimit(t *testing.T) {
 	assert.False(t, IsValidLimit("foo"))
 	assert.False(t, IsValidLimit("bar"))
 	assert.True(t, IsValidLimit(LimitStorage))
+	assert.True(t, IsValidLimit(LimitArtifacts))
 }
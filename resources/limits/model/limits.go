@@ -19,16 +19,24 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/mendersoftware/deployments/resources/images"
 	"github.com/mendersoftware/deployments/resources/limits"
 )
 
+// UsageGetter reports the usage of the artifacts of the tenant
+type UsageGetter interface {
+	GetUsage(ctx context.Context) (*images.Usage, error)
+}
+
 type LimitsModel struct {
 	storage LimitsStorage
+	usage   UsageGetter
 }
 
-func NewLimitsModel(storage LimitsStorage) *LimitsModel {
+func NewLimitsModel(storage LimitsStorage, usage UsageGetter) *LimitsModel {
 	return &LimitsModel{
 		storage: storage,
+		usage:   usage,
 	}
 }
 
@@ -45,3 +53,28 @@ func (lm *LimitsModel) GetLimit(ctx context.Context, name string) (*limits.Limit
 	}
 	return limit, nil
 }
+
+// GetUsage returns the usage of the limited resource, in the units
+// of the limit.
+func (lm *LimitsModel) GetUsage(ctx context.Context, name string) (uint64, error) {
+	switch name {
+	case limits.LimitStorage, limits.LimitArtifacts:
+	default:
+		return 0, nil
+	}
+
+	usage, err := lm.usage.GetUsage(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to obtain usage")
+	}
+
+	value := usage.Size
+	if name == limits.LimitArtifacts {
+		value = usage.Artifacts
+	}
+	// drift is corrected by the reconciliation
+	if value < 0 {
+		return 0, nil
+	}
+	return uint64(value), nil
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"github.com/mendersoftware/deployments/resources/images"
 	"github.com/mendersoftware/deployments/resources/limits"
 	. "github.com/mendersoftware/deployments/resources/limits/model"
 	"github.com/mendersoftware/deployments/resources/limits/model/mocks"
@@ -76,7 +77,7 @@ func TestGetLimit(t *testing.T) {
 					}),
 				tc.name).Return(tc.getLimit, tc.getErr)
 
-			lm := NewLimitsModel(&ls)
+			lm := NewLimitsModel(&ls, nil)
 
 			ctx := context.Background()
 			lim, err := lm.GetLimit(ctx, tc.name)
@@ -93,3 +94,74 @@ func TestGetLimit(t *testing.T) {
 		})
 	}
 }
+
+type fakeUsageGetter struct {
+	usage *images.Usage
+	err   error
+}
+
+func (f *fakeUsageGetter) GetUsage(ctx context.Context) (*images.Usage, error) {
+	return f.usage, f.err
+}
+
+func TestGetUsage(t *testing.T) {
+	testCases := map[string]struct {
+		name  string
+		usage *images.Usage
+		err   error
+
+		expected uint64
+		outErr   error
+	}{
+		"storage": {
+			name:     limits.LimitStorage,
+			usage:    &images.Usage{Artifacts: 2, Size: 1024},
+			expected: 1024,
+		},
+		"storage, negative drift": {
+			name:     limits.LimitStorage,
+			usage:    &images.Usage{Artifacts: -1, Size: -1024},
+			expected: 0,
+		},
+		"storage, error": {
+			name:   limits.LimitStorage,
+			err:    errors.New("error"),
+			outErr: errors.New("failed to obtain usage: error"),
+		},
+		"artifacts": {
+			name:     limits.LimitArtifacts,
+			usage:    &images.Usage{Artifacts: 2, Size: 1024},
+			expected: 2,
+		},
+		"artifacts, negative drift": {
+			name:     limits.LimitArtifacts,
+			usage:    &images.Usage{Artifacts: -1, Size: -1024},
+			expected: 0,
+		},
+		"artifacts, error": {
+			name:   limits.LimitArtifacts,
+			err:    errors.New("error"),
+			outErr: errors.New("failed to obtain usage: error"),
+		},
+		"not tracked": {
+			name:     "foo",
+			usage:    &images.Usage{Artifacts: 2, Size: 1024},
+			expected: 0,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lm := NewLimitsModel(&mocks.LimitsStorage{},
+				&fakeUsageGetter{usage: tc.usage, err: tc.err})
+
+			usage, err := lm.GetUsage(context.Background(), tc.name)
+			if tc.outErr != nil {
+				assert.EqualError(t, err, tc.outErr.Error())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, usage)
+		})
+	}
+}
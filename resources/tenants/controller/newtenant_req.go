@@ -14,21 +14,30 @@
 package controller
 
 import (
-	"encoding/json"
+	"fmt"
 	"io"
+	"regexp"
 
 	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/migrations"
+	"github.com/mendersoftware/deployments/utils/restutil"
 )
 
+// Each tenant has its own database named after the tenant ID; MongoDB limits
+// database names to 63 bytes, without '/\. "$' characters.
+const MaxTenantIdLength = 63 - len(migrations.DbName+"-")
+
+var tenantIdRegexp = regexp.MustCompile(
+	fmt.Sprintf(`^[a-zA-Z0-9_-]{1,%d}$`, MaxTenantIdLength))
+
 type NewTenantReq struct {
 	TenantId string `json:"tenant_id"`
 }
 
 func ParseNewTenantReq(source io.Reader) (*NewTenantReq, error) {
-	jd := json.NewDecoder(source)
-
 	var r NewTenantReq
-	if err := jd.Decode(&r); err != nil {
+	if err := restutil.DecodeJsonObject(source, &r); err != nil {
 		return nil, err
 	}
 
@@ -36,5 +45,10 @@ func ParseNewTenantReq(source io.Reader) (*NewTenantReq, error) {
 		return nil, errors.New("tenant_id must be provided")
 	}
 
+	if !tenantIdRegexp.MatchString(r.TenantId) {
+		return nil, errors.Errorf("tenant_id must be at most %d characters long "+
+			"and contain letters, digits, '_' and '-' only", MaxTenantIdLength)
+	}
+
 	return &r, nil
 }
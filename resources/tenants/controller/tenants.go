@@ -54,3 +54,18 @@ func (c *Controller) ProvisionTenantsHandler(w rest.ResponseWriter, r *rest.Requ
 
 	w.WriteHeader(http.StatusCreated)
 }
+
+// EnsureIndexesHandler creates the missing database indexes and reports
+// the indexes created and the ones already present.
+func (c *Controller) EnsureIndexesHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	reports, err := c.model.EnsureIndexes(ctx)
+	if err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(reports)
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/Sirupsen/logrus"
@@ -27,8 +28,10 @@ import (
 	"github.com/mendersoftware/go-lib-micro/requestid"
 	"github.com/mendersoftware/go-lib-micro/requestlog"
 	mt "github.com/mendersoftware/go-lib-micro/testing"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/mock"
 
+	"github.com/mendersoftware/deployments/migrations"
 	"github.com/mendersoftware/deployments/resources/tenants/model/mocks"
 )
 
@@ -82,6 +85,35 @@ func TestProvisionTenant(t *testing.T) {
 				nil,
 				restError("tenant_id must be provided")),
 		},
+		"error: tenant_id not usable in database name": {
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/deployments/tenants",
+				&NewTenantReq{TenantId: "foo.bar"}),
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("tenant_id must be at most 44 characters long "+
+					"and contain letters, digits, '_' and '-' only")),
+		},
+		"error: tenant_id too long": {
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/deployments/tenants",
+				&NewTenantReq{TenantId: strings.Repeat("a", MaxTenantIdLength+1)}),
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("tenant_id must be at most 44 characters long "+
+					"and contain letters, digits, '_' and '-' only")),
+		},
+		"error: malformed body": {
+			req: test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/deployments/tenants",
+				[]string{"foo"}),
+			checker: mt.NewJSONResponse(
+				http.StatusBadRequest,
+				nil,
+				restError("Malformed request body: JSON object expected")),
+		},
 	}
 
 	for i := range testCases {
@@ -101,6 +133,58 @@ func TestProvisionTenant(t *testing.T) {
 	}
 }
 
+func TestEnsureIndexes(t *testing.T) {
+	reports := []migrations.IndexReport{
+		{
+			Db: "deployment_service",
+			Created: map[string][]string{
+				"images": {"tags"},
+			},
+			Existing: map[string][]string{
+				"images": {"_id_"},
+			},
+		},
+	}
+
+	testCases := map[string]struct {
+		modelReports []migrations.IndexReport
+		modelErr     error
+		checker      mt.ResponseChecker
+	}{
+		"ok": {
+			modelReports: reports,
+			checker: mt.NewJSONResponse(
+				http.StatusOK,
+				nil,
+				reports),
+		},
+		"error": {
+			modelErr: errors.New("connection failed"),
+			checker: mt.NewJSONResponse(
+				http.StatusInternalServerError,
+				nil,
+				restError("internal error")),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			m := &mocks.Model{}
+			m.On("EnsureIndexes", contextMatcher()).Return(tc.modelReports, tc.modelErr)
+			c := NewController(m)
+
+			api := setUpRestTest("/api/internal/v1/deployments/indexes", rest.Post,
+				c.EnsureIndexesHandler)
+
+			req := test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/internal/v1/deployments/indexes", nil)
+			req.Header.Add(requestid.RequestIdHeader, "test")
+			recorded := test.RunRequest(t, api, req)
+			mt.CheckResponse(t, tc.checker, recorded)
+		})
+	}
+}
+
 func restError(status string) map[string]interface{} {
 	return map[string]interface{}{"error": status, "request_id": "test"}
 }
@@ -14,6 +14,7 @@
 package mocks
 
 import context "context"
+import migrations "github.com/mendersoftware/deployments/migrations"
 import mock "github.com/stretchr/testify/mock"
 
 // Model is an autogenerated mock type for the Model type
@@ -21,6 +22,29 @@ type Model struct {
 	mock.Mock
 }
 
+// EnsureIndexes provides a mock function with given fields: ctx
+func (_m *Model) EnsureIndexes(ctx context.Context) ([]migrations.IndexReport, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []migrations.IndexReport
+	if rf, ok := ret.Get(0).(func(context.Context) []migrations.IndexReport); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]migrations.IndexReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ProvisionTenant provides a mock function with given fields: ctx, tenant_id
 func (_m *Model) ProvisionTenant(ctx context.Context, tenant_id string) error {
 	ret := _m.Called(ctx, tenant_id)
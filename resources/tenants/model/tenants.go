@@ -19,11 +19,13 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/mendersoftware/deployments/migrations"
 	"github.com/mendersoftware/deployments/resources/tenants/store"
 )
 
 type Model interface {
 	ProvisionTenant(ctx context.Context, tenant_id string) error
+	EnsureIndexes(ctx context.Context) ([]migrations.IndexReport, error)
 }
 
 type model struct {
@@ -43,3 +45,12 @@ func (m *model) ProvisionTenant(ctx context.Context, tenant_id string) error {
 
 	return nil
 }
+
+func (m *model) EnsureIndexes(ctx context.Context) ([]migrations.IndexReport, error) {
+	reports, err := m.store.EnsureIndexes(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ensure indexes")
+	}
+
+	return reports, nil
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"github.com/mendersoftware/deployments/migrations"
 	. "github.com/mendersoftware/deployments/resources/tenants/model"
 	mstore "github.com/mendersoftware/deployments/resources/tenants/store/mocks"
 )
@@ -70,3 +71,54 @@ func TestProvisionTenant(t *testing.T) {
 		})
 	}
 }
+
+func TestEnsureIndexes(t *testing.T) {
+	reports := []migrations.IndexReport{
+		{
+			Db: "deployment_service",
+			Created: map[string][]string{
+				"images": {"tags"},
+			},
+			Existing: map[string][]string{
+				"images": {"_id_"},
+			},
+		},
+	}
+
+	testCases := map[string]struct {
+		storeReports []migrations.IndexReport
+		storeErr     error
+
+		err error
+	}{
+		"ok": {
+			storeReports: reports,
+		},
+		"error": {
+			storeErr: errors.New("connection failed"),
+			err:      errors.New("failed to ensure indexes: connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := mstore.Store{}
+			s.On("EnsureIndexes",
+				mock.MatchedBy(
+					func(_ context.Context) bool {
+						return true
+					})).Return(tc.storeReports, tc.storeErr)
+
+			m := NewModel(&s)
+
+			res, err := m.EnsureIndexes(context.Background())
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				assert.Nil(t, res)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.storeReports, res)
+			}
+		})
+	}
+}
@@ -19,11 +19,14 @@ import (
 	"gopkg.in/mgo.v2"
 
 	"github.com/mendersoftware/deployments/migrations"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
 	mstore "github.com/mendersoftware/go-lib-micro/store"
 )
 
 type Store interface {
 	ProvisionTenant(ctx context.Context, tenantId string) error
+	ListTenants(ctx context.Context) ([]string, error)
+	EnsureIndexes(ctx context.Context) ([]migrations.IndexReport, error)
 }
 
 type store struct {
@@ -44,3 +47,28 @@ func (ts *store) ProvisionTenant(ctx context.Context, tenantId string) error {
 
 	return migrations.MigrateSingle(ctx, dbname, migrations.DbVersion, session, true)
 }
+
+// ListTenants returns IDs of the tenants which have their own database.
+// Empty list is returned for single tenant setup.
+func (ts *store) ListTenants(ctx context.Context) ([]string, error) {
+	dbs, err := migrate.GetTenantDbs(ts.session, mstore.IsTenantDb(migrations.DbName))
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]string, 0, len(dbs))
+	for _, db := range dbs {
+		tenants = append(tenants, mstore.TenantFromDbName(db, migrations.DbName))
+	}
+
+	return tenants, nil
+}
+
+// EnsureIndexes creates the missing indexes in the databases of all
+// the tenants, or in the default database for single tenant setup.
+func (ts *store) EnsureIndexes(ctx context.Context) ([]migrations.IndexReport, error) {
+	session := ts.session.Copy()
+	defer session.Close()
+
+	return migrations.EnsureAllIndexes(ctx, session)
+}
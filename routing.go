@@ -15,29 +15,43 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
-	"time"
+	"net/http"
+	"regexp"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/pkg/errors"
 	"gopkg.in/mgo.v2"
 
 	"github.com/mendersoftware/deployments/config"
+	"github.com/mendersoftware/deployments/resources/deployments"
 	deploymentsController "github.com/mendersoftware/deployments/resources/deployments/controller"
+	deploymentsMetrics "github.com/mendersoftware/deployments/resources/deployments/metrics"
 	deploymentsModel "github.com/mendersoftware/deployments/resources/deployments/model"
 	deploymentsMongo "github.com/mendersoftware/deployments/resources/deployments/mongo"
 	deploymentsView "github.com/mendersoftware/deployments/resources/deployments/view"
+	"github.com/mendersoftware/deployments/resources/deployments/webhook"
+	healthController "github.com/mendersoftware/deployments/resources/health/controller"
+	healthModel "github.com/mendersoftware/deployments/resources/health/model"
+	"github.com/mendersoftware/deployments/resources/images"
 	imagesController "github.com/mendersoftware/deployments/resources/images/controller"
+	imagesMetrics "github.com/mendersoftware/deployments/resources/images/metrics"
 	imagesModel "github.com/mendersoftware/deployments/resources/images/model"
 	imagesMongo "github.com/mendersoftware/deployments/resources/images/mongo"
 	"github.com/mendersoftware/deployments/resources/images/s3"
+	"github.com/mendersoftware/deployments/resources/images/scanner"
 	limitsController "github.com/mendersoftware/deployments/resources/limits/controller"
 	limitsModel "github.com/mendersoftware/deployments/resources/limits/model"
 	limitsMongo "github.com/mendersoftware/deployments/resources/limits/mongo"
 	tenantsController "github.com/mendersoftware/deployments/resources/tenants/controller"
 	tenantsModel "github.com/mendersoftware/deployments/resources/tenants/model"
 	tenantsStore "github.com/mendersoftware/deployments/resources/tenants/store"
+	"github.com/mendersoftware/deployments/utils/logging"
+	"github.com/mendersoftware/deployments/utils/maintenance"
+	"github.com/mendersoftware/deployments/utils/metrics"
+	"github.com/mendersoftware/deployments/utils/readpref"
 	"github.com/mendersoftware/deployments/utils/restutil"
 	"github.com/mendersoftware/deployments/utils/restutil/view"
 )
@@ -47,14 +61,31 @@ const (
 	ApiUrlManagement = "/api/management/v1/deployments"
 	ApiUrlDevices    = "/api/devices/v1/deployments"
 
-	ApiUrlManagementArtifacts = ApiUrlManagement + "/artifacts"
+	ApiUrlManagementArtifacts        = ApiUrlManagement + "/artifacts"
+	ApiUrlManagementArtifactsUploads = ApiUrlManagementArtifacts + "/uploads"
+	ApiUrlManagementArtifactsDirect  = ApiUrlManagementArtifacts + "/directupload"
 )
 
-func SetupS3(c config.ConfigReader) (imagesModel.FileStorage, error) {
+func SetupS3(c config.ConfigReader) (*s3.SimpleStorageService, error) {
+	return setupS3Bucket(c, c.GetString(SettingAwsS3Bucket), c.GetString(SettingAwsS3Region))
+}
 
-	bucket := c.GetString(SettingAwsS3Bucket)
-	region := c.GetString(SettingAwsS3Region)
+// SetupS3Replicas creates the file storages of the regions the artifact files
+// are replicated to, by region name. Replicas share the credentials and
+// the settings of the primary storage.
+func SetupS3Replicas(c config.ConfigReader) (map[string]imagesModel.FileStorage, error) {
+	replicas := make(map[string]imagesModel.FileStorage)
+	for region, bucket := range c.GetStringMapString(SettingAwsReplicas) {
+		replica, err := setupS3Bucket(c, bucket, region)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to setup replica in %s", region)
+		}
+		replicas[region] = replica
+	}
+	return replicas, nil
+}
 
+func setupS3Bucket(c config.ConfigReader, bucket, region string) (*s3.SimpleStorageService, error) {
 	if c.IsSet(SettingsAwsAuth) || (c.IsSet(SettingAwsAuthKeyId) && c.IsSet(SettingAwsAuthSecret) && c.IsSet(SettingAwsURI)) {
 		return s3.NewSimpleStorageServiceStatic(
 			bucket,
@@ -77,8 +108,9 @@ func NewMongoSession(c config.ConfigReader) (*mgo.Session, error) {
 		return nil, errors.Wrap(err, "failed to open mgo session")
 	}
 
-	// Set 10s timeout - same as set by Dial
-	dialInfo.Timeout = 10 * time.Second
+	dialInfo.Timeout = c.GetDuration(SettingDbConnectTimeout)
+	// zero keeps the driver default
+	dialInfo.PoolLimit = c.GetInt(SettingDbPoolLimit)
 
 	username := c.GetString(SettingDbUsername)
 	if username != "" {
@@ -118,6 +150,10 @@ func NewMongoSession(c config.ConfigReader) (*mgo.Session, error) {
 		J: true,
 	})
 
+	masterSession.SetSocketTimeout(c.GetDuration(SettingDbSocketTimeout))
+	// also bounds the wait for a socket of an exhausted pool
+	masterSession.SetSyncTimeout(c.GetDuration(SettingDbSyncTimeout))
+
 	return masterSession, nil
 }
 
@@ -138,9 +174,21 @@ func NewRouter(c config.ConfigReader) (rest.App, error) {
 	deviceDeploymentsStorage := deploymentsMongo.NewDeviceDeploymentsStorage(dbSession)
 	deviceDeploymentLogsStorage := deploymentsMongo.NewDeviceDeploymentLogsStorage(dbSession)
 	imagesStorage := imagesMongo.NewSoftwareImagesStorage(dbSession)
+
+	// validated on startup
+	readMode, _ := readpref.ParseMode(c.GetString(SettingDbReadPreference))
+	deploymentsStorage.SetReadMode(readMode)
+	deviceDeploymentsStorage.SetReadMode(readMode)
+	imagesStorage.SetReadMode(readMode)
+
+	uploadsStorage := imagesMongo.NewUploadSessionsStorage(dbSession)
 	limitsStorage := limitsMongo.NewLimitsStorage(dbSession)
 	tenantsStorage := tenantsStore.NewStore(dbSession)
 
+	metricsRegistry := metrics.NewRegistry()
+	metricsRegistry.Tenants().SetLimit(c.GetInt(SettingMetricsTenantLabelsMax))
+	maintenanceMode := maintenance.NewMode(c.GetBool(SettingMaintenance))
+
 	// Domain Models
 	deploymentModel := deploymentsModel.NewDeploymentModel(deploymentsModel.DeploymentsModelConfig{
 		DeploymentsStorage:          deploymentsStorage,
@@ -149,53 +197,312 @@ func NewRouter(c config.ConfigReader) (rest.App, error) {
 		ImageLinker:                 fileStorage,
 		ArtifactGetter:              imagesStorage,
 		ImageContentType:            imagesModel.ArtifactContentType,
+		Notifier: webhook.NewNotifier(webhook.Config{
+			Attempts: c.GetInt(SettingDeploymentCallbackAttempts),
+			Backoff:  c.GetDuration(SettingDeploymentCallbackBackoff),
+			Timeout:  c.GetDuration(SettingDeploymentCallbackTimeout),
+
+			AllowPrivate: c.GetBool(SettingDeploymentCallbackAllowPrivate),
+		}),
+		Metrics:           deploymentsMetrics.NewMetrics(metricsRegistry),
+		IdempotencyWindow: c.GetDuration(SettingDeploymentIdempotencyWindow),
+		RequireApproval:   c.GetBool(SettingDeploymentRequireApproval),
+		BlockDeprecated:   c.GetBool(SettingDeploymentBlockDeprecated),
 	})
 
-	imagesModel := imagesModel.NewImagesModel(fileStorage, deploymentModel, imagesStorage)
-	limitsModel := limitsModel.NewLimitsModel(limitsStorage)
+	keyTemplate, err := images.NewObjectKeyTemplate(c.GetString(SettingArtifactKeyTemplate))
+	if err != nil {
+		return nil, err
+	}
+
+	images.MaxNameLength = c.GetInt(SettingArtifactNameMaxLength)
+	deployments.AllowPrivateCallbacks = c.GetBool(SettingDeploymentCallbackAllowPrivate)
+	if pattern := c.GetString(SettingArtifactNamePattern); pattern != "" {
+		images.NamePattern = regexp.MustCompile(pattern)
+	}
+	images.RequiredMetaFields = c.GetStringSlice(SettingArtifactRequiredFields)
+	imagesController.AllowedArtifactContentTypes = c.GetStringSlice(SettingArtifactContentTypes)
+	imagesController.FormFieldAliases = c.GetStringMapString(SettingArtifactFormAliases)
+	imagesController.RejectUnknownFormFields = c.GetBool(SettingArtifactFormRejectUnknown)
+	imagesController.DownloadLinkClockSkew = c.GetDuration(SettingAwsPresignClockSkew)
+	imagesController.ArtifactReviewerRole = c.GetString(SettingArtifactReviewerRole)
+	imagesController.EmptyListStatus = c.GetInt(SettingArtifactEmptyListStatus)
+	imagesController.SlowUploadThroughput = int64(c.GetInt(SettingUploadSlowThroughput))
+	imagesController.SlowUploadPeriod = c.GetDuration(SettingUploadSlowPeriod)
+
+	trustedKeys, err := imagesModel.LoadTrustedKeys(c.GetStringSlice(SettingArtifactVerifyKeys))
+	if err != nil {
+		return nil, err
+	}
+
+	imageModel := imagesModel.NewImagesModel(fileStorage, deploymentModel, imagesStorage,
+		keyTemplate, trustedKeys)
+	imageModel.SetFileRetry(imagesModel.FileRetry{
+		Window:   c.GetDuration(SettingAwsConsistencyWindow),
+		Attempts: c.GetInt(SettingAwsConsistencyAttempts),
+		Backoff:  c.GetDuration(SettingAwsConsistencyBackoff),
+	})
+	imageModel.SetUploadTimeout(c.GetDuration(SettingStorageUploadTimeout))
+	imageModel.SetComposeScratch(imagesModel.ComposeScratch{
+		Dir:     c.GetString(SettingComposeDir),
+		MaxSize: int64(c.GetInt(SettingComposeMaxSize)),
+	})
+	if command := c.GetStringSlice(SettingArtifactScanCommand); len(command) > 0 {
+		artifactScanner, err := scanner.NewCommandScanner(command)
+		if err != nil {
+			return nil, err
+		}
+		imageModel.SetArtifactScanning(imagesModel.ArtifactScanning{
+			Scanner: artifactScanner,
+			Async:   c.GetBool(SettingArtifactScanAsync),
+			Timeout: c.GetDuration(SettingArtifactScanTimeout),
+		})
+	}
+	replicas, err := SetupS3Replicas(c)
+	if err != nil {
+		return nil, err
+	}
+	imageModel.SetReplicas(replicas)
+	imageModel.SetDeploymentsAborter(deploymentModel)
+	deploymentModel.SetFileVersioner(imageModel)
+	if c.GetBool(SettingDownloadRestrictDevices) {
+		imageModel.RestrictDeviceDownloads(deploymentModel)
+	}
+	uploadsModel := imagesModel.NewUploadsModel(fileStorage, uploadsStorage, imageModel)
+	uploadsModel.SetPurgeInterval(tenantsStorage, c.GetDuration(SettingUploadPurgeInterval))
+	incompleteUploadsModel := imagesModel.NewIncompleteUploadsModel(uploadsModel, imageModel,
+		imagesStorage, tenantsStorage)
+	integrityModel := imagesModel.NewIntegrityModel(fileStorage, imagesStorage,
+		tenantsStorage, c.GetDuration(SettingIntegrityCheckInterval))
+	integrityModel.SetChecksumsRate(int64(c.GetInt(SettingChecksumRecomputeRate)))
+	expiryModel := imagesModel.NewExpiryModel(imageModel, imagesStorage,
+		tenantsStorage, c.GetDuration(SettingArtifactExpiryCheckInterval))
+	usageModel := imagesModel.NewUsageModel(imagesStorage, tenantsStorage,
+		c.GetDuration(SettingUsageReconcileInterval))
+	deletionsModel := imagesModel.NewDeletionsModel(fileStorage, imagesStorage,
+		tenantsStorage, c.GetDuration(SettingDeletionRetryInterval),
+		c.GetDuration(SettingDeletionRetryBackoff))
+	deletionsModel.SetReplicas(replicas)
+	artifactMetrics := imagesMetrics.NewMetrics(metricsRegistry)
+	deletionsModel.SetMetrics(artifactMetrics)
+	limitsModel := limitsModel.NewLimitsModel(limitsStorage, usageModel)
+	imageModel.SetLimits(limitsModel)
 	tenantsModel := tenantsModel.NewModel(tenantsStorage)
+	healthModel := healthModel.NewHealthModel(fileStorage,
+		c.GetDuration(SettingStorageLatencyThreshold), maintenanceMode)
 
 	// Controllers
-	imagesController := imagesController.NewSoftwareImagesController(imagesModel,
+	uploadsController := imagesController.NewUploadsController(uploadsModel,
+		new(view.RESTView))
+	incompleteUploadsController := imagesController.NewIncompleteUploadsController(
+		incompleteUploadsModel, new(view.RESTView))
+	integrityController := imagesController.NewIntegrityController(integrityModel,
 		new(view.RESTView))
+	uploadLimiter := imagesController.NewUploadLimiter(
+		c.GetInt(SettingUploadConcurrency),
+		c.GetInt(SettingUploadConcurrencyPerTenant))
+	imagesController := imagesController.NewSoftwareImagesController(imageModel,
+		new(view.RESTView), uploadLimiter, &imagesController.Timeouts{
+			Upload: c.GetDuration(SettingUploadTimeout),
+		})
+	imagesController.SetMetrics(artifactMetrics)
+	deploymentsController.DefaultDeviceType = c.GetString(SettingDeploymentDefaultDeviceType)
 	deploymentsController := deploymentsController.NewDeploymentsController(deploymentModel,
 		new(deploymentsView.DeploymentsView))
 	limitsController := limitsController.NewLimitsController(limitsModel,
 		new(view.RESTView))
 	tenantsController := tenantsController.NewController(tenantsModel)
+	healthController := healthController.NewHealthController(healthModel)
 
 	// Routing
-	imageRoutes := NewImagesResourceRoutes(imagesController)
-	deploymentsRoutes := NewDeploymentsResourceRoutes(deploymentsController)
+	uploadsRoutes := NewUploadsResourceRoutes(uploadsController, maintenanceMode)
+	imageRoutes := NewImagesResourceRoutes(imagesController, maintenanceMode)
+	incompleteUploadsRoutes := NewIncompleteUploadsResourceRoutes(incompleteUploadsController)
+	integrityRoutes := NewIntegrityResourceRoutes(integrityController)
+	deploymentsRoutes := NewDeploymentsResourceRoutes(deploymentsController, maintenanceMode)
 	limitsRoutes := NewLimitsResourceRoutes(limitsController)
 	tenantsRoutes := NewTenantsResourceRoutes(tenantsController)
-
-	routes := append(imageRoutes, deploymentsRoutes...)
+	healthRoutes := NewHealthResourceRoutes(healthController)
+	metricsRoutes := NewMetricsResourceRoutes(metricsRegistry)
+	maintenanceRoutes := NewMaintenanceResourceRoutes(maintenanceMode)
+	configRoutes := NewConfigResourceRoutes(c)
+
+	// the artifacts and the deployments requests are bounded as a whole,
+	// the uploads by the upload timeout instead
+	operationTimeout := c.GetDuration(SettingOperationTimeout)
+	imageRoutes = restutil.WithOperationTimeout(operationTimeout,
+		uploadTimeoutRoutes, imageRoutes)
+	deploymentsRoutes = restutil.WithOperationTimeout(operationTimeout,
+		nil, deploymentsRoutes)
+
+	routes := append(uploadsRoutes, imageRoutes...)
+	routes = append(routes, incompleteUploadsRoutes...)
+	routes = append(routes, integrityRoutes...)
+	routes = append(routes, deploymentsRoutes...)
 	routes = append(routes, limitsRoutes...)
 	routes = append(routes, tenantsRoutes...)
+	routes = append(routes, healthRoutes...)
+	routes = append(routes, metricsRoutes...)
+	routes = append(routes, maintenanceRoutes...)
+	routes = append(routes, configRoutes...)
+
+	if c.GetBool(SettingDownloadProxy) {
+		routes = append(routes, restutil.WithOperationTimeout(operationTimeout,
+			nil, NewDownloadProxyResourceRoutes(imagesController))...)
+	}
+
+	go integrityModel.Run(context.Background())
+	go expiryModel.Run(context.Background())
+	go uploadsModel.Run(context.Background())
+	go usageModel.Run(context.Background())
+	go deletionsModel.Run(context.Background())
+
+	routes = restutil.AutogenOptionsRoutes(restutil.NewOptionsHandler, routes...)
+	routes = restutil.AutogenMethodNotAllowedRoutes(restutil.NewMethodNotAllowedHandler, routes...)
+
+	timeouts, err := handlerTimeouts(c)
+	if err != nil {
+		return nil, err
+	}
+	routes, err = restutil.WithTimeouts(c.GetDuration(SettingHandlerTimeout), timeouts,
+		logging.WithHandlerNames(routes))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set up handler timeouts")
+	}
+
+	return rest.MakeRouter(routes...)
+}
 
-	return rest.MakeRouter(restutil.AutogenOptionsRoutes(restutil.NewOptionsHandler, routes...)...)
+// uploadTimeoutRoutes are the artifact routes transferring the artifact files,
+// bounded by the upload timeout instead of the operation one
+var uploadTimeoutRoutes = map[string]bool{
+	http.MethodPost + " " + ApiUrlManagementArtifacts:               true,
+	http.MethodPost + " " + ApiUrlManagementArtifacts + "/validate": true,
+	http.MethodGet + " " + ApiUrlManagementArtifacts + "/export":    true,
+	http.MethodPost + " " + ApiUrlManagementArtifacts + "/import":   true,
+	http.MethodPut + " " + ApiUrlManagement + "/artifacts/:id/file": true,
 }
 
-func NewImagesResourceRoutes(controller *imagesController.SoftwareImagesController) []*rest.Route {
+// NewImagesResourceRoutes defines artifact routes; the requests modifying
+// the artifacts are rejected in maintenance mode.
+func NewImagesResourceRoutes(controller *imagesController.SoftwareImagesController,
+	mode *maintenance.Mode) []*rest.Route {
 
 	if controller == nil {
 		return []*rest.Route{}
 	}
 
 	return []*rest.Route{
-		rest.Post(ApiUrlManagementArtifacts, controller.NewImage),
+		rest.Post(ApiUrlManagementArtifacts, mode.ReadOnly(controller.NewImage)),
 		rest.Get(ApiUrlManagementArtifacts, controller.ListImages),
+		rest.Delete(ApiUrlManagementArtifacts,
+			mode.ReadOnly(controller.DeleteDeviceTypeImages)),
+		rest.Get(ApiUrlManagementArtifacts+"/device_types", controller.ListDeviceTypes),
+		rest.Get(ApiUrlManagementArtifacts+"/changes", controller.ListImageChanges),
+		rest.Post(ApiUrlManagementArtifacts+"/lookup", controller.GetImages),
+		rest.Post(ApiUrlManagementArtifacts+"/installable", controller.ListInstallableImages),
+		rest.Post(ApiUrlManagementArtifacts+"/tags/add", mode.ReadOnly(controller.AddImagesTag)),
+		rest.Post(ApiUrlManagementArtifacts+"/tags/remove",
+			mode.ReadOnly(controller.RemoveImagesTag)),
+		rest.Post(ApiUrlManagementArtifacts+"/compose", mode.ReadOnly(controller.ComposeImage)),
+		rest.Post(ApiUrlManagementArtifacts+"/pending", mode.ReadOnly(controller.NewPendingImage)),
+		rest.Get(ApiUrlManagementArtifacts+"/export", controller.ExportImages),
+		rest.Post(ApiUrlManagementArtifacts+"/import", mode.ReadOnly(controller.ImportImages)),
+		rest.Post(ApiUrlManagementArtifacts+"/validate", controller.ValidateImage),
 
 		rest.Get(ApiUrlManagement+"/artifacts/:id", controller.GetImage),
-		rest.Delete(ApiUrlManagement+"/artifacts/:id", controller.DeleteImage),
-		rest.Put(ApiUrlManagement+"/artifacts/:id", controller.EditImage),
+		rest.Delete(ApiUrlManagement+"/artifacts/:id", mode.ReadOnly(controller.DeleteImage)),
+		rest.Put(ApiUrlManagement+"/artifacts/:id", mode.ReadOnly(controller.EditImage)),
+		rest.Patch(ApiUrlManagement+"/artifacts/:id", mode.ReadOnly(controller.PatchImage)),
+		rest.Put(ApiUrlManagement+"/artifacts/:id/file",
+			mode.ReadOnly(controller.UploadPendingImage)),
 
 		rest.Get(ApiUrlManagement+"/artifacts/:id/download", controller.DownloadLink),
+		rest.Post(ApiUrlManagement+"/artifacts/:id/download/rotate",
+			mode.ReadOnly(controller.RotateDownloadLinks)),
+		rest.Post(ApiUrlManagement+"/artifacts/:id/approve", mode.ReadOnly(controller.ApproveImage)),
+		rest.Post(ApiUrlManagement+"/artifacts/:id/deprecate",
+			mode.ReadOnly(controller.DeprecateImage)),
+		rest.Put(ApiUrlManagement+"/artifacts/:id/sharing", mode.ReadOnly(controller.ShareImage)),
+
+		rest.Post(ApiUrlInternal+"/tenants/:tenant/artifacts/:id/clone", controller.CloneImage),
+		rest.Get(ApiUrlInternal+"/tenants/:tenant/artifacts/:id/location",
+			controller.GetImageLocation),
+	}
+}
+
+func NewDownloadProxyResourceRoutes(controller *imagesController.SoftwareImagesController) []*rest.Route {
+
+	if controller == nil {
+		return []*rest.Route{}
+	}
+
+	return []*rest.Route{
+		rest.Get(ApiUrlDevices+"/artifacts/:id/download", controller.DownloadImage),
+		rest.Get(ApiUrlManagement+"/artifacts/:id/file", controller.DownloadImage),
+	}
+}
+
+// NewUploadsResourceRoutes defines resumable upload routes; upload sessions
+// are not started, continued, cancelled or finalized in maintenance mode.
+func NewUploadsResourceRoutes(controller *imagesController.UploadsController,
+	mode *maintenance.Mode) []*rest.Route {
+
+	if controller == nil {
+		return []*rest.Route{}
+	}
+
+	return []*rest.Route{
+		rest.Post(ApiUrlManagementArtifactsUploads, mode.ReadOnly(controller.NewUpload)),
+		rest.Post(ApiUrlManagementArtifactsDirect, mode.ReadOnly(controller.NewDirectUpload)),
+		rest.Get(ApiUrlManagementArtifactsUploads+"/:id", controller.GetUpload),
+		rest.Get(ApiUrlManagementArtifactsUploads+"/:id/progress",
+			controller.GetUploadProgress),
+		rest.Patch(ApiUrlManagementArtifactsUploads+"/:id",
+			mode.ReadOnly(controller.UploadChunk)),
+		rest.Delete(ApiUrlManagementArtifactsUploads+"/:id",
+			mode.ReadOnly(controller.CancelUpload)),
+		rest.Post(ApiUrlManagementArtifactsUploads+"/:id/finalize",
+			mode.ReadOnly(controller.FinalizeUpload)),
+	}
+}
+
+// NewIncompleteUploadsResourceRoutes defines the routes the operators list
+// and purge the incomplete uploads of all the tenants with.
+func NewIncompleteUploadsResourceRoutes(
+	controller *imagesController.IncompleteUploadsController) []*rest.Route {
+
+	if controller == nil {
+		return []*rest.Route{}
+	}
+
+	return []*rest.Route{
+		rest.Get(ApiUrlInternal+"/artifacts/uploads/incomplete",
+			controller.ListIncompleteUploads),
+		rest.Delete(ApiUrlInternal+"/artifacts/uploads/incomplete",
+			controller.PurgeIncompleteUploads),
 	}
 }
 
-func NewDeploymentsResourceRoutes(controller *deploymentsController.DeploymentsController) []*rest.Route {
+func NewIntegrityResourceRoutes(controller *imagesController.IntegrityController) []*rest.Route {
+
+	if controller == nil {
+		return []*rest.Route{}
+	}
+
+	return []*rest.Route{
+		rest.Post(ApiUrlInternal+"/artifacts/verify", controller.VerifyAllImages),
+		rest.Post(ApiUrlInternal+"/tenants/:tenant/artifacts/checksums/recompute",
+			controller.RecomputeChecksums),
+		rest.Post(ApiUrlInternal+"/tenants/:tenant/artifacts/verify",
+			controller.VerifyTenantImages),
+	}
+}
+
+// NewDeploymentsResourceRoutes defines deployment routes; the management
+// requests modifying the deployments are rejected in maintenance mode.
+func NewDeploymentsResourceRoutes(controller *deploymentsController.DeploymentsController,
+	mode *maintenance.Mode) []*rest.Route {
 
 	if controller == nil {
 		return []*rest.Route{}
@@ -204,24 +511,46 @@ func NewDeploymentsResourceRoutes(controller *deploymentsController.DeploymentsC
 	return []*rest.Route{
 
 		// Deployments
-		rest.Post(ApiUrlManagement+"/deployments", controller.PostDeployment),
+		rest.Post(ApiUrlManagement+"/deployments", mode.ReadOnly(controller.PostDeployment)),
 		rest.Get(ApiUrlManagement+"/deployments", controller.LookupDeployment),
+		// defined before the deployment routes, not to be taken for one
+		rest.Get(ApiUrlManagement+"/deployments/artifacts/statistics",
+			controller.GetArtifactStats),
+		rest.Post(ApiUrlManagement+"/deployments/statistics/lookup",
+			controller.GetDeploymentsStats),
 		rest.Get(ApiUrlManagement+"/deployments/:id", controller.GetDeployment),
 		rest.Get(ApiUrlManagement+"/deployments/:id/statistics", controller.GetDeploymentStats),
-		rest.Put(ApiUrlManagement+"/deployments/:id/status", controller.AbortDeployment),
+		rest.Put(ApiUrlManagement+"/deployments/:id/status",
+			mode.ReadOnly(controller.AbortDeployment)),
+		rest.Post(ApiUrlManagement+"/deployments/:id/promote",
+			mode.ReadOnly(controller.PromoteDeployment)),
+		rest.Post(ApiUrlManagement+"/deployments/:id/halt",
+			mode.ReadOnly(controller.HaltDeployment)),
 		rest.Get(ApiUrlManagement+"/deployments/:id/devices",
 			controller.GetDeviceStatusesForDeployment),
+		rest.Get(ApiUrlManagement+"/deployments/:id/devices/list",
+			controller.GetDevicesListForDeployment),
 		rest.Get(ApiUrlManagement+"/deployments/:id/devices/:devid/log",
 			controller.GetDeploymentLogForDevice),
 		rest.Delete(ApiUrlManagement+"/deployments/devices/:id",
-			controller.DecommissionDevice),
+			mode.ReadOnly(controller.DecommissionDevice)),
+		rest.Get(ApiUrlManagement+"/deployments/devices/:id/next",
+			controller.PreviewDeploymentForDeviceID),
+		rest.Get(ApiUrlManagement+"/artifacts/:id/deployments",
+			controller.ListDeploymentsForArtifact),
 
 		// Devices
 		rest.Get(ApiUrlDevices+"/device/deployments/next", controller.GetDeploymentForDevice),
+		rest.Get(ApiUrlDevices+"/device/deployments/next/preview",
+			controller.PreviewDeploymentForDevice),
 		rest.Put(ApiUrlDevices+"/device/deployments/:id/status",
 			controller.PutDeploymentStatusForDevice),
 		rest.Put(ApiUrlDevices+"/device/deployments/:id/log",
 			controller.PutDeploymentLogForDevice),
+
+		// Internal
+		rest.Post(ApiUrlInternal+"/tenants/:tenant/deployments/devices/statuses",
+			controller.PutDeploymentStatusesForDevices),
 	}
 }
 
@@ -245,5 +574,51 @@ func NewTenantsResourceRoutes(controller *tenantsController.Controller) []*rest.
 
 	return []*rest.Route{
 		rest.Post(ApiUrlInternal+"/tenants", controller.ProvisionTenantsHandler),
+		rest.Post(ApiUrlInternal+"/indexes", controller.EnsureIndexesHandler),
+	}
+}
+
+func NewHealthResourceRoutes(controller *healthController.HealthController) []*rest.Route {
+
+	if controller == nil {
+		return []*rest.Route{}
+	}
+
+	return []*rest.Route{
+		rest.Get(ApiUrlInternal+"/health/ready", controller.Readiness),
+	}
+}
+
+func NewMetricsResourceRoutes(registry *metrics.Registry) []*rest.Route {
+
+	if registry == nil {
+		return []*rest.Route{}
+	}
+
+	return []*rest.Route{
+		rest.Get(ApiUrlInternal+"/metrics", metrics.Handler(registry)),
+	}
+}
+
+func NewMaintenanceResourceRoutes(mode *maintenance.Mode) []*rest.Route {
+
+	if mode == nil {
+		return []*rest.Route{}
+	}
+
+	return []*rest.Route{
+		rest.Get(ApiUrlInternal+"/maintenance", mode.GetState),
+		rest.Put(ApiUrlInternal+"/maintenance", mode.SetState),
+	}
+}
+
+func NewConfigResourceRoutes(c config.ConfigReader) []*rest.Route {
+
+	if c == nil {
+		return []*rest.Route{}
+	}
+
+	return []*rest.Route{
+		rest.Get(ApiUrlInternal+"/config", NewEffectiveConfigHandler(c)),
 	}
 }
@@ -0,0 +1,83 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+
+	deploymentsController "github.com/mendersoftware/deployments/resources/deployments/controller"
+	imagesController "github.com/mendersoftware/deployments/resources/images/controller"
+	"github.com/mendersoftware/deployments/utils/maintenance"
+)
+
+// readOnlyPostRoutes are the management routes taking the query in the POST
+// body, which keep working in maintenance mode
+var readOnlyPostRoutes = map[string]bool{
+	ApiUrlManagementArtifacts + "/lookup":               true,
+	ApiUrlManagementArtifacts + "/installable":          true,
+	ApiUrlManagementArtifacts + "/validate":             true,
+	ApiUrlManagement + "/deployments/statistics/lookup": true,
+}
+
+func TestMaintenanceModeRoutes(t *testing.T) {
+	mode := maintenance.NewMode(true)
+
+	routes := NewImagesResourceRoutes(
+		new(imagesController.SoftwareImagesController), mode)
+	routes = append(routes, NewUploadsResourceRoutes(
+		new(imagesController.UploadsController), mode)...)
+	routes = append(routes, NewDeploymentsResourceRoutes(
+		new(deploymentsController.DeploymentsController), mode)...)
+
+	router, err := rest.MakeRouter(routes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := rest.NewApi()
+	// handlers not wrapped run with no models, and fail
+	api.Use(&rest.RecoverMiddleware{})
+	api.SetApp(router)
+	handler := api.MakeHandler()
+
+	checked := 0
+	for _, route := range routes {
+		if route.HttpMethod == http.MethodGet ||
+			!strings.HasPrefix(route.PathExp, ApiUrlManagement) ||
+			readOnlyPostRoutes[route.PathExp] {
+			continue
+		}
+
+		t.Run(route.HttpMethod+" "+route.PathExp, func(t *testing.T) {
+			path := route.PathExp
+			for _, param := range []string{":id", ":devid"} {
+				path = strings.Replace(path, param, "1", -1)
+			}
+
+			req := test.MakeSimpleRequest(route.HttpMethod, "http://localhost"+path, nil)
+			recorded := test.RunRequest(t, handler, req)
+			recorded.CodeIs(http.StatusServiceUnavailable)
+		})
+		checked++
+	}
+
+	if checked == 0 {
+		t.Error("no routes checked")
+	}
+}
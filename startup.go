@@ -0,0 +1,83 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/config"
+)
+
+// StartupCheckTimeout limits the time spent on checking each of the external services
+const StartupCheckTimeout = 10 * time.Second
+
+// ValidateStartup validates the configuration and checks that the database
+// and the artifact storage are reachable with it. Every problem found is
+// reported, so that all of them can be fixed at once.
+func ValidateStartup(c config.ConfigReader) error {
+	var errs config.ValidationErrors
+
+	if err := config.ValidateConfigAll(c, configValidators...); err != nil {
+		errs = append(errs, err.(config.ValidationErrors)...)
+	}
+
+	// connectivity is checked only if the related options are sane,
+	// otherwise the same problem would be reported twice
+	if ValidateMongoURL(c) == nil {
+		if err := checkMongo(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if ValidateAwsS3Bucket(c) == nil && ValidateAwsAuth(c) == nil {
+		if err := checkStorage(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func checkMongo(c config.ConfigReader) error {
+	session, err := NewMongoSession(c)
+	if err != nil {
+		return errors.Wrapf(err, "Mongo at '%s' not reachable", c.GetString(SettingMongo))
+	}
+	session.Close()
+
+	return nil
+}
+
+func checkStorage(c config.ConfigReader) error {
+	storage, err := SetupS3(c)
+	if err != nil {
+		return errors.Wrapf(err, "S3 bucket '%s' not accessible", c.GetString(SettingAwsS3Bucket))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), StartupCheckTimeout)
+	defer cancel()
+
+	if err := storage.HealthCheck(ctx); err != nil {
+		return errors.Wrapf(err, "S3 bucket '%s' not accessible", c.GetString(SettingAwsS3Bucket))
+	}
+
+	return nil
+}
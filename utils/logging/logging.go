@@ -0,0 +1,120 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package logging configures the service logger and provides middlewares
+// enriching request logs with structured fields.
+package logging
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestlog"
+	"github.com/pkg/errors"
+)
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Log fields
+const (
+	FieldHandler = "handler"
+	FieldStatus  = "status"
+)
+
+// Errors
+var (
+	ErrUnknownFormat = errors.New("unknown log format")
+)
+
+// Setup sets the format of the global logger.
+func Setup(format string) error {
+	switch format {
+	case FormatText:
+		log.Log.Formatter = &logrus.TextFormatter{
+			FullTimestamp: true,
+		}
+	case FormatJSON:
+		log.Log.Formatter = &logrus.JSONFormatter{}
+	default:
+		return errors.Wrap(ErrUnknownFormat, format)
+	}
+	return nil
+}
+
+// StatusLogMiddleware adds response status code to the request logger once
+// the request is handled, so that the access log line carries it.
+// Has to be placed between the access log and the recorder middlewares.
+type StatusLogMiddleware struct {
+}
+
+// MiddlewareFunc makes StatusLogMiddleware implement the Middleware interface.
+func (mw *StatusLogMiddleware) MiddlewareFunc(h rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		h(w, r)
+
+		if status, ok := r.Env["STATUS_CODE"].(int); ok {
+			l := requestlog.GetRequestLogger(r).F(log.Ctx{FieldStatus: status})
+			requestlog.SetRequestLogger(r, l)
+		}
+	}
+}
+
+// WithHandlerNames wraps the route handlers, so that the request logger
+// carries the name of the handler serving the request.
+func WithHandlerNames(routes []*rest.Route) []*rest.Route {
+	for _, route := range routes {
+		route.Func = withHandlerName(HandlerName(route.Func), route.Func)
+	}
+	return routes
+}
+
+func withHandlerName(name string, h rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		l := requestlog.GetRequestLogger(r).F(log.Ctx{FieldHandler: name})
+		h(w, requestlog.SetRequestLogger(r, l))
+	}
+}
+
+// HandlerName returns short name of the handler function,
+// e.g. 'DeploymentsController.GetDeployment' for method values.
+func HandlerName(h rest.HandlerFunc) string {
+	f := runtime.FuncForPC(reflect.ValueOf(h).Pointer())
+	if f == nil {
+		return ""
+	}
+
+	name := f.Name()
+	// drop the package path
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	// drop the package name
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	// method values are suffixed with '-fm'
+	name = strings.TrimSuffix(name, "-fm")
+	// pointer receivers look like '(*Type).Method'
+	name = strings.NewReplacer("(*", "", ")", "").Replace(name)
+
+	return name
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/mendersoftware/go-lib-micro/accesslog"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestlog"
+	"github.com/stretchr/testify/assert"
+)
+
+type testController struct {
+}
+
+func (c *testController) Get(w rest.ResponseWriter, r *rest.Request) {
+	w.WriteHeader(http.StatusTeapot)
+}
+
+func TestSetup(t *testing.T) {
+	defer Setup(FormatText)
+
+	assert.NoError(t, Setup(FormatJSON))
+	assert.IsType(t, &logrus.JSONFormatter{}, log.Log.Formatter)
+
+	assert.NoError(t, Setup(FormatText))
+	assert.IsType(t, &logrus.TextFormatter{}, log.Log.Formatter)
+
+	err := Setup("xml")
+	assert.EqualError(t, err, "xml: unknown log format")
+}
+
+func TestHandlerName(t *testing.T) {
+	c := &testController{}
+	assert.Equal(t, "testController.Get", HandlerName(c.Get))
+}
+
+func TestRequestLogFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buf
+	logger.Formatter = &logrus.JSONFormatter{}
+
+	c := &testController{}
+	router, err := rest.MakeRouter(WithHandlerNames([]*rest.Route{
+		rest.Get("/test", c.Get),
+	})...)
+	assert.NoError(t, err)
+
+	api := rest.NewApi()
+	api.Use(&requestlog.RequestLogMiddleware{BaseLogger: logger},
+		&accesslog.AccessLogMiddleware{Format: accesslog.SimpleLogFormat},
+		&StatusLogMiddleware{},
+		&rest.RecorderMiddleware{})
+	api.SetApp(router)
+
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/test", nil))
+	recorded.CodeIs(http.StatusTeapot)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "testController.Get", entry[FieldHandler])
+	assert.Equal(t, float64(http.StatusTeapot), entry[FieldStatus])
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package maintenance
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deployments/utils/restutil"
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+)
+
+var ErrInMaintenance = errors.New("service in maintenance")
+
+var restView = new(view.RESTView)
+
+// Mode is the maintenance (read-only) mode switch. While enabled, handlers
+// wrapped with ReadOnly reject the requests with 503, reads keep working.
+type Mode struct {
+	enabled int32
+}
+
+// State is the maintenance mode state as reported and set through the API
+type State struct {
+	Enabled bool `json:"enabled"`
+}
+
+func NewMode(enabled bool) *Mode {
+	m := &Mode{}
+	m.Set(enabled)
+	return m
+}
+
+// Enabled tells if the service is in maintenance mode
+func (m *Mode) Enabled() bool {
+	return atomic.LoadInt32(&m.enabled) == 1
+}
+
+// Set enables or disables the maintenance mode
+func (m *Mode) Set(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&m.enabled, v)
+}
+
+// ReadOnly wraps the mutating handler, which is rejected with 503
+// while the maintenance mode is enabled.
+func (m *Mode) ReadOnly(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		if m.Enabled() {
+			l := log.FromContext(r.Context())
+			restView.RenderError(w, r, ErrInMaintenance,
+				http.StatusServiceUnavailable, l)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// GetState responds with the maintenance mode state.
+func (m *Mode) GetState(w rest.ResponseWriter, r *rest.Request) {
+	w.WriteJson(State{Enabled: m.Enabled()})
+}
+
+// SetState enables or disables the maintenance mode.
+func (m *Mode) SetState(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	var state State
+	if err := restutil.DecodeJsonObject(r.Body, &state); err != nil {
+		restView.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	m.Set(state.Enabled)
+	l.F(log.Ctx{"enabled": state.Enabled}).Info("maintenance mode changed")
+
+	restView.RenderSuccessPut(w)
+}
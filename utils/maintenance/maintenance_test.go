@@ -0,0 +1,90 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package maintenance
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeApi(t *testing.T, m *Mode) http.Handler {
+	router, err := rest.MakeRouter(
+		rest.Get("/r", func(w rest.ResponseWriter, r *rest.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		rest.Post("/r", m.ReadOnly(func(w rest.ResponseWriter, r *rest.Request) {
+			w.WriteHeader(http.StatusCreated)
+		})),
+		rest.Get("/maintenance", m.GetState),
+		rest.Put("/maintenance", m.SetState),
+	)
+	assert.NoError(t, err)
+
+	api := rest.NewApi()
+	api.SetApp(router)
+	return api.MakeHandler()
+}
+
+func TestMode(t *testing.T) {
+	m := NewMode(false)
+	handler := makeApi(t, m)
+
+	recorded := test.RunRequest(t, handler,
+		test.MakeSimpleRequest("POST", "http://localhost/r", nil))
+	recorded.CodeIs(http.StatusCreated)
+
+	recorded = test.RunRequest(t, handler,
+		test.MakeSimpleRequest("PUT", "http://localhost/maintenance",
+			State{Enabled: true}))
+	recorded.CodeIs(http.StatusNoContent)
+	assert.True(t, m.Enabled())
+
+	recorded = test.RunRequest(t, handler,
+		test.MakeSimpleRequest("GET", "http://localhost/maintenance", nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs(`{"enabled":true}`)
+
+	// writes rejected, reads served
+	recorded = test.RunRequest(t, handler,
+		test.MakeSimpleRequest("POST", "http://localhost/r", nil))
+	recorded.CodeIs(http.StatusServiceUnavailable)
+	assert.Contains(t, recorded.Recorder.Body.String(), ErrInMaintenance.Error())
+
+	recorded = test.RunRequest(t, handler,
+		test.MakeSimpleRequest("GET", "http://localhost/r", nil))
+	recorded.CodeIs(http.StatusOK)
+
+	m.Set(false)
+	recorded = test.RunRequest(t, handler,
+		test.MakeSimpleRequest("POST", "http://localhost/r", nil))
+	recorded.CodeIs(http.StatusCreated)
+}
+
+func TestSetStateMalformedBody(t *testing.T) {
+	m := NewMode(true)
+	handler := makeApi(t, m)
+
+	req := test.MakeSimpleRequest("PUT", "http://localhost/maintenance", nil)
+	req.Body = ioutil.NopCloser(bytes.NewBufferString(`{"enabled": "yes"}`))
+	recorded := test.RunRequest(t, handler, req)
+	recorded.CodeIs(http.StatusBadRequest)
+	assert.True(t, m.Enabled())
+}
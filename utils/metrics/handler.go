@@ -0,0 +1,34 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+// Handler responds with the metrics of the registry.
+func Handler(r *Registry) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, req *rest.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		w.WriteHeader(http.StatusOK)
+		if _, err := r.WriteTo(w.(http.ResponseWriter)); err != nil {
+			log.FromContext(req.Context()).F(log.Ctx{"error": err.Error()}).
+				Error("failed to write metrics")
+		}
+	}
+}
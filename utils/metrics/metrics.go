@@ -0,0 +1,322 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package metrics provides labeled counters, gauges and histograms exposed in the
+// Prometheus text format, so that they can be scraped without pulling
+// the Prometheus client library in.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// ContentType of the exposition format
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+
+	// label of the histogram bucket upper bounds
+	labelBucket = "le"
+
+	// separates label values in the series key, can't appear in UTF-8 text
+	labelSeparator = "\xff"
+)
+
+// Registry is a set of metrics rendered together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+	tenants *TenantLabels
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: map[string]*metric{},
+		tenants: NewTenantLabels(),
+	}
+}
+
+// Tenants returns the tenant labels shared by the metrics of the registry,
+// so that the tenants are labeled the same way across the metrics.
+func (r *Registry) Tenants() *TenantLabels {
+	return r.tenants
+}
+
+// NewCounter registers a counter with the given label names.
+// Panics if the name is already registered.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, kindCounter, labels)}
+}
+
+// NewGauge registers a gauge with the given label names.
+// Panics if the name is already registered.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, kindGauge, labels)}
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds,
+// in increasing order, and label names.
+// Panics if the name is already registered or the buckets are not ordered.
+func (r *Registry) NewHistogram(name, help string, buckets []float64,
+	labels ...string) *Histogram {
+	for i := range buckets {
+		if i > 0 && buckets[i] <= buckets[i-1] {
+			panic(fmt.Sprintf("histogram %s buckets not in increasing order", name))
+		}
+	}
+	m := r.register(name, help, kindHistogram, labels)
+	m.buckets = append([]float64(nil), buckets...)
+	return &Histogram{m}
+}
+
+func (r *Registry) register(name, help, kind string, labels []string) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metric %s already registered", name))
+	}
+
+	m := &metric{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: map[string]*series{},
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// WriteTo renders all the metrics in the text exposition format,
+// ordered by name and label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]*metric, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		m.render(&buf)
+	}
+
+	return buf.WriteTo(w)
+}
+
+// Counter is a metric which only goes up, e.g. number of events.
+type Counter struct {
+	m *metric
+}
+
+// Inc increments the counter of the series with the given label values.
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add increases the counter of the series with the given label values.
+// Panics if the value is negative.
+func (c *Counter) Add(v float64, labels ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("counter %s can not decrease", c.m.name))
+	}
+	c.m.update(labels, func(s *series) { s.value += v })
+}
+
+// Gauge is a metric which goes up and down, e.g. number of items in progress.
+type Gauge struct {
+	m *metric
+}
+
+// Inc increments the gauge of the series with the given label values.
+func (g *Gauge) Inc(labels ...string) {
+	g.Add(1, labels...)
+}
+
+// Dec decrements the gauge of the series with the given label values.
+func (g *Gauge) Dec(labels ...string) {
+	g.Add(-1, labels...)
+}
+
+// Add changes the gauge of the series with the given label values by v.
+func (g *Gauge) Add(v float64, labels ...string) {
+	g.m.update(labels, func(s *series) { s.value += v })
+}
+
+// Sub changes the gauge of the series with the given label values by -v.
+func (g *Gauge) Sub(v float64, labels ...string) {
+	g.Add(-v, labels...)
+}
+
+// Set sets the gauge of the series with the given label values.
+func (g *Gauge) Set(v float64, labels ...string) {
+	g.m.update(labels, func(s *series) { s.value = v })
+}
+
+// Histogram is a metric which counts the observed values in buckets,
+// e.g. request durations.
+type Histogram struct {
+	m *metric
+}
+
+// Observe adds the value to the series with the given label values.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	h.m.update(labels, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.m.buckets))
+		}
+		for i, bound := range h.m.buckets {
+			if v <= bound {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.value += v
+	})
+}
+
+type series struct {
+	labels []string
+	value  float64
+
+	// histograms only: cumulative counts by bucket and the number
+	// of the observations, value being their sum
+	counts []uint64
+	count  uint64
+}
+
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// update applies the change to the series, creating it if needed.
+// Panics if the number of label values doesn't match the label names.
+func (m *metric) update(labels []string, change func(s *series)) {
+	if len(labels) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d",
+			m.name, len(m.labels), len(labels)))
+	}
+
+	key := strings.Join(labels, labelSeparator)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labels...)}
+		m.series[key] = s
+	}
+	change(s)
+}
+
+func (m *metric) render(buf *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", m.name, m.kind)
+
+	ordered := make([]*series, 0, len(m.series))
+	for _, s := range m.series {
+		ordered = append(ordered, s)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return lessLabels(ordered[i].labels, ordered[j].labels)
+	})
+
+	for _, s := range ordered {
+		if m.kind != kindHistogram {
+			writeSample(buf, m.name, m.labels, s.labels, formatFloat(s.value))
+			continue
+		}
+
+		labels := append(m.labels[:len(m.labels):len(m.labels)], labelBucket)
+		for i, bound := range m.buckets {
+			writeSample(buf, m.name+"_bucket", labels,
+				append(s.labels[:len(s.labels):len(s.labels)], formatFloat(bound)),
+				strconv.FormatUint(s.counts[i], 10))
+		}
+		writeSample(buf, m.name+"_bucket", labels,
+			append(s.labels[:len(s.labels):len(s.labels)], "+Inf"),
+			strconv.FormatUint(s.count, 10))
+		writeSample(buf, m.name+"_sum", m.labels, s.labels, formatFloat(s.value))
+		writeSample(buf, m.name+"_count", m.labels, s.labels,
+			strconv.FormatUint(s.count, 10))
+	}
+}
+
+// writeSample renders the sample line of the series.
+func writeSample(buf *bytes.Buffer, name string, labels, values []string, value string) {
+	buf.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, len(labels))
+		for i, label := range labels {
+			pairs[i] = label + `="` + escapeLabelValue(values[i]) + `"`
+		}
+		buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	buf.WriteString(" " + value + "\n")
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// lessLabels orders the series by label values, in label order.
+func lessLabels(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	counter := r.NewCounter("events_total", "Number of events.", "tenant", "status")
+	gauge := r.NewGauge("items", "Items in progress,\nper tenant.", "tenant")
+	plain := r.NewGauge("up", "Service is up.")
+
+	counter.Inc("t2", "failure")
+	counter.Add(2, "t1", "success")
+	counter.Inc("t1", "success")
+	gauge.Inc("t1")
+	gauge.Add(5, "t1")
+	gauge.Dec("t1")
+	gauge.Sub(2, "t1")
+	gauge.Add(2, "t1")
+	gauge.Set(0.5, `say "hi"\`)
+	plain.Set(1)
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP events_total Number of events.
+# TYPE events_total counter
+events_total{tenant="t1",status="success"} 3
+events_total{tenant="t2",status="failure"} 1
+# HELP items Items in progress,\nper tenant.
+# TYPE items gauge
+items{tenant="say \"hi\"\\"} 0.5
+items{tenant="t1"} 5
+# HELP up Service is up.
+# TYPE up gauge
+up 1
+`, buf.String())
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+
+	histogram := r.NewHistogram("duration_seconds", "Duration of requests.",
+		[]float64{0.5, 1, 2.5}, "tenant")
+	plain := r.NewHistogram("size_bytes", "Size of requests.", []float64{1024})
+
+	histogram.Observe(0.1, "t1")
+	histogram.Observe(1, "t1")
+	histogram.Observe(3, "t1")
+	histogram.Observe(2, "t2")
+	plain.Observe(2048)
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP duration_seconds Duration of requests.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{tenant="t1",le="0.5"} 1
+duration_seconds_bucket{tenant="t1",le="1"} 2
+duration_seconds_bucket{tenant="t1",le="2.5"} 2
+duration_seconds_bucket{tenant="t1",le="+Inf"} 3
+duration_seconds_sum{tenant="t1"} 4.1
+duration_seconds_count{tenant="t1"} 3
+duration_seconds_bucket{tenant="t2",le="0.5"} 0
+duration_seconds_bucket{tenant="t2",le="1"} 0
+duration_seconds_bucket{tenant="t2",le="2.5"} 1
+duration_seconds_bucket{tenant="t2",le="+Inf"} 1
+duration_seconds_sum{tenant="t2"} 2
+duration_seconds_count{tenant="t2"} 1
+# HELP size_bytes Size of requests.
+# TYPE size_bytes histogram
+size_bytes_bucket{le="1024"} 0
+size_bytes_bucket{le="+Inf"} 1
+size_bytes_sum 2048
+size_bytes_count 1
+`, buf.String())
+}
+
+func TestRegistryMisuse(t *testing.T) {
+	r := NewRegistry()
+
+	counter := r.NewCounter("events_total", "Number of events.", "tenant")
+
+	assert.Panics(t, func() { r.NewGauge("events_total", "Duplicate.") })
+	assert.Panics(t, func() { counter.Add(-1, "t1") })
+	assert.Panics(t, func() { counter.Inc() })
+	assert.Panics(t, func() { counter.Inc("t1", "extra") })
+	assert.Panics(t, func() { r.NewHistogram("unordered", "Unordered.", []float64{2, 1}) })
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("events_total", "Number of events.").Inc()
+
+	api := rest.NewApi()
+	router, _ := rest.MakeRouter(rest.Get("/metrics", Handler(r)))
+	api.SetApp(router)
+
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/metrics", nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.HeaderIs("Content-Type", ContentType)
+	recorded.BodyIs("# HELP events_total Number of events.\n" +
+		"# TYPE events_total counter\n" +
+		"events_total 1\n")
+}
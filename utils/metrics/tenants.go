@@ -0,0 +1,69 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package metrics
+
+import (
+	"sync"
+)
+
+// OtherTenants is the tenant label value of the tenants over the limit.
+const OtherTenants = "other"
+
+// TenantLabels limits the number of the tenants labeled on their own,
+// so that the number of the series does not grow with the number of
+// the tenants. The tenants are labeled on their own in the order they are
+// first seen, until the limit is reached; the tenants seen later share
+// the OtherTenants label. Once given, the label of a tenant doesn't change,
+// so that the gauges changed in steps stay consistent.
+type TenantLabels struct {
+	mu      sync.Mutex
+	max     int
+	labeled map[string]struct{}
+}
+
+// NewTenantLabels creates the tenant labels, not limited until SetLimit.
+func NewTenantLabels() *TenantLabels {
+	return &TenantLabels{
+		labeled: map[string]struct{}{},
+	}
+}
+
+// SetLimit sets the maximum number of the tenants labeled on their own,
+// zero means no limit. Tenants already labeled on their own keep their labels.
+func (t *TenantLabels) SetLimit(max int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.max = max
+}
+
+// Label returns the label value of the tenant. The empty tenant, of the
+// single tenant setups, is not counted in the limit.
+func (t *TenantLabels) Label(tenant string) string {
+	if tenant == "" {
+		return tenant
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.labeled[tenant]; ok {
+		return tenant
+	}
+	if t.max > 0 && len(t.labeled) >= t.max {
+		return OtherTenants
+	}
+	t.labeled[tenant] = struct{}{}
+	return tenant
+}
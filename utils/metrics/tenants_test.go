@@ -0,0 +1,46 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantLabels(t *testing.T) {
+	labels := NewTenantLabels()
+
+	// not limited by default
+	assert.Equal(t, "t1", labels.Label("t1"))
+	assert.Equal(t, "t2", labels.Label("t2"))
+
+	labels.SetLimit(3)
+	assert.Equal(t, "t3", labels.Label("t3"))
+	assert.Equal(t, OtherTenants, labels.Label("t4"))
+	assert.Equal(t, OtherTenants, labels.Label("t5"))
+
+	// labeled tenants keep their labels
+	assert.Equal(t, "t1", labels.Label("t1"))
+	assert.Equal(t, "t3", labels.Label("t3"))
+
+	// empty tenant is not counted
+	assert.Equal(t, "", labels.Label(""))
+
+	// lowering the limit doesn't relabel the tenants
+	labels.SetLimit(1)
+	assert.Equal(t, "t2", labels.Label("t2"))
+	assert.Equal(t, OtherTenants, labels.Label("t4"))
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package readpref selects which operations may read from the secondary
+// members of the MongoDB replica set.
+//
+// Reads from the secondaries spread the load, but may be stale: the
+// replication lag is usually below a second, but is not bounded. Only
+// the operations serving the list and get endpoints are allowed to read
+// from the secondaries, everything a write depends on, e.g. the lookup
+// of the artifact just uploaded, reads from the primary.
+package readpref
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+)
+
+// Read preferences
+const (
+	Primary            = "primary"
+	PrimaryPreferred   = "primaryPreferred"
+	Secondary          = "secondary"
+	SecondaryPreferred = "secondaryPreferred"
+	Nearest            = "nearest"
+)
+
+var modes = map[string]mgo.Mode{
+	strings.ToLower(Primary):            mgo.Primary,
+	strings.ToLower(PrimaryPreferred):   mgo.PrimaryPreferred,
+	strings.ToLower(Secondary):          mgo.Secondary,
+	strings.ToLower(SecondaryPreferred): mgo.SecondaryPreferred,
+	strings.ToLower(Nearest):            mgo.Nearest,
+}
+
+type contextKey int
+
+const secondaryReadsKey contextKey = 0
+
+// ParseMode parses the read preference name, case insensitive.
+func ParseMode(name string) (mgo.Mode, error) {
+	mode, ok := modes[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return mgo.Primary, fmt.Errorf("unknown read preference: '%s'", name)
+	}
+	return mode, nil
+}
+
+// WithSecondaryReads returns context allowing the reads to be served
+// by the secondaries, according to the configured read preference.
+func WithSecondaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, secondaryReadsKey, true)
+}
+
+// SecondaryReads tells if the reads of the context may be served
+// by the secondaries.
+func SecondaryReads(ctx context.Context) bool {
+	allowed, _ := ctx.Value(secondaryReadsKey).(bool)
+	return allowed
+}
+
+// SetMode sets the read mode of the session copy, if the reads of
+// the context may be served by the secondaries. Writes always go
+// to the primary, regardless of the mode.
+func SetMode(ctx context.Context, session *mgo.Session, mode mgo.Mode) {
+	if mode != mgo.Primary && SecondaryReads(ctx) {
+		session.SetMode(mode, false)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package readpref
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2"
+)
+
+func TestParseMode(t *testing.T) {
+	testCases := map[string]struct {
+		name string
+		mode mgo.Mode
+		err  bool
+	}{
+		"primary":             {name: Primary, mode: mgo.Primary},
+		"primary preferred":   {name: PrimaryPreferred, mode: mgo.PrimaryPreferred},
+		"secondary":           {name: Secondary, mode: mgo.Secondary},
+		"secondary preferred": {name: SecondaryPreferred, mode: mgo.SecondaryPreferred},
+		"nearest":             {name: Nearest, mode: mgo.Nearest},
+		"case insensitive":    {name: " SecondaryPreferred ", mode: mgo.SecondaryPreferred},
+		"empty":               {name: "", err: true},
+		"unknown":             {name: "eventual", err: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mode, err := ParseMode(tc.name)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.mode, mode)
+		})
+	}
+}
+
+func TestSecondaryReads(t *testing.T) {
+	ctx := context.Background()
+	assert.False(t, SecondaryReads(ctx))
+	assert.True(t, SecondaryReads(WithSecondaryReads(ctx)))
+}
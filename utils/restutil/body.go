@@ -0,0 +1,61 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+)
+
+// MalformedBodyError describes why the request body could not be decoded.
+// It is distinct from validation failures of a well formed body.
+type MalformedBodyError struct {
+	Reason string
+}
+
+func (e *MalformedBodyError) Error() string {
+	return "Malformed request body: " + e.Reason
+}
+
+// DecodeJsonObject decodes JSON object read from source into v.
+// Empty, truncated and syntactically invalid payloads, payloads which are not
+// a JSON object and fields of unexpected type are reported with *MalformedBodyError.
+// Errors returned by custom unmarshalers, usually validation failures,
+// are passed unchanged.
+func DecodeJsonObject(source io.Reader, v interface{}) error {
+	content, err := ioutil.ReadAll(source)
+	if err != nil {
+		return &MalformedBodyError{Reason: err.Error()}
+	}
+
+	content = bytes.TrimSpace(content)
+	if len(content) == 0 {
+		return &MalformedBodyError{Reason: "JSON payload is empty"}
+	}
+
+	if content[0] != '{' {
+		return &MalformedBodyError{Reason: "JSON object expected"}
+	}
+
+	err = json.Unmarshal(content, v)
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return &MalformedBodyError{Reason: err.Error()}
+	}
+
+	return err
+}
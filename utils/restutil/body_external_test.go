@@ -0,0 +1,101 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/mendersoftware/deployments/utils/restutil"
+)
+
+func TestDecodeJsonObject(t *testing.T) {
+
+	t.Parallel()
+
+	type object struct {
+		Name string `json:"name"`
+	}
+
+	testCases := []struct {
+		body string
+
+		out object
+		err string
+	}{
+		{
+			body: `{"name": "foo"}`,
+			out:  object{Name: "foo"},
+		},
+		{
+			body: " \n",
+			err:  "Malformed request body: JSON payload is empty",
+		},
+		{
+			body: `{"name": "fo`,
+			err:  "Malformed request body: unexpected end of JSON input",
+		},
+		{
+			body: `["foo"]`,
+			err:  "Malformed request body: JSON object expected",
+		},
+		{
+			body: `null`,
+			err:  "Malformed request body: JSON object expected",
+		},
+		{
+			body: `"foo"`,
+			err:  "Malformed request body: JSON object expected",
+		},
+		{
+			body: `{"name": 1}`,
+			// exact message depends on the Go version
+			err: "cannot unmarshal number",
+		},
+	}
+
+	for _, tc := range testCases {
+		var out object
+		err := DecodeJsonObject(strings.NewReader(tc.body), &out)
+		if tc.err != "" {
+			assert.IsType(t, &MalformedBodyError{}, err, tc.body)
+			assert.Contains(t, err.Error(), tc.err, tc.body)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, tc.out, out)
+		}
+	}
+}
+
+type validatedObject struct {
+	Name string
+}
+
+func (o *validatedObject) UnmarshalJSON(raw []byte) error {
+	return errors.New("Name: non zero value required")
+}
+
+func TestDecodeJsonObjectUnmarshalerError(t *testing.T) {
+
+	t.Parallel()
+
+	var out validatedObject
+	err := DecodeJsonObject(strings.NewReader(`{}`), &out)
+	assert.EqualError(t, err, "Name: non zero value required")
+	assert.IsType(t, errors.New(""), err)
+}
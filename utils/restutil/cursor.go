@@ -0,0 +1,110 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/rest_utils"
+)
+
+// QueryCursor is the query parameter of the cursor pagination; present,
+// even empty for the first page, it selects the cursor pagination.
+const QueryCursor = "cursor"
+
+// ErrInvalidCursor is returned for the cursor not issued by the service
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// Cursor points right after the last item of the page by the stable sort
+// key of the listing: the ID, preceded by the time for the items ordered
+// by time. Unlike the page number, the cursor does not drift when the items
+// are added or removed in the meantime. The clients get it encoded, as an
+// opaque string.
+type Cursor struct {
+	Time *time.Time `json:"t,omitempty"`
+	ID   string     `json:"id"`
+}
+
+// Encode returns the opaque representation of the cursor.
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses the cursor given by Encode.
+func DecodeCursor(value string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// CursorPage is the page of the cursor pagination
+type CursorPage struct {
+	// Items listed after the cursor, from the first one if nil
+	Cursor *Cursor
+	// Maximum number of the items listed
+	PerPage int
+}
+
+// ParseCursorPage parses the cursor and the page size (per_page, as in the
+// offset pagination) of the request. Nil if the request does not select
+// the cursor pagination.
+func ParseCursorPage(r *rest.Request) (*CursorPage, error) {
+	values, ok := r.URL.Query()[QueryCursor]
+	if !ok {
+		return nil, nil
+	}
+
+	perPage, err := rest_utils.ParseQueryParmUInt(r, rest_utils.PerPageName, false,
+		rest_utils.PerPageMin, rest_utils.PerPageMax, rest_utils.PerPageDefault)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &CursorPage{PerPage: int(perPage)}
+	if values[0] != "" {
+		if page.Cursor, err = DecodeCursor(values[0]); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// MakeCursorLinkHdr returns the Link header pointing to the next page,
+// listing the items after the cursor.
+func MakeCursorLinkHdr(r *rest.Request, next *Cursor) string {
+	url := *r.URL
+	q := url.Query()
+	q.Set(QueryCursor, next.Encode())
+	url.RawQuery = q.Encode()
+
+	url.Host = r.Host
+	if url.Scheme == "" {
+		url.Scheme = rest_utils.DefaultScheme
+	}
+
+	return fmt.Sprintf(rest_utils.LinkTmpl, url.String(), rest_utils.LinkNext)
+}
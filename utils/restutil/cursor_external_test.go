@@ -0,0 +1,109 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/mendersoftware/deployments/utils/restutil"
+)
+
+func TestCursor(t *testing.T) {
+
+	t.Parallel()
+
+	created := time.Date(2018, 5, 3, 10, 0, 0, 0, time.UTC)
+	for _, cursor := range []*Cursor{
+		{ID: "d50eda0d-2cea-4de1-8d42-9cd3e7e8670d"},
+		{Time: &created, ID: "d50eda0d-2cea-4de1-8d42-9cd3e7e8670d"},
+	} {
+		decoded, err := DecodeCursor(cursor.Encode())
+		assert.NoError(t, err)
+		assert.Equal(t, cursor, decoded)
+	}
+
+	for _, value := range []string{"foo!", "Zm9v", (&Cursor{}).Encode()} {
+		_, err := DecodeCursor(value)
+		assert.Equal(t, ErrInvalidCursor, err)
+	}
+}
+
+func TestParseCursorPage(t *testing.T) {
+
+	t.Parallel()
+
+	cursor := &Cursor{ID: "d50eda0d-2cea-4de1-8d42-9cd3e7e8670d"}
+
+	testCases := map[string]struct {
+		query string
+
+		page    *CursorPage
+		err     error
+		invalid bool
+	}{
+		"offset pagination": {
+			query: "?page=2",
+		},
+		"first page": {
+			query: "?cursor=",
+			page:  &CursorPage{PerPage: 20},
+		},
+		"next page": {
+			query: "?cursor=" + cursor.Encode() + "&per_page=50",
+			page:  &CursorPage{Cursor: cursor, PerPage: 50},
+		},
+		"invalid cursor": {
+			query: "?cursor=foo",
+			err:   ErrInvalidCursor,
+		},
+		"invalid page size": {
+			query:   "?cursor=&per_page=1000",
+			invalid: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet,
+				"http://localhost/r"+tc.query, nil)
+			page, err := ParseCursorPage(&rest.Request{Request: req})
+			if tc.invalid {
+				assert.Error(t, err)
+				return
+			}
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.page, page)
+		})
+	}
+}
+
+func TestMakeCursorLinkHdr(t *testing.T) {
+
+	t.Parallel()
+
+	cursor := &Cursor{ID: "d50eda0d-2cea-4de1-8d42-9cd3e7e8670d"}
+
+	req, _ := http.NewRequest(http.MethodGet,
+		"http://localhost/r?cursor=&per_page=10&tag=foo", nil)
+	assert.Equal(t,
+		"<http://localhost/r?cursor="+cursor.Encode()+
+			"&per_page=10&tag=foo>; rel=\"next\"",
+		MakeCursorLinkHdr(&rest.Request{Request: req}, cursor))
+}
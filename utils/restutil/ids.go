@@ -0,0 +1,81 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+)
+
+// InvalidIDListError describes why the list of IDs passed
+// to a bulk operation was rejected.
+type InvalidIDListError struct {
+	// Entries that are not valid UUIDv4
+	Invalid []string
+	// Entries present more than once
+	Duplicated []string
+	// Number of entries exceeds the limit
+	TooLong bool
+	Limit   int
+}
+
+func (e *InvalidIDListError) Error() string {
+	var msgs []string
+	if e.TooLong {
+		msgs = append(msgs, fmt.Sprintf("too many IDs, at most %d allowed", e.Limit))
+	}
+	if len(e.Invalid) > 0 {
+		msgs = append(msgs, "IDs not UUIDv4: "+strings.Join(e.Invalid, ", "))
+	}
+	if len(e.Duplicated) > 0 {
+		msgs = append(msgs, "duplicated IDs: "+strings.Join(e.Duplicated, ", "))
+	}
+	return "Invalid ID list: " + strings.Join(msgs, "; ")
+}
+
+// ValidateIDList checks the ID list of a bulk operation:
+// it has to be non-empty, at most limit long and contain unique UUIDv4 IDs only.
+// Returns *InvalidIDListError listing offending entries.
+func ValidateIDList(ids []string, limit int) error {
+	e := &InvalidIDListError{Limit: limit}
+
+	if len(ids) == 0 {
+		return fmt.Errorf("Invalid ID list: empty")
+	}
+
+	if len(ids) > limit {
+		e.TooLong = true
+	}
+
+	seen := make(map[string]int, len(ids))
+	for _, id := range ids {
+		seen[id]++
+
+		switch {
+		case seen[id] == 2:
+			e.Duplicated = append(e.Duplicated, id)
+		case seen[id] == 1 && !govalidator.IsUUIDv4(id):
+			e.Invalid = append(e.Invalid, id)
+		}
+	}
+
+	if e.TooLong || len(e.Invalid) > 0 || len(e.Duplicated) > 0 {
+		return e
+	}
+
+	return nil
+}
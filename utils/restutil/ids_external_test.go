@@ -0,0 +1,71 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/mendersoftware/deployments/utils/restutil"
+)
+
+func TestValidateIDList(t *testing.T) {
+
+	t.Parallel()
+
+	const (
+		id1 = "d50eda0d-2cea-4de1-8d42-9cd3e7e8670d"
+		id2 = "b2a0bf9e-3b55-4bbf-a6f7-0c1a7c3e5f4a"
+	)
+
+	testCases := []struct {
+		ids   []string
+		limit int
+
+		err *InvalidIDListError
+	}{
+		{
+			ids:   []string{id1, id2},
+			limit: 2,
+		},
+		{
+			ids:   []string{id1, id2},
+			limit: 1,
+			err:   &InvalidIDListError{TooLong: true, Limit: 1},
+		},
+		{
+			ids:   []string{id1, "foo", id1, "foo", id1},
+			limit: 10,
+			err: &InvalidIDListError{
+				Invalid:    []string{"foo"},
+				Duplicated: []string{id1, "foo"},
+				Limit:      10,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		err := ValidateIDList(tc.ids, tc.limit)
+		if tc.err == nil {
+			assert.NoError(t, err)
+			continue
+		}
+		assert.Equal(t, tc.err, err)
+		assert.Contains(t, err.Error(), "Invalid ID list")
+	}
+
+	assert.Error(t, ValidateIDList(nil, 10))
+}
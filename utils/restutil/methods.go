@@ -0,0 +1,100 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package restutil
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+)
+
+var ErrMethodNotAllowed = errors.New("Method not allowed")
+
+// Methods responded with 405 Method Not Allowed if not supported by the path
+var MethodsNotAllowed = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// NewMethodNotAllowedHandler creates http handler responding with
+// 405 Method Not Allowed, listing the given methods in Allow header.
+// Adds OPTIONS method to the list automatically.
+func NewMethodNotAllowedHandler(methods ...string) rest.HandlerFunc {
+	allowed := make(map[string]bool, len(methods)+1)
+	for _, method := range methods {
+		allowed[method] = true
+	}
+	allowed[http.MethodOptions] = true
+
+	sorted := make([]string, 0, len(allowed))
+	for method := range allowed {
+		sorted = append(sorted, method)
+	}
+	sort.Strings(sorted)
+
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		for _, method := range sorted {
+			w.Header().Add(HttpHeaderAllow, method)
+		}
+		new(view.RESTView).RenderError(w, r, ErrMethodNotAllowed,
+			http.StatusMethodNotAllowed, log.FromContext(r.Context()))
+	}
+}
+
+// AutogenMethodNotAllowedRoutes adds routes responding with 405 Method Not
+// Allowed for each of MethodsNotAllowed not supported by the defined path.
+// The router responds with 405 on its own, but without Allow header.
+func AutogenMethodNotAllowedRoutes(createHandler CreateOptionsHandler,
+	routes ...*rest.Route) []*rest.Route {
+
+	methodGroups := make(map[string][]string, len(routes))
+	for _, route := range routes {
+		methodGroups[route.PathExp] = append(methodGroups[route.PathExp], route.HttpMethod)
+	}
+
+	notAllowed := make([]*rest.Route, 0, len(methodGroups))
+	for path, methods := range methodGroups {
+		handler := createHandler(methods...)
+
+		for _, method := range MethodsNotAllowed {
+			if !contains(methods, method) {
+				notAllowed = append(notAllowed, &rest.Route{
+					HttpMethod: method,
+					PathExp:    path,
+					Func:       handler,
+				})
+			}
+		}
+	}
+
+	return append(routes, notAllowed...)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
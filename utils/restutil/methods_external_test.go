@@ -0,0 +1,85 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package restutil_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+
+	. "github.com/mendersoftware/deployments/utils/restutil"
+)
+
+func TestMethodNotAllowed(t *testing.T) {
+
+	t.Parallel()
+
+	ok := func(w rest.ResponseWriter, r *rest.Request) {}
+
+	routes := []*rest.Route{
+		rest.Get("/r", ok),
+		rest.Put("/r", ok),
+		rest.Get("/r/:id", ok),
+		rest.Post("/r/action", ok),
+	}
+	routes = AutogenOptionsRoutes(NewOptionsHandler, routes...)
+	routes = AutogenMethodNotAllowedRoutes(NewMethodNotAllowedHandler, routes...)
+
+	router, err := rest.MakeRouter(routes...)
+	if err != nil {
+		t.FailNow()
+	}
+
+	api := rest.NewApi()
+	api.SetApp(router)
+
+	testCases := []struct {
+		method string
+		path   string
+
+		code  int
+		allow []string
+	}{
+		{http.MethodGet, "/r", http.StatusOK, nil},
+		{http.MethodPut, "/r", http.StatusOK, nil},
+		{http.MethodPost, "/r", http.StatusMethodNotAllowed,
+			[]string{"GET", "OPTIONS", "PUT"}},
+		{http.MethodDelete, "/r/123", http.StatusMethodNotAllowed,
+			[]string{"GET", "OPTIONS"}},
+		// defined routes take precedence over the generated ones
+		{http.MethodPost, "/r/action", http.StatusOK, nil},
+		{http.MethodGet, "/r/action", http.StatusOK, nil},
+		{http.MethodGet, "/unknown", http.StatusNotFound, nil},
+	}
+
+	for _, tc := range testCases {
+		recorded := test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest(tc.method, "http://1.2.3.4"+tc.path, nil))
+
+		recorded.CodeIs(tc.code)
+
+		allow := recorded.Recorder.Header()[HttpHeaderAllow]
+		if len(allow) != len(tc.allow) {
+			t.Errorf("%s %s: expected Allow %v, got %v", tc.method, tc.path, tc.allow, allow)
+			continue
+		}
+		for i := range allow {
+			if allow[i] != tc.allow[i] {
+				t.Errorf("%s %s: expected Allow %v, got %v", tc.method, tc.path, tc.allow, allow)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+)
+
+// RecoverMiddleware responds with 500 Internal Server Error to the requests
+// which handler panicked, logging the panic along with its stack trace.
+// Has to be placed after the request ID middleware, so that the log entry
+// carries the request ID.
+type RecoverMiddleware struct {
+}
+
+// handlerPanic is the panic recovered in the handler goroutine, passed on
+// with the stack trace of the goroutine.
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// MiddlewareFunc makes RecoverMiddleware implement the Middleware interface.
+func (mw *RecoverMiddleware) MiddlewareFunc(h rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			stack := debug.Stack()
+			if p, ok := rec.(*handlerPanic); ok {
+				rec, stack = p.value, p.stack
+			}
+
+			l := log.FromContext(r.Context()).F(log.Ctx{"stack": string(stack)})
+			new(view.RESTView).RenderInternalError(w, r,
+				fmt.Errorf("handler panic: %v", rec), l)
+		}()
+
+		h(w, r)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+	"github.com/mendersoftware/go-lib-micro/requestlog"
+
+	. "github.com/mendersoftware/deployments/utils/restutil"
+)
+
+func TestRecoverMiddleware(t *testing.T) {
+
+	t.Parallel()
+
+	router, err := rest.MakeRouter(
+		rest.Get("/ok", func(w rest.ResponseWriter, r *rest.Request) {
+			w.WriteJson(map[string]string{"status": "ok"})
+		}),
+		rest.Get("/panic", func(w rest.ResponseWriter, r *rest.Request) {
+			panic("handler failed")
+		}),
+	)
+	if err != nil {
+		t.FailNow()
+	}
+
+	api := rest.NewApi()
+	api.Use(&requestlog.RequestLogMiddleware{},
+		&requestid.RequestIdMiddleware{},
+		&RecoverMiddleware{})
+	api.SetApp(router)
+
+	req := test.MakeSimpleRequest(http.MethodGet, "http://1.2.3.4/ok", nil)
+	recorded := test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs(`{"status":"ok"}`)
+
+	req = test.MakeSimpleRequest(http.MethodGet, "http://1.2.3.4/panic", nil)
+	req.Header.Set(requestid.RequestIdHeader, "test")
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusInternalServerError)
+	recorded.ContentTypeIsJson()
+	recorded.BodyIs(`{"error":"internal error","request_id":"test"}`)
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// ClaimRoles is the claim of the user JWT listing the roles of the user
+const ClaimRoles = "mender.roles"
+
+// HasRole tells if the JWT the request is authorized with lists the role.
+// Same as for the identity, the token is not verified, it is up to the API
+// gateway.
+func HasRole(r *rest.Request, role string) bool {
+	auth := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(auth) != 2 || auth[0] != "Bearer" {
+		return false
+	}
+
+	parts := strings.Split(auth[1], ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	// tokens are expected to be base64url encoded, but not all the issuers do
+	claims64 := strings.TrimRight(parts[1], "=")
+	payload, err := base64.RawURLEncoding.DecodeString(claims64)
+	if err != nil {
+		payload, err = base64.RawStdEncoding.DecodeString(claims64)
+	}
+	if err != nil {
+		return false
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return false
+	}
+
+	roles, _ := claims[ClaimRoles].([]interface{})
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
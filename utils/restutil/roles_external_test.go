@@ -0,0 +1,75 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/mendersoftware/deployments/utils/restutil"
+)
+
+func TestHasRole(t *testing.T) {
+
+	t.Parallel()
+
+	claims := `{"sub": "user-1", "mender.roles": ["admin", "operator"]}`
+
+	testCases := map[string]struct {
+		auth string
+		role string
+
+		hasRole bool
+	}{
+		"role listed": {
+			auth:    "Bearer foo." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".bar",
+			role:    "operator",
+			hasRole: true,
+		},
+		"padded standard encoding": {
+			auth:    "Bearer foo." + base64.StdEncoding.EncodeToString([]byte(claims)) + ".bar",
+			role:    "admin",
+			hasRole: true,
+		},
+		"role not listed": {
+			auth: "Bearer foo." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".bar",
+			role: "artifact_reviewer",
+		},
+		"no roles": {
+			auth: "Bearer foo." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub": "user-1"}`)) + ".bar",
+			role: "admin",
+		},
+		"malformed token": {
+			auth: "Bearer foo.bar",
+			role: "admin",
+		},
+		"not bearer": {
+			auth: "Basic dXNlcjpwYXNz",
+			role: "admin",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost/r", nil)
+			req.Header.Set("Authorization", tc.auth)
+			assert.Equal(t, tc.hasRole, HasRole(&rest.Request{Request: req}, tc.role))
+		})
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+)
+
+var (
+	ErrHandlerTimeout   = errors.New("Request timed out")
+	ErrOperationTimeout = errors.New("Operation timed out")
+	ErrUnknownRoute     = errors.New("unknown route")
+)
+
+// RouteKey identifies the route in the timeouts configuration,
+// e.g. 'GET /api/management/v1/deployments/deployments/:id'.
+func RouteKey(route *rest.Route) string {
+	return route.HttpMethod + " " + route.PathExp
+}
+
+// WithTimeouts wraps the route handlers, so that the requests handled longer
+// than the timeout are responded with 503 Service Unavailable. Routes listed
+// in overrides by RouteKey get their own timeout, zero disables the timeout.
+// The handler context is canceled on timeout, anything it writes afterwards
+// is discarded.
+func WithTimeouts(timeout time.Duration, overrides map[string]time.Duration,
+	routes []*rest.Route) ([]*rest.Route, error) {
+
+	known := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		key := RouteKey(route)
+		known[key] = true
+
+		t := timeout
+		if override, ok := overrides[key]; ok {
+			t = override
+		}
+		if t > 0 {
+			route.Func = withTimeout(t, route.Func)
+		}
+	}
+
+	for key := range overrides {
+		if !known[key] {
+			return nil, errors.Wrap(ErrUnknownRoute, key)
+		}
+	}
+
+	return routes, nil
+}
+
+func withTimeout(timeout time.Duration, h rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{w: w, header: make(http.Header)}
+		req := *r
+		req.Request = r.Request.WithContext(ctx)
+
+		done := make(chan struct{})
+		panicked := make(chan *handlerPanic, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					hp := &handlerPanic{value: p, stack: debug.Stack()}
+					if tw.timedOut() {
+						// nobody is waiting for the handler anymore
+						log.FromContext(ctx).F(log.Ctx{"stack": string(hp.stack)}).
+							Errorf("handler panic after timeout: %v", p)
+					}
+					panicked <- hp
+				}
+				close(done)
+			}()
+			h(tw, &req)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+		case <-ctx.Done():
+			tw.timeout(r)
+		}
+	}
+}
+
+// timeoutWriter passes the response through until the handler times out,
+// later writes are discarded. Headers are kept aside until the status is
+// written, so that the handler does not modify them concurrently with
+// the timeout response.
+type timeoutWriter struct {
+	w      rest.ResponseWriter
+	header http.Header
+
+	mu          sync.Mutex
+	wroteHeader bool
+	expired     bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) EncodeJson(v interface{}) ([]byte, error) {
+	return tw.w.EncodeJson(v)
+}
+
+func (tw *timeoutWriter) WriteJson(v interface{}) error {
+	b, err := tw.w.EncodeJson(v)
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(b)
+	return err
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.expired || tw.wroteHeader {
+		return
+	}
+	tw.writeHeader(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.expired {
+		return len(p), nil
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	return tw.w.(http.ResponseWriter).Write(p)
+}
+
+// Flush passes the response written so far through, e.g. the streamed events.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.expired {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	tw.w.(http.Flusher).Flush()
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	header := tw.w.Header()
+	for k, v := range tw.header {
+		header[k] = v
+	}
+	tw.w.WriteHeader(code)
+	tw.wroteHeader = true
+}
+
+func (tw *timeoutWriter) timedOut() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	return tw.expired
+}
+
+// timeout stops passing the response through, responding with 503 unless
+// the handler already started the response.
+func (tw *timeoutWriter) timeout(r *rest.Request) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.expired = true
+	if !tw.wroteHeader {
+		new(view.RESTView).RenderError(tw.w, r, ErrHandlerTimeout,
+			http.StatusServiceUnavailable, log.FromContext(r.Context()))
+	}
+}
+
+// WithOperationTimeout bounds the context of the route handlers with the
+// timeout, zero disables it. Unlike the handler timeout, the handler is
+// waited for, and the deadline is lifted once it starts the response, so that
+// the streamed responses, e.g. the downloads, are not cut. The internal error
+// responded after the deadline passed is replaced with 504 Gateway Timeout.
+// Routes listed in except by RouteKey are left alone, e.g. the ones bounded
+// by their own timeout.
+func WithOperationTimeout(timeout time.Duration, except map[string]bool,
+	routes []*rest.Route) []*rest.Route {
+
+	if timeout <= 0 {
+		return routes
+	}
+	for _, route := range routes {
+		if !except[RouteKey(route)] {
+			route.Func = withOperationTimeout(timeout, route.Func)
+		}
+	}
+	return routes
+}
+
+func withOperationTimeout(timeout time.Duration, h rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		ctx := newOperationContext(r.Context(), timeout)
+		defer ctx.cancel()
+
+		ow := &operationWriter{ResponseWriter: w, ctx: ctx}
+		req := *r
+		req.Request = r.Request.WithContext(ctx)
+		h(ow, &req)
+
+		if ow.timedOut {
+			new(view.RESTView).RenderError(w, r, ErrOperationTimeout,
+				http.StatusGatewayTimeout, log.FromContext(r.Context()))
+		}
+	}
+}
+
+// operationContext is canceled once the timeout passes, unless the deadline
+// is lifted before. Err tells that the deadline passed then, like the one
+// of the context with the deadline does.
+type operationContext struct {
+	context.Context
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	expired int32
+}
+
+func newOperationContext(parent context.Context,
+	timeout time.Duration) *operationContext {
+
+	ctx, cancel := context.WithCancel(parent)
+	oc := &operationContext{Context: ctx, cancel: cancel}
+	oc.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&oc.expired, 1)
+		cancel()
+	})
+	return oc
+}
+
+func (oc *operationContext) Err() error {
+	err := oc.Context.Err()
+	if err != nil && oc.isExpired() {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func (oc *operationContext) String() string {
+	return fmt.Sprintf("%v.WithOperationTimeout", oc.Context)
+}
+
+func (oc *operationContext) isExpired() bool {
+	return atomic.LoadInt32(&oc.expired) == 1
+}
+
+// lift stops the timeout, unless it passed already.
+func (oc *operationContext) lift() {
+	oc.timer.Stop()
+}
+
+// operationWriter lifts the deadline of the operation once the response
+// starts. The internal error responded after the deadline passed is
+// discarded, to be replaced with the timeout error.
+type operationWriter struct {
+	rest.ResponseWriter
+	ctx *operationContext
+
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (ow *operationWriter) WriteHeader(code int) {
+	if ow.wroteHeader {
+		return
+	}
+	ow.wroteHeader = true
+	if code == http.StatusInternalServerError && ow.ctx.isExpired() {
+		ow.timedOut = true
+		return
+	}
+	ow.ctx.lift()
+	ow.ResponseWriter.WriteHeader(code)
+}
+
+func (ow *operationWriter) WriteJson(v interface{}) error {
+	b, err := ow.EncodeJson(v)
+	if err != nil {
+		return err
+	}
+	_, err = ow.Write(b)
+	return err
+}
+
+func (ow *operationWriter) Write(p []byte) (int, error) {
+	if !ow.wroteHeader {
+		ow.WriteHeader(http.StatusOK)
+	}
+	if ow.timedOut {
+		return len(p), nil
+	}
+	return ow.ResponseWriter.(http.ResponseWriter).Write(p)
+}
+
+// Flush passes the response written so far through, e.g. the streamed events.
+func (ow *operationWriter) Flush() {
+	if !ow.wroteHeader {
+		ow.WriteHeader(http.StatusOK)
+	}
+	if ow.timedOut {
+		return
+	}
+	ow.ResponseWriter.(http.Flusher).Flush()
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package restutil_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+	"github.com/mendersoftware/go-lib-micro/requestlog"
+
+	. "github.com/mendersoftware/deployments/utils/restutil"
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+)
+
+func TestWithTimeouts(t *testing.T) {
+
+	t.Parallel()
+
+	slow := func(w rest.ResponseWriter, r *rest.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+		w.WriteJson(map[string]string{"status": "done"})
+	}
+	fast := func(w rest.ResponseWriter, r *rest.Request) {
+		w.Header().Set("X-Test", "fast")
+		w.WriteHeader(http.StatusCreated)
+		w.WriteJson(map[string]string{"status": "done"})
+	}
+	panicking := func(w rest.ResponseWriter, r *rest.Request) {
+		panic("handler failed")
+	}
+	// streamed until the timeout, the rest is discarded
+	streaming := func(w rest.ResponseWriter, r *rest.Request) {
+		w.(http.ResponseWriter).Write([]byte("event\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		w.(http.ResponseWriter).Write([]byte("discarded\n"))
+	}
+
+	routes, err := WithTimeouts(10*time.Millisecond, map[string]time.Duration{
+		"GET /unlimited": 0,
+	}, []*rest.Route{
+		rest.Get("/slow", slow),
+		rest.Get("/unlimited", slow),
+		rest.Post("/fast", fast),
+		rest.Get("/panic", panicking),
+		rest.Get("/stream", streaming),
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	router, err := rest.MakeRouter(routes...)
+	if err != nil {
+		t.FailNow()
+	}
+
+	api := rest.NewApi()
+	api.Use(&requestlog.RequestLogMiddleware{},
+		&requestid.RequestIdMiddleware{},
+		&RecoverMiddleware{})
+	api.SetApp(router)
+
+	req := test.MakeSimpleRequest(http.MethodGet, "http://1.2.3.4/slow", nil)
+	req.Header.Set(requestid.RequestIdHeader, "test")
+	recorded := test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusServiceUnavailable)
+	recorded.BodyIs(`{"error":"Request timed out","request_id":"test"}`)
+
+	req = test.MakeSimpleRequest(http.MethodGet, "http://1.2.3.4/stream", nil)
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs("event\n")
+
+	req = test.MakeSimpleRequest(http.MethodGet, "http://1.2.3.4/unlimited", nil)
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs(`{"status":"done"}`)
+
+	req = test.MakeSimpleRequest(http.MethodPost, "http://1.2.3.4/fast", nil)
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusCreated)
+	recorded.HeaderIs("X-Test", "fast")
+	recorded.ContentTypeIsJson()
+	recorded.BodyIs(`{"status":"done"}`)
+
+	req = test.MakeSimpleRequest(http.MethodGet, "http://1.2.3.4/panic", nil)
+	req.Header.Set(requestid.RequestIdHeader, "test")
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusInternalServerError)
+	recorded.BodyIs(`{"error":"internal error","request_id":"test"}`)
+}
+
+func TestWithTimeoutsUnknownRoute(t *testing.T) {
+
+	t.Parallel()
+
+	_, err := WithTimeouts(time.Second, map[string]time.Duration{
+		"GET /r/:id": time.Minute,
+	}, []*rest.Route{
+		rest.Get("/r", func(w rest.ResponseWriter, r *rest.Request) {}),
+	})
+	if err == nil || err.Error() != "GET /r/:id: unknown route" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWithOperationTimeout(t *testing.T) {
+
+	t.Parallel()
+
+	// fails once the deadline passes
+	failing := func(w rest.ResponseWriter, r *rest.Request) {
+		<-r.Context().Done()
+		if r.Context().Err() != context.DeadlineExceeded {
+			t.Errorf("unexpected context error: %v", r.Context().Err())
+		}
+		new(view.RESTView).RenderInternalError(w, r, r.Context().Err(),
+			log.FromContext(r.Context()))
+	}
+	notFound := func(w rest.ResponseWriter, r *rest.Request) {
+		<-r.Context().Done()
+		new(view.RESTView).RenderErrorNotFound(w, r, log.FromContext(r.Context()))
+	}
+	// streamed past the deadline, lifted once the response started
+	streaming := func(w rest.ResponseWriter, r *rest.Request) {
+		w.(http.ResponseWriter).Write([]byte("event\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		if r.Context().Err() != nil {
+			t.Errorf("unexpected context error: %v", r.Context().Err())
+		}
+		w.(http.ResponseWriter).Write([]byte("more\n"))
+	}
+	slow := func(w rest.ResponseWriter, r *rest.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(50 * time.Millisecond):
+		}
+		w.WriteJson(map[string]string{"status": "done"})
+	}
+
+	routes := WithOperationTimeout(10*time.Millisecond, map[string]bool{
+		"GET /exempt": true,
+	}, []*rest.Route{
+		rest.Get("/failing", failing),
+		rest.Get("/notfound", notFound),
+		rest.Get("/stream", streaming),
+		rest.Get("/exempt", slow),
+	})
+
+	router, err := rest.MakeRouter(routes...)
+	if err != nil {
+		t.FailNow()
+	}
+
+	api := rest.NewApi()
+	api.Use(&requestlog.RequestLogMiddleware{},
+		&requestid.RequestIdMiddleware{},
+		&RecoverMiddleware{})
+	api.SetApp(router)
+
+	req := test.MakeSimpleRequest(http.MethodGet, "http://1.2.3.4/failing", nil)
+	req.Header.Set(requestid.RequestIdHeader, "test")
+	recorded := test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusGatewayTimeout)
+	recorded.ContentTypeIsJson()
+	recorded.BodyIs(`{"error":"Operation timed out","request_id":"test"}`)
+
+	req = test.MakeSimpleRequest(http.MethodGet, "http://1.2.3.4/notfound", nil)
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusNotFound)
+
+	req = test.MakeSimpleRequest(http.MethodGet, "http://1.2.3.4/stream", nil)
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs("event\nmore\n")
+
+	req = test.MakeSimpleRequest(http.MethodGet, "http://1.2.3.4/exempt", nil)
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs(`{"status":"done"}`)
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package restutil
+
+import (
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// TrailingSlashMiddleware strips trailing slashes from the request path
+// before routing, so that '/artifacts/' is served as '/artifacts'.
+type TrailingSlashMiddleware struct {
+}
+
+// MiddlewareFunc makes TrailingSlashMiddleware implement the Middleware interface.
+func (mw *TrailingSlashMiddleware) MiddlewareFunc(h rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		r.URL.Path = trimTrailingSlash(r.URL.Path)
+		if r.URL.RawPath != "" {
+			r.URL.RawPath = trimTrailingSlash(r.URL.RawPath)
+		}
+
+		h(w, r)
+	}
+}
+
+func trimTrailingSlash(path string) string {
+	trimmed := strings.TrimRight(path, "/")
+	if trimmed == "" && path != "" {
+		return "/"
+	}
+	return trimmed
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package restutil_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+
+	. "github.com/mendersoftware/deployments/utils/restutil"
+)
+
+func TestTrailingSlashMiddleware(t *testing.T) {
+
+	t.Parallel()
+
+	var served string
+	handler := func(w rest.ResponseWriter, r *rest.Request) {
+		served = r.URL.Path
+	}
+
+	router, err := rest.MakeRouter(
+		rest.Get("/", handler),
+		rest.Get("/r", handler),
+		rest.Get("/r/:id", handler),
+	)
+	if err != nil {
+		t.FailNow()
+	}
+
+	api := rest.NewApi()
+	api.Use(&TrailingSlashMiddleware{})
+	api.SetApp(router)
+
+	testCases := map[string]string{
+		"/":         "/",
+		"/r":        "/r",
+		"/r/":       "/r",
+		"/r//":      "/r",
+		"/r/123/":   "/r/123",
+		"/r/a%2Fb/": "/r/a/b",
+	}
+
+	for path, expected := range testCases {
+		served = ""
+		recorded := test.RunRequest(t, api.MakeHandler(),
+			test.MakeSimpleRequest(http.MethodGet, "http://1.2.3.4"+path, nil))
+
+		recorded.CodeIs(http.StatusOK)
+		if served != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, served)
+		}
+	}
+}
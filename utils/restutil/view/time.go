@@ -0,0 +1,110 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+
+package view
+
+import (
+	"reflect"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// utcTimes returns a copy of the object with all the times, however deeply
+// nested, in UTC. Times are rendered in the time zone they are in, which
+// depends on the storage driver, so that the clients would have to guess it.
+// Unexported fields are copied as they are.
+func utcTimes(object interface{}) interface{} {
+	if object == nil {
+		return nil
+	}
+	return utcValue(reflect.ValueOf(object)).Interface()
+}
+
+func utcValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return reflect.ValueOf(v.Interface().(time.Time).UTC())
+		}
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if field := copied.Field(i); field.CanSet() {
+				field.Set(utcValue(v.Field(i)))
+			}
+		}
+		return copied
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type().Elem())
+		copied.Elem().Set(utcValue(v.Elem()))
+		return copied
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(utcValue(v.Elem()))
+		return copied
+
+	case reflect.Slice:
+		if v.IsNil() || !mayHoldTime(v.Type().Elem()) {
+			return v
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(utcValue(v.Index(i)))
+		}
+		return copied
+
+	case reflect.Array:
+		if !mayHoldTime(v.Type().Elem()) {
+			return v
+		}
+		copied := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(utcValue(v.Index(i)))
+		}
+		return copied
+
+	case reflect.Map:
+		if v.IsNil() || !mayHoldTime(v.Type().Elem()) {
+			return v
+		}
+		copied := reflect.MakeMap(v.Type())
+		for _, key := range v.MapKeys() {
+			copied.SetMapIndex(key, utcValue(v.MapIndex(key)))
+		}
+		return copied
+	}
+
+	return v
+}
+
+// mayHoldTime tells if the values of the type can have times inside,
+// the collections of the other ones are not copied.
+func mayHoldTime(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Ptr, reflect.Interface,
+		reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	}
+	return false
+}
@@ -15,9 +15,13 @@
 package view
 
 import (
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/ant0ine/go-json-rest/rest"
@@ -27,14 +31,47 @@ import (
 
 // Headers
 const (
-	HttpHeaderLocation = "Location"
+	HttpHeaderLocation    = "Location"
+	HttpHeaderAccept      = "Accept"
+	HttpHeaderContentType = "Content-Type"
+)
+
+// Media types
+const (
+	ContentTypeJSON = "application/json"
+	ContentTypeXML  = "application/xml"
 )
 
 // Errors
 var (
-	ErrNotFound = errors.New("Resource not found")
+	ErrNotFound      = errors.New("Resource not found")
+	ErrNotAcceptable = errors.New("Requested media type not supported, use 'application/json' or 'application/xml'")
 )
 
+// DetailedError is implemented by the errors carrying the details of the
+// failure, e.g. the list of the invalid fields, which are rendered along
+// with the message.
+type DetailedError interface {
+	error
+	Details() interface{}
+}
+
+// errorDetails returns the details of the first DetailedError found
+// in the chain of the wrapped errors, nil if there are none.
+func errorDetails(err error) interface{} {
+	for err != nil {
+		if detailed, ok := err.(DetailedError); ok {
+			return detailed.Details()
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			return nil
+		}
+		err = cause.Cause()
+	}
+	return nil
+}
+
 type RESTView struct {
 }
 
@@ -43,13 +80,142 @@ func (p *RESTView) RenderSuccessPost(w rest.ResponseWriter, r *rest.Request, id
 	w.WriteHeader(http.StatusCreated)
 }
 
-func (p *RESTView) RenderSuccessGet(w rest.ResponseWriter, object interface{}) {
-	w.WriteJson(object)
+// RenderSuccessPostObject responds with 201 Created pointing to the created
+// resource, with the object in the body
+func (p *RESTView) RenderSuccessPostObject(w rest.ResponseWriter, r *rest.Request,
+	id string, object interface{}) {
+
+	w.Header().Add(HttpHeaderLocation, fmt.Sprintf("./%s/%s", strings.TrimLeft(r.URL.Path, "/api/0.0.1/"), id))
+	w.WriteHeader(http.StatusCreated)
+	w.WriteJson(utcTimes(object))
+}
+
+// RenderSuccessPostLocation responds with 201 Created pointing to the given location
+func (p *RESTView) RenderSuccessPostLocation(w rest.ResponseWriter, location string) {
+	w.Header().Add(HttpHeaderLocation, location)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// RenderSuccessRedirect responds with 302 Found pointing to the given location
+func (p *RESTView) RenderSuccessRedirect(w rest.ResponseWriter, location string) {
+	w.Header().Set(HttpHeaderLocation, location)
+	w.WriteHeader(http.StatusFound)
+}
+
+// RenderSuccessGet renders object as JSON, regardless of the Accept header.
+// Times are rendered in UTC.
+func (p *RESTView) RenderSuccessGet(w rest.ResponseWriter, r *rest.Request, object interface{}) {
+	w.WriteJson(utcTimes(object))
+}
+
+// RenderSuccessGetNegotiated renders object in the format requested by
+// the Accept header, JSON is used by default; 406 is responded if neither
+// JSON nor XML is acceptable. Times are rendered in UTC.
+func (p *RESTView) RenderSuccessGetNegotiated(w rest.ResponseWriter, r *rest.Request,
+	object interface{}) {
+
+	object = utcTimes(object)
+	switch negotiateContentType(r.Header.Get(HttpHeaderAccept)) {
+	case ContentTypeJSON:
+		w.WriteJson(object)
+	case ContentTypeXML:
+		renderXML(w, r, object)
+	default:
+		renderErrorWithMsg(w, r, http.StatusNotAcceptable, ErrNotAcceptable.Error())
+	}
+}
+
+// xmlList is the root element for collections, XML document needs single root
+type xmlList struct {
+	XMLName xml.Name `xml:"list"`
+	Items   interface{}
+}
+
+func renderXML(w rest.ResponseWriter, r *rest.Request, object interface{}) {
+	if v := reflect.ValueOf(object); v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		object = xmlList{Items: object}
+	}
+
+	data, err := xml.Marshal(object)
+	if err != nil {
+		// object has no XML representation
+		renderErrorWithMsg(w, r, http.StatusNotAcceptable, ErrNotAcceptable.Error())
+		return
+	}
+
+	w.Header().Set(HttpHeaderContentType, ContentTypeXML+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.(http.ResponseWriter).Write(append([]byte(xml.Header), data...)); err != nil {
+		panic(err)
+	}
+}
+
+// negotiateContentType selects response media type based on the Accept header.
+// Returns empty string if none of the supported media types is acceptable.
+func negotiateContentType(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return ContentTypeJSON
+	}
+
+	var best string
+	var bestQ float64
+	var bestSpecific bool
+
+	for _, item := range strings.Split(accept, ",") {
+		mediatype, params, err := mime.ParseMediaType(item)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		var candidate string
+		specific := true
+		switch mediatype {
+		case ContentTypeJSON:
+			candidate = ContentTypeJSON
+		case ContentTypeXML, "text/xml":
+			candidate = ContentTypeXML
+		case "application/*", "*/*":
+			candidate = ContentTypeJSON
+			specific = false
+		default:
+			continue
+		}
+
+		// more specific media range wins on equal quality
+		if q > bestQ || (q == bestQ && q > 0 && specific && !bestSpecific) {
+			best, bestQ, bestSpecific = candidate, q, specific
+		}
+	}
+
+	return best
 }
 
+// RenderError renders the error message, along with the details
+// if the error or any error it wraps is a DetailedError.
 func (p *RESTView) RenderError(w rest.ResponseWriter, r *rest.Request, err error, status int, l *log.Logger) {
 	l.Error(err.Error())
-	renderErrorWithMsg(w, r, status, err.Error())
+	details := errorDetails(err)
+	if details == nil {
+		renderErrorWithMsg(w, r, status, err.Error())
+		return
+	}
+
+	w.WriteHeader(status)
+	writeErr := w.WriteJson(map[string]interface{}{
+		"error":      err.Error(),
+		"details":    details,
+		"request_id": requestid.GetReqId(r),
+	})
+	if writeErr != nil {
+		panic(writeErr)
+	}
 }
 
 func (p *RESTView) RenderInternalError(w rest.ResponseWriter, r *rest.Request, err error, l *log.Logger) {
@@ -57,6 +223,22 @@ func (p *RESTView) RenderInternalError(w rest.ResponseWriter, r *rest.Request, e
 	renderErrorWithMsg(w, r, http.StatusInternalServerError, "internal error")
 }
 
+// RenderErrorWithCode renders the error along with the code identifying
+// the failure, so that the clients can react to it without parsing the message.
+func (p *RESTView) RenderErrorWithCode(w rest.ResponseWriter, r *rest.Request, err error,
+	status int, code string, l *log.Logger) {
+	l.F(log.Ctx{"code": code}).Error(err.Error())
+	w.WriteHeader(status)
+	writeErr := w.WriteJson(map[string]string{
+		"error":      err.Error(),
+		"code":       code,
+		"request_id": requestid.GetReqId(r),
+	})
+	if writeErr != nil {
+		panic(writeErr)
+	}
+}
+
 func renderErrorWithMsg(w rest.ResponseWriter, r *rest.Request, status int, msg string) {
 	w.WriteHeader(status)
 	writeErr := w.WriteJson(map[string]string{
@@ -15,12 +15,16 @@
 package view_test
 
 import (
+	"encoding/xml"
+	"errors"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/ant0ine/go-json-rest/rest/test"
 	"github.com/mendersoftware/go-lib-micro/log"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 
 	. "github.com/mendersoftware/deployments/utils/restutil/view"
@@ -47,10 +51,52 @@ func TestRenderPost(t *testing.T) {
 	recorded.HeaderIs(HttpHeaderLocation, "./test/test_id")
 }
 
+func TestRenderPostObject(t *testing.T) {
+
+	router, err := rest.MakeRouter(rest.Post("/test", func(w rest.ResponseWriter, r *rest.Request) {
+		new(RESTView).RenderSuccessPostObject(w, r, "test_id", map[string]string{"id": "test_id"})
+	}))
+
+	if err != nil {
+		assert.NoError(t, err)
+	}
+
+	api := rest.NewApi()
+	api.SetApp(router)
+
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/test", "blah"))
+
+	recorded.CodeIs(http.StatusCreated)
+	recorded.ContentTypeIsJson()
+	recorded.HeaderIs(HttpHeaderLocation, "./test/test_id")
+	recorded.BodyIs(`{"id":"test_id"}`)
+}
+
+func TestRenderPostLocation(t *testing.T) {
+
+	router, err := rest.MakeRouter(rest.Post("/test", func(w rest.ResponseWriter, r *rest.Request) {
+		new(RESTView).RenderSuccessPostLocation(w, "/test/test_id")
+	}))
+
+	if err != nil {
+		assert.NoError(t, err)
+	}
+
+	api := rest.NewApi()
+	api.SetApp(router)
+
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("POST", "http://localhost/test", "blah"))
+
+	recorded.CodeIs(http.StatusCreated)
+	recorded.HeaderIs(HttpHeaderLocation, "/test/test_id")
+}
+
 func TestRenderSuccessGet(t *testing.T) {
 
 	router, err := rest.MakeRouter(rest.Get("/test", func(w rest.ResponseWriter, r *rest.Request) {
-		new(RESTView).RenderSuccessGet(w, "test")
+		new(RESTView).RenderSuccessGet(w, r, "test")
 	}))
 
 	if err != nil {
@@ -68,6 +114,186 @@ func TestRenderSuccessGet(t *testing.T) {
 	recorded.BodyIs(`"test"`)
 }
 
+func TestRenderSuccessGetUTC(t *testing.T) {
+
+	zone := time.FixedZone("CEST", 2*60*60)
+	local := time.Date(2018, 3, 10, 11, 12, 1, 422000000, zone)
+
+	type item struct {
+		Created  time.Time  `json:"created" xml:"created"`
+		Modified *time.Time `json:"modified" xml:"modified"`
+		Missing  *time.Time `json:"missing,omitempty" xml:"missing,omitempty"`
+	}
+	type object struct {
+		XMLName xml.Name               `json:"-" xml:"object"`
+		Item    item                   `json:"item" xml:"item"`
+		Items   []*item                `json:"items" xml:"items>item"`
+		ByName  map[string]time.Time   `json:"by_name" xml:"-"`
+		Any     interface{}            `json:"any" xml:"-"`
+		Names   []string               `json:"names" xml:"-"`
+		Extra   map[string]interface{} `json:"extra" xml:"-"`
+		hidden  time.Time
+	}
+
+	in := &object{
+		Item:   item{Created: local, Modified: &local},
+		Items:  []*item{{Created: local, Modified: &local}},
+		ByName: map[string]time.Time{"a": local},
+		Any:    local,
+		Names:  []string{"a"},
+		Extra:  map[string]interface{}{"t": &local},
+		hidden: local,
+	}
+
+	router, err := rest.MakeRouter(rest.Get("/test", func(w rest.ResponseWriter, r *rest.Request) {
+		new(RESTView).RenderSuccessGetNegotiated(w, r, in)
+	}))
+	assert.NoError(t, err)
+
+	api := rest.NewApi()
+	api.SetApp(router)
+
+	utc := `"2018-03-10T09:12:01.422Z"`
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/test", nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs(`{"item":{"created":` + utc + `,"modified":` + utc + `},` +
+		`"items":[{"created":` + utc + `,"modified":` + utc + `}],` +
+		`"by_name":{"a":` + utc + `},"any":` + utc + `,"names":["a"],` +
+		`"extra":{"t":` + utc + `}}`)
+
+	req := test.MakeSimpleRequest("GET", "http://localhost/test", nil)
+	req.Header.Set(HttpHeaderAccept, ContentTypeXML)
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs(xml.Header + `<object>` +
+		`<item><created>2018-03-10T09:12:01.422Z</created>` +
+		`<modified>2018-03-10T09:12:01.422Z</modified></item>` +
+		`<items><item><created>2018-03-10T09:12:01.422Z</created>` +
+		`<modified>2018-03-10T09:12:01.422Z</modified></item></items>` +
+		`</object>`)
+
+	// rendered object is left intact
+	assert.Equal(t, zone, in.Item.Created.Location())
+	assert.Equal(t, zone, in.Items[0].Modified.Location())
+	assert.Equal(t, zone, in.hidden.Location())
+}
+
+func TestRenderSuccessGetNotNegotiated(t *testing.T) {
+	router, err := rest.MakeRouter(rest.Get("/test", func(w rest.ResponseWriter, r *rest.Request) {
+		new(RESTView).RenderSuccessGet(w, r, map[string]string{"foo": "bar"})
+	}))
+	assert.NoError(t, err)
+
+	api := rest.NewApi()
+	api.SetApp(router)
+
+	// JSON only, whatever is requested
+	req := test.MakeSimpleRequest("GET", "http://localhost/test", nil)
+	req.Header.Set(HttpHeaderAccept, "text/html")
+
+	recorded := test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusOK)
+	recorded.HeaderIs(HttpHeaderContentType, "application/json; charset=utf-8")
+	recorded.BodyIs(`{"foo":"bar"}`)
+}
+
+func TestRenderSuccessGetNegotiate(t *testing.T) {
+
+	type item struct {
+		XMLName xml.Name `json:"-" xml:"item"`
+		Name    string   `json:"name" xml:"name"`
+	}
+
+	testCases := []struct {
+		accept string
+		object interface{}
+
+		code        int
+		contentType string
+		body        string
+	}{
+		{
+			accept:      "application/json",
+			object:      item{Name: "foo"},
+			code:        http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name":"foo"}`,
+		},
+		{
+			accept:      "*/*",
+			object:      item{Name: "foo"},
+			code:        http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name":"foo"}`,
+		},
+		{
+			accept:      "application/xml",
+			object:      item{Name: "foo"},
+			code:        http.StatusOK,
+			contentType: "application/xml; charset=utf-8",
+			body:        xml.Header + `<item><name>foo</name></item>`,
+		},
+		{
+			accept:      "text/html, application/xml;q=0.9, */*;q=0.8",
+			object:      []item{{Name: "foo"}, {Name: "bar"}},
+			code:        http.StatusOK,
+			contentType: "application/xml; charset=utf-8",
+			body:        xml.Header + `<list><item><name>foo</name></item><item><name>bar</name></item></list>`,
+		},
+		{
+			accept:      "*/*, application/xml",
+			object:      item{Name: "foo"},
+			code:        http.StatusOK,
+			contentType: "application/xml; charset=utf-8",
+			body:        xml.Header + `<item><name>foo</name></item>`,
+		},
+		{
+			accept:      "application/xml;q=0.5, application/json",
+			object:      item{Name: "foo"},
+			code:        http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name":"foo"}`,
+		},
+		{
+			accept: "text/html",
+			object: item{Name: "foo"},
+			code:   http.StatusNotAcceptable,
+		},
+		{
+			accept: "application/json;q=0",
+			object: item{Name: "foo"},
+			code:   http.StatusNotAcceptable,
+		},
+		{
+			accept: "application/xml",
+			object: map[string]string{"foo": "bar"},
+			code:   http.StatusNotAcceptable,
+		},
+	}
+
+	for _, tc := range testCases {
+		router, err := rest.MakeRouter(rest.Get("/test", func(w rest.ResponseWriter, r *rest.Request) {
+			new(RESTView).RenderSuccessGetNegotiated(w, r, tc.object)
+		}))
+		assert.NoError(t, err)
+
+		api := rest.NewApi()
+		api.SetApp(router)
+
+		req := test.MakeSimpleRequest("GET", "http://localhost/test", nil)
+		req.Header.Set(HttpHeaderAccept, tc.accept)
+
+		recorded := test.RunRequest(t, api.MakeHandler(), req)
+
+		recorded.CodeIs(tc.code)
+		if tc.code == http.StatusOK {
+			recorded.HeaderIs(HttpHeaderContentType, tc.contentType)
+			recorded.BodyIs(tc.body)
+		}
+	}
+}
+
 func TestRenderSuccessDelete(t *testing.T) {
 
 	router, err := rest.MakeRouter(rest.Delete("/test", func(w rest.ResponseWriter, r *rest.Request) {
@@ -127,3 +353,84 @@ func TestRenderErrorNotFound(t *testing.T) {
 	recorded.CodeIs(http.StatusNotFound)
 	recorded.BodyIs(`{"error":"Resource not found","request_id":""}`)
 }
+
+func TestRenderErrorWithCode(t *testing.T) {
+
+	router, err := rest.MakeRouter(rest.Get("/test", func(w rest.ResponseWriter, r *rest.Request) {
+
+		l := log.New(log.Ctx{})
+		new(RESTView).RenderErrorWithCode(w, r, errors.New("Storage is busy"),
+			http.StatusServiceUnavailable, "storage_throttled", l)
+	}))
+
+	if err != nil {
+		assert.NoError(t, err)
+	}
+
+	api := rest.NewApi()
+	api.SetApp(router)
+
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/test", nil))
+
+	recorded.CodeIs(http.StatusServiceUnavailable)
+	recorded.BodyIs(`{"code":"storage_throttled","error":"Storage is busy","request_id":""}`)
+}
+
+type fieldsError struct {
+	fields []map[string]string
+}
+
+func (e *fieldsError) Error() string {
+	return "Invalid fields"
+}
+
+func (e *fieldsError) Details() interface{} {
+	return e.fields
+}
+
+func TestRenderError(t *testing.T) {
+	testCases := map[string]struct {
+		err  error
+		body string
+	}{
+		"plain": {
+			err:  errors.New("Invalid request"),
+			body: `{"error":"Invalid request","request_id":""}`,
+		},
+		"details": {
+			err: &fieldsError{fields: []map[string]string{
+				{"field": "tags[0]", "message": "Invalid tag"},
+			}},
+			body: `{"details":[{"field":"tags[0]","message":"Invalid tag"}],` +
+				`"error":"Invalid fields","request_id":""}`,
+		},
+		"wrapped details": {
+			err: pkgerrors.Wrap(&fieldsError{fields: []map[string]string{
+				{"field": "description", "message": "Missing required field"},
+			}}, "Validating request body"),
+			body: `{"details":[{"field":"description","message":"Missing required field"}],` +
+				`"error":"Validating request body: Invalid fields","request_id":""}`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			router, err := rest.MakeRouter(rest.Get("/test",
+				func(w rest.ResponseWriter, r *rest.Request) {
+					l := log.New(log.Ctx{})
+					new(RESTView).RenderError(w, r, tc.err, http.StatusBadRequest, l)
+				}))
+			assert.NoError(t, err)
+
+			api := rest.NewApi()
+			api.SetApp(router)
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("GET", "http://localhost/test", nil))
+
+			recorded.CodeIs(http.StatusBadRequest)
+			recorded.BodyIs(tc.body)
+		})
+	}
+}
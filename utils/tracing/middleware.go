@@ -0,0 +1,49 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package tracing
+
+import (
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// TracingMiddleware starts the request span, continuing the trace passed
+// with the traceparent header if present. The span is available
+// to the handlers via request context.
+type TracingMiddleware struct {
+}
+
+// MiddlewareFunc makes TracingMiddleware implement the Middleware interface.
+func (mw *TracingMiddleware) MiddlewareFunc(h rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		ctx := r.Context()
+		if remote := ParseTraceParent(r.Header.Get(HttpHeaderTraceParent)); remote.IsValid() {
+			ctx = ContextWithSpanContext(ctx, remote)
+		}
+
+		ctx, span := StartSpan(ctx, r.Method+" "+r.URL.Path)
+		defer span.End()
+
+		r.Request = r.Request.WithContext(ctx)
+
+		// let the client correlate the response with the trace
+		w.Header().Set(HttpHeaderTraceParent, FormatTraceParent(span.SpanContext))
+
+		h(w, r)
+
+		if env, ok := r.Env["STATUS_CODE"].(int); ok {
+			span.SetAttribute("http.status_code", env)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package tracing provides lightweight spans for measuring latency of
+// request processing stages.
+//
+// Trace context is propagated with the W3C 'traceparent' header, the same
+// format OpenTelemetry uses, so the spans can be correlated with traces
+// of the other services. Finished spans are reported through the request
+// logger at debug level.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+const (
+	HttpHeaderTraceParent = "traceparent"
+
+	traceparentVersion = "00"
+	flagSampled        = "01"
+)
+
+type spanContextKeyType int
+
+const spanContextKey spanContextKeyType = 0
+
+// SpanContext identifies span within a trace
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+}
+
+// IsValid checks if both trace and span IDs are set
+func (s SpanContext) IsValid() bool {
+	return len(s.TraceID) == 32 && len(s.SpanID) == 16 &&
+		strings.Trim(s.TraceID, "0") != "" && strings.Trim(s.SpanID, "0") != ""
+}
+
+// Span measures the duration of a single operation
+type Span struct {
+	SpanContext
+
+	Name     string
+	ParentID string
+	Start    time.Time
+
+	attributes log.Ctx
+	logger     *log.Logger
+}
+
+// StartSpan starts a child of the span found in the context,
+// or a root span of a new trace if there's none.
+// Returned context carries the new span.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{
+		Name:       name,
+		Start:      time.Now(),
+		attributes: log.Ctx{},
+		logger:     log.FromContext(ctx),
+	}
+
+	if parent := SpanContextFromContext(ctx); parent.IsValid() {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		span.TraceID = randomID(16)
+	}
+	span.SpanID = randomID(8)
+
+	return ContextWithSpanContext(ctx, span.SpanContext), span
+}
+
+// SetAttribute records key/value describing the operation
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+// SetError records the operation failure, nil error is ignored
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.attributes["error"] = err.Error()
+	}
+}
+
+// End finishes the span and reports it
+func (s *Span) End() {
+	ctx := log.Ctx{
+		"trace_id": s.TraceID,
+		"span_id":  s.SpanID,
+		"span":     s.Name,
+		"duration": time.Since(s.Start).String(),
+	}
+	if s.ParentID != "" {
+		ctx["parent_span_id"] = s.ParentID
+	}
+	for k, v := range s.attributes {
+		ctx[k] = v
+	}
+
+	s.logger.F(ctx).Debug("span finished")
+}
+
+// SpanContextFromContext returns span context carried by ctx,
+// zero value if there's none.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s, ok := ctx.Value(spanContextKey).(SpanContext); ok {
+		return s
+	}
+	return SpanContext{}
+}
+
+// ContextWithSpanContext returns context carrying span context
+func ContextWithSpanContext(ctx context.Context, s SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey, s)
+}
+
+// ParseTraceParent parses the W3C traceparent header value.
+// Returns zero value if the header is malformed.
+func ParseTraceParent(value string) SpanContext {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != traceparentVersion {
+		return SpanContext{}
+	}
+
+	s := SpanContext{
+		TraceID: strings.ToLower(parts[1]),
+		SpanID:  strings.ToLower(parts[2]),
+	}
+	if !s.IsValid() || !isHex(s.TraceID) || !isHex(s.SpanID) {
+		return SpanContext{}
+	}
+
+	return s
+}
+
+// FormatTraceParent formats span context as the W3C traceparent header value
+func FormatTraceParent(s SpanContext) string {
+	return fmt.Sprintf("%s-%s-%s-%s", traceparentVersion, s.TraceID, s.SpanID, flagSampled)
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func randomID(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		// fall back to time based ID, uniqueness is best effort anyway
+		return fmt.Sprintf("%0*x", size*2, time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
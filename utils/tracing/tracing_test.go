@@ -0,0 +1,92 @@
+// Copyright 2017 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	testCases := []struct {
+		value string
+		valid bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-00", true},
+		{"", false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6-00f067aa0ba902b7-01", false},
+	}
+
+	for _, tc := range testCases {
+		s := ParseTraceParent(tc.value)
+		assert.Equal(t, tc.valid, s.IsValid(), tc.value)
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	ctx, root := StartSpan(context.Background(), "root")
+	assert.True(t, root.IsValid())
+	assert.Empty(t, root.ParentID)
+
+	_, child := StartSpan(ctx, "child")
+	assert.Equal(t, root.TraceID, child.TraceID)
+	assert.Equal(t, root.SpanID, child.ParentID)
+	assert.NotEqual(t, root.SpanID, child.SpanID)
+
+	child.SetAttribute("foo", "bar")
+	child.SetError(nil)
+	child.End()
+	root.End()
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	var span SpanContext
+
+	router, err := rest.MakeRouter(rest.Get("/test", func(w rest.ResponseWriter, r *rest.Request) {
+		span = SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	assert.NoError(t, err)
+
+	api := rest.NewApi()
+	api.Use(&TracingMiddleware{})
+	api.SetApp(router)
+
+	// new trace
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/test", nil))
+	recorded.CodeIs(http.StatusNoContent)
+	assert.True(t, span.IsValid())
+	recorded.HeaderIs(HttpHeaderTraceParent, FormatTraceParent(span))
+
+	// trace continued
+	req := test.MakeSimpleRequest("GET", "http://localhost/test", nil)
+	req.Header.Set(HttpHeaderTraceParent,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	recorded = test.RunRequest(t, api.MakeHandler(), req)
+	recorded.CodeIs(http.StatusNoContent)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+	assert.NotEqual(t, "00f067aa0ba902b7", span.SpanID)
+}